@@ -24,6 +24,41 @@ type List struct {
 	len        int64
 }
 
+// Len Return the number of nodes in the list.
+func (l *List) Len() int64 {
+	return l.len
+}
+
+// First Return the head node of the list, nil if the list is empty.
+func (l *List) First() *Node {
+	return l.head
+}
+
+// Last Return the tail node of the list, nil if the list is empty.
+func (l *List) Last() *Node {
+	return l.tail
+}
+
+// Prev Return the previous node, nil at the head of the list.
+func (n *Node) Prev() *Node {
+	return n.prev
+}
+
+// Next Return the next node, nil at the tail of the list.
+func (n *Node) Next() *Node {
+	return n.next
+}
+
+// Value Return the value of the node.
+func (n *Node) Value() Value {
+	return n.value
+}
+
+// SetValue Set the value of the node, the old value is not freed.
+func (n *Node) SetValue(value Value) {
+	n.value = value
+}
+
 // Directions for iterators
 const (
 	// iter of list head.
@@ -122,14 +157,20 @@ func (l *List) AddNodeHead(value Value) {
 		value: value,
 	}
 
+	l.LinkNodeHead(node)
+}
+
+// LinkNodeHead Add a node that has already been allocated to the head of list.
+func (l *List) LinkNodeHead(node *Node) {
 	if l.head == nil {
 		l.head, l.tail = node, node
-		l.head.next, l.tail.prev = node, node
+		node.prev, node.next = nil, nil
 	} else {
-		l.head.prev = node
+		node.prev = nil
 		node.next = l.head
+		l.head.prev = node
 
-		l.head = l.head.prev
+		l.head = node
 	}
 	l.len++
 }
@@ -144,14 +185,20 @@ func (l *List) AddNodeTail(value Value) {
 		value: value,
 	}
 
+	l.LinkNodeTail(node)
+}
+
+// LinkNodeTail Add a node that has already been allocated to the tail of list.
+func (l *List) LinkNodeTail(node *Node) {
 	if l.head == nil {
 		l.head, l.tail = node, node
-		l.head.next, l.tail.prev = node, node
+		node.prev, node.next = nil, nil
 	} else {
-		l.tail.next = node
+		node.next = nil
 		node.prev = l.tail
+		l.tail.next = node
 
-		l.tail = l.tail.next
+		l.tail = node
 	}
 	l.len++
 }
@@ -190,6 +237,14 @@ func (l *List) InsertNode(oldNode *Node, value Value, after int) {
 // It's up to the caller to free the private value of the node.
 // This function can't fail.
 func (l *List) DelNode(node *Node) {
+	l.UnlinkNode(node)
+	if l.free != nil {
+		l.free(node.value)
+	}
+}
+
+// UnlinkNode Remove the specified node from the list without freeing it.
+func (l *List) UnlinkNode(node *Node) {
 	if node.prev != nil {
 		node.prev.next = node.next
 	} else {
@@ -201,9 +256,7 @@ func (l *List) DelNode(node *Node) {
 		l.tail = node.prev
 	}
 
-	if l.free != nil {
-		l.free(node.value)
-	}
+	node.next, node.prev = nil, nil
 
 	l.len--
 }
@@ -275,6 +328,29 @@ func (i *Iter) Next() *Node {
 	return current
 }
 
+// Index Return the element at the specified zero-based index
+// where 0 is the head, 1 is the element next to head
+// and so on. Negative integers are used in order to count
+// from the tail, -1 is the last element, -2 the penultimate
+// and so on. If the index is out of range nil is returned.
+func (l *List) Index(index int64) *Node {
+	var n *Node
+
+	if index < 0 {
+		index = (-index) - 1
+		n = l.tail
+		for ; index > 0 && n != nil; index-- {
+			n = n.prev
+		}
+	} else {
+		n = l.head
+		for ; index > 0 && n != nil; index-- {
+			n = n.next
+		}
+	}
+	return n
+}
+
 // Join  Add all the elements of the list 'o' at the end of the
 // list 'l'. The list 'other' remains empty but otherwise valid.
 func (l *List) Join(o *List) {
@@ -99,3 +99,30 @@ func TestAdlist(t *testing.T) {
 
 	list.Release()
 }
+
+func TestIndex(t *testing.T) {
+	list := ListCreate()
+	if list.Index(0) != nil || list.Index(-1) != nil {
+		t.Fatal("index of empty list")
+	}
+	for i := 0; i < 5; i++ {
+		list.AddNodeTail(&valueT{value: i})
+	}
+	for i := int64(-5); i < 5; i++ {
+		want := int(i)
+		if i < 0 {
+			want += 5
+		}
+		if n := list.Index(i); n == nil || n.Value().(*valueT).value != want {
+			t.Fatalf("index %d", i)
+		}
+	}
+	if list.Index(5) != nil || list.Index(-6) != nil {
+		t.Fatal("out of range")
+	}
+
+	list.Index(2).SetValue(&valueT{value: 10})
+	if list.Index(-3).Value().(*valueT).value != 10 {
+		t.Fatal("set value")
+	}
+}
@@ -0,0 +1,254 @@
+package adlist
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Blocking operations on lists, similar to BLPOP/BRPOP/BLMOVE of redis.
+// Lists used with a Blocking must only be modified through it, as the
+// pushes are what wake up the blocked callers.
+
+// Error
+var (
+	// ErrNoLists no list to wait for.
+	ErrNoLists = errors.New("no lists to block on")
+)
+
+// Blocking keeps, for every list, the FIFO queue of callers blocked on it.
+type Blocking struct {
+	mu sync.Mutex
+
+	// list -> list of *waiter, the oldest waiter at head.
+	keys map[*List]*List
+
+	// lists that received data and whose waiters must be served.
+	ready []*List
+}
+
+// waiter a caller blocked on one or more lists.
+type waiter struct {
+	lists []*List
+	// node of the waiter in the queue of every list it is blocked on.
+	nodes []*Node
+
+	// side to pop from
+	where int
+
+	// BMove destination, nil for plain pops.
+	dst      *List
+	dstWhere int
+
+	served bool
+	reply  chan popReply
+}
+
+type popReply struct {
+	list  *List
+	value Value
+}
+
+func (w *waiter) Value() {}
+
+// BlockingCreate Create a new blocking layer.
+func BlockingCreate() *Blocking {
+	return &Blocking{
+		keys: make(map[*List]*List),
+	}
+}
+
+// AddNodeHead Push the value at the head of the list and serve the
+// callers blocked on it.
+func (b *Blocking) AddNodeHead(l *List, value Value) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	l.AddNodeHead(value)
+	b.signalListAsReady(l)
+	b.handleListsReady()
+}
+
+// AddNodeTail Push the value at the tail of the list and serve the
+// callers blocked on it.
+func (b *Blocking) AddNodeTail(l *List, value Value) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	l.AddNodeTail(value)
+	b.signalListAsReady(l)
+	b.handleListsReady()
+}
+
+// Len Return the length of the list, safe against concurrent pushes and pops.
+func (b *Blocking) Len(l *List) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return l.Len()
+}
+
+// BPopHead Pop the head value of the first non empty list, blocking
+// until one of the lists receives data or the ctx is done.
+// The list the value was popped from is returned with the value.
+func (b *Blocking) BPopHead(ctx context.Context, lists ...*List) (*List, Value, error) {
+	return b.blockingPop(ctx, ALStartHead, nil, 0, lists)
+}
+
+// BPopTail Pop the tail value of the first non empty list, blocking
+// until one of the lists receives data or the ctx is done.
+// The list the value was popped from is returned with the value.
+func (b *Blocking) BPopTail(ctx context.Context, lists ...*List) (*List, Value, error) {
+	return b.blockingPop(ctx, ALStartTail, nil, 0, lists)
+}
+
+// BMove Atomically pop a value from the 'wherefrom' side of src and push
+// it to the 'whereto' side of dst, blocking while src is empty.
+// wherefrom and whereto are ALStartHead or ALStartTail.
+func (b *Blocking) BMove(ctx context.Context, src, dst *List, wherefrom, whereto int) (Value, error) {
+	_, value, err := b.blockingPop(ctx, wherefrom, dst, whereto, []*List{src})
+	return value, err
+}
+
+func (b *Blocking) blockingPop(ctx context.Context, where int, dst *List, dstWhere int, lists []*List) (*List, Value, error) {
+	if len(lists) == 0 {
+		return nil, nil, ErrNoLists
+	}
+
+	b.mu.Lock()
+
+	// If some list is non empty we serve the caller ASAP.
+	for _, l := range lists {
+		if l.Len() == 0 {
+			continue
+		}
+		value := popValue(l, where)
+		if dst != nil {
+			b.push(dst, value, dstWhere)
+			b.handleListsReady()
+		}
+		b.mu.Unlock()
+		return l, value, nil
+	}
+
+	// Check the ctx before blocking, like a zero timeout in redis.
+	if err := ctx.Err(); err != nil {
+		b.mu.Unlock()
+		return nil, nil, err
+	}
+
+	w := &waiter{
+		lists:    lists,
+		nodes:    make([]*Node, 0, len(lists)),
+		where:    where,
+		dst:      dst,
+		dstWhere: dstWhere,
+		reply:    make(chan popReply, 1),
+	}
+	b.block(w)
+	b.mu.Unlock()
+
+	select {
+	case r := <-w.reply:
+		return r.list, r.value, nil
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	if w.served {
+		// The value was already handed to us, don't lose it.
+		b.mu.Unlock()
+		r := <-w.reply
+		return r.list, r.value, nil
+	}
+	b.unblock(w)
+	b.mu.Unlock()
+
+	return nil, nil, ctx.Err()
+}
+
+// block Add the waiter to the tail of the queue of every list.
+func (b *Blocking) block(w *waiter) {
+	for _, l := range w.lists {
+		queue, ok := b.keys[l]
+		if !ok {
+			queue = ListCreate()
+			b.keys[l] = queue
+		}
+		queue.AddNodeTail(w)
+		w.nodes = append(w.nodes, queue.Last())
+	}
+}
+
+// unblock Remove the waiter from the queue of every list.
+func (b *Blocking) unblock(w *waiter) {
+	for i, l := range w.lists {
+		queue := b.keys[l]
+		queue.UnlinkNode(w.nodes[i])
+		if queue.Len() == 0 {
+			delete(b.keys, l)
+		}
+	}
+	w.nodes = nil
+}
+
+// signalListAsReady Remember the list received data, if someone is
+// blocked on it.
+func (b *Blocking) signalListAsReady(l *List) {
+	if _, ok := b.keys[l]; !ok {
+		return
+	}
+	for _, r := range b.ready {
+		if r == l {
+			return
+		}
+	}
+	b.ready = append(b.ready, l)
+}
+
+// handleListsReady Serve the callers blocked on the ready lists in FIFO
+// order, as long as the lists have data. Serving a BMove pushes to its
+// destination, which may make more lists ready.
+func (b *Blocking) handleListsReady() {
+	for len(b.ready) > 0 {
+		l := b.ready[0]
+		b.ready = b.ready[1:]
+
+		for l.Len() > 0 {
+			queue, ok := b.keys[l]
+			if !ok {
+				break
+			}
+			w := queue.First().Value().(*waiter)
+			b.unblock(w)
+
+			value := popValue(l, w.where)
+			if w.dst != nil {
+				b.push(w.dst, value, w.dstWhere)
+			}
+			w.served = true
+			w.reply <- popReply{list: l, value: value}
+		}
+	}
+	b.ready = nil
+}
+
+func (b *Blocking) push(l *List, value Value, where int) {
+	if where == ALStartHead {
+		l.AddNodeHead(value)
+	} else {
+		l.AddNodeTail(value)
+	}
+	b.signalListAsReady(l)
+}
+
+// popValue Unlink the head or tail node of a non empty list, returning
+// its value. The value is handed to the caller so it is not freed.
+func popValue(l *List, where int) Value {
+	node := l.head
+	if where == ALStartTail {
+		node = l.tail
+	}
+	l.UnlinkNode(node)
+	return node.value
+}
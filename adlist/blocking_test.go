@@ -0,0 +1,129 @@
+package adlist
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBlockingPopReady(t *testing.T) {
+	b := BlockingCreate()
+	l1, l2 := ListCreate(), ListCreate()
+
+	b.AddNodeTail(l2, &valueT{value: 1})
+	b.AddNodeTail(l2, &valueT{value: 2})
+
+	l, value, err := b.BPopTail(context.Background(), l1, l2)
+	if err != nil || l != l2 || value.(*valueT).value != 2 {
+		t.FailNow()
+	}
+	if l2.Len() != 1 || l2.First() != l2.Last() {
+		t.FailNow()
+	}
+}
+
+func TestBlockingPopWait(t *testing.T) {
+	b := BlockingCreate()
+	l1, l2 := ListCreate(), ListCreate()
+
+	type result struct {
+		list  *List
+		value Value
+	}
+
+	// Waiters must be served in the order they blocked.
+	results := make(chan result, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			l, value, err := b.BPopHead(context.Background(), l1, l2)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- result{l, value}
+		}()
+		waitBlocked(b, l2, int64(i+1))
+	}
+
+	b.AddNodeTail(l2, &valueT{value: 1})
+	r := <-results
+	if r.list != l2 || r.value.(*valueT).value != 1 {
+		t.FailNow()
+	}
+
+	b.AddNodeHead(l1, &valueT{value: 2})
+	r = <-results
+	if r.list != l1 || r.value.(*valueT).value != 2 {
+		t.FailNow()
+	}
+
+	if len(b.keys) != 0 || l1.Len() != 0 || l2.Len() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	b := BlockingCreate()
+	l := ListCreate()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := b.BPopHead(ctx, l); err != context.DeadlineExceeded {
+		t.FailNow()
+	}
+	if len(b.keys) != 0 {
+		t.FailNow()
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.BMove(ctx, l, ListCreate(), ALStartHead, ALStartTail); err != context.Canceled {
+		t.FailNow()
+	}
+
+	if _, _, err := b.BPopHead(context.Background()); err != ErrNoLists {
+		t.FailNow()
+	}
+}
+
+func TestBlockingMove(t *testing.T) {
+	b := BlockingCreate()
+	src, dst := ListCreate(), ListCreate()
+
+	// A pop blocked on the destination is served by the move.
+	popped := make(chan Value)
+	go func() {
+		_, value, _ := b.BPopHead(context.Background(), dst)
+		popped <- value
+	}()
+	waitBlocked(b, dst, 1)
+
+	moved := make(chan Value)
+	go func() {
+		value, _ := b.BMove(context.Background(), src, dst, ALStartTail, ALStartHead)
+		moved <- value
+	}()
+	waitBlocked(b, src, 1)
+
+	b.AddNodeTail(src, &valueT{value: 7})
+	if (<-moved).(*valueT).value != 7 || (<-popped).(*valueT).value != 7 {
+		t.FailNow()
+	}
+	if src.Len() != 0 || dst.Len() != 0 {
+		t.FailNow()
+	}
+}
+
+// waitBlocked Wait until n callers are blocked on the list.
+func waitBlocked(b *Blocking, l *List, n int64) {
+	for {
+		b.mu.Lock()
+		queue, ok := b.keys[l]
+		blocked := ok && queue.Len() == n
+		b.mu.Unlock()
+		if blocked {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,584 @@
+package ae
+
+// A simple event-driven programming library, a port of ae.c of redis.
+//
+// The event loop multiplexes the readiness of the file descriptors, with
+// epoll on Linux and poll on the other unix systems (or on Linux with the
+// aepoll build tag), and the time events, kept in a heap ordered by
+// their time. It is single threaded: the event loop must be used by a
+// single goroutine, the one calling ProcessEvents or Main.
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+)
+
+// Error
+var (
+	// ErrRange the file descriptor is out of the range of the set size.
+	ErrRange = errors.New("ae: file descriptor out of range")
+	// ErrSetSize the new set size is smaller than the max file descriptor.
+	ErrSetSize = errors.New("ae: set size smaller than the max fd")
+	// ErrNoSuchEvent the time event doesn't exist.
+	ErrNoSuchEvent = errors.New("ae: no such time event")
+)
+
+// File event masks.
+const (
+	// None No events registered.
+	None = 0
+	// Readable Fire when descriptor is readable.
+	Readable = 1
+	// Writable Fire when descriptor is writable.
+	Writable = 2
+	// Barrier With Writable, never fire the event if the Readable event
+	// already fired in the same event loop iteration. Useful when you
+	// want to persist things to disk before sending replies, and want to
+	// do that in a group fashion.
+	Barrier = 4
+)
+
+// Flags of ProcessEvents.
+const (
+	// FileEvents Process the file events.
+	FileEvents = 1 << 0
+	// TimeEvents Process the time events.
+	TimeEvents = 1 << 1
+	// AllEvents Process the file and time events.
+	AllEvents = FileEvents | TimeEvents
+	// DontWait Return ASAP once all the events that can be processed
+	// without a wait are processed.
+	DontWait = 1 << 2
+	// CallBeforeSleep Call the before sleep hook.
+	CallBeforeSleep = 1 << 3
+	// CallAfterSleep Call the after sleep hook.
+	CallAfterSleep = 1 << 4
+)
+
+const (
+	// NoMore Returned by a TimeProc, the time event is not rescheduled.
+	NoMore = -1
+	// DeletedEventID The id of the time events scheduled for deletion.
+	DeletedEventID = -1
+)
+
+// FileProc The handler of a file event, 'mask' being the fired events.
+type FileProc func(el *EventLoop, fd int, clientData interface{}, mask int)
+
+// TimeProc The handler of a time event. Returns the number of
+// milliseconds after which the event fires again, or NoMore.
+type TimeProc func(el *EventLoop, id int64, clientData interface{}) int
+
+// EventFinalizerProc Called when a time event is deleted.
+type EventFinalizerProc func(el *EventLoop, clientData interface{})
+
+// BeforeSleepProc The hooks called before and after waiting for the
+// events.
+type BeforeSleepProc func(el *EventLoop)
+
+// fileEvent File event structure
+type fileEvent struct {
+	// one of Readable|Writable|Barrier
+	mask       int
+	rfileProc  FileProc
+	wfileProc  FileProc
+	clientData interface{}
+}
+
+// firedEvent A fired event
+type firedEvent struct {
+	fd   int
+	mask int
+}
+
+// timeEvent Time event structure
+type timeEvent struct {
+	id int64
+	// monotonic time in microseconds at which the event fires.
+	when       int64
+	timeProc   TimeProc
+	finalizer  EventFinalizerProc
+	clientData interface{}
+	// refcount to prevent the event from being freed while the handler
+	// is running.
+	refcount int
+	// index in the heap, -1 if not in the heap.
+	index int
+}
+
+// timeEventHeap The time events ordered by time, implements
+// heap.Interface.
+type timeEventHeap []*timeEvent
+
+func (h timeEventHeap) Len() int           { return len(h) }
+func (h timeEventHeap) Less(i, j int) bool { return h[i].when < h[j].when }
+
+func (h timeEventHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timeEventHeap) Push(x interface{}) {
+	te := x.(*timeEvent)
+	te.index = len(*h)
+	*h = append(*h, te)
+}
+
+func (h *timeEventHeap) Pop() interface{} {
+	old := *h
+	te := old[len(old)-1]
+	old[len(old)-1] = nil
+	te.index = -1
+	*h = old[:len(old)-1]
+	return te
+}
+
+// EventLoop State of an event based program
+type EventLoop struct {
+	// highest file descriptor currently registered
+	maxfd int
+	// max number of file descriptors tracked
+	setsize int
+	// Registered events
+	events []fileEvent
+	// Fired events
+	fired []firedEvent
+
+	timeEventNextID int64
+	timeEvents      timeEventHeap
+	timeEventsByID  map[int64]*timeEvent
+
+	stop bool
+	// This is used for polling API specific data
+	apidata     *apiState
+	beforesleep BeforeSleepProc
+	aftersleep  BeforeSleepProc
+	flags       int
+	start       time.Time
+}
+
+// Create an event loop tracking the file descriptors up to 'setsize'
+// excluded.
+func Create(setsize int) (*EventLoop, error) {
+	el := &EventLoop{
+		maxfd:          -1,
+		setsize:        setsize,
+		events:         make([]fileEvent, setsize),
+		fired:          make([]firedEvent, setsize),
+		timeEventsByID: make(map[int64]*timeEvent),
+		start:          time.Now(),
+	}
+	if err := el.apiCreate(); err != nil {
+		return nil, err
+	}
+	return el, nil
+}
+
+// GetSetSize Return the current set size.
+func (el *EventLoop) GetSetSize() int {
+	return el.setsize
+}
+
+// SetDontWait Tells the next iteration/s of the event processing to set
+// timeout of 0.
+func (el *EventLoop) SetDontWait(noWait bool) {
+	if noWait {
+		el.flags |= DontWait
+	} else {
+		el.flags &^= DontWait
+	}
+}
+
+// ResizeSetSize Resize the maximum set size of the event loop. If the
+// requested set size is smaller than the current set size, but there is
+// already a file descriptor in use that is >= the requested set size
+// minus one, ErrSetSize is returned and the operation is not performed
+// at all.
+func (el *EventLoop) ResizeSetSize(setsize int) error {
+	if setsize == el.setsize {
+		return nil
+	}
+	if el.maxfd >= setsize {
+		return ErrSetSize
+	}
+	if err := el.apiResize(setsize); err != nil {
+		return err
+	}
+
+	events := make([]fileEvent, setsize)
+	copy(events, el.events)
+	el.events = events
+	el.fired = make([]firedEvent, setsize)
+	el.setsize = setsize
+	return nil
+}
+
+// Close Release the resources of the event loop. The finalizers of the
+// pending time events are called.
+func (el *EventLoop) Close() {
+	el.apiFree()
+	for _, te := range el.timeEvents {
+		if te.finalizer != nil {
+			te.finalizer(el, te.clientData)
+		}
+	}
+	el.timeEvents = nil
+	el.timeEventsByID = make(map[int64]*timeEvent)
+}
+
+// Stop the event loop, Main returns after the current iteration.
+func (el *EventLoop) Stop() {
+	el.stop = true
+}
+
+// CreateFileEvent Register the 'mask' events of the file descriptor,
+// with 'proc' as handler.
+func (el *EventLoop) CreateFileEvent(fd int, mask int, proc FileProc, clientData interface{}) error {
+	if fd < 0 || fd >= el.setsize {
+		return ErrRange
+	}
+	fe := &el.events[fd]
+
+	if err := el.apiAddEvent(fd, mask); err != nil {
+		return err
+	}
+	fe.mask |= mask
+	if mask&Readable != 0 {
+		fe.rfileProc = proc
+	}
+	if mask&Writable != 0 {
+		fe.wfileProc = proc
+	}
+	fe.clientData = clientData
+	if fd > el.maxfd {
+		el.maxfd = fd
+	}
+	return nil
+}
+
+// DeleteFileEvent Unregister the 'mask' events of the file descriptor.
+func (el *EventLoop) DeleteFileEvent(fd int, mask int) {
+	if fd < 0 || fd >= el.setsize {
+		return
+	}
+	fe := &el.events[fd]
+	if fe.mask == None {
+		return
+	}
+
+	// We want to always remove Barrier if set when Writable is removed.
+	if mask&Writable != 0 {
+		mask |= Barrier
+	}
+
+	el.apiDelEvent(fd, mask)
+	fe.mask &^= mask
+	if mask&Readable != 0 {
+		fe.rfileProc = nil
+	}
+	if mask&Writable != 0 {
+		fe.wfileProc = nil
+	}
+	if fd == el.maxfd && fe.mask == None {
+		// Update the max fd
+		j := el.maxfd - 1
+		for ; j >= 0; j-- {
+			if el.events[j].mask != None {
+				break
+			}
+		}
+		el.maxfd = j
+	}
+}
+
+// GetFileEvents Return the events registered for the file descriptor.
+func (el *EventLoop) GetFileEvents(fd int) int {
+	if fd < 0 || fd >= el.setsize {
+		return 0
+	}
+	return el.events[fd].mask
+}
+
+// monotonicUs Return the monotonic time in microseconds.
+func (el *EventLoop) monotonicUs() int64 {
+	return int64(time.Since(el.start) / time.Microsecond)
+}
+
+// CreateTimeEvent Register a time event firing in 'milliseconds', with
+// 'proc' as handler. The finalizer, if not nil, is called when the event
+// is deleted. Returns the id of the event.
+func (el *EventLoop) CreateTimeEvent(milliseconds int64, proc TimeProc, clientData interface{},
+	finalizer EventFinalizerProc) int64 {
+	id := el.timeEventNextID
+	el.timeEventNextID++
+	te := &timeEvent{
+		id:         id,
+		when:       el.monotonicUs() + milliseconds*1000,
+		timeProc:   proc,
+		finalizer:  finalizer,
+		clientData: clientData,
+	}
+	heap.Push(&el.timeEvents, te)
+	el.timeEventsByID[id] = te
+	return id
+}
+
+// DeleteTimeEvent Delete the time event. The event is freed, and its
+// finalizer called, by the next processing of the time events.
+func (el *EventLoop) DeleteTimeEvent(id int64) error {
+	te := el.timeEventsByID[id]
+	if te == nil {
+		return ErrNoSuchEvent
+	}
+	delete(el.timeEventsByID, id)
+	te.id = DeletedEventID
+	if te.index != -1 {
+		// Move it to the top of the heap, to be freed ASAP.
+		te.when = 0
+		heap.Fix(&el.timeEvents, te.index)
+	}
+	return nil
+}
+
+// usUntilEarliestTimer How many microseconds until the first timer should
+// fire. If there are no timers, -1 is returned.
+func (el *EventLoop) usUntilEarliestTimer() int64 {
+	if len(el.timeEvents) == 0 {
+		return -1
+	}
+	now := el.monotonicUs()
+	if earliest := el.timeEvents[0]; earliest.when > now {
+		return earliest.when - now
+	}
+	return 0
+}
+
+// processTimeEvents Process time events
+func (el *EventLoop) processTimeEvents() int {
+	processed := 0
+	maxID := el.timeEventNextID - 1
+	now := el.monotonicUs()
+
+	// The events that can't be processed now are pushed back in the heap
+	// at the end, so that every event is processed at most once.
+	var deferred []*timeEvent
+	for len(el.timeEvents) > 0 && el.timeEvents[0].when <= now {
+		te := heap.Pop(&el.timeEvents).(*timeEvent)
+
+		// Remove events scheduled for deletion.
+		if te.id == DeletedEventID {
+			// If a reference exists for this timer event, don't free
+			// it. This is currently incremented for recursive timerProc
+			// calls
+			if te.refcount > 0 {
+				deferred = append(deferred, te)
+				continue
+			}
+			if te.finalizer != nil {
+				te.finalizer(el, te.clientData)
+			}
+			continue
+		}
+
+		// Make sure we don't process time events created by time events
+		// in this iteration. Note that this check is currently useless:
+		// we always add new timers with a time in the future, however
+		// the timers created with 0 milliseconds would be processed in a
+		// loop otherwise.
+		if te.id > maxID {
+			deferred = append(deferred, te)
+			continue
+		}
+
+		te.refcount++
+		retval := te.timeProc(el, te.id, te.clientData)
+		te.refcount--
+		processed++
+		now = el.monotonicUs()
+		if te.id == DeletedEventID {
+			te.when = 0
+		} else if retval != NoMore {
+			te.when = now + int64(retval)*1000
+		} else {
+			delete(el.timeEventsByID, te.id)
+			te.id = DeletedEventID
+			te.when = 0
+		}
+		deferred = append(deferred, te)
+	}
+
+	for _, te := range deferred {
+		heap.Push(&el.timeEvents, te)
+	}
+	return processed
+}
+
+// ProcessEvents Process every pending time event, then every pending file
+// event (that may be registered by time event callbacks just processed).
+// Without special flags the function sleeps until some file event fires,
+// or when the next time event occurs (if any).
+//
+// If flags is 0, the function does nothing and returns.
+// If flags has AllEvents set, all the kind of events are processed.
+// If flags has FileEvents set, file events are processed.
+// If flags has TimeEvents set, time events are processed.
+// If flags has DontWait set, the function returns ASAP once all the
+// events that can be handled without a wait are processed.
+// If flags has CallAfterSleep set, the aftersleep callback is called.
+// If flags has CallBeforeSleep set, the beforesleep callback is called.
+//
+// The function returns the number of events processed.
+func (el *EventLoop) ProcessEvents(flags int) int {
+	processed := 0
+
+	// Nothing to do? return ASAP
+	if flags&TimeEvents == 0 && flags&FileEvents == 0 {
+		return 0
+	}
+
+	// Note that we want to call poll even if there are no file events to
+	// process as long as we want to process time events, in order to
+	// sleep until the next time event is ready to fire.
+	if el.maxfd != -1 || flags&TimeEvents != 0 && flags&DontWait == 0 {
+		if el.beforesleep != nil && flags&CallBeforeSleep != 0 {
+			el.beforesleep(el)
+		}
+
+		// The timeout in microseconds, -1 to wait forever.
+		var usUntilTimer int64 = -1
+		if flags&TimeEvents != 0 && flags&DontWait == 0 {
+			usUntilTimer = el.usUntilEarliestTimer()
+		}
+		// If we have to check for events but need to return ASAP because
+		// of DontWait we need to set the timeout to zero
+		if flags&DontWait != 0 || el.flags&DontWait != 0 {
+			usUntilTimer = 0
+		}
+
+		// Call the multiplexing API, will return only on timeout or when
+		// some event fires.
+		numevents := el.apiPoll(usUntilTimer)
+
+		// Don't process file events if not requested.
+		if flags&FileEvents == 0 {
+			numevents = 0
+		}
+
+		// After sleep callback.
+		if el.aftersleep != nil && flags&CallAfterSleep != 0 {
+			el.aftersleep(el)
+		}
+
+		for j := 0; j < numevents; j++ {
+			fd := el.fired[j].fd
+			fe := &el.events[fd]
+			mask := el.fired[j].mask
+			// Number of events fired for current fd.
+			fired := 0
+
+			// Normally we execute the readable event first, and the
+			// writable event later. This is useful as sometimes we may be
+			// able to serve the reply of a query immediately after
+			// processing the query.
+			//
+			// However if Barrier is set in the mask, our application is
+			// asking us to do the reverse: never fire the writable event
+			// after the readable. In such a case, we invert the calls.
+			// This is useful when, for instance, we want to do things in
+			// the beforeSleep() hook, like fsyncing a file to disk,
+			// before replying to a client.
+			invert := fe.mask&Barrier != 0
+
+			// Fire the readable event if the call sequence is not
+			// inverted.
+			if !invert && fe.mask&mask&Readable != 0 {
+				fe.rfileProc(el, fd, fe.clientData, mask)
+				fired++
+				fe = &el.events[fd] // Refresh in case of resize.
+			}
+
+			// Fire the writable event.
+			if fe.mask&mask&Writable != 0 {
+				fe.wfileProc(el, fd, fe.clientData, mask)
+				fired++
+			}
+
+			// If we have to invert the call, fire the readable event now
+			// after the writable one.
+			if invert {
+				fe = &el.events[fd] // Refresh in case of resize.
+				if fe.mask&mask&Readable != 0 {
+					fe.rfileProc(el, fd, fe.clientData, mask)
+					fired++
+				}
+			}
+
+			processed++
+		}
+	}
+	// Check time events
+	if flags&TimeEvents != 0 {
+		processed += el.processTimeEvents()
+	}
+
+	return processed // return the number of processed file/time events
+}
+
+// Wait for milliseconds until the given file descriptor becomes
+// writable/readable/exception. A negative timeout waits forever. Returns
+// the fired events.
+func Wait(fd int, mask int, milliseconds int) (int, error) {
+	pfd := pollFd{fd: int32(fd)}
+	if mask&Readable != 0 {
+		pfd.events |= pollIn
+	}
+	if mask&Writable != 0 {
+		pfd.events |= pollOut
+	}
+
+	fds := []pollFd{pfd}
+	n, err := poll(fds, milliseconds)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	var retmask int
+	if fds[0].revents&pollIn != 0 {
+		retmask |= Readable
+	}
+	if fds[0].revents&pollOut != 0 {
+		retmask |= Writable
+	}
+	if fds[0].revents&pollErr != 0 {
+		retmask |= Writable
+	}
+	if fds[0].revents&pollHup != 0 {
+		retmask |= Writable
+	}
+	return retmask, nil
+}
+
+// Main Process the events until the event loop is stopped.
+func (el *EventLoop) Main() {
+	el.stop = false
+	for !el.stop {
+		el.ProcessEvents(AllEvents | CallBeforeSleep | CallAfterSleep)
+	}
+}
+
+// GetAPIName Return the name of the multiplexing API.
+func GetAPIName() string {
+	return apiName
+}
+
+// SetBeforeSleepProc Set the hook called before waiting for the events.
+func (el *EventLoop) SetBeforeSleepProc(beforesleep BeforeSleepProc) {
+	el.beforesleep = beforesleep
+}
+
+// SetAfterSleepProc Set the hook called after waiting for the events.
+func (el *EventLoop) SetAfterSleepProc(aftersleep BeforeSleepProc) {
+	el.aftersleep = aftersleep
+}
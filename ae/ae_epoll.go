@@ -0,0 +1,115 @@
+//go:build linux && !aepoll
+// +build linux,!aepoll
+
+package ae
+
+// Linux epoll(2) based ae.c module
+
+import (
+	"syscall"
+)
+
+const apiName = "epoll"
+
+type apiState struct {
+	epfd   int
+	events []syscall.EpollEvent
+}
+
+func (el *EventLoop) apiCreate() error {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	el.apidata = &apiState{
+		epfd:   epfd,
+		events: make([]syscall.EpollEvent, el.setsize),
+	}
+	return nil
+}
+
+func (el *EventLoop) apiResize(setsize int) error {
+	el.apidata.events = make([]syscall.EpollEvent, setsize)
+	return nil
+}
+
+func (el *EventLoop) apiFree() {
+	syscall.Close(el.apidata.epfd)
+}
+
+func (el *EventLoop) apiAddEvent(fd int, mask int) error {
+	state := el.apidata
+	// If the fd was already monitored for some event, we need a MOD
+	// operation. Otherwise we need an ADD operation.
+	op := syscall.EPOLL_CTL_ADD
+	if el.events[fd].mask != None {
+		op = syscall.EPOLL_CTL_MOD
+	}
+
+	ee := syscall.EpollEvent{Fd: int32(fd)}
+	mask |= el.events[fd].mask // Merge old events
+	if mask&Readable != 0 {
+		ee.Events |= syscall.EPOLLIN
+	}
+	if mask&Writable != 0 {
+		ee.Events |= syscall.EPOLLOUT
+	}
+	return syscall.EpollCtl(state.epfd, op, fd, &ee)
+}
+
+func (el *EventLoop) apiDelEvent(fd int, delmask int) {
+	state := el.apidata
+	mask := el.events[fd].mask &^ delmask
+
+	ee := syscall.EpollEvent{Fd: int32(fd)}
+	if mask&Readable != 0 {
+		ee.Events |= syscall.EPOLLIN
+	}
+	if mask&Writable != 0 {
+		ee.Events |= syscall.EPOLLOUT
+	}
+	if mask&(Readable|Writable) != None {
+		syscall.EpollCtl(state.epfd, syscall.EPOLL_CTL_MOD, fd, &ee)
+	} else {
+		// Note, Kernel < 2.6.9 requires a non null event pointer even
+		// for EPOLL_CTL_DEL.
+		syscall.EpollCtl(state.epfd, syscall.EPOLL_CTL_DEL, fd, &ee)
+	}
+}
+
+// apiPoll Wait for the events for 'us' microseconds, forever if negative,
+// and fill el.fired. Returns the number of fired events.
+func (el *EventLoop) apiPoll(us int64) int {
+	state := el.apidata
+	timeout := -1
+	if us >= 0 {
+		timeout = int((us + 999) / 1000)
+	}
+
+	numevents, err := syscall.EpollWait(state.epfd, state.events[:el.setsize], timeout)
+	if err != nil {
+		if err != syscall.EINTR {
+			panic("ae: epoll_wait: " + err.Error())
+		}
+		return 0
+	}
+
+	for j := 0; j < numevents; j++ {
+		e := &state.events[j]
+		mask := 0
+		if e.Events&syscall.EPOLLIN != 0 {
+			mask |= Readable
+		}
+		if e.Events&syscall.EPOLLOUT != 0 {
+			mask |= Writable
+		}
+		if e.Events&syscall.EPOLLERR != 0 {
+			mask |= Readable | Writable
+		}
+		if e.Events&syscall.EPOLLHUP != 0 {
+			mask |= Readable | Writable
+		}
+		el.fired[j] = firedEvent{fd: int(e.Fd), mask: mask}
+	}
+	return numevents
+}
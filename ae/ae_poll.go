@@ -0,0 +1,95 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd || (linux && aepoll)
+// +build darwin dragonfly freebsd netbsd openbsd linux,aepoll
+
+package ae
+
+// poll(2) based ae.c module, the portable fallback of epoll.
+
+import (
+	"syscall"
+)
+
+const apiName = "poll"
+
+type apiState struct {
+	fds []pollFd
+}
+
+func (el *EventLoop) apiCreate() error {
+	el.apidata = &apiState{}
+	return nil
+}
+
+func (el *EventLoop) apiResize(setsize int) error {
+	return nil
+}
+
+func (el *EventLoop) apiFree() {}
+
+// apiAddEvent The registered events are read from el.events by apiPoll.
+func (el *EventLoop) apiAddEvent(fd int, mask int) error {
+	return nil
+}
+
+func (el *EventLoop) apiDelEvent(fd int, delmask int) {}
+
+// apiPoll Wait for the events for 'us' microseconds, forever if negative,
+// and fill el.fired. Returns the number of fired events.
+func (el *EventLoop) apiPoll(us int64) int {
+	state := el.apidata
+	state.fds = state.fds[:0]
+	for fd := 0; fd <= el.maxfd; fd++ {
+		fe := &el.events[fd]
+		if fe.mask == None {
+			continue
+		}
+		pfd := pollFd{fd: int32(fd)}
+		if fe.mask&Readable != 0 {
+			pfd.events |= pollIn
+		}
+		if fe.mask&Writable != 0 {
+			pfd.events |= pollOut
+		}
+		state.fds = append(state.fds, pfd)
+	}
+
+	timeout := -1
+	if us >= 0 {
+		timeout = int((us + 999) / 1000)
+	}
+	retval, err := poll(state.fds, timeout)
+	if err != nil {
+		if err != syscall.EINTR {
+			panic("ae: poll: " + err.Error())
+		}
+		return 0
+	}
+
+	numevents := 0
+	for j := 0; j < len(state.fds) && retval > 0; j++ {
+		pfd := &state.fds[j]
+		if pfd.revents == 0 {
+			continue
+		}
+		retval--
+
+		fd := int(pfd.fd)
+		fe := &el.events[fd]
+		mask := 0
+		if pfd.revents&pollIn != 0 && fe.mask&Readable != 0 {
+			mask |= Readable
+		}
+		if pfd.revents&pollOut != 0 && fe.mask&Writable != 0 {
+			mask |= Writable
+		}
+		if pfd.revents&(pollErr|pollHup) != 0 {
+			mask |= fe.mask & (Readable | Writable)
+		}
+		if mask == 0 {
+			continue
+		}
+		el.fired[numevents] = firedEvent{fd: fd, mask: mask}
+		numevents++
+	}
+	return numevents
+}
@@ -0,0 +1,263 @@
+package ae
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func pipe(t *testing.T) (r, w int) {
+	t.Helper()
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	return fds[0], fds[1]
+}
+
+func create(t *testing.T, setsize int) *EventLoop {
+	t.Helper()
+	el, err := Create(setsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(el.Close)
+	return el
+}
+
+func TestFileEvents(t *testing.T) {
+	el := create(t, 1024)
+	r, w := pipe(t)
+
+	var got []string
+	readProc := func(el *EventLoop, fd int, clientData interface{}, mask int) {
+		buf := make([]byte, 16)
+		n, _ := syscall.Read(fd, buf)
+		got = append(got, fmt.Sprintf("%s read %q", clientData, buf[:n]))
+	}
+	if err := el.CreateFileEvent(r, Readable, readProc, "r"); err != nil {
+		t.Fatal(err)
+	}
+	if el.GetFileEvents(r) != Readable || el.maxfd != r {
+		t.Fatal("file events")
+	}
+
+	// Nothing to read.
+	if n := el.ProcessEvents(FileEvents | DontWait); n != 0 {
+		t.Fatalf("%d events processed", n)
+	}
+	syscall.Write(w, []byte("hello"))
+	if n := el.ProcessEvents(FileEvents | DontWait); n != 1 || len(got) != 1 || got[0] != `r read "hello"` {
+		t.Fatalf("%d events processed: %q", n, got)
+	}
+
+	writeProc := func(el *EventLoop, fd int, clientData interface{}, mask int) {
+		got = append(got, "w writable")
+		el.DeleteFileEvent(fd, Writable)
+	}
+	if err := el.CreateFileEvent(w, Writable, writeProc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := el.ProcessEvents(FileEvents | DontWait); n != 1 || got[1] != "w writable" {
+		t.Fatalf("%d events processed: %q", n, got)
+	}
+	if el.GetFileEvents(w) != None || el.maxfd != r {
+		t.Fatalf("write event not deleted, maxfd %d", el.maxfd)
+	}
+
+	el.DeleteFileEvent(r, Readable)
+	if el.maxfd != -1 {
+		t.Fatalf("maxfd %d", el.maxfd)
+	}
+	syscall.Write(w, []byte("hello"))
+	if n := el.ProcessEvents(FileEvents | DontWait); n != 0 {
+		t.Fatalf("%d events processed", n)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	el := create(t, 1024)
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	syscall.Write(fds[1], []byte("x"))
+
+	var got []string
+	readProc := func(el *EventLoop, fd int, clientData interface{}, mask int) {
+		got = append(got, "read")
+	}
+	writeProc := func(el *EventLoop, fd int, clientData interface{}, mask int) {
+		got = append(got, "write")
+	}
+	el.CreateFileEvent(fds[0], Readable, readProc, nil)
+	el.CreateFileEvent(fds[0], Writable, writeProc, nil)
+	el.ProcessEvents(FileEvents | DontWait)
+	if fmt.Sprint(got) != "[read write]" {
+		t.Fatalf("got %v", got)
+	}
+
+	got = nil
+	el.DeleteFileEvent(fds[0], Readable|Writable)
+	if el.GetFileEvents(fds[0]) != None {
+		t.Fatal("events not deleted")
+	}
+	el.CreateFileEvent(fds[0], Readable, readProc, nil)
+	el.CreateFileEvent(fds[0], Writable|Barrier, writeProc, nil)
+	el.ProcessEvents(FileEvents | DontWait)
+	if fmt.Sprint(got) != "[write read]" {
+		t.Fatalf("barrier: got %v", got)
+	}
+
+	// Removing the writable event removes the barrier.
+	got = nil
+	el.DeleteFileEvent(fds[0], Writable)
+	if el.GetFileEvents(fds[0]) != Readable {
+		t.Fatalf("events %d", el.GetFileEvents(fds[0]))
+	}
+	el.CreateFileEvent(fds[0], Writable, writeProc, nil)
+	el.ProcessEvents(FileEvents | DontWait)
+	if fmt.Sprint(got) != "[read write]" {
+		t.Fatalf("no barrier: got %v", got)
+	}
+}
+
+func TestSetSize(t *testing.T) {
+	el := create(t, 4)
+	r, _ := pipe(t)
+	if el.GetSetSize() != 4 {
+		t.Fatal("set size")
+	}
+	if err := el.CreateFileEvent(r, Readable, nil, nil); r >= 4 && err != ErrRange {
+		t.Fatalf("fd %d: %v", r, err)
+	}
+	if err := el.ResizeSetSize(r + 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := el.CreateFileEvent(r, Readable, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := el.ResizeSetSize(r); err != ErrSetSize {
+		t.Fatalf("resize below maxfd: %v", err)
+	}
+	if el.GetFileEvents(r) != Readable {
+		t.Fatal("events lost by the resize")
+	}
+}
+
+func TestTimeEvents(t *testing.T) {
+	el := create(t, 64)
+
+	var got []string
+	proc := func(el *EventLoop, id int64, clientData interface{}) int {
+		got = append(got, clientData.(string))
+		return NoMore
+	}
+	var finalized []string
+	finalizer := func(el *EventLoop, clientData interface{}) {
+		finalized = append(finalized, clientData.(string))
+	}
+
+	el.CreateTimeEvent(30, proc, "c", finalizer)
+	el.CreateTimeEvent(10, proc, "a", finalizer)
+	el.CreateTimeEvent(20, proc, "b", finalizer)
+	deleted := el.CreateTimeEvent(15, proc, "deleted", finalizer)
+	if err := el.DeleteTimeEvent(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := el.DeleteTimeEvent(deleted); err != ErrNoSuchEvent {
+		t.Fatalf("delete twice: %v", err)
+	}
+
+	// A periodic event, firing 3 times.
+	var times []time.Time
+	el.CreateTimeEvent(0, func(el *EventLoop, id int64, clientData interface{}) int {
+		times = append(times, time.Now())
+		if len(times) == 3 {
+			// Delete itself.
+			el.DeleteTimeEvent(id)
+		}
+		return 10
+	}, "periodic", finalizer)
+
+	el.CreateTimeEvent(50, func(el *EventLoop, id int64, clientData interface{}) int {
+		el.Stop()
+		return NoMore
+	}, nil, nil)
+
+	start := time.Now()
+	el.Main()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("stopped after %v", elapsed)
+	}
+	if fmt.Sprint(got) != "[a b c]" {
+		t.Fatalf("fired %v", got)
+	}
+	if len(times) != 3 || times[2].Sub(times[0]) < 20*time.Millisecond {
+		t.Fatalf("periodic event fired %d times", len(times))
+	}
+	// The events that fired for the last time are freed by the next
+	// processing.
+	el.ProcessEvents(TimeEvents | DontWait)
+	if fmt.Sprint(finalized) != "[deleted a b periodic c]" {
+		t.Fatalf("finalized %v", finalized)
+	}
+	if len(el.timeEvents) != 0 || len(el.timeEventsByID) != 0 {
+		t.Fatalf("%d time events left", len(el.timeEvents))
+	}
+}
+
+func TestHooks(t *testing.T) {
+	el := create(t, 64)
+	var got []string
+	el.SetBeforeSleepProc(func(el *EventLoop) { got = append(got, "before") })
+	el.SetAfterSleepProc(func(el *EventLoop) { got = append(got, "after") })
+	el.CreateTimeEvent(0, func(el *EventLoop, id int64, clientData interface{}) int {
+		got = append(got, "time")
+		return NoMore
+	}, nil, nil)
+
+	el.ProcessEvents(AllEvents | CallBeforeSleep | CallAfterSleep)
+	if fmt.Sprint(got) != "[before after time]" {
+		t.Fatalf("got %v", got)
+	}
+	got = nil
+	el.ProcessEvents(TimeEvents | DontWait)
+	if len(got) != 0 {
+		t.Fatalf("got %v", got)
+	}
+
+	// The DontWait flag of the event loop.
+	el.SetDontWait(true)
+	el.CreateTimeEvent(1000, func(el *EventLoop, id int64, clientData interface{}) int {
+		return NoMore
+	}, nil, nil)
+	start := time.Now()
+	if el.ProcessEvents(AllEvents) != 0 || time.Since(start) > 500*time.Millisecond {
+		t.Fatal("waited")
+	}
+}
+
+func TestWait(t *testing.T) {
+	r, w := pipe(t)
+	if mask, err := Wait(r, Readable, 10); mask != 0 || err != nil {
+		t.Fatalf("mask %d err %v", mask, err)
+	}
+	if mask, err := Wait(w, Writable, 10); mask != Writable || err != nil {
+		t.Fatalf("mask %d err %v", mask, err)
+	}
+	syscall.Write(w, []byte("x"))
+	if mask, err := Wait(r, Readable, -1); mask != Readable || err != nil {
+		t.Fatalf("mask %d err %v", mask, err)
+	}
+	if GetAPIName() == "" {
+		t.Fatal("api name")
+	}
+}
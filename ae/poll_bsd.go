@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package ae
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// poll Wait for the events of 'fds' for 'milliseconds', forever if
+// negative, with poll(2). Returns the number of file descriptors with
+// events.
+func poll(fds []pollFd, milliseconds int) (int, error) {
+	var p unsafe.Pointer
+	if len(fds) > 0 {
+		p = unsafe.Pointer(&fds[0])
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_POLL, uintptr(p), uintptr(len(fds)), uintptr(milliseconds))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
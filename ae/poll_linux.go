@@ -0,0 +1,28 @@
+package ae
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// poll Wait for the events of 'fds' for 'milliseconds', forever if
+// negative, with ppoll(2), available on all the architectures. Returns
+// the number of file descriptors with events.
+func poll(fds []pollFd, milliseconds int) (int, error) {
+	var ts *syscall.Timespec
+	if milliseconds >= 0 {
+		t := syscall.NsecToTimespec(int64(time.Duration(milliseconds) * time.Millisecond))
+		ts = &t
+	}
+	var p unsafe.Pointer
+	if len(fds) > 0 {
+		p = unsafe.Pointer(&fds[0])
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(p), uintptr(len(fds)),
+		uintptr(unsafe.Pointer(ts)), 0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
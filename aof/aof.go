@@ -0,0 +1,461 @@
+package aof
+
+// The append only file, a port of aof.c of redis.
+//
+// Every write command executed is appended to the AOF in the RESP
+// protocol, so that replaying the file rebuilds the keyspace. The
+// commands are accumulated in a buffer that is written to the file
+// before the replies are sent to the clients, and the file is synced to
+// the disk according to the fsync policy: always, every second in the
+// background, or never, leaving it to the operating system.
+//
+// The AOF is a directory of files tracked by a manifest: a base file,
+// produced by the last rewrite, and the incr files with the commands
+// executed since. The rewrite produces a new base file with the minimal
+// set of commands rebuilding the keyspace, see rewrite.go.
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error
+var (
+	// ErrFormat the AOF contains an invalid command.
+	ErrFormat = errors.New("Bad file format reading the append only file")
+	// ErrTruncated the AOF is truncated, and the truncated tail can't be
+	// removed.
+	ErrTruncated = errors.New("Unexpected end of file reading the append only file")
+	// ErrManifest the manifest is malformed.
+	ErrManifest = errors.New("Invalid AOF manifest file format")
+	// ErrRewriteInProgress a rewrite is already in progress.
+	ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+	// ErrClosed the AOF is closed.
+	ErrClosed = errors.New("aof: AOF closed")
+)
+
+// Fsync policies.
+const (
+	// FsyncNo Don't fsync, just let the OS flush the data when it wants.
+	FsyncNo = 0
+	// FsyncAlways fsync after every write to the append only log.
+	FsyncAlways = 1
+	// FsyncEverysec fsync only one time every second, in background.
+	FsyncEverysec = 2
+)
+
+const (
+	// DefaultDirName Default name of the directory of the AOF files,
+	// like appenddirname.
+	DefaultDirName = "appendonlydir"
+	// DefaultFilename Default base name of the AOF files, like
+	// appendfilename.
+	DefaultFilename = "appendonly.aof"
+	// DefaultAutoRewritePerc Default growth of the AOF since the last
+	// rewrite triggering a rewrite, like auto-aof-rewrite-percentage.
+	DefaultAutoRewritePerc = 100
+	// DefaultAutoRewriteMinSize Default min size of the AOF triggering a
+	// rewrite, like auto-aof-rewrite-min-size.
+	DefaultAutoRewriteMinSize = 64 * 1024 * 1024
+)
+
+// AOF the append only file. The methods are not safe for concurrent
+// use, they are called with the lock serializing the commands held, the
+// same lock passed to StartRewrite.
+type AOF struct {
+	dir      string
+	filename string
+	// Kind of fsync policy
+	fsync int
+	// Don't stop on unexpected AOF EOF.
+	loadTruncated bool
+	// Rewrite AOF if % growth is > M and...
+	rewritePerc int
+	// the AOF file is at least N bytes.
+	rewriteMinSize int64
+	now            func() time.Time
+
+	manifest *manifest
+	// The incr file commands are appended to.
+	file *os.File
+	// AOF buffer, written before entering the event loop
+	buf []byte
+	// Currently selected DB in AOF
+	selectedDB int
+	// The size of the incr file appended to.
+	fileSize int64
+	// AOF current size (Including BASE + INCRs).
+	currentSize int64
+	// AOF size on latest startup or rewrite.
+	rewriteBaseSize int64
+	// The data written but not yet synced.
+	unsynced bool
+	// UNIX time of last fsync()
+	lastFsync time.Time
+	// Closed when the background fsync in progress is done, nil if
+	// none. The result is in bioFsyncErr.
+	fsyncDone   chan struct{}
+	bioFsyncErr error
+	// The last write error, nil if the last write succeeded.
+	lastWriteErr error
+
+	// rwMu protects 'rw' from Close, that is called without the lock of
+	// the rewrite.
+	rwMu sync.Mutex
+	// The rewrite in progress, nil if none.
+	rw *rewrite
+	// The error of the last rewrite, nil if it succeeded.
+	lastRewriteErr error
+	closed         bool
+}
+
+// Option opt.
+type Option func(a *AOF)
+
+// WithDir The directory of the AOF files, DefaultDirName by default.
+func WithDir(dir string) Option {
+	return func(a *AOF) {
+		a.dir = dir
+	}
+}
+
+// WithFilename The base name of the AOF files, DefaultFilename by
+// default.
+func WithFilename(name string) Option {
+	return func(a *AOF) {
+		a.filename = name
+	}
+}
+
+// WithFsync The fsync policy, FsyncEverysec by default.
+func WithFsync(policy int) Option {
+	return func(a *AOF) {
+		a.fsync = policy
+	}
+}
+
+// WithLoadTruncated Remove the truncated tail of the last file of the AOF
+// on load, instead of failing with ErrTruncated, like
+// aof-load-truncated. Enabled by default.
+func WithLoadTruncated(loadTruncated bool) Option {
+	return func(a *AOF) {
+		a.loadTruncated = loadTruncated
+	}
+}
+
+// WithAutoRewrite The growth percentage of the AOF since the last rewrite
+// and the min size of the AOF triggering a rewrite, see NeedRewrite. A
+// zero percentage disables the automatic rewrite.
+func WithAutoRewrite(perc int, minSize int64) Option {
+	return func(a *AOF) {
+		a.rewritePerc = perc
+		a.rewriteMinSize = minSize
+	}
+}
+
+// WithClock The 'now' is used to schedule the fsync every second,
+// time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(a *AOF) {
+		a.now = now
+	}
+}
+
+// Open the AOF, creating its directory and its first incr file if they
+// don't exist. The history files left by a rewrite are deleted. The
+// commands are appended to the last incr file, after loading the AOF
+// with Load.
+func Open(opts ...Option) (*AOF, error) {
+	a := &AOF{
+		dir:            DefaultDirName,
+		filename:       DefaultFilename,
+		fsync:          FsyncEverysec,
+		loadTruncated:  true,
+		rewritePerc:    DefaultAutoRewritePerc,
+		rewriteMinSize: DefaultAutoRewriteMinSize,
+		now:            time.Now,
+		selectedDB:     -1,
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+	a.lastFsync = a.now()
+
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := a.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = &manifest{}
+	}
+	a.manifest = m
+	a.deleteHistoryFiles()
+
+	// Open the last incr file, or create a new one.
+	if n := len(m.incrs); n > 0 {
+		a.file, err = os.OpenFile(a.path(m.incrs[n-1].name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		info := &fileInfo{name: a.incrName(m), seq: m.currIncrSeq, typ: fileTypeIncr}
+		if a.file, err = a.createFile(info.name); err != nil {
+			return nil, err
+		}
+		m.incrs = append(m.incrs, info)
+	}
+	if err := a.persistManifest(m); err != nil {
+		a.file.Close()
+		return nil, err
+	}
+
+	if err := a.updateSizes(); err != nil {
+		a.file.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// createFile Create a new file in the AOF directory, opened for append.
+func (a *AOF) createFile(name string) (*os.File, error) {
+	return os.OpenFile(a.path(name), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// updateSizes Update the size of the AOF, the base and incr files, and
+// of the incr file appended to. The size is the base size of the growth
+// triggering a rewrite.
+func (a *AOF) updateSizes() error {
+	st, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	a.fileSize = st.Size()
+
+	a.currentSize = 0
+	for _, info := range a.manifest.files() {
+		if st, err := os.Stat(a.path(info.name)); err == nil {
+			a.currentSize += st.Size()
+		}
+	}
+	a.rewriteBaseSize = a.currentSize
+	return nil
+}
+
+// catCommand Append the command to 'buf' in the RESP protocol.
+func catCommand(buf []byte, argv ...[]byte) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(argv)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range argv {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// catSelect Append a SELECT of the database 'dbid' to 'buf'.
+func catSelect(buf []byte, dbid int) []byte {
+	return catCommand(buf, []byte("SELECT"), []byte(strconv.Itoa(dbid)))
+}
+
+// Feed Append the command executed in the database 'dbid' to the AOF
+// buffer, written to the file by the next Flush. A SELECT is added when
+// the database differs from the one of the previous command. The command
+// is also buffered by the rewrite in progress, if any.
+func (a *AOF) Feed(dbid int, argv [][]byte) {
+	// The DB this command was targeting is not the same as the last
+	// command we appended. To issue a SELECT command is needed.
+	if dbid != a.selectedDB {
+		a.buf = catSelect(a.buf, dbid)
+		a.selectedDB = dbid
+	}
+	a.buf = catCommand(a.buf, argv...)
+
+	if a.rw != nil {
+		a.rw.feed(dbid, argv)
+	}
+}
+
+// Flush Write the AOF buffer to the file, and sync the file according to
+// the fsync policy. On a write error the buffer is kept to retry the
+// write, the written part of a short write is removed from the file, and
+// the error is returned until a write succeeds, see LastWriteError.
+func (a *AOF) Flush() error {
+	if a.closed {
+		return ErrClosed
+	}
+	if len(a.buf) == 0 {
+		// Check if we need to do fsync even the aof buffer is empty,
+		// because previously in AOF_FSYNC_EVERYSEC mode, fsync is called
+		// only when aof buffer is not empty, so if users stop write
+		// commands before fsync called in one second, the data in page
+		// cache cannot be flushed in time.
+		if a.fsync == FsyncEverysec && a.unsynced && a.fsyncDone == nil &&
+			a.now().Sub(a.lastFsync) >= time.Second {
+			a.backgroundFsync()
+		}
+		return nil
+	}
+
+	nwritten, err := a.file.Write(a.buf)
+	if err != nil {
+		if nwritten > 0 {
+			// Partial writes: try to remove the written part from the
+			// file, so that the next write appends the whole buffer
+			// again.
+			if terr := a.file.Truncate(a.fileSize); terr != nil {
+				// If the ftruncate() failed we can't remove the
+				// written part: trim it from the buffer instead.
+				a.fileSize += int64(nwritten)
+				a.currentSize += int64(nwritten)
+				a.buf = a.buf[:copy(a.buf, a.buf[nwritten:])]
+			}
+		}
+		a.lastWriteErr = err
+		return err
+	}
+	// Successful write(2). If AOF was in error state, restore the OK
+	// state.
+	a.lastWriteErr = nil
+	a.fileSize += int64(nwritten)
+	a.currentSize += int64(nwritten)
+	a.unsynced = true
+	// Reuse the buffer if it is small enough.
+	if cap(a.buf) < 4000 {
+		a.buf = a.buf[:0]
+	} else {
+		a.buf = nil
+	}
+
+	// Perform the fsync if needed.
+	switch a.fsync {
+	case FsyncAlways:
+		a.waitFsync()
+		if err := a.file.Sync(); err != nil {
+			a.lastWriteErr = err
+			return err
+		}
+		a.unsynced = false
+		a.lastFsync = a.now()
+	case FsyncEverysec:
+		if a.fsyncDone == nil && a.now().Sub(a.lastFsync) >= time.Second {
+			a.backgroundFsync()
+		}
+	}
+	return nil
+}
+
+// backgroundFsync Sync the incr file in a goroutine, the result is
+// collected by the next Flush or Cron.
+func (a *AOF) backgroundFsync() {
+	done := make(chan struct{})
+	f := a.file
+	a.fsyncDone = done
+	a.unsynced = false
+	a.lastFsync = a.now()
+	go func() {
+		a.bioFsyncErr = f.Sync()
+		close(done)
+	}()
+}
+
+// waitFsync Wait for the background fsync in progress, if any, and
+// return its error.
+func (a *AOF) waitFsync() error {
+	if a.fsyncDone == nil {
+		return nil
+	}
+	<-a.fsyncDone
+	a.fsyncDone = nil
+	err := a.bioFsyncErr
+	a.bioFsyncErr = nil
+	if err != nil {
+		// Sync again, the data is not known to be on disk.
+		a.unsynced = true
+	}
+	return err
+}
+
+// Cron Flush the AOF buffer if a previous write failed, and collect the
+// background fsync. Called periodically, like the AOF part of
+// serverCron.
+func (a *AOF) Cron() error {
+	if a.closed {
+		return ErrClosed
+	}
+	if a.fsyncDone != nil {
+		select {
+		case <-a.fsyncDone:
+			if err := a.waitFsync(); err != nil {
+				a.lastWriteErr = err
+			}
+		default:
+		}
+	}
+	return a.Flush()
+}
+
+// LastWriteError Return the error of the last write or fsync of the AOF,
+// nil if it succeeded. The write commands should be refused while the
+// AOF can't be written.
+func (a *AOF) LastWriteError() error {
+	return a.lastWriteErr
+}
+
+// Size Return the size of the AOF, the base and incr files.
+func (a *AOF) Size() int64 {
+	return a.currentSize
+}
+
+// NeedRewrite Return true if the AOF grew enough since the last rewrite,
+// or since it was opened, to be rewritten: the size is at least the min
+// size, and the growth percentage is at least the one configured.
+func (a *AOF) NeedRewrite() bool {
+	if a.rewritePerc == 0 || a.rw != nil || a.currentSize < a.rewriteMinSize {
+		return false
+	}
+	base := a.rewriteBaseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := a.currentSize*100/base - 100
+	return growth >= int64(a.rewritePerc)
+}
+
+// Close Abort the rewrite in progress, if any, flush the AOF buffer and
+// close the file. It must be called without the lock passed to
+// StartRewrite held.
+func (a *AOF) Close() error {
+	a.rwMu.Lock()
+	rw := a.rw
+	a.rwMu.Unlock()
+	if rw != nil {
+		rw.abort()
+	}
+
+	if a.closed {
+		return ErrClosed
+	}
+	err := a.Flush()
+	if werr := a.waitFsync(); err == nil {
+		err = werr
+	}
+	if serr := a.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	a.closed = true
+	return err
+}
@@ -0,0 +1,591 @@
+package aof
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"db"
+	"object"
+	"stream"
+	"zset"
+)
+
+// cmd Return the arguments of a command.
+func cmd(args ...string) [][]byte {
+	argv := make([][]byte, len(args))
+	for i, arg := range args {
+		argv[i] = []byte(arg)
+	}
+	return argv
+}
+
+// load Load the AOF, returning the commands as strings.
+func load(t *testing.T, a *AOF) []string {
+	t.Helper()
+	cmds, err := tryLoad(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmds
+}
+
+func tryLoad(a *AOF) ([]string, error) {
+	var cmds []string
+	err := a.Load(func(r io.Reader) error {
+		return fmt.Errorf("unexpected RDB preamble")
+	}, func(argv [][]byte) error {
+		args := make([]string, len(argv))
+		for i, arg := range argv {
+			args[i] = string(arg)
+		}
+		cmds = append(cmds, strings.Join(args, " "))
+		return nil
+	})
+	return cmds, err
+}
+
+// open Open the AOF in 'dir', closed at the end of the test.
+func open(t *testing.T, dir string, opts ...Option) *AOF {
+	t.Helper()
+	a, err := Open(append([]Option{WithDir(dir)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+// readFile Return the content of a file of the AOF directory.
+func readFile(t *testing.T, a *AOF, name string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(a.path(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFeed(t *testing.T) {
+	dir := t.TempDir()
+	a := open(t, dir)
+	if got := load(t, a); got != nil {
+		t.Fatalf("empty AOF %q", got)
+	}
+	a.Feed(0, cmd("SET", "a", "1"))
+	a.Feed(0, cmd("INCR", "a"))
+	a.Feed(3, cmd("DEL", "b"))
+	a.Feed(0, cmd("SET", "c", ""))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"*2\r\n$4\r\nINCR\r\n$1\r\na\r\n" +
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n3\r\n" +
+		"*2\r\n$3\r\nDEL\r\n$1\r\nb\r\n" +
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$0\r\n\r\n"
+	if got := readFile(t, a, "appendonly.aof.1.incr.aof"); got != want {
+		t.Fatalf("got %q", got)
+	}
+	if a.Size() != int64(len(want)) {
+		t.Fatalf("size %d", a.Size())
+	}
+	if got := readFile(t, a, "appendonly.aof.manifest"); got != "file appendonly.aof.1.incr.aof seq 1 type i\n" {
+		t.Fatalf("manifest %q", got)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != ErrClosed {
+		t.Fatal(err)
+	}
+
+	// The commands are appended to the same incr file after a restart.
+	a = open(t, dir, WithFsync(FsyncAlways))
+	wantCmds := []string{"SELECT 0", "SET a 1", "INCR a", "SELECT 3", "DEL b", "SELECT 0", "SET c "}
+	if got := load(t, a); !reflect.DeepEqual(got, wantCmds) {
+		t.Fatalf("got %q", got)
+	}
+	a.Feed(1, cmd("SET", "d", "x"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	wantCmds = append(wantCmds, "SELECT 1", "SET d x")
+	if got := load(t, a); !reflect.DeepEqual(got, wantCmds) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestFsyncEverysec(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	a := open(t, t.TempDir(), WithClock(func() time.Time { return now }))
+	a.Feed(0, cmd("SET", "a", "1"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if a.fsyncDone != nil || !a.unsynced {
+		t.Fatal("synced before a second")
+	}
+
+	now = now.Add(time.Second)
+	if err := a.Cron(); err != nil {
+		t.Fatal(err)
+	}
+	if a.fsyncDone == nil || a.unsynced {
+		t.Fatal("not synced in background")
+	}
+	if err := a.waitFsync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LastWriteError(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	for _, test := range []struct {
+		manifest string
+		ok       bool
+	}{
+		{"file a.1.base.aof seq 1 type b\nfile a.1.incr.aof seq 1 type i\n", true},
+		{"# comment\nfile a.1.incr.aof seq 1 type i\nfile a.2.incr.aof seq 2 type i\n", true},
+		{"file a.1.base.aof seq 1 type b\nfile a.0.base.aof seq 0 type h\n", true},
+		{"file a.1.base.aof type b seq 1 unknown x\n", true},
+		{"", false},
+		{"file a.1.base.aof seq 1 type h\n", false},
+		{"file a.1.base.aof seq 1 type b\nfile a.2.base.aof seq 2 type b\n", false},
+		{"file a.2.incr.aof seq 2 type i\nfile a.1.incr.aof seq 1 type i\n", false},
+		{"file a.1.incr.aof seq 1 type i", false},
+		{"file a.1.incr.aof seq 1 type x\n", false},
+		{"file a.1.incr.aof seq -1 type i\n", false},
+		{"file a.1.incr.aof seq 1\n", false},
+		{"file ../a.1.incr.aof seq 1 type i\n", false},
+		{"file a.1.incr.aof seq 1 type i\n" + strings.Repeat("#", manifestMaxLine+1) + "\n", false},
+	} {
+		m, err := parseManifest(strings.NewReader(test.manifest))
+		if test.ok != (err == nil) {
+			t.Fatalf("%q: %v", test.manifest, err)
+		}
+		if err != nil {
+			if err != ErrManifest {
+				t.Fatalf("%q: %v", test.manifest, err)
+			}
+			continue
+		}
+		// The manifest persisted is parsed back.
+		m2, err := parseManifest(strings.NewReader(m.String()))
+		if err != nil || !reflect.DeepEqual(m, m2) {
+			t.Fatalf("%q: %q %v", test.manifest, m.String(), err)
+		}
+	}
+
+	// The history files are deleted on open.
+	dir := t.TempDir()
+	for _, name := range []string{"appendonly.aof.1.base.aof", "appendonly.aof.2.base.aof", "appendonly.aof.3.incr.aof"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := "file appendonly.aof.2.base.aof seq 2 type b\n" +
+		"file appendonly.aof.1.base.aof seq 1 type h\n" +
+		"file appendonly.aof.3.incr.aof seq 3 type i\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := open(t, dir)
+	if _, err := os.Stat(filepath.Join(dir, "appendonly.aof.1.base.aof")); !os.IsNotExist(err) {
+		t.Fatal("history file not deleted")
+	}
+	want := "file appendonly.aof.2.base.aof seq 2 type b\nfile appendonly.aof.3.incr.aof seq 3 type i\n"
+	if got := readFile(t, a, "appendonly.aof.manifest"); got != want {
+		t.Fatalf("manifest %q", got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(WithDir(dir)); err != ErrManifest {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	valid := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	multi := "*1\r\n$5\r\nMULTI\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n"
+	for _, test := range []struct {
+		tail string
+		cmds []string
+		err  error
+	}{
+		{"", []string{"SELECT 0", "SET a 1"}, nil},
+		{"#annotation\r\n", []string{"SELECT 0", "SET a 1"}, nil},
+		{"*2\r\n$4\r\nINCR\r\n$1\r\n", []string{"SELECT 0", "SET a 1"}, ErrTruncated},
+		{"*2\r\n$4\r\nIN", []string{"SELECT 0", "SET a 1"}, ErrTruncated},
+		{"*2", []string{"SELECT 0", "SET a 1"}, ErrTruncated},
+		{multi, []string{"SELECT 0", "SET a 1", "MULTI", "INCR a"}, ErrTruncated},
+		{multi + "*1\r\n$4\r\nEXEC\r\n", []string{"SELECT 0", "SET a 1", "MULTI", "INCR a", "EXEC"}, nil},
+		{"+OK\r\n", []string{"SELECT 0", "SET a 1"}, ErrFormat},
+		{"*0\r\n", []string{"SELECT 0", "SET a 1"}, ErrFormat},
+		{"*1\r\n:1\r\n", []string{"SELECT 0", "SET a 1"}, ErrFormat},
+		{"*1\r\n$1\r\nab\r\n", []string{"SELECT 0", "SET a 1"}, ErrFormat},
+		{"*1\n", []string{"SELECT 0", "SET a 1"}, ErrFormat},
+	} {
+		for _, loadTruncated := range []bool{false, true} {
+			dir := t.TempDir()
+			a := open(t, dir, WithLoadTruncated(loadTruncated))
+			name := a.manifest.incrs[0].name
+			if err := ioutil.WriteFile(a.path(name), []byte(valid+test.tail), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tryLoad(a)
+			wantErr := test.err
+			if loadTruncated && wantErr == ErrTruncated {
+				wantErr = nil
+			}
+			if err != wantErr || !reflect.DeepEqual(got, test.cmds) {
+				t.Fatalf("%q %v: %q %v", test.tail, loadTruncated, got, err)
+			}
+			if test.err != ErrTruncated || !loadTruncated {
+				continue
+			}
+
+			// The truncated tail is removed, the commands are appended
+			// after the valid ones.
+			if data := readFile(t, a, name); data != valid {
+				t.Fatalf("%q: not truncated %q", test.tail, data)
+			}
+			if a.Size() != int64(len(valid)) {
+				t.Fatalf("%q: size %d", test.tail, a.Size())
+			}
+			a.Feed(0, cmd("DEL", "a"))
+			if err := a.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := load(t, a); !reflect.DeepEqual(got, []string{"SELECT 0", "SET a 1", "SELECT 0", "DEL a"}) {
+				t.Fatalf("%q: %q", test.tail, got)
+			}
+		}
+	}
+}
+
+func TestLoadMultiPart(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"appendonly.aof.1.base.aof": "REDIS0011" + "*2\r\n$3\r\nGET\r\n$1\r\na\r\n",
+		"appendonly.aof.1.incr.aof": "*2\r\n$3\r\nDEL\r\n$1\r\nb\r\n*2\r\n$3\r\nDEL\r\n",
+		"appendonly.aof.2.incr.aof": "*2\r\n$3\r\nDEL\r\n$1\r\nc\r\n",
+		"appendonly.aof.manifest": "file appendonly.aof.1.base.aof seq 1 type b\n" +
+			"file appendonly.aof.1.incr.aof seq 1 type i\n" +
+			"file appendonly.aof.2.incr.aof seq 2 type i\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The RDB preamble is loaded by the callback, the commands follow.
+	a := open(t, dir)
+	var cmds []string
+	loadRDB := func(r io.Reader) error {
+		buf := make([]byte, 9)
+		if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "REDIS0011" {
+			return fmt.Errorf("preamble %q %v", buf, err)
+		}
+		cmds = append(cmds, "RDB")
+		return nil
+	}
+	exec := func(argv [][]byte) error {
+		cmds = append(cmds, string(argv[0])+" "+string(argv[1]))
+		return nil
+	}
+
+	// Only the last file can be truncated.
+	if err := a.Load(loadRDB, exec); err != ErrTruncated {
+		t.Fatal(err)
+	}
+	files["appendonly.aof.1.incr.aof"] = "*2\r\n$3\r\nDEL\r\n$1\r\nb\r\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), []byte(files["appendonly.aof.1.incr.aof"]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmds = nil
+	if err := a.Load(loadRDB, exec); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"RDB", "GET a", "DEL b", "DEL c"}; !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("got %q", cmds)
+	}
+
+	// The errors of the callbacks are returned.
+	errExec := fmt.Errorf("exec")
+	if err := a.Load(loadRDB, func(argv [][]byte) error { return errExec }); err != errExec {
+		t.Fatal(err)
+	}
+
+	var size int64
+	for _, data := range files {
+		if !strings.HasSuffix(data, "\n") || strings.HasPrefix(data, "file") {
+			continue
+		}
+		size += int64(len(data))
+	}
+	if a.Size() != size {
+		t.Fatalf("size %d %d", a.Size(), size)
+	}
+}
+
+func TestNeedRewrite(t *testing.T) {
+	a := open(t, t.TempDir(), WithAutoRewrite(100, 100))
+	for a.Size() < 100 {
+		if a.NeedRewrite() {
+			t.Fatalf("need rewrite at %d", a.Size())
+		}
+		a.Feed(0, cmd("SET", "a", "1"))
+		a.Flush()
+	}
+	if !a.NeedRewrite() {
+		t.Fatal("no rewrite")
+	}
+
+	// The growth is relative to the size on startup.
+	a.rewriteBaseSize = a.Size()
+	if a.NeedRewrite() {
+		t.Fatal("need rewrite")
+	}
+	size := a.Size()
+	for a.Size() < 2*size {
+		a.Feed(0, cmd("SET", "a", "1"))
+		a.Flush()
+	}
+	if !a.NeedRewrite() {
+		t.Fatal("no rewrite")
+	}
+
+	a = open(t, t.TempDir(), WithAutoRewrite(0, 0))
+	a.Feed(0, cmd("SET", "a", "1"))
+	a.Flush()
+	if a.NeedRewrite() {
+		t.Fatal("rewrite disabled")
+	}
+}
+
+func TestRewriteObject(t *testing.T) {
+	cfg := &object.DefaultConfig
+	list := object.CreateList()
+	var items []string
+	for i := 0; i < 130; i++ {
+		items = append(items, fmt.Sprint(i))
+		object.ListPush(list, object.ListTail, [][]byte{[]byte(fmt.Sprint(i))}, cfg)
+	}
+	hash := object.CreateHash()
+	object.HashSet(hash, []byte("f"), []byte("v"), cfg)
+	zs := object.CreateZset(cfg)
+	zs.Ptr().(*zset.Zset).Add(1.5, []byte("m"), 0)
+	set := object.CreateSet([]byte("x"), 1, cfg)
+	object.SetAdd(set, []byte("x"), cfg)
+	st := object.CreateStream()
+	empty := object.CreateStream()
+
+	s := st.Ptr().(*stream.Stream)
+	if _, err := s.Append(cmd("f", "v"), &stream.ID{Ms: 1, Seq: 1}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Append(cmd("g", "w"), &stream.ID{Ms: 2, Seq: 0}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateGroup("g1", stream.ID{Ms: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateGroup("g2", stream.ID{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateConsumer("g2", "idle"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadGroup("g2", "c", nil, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	pending := s.GroupStates()[1].Pending[0]
+
+	for _, test := range []struct {
+		o    *object.Object
+		want []string
+	}{
+		{object.CreateString([]byte("v")), []string{"SET k v"}},
+		{object.CreateStringFromLongLong(12), []string{"SET k 12"}},
+		{list, []string{
+			"RPUSH k " + strings.Join(items[:64], " "),
+			"RPUSH k " + strings.Join(items[64:128], " "),
+			"RPUSH k 128 129",
+		}},
+		{hash, []string{"HMSET k f v"}},
+		{zs, []string{"ZADD k 1.5 m"}},
+		{set, []string{"SADD k x"}},
+		{empty, []string{"XADD k MAXLEN 0 0-0 x y", "XSETID k 0-0 ENTRIESADDED 0"}},
+		{st, []string{
+			"XADD k 1-1 f v",
+			"XADD k 2-0 g w",
+			"XSETID k 2-0 ENTRIESADDED 2",
+			"XGROUP CREATE k g1 2-0",
+			"XGROUP CREATE k g2 1-1",
+			fmt.Sprintf("XCLAIM k g2 c 0 1-1 TIME %d RETRYCOUNT 1 JUSTID FORCE", pending.DeliveryTime),
+			"XGROUP CREATECONSUMER k g2 idle",
+		}},
+	} {
+		var got []string
+		br := bufio.NewReader(strings.NewReader(string(rewriteObject(nil, []byte("k"), test.o))))
+		for {
+			argv, err := readCommand(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := make([]string, len(argv))
+			for i, arg := range argv {
+				args[i] = string(arg)
+			}
+			got = append(got, strings.Join(args, " "))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1600000000, 0)
+	clock := func() time.Time { return now }
+	dbs := db.Create(db.WithDatabases(4), db.WithClock(clock))
+	d0, _ := dbs.Select(0)
+	d2, _ := dbs.Select(2)
+	for i := 0; i < 3000; i++ {
+		d0.Set([]byte(fmt.Sprint("k", i)), []byte(fmt.Sprint(i)), 0, 0)
+	}
+	d0.Set([]byte("expired"), []byte("x"), 0, now.UnixNano()/1e6-1)
+	d2.Set([]byte("ttl"), []byte("x"), 0, now.UnixNano()/1e6+1000)
+
+	a := open(t, dir, WithClock(clock))
+	a.Feed(0, cmd("SET", "old", "x"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	mu.Lock()
+	if err := a.StartRewrite(dbs, &mu); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.StartRewrite(dbs, &mu); err != ErrRewriteInProgress {
+		t.Fatal(err)
+	}
+	if !a.RewriteInProgress() {
+		t.Fatal("not in progress")
+	}
+	// Modify the keys during the rewrite, as a command would.
+	a.RewriteVisit(0, []byte("k1"))
+	d0.Delete([]byte("k1"))
+	a.Feed(0, cmd("DEL", "k1"))
+	a.RewriteVisit(2, []byte("new"))
+	d2.Set([]byte("new"), []byte("y"), 0, 0)
+	a.Feed(2, cmd("SET", "new", "y"))
+	mu.Unlock()
+
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		key := []byte(fmt.Sprint("k", i*29))
+		a.RewriteVisit(0, key)
+		d0.Set(key, []byte("z"), 0, 0)
+		a.Feed(0, cmd("SET", string(key), "z"))
+		a.Flush()
+		mu.Unlock()
+	}
+	if err := a.WaitRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if a.RewriteInProgress() {
+		t.Fatal("still in progress")
+	}
+
+	// The new base file and a new incr file replace the old ones.
+	want := "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+	if got := readFile(t, a, "appendonly.aof.manifest"); got != want {
+		t.Fatalf("manifest %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "appendonly.aof.1.incr.aof")); !os.IsNotExist(err) {
+		t.Fatal("old incr file not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, rewriteTempFile)); !os.IsNotExist(err) {
+		t.Fatal("temp file not deleted")
+	}
+	a.Feed(3, cmd("SET", "after", "x"))
+	a.Flush()
+
+	// Replaying the AOF rebuilds the keyspace.
+	keys := make(map[string]string)
+	selected := -1
+	for _, c := range load(t, a) {
+		argv := strings.Split(c, " ")
+		switch argv[0] {
+		case "SELECT":
+			fmt.Sscan(argv[1], &selected)
+		case "SET":
+			keys[fmt.Sprint(selected, argv[1])] = argv[2]
+		case "DEL":
+			delete(keys, fmt.Sprint(selected, argv[1]))
+		case "PEXPIREAT":
+			keys[fmt.Sprint(selected, argv[1])] += " " + argv[2]
+		default:
+			t.Fatalf("command %q", c)
+		}
+	}
+	want2 := make(map[string]string)
+	for id := 0; id < dbs.Len(); id++ {
+		d, _ := dbs.Select(id)
+		d.Each(func(key []byte, val *object.Object, expire int64) bool {
+			if expire != -1 && expire < now.UnixNano()/1e6 {
+				return true
+			}
+			v := string(object.StringBytes(val))
+			if expire != -1 {
+				v += fmt.Sprint(" ", expire)
+			}
+			want2[fmt.Sprint(id, string(key))] = v
+			return true
+		})
+	}
+	want2["3after"] = "x"
+	if !reflect.DeepEqual(keys, want2) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(want2))
+	}
+
+	// A rewrite is aborted by Close.
+	mu.Lock()
+	if err := a.StartRewrite(dbs, &mu); err != nil {
+		t.Fatal(err)
+	}
+	mu.Unlock()
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WaitRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, rewriteTempFile)); !os.IsNotExist(err) {
+		t.Fatal("temp file not deleted")
+	}
+}
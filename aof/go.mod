@@ -0,0 +1,30 @@
+module aof
+
+go 1.14
+
+require (
+	db v0.0.0
+	object v0.0.0
+	resp v0.0.0
+	stream v0.0.0
+	zset v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	db => ../db
+	dict => ../dict
+	intset => ../intset
+	listpack => ../listpack
+	lzf => ../lzf
+	object => ../object
+	pubsub => ../pubsub
+	quicklist => ../quicklist
+	rax => ../rax
+	resp => ../resp
+	sds => ../sds
+	skiplist => ../skiplist
+	stream => ../stream
+	util => ../util
+	zset => ../zset
+)
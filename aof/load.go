@@ -0,0 +1,194 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+
+	"resp"
+)
+
+const (
+	// ioBufLen Generic I/O buffer size.
+	ioBufLen = 1024 * 16
+	// rdbSignature The signature of a file in the RDB format, the base
+	// file may be an RDB preamble followed by commands.
+	rdbSignature = "REDIS"
+)
+
+// Load Replay the AOF, the base file then the incr files, calling 'exec'
+// with every command: the SELECT commands are passed along, and so are
+// MULTI and EXEC, 'exec' is responsible to queue the commands of a
+// transaction. A file starting with an RDB preamble is passed to
+// 'loadRDB', the commands following the preamble are then replayed.
+//
+// If the last file ends with a truncated command, or with a transaction
+// without EXEC, the file is truncated to its last valid command and the
+// replay succeeds, unless WithLoadTruncated is disabled: ErrTruncated is
+// returned. ErrFormat is returned for a malformed command.
+func (a *AOF) Load(loadRDB func(r io.Reader) error, exec func(argv [][]byte) error) error {
+	files := a.manifest.files()
+	for i, info := range files {
+		if err := a.loadFile(info.name, i == len(files)-1, loadRDB, exec); err != nil {
+			return err
+		}
+	}
+	// The sizes are updated after the truncated tail is removed.
+	return a.updateSizes()
+}
+
+// loadFile Replay a file of the AOF, see Load.
+func (a *AOF) loadFile(name string, last bool, loadRDB func(r io.Reader) error, exec func(argv [][]byte) error) error {
+	f, err := os.Open(a.path(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, ioBufLen)
+	// offset Return the offset of the next byte read from the buffer.
+	offset := func() int64 {
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return pos - int64(br.Buffered())
+	}
+
+	// Check if the AOF file is in RDB format (it may be RDB encoded base
+	// AOF), this is the case of the base file produced with the RDB
+	// preamble. An empty file is fine.
+	if sig, err := br.Peek(len(rdbSignature)); err == nil && string(sig) == rdbSignature {
+		if err := loadRDB(br); err != nil {
+			return err
+		}
+	}
+
+	// Offset of latest well-formed command loaded.
+	validUpTo := offset()
+	// Offset before MULTI command loaded.
+	validBeforeMulti := int64(-1)
+	inMulti := false
+	for {
+		argv, err := readCommand(br)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return a.truncated(name, last, validUpTo)
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bytes.EqualFold(argv[0], []byte("multi")):
+			validBeforeMulti = validUpTo
+			inMulti = true
+		case bytes.EqualFold(argv[0], []byte("exec")),
+			bytes.EqualFold(argv[0], []byte("discard")):
+			inMulti = false
+		}
+		if err := exec(argv); err != nil {
+			return err
+		}
+		validUpTo = offset()
+	}
+
+	// This point can only be reached when EOF is reached without errors.
+	// If the client is in the middle of a MULTI/EXEC, handle it as it
+	// was a short read, even if technically the protocol is correct: we
+	// want to remove the unprocessed tail and continue.
+	if inMulti {
+		return a.truncated(name, last, validBeforeMulti)
+	}
+	return nil
+}
+
+// truncated Handle the truncated tail of a file: the last file is
+// truncated to 'validUpTo' if allowed, ErrTruncated is returned
+// otherwise.
+func (a *AOF) truncated(name string, last bool, validUpTo int64) error {
+	if !last || !a.loadTruncated || validUpTo == -1 {
+		return ErrTruncated
+	}
+	if err := os.Truncate(a.path(name), validUpTo); err != nil {
+		return ErrTruncated
+	}
+	return nil
+}
+
+// readLine Read a line terminated by CRLF, returned without the CRLF.
+// io.EOF is returned at the end of the file, io.ErrUnexpectedEOF if the
+// line is truncated.
+func readLine(br *bufio.Reader, first bool) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err == io.EOF {
+		if first && len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// The line is too long.
+		return nil, ErrFormat
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrFormat
+	}
+	return line[:len(line)-2], nil
+}
+
+// readCommand Read a command in the RESP protocol, skipping the
+// annotations, the lines starting with '#'.
+func readCommand(br *bufio.Reader) ([][]byte, error) {
+	var line []byte
+	for {
+		var err error
+		if line, err = readLine(br, true); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '#' {
+			break
+		}
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return nil, ErrFormat
+	}
+	argc, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || argc < 1 || argc > resp.MaxMultibulkLen {
+		return nil, ErrFormat
+	}
+
+	// Don't trust the length of a corrupted file to preallocate.
+	prealloc := argc
+	if prealloc > 1024 {
+		prealloc = 1024
+	}
+	argv := make([][]byte, 0, prealloc)
+	for j := int64(0); j < argc; j++ {
+		line, err := readLine(br, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, ErrFormat
+		}
+		l, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || l < 0 || l > resp.MaxBulkLen {
+			return nil, ErrFormat
+		}
+		arg := make([]byte, l+2)
+		if _, err := io.ReadFull(br, arg); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if arg[l] != '\r' || arg[l+1] != '\n' {
+			return nil, ErrFormat
+		}
+		argv = append(argv, arg[:l:l])
+	}
+	return argv, nil
+}
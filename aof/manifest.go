@@ -0,0 +1,261 @@
+package aof
+
+// The manifest of the multi part AOF, listing the base file and the incr
+// files of the AOF in the AOF directory, one file per line:
+//
+// file appendonly.aof.1.base.aof seq 1 type b
+// file appendonly.aof.1.incr.aof seq 1 type i
+// file appendonly.aof.2.incr.aof seq 2 type i
+//
+// The base file is the result of the last rewrite, and the incr files
+// the commands appended since. The history files are the files replaced
+// by a rewrite, still to be deleted.
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Types of the AOF files.
+const (
+	fileTypeBase = 'b'
+	fileTypeHist = 'h'
+	fileTypeIncr = 'i'
+)
+
+const (
+	baseFileSuffix     = ".base"
+	incrFileSuffix     = ".incr"
+	aofFormatSuffix    = ".aof"
+	manifestNameSuffix = ".manifest"
+	tempFilePrefix     = "temp-"
+	// manifestMaxLine The max length of a line of the manifest.
+	manifestMaxLine = 1024
+)
+
+// fileInfo a file of the AOF.
+type fileInfo struct {
+	name string
+	// The sequence number of the base or incr file.
+	seq int64
+	typ byte
+}
+
+// manifest the files of the AOF.
+type manifest struct {
+	// The base file, nil if none.
+	base *fileInfo
+	// The incr files, in increasing order of sequence number.
+	incrs []*fileInfo
+	// The files to delete, replaced by a rewrite.
+	history []*fileInfo
+	// The sequence numbers of the last base and incr files created.
+	currBaseSeq int64
+	currIncrSeq int64
+}
+
+// dup Return a copy of the manifest, the file infos are shared.
+func (m *manifest) dup() *manifest {
+	c := *m
+	c.incrs = append([]*fileInfo{}, m.incrs...)
+	c.history = append([]*fileInfo{}, m.history...)
+	return &c
+}
+
+// files Return the base file, if any, and the incr files, in the order
+// they are loaded.
+func (m *manifest) files() []*fileInfo {
+	var files []*fileInfo
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// String Return the manifest as persisted: the base file, the history
+// files and the incr files.
+func (m *manifest) String() string {
+	var b strings.Builder
+	write := func(info *fileInfo) {
+		b.WriteString("file ")
+		b.WriteString(info.name)
+		b.WriteString(" seq ")
+		b.WriteString(strconv.FormatInt(info.seq, 10))
+		b.WriteString(" type ")
+		b.WriteByte(info.typ)
+		b.WriteByte('\n')
+	}
+	if m.base != nil {
+		write(m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return b.String()
+}
+
+// parseManifest Parse the manifest. The lines starting with '#' are
+// comments, and the unknown keys of a line are ignored.
+func parseManifest(r io.Reader) (*manifest, error) {
+	m := &manifest{}
+	br := bufio.NewReaderSize(r, manifestMaxLine+1)
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil {
+			// The line is too long, or not terminated.
+			return nil, ErrManifest
+		}
+		if line[0] == '#' {
+			continue
+		}
+
+		args := strings.Fields(string(line))
+		if len(args) < 6 || len(args)%2 != 0 {
+			return nil, ErrManifest
+		}
+		info := &fileInfo{seq: -1}
+		for i := 0; i < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "file":
+				info.name = args[i+1]
+			case "seq":
+				if info.seq, err = strconv.ParseInt(args[i+1], 10, 64); err != nil || info.seq < 0 {
+					return nil, ErrManifest
+				}
+			case "type":
+				if len(args[i+1]) != 1 {
+					return nil, ErrManifest
+				}
+				info.typ = args[i+1][0]
+			}
+		}
+		// The files are in the AOF directory.
+		if info.name == "" || filepath.Base(info.name) != info.name || info.seq == -1 {
+			return nil, ErrManifest
+		}
+
+		switch info.typ {
+		case fileTypeBase:
+			// Found duplicate base file information
+			if m.base != nil {
+				return nil, ErrManifest
+			}
+			m.base = info
+			m.currBaseSeq = info.seq
+		case fileTypeHist:
+			m.history = append(m.history, info)
+		case fileTypeIncr:
+			// Found a non-monotonic sequence number
+			if info.seq <= m.currIncrSeq {
+				return nil, ErrManifest
+			}
+			m.incrs = append(m.incrs, info)
+			m.currIncrSeq = info.seq
+		default:
+			return nil, ErrManifest
+		}
+	}
+
+	// Found an empty AOF manifest
+	if m.base == nil && len(m.incrs) == 0 {
+		return nil, ErrManifest
+	}
+	return m, nil
+}
+
+// manifestName Return the name of the manifest.
+func (a *AOF) manifestName() string {
+	return a.filename + manifestNameSuffix
+}
+
+// baseName Return the name of a new base file, and increment the base
+// sequence number.
+func (a *AOF) baseName(m *manifest) string {
+	m.currBaseSeq++
+	return a.filename + "." + strconv.FormatInt(m.currBaseSeq, 10) +
+		baseFileSuffix + aofFormatSuffix
+}
+
+// incrName Return the name of a new incr file, and increment the incr
+// sequence number.
+func (a *AOF) incrName(m *manifest) string {
+	m.currIncrSeq++
+	return a.filename + "." + strconv.FormatInt(m.currIncrSeq, 10) +
+		incrFileSuffix + aofFormatSuffix
+}
+
+// path Return the path of a file of the AOF directory.
+func (a *AOF) path(name string) string {
+	return filepath.Join(a.dir, name)
+}
+
+// loadManifest Load the manifest from the AOF directory, nil if there is
+// no manifest.
+func (a *AOF) loadManifest() (*manifest, error) {
+	f, err := os.Open(a.path(a.manifestName()))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseManifest(f)
+}
+
+// persistManifest Atomically replace the manifest of the AOF directory:
+// the manifest is written to a temp file, that is renamed once synced.
+func (a *AOF) persistManifest(m *manifest) error {
+	tmp := a.path(tempFilePrefix + a.manifestName())
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(m.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, a.path(a.manifestName()))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return fsyncDir(a.dir)
+}
+
+// deleteHistoryFiles Delete the history files, and remove them from the
+// manifest. The manifest is persisted later.
+func (a *AOF) deleteHistoryFiles() {
+	for _, info := range a.manifest.history {
+		os.Remove(a.path(info.name))
+	}
+	a.manifest.history = nil
+}
+
+// fsyncDir Sync the directory, so that the renames and the files created
+// in the directory are durable.
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not all the platforms support the sync of a directory.
+	d.Sync()
+	return nil
+}
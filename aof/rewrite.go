@@ -0,0 +1,465 @@
+package aof
+
+// The rewrite of the AOF, producing a new base file with the minimal set
+// of commands rebuilding the keyspace.
+//
+// Redis forks a child writing the snapshot of the keyspace. Here the
+// keyspace is rewritten by a goroutine that takes the lock serializing
+// the commands to rewrite a bunch of keys at a time, iterating the hash
+// tables of the databases with a safe iterator, and writes the commands
+// to a temp file after releasing the lock. The keyspace changes between
+// the steps, so before a write command is executed the keys it touches
+// are rewritten with their current value, if not already rewritten, and
+// the commands executed since the start of the rewrite are buffered: the
+// base file is the rewritten keys followed by the buffered commands.
+//
+// When the iteration is done, the buffered commands are written to the
+// temp file, that becomes the new base file, a new incr file is created
+// for the commands executed from then on, and the files replaced are
+// deleted once the manifest is persisted.
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"sync"
+
+	"db"
+	"object"
+	"resp"
+	"stream"
+	"zset"
+)
+
+const (
+	// rewriteItemsPerCmd The max number of items of a command of the
+	// rewrite, like AOF_REWRITE_ITEMS_PER_CMD.
+	rewriteItemsPerCmd = 64
+	// rewriteKeysPerStep The number of keys rewritten every time the
+	// lock is taken.
+	rewriteKeysPerStep = 1024
+	// rewriteTempFile The name of the temp file of the rewrite.
+	rewriteTempFile = tempFilePrefix + "rewriteaof-bg.aof"
+)
+
+// rewrite the state of a rewrite in progress. The fields are accessed
+// with the lock held.
+type rewrite struct {
+	a   *AOF
+	mu  sync.Locker
+	dbs *db.Server
+	tmp *os.File
+
+	// The database being iterated, and its iterator, nil before the
+	// first key of the database.
+	dbid int
+	it   *db.KeyIterator
+	// The keys already rewritten of the databases not yet iterated, and
+	// of the one being iterated.
+	done []map[string]struct{}
+	// The rewritten keys, not yet written to the temp file, and the
+	// database selected in them.
+	out   []byte
+	outDB int
+	// The commands executed since the start of the rewrite, and the
+	// database selected in them.
+	buf   []byte
+	bufDB int
+
+	aborted bool
+	// Closed when the rewrite is done, successfully or not.
+	finished chan struct{}
+}
+
+// StartRewrite Start rewriting the AOF in the background, rewriting the
+// databases 'dbs'. The keyspace is accessed with 'mu' held, the lock
+// serializing the commands, it is held by the caller.
+//
+// While the rewrite is in progress, RewriteVisit must be called with
+// the keys touched by a write command before executing it, and
+// RewriteDrain before a write command touching the keyspace as a whole,
+// like FLUSHALL or SWAPDB. The commands executed are fed as usual with
+// Feed.
+func (a *AOF) StartRewrite(dbs *db.Server, mu sync.Locker) error {
+	if a.closed {
+		return ErrClosed
+	}
+	if a.rw != nil {
+		return ErrRewriteInProgress
+	}
+
+	tmp, err := os.OpenFile(a.path(rewriteTempFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	rw := &rewrite{
+		a:        a,
+		mu:       mu,
+		dbs:      dbs,
+		tmp:      tmp,
+		done:     make([]map[string]struct{}, dbs.Len()),
+		outDB:    -1,
+		bufDB:    -1,
+		finished: make(chan struct{}),
+	}
+	for i := range rw.done {
+		rw.done[i] = make(map[string]struct{})
+	}
+	a.rwMu.Lock()
+	a.rw = rw
+	a.rwMu.Unlock()
+
+	go rw.run()
+	return nil
+}
+
+// RewriteInProgress Return true if a rewrite is in progress.
+func (a *AOF) RewriteInProgress() bool {
+	return a.rw != nil
+}
+
+// LastRewriteError Return the error of the last rewrite, nil if it
+// succeeded.
+func (a *AOF) LastRewriteError() error {
+	return a.lastRewriteErr
+}
+
+// WaitRewrite Wait for the rewrite in progress, if any, and return the
+// error of the last rewrite. It must be called without the lock passed
+// to StartRewrite held.
+func (a *AOF) WaitRewrite() error {
+	a.rwMu.Lock()
+	rw := a.rw
+	a.rwMu.Unlock()
+	if rw != nil {
+		<-rw.finished
+	}
+
+	a.rwMu.Lock()
+	defer a.rwMu.Unlock()
+	return a.lastRewriteErr
+}
+
+// RewriteVisit Rewrite the key of the database 'dbid' with its current
+// value, if not already rewritten, before a write command touches it.
+// Nothing is done if no rewrite is in progress.
+func (a *AOF) RewriteVisit(dbid int, key []byte) {
+	if a.rw != nil {
+		a.rw.visit(dbid, key)
+	}
+}
+
+// RewriteDrain Rewrite all the keys not already rewritten, before a write
+// command touches the keyspace as a whole. Nothing is done if no rewrite
+// is in progress.
+func (a *AOF) RewriteDrain() {
+	if a.rw != nil {
+		for a.rw.step(math.MaxInt32) {
+		}
+	}
+}
+
+// run Rewrite the keyspace a step at a time, then finish the rewrite.
+func (rw *rewrite) run() {
+	defer close(rw.finished)
+	for {
+		rw.mu.Lock()
+		if rw.aborted {
+			rw.fail(nil)
+			rw.mu.Unlock()
+			return
+		}
+		more := rw.step(rewriteKeysPerStep)
+		out := rw.out
+		rw.out = nil
+		if !more {
+			if err := rw.finish(out); err != nil {
+				rw.fail(err)
+			}
+			rw.mu.Unlock()
+			return
+		}
+		rw.mu.Unlock()
+
+		if _, err := rw.tmp.Write(out); err != nil {
+			rw.mu.Lock()
+			rw.fail(err)
+			rw.mu.Unlock()
+			return
+		}
+	}
+}
+
+// abort Stop the rewrite and wait for it, the lock is not held.
+func (rw *rewrite) abort() {
+	rw.mu.Lock()
+	rw.aborted = true
+	rw.mu.Unlock()
+	<-rw.finished
+}
+
+// fail Discard the rewrite, with the error 'err'.
+func (rw *rewrite) fail(err error) {
+	if rw.it != nil {
+		rw.it.Release()
+		rw.it = nil
+	}
+	if rw.tmp != nil {
+		rw.tmp.Close()
+		os.Remove(rw.tmp.Name())
+	}
+
+	a := rw.a
+	a.rwMu.Lock()
+	a.rw = nil
+	a.lastRewriteErr = err
+	a.rwMu.Unlock()
+}
+
+// step Rewrite up to 'n' keys, returns false when all the databases are
+// rewritten.
+func (rw *rewrite) step(n int) bool {
+	for rw.dbid < rw.dbs.Len() {
+		d, _ := rw.dbs.Select(rw.dbid)
+		if rw.it == nil {
+			rw.it = d.SafeIterator()
+		}
+		done := rw.done[rw.dbid]
+		for ; n > 0; n-- {
+			key, val, expire, ok := rw.it.Next()
+			if !ok {
+				break
+			}
+			if _, ok := done[string(key)]; ok {
+				continue
+			}
+			done[string(key)] = struct{}{}
+			rw.emit(d, key, val, expire)
+		}
+		if n == 0 {
+			return true
+		}
+
+		// The database is rewritten, its keys are no longer tracked.
+		rw.it.Release()
+		rw.it = nil
+		rw.done[rw.dbid] = nil
+		rw.dbid++
+	}
+	return false
+}
+
+// visit Rewrite the key, see RewriteVisit.
+func (rw *rewrite) visit(dbid int, key []byte) {
+	// The databases already iterated are fully rewritten, the changes
+	// are in the buffered commands.
+	if dbid < rw.dbid || dbid >= len(rw.done) {
+		return
+	}
+	done := rw.done[dbid]
+	if _, ok := done[string(key)]; ok {
+		return
+	}
+	// Even if it doesn't exist: the key is created by the buffered
+	// commands, it must not be rewritten again by the iteration.
+	done[string(key)] = struct{}{}
+
+	d, _ := rw.dbs.Select(dbid)
+	if val := d.Lookup(key); val != nil {
+		rw.emit(d, key, val, d.GetExpire(key))
+	}
+}
+
+// emit Rewrite the key of the database, the expired keys are skipped.
+func (rw *rewrite) emit(d *db.DB, key []byte, val *object.Object, expire int64) {
+	if expire != -1 && d.KeyIsExpired(key) {
+		return
+	}
+	dbid := d.ID()
+	if dbid != rw.outDB {
+		rw.out = catSelect(rw.out, dbid)
+		rw.outDB = dbid
+	}
+	rw.out = rewriteObject(rw.out, key, val)
+	// Save the expire time
+	if expire != -1 {
+		rw.out = catCommand(rw.out, []byte("PEXPIREAT"), key,
+			[]byte(strconv.FormatInt(expire, 10)))
+	}
+}
+
+// feed Buffer the command executed, see Feed.
+func (rw *rewrite) feed(dbid int, argv [][]byte) {
+	if dbid != rw.bufDB {
+		rw.buf = catSelect(rw.buf, dbid)
+		rw.bufDB = dbid
+	}
+	rw.buf = catCommand(rw.buf, argv...)
+}
+
+// finish Write the last rewritten keys and the buffered commands to the
+// temp file, and switch the AOF to the new base file and a new incr file.
+func (rw *rewrite) finish(out []byte) error {
+	a := rw.a
+	// The commands of the AOF buffer are in the buffered commands, they
+	// are written to the old incr file in case the switch fails.
+	a.Flush()
+
+	tmp := rw.tmp
+	_, err := tmp.Write(append(out, rw.buf...))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	m := a.manifest.dup()
+	base := &fileInfo{name: a.baseName(m), seq: m.currBaseSeq, typ: fileTypeBase}
+	if err := os.Rename(tmp.Name(), a.path(base.name)); err != nil {
+		return err
+	}
+	rw.tmp = nil
+	incr := &fileInfo{name: a.incrName(m), seq: m.currIncrSeq, typ: fileTypeIncr}
+	f, err := a.createFile(incr.name)
+	if err != nil {
+		os.Remove(a.path(base.name))
+		return err
+	}
+
+	// The replaced files become history files, deleted once the new
+	// manifest is persisted.
+	for _, info := range m.files() {
+		m.history = append(m.history, &fileInfo{name: info.name, seq: info.seq, typ: fileTypeHist})
+	}
+	m.base = base
+	m.incrs = []*fileInfo{incr}
+	if err := a.persistManifest(m); err != nil {
+		f.Close()
+		os.Remove(a.path(base.name))
+		os.Remove(a.path(incr.name))
+		return err
+	}
+
+	// Switch to the new incr file, the commands not yet written are in
+	// the new base file.
+	a.waitFsync()
+	a.file.Close()
+	a.file = f
+	a.manifest = m
+	a.buf = a.buf[:0]
+	a.selectedDB = -1
+	a.unsynced = false
+	a.lastWriteErr = nil
+	a.deleteHistoryFiles()
+	a.persistManifest(m)
+	a.updateSizes()
+
+	a.rwMu.Lock()
+	a.rw = nil
+	a.lastRewriteErr = nil
+	a.rwMu.Unlock()
+	return nil
+}
+
+// rewriteObject Append the commands rebuilding the value of the key to
+// 'buf', emitting up to rewriteItemsPerCmd items per command.
+func rewriteObject(buf []byte, key []byte, o *object.Object) []byte {
+	switch o.Type() {
+	case object.TypeString:
+		// Emit a SET command
+		return catCommand(buf, []byte("SET"), key, object.StringBytes(o))
+	case object.TypeList:
+		return catItems(buf, []byte("RPUSH"), key, object.ListRange(o, 0, -1), 1)
+	case object.TypeSet:
+		return catItems(buf, []byte("SADD"), key, object.SetMembers(o), 1)
+	case object.TypeZset:
+		elements := o.Ptr().(*zset.Zset).RangeByRank(0, -1, false)
+		items := make([][]byte, 0, 2*len(elements))
+		for _, el := range elements {
+			items = append(items, []byte(resp.FormatDouble(el.Score)), el.Member)
+		}
+		return catItems(buf, []byte("ZADD"), key, items, 2)
+	case object.TypeHash:
+		return catItems(buf, []byte("HMSET"), key, object.HashGetAll(o), 2)
+	case object.TypeStream:
+		return rewriteStream(buf, key, o.Ptr().(*stream.Stream))
+	}
+	panic("aof: unknown object type")
+}
+
+// catItems Append the commands 'name key items...' to 'buf', each one
+// with up to rewriteItemsPerCmd items, an item being 'width' arguments.
+func catItems(buf []byte, name, key []byte, items [][]byte, width int) []byte {
+	for len(items) > 0 {
+		n := rewriteItemsPerCmd * width
+		if n > len(items) {
+			n = len(items)
+		}
+		argv := make([][]byte, 0, 2+n)
+		argv = append(argv, name, key)
+		buf = catCommand(buf, append(argv, items[:n]...)...)
+		items = items[n:]
+	}
+	return buf
+}
+
+// rewriteStream Append the commands rebuilding the stream to 'buf': the
+// entries, the last ID and the consumer groups, with their pending
+// entries and consumers.
+func rewriteStream(buf []byte, key []byte, s *stream.Stream) []byte {
+	lastID := []byte(s.LastID().String())
+	if s.Len() > 0 {
+		for _, e := range s.Range(stream.ID{}, stream.MaxID, 0, false) {
+			argv := make([][]byte, 0, 3+len(e.Fields))
+			argv = append(argv, []byte("XADD"), key, []byte(e.ID.String()))
+			buf = catCommand(buf, append(argv, e.Fields...)...)
+		}
+	} else {
+		// Use the XADD MAXLEN 0 trick to generate an empty stream if the
+		// key we are serializing is an empty string, which is possible
+		// for the Stream type.
+		buf = catCommand(buf, []byte("XADD"), key, []byte("MAXLEN"), []byte("0"),
+			lastID, []byte("x"), []byte("y"))
+	}
+
+	// Append XSETID after XADD, make sure lastid is correct, in case of
+	// XDEL lastid.
+	buf = catCommand(buf, []byte("XSETID"), key, lastID,
+		[]byte("ENTRIESADDED"), []byte(strconv.FormatUint(s.EntriesAdded(), 10)))
+
+	for _, g := range s.GroupStates() {
+		// Emit the XGROUP CREATE in order to create the group.
+		name := []byte(g.Name)
+		buf = catCommand(buf, []byte("XGROUP"), []byte("CREATE"), key, name,
+			[]byte(g.LastID.String()))
+
+		// Generate XCLAIMs for each consumer that happens to have
+		// pending entries. Empty consumers are created with XGROUP
+		// CREATECONSUMER.
+		nacks := make(map[stream.ID]stream.PendingState, len(g.Pending))
+		for _, p := range g.Pending {
+			nacks[p.ID] = p
+		}
+		for _, c := range g.Consumers {
+			consumer := []byte(c.Name)
+			if len(c.Pending) == 0 {
+				buf = catCommand(buf, []byte("XGROUP"), []byte("CREATECONSUMER"),
+					key, name, consumer)
+				continue
+			}
+			for _, id := range c.Pending {
+				nack := nacks[id]
+				buf = catCommand(buf, []byte("XCLAIM"), key, name, consumer,
+					[]byte("0"), []byte(id.String()),
+					[]byte("TIME"), []byte(strconv.FormatInt(nack.DeliveryTime, 10)),
+					[]byte("RETRYCOUNT"), []byte(strconv.FormatUint(nack.DeliveryCount, 10)),
+					[]byte("JUSTID"), []byte("FORCE"))
+			}
+		}
+	}
+	return buf
+}
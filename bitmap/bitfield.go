@@ -0,0 +1,368 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+
+	"sds"
+	"util"
+)
+
+// Error
+var (
+	// ErrBitfieldType the type of the field is not valid.
+	ErrBitfieldType = errors.New("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	// ErrOverflowType the OVERFLOW type is not valid.
+	ErrOverflowType = errors.New("Invalid OVERFLOW type specified")
+	// ErrNotInteger the value is not an integer.
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	// ErrSyntax the BITFIELD arguments are malformed.
+	ErrSyntax = errors.New("syntax error")
+	// ErrReadOnly a write subcommand of BITFIELD_RO.
+	ErrReadOnly = errors.New("BITFIELD_RO only supports the GET subcommand")
+)
+
+// Overflow behaviors of BITFIELD SET and INCRBY.
+const (
+	// OverflowWrap wrap around, the default.
+	OverflowWrap = iota
+	// OverflowSat saturate to the min or max value.
+	OverflowSat
+	// OverflowFail don't perform the operation, the reply is nil.
+	OverflowFail
+)
+
+// Subcommands of BITFIELD.
+const (
+	FieldGet = iota
+	FieldSet
+	FieldIncrBy
+)
+
+// FieldOp a subcommand of BITFIELD.
+type FieldOp struct {
+	// Op FieldGet, FieldSet or FieldIncrBy.
+	Op int
+	// Signed the field is a signed integer of 'Bits' bits, up to 64
+	// bits, otherwise an unsigned integer, up to 63 bits.
+	Signed bool
+	Bits   int
+	// Offset the bit offset of the field.
+	Offset uint64
+	// Value the value of SET, the increment of INCRBY.
+	Value int64
+	// Overflow the overflow behavior of SET and INCRBY.
+	Overflow int
+}
+
+// Unsigned Return the unsigned integer of 'bits' bits at the bit
+// 'offset' of 'p', the bits after the end of 'p' are zero.
+func Unsigned(p []byte, offset uint64, bits int) uint64 {
+	var value uint64
+	for j := 0; j < bits; j++ {
+		byte := offset >> 3
+		bit := 7 - uint(offset&0x7)
+		var bitval uint64
+		if byte < uint64(len(p)) {
+			bitval = uint64(p[byte]>>bit) & 1
+		}
+		value = (value << 1) | bitval
+		offset++
+	}
+	return value
+}
+
+// Signed Return the signed integer of 'bits' bits at the bit 'offset' of
+// 'p', the bits after the end of 'p' are zero.
+func Signed(p []byte, offset uint64, bits int) int64 {
+	value := Unsigned(p, offset, bits)
+	// If the top significant bit is 1, propagate it to all the higher
+	// bits for two's complement representation of signed integers.
+	if bits < 64 && value&(1<<uint(bits-1)) != 0 {
+		value |= ^uint64(0) << uint(bits)
+	}
+	return int64(value)
+}
+
+// setUnsigned Set the 'bits' bits at the bit 'offset' of 'p' to 'value',
+// 'p' must be large enough.
+func setUnsigned(p []byte, offset uint64, bits int, value uint64) {
+	for j := 0; j < bits; j++ {
+		bitval := byte(value>>uint(bits-1-j)) & 1
+		byte := offset >> 3
+		bit := 7 - uint(offset&0x7)
+		p[byte] &^= 1 << bit
+		p[byte] |= bitval << bit
+		offset++
+	}
+}
+
+// CheckUnsignedOverflow This helper function is used in order to check
+// if the unsigned integer 'value' of 'bits' bits, incremented by 'incr',
+// overflows. Returns 1 for an overflow, -1 for an underflow, 0 otherwise.
+// The wrapped or saturated value, according to 'owtype', is returned as
+// 'limit' on overflow.
+func CheckUnsignedOverflow(value uint64, incr int64, bits int, owtype int) (limit uint64, overflow int) {
+	max := ^uint64(0)
+	if bits != 64 {
+		max = (1 << uint(bits)) - 1
+	}
+	maxincr := int64(max - value)
+	minincr := -int64(value)
+
+	if value > max || (incr > 0 && incr > maxincr) {
+		if owtype == OverflowSat {
+			return max, 1
+		}
+		return wrapUnsigned(value, incr, bits), 1
+	} else if incr < 0 && incr < minincr {
+		if owtype == OverflowSat {
+			return 0, -1
+		}
+		return wrapUnsigned(value, incr, bits), -1
+	}
+	return 0, 0
+}
+
+func wrapUnsigned(value uint64, incr int64, bits int) uint64 {
+	mask := ^uint64(0) << uint(bits)
+	res := value + uint64(incr)
+	res &^= mask
+	return res
+}
+
+// CheckSignedOverflow Like CheckUnsignedOverflow for signed integers.
+func CheckSignedOverflow(value int64, incr int64, bits int, owtype int) (limit int64, overflow int) {
+	max := int64(1<<63 - 1)
+	if bits != 64 {
+		max = (1 << uint(bits-1)) - 1
+	}
+	min := -max - 1
+
+	// Note that maxincr and minincr could overflow, but we use the values
+	// only after checking 'value' range, so when we use it no overflow
+	// happens.
+	maxincr := max - value
+	minincr := min - value
+
+	if value > max || (bits != 64 && incr > maxincr) || (value >= 0 && incr > 0 && incr > maxincr) {
+		if owtype == OverflowSat {
+			return max, 1
+		}
+		return wrapSigned(value, incr, bits), 1
+	} else if value < min || (bits != 64 && incr < minincr) || (value < 0 && incr < 0 && incr < minincr) {
+		if owtype == OverflowSat {
+			return min, -1
+		}
+		return wrapSigned(value, incr, bits), -1
+	}
+	return 0, 0
+}
+
+func wrapSigned(value int64, incr int64, bits int) int64 {
+	msb := uint64(1) << uint(bits-1)
+	c := uint64(value) + uint64(incr)
+	// Sign extend the result if it is negative, otherwise clear the
+	// bits greater than 'bits'.
+	if bits < 64 {
+		mask := ^uint64(0) << uint(bits)
+		if c&msb != 0 {
+			c |= mask
+		} else {
+			c &^= mask
+		}
+	}
+	return int64(c)
+}
+
+// ParseType Parse the type of a BITFIELD field, "i<bits>" for a signed
+// integer of 1 to 64 bits, or "u<bits>" for an unsigned integer of 1 to
+// 63 bits.
+func ParseType(arg []byte) (signed bool, bits int, err error) {
+	if len(arg) < 2 {
+		return false, 0, ErrBitfieldType
+	}
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, ErrBitfieldType
+	}
+
+	n, ok := util.String2ll(arg[1:])
+	if !ok || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return false, 0, ErrBitfieldType
+	}
+	return signed, int(n), nil
+}
+
+// ParseBitfield Parse the arguments of BITFIELD after the key, or of
+// BITFIELD_RO if 'readonly' is set, that only accepts GET.
+func ParseBitfield(args [][]byte, readonly bool) ([]FieldOp, error) {
+	var ops []FieldOp
+	owtype := OverflowWrap
+
+	for j := 0; j < len(args); j++ {
+		remargs := len(args) - j - 1
+		subcmd := args[j]
+
+		var op int
+		switch {
+		case bytes.EqualFold(subcmd, []byte("get")) && remargs >= 2:
+			op = FieldGet
+		case bytes.EqualFold(subcmd, []byte("set")) && remargs >= 3:
+			op = FieldSet
+		case bytes.EqualFold(subcmd, []byte("incrby")) && remargs >= 3:
+			op = FieldIncrBy
+		case bytes.EqualFold(subcmd, []byte("overflow")) && remargs >= 1:
+			j++
+			switch {
+			case bytes.EqualFold(args[j], []byte("wrap")):
+				owtype = OverflowWrap
+			case bytes.EqualFold(args[j], []byte("sat")):
+				owtype = OverflowSat
+			case bytes.EqualFold(args[j], []byte("fail")):
+				owtype = OverflowFail
+			default:
+				return nil, ErrOverflowType
+			}
+			continue
+		default:
+			return nil, ErrSyntax
+		}
+
+		// Get the type and offset arguments, common to all the ops.
+		signed, bits, err := ParseType(args[j+1])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := ParseOffset(args[j+2], true, bits)
+		if err != nil {
+			return nil, err
+		}
+		// The field must end inside the max length.
+		if (offset+uint64(bits)-1)>>3 >= MaxLen {
+			return nil, ErrBitOffset
+		}
+		j += 2
+
+		var value int64
+		if op != FieldGet {
+			if readonly {
+				return nil, ErrReadOnly
+			}
+			// Get the value or increment.
+			j++
+			var ok bool
+			if value, ok = util.String2ll(args[j]); !ok {
+				return nil, ErrNotInteger
+			}
+		}
+
+		ops = append(ops, FieldOp{
+			Op:       op,
+			Signed:   signed,
+			Bits:     bits,
+			Offset:   offset,
+			Value:    value,
+			Overflow: owtype,
+		})
+	}
+	return ops, nil
+}
+
+// Bitfield Execute the BITFIELD subcommands on the string. The string is
+// grown as needed by the SET and INCRBY subcommands, 's' may be nil only
+// if all the subcommands are GET.
+//
+// Returns a reply for every subcommand: the value of GET, the previous
+// value for SET, the new value for INCRBY. The reply is nil when the
+// subcommand failed because of an overflow with OverflowFail.
+func Bitfield(s *sds.SDS, ops []FieldOp) []*int64 {
+	// Grow the string once for all the write subcommands.
+	var maxbit uint64
+	writes := false
+	for i := range ops {
+		if ops[i].Op == FieldGet {
+			continue
+		}
+		writes = true
+		if bit := ops[i].Offset + uint64(ops[i].Bits) - 1; bit > maxbit {
+			maxbit = bit
+		}
+	}
+	if writes {
+		growFor(s, maxbit)
+	}
+
+	var p []byte
+	if s != nil {
+		p = s.Bytes()
+	}
+
+	replies := make([]*int64, len(ops))
+	for i := range ops {
+		op := &ops[i]
+		var retval int64
+		overflow := 0
+
+		switch {
+		case op.Op == FieldGet && op.Signed:
+			retval = Signed(p, op.Offset, op.Bits)
+		case op.Op == FieldGet:
+			retval = int64(Unsigned(p, op.Offset, op.Bits))
+		case op.Signed:
+			oldval := Signed(p, op.Offset, op.Bits)
+			var newval, wrapped int64
+			if op.Op == FieldIncrBy {
+				wrapped, overflow = CheckSignedOverflow(oldval, op.Value, op.Bits, op.Overflow)
+				newval = oldval + op.Value
+				if overflow != 0 {
+					newval = wrapped
+				}
+				retval = newval
+			} else {
+				newval = op.Value
+				wrapped, overflow = CheckSignedOverflow(newval, 0, op.Bits, op.Overflow)
+				if overflow != 0 {
+					newval = wrapped
+				}
+				retval = oldval
+			}
+			// On overflow of type "FAIL", don't write and return nil
+			// to signal the condition.
+			if overflow == 0 || op.Overflow != OverflowFail {
+				setUnsigned(p, op.Offset, op.Bits, uint64(newval))
+			}
+		default:
+			oldval := Unsigned(p, op.Offset, op.Bits)
+			var newval, wrapped uint64
+			if op.Op == FieldIncrBy {
+				newval = oldval + uint64(op.Value)
+				wrapped, overflow = CheckUnsignedOverflow(oldval, op.Value, op.Bits, op.Overflow)
+				if overflow != 0 {
+					newval = wrapped
+				}
+				retval = int64(newval)
+			} else {
+				newval = uint64(op.Value)
+				wrapped, overflow = CheckUnsignedOverflow(newval, 0, op.Bits, op.Overflow)
+				if overflow != 0 {
+					newval = wrapped
+				}
+				retval = int64(oldval)
+			}
+			if overflow == 0 || op.Overflow != OverflowFail {
+				setUnsigned(p, op.Offset, op.Bits, newval)
+			}
+		}
+
+		if overflow != 0 && op.Overflow == OverflowFail {
+			continue
+		}
+		v := retval
+		replies[i] = &v
+	}
+	return replies
+}
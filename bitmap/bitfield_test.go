@@ -0,0 +1,168 @@
+package bitmap
+
+import (
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"sds"
+)
+
+func args(s string) [][]byte {
+	var a [][]byte
+	for _, f := range strings.Fields(s) {
+		a = append(a, []byte(f))
+	}
+	return a
+}
+
+// bitfield Parse and execute the BITFIELD arguments.
+func bitfield(t *testing.T, s *sds.SDS, cmd string) []*int64 {
+	t.Helper()
+	ops, err := ParseBitfield(args(cmd), false)
+	if err != nil {
+		t.Fatalf("ParseBitfield(%q) %v", cmd, err)
+	}
+	return Bitfield(s, ops)
+}
+
+func checkReplies(t *testing.T, got []*int64, want ...interface{}) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d replies, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if want[i] == nil {
+			if got[i] != nil {
+				t.Fatalf("reply %d = %d, want nil", i, *got[i])
+			}
+			continue
+		}
+		if got[i] == nil || *got[i] != int64(want[i].(int)) {
+			t.Fatalf("reply %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	s := sds.Empty()
+	checkReplies(t, bitfield(t, s, "INCRBY i5 100 1 GET u4 0"), 1, 0)
+	checkReplies(t, bitfield(t, s, "set u8 0 255 get u8 0"), 0, 255)
+	checkReplies(t, bitfield(t, s, "set i8 0 -100 get i8 0 get u8 0"), -1, -100, 156)
+	checkReplies(t, bitfield(t, s, "set u8 #1 200 get u8 8 get i8 #1"), 0, 200, -56)
+
+	s = sds.Empty()
+	checkReplies(t, bitfield(t, s, "incrby u2 100 1 OVERFLOW SAT incrby u2 102 1"), 1, 1)
+	checkReplies(t, bitfield(t, s, "incrby u2 100 1 OVERFLOW SAT incrby u2 102 1"), 2, 2)
+	checkReplies(t, bitfield(t, s, "incrby u2 100 1 OVERFLOW SAT incrby u2 102 1"), 3, 3)
+	checkReplies(t, bitfield(t, s, "incrby u2 100 1 OVERFLOW SAT incrby u2 102 1"), 0, 3)
+	checkReplies(t, bitfield(t, s, "OVERFLOW FAIL incrby u2 102 1 get u2 102"), nil, 3)
+
+	s = sds.Empty()
+	checkReplies(t, bitfield(t, s, "set i64 0 -9223372036854775808 incrby i64 0 -1"), 0, 9223372036854775807)
+	checkReplies(t, bitfield(t, s, "overflow sat incrby i64 0 1"), 9223372036854775807)
+	checkReplies(t, bitfield(t, s, "set u63 0 -1 get u63 0"), 9223372036854775807>>1, 9223372036854775807)
+	checkReplies(t, bitfield(t, s, "overflow fail set i8 0 128 set i8 0 127"), nil, -1)
+	checkReplies(t, bitfield(t, s, "overflow sat set i8 0 128 set i8 0 -129 get i8 0"), 127, 127, -128)
+
+	// GET only on a missing key.
+	ops, _ := ParseBitfield(args("get u8 0 get i4 100"), true)
+	checkReplies(t, Bitfield(nil, ops), 0, 0)
+
+	for _, test := range []struct {
+		cmd      string
+		readonly bool
+		err      error
+	}{
+		{"get u64 0", false, ErrBitfieldType},
+		{"get i65 0", false, ErrBitfieldType},
+		{"get i0 0", false, ErrBitfieldType},
+		{"get x8 0", false, ErrBitfieldType},
+		{"get u8 -1", false, ErrBitOffset},
+		{"get u8 4294967289", false, ErrBitOffset},
+		{"set u8 0 x", false, ErrNotInteger},
+		{"overflow maybe", false, ErrOverflowType},
+		{"get u8", false, ErrSyntax},
+		{"foo u8 0", false, ErrSyntax},
+		{"set u8 0 1", true, ErrReadOnly},
+	} {
+		if _, err := ParseBitfield(args(test.cmd), test.readonly); err != test.err {
+			t.Fatalf("ParseBitfield(%q) = %v, want %v", test.cmd, err, test.err)
+		}
+	}
+}
+
+// reference Compute the result of the increment with big integers.
+func reference(value, incr int64, signed bool, bits int, owtype int) (res int64, overflow bool) {
+	min, max := big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	if signed {
+		min.Neg(new(big.Int).Lsh(big.NewInt(1), uint(bits-1)))
+		max.Lsh(big.NewInt(1), uint(bits-1))
+	}
+	max.Sub(max, big.NewInt(1))
+
+	v := big.NewInt(value)
+	if !signed {
+		v.SetUint64(uint64(value))
+	}
+	sum := new(big.Int).Add(v, big.NewInt(incr))
+	if sum.Cmp(min) >= 0 && sum.Cmp(max) <= 0 {
+		if signed {
+			return sum.Int64(), false
+		}
+		return int64(sum.Uint64()), false
+	}
+	if owtype == OverflowSat {
+		if sum.Cmp(min) < 0 {
+			return min.Int64(), true
+		}
+		if signed {
+			return max.Int64(), true
+		}
+		return int64(max.Uint64()), true
+	}
+
+	// Wrap: modulo 2^bits.
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	sum.Mod(sum, mod)
+	if signed && sum.Cmp(max) > 0 {
+		sum.Sub(sum, mod)
+	}
+	if signed {
+		return sum.Int64(), true
+	}
+	return int64(sum.Uint64()), true
+}
+
+func TestBitfieldOverflow(t *testing.T) {
+	for i := 0; i < 10000; i++ {
+		signed := rand.Intn(2) == 0
+		bits := 1 + rand.Intn(63)
+		if signed {
+			bits = 1 + rand.Intn(64)
+		}
+		owtype := rand.Intn(2)
+
+		s := sds.Empty()
+		// A random value of the field.
+		value := int64(rand.Uint64() >> uint(64-bits))
+		if signed && bits < 64 {
+			value -= 1 << uint(bits-1)
+		}
+		Bitfield(s, []FieldOp{{Op: FieldSet, Signed: signed, Bits: bits, Offset: 3, Value: value}})
+
+		incr := int64(rand.Uint64())
+		if rand.Intn(2) == 0 {
+			incr >>= uint(rand.Intn(64))
+		}
+		want, _ := reference(value, incr, signed, bits, owtype)
+		got := Bitfield(s, []FieldOp{
+			{Op: FieldIncrBy, Signed: signed, Bits: bits, Offset: 3, Value: incr, Overflow: owtype},
+			{Op: FieldGet, Signed: signed, Bits: bits, Offset: 3},
+		})
+		if *got[0] != want || *got[1] != want {
+			t.Fatalf("signed %v bits %d %d incrby %d overflow %d = %d, want %d", signed, bits, value, incr, owtype, *got[0], want)
+		}
+	}
+}
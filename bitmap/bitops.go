@@ -0,0 +1,440 @@
+package bitmap
+
+// Bit operations on strings, a port of bitops.c of redis.
+//
+// A bitmap is just a sds string: the bit 0 is the most significant bit of
+// the first byte, the bit 8 the most significant bit of the second byte,
+// and so forth. The string is grown as needed when a bit is set, the bits
+// after the end of the string are considered to be zero.
+//
+// As bitmaps are plain sds strings, they can be stored in a dict.Dict
+// with sds.Value.
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	"sds"
+	"util"
+)
+
+// Error
+var (
+	// ErrBitOffset the offset is not a valid bit offset.
+	ErrBitOffset = errors.New("bit offset is not an integer or out of range")
+	// ErrBitValue the value is not 0 or 1.
+	ErrBitValue = errors.New("bit is not an integer or out of range")
+	// ErrBitopNot NOT with more than one source.
+	ErrBitopNot = errors.New("BITOP NOT must be called with a single source key.")
+)
+
+// MaxLen The max length in bytes of a bitmap, similar to
+// proto-max-bulk-len of redis.
+const MaxLen = 512 * 1024 * 1024
+
+// ParseOffset Parse the offset argument of SETBIT, GETBIT and BITFIELD.
+//
+// If 'hash' is true, the offset can be given as "#<n>": it is multiplied
+// by 'bits', so that "#2" of an i8 field is the third 8 bits field of the
+// bitmap.
+func ParseOffset(arg []byte, hash bool, bits int) (uint64, error) {
+	usehash := false
+	if hash && len(arg) > 1 && arg[0] == '#' {
+		usehash = true
+		arg = arg[1:]
+	}
+
+	loffset, ok := util.String2ll(arg)
+	if !ok {
+		return 0, ErrBitOffset
+	}
+
+	// Adjust the offset by 'bits' for #<offset> form.
+	if usehash {
+		if loffset > (1<<63-1)/int64(bits) {
+			return 0, ErrBitOffset
+		}
+		loffset *= int64(bits)
+	}
+
+	// Limit offset to MaxLen (512MB in bytes by default).
+	if loffset < 0 || loffset>>3 >= MaxLen {
+		return 0, ErrBitOffset
+	}
+	return uint64(loffset), nil
+}
+
+// growFor Grow the string so that the bit at 'maxbit' is addressable.
+func growFor(s *sds.SDS, maxbit uint64) {
+	s.GrowZero(int(maxbit>>3) + 1)
+}
+
+// SetBit Set the bit at 'offset' to 'on', like SETBIT, growing the
+// string if needed. Returns the previous value of the bit.
+func SetBit(s *sds.SDS, offset uint64, on bool) (int, error) {
+	if offset>>3 >= MaxLen {
+		return 0, ErrBitOffset
+	}
+	growFor(s, offset)
+
+	// Get current values
+	p := s.Bytes()
+	byte := offset >> 3
+	bit := 7 - uint(offset&0x7)
+	bitval := int(p[byte]>>bit) & 1
+
+	// Update bit
+	p[byte] &^= 1 << bit
+	if on {
+		p[byte] |= 1 << bit
+	}
+	return bitval, nil
+}
+
+// GetBit Return the bit at 'offset', like GETBIT. 's' may be nil, the
+// empty string.
+func GetBit(s *sds.SDS, offset uint64) int {
+	if s == nil {
+		return 0
+	}
+	p := s.Bytes()
+	byte := offset >> 3
+	if byte >= uint64(len(p)) {
+		return 0
+	}
+	bit := 7 - uint(offset&0x7)
+	return int(p[byte]>>bit) & 1
+}
+
+// popcount Count number of bits set in the binary array 'p'.
+func popcount(p []byte) int64 {
+	var count int
+	// Count bits 64 bit at a time.
+	for len(p) >= 8 {
+		count += bits.OnesCount64(binary.LittleEndian.Uint64(p))
+		p = p[8:]
+	}
+	// Count the remaining bytes.
+	for _, c := range p {
+		count += bits.OnesCount8(c)
+	}
+	return int64(count)
+}
+
+// bitpos Return the position of the first bit set to one (if 'bit' is 1)
+// or zero (if 'bit' is 0) in the bitmap 'p'.
+//
+// The function is guaranteed to return a value >= 0 if 'bit' is 0 since
+// if no zero bit is found, it returns len(p)*8 assuming the string is
+// zero padded on the right. However if 'bit' is 1 it is possible that
+// there is not a single set bit in the bitmap. In this special case -1 is
+// returned.
+func bitpos(p []byte, bit int) int64 {
+	var skipval byte
+	if bit == 0 {
+		skipval = 0xff
+	}
+
+	// Skip the bytes that can't contain the bit.
+	pos := int64(0)
+	for _, c := range p {
+		if c != skipval {
+			if bit == 1 {
+				return pos + int64(bits.LeadingZeros8(c))
+			}
+			return pos + int64(bits.LeadingZeros8(^c))
+		}
+		pos += 8
+	}
+
+	// If we reached this point, there is no bit set to 'bit': the string
+	// is zero padded on the right, so a zero bit is found just after the
+	// end, while no set bit exists.
+	if bit == 1 {
+		return -1
+	}
+	return pos
+}
+
+// Range The range of BITCOUNT and BITPOS. Negative offsets count from the
+// end of the string, -1 being the last byte (or bit).
+type Range struct {
+	Start, End int64
+	// Bit Start and End are bit offsets instead of byte offsets.
+	Bit bool
+	// NoEnd only Start was given, End is the end of the string.
+	NoEnd bool
+}
+
+// normalize Convert the range into positive offsets of a string of
+// 'strlen' bytes. When the range is a bit range, the offsets are
+// converted into a byte range and the masks of the bits of the first and
+// the last bytes outside the range are returned. Returns ok false if the
+// range is empty.
+func (r *Range) normalize(strlen int64) (start, end int64, firstMask, lastMask byte, ok bool) {
+	totlen := strlen
+	if r.Bit {
+		totlen <<= 3
+	}
+
+	start, end = r.Start, totlen-1
+	if !r.NoEnd {
+		end = r.End
+	}
+
+	// Convert negative indexes
+	if start < 0 {
+		start = totlen + start
+	}
+	if end < 0 {
+		end = totlen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= totlen {
+		end = totlen - 1
+	}
+
+	// For empty ranges (start > end) we return -1 as an empty range does
+	// not contain a 0 nor a 1.
+	if start > end {
+		return 0, 0, 0, 0, false
+	}
+
+	if r.Bit {
+		firstMask = ^byte((1 << (8 - uint(start&7))) - 1)
+		lastMask = byte((1 << (7 - uint(end&7))) - 1)
+		start >>= 3
+		end >>= 3
+	}
+	return start, end, firstMask, lastMask, true
+}
+
+// Count Return the number of bits set in the range of the string, like
+// BITCOUNT. A nil range is the whole string, as is a nil 's' the empty
+// string.
+func Count(s *sds.SDS, r *Range) int64 {
+	if s == nil {
+		return 0
+	}
+	if r == nil {
+		r = &Range{End: -1}
+	}
+	p := s.Bytes()
+
+	start, end, firstMask, lastMask, ok := r.normalize(int64(len(p)))
+	if !ok {
+		return 0
+	}
+
+	// Precondition: end >= 0 && end < strlen, so the only condition
+	// where zero can be returned is: start > end.
+	count := popcount(p[start : end+1])
+	if firstMask != 0 {
+		count -= int64(bits.OnesCount8(p[start] & firstMask))
+	}
+	if lastMask != 0 {
+		count -= int64(bits.OnesCount8(p[end] & lastMask))
+	}
+	return count
+}
+
+// Pos Return the position of the first bit set to 'bit' in the range of
+// the string, like BITPOS. A nil range is the whole string, as is a nil
+// 's' the empty string.
+//
+// If looking for clear bits and the range has no end, the string is
+// considered zero padded on the right, so the position of the first bit
+// after the string is returned when all the bits are set. Otherwise -1
+// is returned when no bit is found.
+func Pos(s *sds.SDS, bit int, r *Range) (int64, error) {
+	if bit != 0 && bit != 1 {
+		return 0, ErrBitValue
+	}
+
+	// If the key does not exist, from our point of view it is an
+	// infinite array of 0 bits. If the user is looking for the first
+	// clear bit return 0, If the user is looking for the first set bit,
+	// return -1.
+	if s == nil {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	if r == nil {
+		r = &Range{NoEnd: true}
+	}
+	p := s.Bytes()
+
+	start, end, firstMask, lastMask, ok := r.normalize(int64(len(p)))
+	if !ok {
+		return -1, nil
+	}
+
+	bytes := end - start + 1
+	var pos int64
+	var tmpchar byte
+
+	// maskByte Return the byte with the bits outside the range set to
+	// the opposite of 'bit', so that they are skipped.
+	maskByte := func(c, mask byte) byte {
+		if bit == 1 {
+			return c &^ mask
+		}
+		return c | mask
+	}
+
+	// Check the first byte alone if it is partially in the range.
+	if firstMask != 0 {
+		tmpchar = maskByte(p[start], firstMask)
+		// Special case, there is only one byte.
+		if lastMask != 0 && bytes == 1 {
+			tmpchar = maskByte(tmpchar, lastMask)
+		}
+		pos = bitpos([]byte{tmpchar}, bit)
+		// If there are no more bytes or we get valid pos, we can exit
+		// early.
+		if bytes == 1 || (pos != -1 && pos != 8) {
+			goto result
+		}
+		start++
+		bytes--
+	}
+
+	// If the last byte has not bits in the range, we should exclude it.
+	{
+		curbytes := bytes
+		if lastMask != 0 {
+			curbytes--
+		}
+		if curbytes > 0 {
+			pos = bitpos(p[start:start+curbytes], bit)
+			// If there is no more bytes or we get valid pos, we can
+			// exit early.
+			if bytes == curbytes || (pos != -1 && pos != curbytes<<3) {
+				goto result
+			}
+			start += curbytes
+			bytes -= curbytes
+		}
+		tmpchar = maskByte(p[end], lastMask)
+		pos = bitpos([]byte{tmpchar}, bit)
+	}
+
+result:
+	// If we are looking for clear bits, and the user specified an exact
+	// range with start-end, we can't consider the right of the range as
+	// zero padded (as we do when no explicit end is given).
+	//
+	// So if bitpos() returns the first bit outside the range, we return
+	// -1 to the caller, to mean, in the specified range there is not a
+	// single "0" bit.
+	if !r.NoEnd && bit == 0 && pos == bytes<<3 {
+		return -1, nil
+	}
+	if pos != -1 {
+		// Adjust for the bytes we skipped.
+		pos += start << 3
+	}
+	return pos, nil
+}
+
+// BITOP operations.
+const (
+	OpAnd = iota
+	OpOr
+	OpXor
+	OpNot
+)
+
+// Op Perform the bit operation 'op' between the strings, like BITOP, and
+// return the result, as long as the longest string. The shorter strings
+// are considered zero padded up to the length of the longest one. A nil
+// string is the empty string.
+func Op(op int, srcs ...*sds.SDS) (*sds.SDS, error) {
+	// Sanity check: NOT accepts only a single key argument.
+	if op == OpNot && len(srcs) != 1 {
+		return nil, ErrBitopNot
+	}
+
+	// Lookup keys, and store pointers to the string objects into an
+	// array.
+	maxlen := 0
+	src := make([][]byte, len(srcs))
+	for i, s := range srcs {
+		if s != nil {
+			src[i] = s.Bytes()
+		}
+		if len(src[i]) > maxlen {
+			maxlen = len(src[i])
+		}
+	}
+
+	res := sds.NewLen(nil, maxlen)
+	dst := res.Bytes()
+	if maxlen == 0 {
+		return res, nil
+	}
+
+	// Fast path: as far as we have data for all the input bitmaps we can
+	// take a fast path that performs much better than the vanilla
+	// algorithm, processing 8 bytes at a time.
+	minlen := maxlen
+	for _, p := range src {
+		if len(p) < minlen {
+			minlen = len(p)
+		}
+	}
+	j := 0
+	for ; j+8 <= minlen; j += 8 {
+		output := binary.LittleEndian.Uint64(src[0][j:])
+		if op == OpNot {
+			output = ^output
+		}
+		for _, p := range src[1:] {
+			w := binary.LittleEndian.Uint64(p[j:])
+			switch op {
+			case OpAnd:
+				output &= w
+			case OpOr:
+				output |= w
+			case OpXor:
+				output ^= w
+			}
+		}
+		binary.LittleEndian.PutUint64(dst[j:], output)
+	}
+
+	// j is set to the next byte to process by the previous loop.
+	for ; j < maxlen; j++ {
+		var output byte
+		if j < len(src[0]) {
+			output = src[0][j]
+		}
+		if op == OpNot {
+			output = ^output
+		}
+		for _, p := range src[1:] {
+			var b byte
+			if j < len(p) {
+				b = p[j]
+			}
+			switch op {
+			case OpAnd:
+				output &= b
+			case OpOr:
+				output |= b
+			case OpXor:
+				output ^= b
+			}
+		}
+		dst[j] = output
+	}
+	return res, nil
+}
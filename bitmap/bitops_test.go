@@ -0,0 +1,221 @@
+package bitmap
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"sds"
+)
+
+func TestSetGetBit(t *testing.T) {
+	s := sds.Empty()
+	if old, err := SetBit(s, 7, true); old != 0 || err != nil {
+		t.Fatalf("SetBit %d %v", old, err)
+	}
+	if s.Len() != 1 || s.Bytes()[0] != 0x01 {
+		t.Fatalf("bitmap %q", s.Bytes())
+	}
+	// The string grows as needed.
+	SetBit(s, 100, true)
+	if s.Len() != 13 {
+		t.Fatalf("len %d", s.Len())
+	}
+	if old, _ := SetBit(s, 100, false); old != 1 {
+		t.Fatalf("old %d", old)
+	}
+	if GetBit(s, 7) != 1 || GetBit(s, 6) != 0 || GetBit(s, 100) != 0 || GetBit(s, 1<<20) != 0 {
+		t.Fatal("GetBit")
+	}
+	if GetBit(nil, 1) != 0 {
+		t.Fatal("GetBit nil")
+	}
+	if _, err := SetBit(s, MaxLen*8, true); err != ErrBitOffset {
+		t.Fatalf("SetBit out of range %v", err)
+	}
+
+	for _, test := range []struct {
+		arg    string
+		hash   bool
+		bits   int
+		offset uint64
+		err    bool
+	}{
+		{"0", false, 1, 0, false},
+		{"4294967295", false, 1, 4294967295, false},
+		{"4294967296", false, 1, 0, true},
+		{"-1", false, 1, 0, true},
+		{"#3", true, 8, 24, false},
+		{"#3", false, 8, 0, true},
+		{"x", false, 1, 0, true},
+	} {
+		offset, err := ParseOffset([]byte(test.arg), test.hash, test.bits)
+		if (err != nil) != test.err || offset != test.offset {
+			t.Fatalf("ParseOffset(%q) = %d %v", test.arg, offset, err)
+		}
+	}
+}
+
+// naiveCount Count the bits set between the bits start and end.
+func naiveCount(p []byte, start, end int64) int64 {
+	var count int64
+	for i := start; i <= end; i++ {
+		if p[i>>3]&(0x80>>uint(i&7)) != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCount(t *testing.T) {
+	s := sds.NewString("foobar")
+	for _, test := range []struct {
+		r     *Range
+		count int64
+	}{
+		{nil, 26},
+		{&Range{Start: 0, End: 0}, 4},
+		{&Range{Start: 1, End: 1}, 6},
+		{&Range{Start: 1, End: 1, Bit: true}, 1},
+		{&Range{Start: 5, End: 30, Bit: true}, 17},
+		{&Range{Start: -2, End: -1}, 7},
+		{&Range{Start: 2, End: 1}, 0},
+		{&Range{Start: -100, End: 100}, 26},
+	} {
+		if count := Count(s, test.r); count != test.count {
+			t.Fatalf("Count(%+v) = %d, want %d", test.r, count, test.count)
+		}
+	}
+	if Count(nil, nil) != 0 || Count(sds.Empty(), nil) != 0 {
+		t.Fatal("Count of empty string")
+	}
+
+	for i := 0; i < 1000; i++ {
+		p := make([]byte, 1+rand.Intn(40))
+		rand.Read(p)
+		bits := int64(len(p) * 8)
+		start, end := rand.Int63n(bits), rand.Int63n(bits)
+		want := int64(0)
+		if start <= end {
+			want = naiveCount(p, start, end)
+		}
+		if count := Count(sds.New(p), &Range{Start: start, End: end, Bit: true}); count != want {
+			t.Fatalf("Count(%x, %d, %d) = %d, want %d", p, start, end, count, want)
+		}
+	}
+}
+
+func TestPos(t *testing.T) {
+	for _, test := range []struct {
+		s   string
+		bit int
+		r   *Range
+		pos int64
+	}{
+		{"\xff\xf0\x00", 0, nil, 12},
+		{"\x00\xff\xf0", 1, &Range{Start: 0, NoEnd: true}, 8},
+		{"\x00\xff\xf0", 1, &Range{Start: 2, NoEnd: true}, 16},
+		{"\x00\xff\xf0", 1, &Range{Start: 2, End: -1}, 16},
+		{"\x00\xff\xf0", 1, &Range{Start: 7, End: 15, Bit: true}, 8},
+		{"\x00\x00\x00", 1, nil, -1},
+		{"\xff\xff\xff", 0, nil, 24},
+		{"\xff\xff\xff", 0, &Range{Start: 0, End: -1}, -1},
+		{"\xff\xff\xff", 0, &Range{Start: 1, NoEnd: true}, 24},
+		{"\xff\x7f\xff", 0, &Range{Start: 9, End: 23, Bit: true}, -1},
+		{"\xff\x7f\xff", 0, &Range{Start: 8, End: 23, Bit: true}, 8},
+		{"\x00\x01\x00", 1, &Range{Start: 3, End: 14, Bit: true}, -1},
+		{"\x00\x01\x00", 1, &Range{Start: 3, End: 15, Bit: true}, 15},
+		{"\x00\x01\x00", 1, &Range{Start: 2, End: 1}, -1},
+	} {
+		pos, err := Pos(sds.NewString(test.s), test.bit, test.r)
+		if err != nil || pos != test.pos {
+			t.Fatalf("Pos(%x, %d, %+v) = %d %v, want %d", test.s, test.bit, test.r, pos, err, test.pos)
+		}
+	}
+
+	if pos, _ := Pos(nil, 1, nil); pos != -1 {
+		t.Fatalf("Pos nil %d", pos)
+	}
+	if pos, _ := Pos(nil, 0, nil); pos != 0 {
+		t.Fatalf("Pos nil %d", pos)
+	}
+	if _, err := Pos(nil, 2, nil); err != ErrBitValue {
+		t.Fatalf("Pos bit 2 %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		p := make([]byte, 1+rand.Intn(20))
+		for j := range p {
+			p[j] = []byte{0, 0xff, byte(rand.Intn(256))}[rand.Intn(3)]
+		}
+		bits := int64(len(p) * 8)
+		start, end := rand.Int63n(bits), rand.Int63n(bits)
+		bit := rand.Intn(2)
+		want := int64(-1)
+		for j := start; j <= end; j++ {
+			if int(p[j>>3]>>(7-uint(j&7)))&1 == bit {
+				want = j
+				break
+			}
+		}
+		pos, _ := Pos(sds.New(p), bit, &Range{Start: start, End: end, Bit: true})
+		if pos != want {
+			t.Fatalf("Pos(%x, %d, %d, %d) = %d, want %d", p, bit, start, end, pos, want)
+		}
+	}
+}
+
+func TestOp(t *testing.T) {
+	if _, err := Op(OpNot, sds.NewString("a"), sds.NewString("b")); err != ErrBitopNot {
+		t.Fatalf("NOT with two sources %v", err)
+	}
+	res, _ := Op(OpNot, sds.NewString("\x00\xff"))
+	if !bytes.Equal(res.Bytes(), []byte("\xff\x00")) {
+		t.Fatalf("NOT %x", res.Bytes())
+	}
+	res, _ = Op(OpAnd, nil, nil)
+	if res.Len() != 0 {
+		t.Fatalf("AND of empty strings %x", res.Bytes())
+	}
+
+	for i := 0; i < 200; i++ {
+		op := rand.Intn(3)
+		n := 1 + rand.Intn(4)
+		srcs := make([]*sds.SDS, n)
+		maxlen := 0
+		for j := range srcs {
+			p := make([]byte, rand.Intn(30))
+			rand.Read(p)
+			srcs[j] = sds.New(p)
+			if len(p) > maxlen {
+				maxlen = len(p)
+			}
+		}
+
+		want := make([]byte, maxlen)
+		for k := 0; k < maxlen; k++ {
+			get := func(j int) byte {
+				if k < srcs[j].Len() {
+					return srcs[j].Bytes()[k]
+				}
+				return 0
+			}
+			want[k] = get(0)
+			for j := 1; j < n; j++ {
+				switch op {
+				case OpAnd:
+					want[k] &= get(j)
+				case OpOr:
+					want[k] |= get(j)
+				case OpXor:
+					want[k] ^= get(j)
+				}
+			}
+		}
+
+		res, err := Op(op, srcs...)
+		if err != nil || !bytes.Equal(res.Bytes(), want) {
+			t.Fatalf("Op %d = %x, want %x", op, res.Bytes(), want)
+		}
+	}
+}
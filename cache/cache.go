@@ -0,0 +1,349 @@
+package cache
+
+// A LRU cache, composed from a dict.Dict to find the entries by key, and
+// an adlist.List keeping the entries from the most to the least recently
+// used. The cache is not safe for concurrent use.
+
+import (
+	"time"
+
+	"adlist"
+	"dict"
+)
+
+// Reason why an entry left the cache.
+type Reason int
+
+const (
+	// ReasonEvicted the entry was evicted to make room for new entries.
+	ReasonEvicted Reason = iota
+	// ReasonExpired the TTL of the entry expired.
+	ReasonExpired
+	// ReasonDeleted the entry was deleted with Delete or Empty.
+	ReasonDeleted
+)
+
+// Stats hit/miss statistics of the cache.
+type Stats struct {
+	// Hits number of Get that found the key.
+	Hits uint64
+	// Misses number of Get that didn't find the key, or found it expired.
+	Misses uint64
+	// Evictions number of entries evicted to make room for new entries.
+	Evictions uint64
+	// Expirations number of entries removed because their TTL expired.
+	Expirations uint64
+}
+
+// item the entry of the cache, shared by the dict (as value) and the list.
+type item struct {
+	// the key owned by the dict.
+	key   dict.Key
+	value dict.Value
+	size  int64
+	// zero when the entry has no TTL.
+	expire time.Time
+
+	node *adlist.Node
+}
+
+// Value implements adlist.Value.
+func (it *item) Value() {}
+
+// Dup implements dict.Value, the dict shares the item with the list.
+func (it *item) Dup() dict.Value {
+	return it
+}
+
+// Destructor implements dict.Value, the item is freed by the list.
+func (it *item) Destructor() {}
+
+// Cache LRU cache.
+type Cache struct {
+	dict *dict.Dict
+	// most recently used at head.
+	list *adlist.List
+
+	maxEntries int64
+	maxBytes   int64
+	bytes      int64
+	sizeof     func(key dict.Key, value dict.Value) int64
+
+	ttl     time.Duration
+	now     func() time.Time
+	onEvict func(key dict.Key, value dict.Value, reason Reason)
+
+	// why the entry being freed is removed.
+	reason Reason
+	stats  Stats
+}
+
+// Option opt.
+type Option func(c *Cache)
+
+// WithMaxEntries Limit the number of entries of the cache, 0 means no
+// limit.
+func WithMaxEntries(n int64) Option {
+	return func(c *Cache) {
+		c.maxEntries = n
+	}
+}
+
+// WithMaxBytes Limit the total size of the entries, as computed by
+// 'sizeof', 0 means no limit.
+func WithMaxBytes(n int64, sizeof func(key dict.Key, value dict.Value) int64) Option {
+	return func(c *Cache) {
+		c.maxBytes = n
+		c.sizeof = sizeof
+	}
+}
+
+// WithTTL The default TTL of the entries added with Set, 0 means the
+// entries never expire.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// WithEvict The 'onEvict' is called with every entry leaving the cache,
+// before the value Destructor.
+func WithEvict(onEvict func(key dict.Key, value dict.Value, reason Reason)) Option {
+	return func(c *Cache) {
+		c.onEvict = onEvict
+	}
+}
+
+// WithClock The 'now' is used to get the current time, time.Now by
+// default.
+func WithClock(now func() time.Time) Option {
+	return func(c *Cache) {
+		c.now = now
+	}
+}
+
+// Create a new cache.
+func Create(opts ...Option) *Cache {
+	c := &Cache{
+		dict: dict.Create(),
+		now:  time.Now,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	// Every entry leaving the cache goes through the free method of the
+	// list.
+	c.list = adlist.ListCreate(adlist.WithFree(c.free))
+	return c
+}
+
+// free Free the item removed from the list.
+func (c *Cache) free(ptr adlist.Value) {
+	it := ptr.(*item)
+
+	c.bytes -= it.size
+	switch c.reason {
+	case ReasonEvicted:
+		c.stats.Evictions++
+	case ReasonExpired:
+		c.stats.Expirations++
+	}
+
+	if c.onEvict != nil {
+		c.onEvict(it.key, it.value, c.reason)
+	}
+	it.value.Destructor()
+}
+
+// remove Remove the item from the list, and then from the dict.
+func (c *Cache) remove(it *item, reason Reason) {
+	c.reason = reason
+	c.list.DelNode(it.node)
+	c.dict.Delete(it.key)
+}
+
+func (c *Cache) expired(it *item) bool {
+	return !it.expire.IsZero() && !c.now().Before(it.expire)
+}
+
+// lookup Find the item of the key, removing it if expired.
+func (c *Cache) lookup(key dict.Key) *item {
+	value := c.dict.FetchValue(key)
+	if value == nil {
+		return nil
+	}
+
+	it := value.(*item)
+	if c.expired(it) {
+		c.remove(it, ReasonExpired)
+		return nil
+	}
+	return it
+}
+
+// Set Add or overwrite the key with the default TTL.
+func (c *Cache) Set(key dict.Key, value dict.Value) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL Add or overwrite the key, the entry expires after 'ttl', or
+// never if 'ttl' is 0. The value is duplicated like the dict does.
+// The entry becomes the most recently used one, and the least recently
+// used entries are evicted until the cache is back to its limits, which
+// may evict the new entry itself if it is larger than the whole cache.
+func (c *Cache) SetWithTTL(key dict.Key, value dict.Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = c.now().Add(ttl)
+	}
+
+	var size int64
+	if c.sizeof != nil {
+		size = c.sizeof(key, value)
+	}
+
+	if v := c.dict.FetchValue(key); v != nil {
+		// Set the new value and free the old one.
+		it := v.(*item)
+		old := it.value
+		it.value = value.Dup()
+		old.Destructor()
+
+		c.bytes += size - it.size
+		it.size = size
+		it.expire = expire
+
+		c.list.UnlinkNode(it.node)
+		c.list.LinkNodeHead(it.node)
+	} else {
+		it := &item{
+			value:  value.Dup(),
+			size:   size,
+			expire: expire,
+		}
+		c.dict.Add(key, it)
+		it.key = c.dict.Find(key).Key()
+
+		c.list.AddNodeHead(it)
+		it.node = c.list.First()
+		c.bytes += size
+	}
+
+	c.evict()
+}
+
+// evict Evict the least recently used entries until the cache is back to
+// its limits.
+func (c *Cache) evict() {
+	for c.list.Len() > 0 &&
+		((c.maxEntries > 0 && c.list.Len() > c.maxEntries) ||
+			(c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		it := c.list.Last().Value().(*item)
+		reason := ReasonEvicted
+		if c.expired(it) {
+			reason = ReasonExpired
+		}
+		c.remove(it, reason)
+	}
+}
+
+// Get Return the value of the key, nil if not found or expired.
+// The entry becomes the most recently used one.
+func (c *Cache) Get(key dict.Key) dict.Value {
+	it := c.lookup(key)
+	if it == nil {
+		c.stats.Misses++
+		return nil
+	}
+
+	c.stats.Hits++
+	c.list.UnlinkNode(it.node)
+	c.list.LinkNodeHead(it.node)
+	return it.value
+}
+
+// Peek Return the value of the key, nil if not found or expired.
+// Unlike Get, the recency of the entry and the statistics are not updated.
+func (c *Cache) Peek(key dict.Key) dict.Value {
+	it := c.lookup(key)
+	if it == nil {
+		return nil
+	}
+	return it.value
+}
+
+// TTL Return the remaining time to live of the key, 0 if the key never
+// expires. ok is false if the key is not found or expired.
+func (c *Cache) TTL(key dict.Key) (ttl time.Duration, ok bool) {
+	it := c.lookup(key)
+	if it == nil {
+		return 0, false
+	}
+	if it.expire.IsZero() {
+		return 0, true
+	}
+	return it.expire.Sub(c.now()), true
+}
+
+// Delete Remove the key from the cache. Returns false if the key was not
+// found.
+func (c *Cache) Delete(key dict.Key) bool {
+	value := c.dict.FetchValue(key)
+	if value == nil {
+		return false
+	}
+	c.remove(value.(*item), ReasonDeleted)
+	return true
+}
+
+// RemoveExpired Remove all the expired entries, returning how many were
+// removed.
+func (c *Cache) RemoveExpired() int {
+	removed := 0
+
+	iter := c.list.RewindTail()
+	for node := iter.Next(); node != nil; node = iter.Next() {
+		if it := node.Value().(*item); c.expired(it) {
+			c.remove(it, ReasonExpired)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len Return the number of entries, including the expired entries not
+// removed yet.
+func (c *Cache) Len() int64 {
+	return c.list.Len()
+}
+
+// Bytes Return the total size of the entries.
+func (c *Cache) Bytes() int64 {
+	return c.bytes
+}
+
+// Stats Return the hit/miss statistics.
+func (c *Cache) Stats() Stats {
+	return c.stats
+}
+
+// ResetStats Reset the hit/miss statistics.
+func (c *Cache) ResetStats() {
+	c.stats = Stats{}
+}
+
+// Empty Remove all the entries from the cache.
+func (c *Cache) Empty() {
+	for c.list.Len() > 0 {
+		c.remove(c.list.First().Value().(*item), ReasonDeleted)
+	}
+}
+
+// Release Remove all the entries and release the cache.
+func (c *Cache) Release() {
+	c.Empty()
+	c.list.Release()
+	c.dict.Close()
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"dict"
+)
+
+type keyT struct {
+	key uint64
+}
+
+func (k *keyT) HashFunction() uint64 {
+	return k.key
+}
+
+func (k *keyT) Compare(key dict.Key) int {
+	if k.key == key.(*keyT).key {
+		return 0
+	}
+	return 1
+}
+
+func (k *keyT) Dup() dict.Key {
+	return &keyT{
+		key: k.key,
+	}
+}
+
+func (k *keyT) Destructor() {}
+
+type valueT struct {
+	value     uint64
+	destroyed *int
+}
+
+func (v *valueT) Dup() dict.Value {
+	return &valueT{
+		value:     v.value,
+		destroyed: v.destroyed,
+	}
+}
+
+func (v *valueT) Destructor() {
+	if v.destroyed != nil {
+		*v.destroyed++
+	}
+}
+
+type evicted struct {
+	key    uint64
+	value  uint64
+	reason Reason
+}
+
+func TestCacheLRU(t *testing.T) {
+	var (
+		destroyed int
+		evictions []evicted
+	)
+
+	c := Create(
+		WithMaxEntries(3),
+		WithEvict(func(key dict.Key, value dict.Value, reason Reason) {
+			evictions = append(evictions, evicted{key.(*keyT).key, value.(*valueT).value, reason})
+		}),
+	)
+	for i := uint64(1); i <= 3; i++ {
+		c.Set(&keyT{key: i}, &valueT{value: i * 10, destroyed: &destroyed})
+	}
+
+	// Get makes 1 the most recently used, Peek doesn't change 2.
+	if c.Get(&keyT{key: 1}).(*valueT).value != 10 || c.Peek(&keyT{key: 2}).(*valueT).value != 20 {
+		t.FailNow()
+	}
+
+	c.Set(&keyT{key: 4}, &valueT{value: 40, destroyed: &destroyed})
+	if c.Len() != 3 || c.Peek(&keyT{key: 2}) != nil {
+		t.FailNow()
+	}
+	if len(evictions) != 1 || evictions[0] != (evicted{2, 20, ReasonEvicted}) || destroyed != 1 {
+		t.Fatalf("%v %d", evictions, destroyed)
+	}
+
+	// Overwriting destroys the old value, and makes 3 the most recent.
+	c.Set(&keyT{key: 3}, &valueT{value: 33, destroyed: &destroyed})
+	if destroyed != 2 || c.Get(&keyT{key: 3}).(*valueT).value != 33 {
+		t.FailNow()
+	}
+	c.Set(&keyT{key: 5}, &valueT{value: 50})
+	if c.Peek(&keyT{key: 1}) != nil || evictions[1] != (evicted{1, 10, ReasonEvicted}) {
+		t.FailNow()
+	}
+
+	if !c.Delete(&keyT{key: 4}) || c.Delete(&keyT{key: 4}) {
+		t.FailNow()
+	}
+	if evictions[2] != (evicted{4, 40, ReasonDeleted}) || c.Len() != 2 {
+		t.FailNow()
+	}
+
+	if c.Get(&keyT{key: 100}) != nil {
+		t.FailNow()
+	}
+	stats := c.Stats()
+	if stats != (Stats{Hits: 2, Misses: 1, Evictions: 2}) {
+		t.Fatalf("%+v", stats)
+	}
+	c.ResetStats()
+	if c.Stats() != (Stats{}) {
+		t.FailNow()
+	}
+
+	c.Release()
+	if c.Len() != 0 || len(evictions) != 5 {
+		t.FailNow()
+	}
+}
+
+func TestCacheBytes(t *testing.T) {
+	sizeof := func(key dict.Key, value dict.Value) int64 {
+		return int64(value.(*valueT).value)
+	}
+	c := Create(WithMaxBytes(100, sizeof))
+
+	c.Set(&keyT{key: 1}, &valueT{value: 40})
+	c.Set(&keyT{key: 2}, &valueT{value: 40})
+	if c.Bytes() != 80 {
+		t.FailNow()
+	}
+
+	c.Set(&keyT{key: 3}, &valueT{value: 30})
+	if c.Bytes() != 70 || c.Peek(&keyT{key: 1}) != nil {
+		t.FailNow()
+	}
+
+	// Shrinking an entry frees room.
+	c.Set(&keyT{key: 2}, &valueT{value: 10})
+	c.Set(&keyT{key: 4}, &valueT{value: 60})
+	if c.Bytes() != 100 || c.Len() != 3 {
+		t.FailNow()
+	}
+
+	// An entry larger than the cache doesn't stay.
+	c.Set(&keyT{key: 5}, &valueT{value: 101})
+	if c.Len() != 0 || c.Bytes() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := Create(
+		WithTTL(time.Minute),
+		WithClock(func() time.Time { return now }),
+	)
+
+	c.Set(&keyT{key: 1}, &valueT{value: 1})
+	c.SetWithTTL(&keyT{key: 2}, &valueT{value: 2}, 0)
+	c.SetWithTTL(&keyT{key: 3}, &valueT{value: 3}, time.Hour)
+
+	if ttl, ok := c.TTL(&keyT{key: 1}); !ok || ttl != time.Minute {
+		t.FailNow()
+	}
+	if ttl, ok := c.TTL(&keyT{key: 2}); !ok || ttl != 0 {
+		t.FailNow()
+	}
+
+	now = now.Add(time.Minute)
+	if c.Get(&keyT{key: 1}) != nil || c.Len() != 2 {
+		t.FailNow()
+	}
+	if _, ok := c.TTL(&keyT{key: 1}); ok {
+		t.FailNow()
+	}
+
+	now = now.Add(time.Hour)
+	if c.RemoveExpired() != 1 || c.Len() != 1 || c.Get(&keyT{key: 2}) == nil {
+		t.FailNow()
+	}
+
+	stats := c.Stats()
+	if stats.Expirations != 2 || stats.Misses != 1 || stats.Hits != 1 {
+		t.Fatalf("%+v", stats)
+	}
+}
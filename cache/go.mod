@@ -0,0 +1,13 @@
+module cache
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	dict v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	dict => ../dict
+)
@@ -0,0 +1,103 @@
+package db
+
+// The periodic jobs on the databases, a port of databasesCron of server.c
+// of redis: the active expire of the keys, and the resize and the
+// incremental rehashing of the hash tables.
+
+import (
+	"time"
+
+	"dict"
+)
+
+const (
+	// CronDBsPerCall Number of databases processed by a cron job call.
+	CronDBsPerCall = 16
+	// hashtableMinFill Minimal hash table fill 10%.
+	hashtableMinFill = 10
+	// dictHTInitialSize Initial size of the hash tables.
+	dictHTInitialSize = 4
+)
+
+// htNeedsResize Return true if the hash table is filled at less than
+// hashtableMinFill, and should be shrunk to save memory.
+func htNeedsResize(d *dict.Dict) bool {
+	size, used := d.Slots(), d.Size()
+	return size > dictHTInitialSize && used*100/size < hashtableMinFill
+}
+
+// TryResizeHashTables If the percentage of used slots in the HT reaches
+// hashtableMinFill we resize the hash table to save memory. Up to
+// CronDBsPerCall databases are checked, the next call continues from the
+// next database.
+func (s *Server) TryResizeHashTables() {
+	dbsPerCall := CronDBsPerCall
+	if dbsPerCall > len(s.dbs) {
+		dbsPerCall = len(s.dbs)
+	}
+	for j := 0; j < dbsPerCall; j++ {
+		db := s.dbs[s.resizeDB%len(s.dbs)]
+		if htNeedsResize(db.dict) {
+			db.dict.Resize()
+		}
+		if htNeedsResize(db.expires) {
+			db.expires.Resize()
+		}
+		s.resizeDB++
+	}
+}
+
+// incrementallyRehash Our hash table implementation performs rehashing
+// incrementally while we write/read from the hash table. Still if the
+// server is idle, the hash table will use two tables for a long time. So
+// we try to use 1 millisecond of CPU time at every call of this function
+// to perform some rehashing.
+//
+// The function returns true if some rehashing was performed, otherwise
+// false is returned.
+func (db *DB) incrementallyRehash() bool {
+	// Keys dictionary
+	if db.dict.IsRehashing() {
+		db.dict.RehashMilliseconds(1)
+		return true // already used our millisecond for this loop...
+	}
+	// Expires
+	if db.expires.IsRehashing() {
+		db.expires.RehashMilliseconds(1)
+		return true // already used our millisecond for this loop...
+	}
+	return false
+}
+
+// IncrementallyRehash Rehash the hash tables of the first database that
+// is rehashing, for 1 millisecond. Up to CronDBsPerCall databases are
+// checked, the next call continues from the database rehashed. Returns
+// true if some rehashing was performed.
+func (s *Server) IncrementallyRehash() bool {
+	dbsPerCall := CronDBsPerCall
+	if dbsPerCall > len(s.dbs) {
+		dbsPerCall = len(s.dbs)
+	}
+	for j := 0; j < dbsPerCall; j++ {
+		if s.dbs[s.rehashDB%len(s.dbs)].incrementallyRehash() {
+			// If the function did some work, stop here, we'll do more at
+			// the next cron loop.
+			return true
+		}
+		s.rehashDB++
+	}
+	return false
+}
+
+// DatabasesCron This function handles 'background' operations we are
+// required to do incrementally in Redis databases, such as active key
+// expiring, resizing, rehashing. The active expire cycle runs for at
+// most 'expireTimelimit'.
+func (s *Server) DatabasesCron(expireTimelimit time.Duration) {
+	// Expire keys by random sampling.
+	s.ActiveExpireCycle(expireTimelimit)
+
+	// Perform hash tables rehashing if needed.
+	s.TryResizeHashTables()
+	s.IncrementallyRehash()
+}
@@ -0,0 +1,564 @@
+package db
+
+// Keyspace handling, a port of db.c of redis.
+//
+// A server has a fixed number of numbered databases. Every database maps
+// the keys to *object.Object values in a dict.Dict, and the keys with a
+// timeout to their expire time in a second dict.Dict. The expired keys
+// are deleted lazily, when they are accessed. The modifications of the
+// keys are signaled to the watchers of the keys, see Watch, and notified
+// as keyspace events, see SubscribeKeyspaceEvents.
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"adlist"
+	"dict"
+	"object"
+	"pubsub"
+	"sds"
+	"util"
+)
+
+// Error
+var (
+	// ErrDBIndex the database doesn't exist.
+	ErrDBIndex = errors.New("DB index is out of range")
+	// ErrNoSuchKey the key doesn't exist.
+	ErrNoSuchKey = errors.New("no such key")
+	// ErrSameObject the source and destination databases are the same.
+	ErrSameObject = errors.New("source and destination objects are the same")
+	// ErrWrongType the key holds a value of another type.
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	// ErrUnknownType the type name is not valid.
+	ErrUnknownType = errors.New("unknown type name")
+	// ErrSyntax syntax error.
+	ErrSyntax = errors.New("syntax error")
+)
+
+const (
+	// Databases default number of databases.
+	Databases = 16
+	// ScanCount default number of keys returned by Scan.
+	ScanCount = 10
+)
+
+// Server the databases of a server.
+type Server struct {
+	dbs []*DB
+	now func() time.Time
+
+	// Classes of the keyspace events published to pubsub.
+	notifyKeyspaceEvents int
+	pubsub               *pubsub.PubSub
+	// Callbacks subscribed to the keyspace events.
+	keyspaceSubscribers *adlist.List
+
+	// The next databases of the cron jobs, processed incrementally.
+	expireDB int
+	resizeDB int
+	rehashDB int
+	// The last active expire cycle exited for the time limit.
+	timelimitExit bool
+}
+
+// Option opt.
+type Option func(s *Server)
+
+// WithDatabases The number of databases, Databases by default.
+func WithDatabases(n int) Option {
+	return func(s *Server) {
+		s.dbs = make([]*DB, n)
+	}
+}
+
+// WithClock The 'now' is used to expire the keys, time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithPubSub The keyspace events are published to the channels of 'ps',
+// according to the classes of WithNotifyKeyspaceEvents.
+func WithPubSub(ps *pubsub.PubSub) Option {
+	return func(s *Server) {
+		s.pubsub = ps
+	}
+}
+
+// WithNotifyKeyspaceEvents The classes of the keyspace events published
+// to pubsub, like notify-keyspace-events, none by default.
+func WithNotifyKeyspaceEvents(classes int) Option {
+	return func(s *Server) {
+		s.notifyKeyspaceEvents = classes
+	}
+}
+
+// Create the databases of a server.
+func Create(opts ...Option) *Server {
+	s := &Server{
+		dbs:                 make([]*DB, Databases),
+		now:                 time.Now,
+		keyspaceSubscribers: adlist.ListCreate(),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+	for id := range s.dbs {
+		s.dbs[id] = &DB{
+			id:          id,
+			srv:         s,
+			dict:        dict.Create(),
+			expires:     dict.Create(),
+			watchedKeys: dict.Create(),
+			now:         s.now,
+		}
+	}
+	return s
+}
+
+// Len Return the number of databases.
+func (s *Server) Len() int {
+	return len(s.dbs)
+}
+
+// Select Return the database 'id', like SELECT.
+func (s *Server) Select(id int) (*DB, error) {
+	if id < 0 || id >= len(s.dbs) {
+		return nil, ErrDBIndex
+	}
+	return s.dbs[id], nil
+}
+
+// SwapDB Swap two databases at runtime, like SWAPDB, so that all the
+// clients will immediately see the new database: the data of the two
+// databases is exchanged, their ids don't change.
+func (s *Server) SwapDB(id1, id2 int) error {
+	db1, err := s.Select(id1)
+	if err != nil {
+		return err
+	}
+	db2, err := s.Select(id2)
+	if err != nil {
+		return err
+	}
+
+	// Touch the keys watched in the two databases, the watched keys stay
+	// with the database ids.
+	touchAllWatchedKeys(db1, db2)
+	touchAllWatchedKeys(db2, db1)
+
+	// Swap hash tables. Note that we don't swap ids, as clients hold
+	// exactly the pointers to the databases.
+	db1.dict, db2.dict = db2.dict, db1.dict
+	db1.expires, db2.expires = db2.expires, db1.expires
+	return nil
+}
+
+// Move the key from the database 'src' to the database 'dstID', like
+// MOVE. Returns false if the key doesn't exist in the source database or
+// already exists in the target database.
+func (s *Server) Move(src *DB, key []byte, dstID int) (bool, error) {
+	dst, err := s.Select(dstID)
+	if err != nil {
+		return false, err
+	}
+
+	// If the user is moving using as target the same DB as the source DB
+	// it is probably an error.
+	if src == dst {
+		return false, ErrSameObject
+	}
+
+	// Check if the element exists and get a reference
+	o := src.Lookup(key)
+	if o == nil {
+		return false, nil
+	}
+	expire := src.GetExpire(key)
+
+	// Return false if the key already exists in the target DB
+	if dst.Lookup(key) != nil {
+		return false, nil
+	}
+	dst.Add(key, o)
+	if expire != -1 {
+		dst.setExpire(key, expire)
+	}
+
+	// OK! key moved, free the entry in the source DB
+	src.delete(key)
+	src.NotifyKeyspaceEvent(NotifyGeneric, "move_from", key)
+	dst.NotifyKeyspaceEvent(NotifyGeneric, "move_to", key)
+	return true, nil
+}
+
+// FlushAll Remove all the keys of all the databases, like FLUSHALL.
+// Returns the number of removed keys.
+func (s *Server) FlushAll() uint64 {
+	var removed uint64
+	for _, db := range s.dbs {
+		removed += db.Flush()
+	}
+	return removed
+}
+
+// DB a database, the keyspace and the expires of the keys.
+type DB struct {
+	id  int
+	srv *Server
+	// The keyspace for this DB
+	dict *dict.Dict
+	// Timeout of keys with a timeout set
+	expires *dict.Dict
+	// Cursor of the active expire cycle.
+	expiresCursor uint64
+	// WATCHED keys for MULTI/EXEC CAS
+	watchedKeys *dict.Dict
+
+	now func() time.Time
+}
+
+// keyOf Return the dict key of 'key'.
+func keyOf(key []byte) *sds.Key {
+	return (*sds.Key)(sds.New(key))
+}
+
+// ID Return the number of the database.
+func (db *DB) ID() int {
+	return db.id
+}
+
+// Size Return the number of keys of the database, like DBSIZE. The
+// expired keys not yet deleted are counted.
+func (db *DB) Size() uint64 {
+	return db.dict.Size()
+}
+
+// Lookup Return the value of the key, nil if the key doesn't exist. The
+// key is deleted if it is expired.
+func (db *DB) Lookup(key []byte) *object.Object {
+	if db.expireIfNeeded(key) {
+		return nil
+	}
+	de := db.dict.Find(keyOf(key))
+	if de == nil {
+		return nil
+	}
+	return de.Value().(*object.Object)
+}
+
+// LookupType Return the value of the key, like Lookup, ErrWrongType if
+// the value is not of type 'typ'.
+func (db *DB) LookupType(key []byte, typ uint8) (*object.Object, error) {
+	o := db.Lookup(key)
+	if o != nil && o.Type() != typ {
+		return nil, ErrWrongType
+	}
+	return o, nil
+}
+
+// Add the key to the DB. The database increments the reference count of
+// the value, the caller keeps its reference. A "new" event is notified.
+//
+// The program is aborted if the key already exists.
+func (db *DB) Add(key []byte, val *object.Object) {
+	if db.dict.Add(keyOf(key), val) != nil {
+		panic("db: key already exists")
+	}
+	db.SignalModifiedKey(key)
+	db.NotifyKeyspaceEvent(NotifyNew, "new", key)
+}
+
+// Overwrite an existing key with a new value. The expire of the key is
+// retained.
+//
+// The program is aborted if the key was not already present.
+func (db *DB) Overwrite(key []byte, val *object.Object) {
+	if db.dict.Replace(keyOf(key), val) != 0 {
+		panic("db: key not found")
+	}
+	db.SignalModifiedKey(key)
+}
+
+// SetKey High level Set operation. This function can be used in order to
+// set a key, whatever it was existing or not, to a new object.
+//
+//  1. The value object reference count is incremented.
+//  2. The expire time of the key is reset (the key is made persistent),
+//     unless 'keepTTL' is true.
+func (db *DB) SetKey(key []byte, val *object.Object, keepTTL bool) {
+	if db.Lookup(key) == nil {
+		db.Add(key, val)
+	} else {
+		db.Overwrite(key, val)
+	}
+	if !keepTTL {
+		db.RemoveExpire(key)
+	}
+}
+
+// Exists Return true if the key exists, like EXISTS.
+func (db *DB) Exists(key []byte) bool {
+	return db.Lookup(key) != nil
+}
+
+// Delete a key, value, and associated expiration entry if any, from the
+// DB, like DEL, notifying a "del" event. Returns false if the key doesn't
+// exist.
+func (db *DB) Delete(key []byte) bool {
+	if !db.delete(key) {
+		return false
+	}
+	db.NotifyKeyspaceEvent(NotifyGeneric, "del", key)
+	return true
+}
+
+// delete Delete the key without notifying an event, the caller notifies
+// the reason of the deletion.
+func (db *DB) delete(key []byte) bool {
+	k := keyOf(key)
+	db.expires.Delete(k)
+	if db.dict.Delete(k) != dict.DictOK {
+		return false
+	}
+	db.SignalModifiedKey(key)
+	return true
+}
+
+// Evict Delete the key to free memory, like the eviction of maxmemory,
+// notifying an "evicted" event. Returns false if the key doesn't exist.
+func (db *DB) Evict(key []byte) bool {
+	if !db.delete(key) {
+		return false
+	}
+	db.NotifyKeyspaceEvent(NotifyEvicted, "evicted", key)
+	return true
+}
+
+// Type Return the type name of the value of the key, like TYPE, "none"
+// if the key doesn't exist.
+func (db *DB) Type(key []byte) string {
+	o := db.Lookup(key)
+	if o == nil {
+		return "none"
+	}
+	return object.TypeName(o.Type())
+}
+
+// RandomKey Return a random key, like RANDOMKEY, nil if the database is
+// empty.
+func (db *DB) RandomKey() []byte {
+	for {
+		de := db.dict.GetRandomKey()
+		if de == nil {
+			return nil
+		}
+
+		key := append([]byte{}, de.Key().(*sds.Key).SDS().Bytes()...)
+		if db.expireIfNeeded(key) {
+			// search for another key. This expired.
+			continue
+		}
+		return key
+	}
+}
+
+// Rename the key 'src' to 'dst', like RENAME, deleting 'dst' if it
+// exists. With 'nx' 'dst' is not overwritten, like RENAMENX, and false is
+// returned. The expire of the key is moved along with the value, and the
+// "rename_from" and "rename_to" events are notified.
+func (db *DB) Rename(src, dst []byte, nx bool) (bool, error) {
+	o := db.Lookup(src)
+	if o == nil {
+		return false, ErrNoSuchKey
+	}
+
+	// When source and dest key is the same, no operation is performed,
+	// if the key exists, however we still return an error on unexisting
+	// key.
+	if bytes.Equal(src, dst) {
+		return !nx, nil
+	}
+
+	o.IncrRefCount()
+	defer o.DecrRefCount()
+	expire := db.GetExpire(src)
+	if db.Lookup(dst) != nil {
+		if nx {
+			return false, nil
+		}
+		// Overwrite: delete the old key before creating the new one
+		// with the same name.
+		db.delete(dst)
+	}
+	db.Add(dst, o)
+	if expire != -1 {
+		db.setExpire(dst, expire)
+	}
+	db.delete(src)
+	db.NotifyKeyspaceEvent(NotifyGeneric, "rename_from", src)
+	db.NotifyKeyspaceEvent(NotifyGeneric, "rename_to", dst)
+	return true, nil
+}
+
+// Scan the keys of the database, like SCAN: starting from 'cursor' (0 to
+// start a new iteration), returns the next cursor (0 when the iteration
+// is complete) and the keys. About 'count' keys are returned, ScanCount
+// if 0. When 'match' is not nil, only the keys matching the glob-style
+// pattern are returned, and when 'typ' is not empty only the keys of
+// this type.
+func (db *DB) Scan(cursor uint64, match []byte, count int, typ string) (uint64, [][]byte, error) {
+	var (
+		typeFilter bool
+		keyType    uint8
+	)
+	if typ != "" {
+		var ok bool
+		if keyType, ok = object.TypeByName(typ); !ok {
+			return 0, nil, ErrUnknownType
+		}
+		typeFilter = true
+	}
+	if count < 0 {
+		return 0, nil, ErrSyntax
+	}
+	if count == 0 {
+		count = ScanCount
+	}
+	allkeys := match == nil || string(match) == "*"
+
+	// We set the max number of iterations to ten times the specified
+	// COUNT, so if the hash table is in a pathological state (very
+	// sparsely populated) we avoid to block too much time at the cost of
+	// returning no or very few elements.
+	var keys [][]byte
+	maxiterations := count * 10
+	for {
+		cursor = db.dict.Scan(cursor, func(de *dict.Entry) {
+			keys = append(keys, append([]byte{}, de.Key().(*sds.Key).SDS().Bytes()...))
+		})
+		maxiterations--
+		if cursor == 0 || maxiterations == 0 || len(keys) >= count {
+			break
+		}
+	}
+
+	// Filter elements.
+	filtered := keys[:0]
+	for _, key := range keys {
+		// Filter element if it does not match the pattern.
+		if !allkeys && !util.StringMatchLen(match, key, false) {
+			continue
+		}
+		// Filter an element if it isn't the type we want, or if it is
+		// expired.
+		o := db.Lookup(key)
+		if o == nil || typeFilter && o.Type() != keyType {
+			continue
+		}
+		filtered = append(filtered, key)
+	}
+	return cursor, filtered, nil
+}
+
+// Flush Remove all the keys of the database, like FLUSHDB. Returns the
+// number of removed keys.
+func (db *DB) Flush() uint64 {
+	removed := db.dict.Size()
+	touchAllWatchedKeys(db, nil)
+	db.dict.Close()
+	db.expires.Close()
+	db.dict = dict.Create()
+	db.expires = dict.Create()
+	return removed
+}
+
+// Each Call 'fn' with every key of the database, its value and its
+// expire time (-1 if none), as rdbSaveDb does. The expired keys are not
+// skipped. 'fn' must not modify the database, the iteration stops when
+// it returns false.
+func (db *DB) Each(fn func(key []byte, val *object.Object, expire int64) bool) {
+	it := db.dict.GetIterator()
+	defer it.Release()
+	for de := it.Next(); de != nil; de = it.Next() {
+		key := de.Key().(*sds.Key).SDS().Bytes()
+		if !fn(key, de.Value().(*object.Object), db.GetExpire(key)) {
+			return
+		}
+	}
+}
+
+// Expand the hash tables of the keys and of the expires to hold 'size'
+// keys and 'expiresSize' expires, like the RESIZEDB hints of an RDB file,
+// so that loading that many keys doesn't rehash.
+func (db *DB) Expand(size, expiresSize uint64) {
+	db.dict.Expand(size)
+	db.expires.Expand(expiresSize)
+}
+
+// IsRehashing Return true if the hash table of the keys or of the
+// expires is being incrementally rehashed.
+func (db *DB) IsRehashing() bool {
+	return db.dict.IsRehashing() || db.expires.IsRehashing()
+}
+
+// LoadKey Add the key with its expire time (-1 if none) while loading
+// the database, like dbAddRDBLoad: the key is not signaled to the
+// watchers and no event is notified. Returns false if the key already
+// exists.
+func (db *DB) LoadKey(key []byte, val *object.Object, expire int64) bool {
+	if db.dict.Add(keyOf(key), val) != nil {
+		return false
+	}
+	if expire != -1 {
+		db.expires.Add(keyOf(key), expireValue(expire))
+	}
+	return true
+}
+
+// KeyIterator an iterator of the keys of a database, with a safe
+// iterator of its hash table.
+type KeyIterator struct {
+	db *DB
+	it *dict.Iterator
+}
+
+// SafeIterator Return an iterator of the keys that allows the database
+// to be modified between the calls to Next, as long as it is not flushed
+// or swapped: the incremental rehashing is paused until the iterator is
+// released, so that every key existing for the whole iteration is
+// returned once. The keys added while iterating may or may not be
+// returned.
+func (db *DB) SafeIterator() *KeyIterator {
+	return &KeyIterator{
+		db: db,
+		it: db.dict.GetSafeIterator(),
+	}
+}
+
+// Next Return the next key, its value and its expire time (-1 if none),
+// ok is false at the end of the iteration. The expired keys are not
+// skipped.
+func (it *KeyIterator) Next() (key []byte, val *object.Object, expire int64, ok bool) {
+	for de := it.it.Next(); de != nil; de = it.it.Next() {
+		// The entry may have been deleted after the iterator saved it
+		// as the next one.
+		k := de.Key().(*sds.Key)
+		if it.db.dict.Find(k) != de {
+			continue
+		}
+		key = k.SDS().Bytes()
+		return key, de.Value().(*object.Object), it.db.GetExpire(key), true
+	}
+	return nil, nil, -1, false
+}
+
+// Release the iterator, resuming the incremental rehashing.
+func (it *KeyIterator) Release() {
+	it.it.Release()
+}
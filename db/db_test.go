@@ -0,0 +1,675 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"object"
+	"pubsub"
+)
+
+// fakeClock a clock advanced by the tests.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) ms() int64 {
+	return c.t.UnixNano() / int64(time.Millisecond)
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newServer() (*Server, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1600000000, 0)}
+	return Create(WithDatabases(4), WithClock(clock.now)), clock
+}
+
+func TestGetSet(t *testing.T) {
+	s, _ := newServer()
+	db, _ := s.Select(0)
+
+	if v, err := db.Get([]byte("foo")); v != nil || err != nil {
+		t.Fatal(v, err)
+	}
+	if ok, err := db.Set([]byte("foo"), []byte("bar"), 0, 0); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if v, _ := db.Get([]byte("foo")); string(v) != "bar" {
+		t.Fatalf("get %q", v)
+	}
+	if ok, _ := db.Set([]byte("foo"), []byte("baz"), SetNX, 0); ok {
+		t.Fatal("NX")
+	}
+	if ok, _ := db.Set([]byte("new"), []byte("baz"), SetXX, 0); ok || db.Exists([]byte("new")) {
+		t.Fatal("XX")
+	}
+	if _, err := db.Set([]byte("foo"), []byte("baz"), SetXX|SetNX, 0); err != ErrSyntax {
+		t.Fatal(err)
+	}
+	db.Set([]byte("n"), []byte("123"), 0, 0)
+	if o := db.Lookup([]byte("n")); o.Encoding() != object.EncodingInt || db.Type([]byte("n")) != "string" {
+		t.Fatal("int encoding")
+	}
+
+	l := object.CreateList()
+	db.Add([]byte("list"), l)
+	l.DecrRefCount()
+	if _, err := db.Get([]byte("list")); err != ErrWrongType {
+		t.Fatal(err)
+	}
+	if db.Type([]byte("list")) != "list" || db.Type([]byte("none")) != "none" {
+		t.Fatal("type")
+	}
+	if !db.Delete([]byte("list")) || db.Delete([]byte("list")) || db.Size() != 2 {
+		t.Fatal("delete")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s, clock := newServer()
+	db, _ := s.Select(0)
+
+	db.Set([]byte("foo"), []byte("bar"), 0, clock.ms()+1000)
+	if ttl := db.TTL([]byte("foo")); ttl != 1000 {
+		t.Fatalf("ttl %d", ttl)
+	}
+	// KEEPTTL
+	db.Set([]byte("foo"), []byte("baz"), SetKeepTTL, 0)
+	if ttl := db.TTL([]byte("foo")); ttl != 1000 {
+		t.Fatalf("ttl %d", ttl)
+	}
+	clock.advance(time.Second)
+	if !db.Exists([]byte("foo")) {
+		t.Fatal("expired too early")
+	}
+	clock.advance(time.Millisecond)
+	if db.Exists([]byte("foo")) || db.Size() != 0 || db.ExpiresSize() != 0 {
+		t.Fatal("not expired")
+	}
+	if ttl := db.TTL([]byte("foo")); ttl != -2 {
+		t.Fatalf("ttl %d", ttl)
+	}
+
+	// SET clears the TTL.
+	db.Set([]byte("foo"), []byte("bar"), 0, clock.ms()+1000)
+	db.Set([]byte("foo"), []byte("bar"), 0, 0)
+	if ttl := db.TTL([]byte("foo")); ttl != -1 {
+		t.Fatalf("ttl %d", ttl)
+	}
+	db.SetExpire([]byte("foo"), clock.ms()+10)
+	if !db.RemoveExpire([]byte("foo")) || db.RemoveExpire([]byte("foo")) {
+		t.Fatal("persist")
+	}
+
+	// An expire in the past deletes the key.
+	if ok, _ := db.Set([]byte("foo"), []byte("bar"), 0, clock.ms()-1); !ok || db.Exists([]byte("foo")) {
+		t.Fatal("expire in the past")
+	}
+}
+
+func TestRename(t *testing.T) {
+	s, clock := newServer()
+	db, _ := s.Select(0)
+
+	if _, err := db.Rename([]byte("a"), []byte("b"), false); err != ErrNoSuchKey {
+		t.Fatal(err)
+	}
+	db.Set([]byte("a"), []byte("1"), 0, clock.ms()+1000)
+	db.Set([]byte("b"), []byte("2"), 0, 0)
+	if ok, _ := db.Rename([]byte("a"), []byte("a"), false); !ok {
+		t.Fatal("same key")
+	}
+	if ok, _ := db.Rename([]byte("a"), []byte("a"), true); ok {
+		t.Fatal("same key NX")
+	}
+	if ok, _ := db.Rename([]byte("a"), []byte("b"), true); ok {
+		t.Fatal("NX")
+	}
+	if ok, _ := db.Rename([]byte("a"), []byte("b"), false); !ok {
+		t.Fatal("rename")
+	}
+	if v, _ := db.Get([]byte("b")); string(v) != "1" || db.Exists([]byte("a")) || db.TTL([]byte("b")) != 1000 {
+		t.Fatalf("get %q", v)
+	}
+	if db.Size() != 1 || db.ExpiresSize() != 1 {
+		t.Fatal("size")
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	s, clock := newServer()
+	db, _ := s.Select(0)
+	if db.RandomKey() != nil {
+		t.Fatal("empty db")
+	}
+
+	db.Set([]byte("a"), []byte("1"), 0, 0)
+	for i := 0; i < 100; i++ {
+		db.Set([]byte(strconv.Itoa(i)), []byte("1"), 0, clock.ms()+1)
+	}
+	clock.advance(time.Second)
+	if k := db.RandomKey(); string(k) != "a" {
+		t.Fatalf("random key %q", k)
+	}
+	if db.Size() == 101 {
+		t.Fatal("expired keys not deleted")
+	}
+}
+
+func TestScan(t *testing.T) {
+	s, clock := newServer()
+	db, _ := s.Select(0)
+	for i := 0; i < 1000; i++ {
+		db.Set([]byte(fmt.Sprintf("key:%d", i)), []byte("v"), 0, 0)
+	}
+	for i := 0; i < 10; i++ {
+		o := object.CreateHash()
+		db.Add([]byte(fmt.Sprintf("hash:%d", i)), o)
+		o.DecrRefCount()
+	}
+	db.Set([]byte("key:expired"), []byte("v"), 0, clock.ms()+1)
+	clock.advance(time.Second)
+
+	scan := func(match string, count int, typ string) []string {
+		var m []byte
+		if match != "" {
+			m = []byte(match)
+		}
+		var all []string
+		cursor := uint64(0)
+		for {
+			next, keys, err := db.Scan(cursor, m, count, typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range keys {
+				all = append(all, string(k))
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+		sort.Strings(all)
+		return all
+	}
+
+	if keys := scan("", 0, ""); len(keys) != 1010 {
+		t.Fatalf("%d keys", len(keys))
+	}
+	if keys := scan("key:1?", 100, ""); len(keys) != 10 || keys[0] != "key:10" {
+		t.Fatalf("keys %q", keys)
+	}
+	if keys := scan("*", 7, "hash"); len(keys) != 10 {
+		t.Fatalf("keys %q", keys)
+	}
+	if keys := scan("key:*", 0, "hash"); len(keys) != 0 {
+		t.Fatalf("keys %q", keys)
+	}
+	if _, _, err := db.Scan(0, nil, 0, "foo"); err != ErrUnknownType {
+		t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s, clock := newServer()
+	if s.Len() != 4 {
+		t.Fatal("len")
+	}
+	if _, err := s.Select(4); err != ErrDBIndex {
+		t.Fatal(err)
+	}
+	db0, _ := s.Select(0)
+	db1, _ := s.Select(1)
+	db0.Set([]byte("a"), []byte("0"), 0, clock.ms()+1000)
+	db0.Set([]byte("b"), []byte("0"), 0, 0)
+	db1.Set([]byte("b"), []byte("1"), 0, 0)
+
+	// MOVE
+	if _, err := s.Move(db0, []byte("a"), 0); err != ErrSameObject {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Move(db0, []byte("b"), 1); ok {
+		t.Fatal("moved to an existing key")
+	}
+	if ok, _ := s.Move(db0, []byte("none"), 1); ok {
+		t.Fatal("moved a missing key")
+	}
+	if ok, err := s.Move(db0, []byte("a"), 1); !ok || err != nil {
+		t.Fatal("move")
+	}
+	if db0.Exists([]byte("a")) || db1.TTL([]byte("a")) != 1000 {
+		t.Fatal("move")
+	}
+
+	// SWAPDB
+	if err := s.SwapDB(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db0.Get([]byte("b")); string(v) != "1" || db0.Size() != 2 || db1.Size() != 1 {
+		t.Fatalf("swapdb %q", v)
+	}
+	if db0.ID() != 0 {
+		t.Fatal("id")
+	}
+	if err := s.SwapDB(0, -1); err != ErrDBIndex {
+		t.Fatal(err)
+	}
+
+	// FLUSHDB, FLUSHALL
+	if db1.Flush() != 1 || db1.Size() != 0 {
+		t.Fatal("flushdb")
+	}
+	db1.Set([]byte("x"), []byte("1"), 0, 0)
+	if s.FlushAll() != 3 || db0.Size() != 0 || db0.ExpiresSize() != 0 {
+		t.Fatal("flushall")
+	}
+}
+
+func TestRefCount(t *testing.T) {
+	s, _ := newServer()
+	db, _ := s.Select(0)
+
+	o := object.CreateList()
+	object.ListPush(o, object.ListTail, [][]byte{[]byte("a")}, nil)
+	db.Add([]byte("l"), o)
+	db.SetKey([]byte("l2"), o, false)
+	if o.RefCount() != 3 {
+		t.Fatalf("refcount %d", o.RefCount())
+	}
+	db.Delete([]byte("l"))
+	db.Flush()
+	if o.RefCount() != 1 || object.ListLen(o) != 1 {
+		t.Fatalf("refcount %d", o.RefCount())
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	s, clock := newServer()
+	db, _ := s.Select(0)
+	w := &testWatcher{}
+	w.watch(db, "a")
+
+	// The loaded keys are neither signaled nor notified.
+	var events int
+	s.SubscribeKeyspaceEvents(NotifyAll|NotifyNew, func(class int, event string, key []byte, dbid int) {
+		events++
+	})
+	db.Expand(1000, 500)
+	for i := 0; i < 1000; i++ {
+		expire := int64(-1)
+		if i%2 == 0 {
+			expire = clock.ms() + 1000
+		}
+		o := object.CreateStringFromLongLong(int64(i))
+		if !db.LoadKey([]byte(fmt.Sprint(i)), o, expire) {
+			t.Fatalf("load %d", i)
+		}
+		o.DecrRefCount()
+	}
+	if db.IsRehashing() {
+		t.Fatal("rehashing while loading")
+	}
+	if db.LoadKey([]byte("1"), object.CreateString([]byte("x")), -1) {
+		t.Fatal("loaded a duplicated key")
+	}
+	if db.LoadKey([]byte("a"), object.CreateString([]byte("x")), -1); w.touched != nil || events != 0 {
+		t.Fatal("load signaled")
+	}
+	if db.Size() != 1001 || db.ExpiresSize() != 500 || db.TTL([]byte("2")) != 1000 {
+		t.Fatalf("size %d %d", db.Size(), db.ExpiresSize())
+	}
+
+	var n, expires int
+	db.Each(func(key []byte, val *object.Object, expire int64) bool {
+		n++
+		if expire != -1 {
+			expires++
+		}
+		return true
+	})
+	if n != 1001 || expires != 500 {
+		t.Fatalf("each %d %d", n, expires)
+	}
+	n = 0
+	db.Each(func(key []byte, val *object.Object, expire int64) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatal("each not stopped")
+	}
+}
+
+func TestSafeIterator(t *testing.T) {
+	s, _ := newServer()
+	db, _ := s.Select(0)
+	for i := 0; i < 1000; i++ {
+		db.Set([]byte(fmt.Sprint(i)), []byte("v"), 0, 0)
+	}
+
+	// Modify the database while iterating: every key existing for the
+	// whole iteration is returned once, the deleted keys are not
+	// returned.
+	it := db.SafeIterator()
+	seen := make(map[string]int)
+	deleted := make(map[string]bool)
+	for n := 0; ; n++ {
+		key, val, expire, ok := it.Next()
+		if !ok {
+			break
+		}
+		if val == nil || expire != -1 || deleted[string(key)] {
+			t.Fatalf("key %s %v %d", key, val, expire)
+		}
+		seen[string(key)]++
+		if n%10 == 0 {
+			for i := 0; i < 20; i++ {
+				key := fmt.Sprint(n*7%1000 + i)
+				db.Delete([]byte(key))
+				deleted[key] = true
+				db.Set([]byte(fmt.Sprint("new", n, i)), []byte("v"), 0, 0)
+			}
+		}
+	}
+	it.Release()
+
+	for key, n := range seen {
+		if n != 1 {
+			t.Fatalf("key %s returned %d times", key, n)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		if !deleted[key] && seen[key] != 1 {
+			t.Fatalf("key %s not returned", key)
+		}
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	s, clock := newServer()
+	for id := 0; id < 2; id++ {
+		db, _ := s.Select(id)
+		for i := 0; i < 1000; i++ {
+			when := clock.ms() + 1000
+			if i%2 == 0 {
+				when += 1000
+			}
+			db.Set([]byte(strconv.Itoa(i)), []byte("v"), 0, when)
+		}
+		db.Set([]byte("persistent"), []byte("v"), 0, 0)
+	}
+	if n := s.ActiveExpireCycle(time.Second); n != 0 {
+		t.Fatalf("%d keys expired", n)
+	}
+
+	// The keys are expired by the active expire cycle, without being
+	// accessed.
+	clock.advance(1500 * time.Millisecond)
+	var expired uint64
+	for i := 0; i < 1000 && expired < 1000; i++ {
+		expired += s.ActiveExpireCycle(time.Second)
+	}
+	if expired != 1000 {
+		t.Fatalf("%d keys expired", expired)
+	}
+	for id := 0; id < 2; id++ {
+		db, _ := s.Select(id)
+		if db.Size() != 501 || db.ExpiresSize() != 500 {
+			t.Fatalf("db %d size %d expires %d", id, db.Size(), db.ExpiresSize())
+		}
+	}
+}
+
+func TestDatabasesCron(t *testing.T) {
+	s, _ := newServer()
+	db, _ := s.Select(1)
+	for i := 0; i < 10000; i++ {
+		db.Set([]byte(strconv.Itoa(i)), []byte("v"), 0, 0)
+	}
+	for i := 0; i < 9990; i++ {
+		db.Delete([]byte(strconv.Itoa(i)))
+	}
+	if !htNeedsResize(db.dict) {
+		t.Fatal("no resize needed")
+	}
+
+	// The hash table is shrunk and rehashed by the cron, without being
+	// accessed.
+	for i := 0; i < 100 && (htNeedsResize(db.dict) || db.dict.IsRehashing()); i++ {
+		s.DatabasesCron(time.Millisecond)
+	}
+	if htNeedsResize(db.dict) || db.dict.IsRehashing() || db.dict.Slots() != 16 {
+		t.Fatalf("slots %d rehashing %v", db.dict.Slots(), db.dict.IsRehashing())
+	}
+	if db.Size() != 10 {
+		t.Fatalf("size %d", db.Size())
+	}
+}
+
+// testWatcher a watcher recording the touched keys, and unwatching its
+// keys like a client.
+type testWatcher struct {
+	keys    []*WatchedKey
+	touched []string
+}
+
+func (w *testWatcher) watch(db *DB, key string) {
+	w.keys = append(w.keys, db.Watch([]byte(key), w))
+}
+
+func (w *testWatcher) Touched(wk *WatchedKey) {
+	w.touched = append(w.touched, string(wk.Key()))
+	for _, wk := range w.keys {
+		wk.Unwatch()
+	}
+	w.keys = nil
+}
+
+func TestWatch(t *testing.T) {
+	s, clock := newServer()
+	db, _ := s.Select(0)
+	db1, _ := s.Select(1)
+
+	checkTouched := func(w *testWatcher, want string) {
+		t.Helper()
+		if got := fmt.Sprint(w.touched); got != want {
+			t.Fatalf("touched %s, want %s", got, want)
+		}
+		w.touched = nil
+	}
+
+	// Every write path touches the watched keys.
+	ops := []func(key []byte){
+		func(key []byte) { db.Set(key, []byte("v"), 0, 0) },
+		func(key []byte) { db.Delete(key) },
+		func(key []byte) { db.SetExpire(key, clock.ms()+1000) },
+		func(key []byte) { db.RemoveExpire(key) },
+		func(key []byte) { db.Rename(key, []byte("other"), false) },
+		func(key []byte) { db.Rename([]byte("other"), key, false) },
+		func(key []byte) { s.Move(db, key, 1) },
+		func(key []byte) { db.SignalModifiedKey(key) },
+		func(key []byte) { db.Flush() },
+		func(key []byte) { s.SwapDB(0, 1) },
+		func(key []byte) { s.FlushAll() },
+	}
+	for i, op := range ops {
+		db.Set([]byte("foo"), []byte("bar"), 0, 0)
+		db.Set([]byte("other"), []byte("bar"), 0, 0)
+		db.SetExpire([]byte("foo"), clock.ms()+10000)
+		w1, w2 := &testWatcher{}, &testWatcher{}
+		w1.watch(db, "foo")
+		w1.watch(db, "bar")
+		w2.watch(db, "foo")
+		w2.watch(db1, "foo")
+		op([]byte("foo"))
+		checkTouched(w1, "[foo]")
+		checkTouched(w2, "[foo]")
+		if db.WatchedKeysSize() != 0 || db1.WatchedKeysSize() != 0 {
+			t.Fatalf("op %d: watched keys left", i)
+		}
+		s.FlushAll()
+	}
+
+	// The other keys and databases are not touched.
+	w := &testWatcher{}
+	w.watch(db, "foo")
+	db.Set([]byte("bar"), []byte("v"), 0, 0)
+	db1.Set([]byte("foo"), []byte("v"), 0, 0)
+	db.Delete([]byte("foo"))
+	db.Flush()
+	checkTouched(w, "[]")
+
+	// A failed SET NX doesn't touch the key.
+	db.Set([]byte("foo"), []byte("v"), 0, 0)
+	checkTouched(w, "[foo]")
+	w.watch(db, "foo")
+	db.Set([]byte("foo"), []byte("v"), SetNX, 0)
+	checkTouched(w, "[]")
+
+	// The deletion of a key already expired when watched is not a
+	// change, its expire is.
+	w.keys[0].Unwatch()
+	w.keys = nil
+	db.Set([]byte("foo"), []byte("v"), 0, clock.ms()+1000)
+	clock.advance(2 * time.Second)
+	w.watch(db, "foo")
+	if w.keys[0].IsExpired() {
+		t.Fatal("already expired key")
+	}
+	if db.Exists([]byte("foo")) {
+		t.Fatal("not expired")
+	}
+	checkTouched(w, "[]")
+
+	db.Set([]byte("foo"), []byte("v"), 0, clock.ms()+1000)
+	checkTouched(w, "[foo]")
+	w.watch(db, "foo")
+	clock.advance(2 * time.Second)
+	if !w.keys[0].IsExpired() {
+		t.Fatal("key not expired")
+	}
+	s.ActiveExpireCycle(time.Second)
+	checkTouched(w, "[foo]")
+}
+
+func TestKeyspaceEventsFlags(t *testing.T) {
+	tests := []struct {
+		classes string
+		flags   int
+		str     string
+	}{
+		{"", 0, ""},
+		{"KEA", NotifyKeyspace | NotifyKeyevent | NotifyAll, "AKE"},
+		{"Eg$lshzxet", NotifyKeyevent | NotifyAll, "AE"},
+		{"Kgx", NotifyKeyspace | NotifyGeneric | NotifyExpired, "gxK"},
+		{"nK", NotifyKeyspace | NotifyNew, "Kn"},
+	}
+	for _, test := range tests {
+		flags, ok := KeyspaceEventsStringToFlags(test.classes)
+		if !ok || flags != test.flags {
+			t.Errorf("KeyspaceEventsStringToFlags(%q) = %d, %v", test.classes, flags, ok)
+		}
+		if str := KeyspaceEventsFlagsToString(flags); str != test.str {
+			t.Errorf("KeyspaceEventsFlagsToString(%q) = %q, want %q", test.classes, str, test.str)
+		}
+	}
+	if _, ok := KeyspaceEventsStringToFlags("Kw"); ok {
+		t.Error("unknown class accepted")
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1600000000, 0)}
+	ps := pubsub.Create()
+	s := Create(WithDatabases(2), WithClock(clock.now), WithPubSub(ps),
+		WithNotifyKeyspaceEvents(NotifyKeyspace|NotifyKeyevent|NotifyGeneric|NotifyExpired))
+	db0, _ := s.Select(0)
+	db1, _ := s.Select(1)
+
+	var events []string
+	ks := s.SubscribeKeyspaceEvents(NotifyAll|NotifyNew, func(class int, event string, key []byte, dbid int) {
+		events = append(events, fmt.Sprintf("%d:%s:%s", dbid, event, key))
+	})
+	check := func(want ...string) {
+		t.Helper()
+		if fmt.Sprint(events) != fmt.Sprint(want) {
+			t.Fatalf("events %q, want %q", events, want)
+		}
+		events = nil
+	}
+
+	db0.Set([]byte("a"), []byte("1"), 0, clock.ms()+1000)
+	check("0:new:a", "0:set:a", "0:expire:a")
+	db0.Set([]byte("a"), []byte("2"), SetKeepTTL, 0)
+	check("0:set:a")
+	db0.Set([]byte("a"), []byte("3"), SetNX, 0)
+	check()
+	db0.Rename([]byte("a"), []byte("b"), false)
+	check("0:new:b", "0:rename_from:a", "0:rename_to:b")
+	s.Move(db0, []byte("b"), 1)
+	check("1:new:b", "0:move_from:b", "1:move_to:b")
+	db1.Delete([]byte("b"))
+	db1.Delete([]byte("b"))
+	check("1:del:b")
+	db0.Set([]byte("c"), []byte("1"), 0, 0)
+	db0.Evict([]byte("c"))
+	check("0:new:c", "0:set:c", "0:evicted:c")
+	db0.Set([]byte("d"), []byte("1"), 0, clock.ms()+10)
+	events = nil
+	clock.advance(time.Second)
+	if db0.Exists([]byte("d")) {
+		t.Fatal("not expired")
+	}
+	check("0:expired:d")
+
+	// Only the classes of the subscription are passed to the callback.
+	s.UnsubscribeKeyspaceEvents(ks)
+	s.SubscribeKeyspaceEvents(NotifyExpired, func(class int, event string, key []byte, dbid int) {
+		events = append(events, fmt.Sprintf("%d:%s:%s", dbid, event, key))
+		// The events notified by the callback are not passed to it.
+		db0.Exists([]byte("g"))
+	})
+	db0.Set([]byte("e"), []byte("1"), 0, clock.ms()+10)
+	db0.Set([]byte("g"), []byte("1"), 0, clock.ms()+10)
+	clock.advance(time.Second)
+	if db0.Exists([]byte("e")) || db0.Size() != 0 {
+		t.Fatal("not expired")
+	}
+	check("0:expired:e")
+
+	// The enabled classes are published.
+	sub, ch := ps.CreateChanSubscriber(100)
+	sub.PSubscribe([]byte("__key*@0__:*"))
+	db0.Set([]byte("f"), []byte("1"), 0, clock.ms()+10)
+	db0.Delete([]byte("f"))
+	var published []string
+	for len(ch) > 0 {
+		m := <-ch
+		published = append(published, fmt.Sprintf("%s=%s", m.Channel, m.Payload))
+	}
+	want := []string{
+		"__keyspace@0__:f=expire", "__keyevent@0__:expire=f",
+		"__keyspace@0__:f=del", "__keyevent@0__:del=f",
+	}
+	if fmt.Sprint(published) != fmt.Sprint(want) {
+		t.Fatalf("published %q, want %q", published, want)
+	}
+	s.SetNotifyKeyspaceEvents(NotifyKeyevent | NotifyAll)
+	if s.NotifyKeyspaceEvents() != NotifyKeyevent|NotifyAll {
+		t.Fatal("SetNotifyKeyspaceEvents")
+	}
+	db0.Set([]byte("f"), []byte("1"), 0, 0)
+	if m := <-ch; string(m.Channel) != "__keyevent@0__:set" || len(ch) != 0 {
+		t.Fatalf("published %s", m.Channel)
+	}
+}
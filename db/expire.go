@@ -0,0 +1,216 @@
+package db
+
+import (
+	"time"
+
+	"dict"
+	"sds"
+)
+
+// expireValue the expire time of a key, in unix time in milliseconds.
+type expireValue int64
+
+// Dup implements dict.Value.
+func (v expireValue) Dup() dict.Value {
+	return v
+}
+
+// Destructor implements dict.Value.
+func (v expireValue) Destructor() {}
+
+// mstime Return the UNIX time in milliseconds.
+func (db *DB) mstime() int64 {
+	return db.now().UnixNano() / int64(time.Millisecond)
+}
+
+// SetExpire Set an expire to the specified key, 'when' being the unix
+// time in milliseconds at which the key expires, notifying an "expire"
+// event.
+//
+// The program is aborted if the key doesn't exist.
+func (db *DB) SetExpire(key []byte, when int64) {
+	db.setExpire(key, when)
+	db.NotifyKeyspaceEvent(NotifyGeneric, "expire", key)
+}
+
+// setExpire Set the expire without notifying an event.
+func (db *DB) setExpire(key []byte, when int64) {
+	if db.dict.Find(keyOf(key)) == nil {
+		panic("db: key not found")
+	}
+	db.expires.Replace(keyOf(key), expireValue(when))
+	db.SignalModifiedKey(key)
+}
+
+// GetExpire Return the expire time of the specified key, or -1 if no
+// expire is associated with this key (i.e. the key is non volatile)
+func (db *DB) GetExpire(key []byte) int64 {
+	// No expire? return ASAP
+	if db.expires.Size() == 0 {
+		return -1
+	}
+	de := db.expires.Find(keyOf(key))
+	if de == nil {
+		return -1
+	}
+	return int64(de.Value().(expireValue))
+}
+
+// RemoveExpire Remove the expire of the key. Returns false if the key has
+// no expire. No event is notified, the caller notifies "persist" for
+// PERSIST.
+func (db *DB) RemoveExpire(key []byte) bool {
+	if db.expires.Delete(keyOf(key)) != dict.DictOK {
+		return false
+	}
+	db.SignalModifiedKey(key)
+	return true
+}
+
+// TTL Return the remaining time to live of the key in milliseconds, like
+// PTTL: -2 if the key doesn't exist, -1 if it has no expire.
+func (db *DB) TTL(key []byte) int64 {
+	// If the key does not exist at all, return -2
+	if db.Lookup(key) == nil {
+		return -2
+	}
+
+	// The key exists. Return -1 if it has no expire, or the actual TTL
+	// value otherwise.
+	expire := db.GetExpire(key)
+	if expire == -1 {
+		return -1
+	}
+	ttl := expire - db.mstime()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl
+}
+
+// ExpiresSize Return the number of keys with an expire.
+func (db *DB) ExpiresSize() uint64 {
+	return db.expires.Size()
+}
+
+// KeyIsExpired Check if the key is expired.
+func (db *DB) KeyIsExpired(key []byte) bool {
+	when := db.GetExpire(key)
+	if when < 0 {
+		return false // No expire for this key
+	}
+	return db.mstime() > when
+}
+
+// expireIfNeeded This function is called when we are going to perform
+// some operation in a given key, but such key may be already logically
+// expired even if it still exists in the database. If the key is expired
+// it is deleted, and true is returned.
+func (db *DB) expireIfNeeded(key []byte) bool {
+	if !db.KeyIsExpired(key) {
+		return false
+	}
+	// Delete the key
+	db.delete(key)
+	db.NotifyKeyspaceEvent(NotifyExpired, "expired", key)
+	return true
+}
+
+const (
+	// activeExpireCycleKeysPerLoop Keys for each DB loop.
+	activeExpireCycleKeysPerLoop = 20
+	// activeExpireCycleAcceptableStale % of stale keys after which we do
+	// extra efforts.
+	activeExpireCycleAcceptableStale = 10
+)
+
+// ActiveExpireCycle Try to expire a few timed out keys. The algorithm
+// used is adaptive and will use few CPU cycles if there are few expiring
+// keys, otherwise it will get more aggressive to avoid that too much
+// memory is used by keys that can be removed from the keyspace.
+//
+// Every expire cycle tests multiple databases: the next call will start
+// again from the next db. No more than CronDBsPerCall databases are
+// tested at every iteration, unless the previous cycle exited for the
+// time limit, and the cycle doesn't run for more than 'timelimit'.
+//
+// The keys of a database are sampled with a scan of the expires,
+// continued by the next cycle. The sampling is repeated while more than
+// 10% of the sampled keys are expired. Returns the number of expired
+// keys.
+func (s *Server) ActiveExpireCycle(timelimit time.Duration) uint64 {
+	start := time.Now()
+	dbsPerCall := CronDBsPerCall
+
+	// We usually should test CronDBsPerCall per iteration, with two
+	// exceptions:
+	//
+	// 1) Don't test more DBs than we have.
+	// 2) If last time we hit the time limit, we want to scan all DBs in
+	//    this iteration, as there is work to do in some DB and we don't
+	//    want expired keys to use memory for too much time.
+	if dbsPerCall > len(s.dbs) || s.timelimitExit {
+		dbsPerCall = len(s.dbs)
+	}
+	s.timelimitExit = false
+
+	var (
+		total     uint64
+		iteration int
+	)
+	for j := 0; j < dbsPerCall && !s.timelimitExit; j++ {
+		db := s.dbs[s.expireDB%len(s.dbs)]
+		// Increment the DB now so we are sure if we run out of time in
+		// the current DB we'll restart from the next. This allows to
+		// distribute the time evenly across DBs.
+		s.expireDB++
+
+		// Continue to expire if at the end of the cycle there are still
+		// a big percentage of keys to expire, compared to the number of
+		// keys we scanned.
+		for {
+			// If there is nothing to expire try next DB ASAP.
+			if db.expires.Size() == 0 {
+				break
+			}
+			iteration++
+
+			// Sample the keys with a scan of the expires, visiting at most
+			// 20 times more buckets than keys to sample.
+			var (
+				keys    [][]byte
+				buckets int
+			)
+			for len(keys) < activeExpireCycleKeysPerLoop &&
+				buckets < activeExpireCycleKeysPerLoop*20 {
+				db.expiresCursor = db.expires.Scan(db.expiresCursor, func(de *dict.Entry) {
+					keys = append(keys, append([]byte{}, de.Key().(*sds.Key).SDS().Bytes()...))
+				})
+				buckets++
+				if db.expiresCursor == 0 {
+					break
+				}
+			}
+
+			var expired int
+			for _, key := range keys {
+				if db.expireIfNeeded(key) {
+					expired++
+				}
+			}
+			total += uint64(expired)
+
+			// We can't block forever here even if there are many keys to
+			// expire. So after a given amount of iterations check the
+			// time limit.
+			if iteration&0xf == 0 && time.Since(start) > timelimit {
+				s.timelimitExit = true
+				break
+			}
+			if len(keys) != 0 && expired*100/len(keys) <= activeExpireCycleAcceptableStale {
+				break
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,29 @@
+module db
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	dict v0.0.0
+	object v0.0.0
+	pubsub v0.0.0
+	sds v0.0.0
+	util v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	dict => ../dict
+	intset => ../intset
+	listpack => ../listpack
+	lzf => ../lzf
+	object => ../object
+	pubsub => ../pubsub
+	quicklist => ../quicklist
+	rax => ../rax
+	sds => ../sds
+	skiplist => ../skiplist
+	stream => ../stream
+	util => ../util
+	zset => ../zset
+)
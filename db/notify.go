@@ -0,0 +1,237 @@
+package db
+
+// Keyspace events notification, a port of notify.c of redis.
+//
+// The operations of the keyspace notify events, like a key being
+// created, renamed or expired, and the callers modifying the keys notify
+// the events of their commands with NotifyKeyspaceEvent. Every event has
+// a class. The events are passed to the callbacks subscribed to their
+// class with SubscribeKeyspaceEvents, and published to the pubsub
+// channels when the notifications of their class are enabled:
+//
+//	__keyspace@<db>__:<key> <event>
+//	__keyevent@<db>__:<event> <key>
+
+import (
+	"strconv"
+
+	"adlist"
+)
+
+// Keyspace events notification classes.
+const (
+	// NotifyKeyspace K, publish to __keyspace@<db>__:<key>.
+	NotifyKeyspace = 1 << iota
+	// NotifyKeyevent E, publish to __keyevent@<db>__:<event>.
+	NotifyKeyevent
+	// NotifyGeneric g, generic commands like DEL, EXPIRE, RENAME.
+	NotifyGeneric
+	// NotifyString $, string commands.
+	NotifyString
+	// NotifyList l, list commands.
+	NotifyList
+	// NotifySet s, set commands.
+	NotifySet
+	// NotifyHash h, hash commands.
+	NotifyHash
+	// NotifyZset z, sorted set commands.
+	NotifyZset
+	// NotifyExpired x, keys expired.
+	NotifyExpired
+	// NotifyEvicted e, keys evicted.
+	NotifyEvicted
+	// NotifyStream t, stream commands.
+	NotifyStream
+	// NotifyNew n, new keys, excluded from NotifyAll.
+	NotifyNew
+
+	// NotifyAll A, alias for "g$lshzxet".
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet |
+		NotifyHash | NotifyZset | NotifyExpired | NotifyEvicted | NotifyStream
+)
+
+// KeyspaceEventsStringToFlags Turn a string representing notification
+// classes into an integer representing notification classes flags
+// xored. Returns false if the string contains an unknown class.
+func KeyspaceEventsStringToFlags(classes string) (int, bool) {
+	flags := 0
+	for _, c := range classes {
+		switch c {
+		case 'A':
+			flags |= NotifyAll
+		case 'g':
+			flags |= NotifyGeneric
+		case '$':
+			flags |= NotifyString
+		case 'l':
+			flags |= NotifyList
+		case 's':
+			flags |= NotifySet
+		case 'h':
+			flags |= NotifyHash
+		case 'z':
+			flags |= NotifyZset
+		case 'x':
+			flags |= NotifyExpired
+		case 'e':
+			flags |= NotifyEvicted
+		case 't':
+			flags |= NotifyStream
+		case 'n':
+			flags |= NotifyNew
+		case 'K':
+			flags |= NotifyKeyspace
+		case 'E':
+			flags |= NotifyKeyevent
+		default:
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// KeyspaceEventsFlagsToString This function does exactly the reverse of
+// what KeyspaceEventsStringToFlags() does: given an input integer
+// composed of flags, returns the string representation of the classes,
+// using the smallest number of characters needed.
+func KeyspaceEventsFlagsToString(flags int) string {
+	var res []byte
+	if flags&NotifyAll == NotifyAll {
+		res = append(res, 'A')
+	} else {
+		if flags&NotifyGeneric != 0 {
+			res = append(res, 'g')
+		}
+		if flags&NotifyString != 0 {
+			res = append(res, '$')
+		}
+		if flags&NotifyList != 0 {
+			res = append(res, 'l')
+		}
+		if flags&NotifySet != 0 {
+			res = append(res, 's')
+		}
+		if flags&NotifyHash != 0 {
+			res = append(res, 'h')
+		}
+		if flags&NotifyZset != 0 {
+			res = append(res, 'z')
+		}
+		if flags&NotifyExpired != 0 {
+			res = append(res, 'x')
+		}
+		if flags&NotifyEvicted != 0 {
+			res = append(res, 'e')
+		}
+		if flags&NotifyStream != 0 {
+			res = append(res, 't')
+		}
+	}
+	if flags&NotifyKeyspace != 0 {
+		res = append(res, 'K')
+	}
+	if flags&NotifyKeyevent != 0 {
+		res = append(res, 'E')
+	}
+	if flags&NotifyNew != 0 {
+		res = append(res, 'n')
+	}
+	return string(res)
+}
+
+// KeyspaceEventFunc a callback of the keyspace events: 'class' is the
+// class of the event, 'event' its name, like "del" or "expired", 'key'
+// the key and 'dbid' the database of the key.
+type KeyspaceEventFunc func(class int, event string, key []byte, dbid int)
+
+// KeyspaceSubscriber a callback subscribed to keyspace events.
+type KeyspaceSubscriber struct {
+	classes int
+	fn      KeyspaceEventFunc
+	// The callback is running: the events notified by the callback are
+	// not passed to it again.
+	active bool
+	node   *adlist.Node
+}
+
+// Value implements adlist.Value.
+func (ks *KeyspaceSubscriber) Value() {}
+
+// SubscribeKeyspaceEvents Call 'fn' for every keyspace event of the
+// 'classes', until UnsubscribeKeyspaceEvents is called. The callbacks
+// get the events whether their notification is enabled or not. They
+// are called synchronously, by the operation notifying the event.
+func (s *Server) SubscribeKeyspaceEvents(classes int, fn KeyspaceEventFunc) *KeyspaceSubscriber {
+	ks := &KeyspaceSubscriber{
+		classes: classes,
+		fn:      fn,
+	}
+	s.keyspaceSubscribers.AddNodeTail(ks)
+	ks.node = s.keyspaceSubscribers.Last()
+	return ks
+}
+
+// UnsubscribeKeyspaceEvents Stop calling the callback of the
+// subscriber.
+func (s *Server) UnsubscribeKeyspaceEvents(ks *KeyspaceSubscriber) {
+	if ks.node == nil {
+		return
+	}
+	s.keyspaceSubscribers.DelNode(ks.node)
+	ks.node = nil
+}
+
+// NotifyKeyspaceEvents Return the classes of the keyspace events
+// published to the pubsub channels.
+func (s *Server) NotifyKeyspaceEvents() int {
+	return s.notifyKeyspaceEvents
+}
+
+// SetNotifyKeyspaceEvents Set the classes of the keyspace events
+// published to the pubsub channels, like notify-keyspace-events.
+func (s *Server) SetNotifyKeyspaceEvents(classes int) {
+	s.notifyKeyspaceEvents = classes
+}
+
+// NotifyKeyspaceEvent The API provided to the rest of the server core
+// is a simple function:
+//
+// 'class' is the class of the event, 'event' is a string representing
+// the event name, 'key' is the key the event is about.
+func (db *DB) NotifyKeyspaceEvent(class int, event string, key []byte) {
+	db.srv.notifyKeyspaceEvent(class, event, key, db.id)
+}
+
+// notifyKeyspaceEvent Pass the event to the callbacks, and publish it
+// if the notifications of its class are enabled.
+func (s *Server) notifyKeyspaceEvent(class int, event string, key []byte, dbid int) {
+	// If any callback is interested in this event, call it. The
+	// callbacks may unsubscribe while iterating.
+	iter := s.keyspaceSubscribers.Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		ks := ln.Value().(*KeyspaceSubscriber)
+		if ks.classes&class != 0 && !ks.active {
+			ks.active = true
+			ks.fn(class, event, key, dbid)
+			ks.active = false
+		}
+	}
+
+	// If notifications for this class of events are off, return ASAP.
+	if s.notifyKeyspaceEvents&class == 0 || s.pubsub == nil {
+		return
+	}
+
+	// __keyspace@<db>__:<key> <event> notifications.
+	if s.notifyKeyspaceEvents&NotifyKeyspace != 0 {
+		channel := []byte("__keyspace@" + strconv.Itoa(dbid) + "__:")
+		channel = append(channel, key...)
+		s.pubsub.Publish(channel, []byte(event))
+	}
+
+	// __keyevent@<db>__:<event> <key> notifications.
+	if s.notifyKeyspaceEvents&NotifyKeyevent != 0 {
+		channel := []byte("__keyevent@" + strconv.Itoa(dbid) + "__:" + event)
+		s.pubsub.Publish(channel, key)
+	}
+}
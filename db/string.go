@@ -0,0 +1,57 @@
+package db
+
+import (
+	"object"
+)
+
+// Flags of Set.
+const (
+	// SetNX Set if key not exists.
+	SetNX = 1 << 0
+	// SetXX Set if key exists.
+	SetXX = 1 << 1
+	// SetKeepTTL Keep the TTL of the key.
+	SetKeepTTL = 1 << 2
+)
+
+// Get Return the value of the string key, like GET, nil if the key
+// doesn't exist.
+func (db *DB) Get(key []byte) ([]byte, error) {
+	o, err := db.LookupType(key, object.TypeString)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	return append([]byte{}, object.StringBytes(o)...), nil
+}
+
+// Set the string value of the key, like SET, with the flags SetNX,
+// SetXX and SetKeepTTL. When 'when' is not 0 the key expires at this unix
+// time in milliseconds, like SET PXAT. The "set" event is notified, and
+// "expire" with an expire. Returns false if the key was not set because
+// of the NX or XX condition.
+func (db *DB) Set(key, value []byte, flags int, when int64) (bool, error) {
+	if flags&SetNX != 0 && flags&SetXX != 0 ||
+		flags&SetKeepTTL != 0 && when != 0 || when < 0 {
+		return false, ErrSyntax
+	}
+
+	found := db.Lookup(key) != nil
+	if flags&SetNX != 0 && found || flags&SetXX != 0 && !found {
+		return false, nil
+	}
+
+	// An expire in the past deletes the key.
+	if when != 0 && when <= db.mstime() {
+		db.Delete(key)
+		return true, nil
+	}
+
+	o := object.TryEncoding(object.CreateString(value))
+	db.SetKey(key, o, flags&SetKeepTTL != 0)
+	o.DecrRefCount()
+	db.NotifyKeyspaceEvent(NotifyString, "set", key)
+	if when != 0 {
+		db.SetExpire(key, when)
+	}
+	return true, nil
+}
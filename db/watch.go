@@ -0,0 +1,202 @@
+package db
+
+// The watched keys of the optimistic locking of the MULTI/EXEC
+// transactions, a port of the WATCH part of multi.c of redis.
+//
+// Every database maps the watched keys to the list of their watchers, so
+// that the watchers can be touched when a key is modified: every write
+// path of the database signals the modified keys, and the callers
+// modifying a value in place, like a list push, signal it with
+// SignalModifiedKey.
+
+import (
+	"adlist"
+	"dict"
+	"sds"
+)
+
+// Watcher a watcher of keys, like a client calling WATCH.
+type Watcher interface {
+	// Touched Called when a key watched by the watcher is modified, once
+	// for every watched key.
+	Touched(wk *WatchedKey)
+}
+
+// WatchedKey a key watched by a watcher, in the list of the watchers of
+// the key.
+type WatchedKey struct {
+	db      *DB
+	key     []byte
+	watcher Watcher
+	node    *adlist.Node
+	// The key was already logically expired when WATCH was called.
+	expired bool
+}
+
+// Value implements adlist.Value.
+func (wk *WatchedKey) Value() {}
+
+// DB Return the database of the key.
+func (wk *WatchedKey) DB() *DB {
+	return wk.db
+}
+
+// Key Return the watched key.
+func (wk *WatchedKey) Key() []byte {
+	return wk.key
+}
+
+// Watcher Return the watcher of the key.
+func (wk *WatchedKey) Watcher() Watcher {
+	return wk.watcher
+}
+
+// watchers the list of the watchers of a key, the *WatchedKey, as a
+// dict.Value.
+type watchers struct {
+	*adlist.List
+}
+
+// Dup implements dict.Value.
+func (w watchers) Dup() dict.Value {
+	return w
+}
+
+// Destructor implements dict.Value.
+func (w watchers) Destructor() {}
+
+// Watch the key with the watcher, until Unwatch is called. A watcher
+// should not watch the same key twice.
+func (db *DB) Watch(key []byte, w Watcher) *WatchedKey {
+	// This key is not already watched in this DB. Let's add it
+	var clients watchers
+	if de := db.watchedKeys.Find(keyOf(key)); de != nil {
+		clients = de.Value().(watchers)
+	} else {
+		clients = watchers{adlist.ListCreate()}
+		db.watchedKeys.Add(keyOf(key), clients)
+	}
+
+	wk := &WatchedKey{
+		db:      db,
+		key:     append([]byte{}, key...),
+		watcher: w,
+		expired: db.KeyIsExpired(key),
+	}
+	clients.AddNodeTail(wk)
+	wk.node = clients.Last()
+	return wk
+}
+
+// Unwatch Stop watching the key.
+func (wk *WatchedKey) Unwatch() {
+	if wk.node == nil {
+		return
+	}
+	db := wk.db
+	de := db.watchedKeys.Find(keyOf(wk.key))
+	if de == nil {
+		panic("db: watched key not found")
+	}
+	clients := de.Value().(watchers)
+	clients.DelNode(wk.node)
+	wk.node = nil
+	// Kill the entry at all if this was the only client
+	if clients.Len() == 0 {
+		db.watchedKeys.Delete(keyOf(wk.key))
+	}
+}
+
+// IsExpired Return true if the key logically expired while being
+// watched, not being deleted yet. The keys already expired when WATCH
+// was called are not considered.
+func (wk *WatchedKey) IsExpired() bool {
+	return !wk.expired && wk.db.KeyIsExpired(wk.key)
+}
+
+// WatchedKeysSize Return the number of keys watched in the database.
+func (db *DB) WatchedKeysSize() uint64 {
+	return db.watchedKeys.Size()
+}
+
+// SignalModifiedKey "Touch" a key, so that if this key is being WATCHed
+// by some watcher, the next EXEC will fail: the watchers of the key are
+// touched.
+func (db *DB) SignalModifiedKey(key []byte) {
+	if db.watchedKeys.Size() == 0 {
+		return
+	}
+	de := db.watchedKeys.Find(keyOf(key))
+	if de == nil {
+		return
+	}
+
+	// Check if we are already watching for this key. The watchers are
+	// collected first, as a watcher usually unwatches its keys when
+	// touched.
+	var touched []*WatchedKey
+	iter := de.Value().(watchers).Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		wk := ln.Value().(*WatchedKey)
+		if wk.expired {
+			// The key was already expired when WATCH was called.
+			if db.dict.Find(keyOf(key)) == nil {
+				// Already expired key is deleted, so logically no
+				// change. Clear the flag. Deleted keys are not flagged
+				// as expired.
+				wk.expired = false
+				continue
+			}
+		}
+		touched = append(touched, wk)
+	}
+	for _, wk := range touched {
+		wk.watcher.Touched(wk)
+	}
+}
+
+// touchAllWatchedKeys Touch the watched keys of the database 'emptied',
+// being flushed or swapped with the database 'replacedWith' if not nil:
+// the keys existing in either database are touched.
+func touchAllWatchedKeys(emptied, replacedWith *DB) {
+	if emptied.watchedKeys.Size() == 0 {
+		return
+	}
+
+	var touched []*WatchedKey
+	it := emptied.watchedKeys.GetIterator()
+	for de := it.Next(); de != nil; de = it.Next() {
+		key := de.Key().(*sds.Key)
+		existsInEmptied := emptied.dict.Find(key) != nil
+		existsInReplaced := replacedWith != nil && replacedWith.dict.Find(key) != nil
+		if !existsInEmptied && !existsInReplaced {
+			continue
+		}
+
+		iter := de.Value().(watchers).Rewind()
+		for ln := iter.Next(); ln != nil; ln = iter.Next() {
+			wk := ln.Value().(*WatchedKey)
+			if wk.expired {
+				if !existsInReplaced {
+					// Expired key now deleted. No logical change. Clear
+					// the flag. Deleted keys are not flagged as expired.
+					wk.expired = false
+					continue
+				} else if replacedWith.KeyIsExpired(wk.key) {
+					// Expired key remains expired.
+					continue
+				}
+			} else if !existsInEmptied && replacedWith.KeyIsExpired(wk.key) {
+				// Non-existing key is replaced with an expired key.
+				wk.expired = true
+				continue
+			}
+			touched = append(touched, wk)
+		}
+	}
+	it.Release()
+
+	for _, wk := range touched {
+		wk.watcher.Touched(wk)
+	}
+}
@@ -3,6 +3,7 @@ package dict
 import (
 	"errors"
 	"math"
+	"time"
 )
 
 // Error
@@ -60,6 +61,16 @@ type Entry struct {
 	next *Entry
 }
 
+// Key Return the key of the entry.
+func (e *Entry) Key() Key {
+	return e.key
+}
+
+// Value Return the value of the entry.
+func (e *Entry) Value() Value {
+	return e.value
+}
+
 // dictht This is our hash table structure. Every dictionary has two of this as we
 // implement incremental rehashing, for the old to the new table.
 type dictht struct {
@@ -79,6 +90,9 @@ type Dict struct {
 
 	// number of iterators currently running
 	iterators uint64
+
+	// The active snapshot, nil if none.
+	snapshot *Snapshot
 }
 
 // Create a new hash tables
@@ -139,9 +153,12 @@ func (d *Dict) Replace(key Key, value Value) int {
 	 * as the previous one. In this context, think to reference counting,
 	 * you want to increment (set), and then decrement (free), and not the
 	 * reverse. */
+	if d.snapshot != nil {
+		d.snapshot.save(key.HashFunction())
+	}
 	auxentry = *existing
 	existing.setVal(value)
-	auxentry.freeVal()
+	d.freeEntry(&auxentry, false)
 	return 0
 }
 
@@ -166,9 +183,13 @@ func (d *Dict) addRaw(key Key, existing **Entry) *Entry {
 
 	// Get the index of the new element, or -1 if
 	// the element already exists.
-	if index = d.keyIndex(key, key.HashFunction(), existing); index == -1 {
+	h := key.HashFunction()
+	if index = d.keyIndex(key, h, existing); index == -1 {
 		return nil
 	}
+	if d.snapshot != nil {
+		d.snapshot.save(h)
+	}
 
 	ht := d.ht[0]
 	if d.isRehashing() {
@@ -189,7 +210,12 @@ func (e *Entry) setKey(key Key) {
 	e.key = key.Dup()
 }
 
+// setVal A nil value is allowed, for dicts used as sets.
 func (e *Entry) setVal(val Value) {
+	if val == nil {
+		e.value = nil
+		return
+	}
 	e.value = val.Dup()
 }
 
@@ -198,7 +224,26 @@ func (e *Entry) freeKey() {
 }
 
 func (e *Entry) freeVal() {
-	e.value.Destructor()
+	if e.value != nil {
+		e.value.Destructor()
+	}
+}
+
+// freeEntry Destroy the value of the entry, and the key if 'key' is set.
+// While a snapshot is active, they are destroyed once it's released.
+func (d *Dict) freeEntry(e *Entry, key bool) {
+	if d.snapshot != nil {
+		if key {
+			d.snapshot.free(e.key, e.value)
+		} else {
+			d.snapshot.free(nil, e.value)
+		}
+		return
+	}
+	if key {
+		e.freeKey()
+	}
+	e.freeVal()
 }
 
 func (d *Dict) keyIndex(key Key, hash uint64, existing **Entry) int64 {
@@ -244,7 +289,7 @@ func (d *Dict) Find(key Key) *Entry {
 	var h, idx, table uint64
 
 	//  dict is empty
-	if d.size() == 0 {
+	if d.Slots() == 0 {
 		return nil
 	}
 
@@ -314,8 +359,18 @@ func (d *Dict) Resize() int {
 	return d.expand(minimal)
 }
 
-// Close Clear & Release the hash table
+// Expand the hash table to hold at least 'size' elements, so that adding
+// that many elements doesn't trigger a rehash. Returns DictErr if the
+// dict is rehashing, or if 'size' is smaller than the number of elements.
+func (d *Dict) Expand(size uint64) int {
+	return d.expand(size)
+}
+
+// Close Clear & Release the hash table. The active snapshot is released.
 func (d *Dict) Close() {
+	if d.snapshot != nil {
+		d.snapshot.Release()
+	}
 	d.clear(d.ht[0])
 	d.clear(d.ht[1])
 	d = nil
@@ -330,7 +385,7 @@ func (d *Dict) genericDelete(key Key, nofree int) *Entry {
 	var table int
 
 	//  dict is empty
-	if d.size() == 0 {
+	if d.Slots() == 0 {
 		return nil
 	}
 
@@ -339,6 +394,9 @@ func (d *Dict) genericDelete(key Key, nofree int) *Entry {
 	}
 
 	h = key.HashFunction()
+	if d.snapshot != nil {
+		d.snapshot.save(h)
+	}
 
 	for table = 0; table <= 1; table++ {
 		idx = h & d.ht[table].sizemask
@@ -356,8 +414,7 @@ func (d *Dict) genericDelete(key Key, nofree int) *Entry {
 				}
 
 				if nofree == 0 {
-					he.freeKey()
-					he.freeVal()
+					d.freeEntry(he, true)
 				}
 				d.ht[table].used--
 				return he
@@ -371,14 +428,25 @@ func (d *Dict) genericDelete(key Key, nofree int) *Entry {
 	return nil
 }
 
-func (d *Dict) size() uint64 {
+// Slots Return the number of buckets of the hash tables.
+func (d *Dict) Slots() uint64 {
 	return d.ht[0].size + d.ht[1].size
 }
 
+// Size Return the number of elements of the dict.
+func (d *Dict) Size() uint64 {
+	return d.ht[0].used + d.ht[1].used
+}
+
 func (d *Dict) isRehashing() bool {
 	return d.rehashidx != -1
 }
 
+// IsRehashing Return true if an incremental rehashing is in progress.
+func (d *Dict) IsRehashing() bool {
+	return d.isRehashing()
+}
+
 // This function performs just a step of rehashing, and only if there are
 // no safe iterators bound to our hash table. When we have iterators in the
 // middle of a rehashing we can't mess with the two hash tables otherwise
@@ -452,6 +520,26 @@ func (d *Dict) rehash(n int) int {
 	return 1
 }
 
+// RehashMilliseconds Rehash in ms+"delta" milliseconds. The value of
+// "delta" is larger than 0, and is smaller than 1 in most cases. The exact
+// upper bound depends on the running time of rehash(100). Returns the
+// number of rehash steps performed.
+func (d *Dict) RehashMilliseconds(ms int) int {
+	if d.iterators > 0 {
+		return 0
+	}
+
+	start := time.Now()
+	rehashes := 0
+	for d.rehash(100) == 1 {
+		rehashes += 100
+		if time.Since(start) > time.Duration(ms)*time.Millisecond {
+			break
+		}
+	}
+	return rehashes
+}
+
 // Expand the hash table if needed
 func (d *Dict) expandIfNeeded() int {
 	// Incremental rehashing already in progress.
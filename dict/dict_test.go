@@ -112,3 +112,37 @@ func TestDict(t *testing.T) {
 	}
 
 }
+
+func TestRehashMilliseconds(t *testing.T) {
+	d := Create()
+	for i := 0; i < 1000; i++ {
+		d.Add(intKey(i), nil)
+	}
+	d.RehashMilliseconds(100)
+	if d.IsRehashing() {
+		t.Fatal("rehashing not completed")
+	}
+
+	if d.Expand(1<<16) != DictOK || !d.IsRehashing() {
+		t.Fatal("expand")
+	}
+	// No rehashing while a safe iterator is running.
+	it := d.GetSafeIterator()
+	it.Next()
+	if d.RehashMilliseconds(100) != 0 {
+		t.Fatal("rehash with a safe iterator")
+	}
+	it.Release()
+
+	if d.RehashMilliseconds(100) == 0 || d.IsRehashing() {
+		t.Fatal("rehash")
+	}
+	if d.Slots() != 1<<16 || d.Size() != 1000 {
+		t.Fatalf("slots %d size %d", d.Slots(), d.Size())
+	}
+	for i := 0; i < 1000; i++ {
+		if d.Find(intKey(i)) == nil {
+			t.Fatalf("%d not found", i)
+		}
+	}
+}
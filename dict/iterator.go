@@ -0,0 +1,129 @@
+package dict
+
+// Iterator dict iterator.
+//
+// If safe is set to true this is a safe iterator, that means, you can
+// call Add, Find, and other functions against the dictionary even while
+// iterating. Otherwise it is a non safe iterator, and only Next should be
+// called while iterating.
+type Iterator struct {
+	d     *Dict
+	index int64
+	table int
+	safe  bool
+
+	entry     *Entry
+	nextEntry *Entry
+
+	// unsafe iterator fingerprint for misuse detection.
+	fingerprint uint64
+}
+
+// GetIterator Return a non safe iterator: the dict must not be modified
+// while iterating.
+func (d *Dict) GetIterator() *Iterator {
+	return &Iterator{
+		d:     d,
+		index: -1,
+	}
+}
+
+// GetSafeIterator Return a safe iterator: the rehashing is paused while
+// iterating, so that the entries can be added, found or deleted while
+// iterating. The iterator must be released with Release.
+func (d *Dict) GetSafeIterator() *Iterator {
+	it := d.GetIterator()
+	it.safe = true
+	return it
+}
+
+// Next Return the next entry, nil at the end of the iteration.
+func (it *Iterator) Next() *Entry {
+	for {
+		if it.entry == nil {
+			if it.index == -1 && it.table == 0 {
+				if it.safe {
+					it.d.iterators++
+				} else {
+					it.fingerprint = it.d.fingerprint()
+				}
+			}
+			it.index++
+			if uint64(it.index) >= it.d.ht[it.table].size {
+				if it.d.isRehashing() && it.table == 0 {
+					it.table++
+					it.index = 0
+					if it.d.ht[1].size == 0 {
+						break
+					}
+				} else {
+					break
+				}
+			}
+			it.entry = it.d.ht[it.table].table[it.index]
+		} else {
+			it.entry = it.nextEntry
+		}
+
+		if it.entry != nil {
+			// We need to save the 'next' here, the iterator user may
+			// delete the entry we are returning.
+			it.nextEntry = it.entry.next
+			return it.entry
+		}
+	}
+	return nil
+}
+
+// Release the iterator. For a safe iterator, the rehashing is resumed.
+// For a non safe iterator, it panics if the dict was modified while
+// iterating.
+func (it *Iterator) Release() {
+	if !(it.index == -1 && it.table == 0) {
+		if it.safe {
+			it.d.iterators--
+		} else if it.fingerprint != it.d.fingerprint() {
+			panic("dict: modified while iterating with a non safe iterator")
+		}
+	}
+	it.index, it.table = -1, 0
+	it.entry, it.nextEntry = nil, nil
+}
+
+// fingerprint A fingerprint is a 64 bit number that represents the state
+// of the dictionary at a given time, it's just a few dict properties
+// xored together. When an unsafe iterator is initialized, we get the dict
+// fingerprint, and check the fingerprint again when the iterator is
+// released. If the two fingerprints are different it means that the user
+// of the iterator performed forbidden operations against the dictionary
+// while iterating.
+func (d *Dict) fingerprint() uint64 {
+	integers := [...]uint64{
+		d.ht[0].size,
+		d.ht[0].used,
+		d.ht[1].size,
+		d.ht[1].used,
+		uint64(d.rehashidx),
+	}
+
+	// We hash N integers by summing every successive integer with the
+	// integer hashing of the previous sum. Basically:
+	//
+	// Result = hash(hash(hash(int1)+int2)+int3) ...
+	//
+	// This way the same set of integers in a different order will
+	// (likely) hash to a different number.
+	var hash uint64
+	for _, i := range integers {
+		hash += i
+		// For the hashing step we use Tomas Wang's 64 bit integer hash.
+		hash = (^hash) + (hash << 21) // hash = (hash << 21) - hash - 1;
+		hash = hash ^ (hash >> 24)
+		hash = (hash + (hash << 3)) + (hash << 8) // hash * 265
+		hash = hash ^ (hash >> 14)
+		hash = (hash + (hash << 2)) + (hash << 4) // hash * 21
+		hash = hash ^ (hash >> 28)
+		hash = hash + (hash << 31)
+	}
+	return hash
+}
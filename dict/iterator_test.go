@@ -0,0 +1,98 @@
+package dict
+
+import (
+	"testing"
+)
+
+type intKey int
+
+func (k intKey) HashFunction() uint64 { return uint64(k) * 2654435761 }
+func (k intKey) Compare(key Key) int {
+	if k == key.(intKey) {
+		return 0
+	}
+	return 1
+}
+func (k intKey) Dup() Key    { return k }
+func (k intKey) Destructor() {}
+
+func TestIterator(t *testing.T) {
+	d := Create()
+	it := d.GetIterator()
+	if it.Next() != nil {
+		t.Fatal("Next of empty dict")
+	}
+	it.Release()
+
+	for i := 0; i < 1000; i++ {
+		d.Add(intKey(i), nil)
+	}
+	if d.Size() != 1000 {
+		t.Fatalf("Size %d", d.Size())
+	}
+
+	// Every element is returned once, even while rehashing.
+	if !d.isRehashing() {
+		d.Add(intKey(1000), nil)
+		for i := 1001; !d.isRehashing(); i++ {
+			d.Add(intKey(i), nil)
+		}
+	}
+	seen := make(map[intKey]bool)
+	it = d.GetIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		k := e.Key().(intKey)
+		if seen[k] {
+			t.Fatalf("key %d returned twice", k)
+		}
+		seen[k] = true
+	}
+	it.Release()
+	if uint64(len(seen)) != d.Size() {
+		t.Fatalf("iterated %d of %d", len(seen), d.Size())
+	}
+
+	// The safe iterator allows to delete while iterating, and pauses
+	// the rehashing.
+	it = d.GetSafeIterator()
+	rehashidx := d.rehashidx
+	for e := it.Next(); e != nil; e = it.Next() {
+		if k := e.Key().(intKey); k%2 == 0 {
+			d.Delete(k)
+		}
+		if d.rehashidx != rehashidx {
+			t.Fatal("rehashed while iterating")
+		}
+	}
+	it.Release()
+	if d.Size() != uint64(len(seen)/2) {
+		t.Fatalf("Size %d after deleting", d.Size())
+	}
+
+	// Modifying the dict with a non safe iterator is detected.
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic on misuse of the non safe iterator")
+		}
+	}()
+	it = d.GetIterator()
+	it.Next()
+	d.Add(intKey(-1), nil)
+	it.Release()
+}
+
+func TestExpand(t *testing.T) {
+	d := Create()
+	if d.Expand(1000) != DictOK {
+		t.Fatal("Expand")
+	}
+	for i := 0; i < 1000; i++ {
+		d.Add(intKey(i), nil)
+	}
+	if d.isRehashing() || d.ht[0].size != 1024 {
+		t.Fatalf("size %d rehashing %v", d.ht[0].size, d.isRehashing())
+	}
+	if d.Expand(10) != DictErr {
+		t.Fatal("Expand smaller than the elements")
+	}
+}
@@ -0,0 +1,135 @@
+package dict
+
+import (
+	"math/bits"
+	"math/rand"
+)
+
+// Scan is used to iterate over the elements of a dictionary.
+//
+// Iterating works the following way:
+//
+//  1. Initially you call the function using a cursor (v) value of 0.
+//  2. The function performs one step of the iteration, and returns the
+//     new cursor value you must use in the next call.
+//  3. When the returned cursor is 0, the iteration is complete.
+//
+// The function guarantees all elements present in the dictionary get
+// returned between the start and end of the iteration. However it is
+// possible some elements get returned multiple times.
+//
+// For each element returned, the callback 'fn' is called.
+//
+// The cursor is incremented with its bits reversed, that is the high
+// order bits of the cursor are incremented first: this way the buckets
+// already visited are not visited again when the table grows or shrinks,
+// as the buckets of a table of size 2^N are split or merged in the table
+// of the new size. While rehashing, the buckets of the smaller table are
+// visited along with all the buckets they expand to in the larger table.
+func (d *Dict) Scan(v uint64, fn func(de *Entry)) uint64 {
+	if d.Size() == 0 {
+		return 0
+	}
+
+	// This is needed in case the scan callback tries to do Find or
+	// alike.
+	d.iterators++
+	defer func() { d.iterators-- }()
+
+	emit := func(t *dictht, idx uint64) {
+		de := t.table[idx]
+		for de != nil {
+			next := de.next
+			fn(de)
+			de = next
+		}
+	}
+
+	if !d.isRehashing() {
+		t0 := d.ht[0]
+		m0 := t0.sizemask
+
+		// Emit entries at cursor
+		emit(t0, v&m0)
+
+		// Set unmasked bits so incrementing the reversed cursor operates
+		// on the masked bits
+		v |= ^m0
+
+		// Increment the reverse cursor
+		v = bits.Reverse64(v)
+		v++
+		return bits.Reverse64(v)
+	}
+
+	t0, t1 := d.ht[0], d.ht[1]
+	// Make sure t0 is the smaller and t1 is the bigger table
+	if t0.size > t1.size {
+		t0, t1 = t1, t0
+	}
+	m0, m1 := t0.sizemask, t1.sizemask
+
+	// Emit entries at cursor
+	emit(t0, v&m0)
+
+	// Iterate over indices in larger table that are the expansion of the
+	// index pointed to by the cursor in the smaller table
+	for {
+		// Emit entries at cursor
+		emit(t1, v&m1)
+
+		// Increment the reverse cursor not covered by the smaller mask.
+		v |= ^m1
+		v = bits.Reverse64(v)
+		v++
+		v = bits.Reverse64(v)
+
+		// Continue while bits covered by mask difference is non-zero
+		if v&(m0^m1) == 0 {
+			break
+		}
+	}
+	return v
+}
+
+// GetRandomKey Return a random entry from the hash table, nil if the dict
+// is empty. Useful to implement randomized algorithms.
+func (d *Dict) GetRandomKey() *Entry {
+	if d.Size() == 0 {
+		return nil
+	}
+	if d.isRehashing() {
+		d.rehashStep()
+	}
+
+	var he *Entry
+	if d.isRehashing() {
+		// We are sure there are no elements in indexes from 0 to
+		// rehashidx-1
+		for he == nil {
+			h := uint64(d.rehashidx) + rand.Uint64()%(d.Slots()-uint64(d.rehashidx))
+			if h >= d.ht[0].size {
+				he = d.ht[1].table[h-d.ht[0].size]
+			} else {
+				he = d.ht[0].table[h]
+			}
+		}
+	} else {
+		for he == nil {
+			h := rand.Uint64() & d.ht[0].sizemask
+			he = d.ht[0].table[h]
+		}
+	}
+
+	// Now we found a non empty bucket, but it is a linked list and we
+	// need to get a random element from the list. The only sane way to
+	// do so is counting the elements and select a random index.
+	listlen := 0
+	for orighe := he; orighe != nil; orighe = orighe.next {
+		listlen++
+	}
+	for listele := rand.Intn(listlen); listele > 0; listele-- {
+		he = he.next
+	}
+	return he
+}
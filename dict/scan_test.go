@@ -0,0 +1,80 @@
+package dict
+
+import (
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	d := Create()
+	if d.Scan(0, func(*Entry) { t.Fatal("empty dict") }) != 0 {
+		t.Fatal("cursor of empty dict")
+	}
+
+	for i := 0; i < 1000; i++ {
+		d.Add(intKey(i), nil)
+	}
+
+	// Every element present for the whole scan is returned, while the
+	// dict grows and rehashes.
+	seen := make(map[intKey]bool)
+	cursor, next := uint64(0), 1000
+	for {
+		cursor = d.Scan(cursor, func(de *Entry) {
+			seen[de.Key().(intKey)] = true
+		})
+		if next < 2000 {
+			d.Add(intKey(next), nil)
+			next++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !seen[intKey(i)] {
+			t.Fatalf("%d not returned", i)
+		}
+	}
+
+	// And while it shrinks.
+	for i := 1000; i < next; i++ {
+		d.Delete(intKey(i))
+	}
+	seen = make(map[intKey]bool)
+	cursor, deleted := 0, 999
+	for {
+		cursor = d.Scan(cursor, func(de *Entry) {
+			seen[de.Key().(intKey)] = true
+		})
+		if deleted > 500 {
+			d.Delete(intKey(deleted))
+			deleted--
+		}
+		d.Resize()
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i <= deleted; i++ {
+		if !seen[intKey(i)] {
+			t.Fatalf("%d not returned", i)
+		}
+	}
+}
+
+func TestGetRandomKey(t *testing.T) {
+	d := Create()
+	if d.GetRandomKey() != nil {
+		t.Fatal("random key of empty dict")
+	}
+	for i := 0; i < 100; i++ {
+		d.Add(intKey(i), nil)
+	}
+	seen := make(map[intKey]int)
+	for i := 0; i < 10000; i++ {
+		seen[d.GetRandomKey().Key().(intKey)]++
+	}
+	if len(seen) != 100 {
+		t.Fatalf("%d keys returned", len(seen))
+	}
+}
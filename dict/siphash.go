@@ -0,0 +1,115 @@
+package dict
+
+// Hash functions for the keys, the same SipHash 1-2 used by redis.
+// SipHash is a pseudorandom function keyed by a 128 bit seed, so that the
+// distribution of the keys in the buckets can't be guessed by an attacker.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math/bits"
+)
+
+var hashFunctionSeed [16]byte
+
+func init() {
+	rand.Read(hashFunctionSeed[:])
+}
+
+// SetHashFunctionSeed Set the seed of the hash functions.
+func SetHashFunctionSeed(seed [16]byte) {
+	hashFunctionSeed = seed
+}
+
+// GetHashFunctionSeed Return the seed of the hash functions.
+func GetHashFunctionSeed() [16]byte {
+	return hashFunctionSeed
+}
+
+// GenHashFunction The default hash function for binary safe keys.
+func GenHashFunction(key []byte) uint64 {
+	return siphash(key, &hashFunctionSeed, false, 1, 2)
+}
+
+// GenCaseHashFunction Case insensitive hash function (ASCII only).
+func GenCaseHashFunction(key []byte) uint64 {
+	return siphash(key, &hashFunctionSeed, true, 1, 2)
+}
+
+func toLower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + ('a' - 'A')
+	}
+	return b
+}
+
+// siphash SipHash-c-d of the input, lower casing it when 'nocase' is set.
+func siphash(in []byte, k *[16]byte, nocase bool, c, d int) uint64 {
+	k0 := binary.LittleEndian.Uint64(k[0:])
+	k1 := binary.LittleEndian.Uint64(k[8:])
+
+	v0 := uint64(0x736f6d6570736575) ^ k0
+	v1 := uint64(0x646f72616e646f6d) ^ k1
+	v2 := uint64(0x6c7967656e657261) ^ k0
+	v3 := uint64(0x7465646279746573) ^ k1
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	var block [8]byte
+	load := func(p []byte) uint64 {
+		if !nocase {
+			return binary.LittleEndian.Uint64(p)
+		}
+		for i := range block {
+			block[i] = toLower(p[i])
+		}
+		return binary.LittleEndian.Uint64(block[:])
+	}
+
+	end := len(in) - len(in)%8
+	for i := 0; i < end; i += 8 {
+		m := load(in[i:])
+		v3 ^= m
+		for j := 0; j < c; j++ {
+			round()
+		}
+		v0 ^= m
+	}
+
+	b := uint64(len(in)) << 56
+	for i := len(in) - 1; i >= end; i-- {
+		ch := in[i]
+		if nocase {
+			ch = toLower(ch)
+		}
+		b |= uint64(ch) << (8 * uint(i-end))
+	}
+
+	v3 ^= b
+	for j := 0; j < c; j++ {
+		round()
+	}
+	v0 ^= b
+
+	v2 ^= 0xff
+	for j := 0; j < d; j++ {
+		round()
+	}
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,38 @@
+package dict
+
+import (
+	"testing"
+)
+
+func TestSiphash(t *testing.T) {
+	var k [16]byte
+	for i := range k {
+		k[i] = byte(i)
+	}
+	in := make([]byte, 15)
+	for i := range in {
+		in[i] = byte(i)
+	}
+
+	// Reference vectors of SipHash-2-4.
+	tests := []struct {
+		len  int
+		hash uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{8, 0x93f5f5799a932462},
+	}
+	for _, test := range tests {
+		if h := siphash(in[:test.len], &k, false, 2, 4); h != test.hash {
+			t.Fatalf("siphash of %d bytes: %x", test.len, h)
+		}
+	}
+
+	if GenHashFunction([]byte("Hello World!")) == GenHashFunction([]byte("hello world!")) {
+		t.FailNow()
+	}
+	if GenCaseHashFunction([]byte("Hello World!")) != GenCaseHashFunction([]byte("hello world!")) {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,206 @@
+package dict
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Redis persists a point in time view of the dataset by forking a child
+// process, relying on the copy-on-write of the memory pages: the parent
+// keeps serving the writes while the child saves the data. As Go can't
+// fork, a Snapshot copies the buckets on write instead.
+//
+// While a snapshot is active the rehashing is paused, like with a safe
+// iterator, so that the entries don't move between the buckets. Before
+// a bucket the snapshot didn't read yet is modified by Add, Replace or
+// Delete, its entries are copied, and the snapshot reads the copy instead
+// of the live bucket. The keys and values deleted or replaced in the
+// meantime are destroyed once the snapshot is released, as the copies
+// may still reference them.
+//
+// The values are shared with the dict: the owner must not modify in
+// place the values the snapshot may still read.
+
+// Error
+var (
+	// ErrSnapshotInProgress Only one snapshot of a dict can be active.
+	ErrSnapshotInProgress = errors.New("dict: snapshot already in progress")
+)
+
+// snapshotEmptyVisits Max number of empty buckets visited by a Next while
+// holding the lock.
+const snapshotEmptyVisits = 100
+
+// Snapshot a point in time view of a dict, iterated by a goroutine while
+// the owner of the dict keeps modifying it.
+type Snapshot struct {
+	d *Dict
+
+	// mu protects the fields below, accessed by both the owner and the
+	// goroutine iterating the snapshot.
+	mu sync.Mutex
+	// The tables of the dict when the snapshot was taken.
+	tables [2][]*Entry
+	// Copies of the buckets modified before being read.
+	saved [2]map[uint64][]Entry
+	// The next bucket to read.
+	table int
+	index uint64
+	// The iteration is over, or the snapshot released.
+	done bool
+	// The keys and values to destroy once released.
+	freed []Entry
+	// Set to 1 once released, Next returns nil.
+	released int32
+
+	// The entries of the bucket being iterated, only accessed by the
+	// iterating goroutine.
+	bucket []Entry
+	pos    int
+}
+
+// Snapshot Take a snapshot of the dict, in O(1). The snapshot can be
+// iterated by another goroutine, while the owner of the dict keeps
+// calling Add, Replace and Delete, and must be released by the owner
+// with Release.
+func (d *Dict) Snapshot() (*Snapshot, error) {
+	if d.snapshot != nil {
+		return nil, ErrSnapshotInProgress
+	}
+
+	s := &Snapshot{d: d}
+	for table := 0; table <= 1; table++ {
+		s.tables[table] = d.ht[table].table
+		s.saved[table] = make(map[uint64][]Entry)
+	}
+	// Pause the rehashing, the entries stay in their buckets.
+	d.iterators++
+	d.snapshot = s
+	return s, nil
+}
+
+// Next Return the next entry of the snapshot, nil at the end of the
+// iteration. The entry is a copy, the dict isn't accessed. It's called by
+// the goroutine iterating the snapshot.
+func (s *Snapshot) Next() *Entry {
+	if atomic.LoadInt32(&s.released) == 1 {
+		return nil
+	}
+	for s.pos >= len(s.bucket) {
+		if !s.nextBucket() {
+			return nil
+		}
+	}
+	de := &s.bucket[s.pos]
+	s.pos++
+	return de
+}
+
+// nextBucket Load the entries of the next non empty bucket, returning
+// false at the end of the iteration.
+func (s *Snapshot) nextBucket() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bucket, s.pos = nil, 0
+	for emptyVisits := snapshotEmptyVisits; emptyVisits > 0; emptyVisits-- {
+		if s.done {
+			return false
+		}
+		if s.index >= uint64(len(s.tables[s.table])) {
+			if s.table == 1 {
+				s.done = true
+				return false
+			}
+			s.table++
+			s.index = 0
+			continue
+		}
+
+		idx := s.index
+		s.index++
+		if bucket, ok := s.saved[s.table][idx]; ok {
+			delete(s.saved[s.table], idx)
+			s.bucket = bucket
+		} else {
+			s.bucket = copyBucket(s.tables[s.table][idx])
+		}
+		if len(s.bucket) > 0 {
+			break
+		}
+	}
+	return true
+}
+
+// Release the snapshot, called by the owner of the dict once the
+// iteration is over: the rehashing is resumed, and the keys and values
+// deleted or replaced meanwhile are destroyed. Releasing the snapshot
+// before the end of the iteration aborts it, Next returns nil, and the
+// entries already returned must no longer be used.
+func (s *Snapshot) Release() {
+	d := s.d
+	if d == nil || d.snapshot != s {
+		return
+	}
+	d.snapshot = nil
+	d.iterators--
+	atomic.StoreInt32(&s.released, 1)
+
+	s.mu.Lock()
+	s.done = true
+	freed := s.freed
+	s.tables = [2][]*Entry{}
+	s.saved = [2]map[uint64][]Entry{}
+	s.freed = nil
+	s.mu.Unlock()
+
+	for i := range freed {
+		if freed[i].key != nil {
+			freed[i].freeKey()
+		}
+		freed[i].freeVal()
+	}
+}
+
+// save Copy the buckets of the key with hash 'h' that the snapshot
+// didn't read yet, before they are modified.
+func (s *Snapshot) save(h uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+	for table := 0; table <= 1; table++ {
+		size := uint64(len(s.tables[table]))
+		if size == 0 {
+			continue
+		}
+		// The size of the tables is a power of two.
+		idx := h & (size - 1)
+		if table < s.table || (table == s.table && idx < s.index) {
+			continue
+		}
+		if _, ok := s.saved[table][idx]; !ok {
+			s.saved[table][idx] = copyBucket(s.tables[table][idx])
+		}
+	}
+}
+
+// free Destroy the key, if not nil, and the value once the snapshot is
+// released.
+func (s *Snapshot) free(key Key, value Value) {
+	s.mu.Lock()
+	s.freed = append(s.freed, Entry{key: key, value: value})
+	s.mu.Unlock()
+}
+
+// copyBucket Return a copy of the entries of the bucket.
+func copyBucket(he *Entry) []Entry {
+	var bucket []Entry
+	for ; he != nil; he = he.next {
+		bucket = append(bucket, Entry{key: he.key, value: he.value})
+	}
+	return bucket
+}
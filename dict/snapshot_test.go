@@ -0,0 +1,104 @@
+package dict
+
+import (
+	"testing"
+)
+
+type snapValue struct {
+	n         int
+	destroyed bool
+}
+
+func (v *snapValue) Dup() Value  { return v }
+func (v *snapValue) Destructor() { v.destroyed = true }
+
+func TestSnapshot(t *testing.T) {
+	d := Create()
+	values := make(map[int]*snapValue)
+	for i := 0; i < 1000; i++ {
+		values[i] = &snapValue{n: i}
+		d.Add(intKey(i), values[i])
+	}
+
+	s, err := d.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Snapshot(); err != ErrSnapshotInProgress {
+		t.Fatalf("second Snapshot: %v", err)
+	}
+	rehashidx := d.rehashidx
+
+	// Iterate the snapshot while the dict is modified.
+	done := make(chan map[int]int)
+	go func() {
+		seen := make(map[int]int)
+		for e := s.Next(); e != nil; e = s.Next() {
+			seen[int(e.Key().(intKey))] = e.Value().(*snapValue).n
+		}
+		done <- seen
+	}()
+	for i := 0; i < 1000; i++ {
+		switch {
+		case i%2 == 0:
+			d.Delete(intKey(i))
+		case i%3 == 0:
+			d.Replace(intKey(i), &snapValue{n: -i})
+		}
+		d.Add(intKey(1000+i), &snapValue{n: 1000 + i})
+	}
+	seen := <-done
+
+	if len(seen) != 1000 {
+		t.Fatalf("iterated %d of 1000", len(seen))
+	}
+	for i := 0; i < 1000; i++ {
+		if n, ok := seen[i]; !ok || n != i {
+			t.Fatalf("key %d: %d %v", i, n, ok)
+		}
+	}
+	if d.Size() != 1500 {
+		t.Fatalf("Size %d", d.Size())
+	}
+	if d.rehashidx != rehashidx {
+		t.Fatalf("rehashed while the snapshot is active: %d", d.rehashidx)
+	}
+
+	// The deleted and replaced values are destroyed once released.
+	if values[0].destroyed || values[3].destroyed {
+		t.Fatal("destroyed while the snapshot is active")
+	}
+	s.Release()
+	if !values[0].destroyed || !values[3].destroyed || values[1].destroyed {
+		t.Fatal("not destroyed once released")
+	}
+	if s.Next() != nil {
+		t.Fatal("Next after Release")
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := d.FetchValue(intKey(1000 + i)).(*snapValue); !ok || v.n != 1000+i {
+			t.Fatalf("key %d", 1000+i)
+		}
+	}
+	d.RehashMilliseconds(100)
+	if d.IsRehashing() {
+		t.Fatal("rehashing not resumed")
+	}
+
+	// Releasing the snapshot aborts the iteration.
+	s, err = d.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Next() == nil {
+		t.Fatal("Next of the snapshot")
+	}
+	s.Release()
+	if s.Next() != nil {
+		t.Fatal("Next after Release")
+	}
+	if _, err := d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+}
@@ -0,0 +1,335 @@
+package geo
+
+// Geo commands, a port of geo.c of redis.
+//
+// The locations are stored in a zset.Zset, the score of every member
+// being its 52 bits geohash, so that the points of a geohash cell are a
+// contiguous range of scores.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"skiplist"
+	"zset"
+)
+
+// Error
+var (
+	// ErrUnit the unit is not supported.
+	ErrUnit = errors.New("unsupported unit provided. please use M, KM, FT, MI")
+	// ErrMember the member of FROMMEMBER is not in the zset.
+	ErrMember = errors.New("could not decode requested zset member")
+	// ErrAnyCount ANY without COUNT.
+	ErrAnyCount = errors.New("the ANY argument requires COUNT argument")
+	// ErrCount a negative COUNT.
+	ErrCount = errors.New("COUNT must be > 0")
+)
+
+// Sort orders of Search.
+const (
+	SortNone = iota
+	SortAsc
+	SortDesc
+)
+
+// Location a member and its coordinates, an element of GEOADD.
+type Location struct {
+	Longitude, Latitude float64
+	Member              []byte
+}
+
+// Point a longitude and a latitude.
+type Point struct {
+	Longitude, Latitude float64
+}
+
+// invalidCoords Return the error of coordinates out of the WGS84 limits.
+func invalidCoords(longitude, latitude float64) error {
+	return fmt.Errorf("invalid longitude,latitude pair %f,%f", longitude, latitude)
+}
+
+// ParseUnit Return the conversion factor to meters of the unit: "m",
+// "km", "ft" or "mi", case insensitive.
+func ParseUnit(unit []byte) (float64, error) {
+	switch {
+	case bytes.EqualFold(unit, []byte("m")):
+		return 1, nil
+	case bytes.EqualFold(unit, []byte("km")):
+		return 1000, nil
+	case bytes.EqualFold(unit, []byte("ft")):
+		return 0.3048, nil
+	case bytes.EqualFold(unit, []byte("mi")):
+		return 1609.34, nil
+	}
+	return 0, ErrUnit
+}
+
+// decodeScore Return the coordinates of a score.
+func decodeScore(score float64) (longitude, latitude float64) {
+	return DecodeToLongLatWGS84(HashBits{Bits: uint64(score), Step: StepMax})
+}
+
+// Add Add the locations to the zset, like GEOADD. 'flags' accepts the
+// zset.AddNX, zset.AddXX and zset.AddCH flags. Returns the number of
+// added elements, or of added and updated elements with zset.AddCH.
+func Add(zs *zset.Zset, flags int, locations ...Location) (int64, error) {
+	// Create the argument vector to call ZADD in order to add all the
+	// score,value pairs to the requested zset, where score is actually
+	// an encoded version of lat,long.
+	elements := make([]zset.ScoreMember, 0, len(locations))
+	for _, l := range locations {
+		hash, ok := EncodeWGS84(l.Longitude, l.Latitude, StepMax)
+		if !ok {
+			return 0, invalidCoords(l.Longitude, l.Latitude)
+		}
+		elements = append(elements, zset.ScoreMember{
+			Score:  float64(Align52Bits(hash)),
+			Member: l.Member,
+		})
+	}
+
+	reply, _, err := zs.ZAdd(flags&(zset.AddNX|zset.AddXX|zset.AddCH), elements...)
+	return reply, err
+}
+
+// Pos Return the positions of the members, like GEOPOS, nil for a
+// member not in the zset.
+func Pos(zs *zset.Zset, members ...[]byte) []*Point {
+	points := make([]*Point, len(members))
+	for i, m := range members {
+		score, ok := zs.Score(m)
+		if !ok {
+			continue
+		}
+		lon, lat := decodeScore(score)
+		points[i] = &Point{Longitude: lon, Latitude: lat}
+	}
+	return points
+}
+
+// Dist Return the distance between the members in the unit of
+// 'conversion', like GEODIST. ok is false if a member is not in the
+// zset.
+func Dist(zs *zset.Zset, member1, member2 []byte, conversion float64) (dist float64, ok bool) {
+	score1, ok1 := zs.Score(member1)
+	score2, ok2 := zs.Score(member2)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	lon1, lat1 := decodeScore(score1)
+	lon2, lat2 := decodeScore(score2)
+	return Distance(lon1, lat1, lon2, lat2) / conversion, true
+}
+
+// Hash Return the standard geohash strings of 11 characters of the
+// members, like GEOHASH, an empty string for a member not in the zset.
+func Hash(zs *zset.Zset, members ...[]byte) []string {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	hashes := make([]string, len(members))
+	for i, m := range members {
+		score, ok := zs.Score(m)
+		if !ok {
+			continue
+		}
+
+		// The internal format we use for geocoding is a bit different
+		// than the standard, since we use as initial latitude range
+		// -85,85, while the normal geohashing algorithm uses -90,90. So
+		// we have to decode our position and re-encode using the
+		// standard ranges in order to output a valid geohash string.
+		lon, lat := decodeScore(score)
+		hash, _ := Encode(HashRange{-180, 180}, HashRange{-90, 90}, lon, lat, StepMax)
+
+		var buf [11]byte
+		for j := range buf {
+			idx := 0
+			// We have just 52 bits, but the API used to output an 11
+			// bytes geohash. For compatibility we assume zero.
+			if j != 10 {
+				idx = int(hash.Bits>>(52-uint(j+1)*5)) & 0x1f
+			}
+			buf[j] = alphabet[idx]
+		}
+		hashes[i] = string(buf[:])
+	}
+	return hashes
+}
+
+// Query the arguments of Search.
+type Query struct {
+	// FromMember The center of the search is the position of the member
+	// if not nil (FROMMEMBER), otherwise it is the Longitude and the
+	// Latitude of Shape (FROMLONLAT).
+	FromMember []byte
+	// Shape the shape of the search, Shape.Conversion is the unit of the
+	// distances, meters if 0.
+	Shape Shape
+	// Sort SortNone, SortAsc or SortDesc, the order by distance.
+	Sort int
+	// Count Return at most 'Count' results, 0 meaning all of them.
+	Count int64
+	// Any Return as soon as 'Count' results are found, not the nearest
+	// ones.
+	Any bool
+}
+
+// Result a result of Search.
+type Result struct {
+	Member []byte
+	// Dist the distance from the center, in the unit of the query.
+	Dist float64
+	// Score the geohash of the member.
+	Score               uint64
+	Longitude, Latitude float64
+}
+
+// inShape Return the distance of the point from the center of the
+// shape, and true if the point is in the shape.
+func inShape(shape *Shape, longitude, latitude float64) (float64, bool) {
+	if shape.Type == ShapeRadius {
+		return DistanceIfInRadius(shape.Longitude, shape.Latitude, longitude, latitude,
+			shape.Radius*shape.Conversion)
+	}
+	return DistanceIfInRectangle(shape.Width*shape.Conversion, shape.Height*shape.Conversion,
+		shape.Longitude, shape.Latitude, longitude, latitude)
+}
+
+// pointsInRange Append to 'results' the elements with a score in
+// [min, max) that are in the shape. Stop when 'limit' results are found,
+// if not 0.
+func pointsInRange(zs *zset.Zset, min, max float64, shape *Shape, results []Result, limit int64) []Result {
+	r := &skiplist.RangeSpec{Min: min, Max: max, Maxex: true}
+	for _, e := range zs.RangeByScore(r, false, 0, -1) {
+		lon, lat := decodeScore(e.Score)
+		dist, ok := inShape(shape, lon, lat)
+		if !ok {
+			continue
+		}
+		results = append(results, Result{
+			Member:    e.Member,
+			Dist:      dist,
+			Score:     uint64(e.Score),
+			Longitude: lon,
+			Latitude:  lat,
+		})
+		if limit != 0 && int64(len(results)) >= limit {
+			break
+		}
+	}
+	return results
+}
+
+// membersOfAllNeighbors Search all eight neighbors + self geohash box.
+func membersOfAllNeighbors(zs *zset.Zset, n *HashRadius, shape *Shape, limit int64) []Result {
+	neighbors := [9]HashBits{
+		n.Hash,
+		n.Neighbors.North,
+		n.Neighbors.South,
+		n.Neighbors.East,
+		n.Neighbors.West,
+		n.Neighbors.NorthEast,
+		n.Neighbors.NorthWest,
+		n.Neighbors.SouthEast,
+		n.Neighbors.SouthWest,
+	}
+
+	var results []Result
+	lastProcessed := 0
+	// For each neighbor (*and* our own hashbox), get all the matching
+	// members and add them to the potential result list.
+	for i := range neighbors {
+		if neighbors[i].IsZero() {
+			continue
+		}
+
+		// When a huge Radius (in the 5000 km range or more) is used,
+		// adjacent neighbors can be the same, leading to duplicated
+		// elements. Skip every range which is the same as the one
+		// processed previously.
+		if lastProcessed != 0 && neighbors[i] == neighbors[lastProcessed] {
+			continue
+		}
+
+		// We want to get all the points inside the box of the hash:
+		// the scores are in [hash, hash+1) aligned to 52 bits.
+		hash := neighbors[i]
+		min := float64(Align52Bits(hash))
+		hash.Bits++
+		max := float64(Align52Bits(hash))
+
+		results = pointsInRange(zs, min, max, shape, results, limit)
+		if limit != 0 && int64(len(results)) >= limit {
+			break
+		}
+		lastProcessed = i
+	}
+	return results
+}
+
+// Search Return the members in the shape of the query, like GEOSEARCH.
+func Search(zs *zset.Zset, q *Query) ([]Result, error) {
+	shape := q.Shape
+	if shape.Conversion == 0 {
+		shape.Conversion = 1
+	}
+	if q.Count < 0 {
+		return nil, ErrCount
+	}
+	if q.Any && q.Count == 0 {
+		return nil, ErrAnyCount
+	}
+
+	// Look up the requested zset member.
+	if q.FromMember != nil {
+		score, ok := zs.Score(q.FromMember)
+		if !ok {
+			return nil, ErrMember
+		}
+		shape.Longitude, shape.Latitude = decodeScore(score)
+	} else if _, ok := EncodeWGS84(shape.Longitude, shape.Latitude, StepMax); !ok {
+		return nil, invalidCoords(shape.Longitude, shape.Latitude)
+	}
+
+	// COUNT without ordering does not make much sense (we need to sort
+	// in order to return the closest N entries), force ASC ordering if
+	// COUNT was specified but no sorting was requested. Note that this
+	// is not needed for ANY option.
+	sortOrder := q.Sort
+	if q.Count != 0 && sortOrder == SortNone && !q.Any {
+		sortOrder = SortAsc
+	}
+
+	// Get all neighbor geohash boxes for our radius search.
+	georadius := CalculateAreasByShape(&shape)
+
+	// Search the zset for all matching points.
+	var limit int64
+	if q.Any {
+		limit = q.Count
+	}
+	results := membersOfAllNeighbors(zs, &georadius, &shape, limit)
+
+	// Process [optional] requested sorting.
+	switch sortOrder {
+	case SortAsc:
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Dist < results[j].Dist
+		})
+	case SortDesc:
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Dist > results[j].Dist
+		})
+	}
+
+	if q.Count != 0 && int64(len(results)) > q.Count {
+		results = results[:q.Count]
+	}
+	for i := range results {
+		results[i].Dist /= shape.Conversion
+	}
+	return results, nil
+}
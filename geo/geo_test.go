@@ -0,0 +1,206 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"zset"
+)
+
+func sicily(t *testing.T) *zset.Zset {
+	zs := zset.Create()
+	n, err := Add(zs, 0,
+		Location{13.361389, 38.115556, []byte("Palermo")},
+		Location{15.087269, 37.502669, []byte("Catania")})
+	if n != 2 || err != nil {
+		t.Fatalf("Add %d %v", n, err)
+	}
+	return zs
+}
+
+func round(v float64, digits int) float64 {
+	p := math.Pow10(digits)
+	return math.Round(v*p) / p
+}
+
+func TestAdd(t *testing.T) {
+	zs := sicily(t)
+	if _, err := Add(zs, 0, Location{200, 100, []byte("x")}); err == nil ||
+		err.Error() != "invalid longitude,latitude pair 200.000000,100.000000" {
+		t.Fatalf("Add invalid %v", err)
+	}
+	if n, _ := Add(zs, zset.AddNX, Location{13, 38, []byte("Palermo")}); n != 0 {
+		t.Fatalf("Add NX %d", n)
+	}
+	if n, _ := Add(zs, zset.AddXX|zset.AddCH, Location{13, 38, []byte("Palermo")}, Location{13, 38, []byte("Rome")}); n != 1 {
+		t.Fatalf("Add XX CH %d", n)
+	}
+	if zs.Len() != 2 {
+		t.Fatalf("len %d", zs.Len())
+	}
+	if _, err := Add(zs, zset.AddNX|zset.AddXX, Location{13, 38, []byte("Palermo")}); err != zset.ErrXXNX {
+		t.Fatalf("Add NX XX %v", err)
+	}
+}
+
+func TestPosDistHash(t *testing.T) {
+	zs := sicily(t)
+
+	points := Pos(zs, []byte("Palermo"), []byte("NonExisting"), []byte("Catania"))
+	if points[1] != nil {
+		t.Fatal("Pos of missing member")
+	}
+	// The positions are the centers of the cells of the hashes.
+	if round(points[0].Longitude, 12) != 13.361389338970 || round(points[0].Latitude, 12) != 38.115556395496 ||
+		round(points[2].Longitude, 12) != 15.087267458439 || round(points[2].Latitude, 12) != 37.502668423332 {
+		t.Fatalf("Pos %+v %+v", points[0], points[2])
+	}
+
+	if d, ok := Dist(zs, []byte("Palermo"), []byte("Catania"), 1); !ok || round(d, 4) != 166274.1516 {
+		t.Fatalf("Dist %f", d)
+	}
+	unit, _ := ParseUnit([]byte("KM"))
+	if d, _ := Dist(zs, []byte("Palermo"), []byte("Catania"), unit); round(d, 4) != 166.2742 {
+		t.Fatalf("Dist km %f", d)
+	}
+	unit, _ = ParseUnit([]byte("mi"))
+	if d, _ := Dist(zs, []byte("Palermo"), []byte("Catania"), unit); round(d, 4) != 103.3182 {
+		t.Fatalf("Dist mi %f", d)
+	}
+	if _, ok := Dist(zs, []byte("Palermo"), []byte("Rome"), 1); ok {
+		t.Fatal("Dist of missing member")
+	}
+	if _, err := ParseUnit([]byte("yd")); err != ErrUnit {
+		t.Fatalf("ParseUnit %v", err)
+	}
+
+	hashes := Hash(zs, []byte("Palermo"), []byte("Catania"), []byte("Rome"))
+	if hashes[0] != "sqc8b49rny0" || hashes[1] != "sqdtr74hyu0" || hashes[2] != "" {
+		t.Fatalf("Hash %q", hashes)
+	}
+}
+
+func members(results []Result) []string {
+	var m []string
+	for _, r := range results {
+		m = append(m, string(r.Member))
+	}
+	return m
+}
+
+func TestSearch(t *testing.T) {
+	zs := sicily(t)
+	Add(zs, 0, Location{12.758489, 38.788135, []byte("edge1")}, Location{17.241510, 38.788135, []byte("edge2")})
+	km, _ := ParseUnit([]byte("km"))
+
+	results, err := Search(zs, &Query{
+		Shape: Shape{Type: ShapeRadius, Longitude: 15, Latitude: 37, Radius: 200, Conversion: km},
+		Sort:  SortAsc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(members(results)) != "[Catania Palermo]" ||
+		round(results[0].Dist, 4) != 56.4413 || round(results[1].Dist, 4) != 190.4424 {
+		t.Fatalf("Search radius %+v", results)
+	}
+
+	results, _ = Search(zs, &Query{
+		Shape: Shape{Type: ShapeBox, Longitude: 15, Latitude: 37, Width: 400, Height: 400, Conversion: km},
+		Sort:  SortAsc,
+	})
+	if fmt.Sprint(members(results)) != "[Catania Palermo edge2 edge1]" ||
+		round(results[2].Dist, 4) != 279.7403 || round(results[3].Dist, 4) != 279.7405 {
+		t.Fatalf("Search box %+v", results)
+	}
+
+	results, _ = Search(zs, &Query{
+		FromMember: []byte("Palermo"),
+		Shape:      Shape{Type: ShapeRadius, Radius: 200, Conversion: km},
+		Sort:       SortDesc,
+	})
+	if fmt.Sprint(members(results)) != "[Catania edge1 Palermo]" || results[2].Dist != 0 {
+		t.Fatalf("Search from member %+v", results)
+	}
+
+	// COUNT sorts by default, unless ANY.
+	results, _ = Search(zs, &Query{
+		Shape: Shape{Type: ShapeBox, Longitude: 15, Latitude: 37, Width: 400, Height: 400, Conversion: km},
+		Count: 2,
+	})
+	if fmt.Sprint(members(results)) != "[Catania Palermo]" {
+		t.Fatalf("Search count %+v", results)
+	}
+	results, _ = Search(zs, &Query{
+		Shape: Shape{Type: ShapeBox, Longitude: 15, Latitude: 37, Width: 400, Height: 400, Conversion: km},
+		Count: 1,
+		Any:   true,
+	})
+	if len(results) != 1 {
+		t.Fatalf("Search any %+v", results)
+	}
+
+	for _, test := range []struct {
+		q   Query
+		err error
+	}{
+		{Query{FromMember: []byte("Rome")}, ErrMember},
+		{Query{Any: true}, ErrAnyCount},
+		{Query{Count: -1}, ErrCount},
+	} {
+		if _, err := Search(zs, &test.q); err != test.err {
+			t.Fatalf("Search %+v = %v, want %v", test.q, err, test.err)
+		}
+	}
+	if _, err := Search(zs, &Query{Shape: Shape{Longitude: 0, Latitude: 89}}); err == nil {
+		t.Fatal("Search invalid coordinates")
+	}
+}
+
+// TestSearchFuzzy Compare the searches with a brute force scan of all
+// the points, like the fuzzy test of redis: far from the poles, with a
+// radius up to 210 km.
+func TestSearchFuzzy(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		zs := zset.Create()
+		lon := rand.Float64()*360 - 180
+		lat := rand.Float64()*140 - 70
+		radius := float64(10000 + rand.Intn(200000))
+
+		for j := 0; j < 500; j++ {
+			// Points around the center, in a square of 2*radius.
+			dlat := (rand.Float64()*2 - 1) * radius * 2 / 111000
+			dlon := dlat / math.Max(math.Cos(lat*math.Pi/180), 0.1)
+			plon := math.Mod(lon+dlon*(rand.Float64()*2-1)+540, 360) - 180
+			plat := lat + dlat
+			Add(zs, 0, Location{plon, plat, []byte(fmt.Sprint(j))})
+		}
+
+		shape := Shape{Type: ShapeRadius, Longitude: lon, Latitude: lat, Radius: radius, Conversion: 1}
+		if i%2 == 1 {
+			shape = Shape{Type: ShapeBox, Longitude: lon, Latitude: lat, Width: radius, Height: radius * 1.5, Conversion: 1}
+		}
+
+		var want []string
+		for _, e := range zs.RangeByRank(0, -1, false) {
+			plon, plat := decodeScore(e.Score)
+			if _, ok := inShape(&shape, plon, plat); ok {
+				want = append(want, string(e.Member))
+			}
+		}
+		sort.Strings(want)
+
+		results, err := Search(zs, &Query{Shape: shape})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := members(results)
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("shape %+v: got %d members, want %d", shape, len(got), len(want))
+		}
+	}
+}
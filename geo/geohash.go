@@ -0,0 +1,299 @@
+package geo
+
+// Geohash, a port of geohash.c of redis.
+//
+// A geohash interleaves the bits of the latitude and the longitude, each
+// one being a fixed point offset in its range on 'step' bits, so that
+// close points share a common prefix. The hashes of step 26 (52 bits) are
+// used as the scores of the sorted set, a double being able to represent
+// integers of 52 bits without loss.
+
+const (
+	// StepMax The max step: 26*2 = 52 bits.
+	StepMax = 26
+
+	// Limits from EPSG:900913 / EPSG:3785 / OSGEO:41001
+	LatMin  = -85.05112878
+	LatMax  = 85.05112878
+	LongMin = -180.0
+	LongMax = 180.0
+)
+
+// HashBits a geohash of 'Step' * 2 bits.
+type HashBits struct {
+	Bits uint64
+	Step uint8
+}
+
+// IsZero Return true for the zero hash, an excluded neighbor.
+func (h HashBits) IsZero() bool {
+	return h.Bits == 0 && h.Step == 0
+}
+
+// HashRange a range of coordinates.
+type HashRange struct {
+	Min, Max float64
+}
+
+// HashArea the area covered by a geohash.
+type HashArea struct {
+	Hash      HashBits
+	Longitude HashRange
+	Latitude  HashRange
+}
+
+// HashNeighbors the 8 neighbor cells of a geohash.
+type HashNeighbors struct {
+	North     HashBits
+	East      HashBits
+	West      HashBits
+	South     HashBits
+	NorthEast HashBits
+	SouthEast HashBits
+	NorthWest HashBits
+	SouthWest HashBits
+}
+
+// coordRange Return the ranges of the coordinates of the WGS84 hashes.
+func coordRange() (longRange, latRange HashRange) {
+	return HashRange{LongMin, LongMax}, HashRange{LatMin, LatMax}
+}
+
+// interleave64 Interleave lower bits of x and y, so the bits of x are in
+// the even positions and bits from y in the odd; x and y must initially
+// be less than 2**32 (4294967296).
+//
+// From: https://graphics.stanford.edu/~seander/bithacks.html#InterleaveBMN
+func interleave64(xlo, ylo uint32) uint64 {
+	B := [...]uint64{0x5555555555555555, 0x3333333333333333,
+		0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF,
+		0x0000FFFF0000FFFF}
+	S := [...]uint{1, 2, 4, 8, 16}
+
+	x := uint64(xlo)
+	y := uint64(ylo)
+
+	x = (x | (x << S[4])) & B[4]
+	y = (y | (y << S[4])) & B[4]
+
+	x = (x | (x << S[3])) & B[3]
+	y = (y | (y << S[3])) & B[3]
+
+	x = (x | (x << S[2])) & B[2]
+	y = (y | (y << S[2])) & B[2]
+
+	x = (x | (x << S[1])) & B[1]
+	y = (y | (y << S[1])) & B[1]
+
+	x = (x | (x << S[0])) & B[0]
+	y = (y | (y << S[0])) & B[0]
+
+	return x | (y << 1)
+}
+
+// deinterleave64 Reverse the interleave process: the bits of x are in
+// the low 32 bits of the result, the bits of y in the high ones.
+//
+// derived from http://stackoverflow.com/questions/4909263
+func deinterleave64(interleaved uint64) uint64 {
+	B := [...]uint64{0x5555555555555555, 0x3333333333333333,
+		0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF,
+		0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	S := [...]uint{0, 1, 2, 4, 8, 16}
+
+	x := interleaved
+	y := interleaved >> 1
+
+	x = (x | (x >> S[0])) & B[0]
+	y = (y | (y >> S[0])) & B[0]
+
+	x = (x | (x >> S[1])) & B[1]
+	y = (y | (y >> S[1])) & B[1]
+
+	x = (x | (x >> S[2])) & B[2]
+	y = (y | (y >> S[2])) & B[2]
+
+	x = (x | (x >> S[3])) & B[3]
+	y = (y | (y >> S[3])) & B[3]
+
+	x = (x | (x >> S[4])) & B[4]
+	y = (y | (y >> S[4])) & B[4]
+
+	x = (x | (x >> S[5])) & B[5]
+	y = (y | (y >> S[5])) & B[5]
+
+	return x | (y << 32)
+}
+
+// Encode Return the geohash of 'step' of the point in the ranges. ok is
+// false if the point is out of the ranges or of the WGS84 limits.
+func Encode(longRange, latRange HashRange, longitude, latitude float64, step uint8) (hash HashBits, ok bool) {
+	// Check basic arguments sanity.
+	if step > 32 || step == 0 || (latRange.Min == 0 && latRange.Max == 0) ||
+		(longRange.Min == 0 && longRange.Max == 0) {
+		return HashBits{}, false
+	}
+
+	// Return an error when trying to index outside the supported
+	// constraints.
+	if longitude > LongMax || longitude < LongMin ||
+		latitude > LatMax || latitude < LatMin {
+		return HashBits{}, false
+	}
+
+	if latitude < latRange.Min || latitude > latRange.Max ||
+		longitude < longRange.Min || longitude > longRange.Max {
+		return HashBits{}, false
+	}
+
+	latOffset := (latitude - latRange.Min) / (latRange.Max - latRange.Min)
+	longOffset := (longitude - longRange.Min) / (longRange.Max - longRange.Min)
+
+	// convert to fixed point based on the step size
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return HashBits{Bits: interleave64(uint32(latOffset), uint32(longOffset)), Step: step}, true
+}
+
+// EncodeWGS84 Return the geohash of 'step' of the point in the WGS84
+// ranges.
+func EncodeWGS84(longitude, latitude float64, step uint8) (HashBits, bool) {
+	longRange, latRange := coordRange()
+	return Encode(longRange, latRange, longitude, latitude, step)
+}
+
+// Decode Return the area of the geohash in the ranges.
+func Decode(longRange, latRange HashRange, hash HashBits) HashArea {
+	area := HashArea{Hash: hash}
+	step := hash.Step
+	// hash = [LAT][LONG]
+	hashSep := deinterleave64(hash.Bits)
+
+	latScale := latRange.Max - latRange.Min
+	longScale := longRange.Max - longRange.Min
+
+	// get lat part of deinterleaved hash
+	ilato := uint32(hashSep)
+	// shift over to get long part of hash
+	ilono := uint32(hashSep >> 32)
+
+	// divide by 2**step.
+	// Then, for 0-1 coordinate, multiply times scale and add to the min
+	// to get the absolute coordinate.
+	div := float64(uint64(1) << step)
+	area.Latitude.Min = latRange.Min + (float64(ilato)/div)*latScale
+	area.Latitude.Max = latRange.Min + ((float64(ilato)+1)/div)*latScale
+	area.Longitude.Min = longRange.Min + (float64(ilono)/div)*longScale
+	area.Longitude.Max = longRange.Min + ((float64(ilono)+1)/div)*longScale
+	return area
+}
+
+// DecodeWGS84 Return the area of the geohash in the WGS84 ranges.
+func DecodeWGS84(hash HashBits) HashArea {
+	longRange, latRange := coordRange()
+	return Decode(longRange, latRange, hash)
+}
+
+// AreaToLongLat Return the center of the area, clamped to the WGS84
+// limits.
+func AreaToLongLat(area HashArea) (longitude, latitude float64) {
+	longitude = (area.Longitude.Min + area.Longitude.Max) / 2
+	if longitude > LongMax {
+		longitude = LongMax
+	}
+	if longitude < LongMin {
+		longitude = LongMin
+	}
+	latitude = (area.Latitude.Min + area.Latitude.Max) / 2
+	if latitude > LatMax {
+		latitude = LatMax
+	}
+	if latitude < LatMin {
+		latitude = LatMin
+	}
+	return longitude, latitude
+}
+
+// DecodeToLongLatWGS84 Return the center of the area of the geohash.
+func DecodeToLongLatWGS84(hash HashBits) (longitude, latitude float64) {
+	return AreaToLongLat(DecodeWGS84(hash))
+}
+
+func moveX(hash *HashBits, d int) {
+	if d == 0 {
+		return
+	}
+
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+
+	zz := uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	hash.Bits = x | y
+}
+
+func moveY(hash *HashBits, d int) {
+	if d == 0 {
+		return
+	}
+
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	hash.Bits = x | y
+}
+
+// Neighbors Return the 8 cells around the geohash, of the same step.
+func Neighbors(hash HashBits) HashNeighbors {
+	n := HashNeighbors{
+		East:      hash,
+		West:      hash,
+		North:     hash,
+		South:     hash,
+		SouthEast: hash,
+		SouthWest: hash,
+		NorthEast: hash,
+		NorthWest: hash,
+	}
+
+	moveX(&n.East, 1)
+	moveY(&n.East, 0)
+
+	moveX(&n.West, -1)
+	moveY(&n.West, 0)
+
+	moveX(&n.South, 0)
+	moveY(&n.South, -1)
+
+	moveX(&n.North, 0)
+	moveY(&n.North, 1)
+
+	moveX(&n.NorthWest, -1)
+	moveY(&n.NorthWest, 1)
+
+	moveX(&n.SouthWest, -1)
+	moveY(&n.SouthWest, -1)
+
+	moveX(&n.NorthEast, 1)
+	moveY(&n.NorthEast, 1)
+
+	moveX(&n.SouthEast, 1)
+	moveY(&n.SouthEast, -1)
+	return n
+}
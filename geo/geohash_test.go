@@ -0,0 +1,90 @@
+package geo
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestInterleave(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		x, y := rand.Uint32(), rand.Uint32()
+		v := interleave64(x, y)
+		for b := uint(0); b < 32; b++ {
+			if (v>>(2*b))&1 != uint64(x>>b)&1 || (v>>(2*b+1))&1 != uint64(y>>b)&1 {
+				t.Fatalf("interleave64(%x, %x) = %x", x, y, v)
+			}
+		}
+		if d := deinterleave64(v); uint32(d) != x || uint32(d>>32) != y {
+			t.Fatalf("deinterleave64(%x) = %x", v, d)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	if _, ok := EncodeWGS84(181, 0, StepMax); ok {
+		t.Fatal("longitude out of range")
+	}
+	if _, ok := EncodeWGS84(0, 85.1, StepMax); ok {
+		t.Fatal("latitude out of range")
+	}
+	if _, ok := EncodeWGS84(0, 0, 0); ok {
+		t.Fatal("step 0")
+	}
+
+	for i := 0; i < 1000; i++ {
+		lon := rand.Float64()*360 - 180
+		lat := rand.Float64()*2*LatMax - LatMax
+		hash, ok := EncodeWGS84(lon, lat, StepMax)
+		if !ok {
+			t.Fatalf("EncodeWGS84(%f, %f)", lon, lat)
+		}
+		area := DecodeWGS84(hash)
+		if lon < area.Longitude.Min || lon > area.Longitude.Max ||
+			lat < area.Latitude.Min || lat > area.Latitude.Max {
+			t.Fatalf("point %f,%f not in area %+v", lon, lat, area)
+		}
+
+		// The neighbors are the adjacent cells.
+		step := uint8(1 + rand.Intn(StepMax))
+		hash, _ = EncodeWGS84(lon, lat, step)
+		area = DecodeWGS84(hash)
+		n := Neighbors(hash)
+		w, h := area.Longitude.Max-area.Longitude.Min, area.Latitude.Max-area.Latitude.Min
+		for _, c := range []struct {
+			hash   HashBits
+			dx, dy float64
+		}{
+			{n.North, 0, 1}, {n.South, 0, -1}, {n.East, 1, 0}, {n.West, -1, 0},
+			{n.NorthEast, 1, 1}, {n.NorthWest, -1, 1}, {n.SouthEast, 1, -1}, {n.SouthWest, -1, -1},
+		} {
+			cx, cy := AreaToLongLat(area)
+			cx += c.dx * w
+			cy += c.dy * h
+			if cx < LongMin || cx > LongMax || cy < LatMin || cy > LatMax {
+				continue
+			}
+			if want, _ := EncodeWGS84(cx, cy, step); want != c.hash {
+				t.Fatalf("neighbor %v of %v = %v, want %v", c, hash, c.hash, want)
+			}
+		}
+	}
+}
+
+func TestEstimateSteps(t *testing.T) {
+	for _, test := range []struct {
+		radius, lat float64
+		step        uint8
+	}{
+		{0, 0, 26},
+		{1, 0, 24},
+		{1000, 0, 14},
+		{1000, 70, 13},
+		{1000, 85, 12},
+		{5000000, 0, 2},
+		{50000000, 0, 1},
+	} {
+		if step := EstimateStepsByRadius(test.radius, test.lat); step != test.step {
+			t.Fatalf("EstimateStepsByRadius(%f, %f) = %d, want %d", test.radius, test.lat, step, test.step)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+module geo
+
+go 1.14
+
+require (
+	skiplist v0.0.0
+	zset v0.0.0
+)
+
+replace (
+	dict => ../dict
+	listpack => ../listpack
+	sds => ../sds
+	skiplist => ../skiplist
+	util => ../util
+	zset => ../zset
+)
@@ -0,0 +1,264 @@
+package geo
+
+// Helpers of the geo searches, a port of geohash_helper.c of redis.
+//
+// To search the points in a radius or a box, the geohash step is chosen
+// so that the shape is covered by the cell of the center and its 8
+// neighbors, then only the points with a score in the ranges of those 9
+// cells are checked.
+
+import (
+	"math"
+)
+
+const (
+	// EarthRadiusInMeters Earth's quatratic mean radius for WGS-84.
+	EarthRadiusInMeters = 6372797.560856
+
+	mercatorMax = 20037726.37
+)
+
+// Shape types.
+const (
+	// ShapeRadius a circle of radius Radius, GEOSEARCH BYRADIUS.
+	ShapeRadius = iota
+	// ShapeBox a rectangle of Width and Height, GEOSEARCH BYBOX.
+	ShapeBox
+)
+
+// Shape the area of a search.
+type Shape struct {
+	// Type ShapeRadius or ShapeBox.
+	Type int
+	// Longitude, Latitude the center of the search.
+	Longitude, Latitude float64
+	// Conversion the conversion factor from the unit of the shape to
+	// meters.
+	Conversion float64
+	Radius     float64
+	Width      float64
+	Height     float64
+}
+
+// HashRadius the cells to scan for a shape.
+type HashRadius struct {
+	Hash      HashBits
+	Area      HashArea
+	Neighbors HashNeighbors
+}
+
+func degRad(ang float64) float64 {
+	return ang * (math.Pi / 180.0)
+}
+
+func radDeg(ang float64) float64 {
+	return ang / (math.Pi / 180.0)
+}
+
+// EstimateStepsByRadius This function is used in order to estimate the
+// step (bits precision) of the 9 search area boxes during radius queries.
+func EstimateStepsByRadius(rangeMeters, lat float64) uint8 {
+	if rangeMeters == 0 {
+		return 26
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// Make sure range is included in most of the base cases.
+	step -= 2
+
+	// Wider range towards the poles... Note: it is possible to do better
+	// than this approximation by computing the distance between meridians
+	// at this latitude, but this does the trick for now.
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	// Frame to valid range.
+	if step < 1 {
+		step = 1
+	}
+	if step > 26 {
+		step = 26
+	}
+	return uint8(step)
+}
+
+// BoundingBox Return the bounding box of the shape: min longitude, min
+// latitude, max longitude, max latitude.
+//
+// Note that the bounding box may cross the 180th meridian, or go beyond
+// the poles, in that case the values are out of the WGS84 ranges.
+func BoundingBox(shape *Shape) (bounds [4]float64) {
+	height, width := shape.Radius, shape.Radius
+	if shape.Type == ShapeBox {
+		height, width = shape.Height/2, shape.Width/2
+	}
+	height *= shape.Conversion
+	width *= shape.Conversion
+
+	latDelta := radDeg(height / EarthRadiusInMeters)
+	longDeltaTop := radDeg(width / EarthRadiusInMeters / math.Cos(degRad(shape.Latitude+latDelta)))
+	longDeltaBottom := radDeg(width / EarthRadiusInMeters / math.Cos(degRad(shape.Latitude-latDelta)))
+
+	// The directions of the northern and southern hemispheres are
+	// opposite, so we choice different points as min/max long/lat.
+	if shape.Latitude < 0 {
+		bounds[0] = shape.Longitude - longDeltaBottom
+		bounds[2] = shape.Longitude + longDeltaBottom
+	} else {
+		bounds[0] = shape.Longitude - longDeltaTop
+		bounds[2] = shape.Longitude + longDeltaTop
+	}
+	bounds[1] = shape.Latitude - latDelta
+	bounds[3] = shape.Latitude + latDelta
+	return bounds
+}
+
+// CalculateAreasByShape Return the center cell and its neighbors
+// covering the shape, the useless neighbors are set to zero.
+func CalculateAreasByShape(shape *Shape) HashRadius {
+	bounds := BoundingBox(shape)
+	minLon, minLat, maxLon, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+
+	// radiusMeters is calculated differently in different search types:
+	// 1) ShapeRadius, just use radius.
+	// 2) ShapeBox, we use sqrt((width/2)^2 + (height/2)^2) to calculate
+	// the distance from the center point to the corner.
+	radiusMeters := shape.Radius
+	if shape.Type == ShapeBox {
+		radiusMeters = math.Sqrt((shape.Width/2)*(shape.Width/2) + (shape.Height/2)*(shape.Height/2))
+	}
+	radiusMeters *= shape.Conversion
+
+	steps := EstimateStepsByRadius(radiusMeters, shape.Latitude)
+
+	hash, _ := EncodeWGS84(shape.Longitude, shape.Latitude, steps)
+	neighbors := Neighbors(hash)
+	area := DecodeWGS84(hash)
+
+	// Check if the step is enough at the limits of the covered area.
+	// Sometimes when the search area is near an edge of the area, the
+	// estimated step is not small enough, since one of the north / south
+	// / west / east square is too near to the search area to cover
+	// everything.
+	decreaseStep := false
+	{
+		north := DecodeWGS84(neighbors.North)
+		south := DecodeWGS84(neighbors.South)
+		east := DecodeWGS84(neighbors.East)
+		west := DecodeWGS84(neighbors.West)
+
+		if north.Latitude.Max < maxLat {
+			decreaseStep = true
+		}
+		if south.Latitude.Min > minLat {
+			decreaseStep = true
+		}
+		if east.Longitude.Max < maxLon {
+			decreaseStep = true
+		}
+		if west.Longitude.Min > minLon {
+			decreaseStep = true
+		}
+	}
+
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = EncodeWGS84(shape.Longitude, shape.Latitude, steps)
+		neighbors = Neighbors(hash)
+		area = DecodeWGS84(hash)
+	}
+
+	// Exclude the search areas that are useless.
+	if steps >= 2 {
+		if area.Latitude.Min < minLat {
+			neighbors.South = HashBits{}
+			neighbors.SouthWest = HashBits{}
+			neighbors.SouthEast = HashBits{}
+		}
+		if area.Latitude.Max > maxLat {
+			neighbors.North = HashBits{}
+			neighbors.NorthEast = HashBits{}
+			neighbors.NorthWest = HashBits{}
+		}
+		if area.Longitude.Min < minLon {
+			neighbors.West = HashBits{}
+			neighbors.SouthWest = HashBits{}
+			neighbors.NorthWest = HashBits{}
+		}
+		if area.Longitude.Max > maxLon {
+			neighbors.East = HashBits{}
+			neighbors.SouthEast = HashBits{}
+			neighbors.NorthEast = HashBits{}
+		}
+	}
+	return HashRadius{Hash: hash, Area: area, Neighbors: neighbors}
+}
+
+// Align52Bits Return the hash shifted to 52 bits, the precision of the
+// scores.
+func Align52Bits(hash HashBits) uint64 {
+	return hash.Bits << (52 - uint(hash.Step)*2)
+}
+
+// LatDistance Calculate distance using simplified haversine great circle
+// distance formula. Given longitude diff is 0 the asin(sqrt(a)) on the
+// haversine is asin(sin(abs(u))). arcsin(sin(x)) equal to x when x ∈
+// [−𝜋/2,𝜋/2]. Given latitude is between [−𝜋/2,𝜋/2] we can simplify
+// arcsin(sin(x)) to x.
+func LatDistance(lat1d, lat2d float64) float64 {
+	return EarthRadiusInMeters * math.Abs(degRad(lat2d)-degRad(lat1d))
+}
+
+// Distance Calculate distance using haversine great circle distance
+// formula.
+func Distance(lon1d, lat1d, lon2d, lat2d float64) float64 {
+	lat1r := degRad(lat1d)
+	lon1r := degRad(lon1d)
+	lat2r := degRad(lat2d)
+	lon2r := degRad(lon2d)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// if v == 0 we can avoid doing expensive math when lons are
+	// practically the same.
+	if v == 0.0 {
+		return LatDistance(lat1d, lat2d)
+	}
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * EarthRadiusInMeters * math.Asin(math.Sqrt(a))
+}
+
+// DistanceIfInRadius Return the distance between the points, and true
+// if it is not greater than 'radius'.
+func DistanceIfInRadius(x1, y1, x2, y2, radius float64) (float64, bool) {
+	distance := Distance(x1, y1, x2, y2)
+	return distance, distance <= radius
+}
+
+// DistanceIfInRectangle Judge whether a point is in the axis-aligned
+// rectangle, when the distance between a searched point and the center
+// point is less than or equal to height/2 or width/2 in height and width,
+// the point is in the rectangle.
+//
+// 'widthM' and 'heightM' are the size of the rectangle in meters, x1, y1
+// the center of the rectangle and x2, y2 the point. Returns the distance
+// between the points, and true if the point is in the rectangle.
+func DistanceIfInRectangle(widthM, heightM, x1, y1, x2, y2 float64) (float64, bool) {
+	// latitude distance is less expensive to compute than longitude
+	// distance so we check first for the latitude condition.
+	latDistance := LatDistance(y2, y1)
+	if latDistance > heightM/2 {
+		return 0, false
+	}
+	lonDistance := Distance(x2, y2, x1, y2)
+	if lonDistance > widthM/2 {
+		return 0, false
+	}
+	return Distance(x1, y1, x2, y2), true
+}
@@ -0,0 +1,3 @@
+module hyperloglog
+
+go 1.14
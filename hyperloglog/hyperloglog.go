@@ -0,0 +1,495 @@
+package hyperloglog
+
+// HyperLogLog cardinality estimator, a port of the redis implementation,
+// using the same serialization format, so that the blobs are
+// interchangeable with the redis ones.
+//
+// The implementation uses 16384 registers of 6 bits (P = 14), and the
+// improved estimator described by Otmar Ertl in "New cardinality
+// estimation algorithms for HyperLogLog sketches".
+//
+// The blob starts with a 16 bytes header:
+//
+// +------+---+-----+----------+
+// | HYLL | E | N/U | Cardin.  |
+// +------+---+-----+----------+
+//
+// The first 4 bytes are the magic string "HYLL". "E" is one byte encoding,
+// currently set to Dense (0) or Sparse (1). N/U are three not used bytes.
+// The "Cardin." field is a 64 bit integer stored in little endian format
+// with the latest cardinality computed that can be reused if the data
+// structure was not modified since the last computation. The most
+// significant bit of the last byte set means the cached value is invalid.
+//
+// The dense representation stores the 16384 registers of 6 bits packed
+// from the least significant bit of every byte. The sparse representation
+// is a run length encoding of the registers, see sparse.go. A sparse HLL
+// is promoted to the dense representation when it grows larger than the
+// sparse max bytes, or when a register value can't be represented.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Error
+var (
+	// ErrCorrupt the HLL blob is malformed.
+	ErrCorrupt = errors.New("hyperloglog: corrupt blob")
+)
+
+const (
+	// P The greater is P, the smaller the error.
+	P = 14
+	// Q The number of bits of the hash value used for determining the
+	// number of leading zeros.
+	Q = 64 - P
+	// Registers With P=14, 16384 registers.
+	Registers = 1 << P
+	// Bits Enough to count up to 63 leading zeroes.
+	Bits = 6
+
+	pMask       = Registers - 1
+	registerMax = (1 << Bits) - 1
+	hdrSize     = 16
+	// denseSize the size of a dense HLL blob.
+	denseSize = hdrSize + (Registers*Bits+7)/8
+
+	alphaInf = 0.721347520444481703680 // constant for 0.5/ln(2)
+
+	hashSeed = 0xadc83b19
+)
+
+// Encodings
+const (
+	// Dense encoding.
+	Dense = 0
+	// Sparse encoding.
+	Sparse = 1
+	// raw Only used internally, never exposed.
+	raw = 255
+)
+
+// SparseMaxBytes Default max size of a sparse HLL before it is promoted
+// to the dense encoding, similar to hll-sparse-max-bytes of redis.
+const SparseMaxBytes = 3000
+
+var magic = []byte("HYLL")
+
+// HyperLogLog HyperLogLog.
+type HyperLogLog struct {
+	buf []byte
+
+	sparseMaxBytes int
+}
+
+// Option opt.
+type Option func(h *HyperLogLog)
+
+// WithSparseMaxBytes The max size in bytes of a sparse HLL.
+func WithSparseMaxBytes(n int) Option {
+	return func(h *HyperLogLog) {
+		h.sparseMaxBytes = n
+	}
+}
+
+// Create an empty HLL, sparse encoded.
+func Create(opts ...Option) *HyperLogLog {
+	h := &HyperLogLog{
+		sparseMaxBytes: SparseMaxBytes,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+
+	// Populate the sparse representation with as many XZERO opcodes as
+	// needed to represent all the registers.
+	sparselen := hdrSize + ((Registers+(sparseXZeroMaxLen-1))/sparseXZeroMaxLen)*2
+	h.buf = make([]byte, hdrSize, sparselen)
+	copy(h.buf, magic)
+	h.buf[4] = Sparse
+	for aux := Registers; aux > 0; {
+		xzero := sparseXZeroMaxLen
+		if xzero > aux {
+			xzero = aux
+		}
+		h.buf = h.buf[:len(h.buf)+2]
+		sparseXZeroSet(h.buf[len(h.buf)-2:], xzero)
+		aux -= xzero
+	}
+	return h
+}
+
+// Load Create an HLL from a blob previously returned by Bytes, or by the
+// redis GET of a HyperLogLog key. ErrCorrupt is returned if the header
+// or the registers are malformed.
+func Load(blob []byte, opts ...Option) (*HyperLogLog, error) {
+	if len(blob) < hdrSize || !bytes.Equal(blob[:4], magic) {
+		return nil, ErrCorrupt
+	}
+
+	switch blob[4] {
+	case Dense:
+		// Dense representation with the wrong length.
+		if len(blob) != denseSize {
+			return nil, ErrCorrupt
+		}
+	case Sparse:
+		var reghisto [64]int
+		if !sparseRegHisto(blob[hdrSize:], &reghisto) {
+			return nil, ErrCorrupt
+		}
+	default:
+		return nil, ErrCorrupt
+	}
+
+	h := &HyperLogLog{
+		buf:            blob,
+		sparseMaxBytes: SparseMaxBytes,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	return h, nil
+}
+
+// Bytes Return the serialized HLL, the slice is owned by the HLL.
+func (h *HyperLogLog) Bytes() []byte {
+	return h.buf
+}
+
+// Encoding Return the encoding, Dense or Sparse.
+func (h *HyperLogLog) Encoding() int {
+	return int(h.buf[4])
+}
+
+func (h *HyperLogLog) registers() []byte {
+	return h.buf[hdrSize:]
+}
+
+// invalidateCache Invalidate the cached cardinality.
+func (h *HyperLogLog) invalidateCache() {
+	h.buf[15] |= 1 << 7
+}
+
+func (h *HyperLogLog) validCache() bool {
+	return h.buf[15]&(1<<7) == 0
+}
+
+// ========================= Low level bit macros =========================
+//
+// We need to get and set 6 bit counters in an array of 8 bit bytes.
+// The registers are stored starting from the least significant bit of
+// every byte: the register 0 uses the 6 lower bits of the first byte, the
+// register 1 the 2 higher bits of the first byte and the 4 lower bits of
+// the second byte, and so forth.
+
+// denseGetRegister Return the value of the register 'regnum'.
+func denseGetRegister(p []byte, regnum int) uint8 {
+	b := regnum * Bits / 8
+	fb := uint(regnum*Bits) & 7
+	fb8 := 8 - fb
+	b0 := uint(p[b])
+	var b1 uint
+	if b+1 < len(p) {
+		b1 = uint(p[b+1])
+	}
+	return uint8(((b0 >> fb) | (b1 << fb8)) & registerMax)
+}
+
+// denseSetRegister Set the value of the register 'regnum' to 'val'.
+func denseSetRegister(p []byte, regnum int, val uint8) {
+	b := regnum * Bits / 8
+	fb := uint(regnum*Bits) & 7
+	fb8 := 8 - fb
+	v := uint(val)
+	p[b] &^= byte(registerMax << fb)
+	p[b] |= byte(v << fb)
+	if b+1 < len(p) {
+		p[b+1] &^= byte(registerMax >> fb8)
+		p[b+1] |= byte(v >> fb8)
+	}
+}
+
+// murmurHash64A Our hash function is MurmurHash2, 64 bit version, the
+// blocks are read as little endian so that the hash is the same on
+// every architecture.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+
+	end := len(key) - len(key)&7
+	for i := 0; i < end; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+	}
+
+	data := key[end:]
+	switch len(key) & 7 {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// patLen Given a string element to add to the HyperLogLog, returns the
+// length of the pattern 000..1 of the element hash. As a side effect
+// 'index' is set which the element hash to the register index.
+func patLen(ele []byte) (index int, count uint8) {
+	// Count the number of zeroes starting from bit P (that is a power
+	// of two corresponding to the first bit we don't use as index). The
+	// max run can be 64-P+1 = Q+1 bits.
+	//
+	// Note that the final "1" ending the sequence of zeroes must be
+	// included in the count, so if we find "001" the count is 3, and
+	// the smallest count possible is no zeroes at all, just a 1 bit
+	// at the first position, that is a count of 1.
+	hash := murmurHash64A(ele, hashSeed)
+	index = int(hash & pMask) // Register index.
+	hash >>= P                // Remove bits used to address the register.
+	hash |= 1 << Q            // Make sure the loop terminates and count
+	// will be <= Q+1.
+	bit := uint64(1)
+	count = 1 // Initialized to 1 since we count the "00000...1" pattern.
+	for hash&bit == 0 {
+		count++
+		bit <<= 1
+	}
+	return index, count
+}
+
+// denseSet Low level function to set the dense HLL register at 'index'
+// to the specified value if the current value is smaller than 'count'.
+// Returns true if the register was updated.
+func denseSet(registers []byte, index int, count uint8) bool {
+	oldcount := denseGetRegister(registers, index)
+	if count > oldcount {
+		denseSetRegister(registers, index, count)
+		return true
+	}
+	return false
+}
+
+// denseRegHisto Compute the register histogram in the dense
+// representation.
+func denseRegHisto(registers []byte, reghisto *[64]int) {
+	for j := 0; j < Registers; j++ {
+		reghisto[denseGetRegister(registers, j)]++
+	}
+}
+
+// rawRegHisto Implements the register histogram calculation for raw
+// encoded HyperLogLogs, one register per byte.
+func rawRegHisto(registers []byte, reghisto *[64]int) {
+	for _, reg := range registers {
+		reghisto[reg]++
+	}
+}
+
+// sigma Helper function sigma as defined in
+// "New cardinality estimation algorithms for HyperLogLog sketches"
+// Otmar Ertl, arXiv:1702.01284
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			break
+		}
+	}
+	return z
+}
+
+// tau Helper function tau as defined in
+// "New cardinality estimation algorithms for HyperLogLog sketches"
+// Otmar Ertl, arXiv:1702.01284
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			break
+		}
+	}
+	return z / 3
+}
+
+// estimate Return the approximated cardinality of the set based on the
+// harmonic mean of the registers values.
+func estimate(reghisto *[64]int) uint64 {
+	m := float64(Registers)
+
+	// Estimate cardinality from register histogram. See:
+	// "New cardinality estimation algorithms for HyperLogLog sketches"
+	// Otmar Ertl, arXiv:1702.01284
+	z := m * tau((m-float64(reghisto[Q+1]))/m)
+	for j := Q; j >= 1; j-- {
+		z += float64(reghisto[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(reghisto[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+// count Return the approximated cardinality of the set, computing the
+// register histogram of the encoding.
+func (h *HyperLogLog) count() uint64 {
+	var reghisto [64]int
+
+	switch h.Encoding() {
+	case Dense:
+		denseRegHisto(h.registers(), &reghisto)
+	case Sparse:
+		sparseRegHisto(h.registers(), &reghisto)
+	}
+	return estimate(&reghisto)
+}
+
+// Add the elements to the HLL, like PFADD. Returns true if at least one
+// register was altered, meaning the approximated cardinality may have
+// changed.
+func (h *HyperLogLog) Add(elements ...[]byte) bool {
+	updated := false
+	for _, ele := range elements {
+		index, count := patLen(ele)
+		if h.set(index, count) {
+			updated = true
+		}
+	}
+	if updated {
+		h.invalidateCache()
+	}
+	return updated
+}
+
+// set Set the register at 'index' to 'count' if greater than the
+// current value, regardless of the encoding.
+func (h *HyperLogLog) set(index int, count uint8) bool {
+	if h.Encoding() == Dense {
+		return denseSet(h.registers(), index, count)
+	}
+	return h.sparseSet(index, count)
+}
+
+// Count Return the approximated cardinality, like PFCOUNT with a single
+// key. The cardinality is cached in the header until the next update.
+func (h *HyperLogLog) Count() uint64 {
+	if h.validCache() {
+		// Just return the cached value.
+		return binary.LittleEndian.Uint64(h.buf[8:])
+	}
+
+	// Recompute it and update the cached value.
+	card := h.count()
+	binary.LittleEndian.PutUint64(h.buf[8:], card)
+	return card
+}
+
+// merge Merge by computing MAX(registers[i],hll[i]) the HyperLogLog
+// 'h' with the raw registers 'max'.
+func (h *HyperLogLog) merge(max []byte) {
+	if h.Encoding() == Dense {
+		for i := 0; i < Registers; i++ {
+			if val := denseGetRegister(h.registers(), i); val > max[i] {
+				max[i] = val
+			}
+		}
+		return
+	}
+	sparseMerge(h.registers(), max)
+}
+
+// Count Return the approximated cardinality of the union of the HLLs,
+// like PFCOUNT with multiple keys. The HLLs are not modified.
+func Count(hlls ...*HyperLogLog) uint64 {
+	if len(hlls) == 1 {
+		return hlls[0].Count()
+	}
+
+	// Compute an HLL with M[i] = MAX(M[i]_j).
+	max := make([]byte, Registers)
+	for _, h := range hlls {
+		h.merge(max)
+	}
+
+	var reghisto [64]int
+	rawRegHisto(max, &reghisto)
+	return estimate(&reghisto)
+}
+
+// Merge the HLLs into 'h', like PFMERGE, so that 'h' approximates the
+// cardinality of the union. The result is dense encoded if any of the
+// HLLs is dense encoded, otherwise it is kept sparse if possible.
+func (h *HyperLogLog) Merge(hlls ...*HyperLogLog) {
+	max := make([]byte, Registers)
+	useDense := h.Encoding() == Dense
+	h.merge(max)
+	for _, src := range hlls {
+		if src.Encoding() == Dense {
+			useDense = true
+		}
+		src.merge(max)
+	}
+
+	// Convert the destination object to dense representation if at
+	// least one of the inputs was dense.
+	if useDense {
+		h.sparseToDense()
+	}
+
+	// Write the resulting HLL to the destination HLL registers. The
+	// sparse representation may be promoted while setting the registers.
+	for j := 0; j < Registers; j++ {
+		if max[j] == 0 {
+			continue
+		}
+		h.set(j, max[j])
+	}
+	h.invalidateCache()
+}
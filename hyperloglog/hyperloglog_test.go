@@ -0,0 +1,198 @@
+package hyperloglog
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	h := Create()
+	if h.Encoding() != Sparse || h.Count() != 0 {
+		t.Fatalf("encoding %d count %d", h.Encoding(), h.Count())
+	}
+	// One XZERO opcode covers all the registers.
+	if len(h.Bytes()) != hdrSize+2 {
+		t.Fatalf("len %d", len(h.Bytes()))
+	}
+	if !bytes.Equal(h.Bytes()[:4], []byte("HYLL")) {
+		t.Fatal("bad magic")
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	registers := make([]byte, denseSize-hdrSize)
+	for i := 0; i < Registers; i++ {
+		denseSetRegister(registers, i, uint8(i%64))
+	}
+	for i := 0; i < Registers; i++ {
+		if v := denseGetRegister(registers, i); v != uint8(i%64) {
+			t.Fatalf("register %d = %d", i, v)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	h := Create()
+	if !h.Add([]byte("a"), []byte("b"), []byte("c")) {
+		t.Fatal("no register updated")
+	}
+	if h.Add([]byte("a")) {
+		t.Fatal("register updated twice")
+	}
+	if c := h.Count(); c != 3 {
+		t.Fatalf("count %d", c)
+	}
+}
+
+func TestAccuracy(t *testing.T) {
+	h := Create()
+	var i int
+	for _, card := range []int{10, 100, 1000, 10000, 100000} {
+		for ; i < card; i++ {
+			h.Add([]byte(fmt.Sprint("ele:", i)))
+		}
+		// The standard error is 0.81%, allow 5 times it.
+		c := h.Count()
+		if err := math.Abs(float64(c)-float64(card)) / float64(card); err > 0.05 {
+			t.Fatalf("card %d, count %d", card, c)
+		}
+	}
+	if h.Encoding() != Dense {
+		t.Fatal("not promoted")
+	}
+}
+
+func TestSparseDense(t *testing.T) {
+	sparse := Create(WithSparseMaxBytes(math.MaxInt32))
+	dense := Create()
+	dense.sparseToDense()
+
+	for i := 0; i < 5000; i++ {
+		ele := []byte(fmt.Sprint(i))
+		if sparse.Add(ele) != dense.Add(ele) {
+			t.Fatalf("%d: updated differs", i)
+		}
+		if i%500 == 0 && sparse.Count() != dense.Count() {
+			t.Fatalf("%d: count %d, dense count %d", i, sparse.Count(), dense.Count())
+		}
+	}
+	if sparse.Encoding() != Sparse {
+		t.Fatal("sparse promoted")
+	}
+
+	var reghisto [64]int
+	if !sparseRegHisto(sparse.registers(), &reghisto) {
+		t.Fatal("invalid sparse representation")
+	}
+
+	sparse.sparseToDense()
+	if !bytes.Equal(sparse.registers(), dense.registers()) {
+		t.Fatal("registers differ")
+	}
+}
+
+func TestPromote(t *testing.T) {
+	h := Create(WithSparseMaxBytes(64))
+	for i := 0; h.Encoding() == Sparse; i++ {
+		h.Add([]byte(fmt.Sprint(i)))
+		if h.Encoding() == Sparse && len(h.Bytes()) > 64 {
+			t.Fatalf("sparse len %d", len(h.Bytes()))
+		}
+	}
+	if len(h.Bytes()) != denseSize {
+		t.Fatalf("dense len %d", len(h.Bytes()))
+	}
+
+	// A register value greater than 32 is not representable sparse.
+	h = Create()
+	h.sparseSet(100, 33)
+	if h.Encoding() != Dense || denseGetRegister(h.registers(), 100) != 33 {
+		t.Fatal("not promoted")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, c := Create(), Create(), Create()
+	all := Create()
+	for i := 0; i < 3000; i++ {
+		ele := []byte(fmt.Sprint(i))
+		switch i % 3 {
+		case 0:
+			a.Add(ele)
+		case 1:
+			b.Add(ele)
+		default:
+			c.Add(ele)
+		}
+		all.Add(ele)
+	}
+	c.sparseToDense()
+
+	if n := Count(a, b, c); n != all.Count() {
+		t.Fatalf("union count %d, want %d", n, all.Count())
+	}
+	if a.Count() == all.Count() {
+		t.Fatal("count of a is not a third")
+	}
+
+	// All sparse, still sparse after merge.
+	x, y := Create(), Create()
+	for i := 0; i < 100; i++ {
+		x.Add([]byte(fmt.Sprint("x", i)))
+		y.Add([]byte(fmt.Sprint("y", i)))
+	}
+	xy := Create()
+	xy.Merge(x, y)
+	if xy.Encoding() != Sparse || xy.Count() != Count(x, y) {
+		t.Fatalf("encoding %d count %d", xy.Encoding(), xy.Count())
+	}
+
+	a.Merge(b, c)
+	if a.Encoding() != Dense || a.Count() != all.Count() {
+		t.Fatalf("encoding %d count %d, want %d", a.Encoding(), a.Count(), all.Count())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	h := Create()
+	for i := 0; i < 100; i++ {
+		h.Add([]byte(fmt.Sprint(i)))
+	}
+	count := h.Count()
+
+	loaded, err := Load(append([]byte(nil), h.Bytes()...))
+	if err != nil || loaded.Count() != count {
+		t.Fatalf("count %d, err %v", loaded.Count(), err)
+	}
+
+	h.sparseToDense()
+	if loaded, err = Load(append([]byte(nil), h.Bytes()...)); err != nil {
+		t.Fatal(err)
+	}
+	loaded.invalidateCache()
+	if loaded.Count() != count {
+		t.Fatalf("dense count %d, want %d", loaded.Count(), count)
+	}
+
+	sparse := Create().Bytes()
+	corrupt := [][]byte{
+		nil,
+		[]byte("HYLL"),
+		append([]byte("HYLX"), sparse[4:]...),
+		h.Bytes()[:denseSize-1],
+		sparse[:hdrSize],
+		append(append([]byte(nil), sparse...), 0),              // one register too many
+		append(append([]byte(nil), sparse[:hdrSize]...), 0x40), // truncated XZERO
+	}
+	bad := append([]byte(nil), sparse...)
+	bad[4] = 2
+	corrupt = append(corrupt, bad)
+
+	for i, c := range corrupt {
+		if _, err := Load(c); err != ErrCorrupt {
+			t.Fatalf("%d: err %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package hyperloglog
+
+// The sparse representation encodes registers using a run length
+// encoding composed of three opcodes, two using one byte, and one using
+// of two bytes. The opcodes are called ZERO, XZERO and VAL.
+//
+// ZERO opcode is represented as 00xxxxxx. The 6-bit integer represented
+// by the six bits 'xxxxxx', plus 1, means that there are N registers set
+// to 0. This opcode can represent from 1 to 64 contiguous registers set
+// to the value of 0.
+//
+// XZERO opcode is represented by two bytes 01xxxxxx yyyyyyyy. The 14-bit
+// integer represented by the bits 'xxxxxx' as most significant bits and
+// 'yyyyyyyy' as least significant bits, plus 1, means that there are N
+// registers set to 0. This opcode can represent from 0 to 16384
+// contiguous registers set to the value of 0.
+//
+// VAL opcode is represented as 1vvvvvxx. It contains a 5-bit integer
+// representing the value of a register, and a 2-bit integer representing
+// the number of contiguous registers set to that value 'vvvvv'. To obtain
+// the value and run length, the integers vvvvv and xx must be incremented
+// by one. This opcode can represent values from 1 to 32, repeated from 1
+// to 4 times.
+//
+// The sparse representation can't represent registers with a value
+// greater than 32, however it is very unlikely that we find such a
+// register in an HLL with a cardinality where the sparse representation
+// is still more memory efficient than the dense representation. When
+// this happens the HLL is converted to the dense representation.
+
+const (
+	sparseXZeroBit    = 0x40
+	sparseValBit      = 0x80
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+)
+
+func sparseIsZero(b byte) bool {
+	return b&0xc0 == 0
+}
+
+func sparseIsXZero(b byte) bool {
+	return b&0xc0 == sparseXZeroBit
+}
+
+func sparseIsVal(b byte) bool {
+	return b&sparseValBit != 0
+}
+
+func sparseZeroLen(b byte) int {
+	return int(b&0x3f) + 1
+}
+
+func sparseXZeroLen(p []byte) int {
+	return (int(p[0]&0x3f)<<8 | int(p[1])) + 1
+}
+
+func sparseValValue(b byte) uint8 {
+	return (b>>2)&0x1f + 1
+}
+
+func sparseValLen(b byte) int {
+	return int(b&0x3) + 1
+}
+
+func sparseValSet(p []byte, val uint8, l int) {
+	p[0] = (val-1)<<2 | byte(l-1) | sparseValBit
+}
+
+func sparseZeroSet(p []byte, l int) {
+	p[0] = byte(l - 1)
+}
+
+func sparseXZeroSet(p []byte, l int) {
+	l--
+	p[0] = byte(l>>8) | sparseXZeroBit
+	p[1] = byte(l)
+}
+
+// sparseRegHisto Compute the register histogram in the sparse
+// representation. Returns false if the sparse representation is invalid:
+// truncated, or not covering exactly all the registers.
+func sparseRegHisto(sparse []byte, reghisto *[64]int) bool {
+	idx := 0
+	for p := 0; p < len(sparse); {
+		if sparseIsZero(sparse[p]) {
+			runlen := sparseZeroLen(sparse[p])
+			idx += runlen
+			reghisto[0] += runlen
+			p++
+		} else if sparseIsXZero(sparse[p]) {
+			if p+1 >= len(sparse) {
+				return false
+			}
+			runlen := sparseXZeroLen(sparse[p:])
+			idx += runlen
+			reghisto[0] += runlen
+			p += 2
+		} else {
+			runlen := sparseValLen(sparse[p])
+			regval := sparseValValue(sparse[p])
+			idx += runlen
+			reghisto[regval] += runlen
+			p++
+		}
+		if idx > Registers {
+			return false
+		}
+	}
+	return idx == Registers
+}
+
+// sparseMerge Merge the sparse registers into the raw registers 'max'.
+func sparseMerge(sparse []byte, max []byte) {
+	i := 0
+	for p := 0; p < len(sparse); {
+		if sparseIsZero(sparse[p]) {
+			i += sparseZeroLen(sparse[p])
+			p++
+		} else if sparseIsXZero(sparse[p]) {
+			i += sparseXZeroLen(sparse[p:])
+			p += 2
+		} else {
+			runlen := sparseValLen(sparse[p])
+			regval := sparseValValue(sparse[p])
+			for ; runlen > 0; runlen-- {
+				if regval > max[i] {
+					max[i] = regval
+				}
+				i++
+			}
+			p++
+		}
+	}
+}
+
+// sparseToDense Convert the HLL with sparse representation to the dense
+// representation. Nothing is done if the HLL is already dense.
+func (h *HyperLogLog) sparseToDense() {
+	if h.Encoding() == Dense {
+		return
+	}
+
+	// Create a string of the right size filled with zero bytes.
+	// Note that the cached cardinality is set to 0 as a side effect
+	// that is exactly the cardinality of an empty HLL.
+	dense := make([]byte, denseSize)
+	copy(dense, h.buf[:hdrSize])
+	dense[4] = Dense
+	registers := dense[hdrSize:]
+
+	// Now read the sparse representation and set non-zero registers
+	// accordingly.
+	idx := 0
+	sparse := h.registers()
+	for p := 0; p < len(sparse); {
+		if sparseIsZero(sparse[p]) {
+			idx += sparseZeroLen(sparse[p])
+			p++
+		} else if sparseIsXZero(sparse[p]) {
+			idx += sparseXZeroLen(sparse[p:])
+			p += 2
+		} else {
+			runlen := sparseValLen(sparse[p])
+			regval := sparseValValue(sparse[p])
+			for ; runlen > 0; runlen-- {
+				denseSetRegister(registers, idx, regval)
+				idx++
+			}
+			p++
+		}
+	}
+	h.buf = dense
+}
+
+// sparseSet Low level function to set the sparse HLL register at
+// 'index' to the specified value if the current value is smaller than
+// 'count'.
+//
+// The HLL is promoted to the dense representation if the new value can't
+// be represented, or the sparse representation grows larger than the
+// sparse max bytes.
+//
+// Returns true if the register was updated.
+func (h *HyperLogLog) sparseSet(index int, count uint8) bool {
+	// If the count is too big to be representable by the sparse
+	// representation, switch to dense representation.
+	if count > sparseValMaxValue {
+		return h.promote(index, count)
+	}
+
+	// Step 1: we need to locate the opcode we need to modify to check
+	// if a value update is actually needed.
+	sparse := h.registers()
+	p, prev := 0, -1
+	first, span := 0, 0
+	for p < len(sparse) {
+		oplen := 1
+		if sparseIsZero(sparse[p]) {
+			span = sparseZeroLen(sparse[p])
+		} else if sparseIsVal(sparse[p]) {
+			span = sparseValLen(sparse[p])
+		} else { // XZERO.
+			span = sparseXZeroLen(sparse[p:])
+			oplen = 2
+		}
+		// Break if this opcode covers the register as 'index'.
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += oplen
+		first += span
+	}
+
+	// Cache current opcode type to avoid using the macro again and
+	// again for something that will not change.
+	// Also cache the run-length of the opcode.
+	isZero, isXZero, isVal := false, false, false
+	runlen := 0
+	if sparseIsZero(sparse[p]) {
+		isZero = true
+		runlen = sparseZeroLen(sparse[p])
+	} else if sparseIsXZero(sparse[p]) {
+		isXZero = true
+		runlen = sparseXZeroLen(sparse[p:])
+	} else {
+		isVal = true
+		runlen = sparseValLen(sparse[p])
+	}
+
+	// Step 2: After the loop:
+	//
+	// 'first' stores to the index of the first register covered
+	// by the current opcode, which is pointed by 'p'.
+	//
+	// 'prev' points to the previous opcode, or is -1 if the opcode at
+	// 'p' is the first one.
+	//
+	// We need to update the register in the following three cases:
+
+	// Case A: If this is a VAL opcode that already has a value >= count,
+	// there is nothing to do, otherwise if its length is 1 just update
+	// the value.
+	if isVal {
+		oldcount := sparseValValue(sparse[p])
+		// Case A.
+		if oldcount >= count {
+			return false
+		}
+
+		// Case B.
+		if runlen == 1 {
+			sparseValSet(sparse[p:], count, 1)
+			h.sparseMergeValues(prev)
+			return true
+		}
+	}
+
+	// Case C: a ZERO opcode with len 1 can be just replaced by a VAL
+	// opcode with len 1.
+	if isZero && runlen == 1 {
+		sparseValSet(sparse[p:], count, 1)
+		h.sparseMergeValues(prev)
+		return true
+	}
+
+	// Case D: General case: the opcode is split into up to three opcodes:
+	// the registers before 'index' with the old value, the register at
+	// 'index' with the new value, and the registers after 'index' with
+	// the old value.
+	var seq [5]byte
+	n := 0
+	last := first + span - 1 // Last register covered by the sequence.
+
+	if isZero || isXZero {
+		// Handle splitting of ZERO / XZERO.
+		if index != first {
+			l := index - first
+			if l > sparseZeroMaxLen {
+				sparseXZeroSet(seq[n:], l)
+				n += 2
+			} else {
+				sparseZeroSet(seq[n:], l)
+				n++
+			}
+		}
+		sparseValSet(seq[n:], count, 1)
+		n++
+		if index != last {
+			l := last - index
+			if l > sparseZeroMaxLen {
+				sparseXZeroSet(seq[n:], l)
+				n += 2
+			} else {
+				sparseZeroSet(seq[n:], l)
+				n++
+			}
+		}
+	} else {
+		// Handle splitting of VAL.
+		curval := sparseValValue(sparse[p])
+
+		if index != first {
+			sparseValSet(seq[n:], curval, index-first)
+			n++
+		}
+		sparseValSet(seq[n:], count, 1)
+		n++
+		if index != last {
+			sparseValSet(seq[n:], curval, last-index)
+			n++
+		}
+	}
+
+	// Step 3: substitute the new sequence with the old one, promoting
+	// the HLL if the sparse representation grows too large.
+	oldlen := 1
+	if isXZero {
+		oldlen = 2
+	}
+	deltalen := n - oldlen
+
+	if deltalen > 0 && len(h.buf)+deltalen > h.sparseMaxBytes {
+		return h.promote(index, count)
+	}
+
+	buf := make([]byte, 0, len(h.buf)+deltalen)
+	buf = append(buf, h.buf[:hdrSize+p]...)
+	buf = append(buf, seq[:n]...)
+	buf = append(buf, h.buf[hdrSize+p+oldlen:]...)
+	h.buf = buf
+
+	h.sparseMergeValues(prev)
+	return true
+}
+
+// sparseMergeValues Step 4: Merge adjacent values if possible.
+//
+// The representation was updated, however the resulting representation
+// may not be optimal: adjacent VAL opcodes can sometimes be merged into
+// a single one. Scan up to 5 opcodes starting from 'prev'.
+func (h *HyperLogLog) sparseMergeValues(prev int) {
+	p := prev
+	if p == -1 {
+		p = 0
+	}
+	p += hdrSize
+
+	for scanlen := 5; p < len(h.buf) && scanlen > 0; scanlen-- {
+		if sparseIsXZero(h.buf[p]) {
+			p += 2
+			continue
+		} else if sparseIsZero(h.buf[p]) {
+			p++
+			continue
+		}
+
+		// We need two adjacent VAL opcodes to try a merge, having
+		// the same value, and a len that fits the VAL opcode max len.
+		if p+1 < len(h.buf) && sparseIsVal(h.buf[p+1]) {
+			v1 := sparseValValue(h.buf[p])
+			v2 := sparseValValue(h.buf[p+1])
+			if v1 == v2 {
+				l := sparseValLen(h.buf[p]) + sparseValLen(h.buf[p+1])
+				if l <= sparseValMaxLen {
+					sparseValSet(h.buf[p+1:], v1, l)
+					h.buf = append(h.buf[:p], h.buf[p+1:]...)
+					// After a merge we reiterate without incrementing
+					// 'p' in order to try to merge the just merged
+					// value with a value on its right.
+					continue
+				}
+			}
+		}
+		p++
+	}
+}
+
+// promote Convert to dense and set the register, the sparse
+// representation can't represent the register anymore.
+func (h *HyperLogLog) promote(index int, count uint8) bool {
+	h.sparseToDense()
+	return denseSet(h.registers(), index, count)
+}
@@ -0,0 +1,26 @@
+package intset
+
+import (
+	"dict"
+	"sds"
+)
+
+// MaxEntries Default max number of entries of an intset, similar to
+// set-max-intset-entries of redis. Larger sets are converted to a dict.
+const MaxEntries = 512
+
+// NeedConvert Check if the intset has more than 'maxEntries' entries, in
+// which case it should be converted with ToDict.
+func (is *Intset) NeedConvert(maxEntries int) bool {
+	return is.Len() > maxEntries
+}
+
+// ToDict Convert the intset to a dict.Dict without values, every integer
+// being a key in its decimal form, as a *sds.Key.
+func (is *Intset) ToDict() *dict.Dict {
+	d := dict.Create()
+	for i := 0; i < is.Len(); i++ {
+		d.Add((*sds.Key)(sds.FromLongLong(is.get(i))), nil)
+	}
+	return d
+}
@@ -0,0 +1,32 @@
+package intset
+
+import (
+	"testing"
+
+	"sds"
+)
+
+func TestToDict(t *testing.T) {
+	is := Create()
+	for i := int64(-10); i < 10; i++ {
+		is.Add(i * 1000)
+	}
+	if is.NeedConvert(MaxEntries) || !is.NeedConvert(10) {
+		t.Fatal("need convert")
+	}
+
+	d := is.ToDict()
+	defer d.Close()
+	for i := int64(-10); i < 10; i++ {
+		e := d.Find((*sds.Key)(sds.FromLongLong(i * 1000)))
+		if e == nil {
+			t.Fatalf("%d not found", i*1000)
+		}
+		if e.Value() != nil {
+			t.Fatalf("%d has a value", i*1000)
+		}
+	}
+	if d.Find((*sds.Key)(sds.NewString("1"))) != nil {
+		t.Fatal("found missing key")
+	}
+}
@@ -0,0 +1,13 @@
+module intset
+
+go 1.14
+
+require (
+	dict v0.0.0
+	sds v0.0.0
+)
+
+replace (
+	dict => ../dict
+	sds => ../sds
+)
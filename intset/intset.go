@@ -0,0 +1,366 @@
+package intset
+
+// An intset is a sorted set of integers stored in a packed byte array.
+// Every integer uses the same width, the smallest encoding able to hold
+// all of them: adding an integer that doesn't fit upgrades the whole set
+// to a larger encoding. The set is never downgraded.
+//
+// The layout of the blob is as follows, all the fields in little endian:
+//
+// <encoding uint32> <length uint32> <contents>
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// Error
+var (
+	// ErrCorrupt the intset blob is malformed.
+	ErrCorrupt = errors.New("intset: corrupt blob")
+)
+
+// Note that these encodings are ordered, so:
+// EncInt16 < EncInt32 < EncInt64.
+const (
+	// EncInt16 2 bytes integers.
+	EncInt16 uint32 = 2
+	// EncInt32 4 bytes integers.
+	EncInt32 uint32 = 4
+	// EncInt64 8 bytes integers.
+	EncInt64 uint32 = 8
+
+	headerSize = 8
+)
+
+// Intset intset.
+type Intset struct {
+	buf []byte
+}
+
+// valueEncoding Return the required encoding for the provided value.
+func valueEncoding(v int64) uint32 {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return EncInt64
+	} else if v < math.MinInt16 || v > math.MaxInt16 {
+		return EncInt32
+	}
+	return EncInt16
+}
+
+// Create an empty intset.
+func Create() *Intset {
+	is := &Intset{
+		buf: make([]byte, headerSize),
+	}
+	is.setEncoding(EncInt16)
+	is.setLength(0)
+	return is
+}
+
+// Load Create an intset from a blob previously returned by Bytes.
+// The blob is validated, ErrCorrupt is returned if it is malformed.
+func Load(blob []byte) (*Intset, error) {
+	if !validateIntegrity(blob, true) {
+		return nil, ErrCorrupt
+	}
+	return &Intset{
+		buf: blob,
+	}, nil
+}
+
+// Bytes Return the serialized intset, the slice is owned by the intset.
+func (is *Intset) Bytes() []byte {
+	return is.buf
+}
+
+// BlobLen Return intset blob size in bytes.
+func (is *Intset) BlobLen() int {
+	return len(is.buf)
+}
+
+func (is *Intset) encoding() uint32 {
+	return binary.LittleEndian.Uint32(is.buf[0:])
+}
+
+func (is *Intset) setEncoding(encoding uint32) {
+	binary.LittleEndian.PutUint32(is.buf[0:], encoding)
+}
+
+func (is *Intset) length() int {
+	return int(binary.LittleEndian.Uint32(is.buf[4:]))
+}
+
+func (is *Intset) setLength(n int) {
+	binary.LittleEndian.PutUint32(is.buf[4:], uint32(n))
+}
+
+// getEncoded Return the value at pos, given an encoding.
+func (is *Intset) getEncoded(pos int, enc uint32) int64 {
+	p := headerSize + pos*int(enc)
+	switch enc {
+	case EncInt64:
+		return int64(binary.LittleEndian.Uint64(is.buf[p:]))
+	case EncInt32:
+		return int64(int32(binary.LittleEndian.Uint32(is.buf[p:])))
+	}
+	return int64(int16(binary.LittleEndian.Uint16(is.buf[p:])))
+}
+
+// get Return the value at pos, using the configured encoding.
+func (is *Intset) get(pos int) int64 {
+	return is.getEncoded(pos, is.encoding())
+}
+
+// set Set the value at pos, using the configured encoding.
+func (is *Intset) set(pos int, value int64) {
+	enc := is.encoding()
+	p := headerSize + pos*int(enc)
+	switch enc {
+	case EncInt64:
+		binary.LittleEndian.PutUint64(is.buf[p:], uint64(value))
+	case EncInt32:
+		binary.LittleEndian.PutUint32(is.buf[p:], uint32(int32(value)))
+	default:
+		binary.LittleEndian.PutUint16(is.buf[p:], uint16(int16(value)))
+	}
+}
+
+// resize Resize the intset to hold 'l' integers.
+func (is *Intset) resize(l int) {
+	size := headerSize + l*int(is.encoding())
+	if size <= cap(is.buf) {
+		is.buf = is.buf[:size]
+		return
+	}
+	buf := make([]byte, size, size+size/2)
+	copy(buf, is.buf)
+	is.buf = buf
+}
+
+// search Search for the position of "value". Return true when the value
+// was found and set "pos" to the position of the value within the intset.
+// Return false when the value is not present in the intset and set "pos"
+// to the position where "value" can be inserted.
+func (is *Intset) search(value int64) (pos int, found bool) {
+	min, max := 0, is.length()-1
+
+	// The value can never be found when the set is empty
+	if is.length() == 0 {
+		return 0, false
+	}
+
+	// Check for the case where we know we cannot find the value,
+	// but do know the insert position.
+	if value > is.get(max) {
+		return is.length(), false
+	} else if value < is.get(0) {
+		return 0, false
+	}
+
+	mid := -1
+	var cur int64
+	for max >= min {
+		mid = int(uint(min+max) >> 1)
+		cur = is.get(mid)
+		if value > cur {
+			min = mid + 1
+		} else if value < cur {
+			max = mid - 1
+		} else {
+			break
+		}
+	}
+
+	if value == cur {
+		return mid, true
+	}
+	return min, false
+}
+
+// upgradeAndAdd Upgrades the intset to a larger encoding and inserts the
+// given integer.
+func (is *Intset) upgradeAndAdd(value int64) {
+	curenc := is.encoding()
+	newenc := valueEncoding(value)
+	length := is.length()
+	prepend := 0
+	if value < 0 {
+		prepend = 1
+	}
+
+	// First set new encoding and resize
+	old := is.buf
+	is.buf = make([]byte, headerSize+(length+1)*int(newenc))
+	copy(is.buf, old[:headerSize])
+	is.setEncoding(newenc)
+
+	// Upgrade back-to-front so we don't overwrite values.
+	// Note that the "prepend" variable is used to make sure we have an
+	// empty space at either the beginning or the end of the intset.
+	src := &Intset{buf: old}
+	for ; length > 0; length-- {
+		is.set(length-1+prepend, src.getEncoded(length-1, curenc))
+	}
+
+	// Set the value at the beginning or the end.
+	if prepend == 1 {
+		is.set(0, value)
+	} else {
+		is.set(is.length(), value)
+	}
+	is.setLength(is.length() + 1)
+}
+
+// moveTail Move the integers from 'from' to the end one position to 'to'.
+func (is *Intset) moveTail(from, to int) {
+	enc := int(is.encoding())
+	bytes := (is.length() - from) * enc
+	src := headerSize + from*enc
+	dst := headerSize + to*enc
+	copy(is.buf[dst:dst+bytes], is.buf[src:src+bytes])
+}
+
+// Add Insert an integer in the intset. Returns false if the value was
+// already in the set.
+func (is *Intset) Add(value int64) bool {
+	valenc := valueEncoding(value)
+
+	// Upgrade encoding if necessary. If we need to upgrade, we know that
+	// this value should be either appended (if > 0) or prepended (if < 0),
+	// because it lies outside the range of existing values.
+	if valenc > is.encoding() {
+		// This always succeeds, so we don't need to curry *success.
+		is.upgradeAndAdd(value)
+		return true
+	}
+
+	// Abort if the value is already present in the set.
+	// This call will populate "pos" with the right position to insert
+	// the value when it cannot be found.
+	pos, found := is.search(value)
+	if found {
+		return false
+	}
+
+	is.resize(is.length() + 1)
+	if pos < is.length() {
+		is.moveTail(pos, pos+1)
+	}
+
+	is.set(pos, value)
+	is.setLength(is.length() + 1)
+	return true
+}
+
+// Remove Delete integer from intset. Returns false if the value was not
+// in the set.
+func (is *Intset) Remove(value int64) bool {
+	valenc := valueEncoding(value)
+	if valenc > is.encoding() {
+		return false
+	}
+
+	pos, found := is.search(value)
+	if !found {
+		return false
+	}
+
+	length := is.length()
+
+	// Overwrite value with tail and update length
+	if pos < length-1 {
+		is.moveTail(pos+1, pos)
+	}
+	is.setLength(length - 1)
+	is.resize(length - 1)
+	return true
+}
+
+// Find Determine whether a value belongs to this set.
+func (is *Intset) Find(value int64) bool {
+	valenc := valueEncoding(value)
+	if valenc > is.encoding() {
+		return false
+	}
+	_, found := is.search(value)
+	return found
+}
+
+// Random Return random member. The set must not be empty.
+func (is *Intset) Random() int64 {
+	return is.get(rand.Intn(is.length()))
+}
+
+// Max Return the largest member. The set must not be empty.
+func (is *Intset) Max() int64 {
+	return is.get(is.length() - 1)
+}
+
+// Min Return the smallest member. The set must not be empty.
+func (is *Intset) Min() int64 {
+	return is.get(0)
+}
+
+// Get Get the value at the given position. When this position is out of
+// range the function returns false.
+func (is *Intset) Get(pos int) (int64, bool) {
+	if pos < 0 || pos >= is.length() {
+		return 0, false
+	}
+	return is.get(pos), true
+}
+
+// Len Return intset length.
+func (is *Intset) Len() int {
+	return is.length()
+}
+
+// Encoding Return the encoding of the integers, EncInt16, EncInt32 or
+// EncInt64.
+func (is *Intset) Encoding() uint32 {
+	return is.encoding()
+}
+
+// validateIntegrity Validate the integrity of the data structure.
+// when `deep` is false, only the integrity of the header is validated.
+// when `deep` is true, we make sure there are no duplicate or out of
+// order records.
+func validateIntegrity(blob []byte, deep bool) bool {
+	// check that we can actually read the header.
+	if len(blob) < headerSize {
+		return false
+	}
+
+	is := &Intset{buf: blob}
+	enc := is.encoding()
+	if enc != EncInt64 && enc != EncInt32 && enc != EncInt16 {
+		return false
+	}
+
+	// check that the size matches (all records are inside the buffer).
+	count := uint64(is.length())
+	if count*uint64(enc)+headerSize != uint64(len(blob)) {
+		return false
+	}
+
+	if !deep {
+		return true
+	}
+
+	// check that the set is sorted and has no duplicates.
+	if count == 0 {
+		return true
+	}
+	prev := is.get(0)
+	for i := 1; i < int(count); i++ {
+		cur := is.get(i)
+		if cur <= prev {
+			return false
+		}
+		prev = cur
+	}
+	return true
+}
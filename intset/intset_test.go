@@ -0,0 +1,176 @@
+package intset
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func checkConsistency(t *testing.T, is *Intset) {
+	t.Helper()
+	for i := 1; i < is.Len(); i++ {
+		if is.get(i-1) >= is.get(i) {
+			t.Fatalf("not sorted at %d: %d >= %d", i, is.get(i-1), is.get(i))
+		}
+	}
+	if is.BlobLen() != headerSize+is.Len()*int(is.Encoding()) {
+		t.Fatalf("blob len %d, len %d, encoding %d", is.BlobLen(), is.Len(), is.Encoding())
+	}
+}
+
+func TestValueEncoding(t *testing.T) {
+	tests := []struct {
+		v   int64
+		enc uint32
+	}{
+		{-32768, EncInt16},
+		{32767, EncInt16},
+		{-32769, EncInt32},
+		{32768, EncInt32},
+		{math.MinInt32, EncInt32},
+		{math.MaxInt32, EncInt32},
+		{math.MinInt32 - 1, EncInt64},
+		{math.MaxInt32 + 1, EncInt64},
+		{math.MinInt64, EncInt64},
+		{math.MaxInt64, EncInt64},
+	}
+	for _, tt := range tests {
+		if enc := valueEncoding(tt.v); enc != tt.enc {
+			t.Fatalf("valueEncoding(%d) = %d, want %d", tt.v, enc, tt.enc)
+		}
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	is := Create()
+	if !is.Add(5) || !is.Add(6) || !is.Add(4) {
+		t.Fatal("add failed")
+	}
+	if is.Add(4) {
+		t.Fatal("duplicate added")
+	}
+	if is.Len() != 3 || is.Min() != 4 || is.Max() != 6 {
+		t.Fatalf("len %d min %d max %d", is.Len(), is.Min(), is.Max())
+	}
+	if !is.Remove(5) || is.Remove(5) || is.Remove(100000) {
+		t.Fatal("remove failed")
+	}
+	if is.Find(5) || !is.Find(4) || !is.Find(6) {
+		t.Fatal("find failed")
+	}
+	if _, ok := is.Get(2); ok {
+		t.Fatal("get out of range")
+	}
+	checkConsistency(t, is)
+}
+
+func TestUpgrade(t *testing.T) {
+	is := Create()
+	is.Add(32)
+	if is.Encoding() != EncInt16 {
+		t.Fatalf("encoding %d", is.Encoding())
+	}
+
+	// Positive values are appended.
+	is.Add(65535)
+	if is.Encoding() != EncInt32 || !is.Find(32) || !is.Find(65535) {
+		t.Fatal("upgrade to int32 failed")
+	}
+	checkConsistency(t, is)
+
+	// Negative values are prepended.
+	is.Add(-4294967295)
+	if is.Encoding() != EncInt64 || is.Min() != -4294967295 || is.Max() != 65535 {
+		t.Fatal("upgrade to int64 failed")
+	}
+	if v, _ := is.Get(1); v != 32 {
+		t.Fatalf("get(1) = %d", v)
+	}
+	checkConsistency(t, is)
+
+	// Wider values are never found in a narrower set.
+	is = Create()
+	is.Add(1)
+	if is.Find(math.MaxInt64) || is.Remove(math.MinInt64) {
+		t.Fatal("found wider value")
+	}
+}
+
+func TestStress(t *testing.T) {
+	is := Create()
+	seen := make(map[int64]bool)
+	for i := 0; i < 1024; i++ {
+		v := rand.Int63n(1<<40) - 1<<39
+		if is.Add(v) == seen[v] {
+			t.Fatalf("add %d", v)
+		}
+		seen[v] = true
+	}
+	checkConsistency(t, is)
+	if is.Len() != len(seen) {
+		t.Fatalf("len %d, want %d", is.Len(), len(seen))
+	}
+	for v := range seen {
+		if !is.Find(v) {
+			t.Fatalf("%d not found", v)
+		}
+		if r := is.Random(); !seen[r] {
+			t.Fatalf("random %d not in set", r)
+		}
+	}
+	for v := range seen {
+		if !is.Remove(v) {
+			t.Fatalf("remove %d", v)
+		}
+	}
+	if is.Len() != 0 || is.BlobLen() != headerSize+0 {
+		t.Fatalf("len %d, blob len %d", is.Len(), is.BlobLen())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	is := Create()
+	for _, v := range []int64{-3, 70000, 1, 2} {
+		is.Add(v)
+	}
+	blob := append([]byte(nil), is.Bytes()...)
+	loaded, err := Load(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 4 || !loaded.Find(70000) || !loaded.Find(-3) {
+		t.Fatal("loaded intset differs")
+	}
+	if _, err := Load(Create().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := [][]byte{
+		nil,
+		blob[:7],
+		blob[:len(blob)-1],
+	}
+	// bad encoding
+	bad := append([]byte(nil), blob...)
+	bad[0] = 3
+	corrupt = append(corrupt, bad)
+	// wrong length
+	bad = append([]byte(nil), blob...)
+	bad[4] = 5
+	corrupt = append(corrupt, bad)
+	// out of order, swap the first two int32
+	bad = append([]byte(nil), blob...)
+	copy(bad[8:12], blob[12:16])
+	copy(bad[12:16], blob[8:12])
+	corrupt = append(corrupt, bad)
+	// duplicates
+	bad = append([]byte(nil), blob...)
+	copy(bad[12:16], blob[8:12])
+	corrupt = append(corrupt, bad)
+
+	for i, c := range corrupt {
+		if _, err := Load(c); err != ErrCorrupt {
+			t.Fatalf("%d: err %v", i, err)
+		}
+	}
+}
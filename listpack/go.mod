@@ -0,0 +1,7 @@
+module listpack
+
+go 1.14
+
+require util v0.0.0
+
+replace util => ../util
@@ -0,0 +1,824 @@
+package listpack
+
+// Listpack -- A lists of strings serialization format
+//
+// This file implements the specification you can find at:
+//
+//   https://github.com/antirez/listpack
+//
+// The listpack is the successor of the ziplist: instead of storing the
+// length of the previous entry at the start of every entry, every entry
+// ends with its own length (the backlen), so that updating an entry never
+// changes the other entries and there is no cascade update.
+//
+// The general layout of the listpack is as follows:
+//
+// <tot-bytes> <num-elements> <element-1> ... <element-N> <listpack-end-byte>
+//
+// Every element is encoded as:
+//
+// <encoding-type><element-data><element-tot-len>
+//
+// Where element-tot-len is the length of encoding-type + element-data,
+// stored from right to left in 7 bits chunks, the most significant bit of
+// every byte telling if there is another byte to its left.
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"util"
+)
+
+// Error
+var (
+	// ErrCorrupt the listpack blob is malformed.
+	ErrCorrupt = errors.New("listpack: corrupt blob")
+)
+
+// Where to insert, relative to the element 'p'.
+const (
+	// Before insert before p.
+	Before = 0
+	// After insert after p.
+	After = 1
+	// Replace replace p.
+	Replace = 2
+)
+
+const (
+	// HdrSize header size, 32 bit total len + 16 bit number of elements.
+	HdrSize = 6
+	// hdrNumeleUnknown number of elements too large to be stored in the
+	// header, the listpack must be scanned to count them.
+	hdrNumeleUnknown = math.MaxUint16
+	// maxIntEncodingLen max length of a string converted to integer.
+	maxIntEncodingLen = 32
+	// maxBacklenSize max number of bytes of the backlen.
+	maxBacklenSize = 5
+	// safetySize max listpack size, we don't want to create a listpack
+	// larger than 1GB.
+	safetySize = 1 << 30
+
+	encoding7BitUint     byte = 0
+	encoding7BitUintMask byte = 0x80
+	encoding6BitStr      byte = 0x80
+	encoding6BitStrMask  byte = 0xC0
+	encoding13BitInt     byte = 0xC0
+	encoding13BitIntMask byte = 0xE0
+	encoding12BitStr     byte = 0xE0
+	encoding12BitStrMask byte = 0xF0
+	encoding16BitInt     byte = 0xF1
+	encoding24BitInt     byte = 0xF2
+	encoding32BitInt     byte = 0xF3
+	encoding64BitInt     byte = 0xF4
+	encoding32BitStr     byte = 0xF0
+	encodingMask         byte = 0xFF
+
+	eof byte = 0xFF
+)
+
+// Listpack listpack.
+type Listpack struct {
+	buf []byte
+}
+
+// Create a new, empty listpack.
+func Create() *Listpack {
+	lp := &Listpack{
+		buf: make([]byte, HdrSize+1),
+	}
+	lp.setTotalBytes(HdrSize + 1)
+	lp.setNumElements(0)
+	lp.buf[HdrSize] = eof
+	return lp
+}
+
+// Load Create a listpack from a blob previously returned by Bytes.
+// The blob is deeply validated, so it is safe to load untrusted data.
+func Load(blob []byte) (*Listpack, error) {
+	if err := Validate(blob, true); err != nil {
+		return nil, err
+	}
+	return &Listpack{
+		buf: blob,
+	}, nil
+}
+
+// Bytes Return the serialized listpack, the slice is owned by the listpack.
+func (lp *Listpack) Bytes() []byte {
+	return lp.buf
+}
+
+// BlobLen Return the total number of bytes the listpack is composed of.
+func (lp *Listpack) BlobLen() int {
+	return len(lp.buf)
+}
+
+// SafeToAdd Check if adding 'add' bytes to the listpack keeps it under
+// the safety size. A nil listpack is considered empty.
+func (lp *Listpack) SafeToAdd(add int) bool {
+	l := 0
+	if lp != nil {
+		l = len(lp.buf)
+	}
+	return l+add <= safetySize
+}
+
+func (lp *Listpack) setTotalBytes(n int) {
+	binary.LittleEndian.PutUint32(lp.buf[0:], uint32(n))
+}
+
+func (lp *Listpack) numElements() int {
+	return int(binary.LittleEndian.Uint16(lp.buf[4:]))
+}
+
+func (lp *Listpack) setNumElements(n int) {
+	binary.LittleEndian.PutUint16(lp.buf[4:], uint16(n))
+}
+
+func (lp *Listpack) eofOffset() int {
+	return len(lp.buf) - 1
+}
+
+// stringToInt64 Convert a string into a signed 64 bit integer.
+// The function returns false if the string can't be converted into a
+// signed 64 bit integer, or if it is not in its canonical form.
+func stringToInt64(s []byte) (int64, bool) {
+	if len(s) == 0 || len(s) >= maxIntEncodingLen {
+		return 0, false
+	}
+	return util.String2ll(s)
+}
+
+// encodeIntegerGetType Encode the integer 'v', writing the encoded bytes
+// to 'buf' when it is not nil. Returns the number of bytes of the encoding.
+func encodeIntegerGetType(v int64, buf []byte) int {
+	switch {
+	case v >= 0 && v <= 127:
+		// Single byte 0-127 integer.
+		if buf != nil {
+			buf[0] = byte(v)
+		}
+		return 1
+	case v >= -4096 && v <= 4095:
+		// 13 bit integer.
+		if v < 0 {
+			v = (1 << 13) + v
+		}
+		if buf != nil {
+			buf[0] = byte(v>>8) | encoding13BitInt
+			buf[1] = byte(v)
+		}
+		return 2
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		// 16 bit integer.
+		if buf != nil {
+			buf[0] = encoding16BitInt
+			binary.LittleEndian.PutUint16(buf[1:], uint16(v))
+		}
+		return 3
+	case v >= -8388608 && v <= 8388607:
+		// 24 bit integer.
+		if buf != nil {
+			u := uint32(v)
+			buf[0] = encoding24BitInt
+			buf[1], buf[2], buf[3] = byte(u), byte(u>>8), byte(u>>16)
+		}
+		return 4
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		// 32 bit integer.
+		if buf != nil {
+			buf[0] = encoding32BitInt
+			binary.LittleEndian.PutUint32(buf[1:], uint32(v))
+		}
+		return 5
+	}
+	// 64 bit integer.
+	if buf != nil {
+		buf[0] = encoding64BitInt
+		binary.LittleEndian.PutUint64(buf[1:], uint64(v))
+	}
+	return 9
+}
+
+// encodeString Encode the string 's', writing the encoded bytes to 'buf'
+// when it is not nil. Returns the number of bytes of the encoding.
+func encodeString(s []byte, buf []byte) int {
+	l := len(s)
+	switch {
+	case l < 64:
+		if buf != nil {
+			buf[0] = byte(l) | encoding6BitStr
+			copy(buf[1:], s)
+		}
+		return 1 + l
+	case l < 4096:
+		if buf != nil {
+			buf[0] = byte(l>>8) | encoding12BitStr
+			buf[1] = byte(l)
+			copy(buf[2:], s)
+		}
+		return 2 + l
+	}
+	if buf != nil {
+		buf[0] = encoding32BitStr
+		binary.LittleEndian.PutUint32(buf[1:], uint32(l))
+		copy(buf[5:], s)
+	}
+	return 5 + l
+}
+
+// encodeBacklen Store a reverse-encoded variable length field, representing
+// the length of the previous element of size 'l', in the target buffer,
+// when it is not nil. The function returns the number of bytes used to
+// encode it, from 1 to 5.
+func encodeBacklen(buf []byte, l uint64) int {
+	switch {
+	case l <= 127:
+		if buf != nil {
+			buf[0] = byte(l)
+		}
+		return 1
+	case l < 16383:
+		if buf != nil {
+			buf[0] = byte(l >> 7)
+			buf[1] = byte(l&127) | 128
+		}
+		return 2
+	case l < 2097151:
+		if buf != nil {
+			buf[0] = byte(l >> 14)
+			buf[1] = byte((l>>7)&127) | 128
+			buf[2] = byte(l&127) | 128
+		}
+		return 3
+	case l < 268435455:
+		if buf != nil {
+			buf[0] = byte(l >> 21)
+			buf[1] = byte((l>>14)&127) | 128
+			buf[2] = byte((l>>7)&127) | 128
+			buf[3] = byte(l&127) | 128
+		}
+		return 4
+	}
+	if buf != nil {
+		buf[0] = byte(l >> 28)
+		buf[1] = byte((l>>21)&127) | 128
+		buf[2] = byte((l>>14)&127) | 128
+		buf[3] = byte((l>>7)&127) | 128
+		buf[4] = byte(l&127) | 128
+	}
+	return 5
+}
+
+// decodeBacklen Decode the backlen and returns it. 'p' is the offset of
+// the last byte of the backlen. ok is false if the backlen is malformed
+// or runs before 'start'.
+func decodeBacklen(buf []byte, p int, start int) (l uint64, ok bool) {
+	var shift uint
+	for {
+		if p < start || p >= len(buf) {
+			return 0, false
+		}
+		l |= uint64(buf[p]&127) << shift
+		if buf[p]&128 == 0 {
+			return l, true
+		}
+		shift += 7
+		p--
+		if shift > 28 {
+			return 0, false
+		}
+	}
+}
+
+// encodedSizeBytes Return the number of bytes needed to encode the length
+// of the element, starting at its first byte, or 0 for an invalid encoding.
+func encodedSizeBytes(b byte) int {
+	switch {
+	case b&encoding7BitUintMask == encoding7BitUint:
+		return 1
+	case b&encoding6BitStrMask == encoding6BitStr:
+		return 1
+	case b&encoding13BitIntMask == encoding13BitInt:
+		return 1
+	case b&encoding12BitStrMask == encoding12BitStr:
+		return 2
+	case b == encoding16BitInt, b == encoding24BitInt, b == encoding32BitInt, b == encoding64BitInt:
+		return 1
+	case b == encoding32BitStr:
+		return 5
+	}
+	return 0
+}
+
+// currentEncodedSize Return the encoded length of the element at 'p',
+// that is the length of encoding-type + element-data without the backlen.
+// ok is false when the encoding is invalid or truncated.
+func currentEncodedSize(buf []byte, p int) (int, bool) {
+	if p >= len(buf) {
+		return 0, false
+	}
+
+	b := buf[p]
+	n := encodedSizeBytes(b)
+	if n == 0 || p+n > len(buf) {
+		return 0, false
+	}
+
+	switch {
+	case b&encoding7BitUintMask == encoding7BitUint:
+		return 1, true
+	case b&encoding6BitStrMask == encoding6BitStr:
+		return 1 + int(b&0x3f), true
+	case b&encoding13BitIntMask == encoding13BitInt:
+		return 2, true
+	case b&encoding12BitStrMask == encoding12BitStr:
+		return 2 + (int(b&0x0f)<<8 | int(buf[p+1])), true
+	case b == encoding16BitInt:
+		return 3, true
+	case b == encoding24BitInt:
+		return 4, true
+	case b == encoding32BitInt:
+		return 5, true
+	case b == encoding64BitInt:
+		return 9, true
+	}
+	// encoding32BitStr
+	return 5 + int(binary.LittleEndian.Uint32(buf[p+1:])), true
+}
+
+// skip Skip the current entry returning the next. It is invalid to call
+// this function if the current element is the EOF element.
+func (lp *Listpack) skip(p int) int {
+	entrylen, _ := currentEncodedSize(lp.buf, p)
+	entrylen += encodeBacklen(nil, uint64(entrylen))
+	return p + entrylen
+}
+
+// Next If 'p' points to an element of the listpack, calling Next will
+// return the offset of the next element (the one on the right), or -1 if
+// 'p' already pointed to the last element of the listpack.
+func (lp *Listpack) Next(p int) int {
+	if p < 0 || lp.buf[p] == eof {
+		return -1
+	}
+	p = lp.skip(p)
+	if lp.buf[p] == eof {
+		return -1
+	}
+	return p
+}
+
+// Prev If 'p' points to an element of the listpack, calling Prev will
+// return the offset of the previous element (the one on the left), or -1
+// if 'p' already pointed to the first element of the listpack. When 'p'
+// is the EOF element, the last element is returned.
+func (lp *Listpack) Prev(p int) int {
+	if p <= HdrSize {
+		return -1
+	}
+	p-- // Seek the first backlen byte of the last element.
+	prevlen, _ := decodeBacklen(lp.buf, p, HdrSize)
+	prevlen += uint64(encodeBacklen(nil, prevlen))
+	return p - int(prevlen) + 1 // Seek the first byte of the previous entry.
+}
+
+// First Return the offset of the first element of the listpack, or -1 if
+// the listpack has no elements.
+func (lp *Listpack) First() int {
+	if lp.buf[HdrSize] == eof {
+		return -1
+	}
+	return HdrSize
+}
+
+// Last Return the offset of the last element of the listpack, or -1 if
+// the listpack has no elements.
+func (lp *Listpack) Last() int {
+	return lp.Prev(lp.eofOffset())
+}
+
+// Len Return the number of elements inside the listpack. This function
+// attempts to use the cached value when within range, otherwise a full
+// scan is needed.
+func (lp *Listpack) Len() int {
+	if n := lp.numElements(); n != hdrNumeleUnknown {
+		return n
+	}
+
+	// Too many elements inside the listpack. We need to scan in order
+	// to get the total number.
+	count := 0
+	for p := lp.First(); p != -1; p = lp.Next(p) {
+		count++
+	}
+
+	// If the count is again within range of the header numele field,
+	// set it.
+	if count < hdrNumeleUnknown {
+		lp.setNumElements(count)
+	}
+	return count
+}
+
+// Get Return the listpack element pointed by 'p'. Depending on the
+// encoding of the element, either sval (a non nil slice, also for the
+// empty string) or lval is set. The returned slice is owned by the
+// listpack. ok is false when 'p' is -1 or points to the EOF element.
+func (lp *Listpack) Get(p int) (sval []byte, lval int64, ok bool) {
+	if p < 0 || lp.buf[p] == eof {
+		return nil, 0, false
+	}
+
+	var (
+		uval     uint64
+		negstart uint64
+		negmax   uint64
+	)
+
+	b := lp.buf[p]
+	switch {
+	case b&encoding7BitUintMask == encoding7BitUint:
+		return nil, int64(b & 0x7f), true
+	case b&encoding6BitStrMask == encoding6BitStr:
+		l := int(b & 0x3f)
+		return lp.buf[p+1 : p+1+l : p+1+l], 0, true
+	case b&encoding13BitIntMask == encoding13BitInt:
+		uval = uint64(b&0x1f)<<8 | uint64(lp.buf[p+1])
+		negstart = 1 << 12
+		negmax = 8191
+	case b == encoding16BitInt:
+		uval = uint64(binary.LittleEndian.Uint16(lp.buf[p+1:]))
+		negstart = 1 << 15
+		negmax = math.MaxUint16
+	case b == encoding24BitInt:
+		uval = uint64(lp.buf[p+1]) | uint64(lp.buf[p+2])<<8 | uint64(lp.buf[p+3])<<16
+		negstart = 1 << 23
+		negmax = math.MaxUint32 >> 8
+	case b == encoding32BitInt:
+		uval = uint64(binary.LittleEndian.Uint32(lp.buf[p+1:]))
+		negstart = 1 << 31
+		negmax = math.MaxUint32
+	case b == encoding64BitInt:
+		uval = binary.LittleEndian.Uint64(lp.buf[p+1:])
+		negstart = 1 << 63
+		negmax = math.MaxUint64
+	case b&encoding12BitStrMask == encoding12BitStr:
+		l := int(b&0x0f)<<8 | int(lp.buf[p+1])
+		return lp.buf[p+2 : p+2+l : p+2+l], 0, true
+	case b == encoding32BitStr:
+		l := int(binary.LittleEndian.Uint32(lp.buf[p+1:]))
+		return lp.buf[p+5 : p+5+l : p+5+l], 0, true
+	default:
+		// Invalid encoding, the listpack is validated so this can't
+		// happen.
+		return nil, 0, false
+	}
+
+	// We reach this code path only for integer encodings.
+	// Convert the unsigned value to the signed one using two's complement
+	// rule.
+	if uval >= negstart {
+		// This three steps conversion should avoid undefined behaviors
+		// in the unsigned -> signed conversion.
+		uval = negmax - uval
+		return nil, -int64(uval) - 1, true
+	}
+	return nil, int64(uval), true
+}
+
+// insert Insert the encoded element 'ele' before, after, or in place of
+// the element at 'p'. A nil 'ele' with Replace deletes the element.
+// Returns the offset of the inserted element, or for a deletion the
+// offset of the element following the deleted one (-1 if it was the last).
+func (lp *Listpack) insert(ele []byte, p int, where int) int {
+	// An element pointed by 'p' must be a valid element, or the EOF to
+	// append at the end.
+	if where == After {
+		// We want to just insert after the current element, so
+		// transform the insert-after into an insert-before of the next.
+		p = lp.skip(p)
+		where = Before
+	}
+
+	var backlen [maxBacklenSize]byte
+	backlenSize := 0
+	if ele != nil {
+		backlenSize = encodeBacklen(backlen[:], uint64(len(ele)))
+	}
+
+	// We need to also encode the backward-parsable length of the element
+	// and append it to the end: this allows to traverse the listpack from
+	// the end to the start.
+	replacedLen := 0
+	if where == Replace {
+		replacedLen = lp.skip(p) - p
+	}
+
+	buf := make([]byte, 0, len(lp.buf)+len(ele)+backlenSize-replacedLen)
+	buf = append(buf, lp.buf[:p]...)
+	buf = append(buf, ele...)
+	buf = append(buf, backlen[:backlenSize]...)
+	buf = append(buf, lp.buf[p+replacedLen:]...)
+	lp.buf = buf
+	lp.setTotalBytes(len(buf))
+
+	// Update the number of elements, unless it is too big to be stored
+	// in the header.
+	if n := lp.numElements(); n != hdrNumeleUnknown {
+		if ele == nil {
+			lp.setNumElements(n - 1)
+		} else if where != Replace {
+			lp.setNumElements(n + 1)
+		}
+	}
+
+	if ele == nil && lp.buf[p] == eof {
+		return -1
+	}
+	return p
+}
+
+// encode Encode 's' as an integer if possible, as a string otherwise.
+func encode(s []byte) []byte {
+	if v, ok := stringToInt64(s); ok {
+		return encodeInteger(v)
+	}
+	ele := make([]byte, encodeString(s, nil))
+	encodeString(s, ele)
+	return ele
+}
+
+func encodeInteger(v int64) []byte {
+	ele := make([]byte, encodeIntegerGetType(v, nil))
+	encodeIntegerGetType(v, ele)
+	return ele
+}
+
+// EstimateBytesRepeatedInteger Estimate the number of bytes needed to
+// store the integer 'v' 'rep' times, including the backlen of the
+// elements.
+func EstimateBytesRepeatedInteger(v int64, rep int) int {
+	l := encodeIntegerGetType(v, nil)
+	l += encodeBacklen(nil, uint64(l))
+	return l * rep
+}
+
+// Insert the string 's' before, after or in place of the element at 'p',
+// see Before, After and Replace. Strings representing an integer are
+// stored with an integer encoding. Returns the offset of the inserted
+// element.
+func (lp *Listpack) Insert(s []byte, p int, where int) int {
+	return lp.insert(encode(s), p, where)
+}
+
+// InsertInteger Like Insert but for an integer value.
+func (lp *Listpack) InsertInteger(v int64, p int, where int) int {
+	return lp.insert(encodeInteger(v), p, where)
+}
+
+// Append the specified element 's' at the end of the listpack.
+func (lp *Listpack) Append(s []byte) {
+	lp.Insert(s, lp.eofOffset(), Before)
+}
+
+// AppendInteger Append the integer 'v' at the end of the listpack.
+func (lp *Listpack) AppendInteger(v int64) {
+	lp.InsertInteger(v, lp.eofOffset(), Before)
+}
+
+// Prepend the specified element 's' at the head of the listpack.
+func (lp *Listpack) Prepend(s []byte) {
+	lp.Insert(s, HdrSize, Before)
+}
+
+// Replace the element at 'p' with 's'. Returns the offset of the new
+// element.
+func (lp *Listpack) Replace(p int, s []byte) int {
+	return lp.Insert(s, p, Replace)
+}
+
+// ReplaceInteger Replace the element at 'p' with the integer 'v'.
+func (lp *Listpack) ReplaceInteger(p int, v int64) int {
+	return lp.InsertInteger(v, p, Replace)
+}
+
+// Delete the element pointed by 'p'. Returns the offset of the element
+// following it, or -1 when the deleted element was the last one.
+func (lp *Listpack) Delete(p int) int {
+	return lp.insert(nil, p, Replace)
+}
+
+// DeleteRange Delete a range of 'num' entries starting at 'index'.
+// Returns the number of deleted entries.
+func (lp *Listpack) DeleteRange(index int, num int) int {
+	p := lp.Seek(index)
+	if p == -1 || num <= 0 {
+		return 0
+	}
+
+	q := p
+	deleted := 0
+	for ; deleted < num && lp.buf[q] != eof; deleted++ {
+		q = lp.skip(q)
+	}
+
+	buf := make([]byte, 0, len(lp.buf)-(q-p))
+	buf = append(buf, lp.buf[:p]...)
+	buf = append(buf, lp.buf[q:]...)
+	lp.buf = buf
+	lp.setTotalBytes(len(buf))
+	if n := lp.numElements(); n != hdrNumeleUnknown {
+		lp.setNumElements(n - deleted)
+	}
+	return deleted
+}
+
+// Seek Return the offset of the element at the specified index. Negative
+// indexes are counted from the tail, -1 being the last element. Returns -1
+// if the index is out of range.
+func (lp *Listpack) Seek(index int) int {
+	forward := true // Seek forward by default.
+
+	// We want to seek from left to right or the other way around
+	// depending on the listpack length and the element position.
+	// However if the listpack length cannot be obtained in constant time,
+	// we always seek from left to right.
+	if numele := lp.numElements(); numele != hdrNumeleUnknown {
+		if index < 0 {
+			index = numele + index
+		}
+		if index < 0 || index >= numele {
+			return -1 // Out of range the other side.
+		}
+		// We want to scan right-to-left if the element we are looking
+		// for is past the half of the listpack.
+		if index > numele/2 {
+			forward = false
+			// Right to left scanning always expects a negative index.
+			// Convert our index to negative form.
+			index -= numele
+		}
+	} else {
+		// If the listpack length is unspecified, for negative indexes we
+		// want to always scan right-to-left.
+		if index < 0 {
+			forward = false
+		}
+	}
+
+	// Forward and backward scanning is trivially based on Next/Prev.
+	if forward {
+		p := lp.First()
+		for index > 0 && p != -1 {
+			p = lp.Next(p)
+			index--
+		}
+		return p
+	}
+
+	p := lp.Last()
+	for index < -1 && p != -1 {
+		p = lp.Prev(p)
+		index++
+	}
+	return p
+}
+
+// Compare the element at 'p' with 's'. Return true if equal.
+func (lp *Listpack) Compare(p int, s []byte) bool {
+	sval, lval, ok := lp.Get(p)
+	if !ok {
+		return false
+	}
+	if sval != nil {
+		return string(sval) == string(s)
+	}
+	v, ok := stringToInt64(s)
+	return ok && v == lval
+}
+
+// Find pointer to the element equal to the specified one, starting at
+// 'p'. Skip 'skip' entries between every comparison. Returns -1 when the
+// element could not be found.
+func (lp *Listpack) Find(p int, s []byte, skip int) int {
+	var (
+		skipcnt  int
+		vencoded bool
+		vtried   bool
+		vll      int64
+	)
+
+	for ; p != -1; p = lp.Next(p) {
+		if skipcnt > 0 {
+			skipcnt--
+			continue
+		}
+
+		sval, lval, _ := lp.Get(p)
+		if sval != nil {
+			if string(sval) == string(s) {
+				return p
+			}
+		} else {
+			// Find out if the searched field can be encoded. Note that
+			// we do it only the first time, once done vencoded is set.
+			if !vtried {
+				vll, vencoded = stringToInt64(s)
+				vtried = true
+			}
+			if vencoded && lval == vll {
+				return p
+			}
+		}
+
+		// Reset skip count
+		skipcnt = skip
+	}
+	return -1
+}
+
+// validateNext Validate the element at 'p', checking that it does not
+// reach outside the listpack, and that its backlen is consistent.
+// Returns the offset of the next element.
+func validateNext(buf []byte, p int) (int, bool) {
+	end := len(buf) - 1 // offset of the EOF byte
+
+	// Before accessing p, make sure it's valid.
+	if p < HdrSize || p >= end {
+		return 0, false
+	}
+
+	// Check that we can read the encoded size, and that the whole
+	// entry plus its backlen fits.
+	entrylen, ok := currentEncodedSize(buf, p)
+	if !ok || entrylen <= 0 {
+		return 0, false
+	}
+	encodedBacklen := encodeBacklen(nil, uint64(entrylen))
+	if uint64(p)+uint64(entrylen)+uint64(encodedBacklen) > uint64(end) {
+		return 0, false
+	}
+
+	// Make sure the backlen describes the entry itself.
+	next := p + entrylen + encodedBacklen
+	prevlen, ok := decodeBacklen(buf, next-1, p+entrylen)
+	if !ok || prevlen != uint64(entrylen) {
+		return 0, false
+	}
+	return next, true
+}
+
+// Validate the integrity of the listpack blob. When 'deep' is false only
+// the header is validated, otherwise every entry is validated as well, so
+// that iterating it in both directions can't access memory outside the
+// blob. A non nil error is returned when the blob is corrupted or
+// truncated.
+func Validate(blob []byte, deep bool) error {
+	// Check that we can actually read the header. (and EOF)
+	if len(blob) < HdrSize+1 {
+		return ErrCorrupt
+	}
+
+	// Check that the encoded size in the header must match the
+	// allocated size.
+	if int(binary.LittleEndian.Uint32(blob)) != len(blob) {
+		return ErrCorrupt
+	}
+
+	// The last byte must be the terminator.
+	if blob[len(blob)-1] != eof {
+		return ErrCorrupt
+	}
+
+	if !deep {
+		return nil
+	}
+
+	// Validate the individual entries.
+	count := 0
+	numele := int(binary.LittleEndian.Uint16(blob[4:]))
+	p := HdrSize
+	for blob[p] != eof {
+		next, ok := validateNext(blob, p)
+		if !ok {
+			return ErrCorrupt
+		}
+		p = next
+		count++
+	}
+
+	// Make sure 'p' really does point to the end of the listpack.
+	if p != len(blob)-1 {
+		return ErrCorrupt
+	}
+
+	// Check that the count in the header is correct.
+	if numele != hdrNumeleUnknown && numele != count {
+		return ErrCorrupt
+	}
+	return nil
+}
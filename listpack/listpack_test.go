@@ -0,0 +1,206 @@
+package listpack
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// entryString Return the element at 'p' as a string.
+func entryString(lp *Listpack, p int) string {
+	sval, lval, _ := lp.Get(p)
+	if sval != nil {
+		return string(sval)
+	}
+	return strconv.FormatInt(lval, 10)
+}
+
+// checkList Check the elements of the listpack in both directions, and
+// that the blob is still valid.
+func checkList(t *testing.T, lp *Listpack, want []string) {
+	t.Helper()
+
+	if err := Validate(lp.Bytes(), true); err != nil {
+		t.Fatal(err)
+	}
+	if lp.Len() != len(want) {
+		t.Fatalf("len %d, want %d", lp.Len(), len(want))
+	}
+	i := 0
+	for p := lp.First(); p != -1; p = lp.Next(p) {
+		if s := entryString(lp, p); s != want[i] {
+			t.Fatalf("element %d is %q, want %q", i, s, want[i])
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("iterated %d elements, want %d", i, len(want))
+	}
+	for p := lp.Last(); p != -1; p = lp.Prev(p) {
+		i--
+		if s := entryString(lp, p); s != want[i] {
+			t.Fatalf("element %d is %q, want %q", i, s, want[i])
+		}
+	}
+	for i := range want {
+		if s := entryString(lp, lp.Seek(i)); s != want[i] {
+			t.Fatalf("seek %d is %q, want %q", i, s, want[i])
+		}
+		if s := entryString(lp, lp.Seek(i-len(want))); s != want[i] {
+			t.Fatalf("seek %d is %q, want %q", i-len(want), s, want[i])
+		}
+	}
+}
+
+var values = []string{"", "0", "127", "128", "-1", "-4096", "4095", "-4097",
+	"32767", "-32768", "40000", "8388607", "-8388609", "2147483647",
+	"-2147483649", "9223372036854775807", "-9223372036854775808",
+	"9223372036854775808", "007", "hello",
+	string(bytes.Repeat([]byte{'a'}, 63)),
+	string(bytes.Repeat([]byte{'b'}, 64)),
+	string(bytes.Repeat([]byte{'c'}, 4095)),
+	string(bytes.Repeat([]byte{'d'}, 4096)),
+	string(bytes.Repeat([]byte{'e'}, 20000)),
+}
+
+func TestListpackEncodings(t *testing.T) {
+	lp := Create()
+	checkList(t, lp, nil)
+	if lp.First() != -1 || lp.Last() != -1 || lp.Seek(0) != -1 {
+		t.FailNow()
+	}
+
+	for _, s := range values {
+		lp.Append([]byte(s))
+	}
+	checkList(t, lp, values)
+
+	// Integers are auto encoded.
+	if sval, lval, _ := lp.Get(lp.Seek(5)); sval != nil || lval != -4096 {
+		t.FailNow()
+	}
+	if sval, _, _ := lp.Get(lp.Seek(18)); string(sval) != "007" {
+		t.FailNow()
+	}
+}
+
+func TestEstimateBytesRepeatedInteger(t *testing.T) {
+	for _, v := range []int64{0, 127, -4096, 32767, 1 << 20, 1 << 31, -1 << 40} {
+		lp := Create()
+		for i := 0; i < 10; i++ {
+			lp.AppendInteger(v)
+		}
+		if got := EstimateBytesRepeatedInteger(v, 10); got != lp.BlobLen()-HdrSize-1 {
+			t.Fatalf("%d: %d, want %d", v, got, lp.BlobLen()-HdrSize-1)
+		}
+	}
+	var lp *Listpack
+	if !lp.SafeToAdd(1<<30) || lp.SafeToAdd(1<<30+1) {
+		t.Fatal("nil listpack")
+	}
+}
+
+func TestListpackInsertDelete(t *testing.T) {
+	lp := Create()
+	lp.Append([]byte("b"))
+	lp.AppendInteger(3)
+	lp.Prepend([]byte("a"))
+	checkList(t, lp, []string{"a", "b", "3"})
+
+	p := lp.Insert([]byte("x"), lp.Seek(1), After)
+	if entryString(lp, p) != "x" {
+		t.FailNow()
+	}
+	lp.Insert([]byte("y"), lp.Seek(0), Before)
+	checkList(t, lp, []string{"y", "a", "b", "x", "3"})
+
+	p = lp.Replace(lp.Seek(2), []byte(values[22]))
+	p = lp.ReplaceInteger(lp.Next(p), 1000)
+	checkList(t, lp, []string{"y", "a", values[22], "1000", "3"})
+
+	if lp.Find(lp.First(), []byte("1000"), 0) != p {
+		t.FailNow()
+	}
+	if lp.Find(lp.First(), []byte("1000"), 1) != -1 {
+		t.FailNow()
+	}
+	if !lp.Compare(p, []byte("1000")) || lp.Compare(p, []byte("100")) {
+		t.FailNow()
+	}
+
+	p = lp.Delete(lp.Seek(2))
+	if entryString(lp, p) != "1000" {
+		t.FailNow()
+	}
+	if lp.Delete(lp.Last()) != -1 {
+		t.FailNow()
+	}
+	checkList(t, lp, []string{"y", "a", "1000"})
+
+	if lp.DeleteRange(-2, 5) != 2 {
+		t.FailNow()
+	}
+	checkList(t, lp, []string{"y"})
+}
+
+func TestListpackValidate(t *testing.T) {
+	lp := Create()
+	for _, s := range values {
+		lp.Append([]byte(s))
+	}
+	blob := lp.Bytes()
+
+	// Truncated blobs are rejected by both validations.
+	for i := 0; i < len(blob); i++ {
+		if Validate(blob[:i], false) == nil || Validate(blob[:i], true) == nil {
+			t.Fatalf("truncated blob of %d bytes is valid", i)
+		}
+	}
+
+	// Fix the header of a truncated blob, only deep validation fails.
+	truncated := append([]byte{}, blob[:len(blob)/2]...)
+	truncated[len(truncated)-1] = eof
+	lp = &Listpack{buf: truncated}
+	lp.setTotalBytes(len(truncated))
+	if Validate(truncated, false) != nil || Validate(truncated, true) == nil {
+		t.FailNow()
+	}
+
+	// Wrong number of elements.
+	lp, _ = Load(append([]byte{}, blob...))
+	lp.setNumElements(3)
+	if _, err := Load(lp.Bytes()); err != ErrCorrupt {
+		t.FailNow()
+	}
+}
+
+func FuzzValidate(f *testing.F) {
+	lp := Create()
+	f.Add(append([]byte{}, lp.Bytes()...))
+	for _, s := range values[:20] {
+		lp.Append([]byte(s))
+	}
+	f.Add(append([]byte{}, lp.Bytes()...))
+
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		lp, err := Load(blob)
+		if err != nil {
+			return
+		}
+
+		// A validated listpack must be safe to iterate in both
+		// directions.
+		n := 0
+		for p := lp.First(); p != -1; p = lp.Next(p) {
+			lp.Get(p)
+			n++
+		}
+		for p := lp.Last(); p != -1; p = lp.Prev(p) {
+			lp.Get(p)
+			n--
+		}
+		if n != 0 || lp.Len() < 0 {
+			t.Fatalf("forward and backward iteration differ by %d", n)
+		}
+	})
+}
@@ -0,0 +1,3 @@
+module lzf
+
+go 1.14
@@ -0,0 +1,147 @@
+package lzf
+
+// LZF compression, compatible with the liblzf format used by redis.
+//
+// The compressed data is a sequence of chunks, each one starting with a
+// control byte:
+//
+// 000LLLLL <L+1 literal bytes>
+// LLLooooo oooooooo : back reference of L+2 bytes, offset o+1
+// 111ooooo LLLLLLLL oooooooo : back reference of L+9 bytes, offset o+1
+
+import (
+	"errors"
+)
+
+// Error
+var (
+	// ErrCorrupt the compressed data is malformed, or doesn't decompress
+	// to the expected length.
+	ErrCorrupt = errors.New("lzf: corrupt input")
+)
+
+const (
+	hlog  = 16
+	hsize = 1 << hlog
+
+	maxLit = 1 << 5
+	maxOff = 1 << 13
+	maxRef = (1 << 8) + (1 << 3)
+)
+
+// Compress the input. The output may be larger than the input when the
+// data is not compressible, callers are expected to check its length.
+func Compress(in []byte) []byte {
+	var (
+		htab     [hsize]int32
+		lit      int
+		litStart int
+	)
+
+	out := make([]byte, 0, len(in)+len(in)/maxLit+1)
+
+	literal := func(b byte) {
+		if lit == 0 {
+			litStart = len(out)
+			out = append(out, 0)
+		}
+		out = append(out, b)
+		lit++
+		out[litStart] = byte(lit - 1)
+		if lit == maxLit {
+			lit = 0
+		}
+	}
+
+	ip := 0
+	for ip+2 < len(in) {
+		v := uint32(in[ip])<<16 | uint32(in[ip+1])<<8 | uint32(in[ip+2])
+		h := (v * 2654435761) >> (32 - hlog)
+		ref := int(htab[h]) - 1
+		htab[h] = int32(ip + 1)
+
+		if ref >= 0 && ip-ref-1 < maxOff &&
+			in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+			// Match of at least 3 bytes, find out how long it is.
+			l := 3
+			maxl := len(in) - ip
+			if maxl > maxRef {
+				maxl = maxRef
+			}
+			for l < maxl && in[ref+l] == in[ip+l] {
+				l++
+			}
+
+			// Close the current literal run, if any.
+			lit = 0
+
+			off := ip - ref - 1
+			enc := l - 2
+			if enc < 7 {
+				out = append(out, byte(enc<<5|off>>8))
+			} else {
+				out = append(out, byte(7<<5|off>>8), byte(enc-7))
+			}
+			out = append(out, byte(off))
+			ip += l
+			continue
+		}
+
+		literal(in[ip])
+		ip++
+	}
+
+	for ; ip < len(in); ip++ {
+		literal(in[ip])
+	}
+	return out
+}
+
+// Decompress the input, that must decompress to exactly 'outLen' bytes.
+func Decompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < maxLit {
+			// Literal run.
+			ctrl++
+			if ip+ctrl > len(in) || len(out)+ctrl > outLen {
+				return nil, ErrCorrupt
+			}
+			out = append(out, in[ip:ip+ctrl]...)
+			ip += ctrl
+			continue
+		}
+
+		// Back reference.
+		l := ctrl >> 5
+		if l == 7 {
+			if ip >= len(in) {
+				return nil, ErrCorrupt
+			}
+			l += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, ErrCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+		l += 2
+		if ref < 0 || len(out)+l > outLen {
+			return nil, ErrCorrupt
+		}
+		// The reference may overlap with the output, copy byte by byte.
+		for i := 0; i < l; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
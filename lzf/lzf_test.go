@@ -0,0 +1,43 @@
+package lzf
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestLzf(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("abcabcabcabcabcabcabcabcabc"),
+		bytes.Repeat([]byte("x"), 10000),
+		bytes.Repeat([]byte("hello world "), 1000),
+		random,
+	}
+
+	for _, in := range tests {
+		c := Compress(in)
+		out, err := Decompress(c, len(in))
+		if err != nil || !bytes.Equal(in, out) {
+			t.Fatalf("roundtrip of %d bytes failed: %v", len(in), err)
+		}
+	}
+
+	in := bytes.Repeat([]byte("hello world "), 100)
+	c := Compress(in)
+	if len(c) >= len(in)/4 {
+		t.Fatalf("compressed %d bytes to %d", len(in), len(c))
+	}
+	if _, err := Decompress(c, len(in)-1); err != ErrCorrupt {
+		t.FailNow()
+	}
+	for i := 0; i < len(c); i++ {
+		if _, err := Decompress(c[:i], len(in)); err != ErrCorrupt {
+			t.Fatalf("truncated input of %d bytes decompressed", i)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+module object
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	dict v0.0.0
+	intset v0.0.0
+	listpack v0.0.0
+	quicklist v0.0.0
+	sds v0.0.0
+	stream v0.0.0
+	util v0.0.0
+	zset v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	dict => ../dict
+	intset => ../intset
+	listpack => ../listpack
+	lzf => ../lzf
+	quicklist => ../quicklist
+	rax => ../rax
+	sds => ../sds
+	skiplist => ../skiplist
+	stream => ../stream
+	util => ../util
+	zset => ../zset
+)
@@ -0,0 +1,203 @@
+package object
+
+// The hash type, a port of t_hash.c of redis.
+//
+// A small hash is a listpack of field and value pairs, it is converted to
+// a dict.Dict of *sds.Key fields and *sds.Value values when it has too
+// many fields or a field or a value is too long.
+
+import (
+	"dict"
+	"listpack"
+	"sds"
+)
+
+// CreateHash Create an empty hash object, listpack encoded.
+func CreateHash() *Object {
+	return createObject(TypeHash, EncodingListpack, listpack.Create())
+}
+
+// CreateHashSized Create an empty hash object that will hold 'sizeHint'
+// fields: listpack encoded if they fit, otherwise a hash table presized
+// to hold them.
+func CreateHashSized(sizeHint int, cfg *Config) *Object {
+	cfg = config(cfg)
+	if sizeHint <= cfg.HashMaxListpackEntries {
+		return CreateHash()
+	}
+	d := dict.Create()
+	d.Expand(uint64(sizeHint))
+	return createObject(TypeHash, EncodingHT, d)
+}
+
+// hashTryConversion Check the length of the fields and values to add to
+// see if the listpack needs to be converted to a hash table.
+func hashTryConversion(o *Object, args [][]byte, cfg *Config) {
+	lp, ok := o.ptr.(*listpack.Listpack)
+	if !ok {
+		return
+	}
+
+	// We guess that most of the values in the input are unique, so if
+	// there are enough arguments we create a pre-sized hash, which might
+	// over allocate memory if there are duplicates.
+	newFields := len(args) / 2
+	if newFields > cfg.HashMaxListpackEntries {
+		hashConvert(o, newFields)
+		return
+	}
+
+	sum := 0
+	for _, arg := range args {
+		if len(arg) > cfg.HashMaxListpackValue {
+			hashConvert(o, 0)
+			return
+		}
+		sum += len(arg)
+	}
+	if !lp.SafeToAdd(sum) {
+		hashConvert(o, 0)
+	}
+}
+
+// hashConvert Convert the listpack encoded hash to a hash table, sized to
+// hold at least 'size' fields.
+func hashConvert(o *Object, size int) {
+	lp := o.ptr.(*listpack.Listpack)
+	d := dict.Create()
+	if n := lp.Len() / 2; n > size {
+		size = n
+	}
+	d.Expand(uint64(size))
+
+	for p := lp.First(); p != -1; p = lp.Next(lp.Next(p)) {
+		field := lpBytes(lp, p)
+		value := lpBytes(lp, lp.Next(p))
+		if d.Add((*sds.Key)(sds.New(field)), (*sds.Value)(sds.New(value))) != nil {
+			panic("object: listpack corruption detected")
+		}
+	}
+	o.encoding = EncodingHT
+	o.ptr = d
+}
+
+// lpFindField Return the offset of the field in the listpack, -1 if not
+// found.
+func lpFindField(lp *listpack.Listpack, field []byte) int {
+	p := lp.First()
+	if p == -1 {
+		return -1
+	}
+	// Skip the values, only the fields are compared.
+	return lp.Find(p, field, 1)
+}
+
+// HashSet Set the value of the field, like HSET. Returns true if the
+// field already existed and its value was updated. The hash is converted
+// as needed.
+func HashSet(o *Object, field, value []byte, cfg *Config) (update bool) {
+	cfg = config(cfg)
+	hashTryConversion(o, [][]byte{field, value}, cfg)
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		if fptr := lpFindField(ptr, field); fptr != -1 {
+			// Replace value
+			ptr.Replace(ptr.Next(fptr), value)
+			update = true
+		} else {
+			// Push new field/value pair onto the tail of the listpack
+			ptr.Append(field)
+			ptr.Append(value)
+		}
+		// Check if the listpack needs to be converted to a hash table
+		if ptr.Len()/2 > cfg.HashMaxListpackEntries {
+			hashConvert(o, 0)
+		}
+		return update
+	case *dict.Dict:
+		return ptr.Replace((*sds.Key)(sds.New(field)), (*sds.Value)(sds.New(value))) == 0
+	}
+	panic("object: unknown hash encoding")
+}
+
+// HashGet Return the value of the field, like HGET. Returns false if the
+// field doesn't exist.
+func HashGet(o *Object, field []byte) ([]byte, bool) {
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		fptr := lpFindField(ptr, field)
+		if fptr == -1 {
+			return nil, false
+		}
+		return lpBytes(ptr, ptr.Next(fptr)), true
+	case *dict.Dict:
+		de := ptr.Find((*sds.Key)(sds.New(field)))
+		if de == nil {
+			return nil, false
+		}
+		return append([]byte{}, de.Value().(*sds.Value).SDS().Bytes()...), true
+	}
+	panic("object: unknown hash encoding")
+}
+
+// HashExists Return true if the field exists, like HEXISTS.
+func HashExists(o *Object, field []byte) bool {
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		return lpFindField(ptr, field) != -1
+	case *dict.Dict:
+		return ptr.Find((*sds.Key)(sds.New(field))) != nil
+	}
+	panic("object: unknown hash encoding")
+}
+
+// HashDelete Delete the field, like HDEL. Returns false if the field
+// doesn't exist.
+func HashDelete(o *Object, field []byte) bool {
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		fptr := lpFindField(ptr, field)
+		if fptr == -1 {
+			return false
+		}
+		// Delete both of the key and the value.
+		ptr.Delete(ptr.Delete(fptr))
+		return true
+	case *dict.Dict:
+		return ptr.Delete((*sds.Key)(sds.New(field))) == dict.DictOK
+	}
+	panic("object: unknown hash encoding")
+}
+
+// HashLen Return the number of fields of the hash, like HLEN.
+func HashLen(o *Object) int {
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		return ptr.Len() / 2
+	case *dict.Dict:
+		return int(ptr.Size())
+	}
+	panic("object: unknown hash encoding")
+}
+
+// HashGetAll Return the fields and values of the hash, like HGETALL: a
+// field is followed by its value.
+func HashGetAll(o *Object) [][]byte {
+	result := make([][]byte, 0, 2*HashLen(o))
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		for p := ptr.First(); p != -1; p = ptr.Next(p) {
+			result = append(result, lpBytes(ptr, p))
+		}
+	case *dict.Dict:
+		it := ptr.GetIterator()
+		for de := it.Next(); de != nil; de = it.Next() {
+			result = append(result,
+				append([]byte{}, de.Key().(*sds.Key).SDS().Bytes()...),
+				append([]byte{}, de.Value().(*sds.Value).SDS().Bytes()...))
+		}
+		it.Release()
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package object
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"dict"
+)
+
+// checkHash Verify the hash against the reference map.
+func checkHash(t *testing.T, o *Object, want map[string]string) {
+	t.Helper()
+	if HashLen(o) != len(want) {
+		t.Fatalf("len %d, want %d", HashLen(o), len(want))
+	}
+	all := HashGetAll(o)
+	for i := 0; i < len(all); i += 2 {
+		if v, ok := want[string(all[i])]; !ok || v != string(all[i+1]) {
+			t.Fatalf("field %q value %q, want %q", all[i], all[i+1], v)
+		}
+	}
+	for f, v := range want {
+		if got, ok := HashGet(o, []byte(f)); !ok || string(got) != v || !HashExists(o, []byte(f)) {
+			t.Fatalf("get %q: %q", f, got)
+		}
+	}
+}
+
+func TestHashConversion(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.HashMaxListpackEntries = 3
+	cfg.HashMaxListpackValue = 8
+
+	o := CreateHash()
+	ref := map[string]string{"a": "1", "b": "foo", "1": "2"}
+	for _, f := range []string{"a", "b", "1"} {
+		if HashSet(o, []byte(f), []byte(ref[f]), &cfg) {
+			t.Fatalf("set %q", f)
+		}
+	}
+	if !HashSet(o, []byte("a"), []byte("bar"), &cfg) {
+		t.Fatal("update")
+	}
+	ref["a"] = "bar"
+	if o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkHash(t, o, ref)
+
+	if !HashDelete(o, []byte("b")) || HashDelete(o, []byte("b")) || HashExists(o, []byte("b")) {
+		t.Fatal("delete")
+	}
+	delete(ref, "b")
+	checkHash(t, o, ref)
+
+	// Too many fields.
+	HashSet(o, []byte("c"), []byte("3"), &cfg)
+	HashSet(o, []byte("d"), []byte("4"), &cfg)
+	ref["c"], ref["d"] = "3", "4"
+	if o.Encoding() != EncodingHT {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkHash(t, o, ref)
+	if !HashDelete(o, []byte("c")) || HashSet(o, []byte("e"), []byte("5"), &cfg) {
+		t.Fatal("hashtable")
+	}
+	delete(ref, "c")
+	ref["e"] = "5"
+	checkHash(t, o, ref)
+
+	// Value too long.
+	o = CreateHash()
+	HashSet(o, []byte("a"), bytes.Repeat([]byte{'x'}, 9), &cfg)
+	if o.Encoding() != EncodingHT {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkHash(t, o, map[string]string{"a": "xxxxxxxxx"})
+}
+
+func TestCreateHashSized(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.HashMaxListpackEntries = 3
+
+	if o := CreateHashSized(3, &cfg); o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	o := CreateHashSized(100, &cfg)
+	if o.Encoding() != EncodingHT || o.Ptr().(*dict.Dict).Slots() != 128 {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	for i := 0; i < 100; i++ {
+		HashSet(o, []byte(strconv.Itoa(i)), []byte("v"), &cfg)
+	}
+	if o.Ptr().(*dict.Dict).IsRehashing() {
+		t.Fatal("rehashing")
+	}
+}
+
+func TestHashRandom(t *testing.T) {
+	o := CreateHash()
+	ref := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		f := strconv.Itoa(rand.Intn(300))
+		switch rand.Intn(3) {
+		case 0:
+			_, exists := ref[f]
+			if HashDelete(o, []byte(f)) != exists {
+				t.Fatalf("delete %q", f)
+			}
+			delete(ref, f)
+		default:
+			v := strconv.Itoa(rand.Int())
+			_, exists := ref[f]
+			if HashSet(o, []byte(f), []byte(v), nil) != exists {
+				t.Fatalf("set %q", f)
+			}
+			ref[f] = v
+		}
+		if i == 100 {
+			if o.Encoding() != EncodingListpack {
+				t.Fatal(EncodingName(o.Encoding()))
+			}
+			checkHash(t, o, ref)
+		}
+	}
+	if o.Encoding() != EncodingHT {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkHash(t, o, ref)
+}
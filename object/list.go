@@ -0,0 +1,531 @@
+package object
+
+// The list type, a port of t_list.c of redis.
+//
+// A list is listpack encoded while it fits a quicklist node, then it is
+// converted to a quicklist. A quicklist of a single node is converted back
+// to a listpack when it shrinks to half the node limits, so that a list
+// of a size around the limit doesn't keep converting.
+//
+// A list can also be created as an adlist.List linked list of string
+// objects, the encoding of the early versions of redis, that is never
+// converted.
+
+import (
+	"strconv"
+
+	"adlist"
+	"listpack"
+	"quicklist"
+)
+
+const (
+	// ListHead the head of the list.
+	ListHead = 0
+	// ListTail the tail of the list.
+	ListTail = 1
+)
+
+// CreateList Create an empty list object, listpack encoded.
+func CreateList() *Object {
+	return createObject(TypeList, EncodingListpack, listpack.Create())
+}
+
+// CreateLinkedList Create an empty list object, encoded as an adlist.List
+// of string objects.
+func CreateLinkedList() *Object {
+	l := adlist.ListCreate(adlist.WithFree(func(v adlist.Value) {
+		v.(*Object).DecrRefCount()
+	}))
+	return createObject(TypeList, EncodingLinkedlist, l)
+}
+
+// nodeBytes Return the value of a linked list node as a new slice.
+func nodeBytes(ln *adlist.Node) []byte {
+	return append([]byte{}, StringBytes(ln.Value().(*Object))...)
+}
+
+// lpBytes Return the value at 'p' as a new slice.
+func lpBytes(lp *listpack.Listpack, p int) []byte {
+	sval, lval, _ := lp.Get(p)
+	if sval != nil {
+		return append([]byte{}, sval...)
+	}
+	return strconv.AppendInt(nil, lval, 10)
+}
+
+// entryBytes Return the value of a quicklist entry as a new slice.
+func entryBytes(entry *quicklist.Entry) []byte {
+	if entry.Str != nil {
+		return append([]byte{}, entry.Str...)
+	}
+	return strconv.AppendInt(nil, entry.Int, 10)
+}
+
+// listTryConvertListpack Convert a listpack encoded list to a quicklist
+// if adding 'values' would exceed the limits of a quicklist node.
+func listTryConvertListpack(o *Object, values [][]byte, cfg *Config) {
+	if o.encoding != EncodingListpack {
+		return
+	}
+	lp := o.ptr.(*listpack.Listpack)
+
+	addBytes := 0
+	for _, v := range values {
+		addBytes += len(v)
+	}
+	if !quicklist.NodeExceedsLimit(cfg.ListMaxListpackSize, lp.BlobLen()+addBytes, lp.Len()+len(values)) {
+		return
+	}
+
+	ql := quicklist.Create(cfg.ListMaxListpackSize, cfg.ListCompressDepth)
+	// Append listpack to quicklist if it's not empty.
+	if lp.Len() > 0 {
+		ql.AppendListpack(lp)
+	}
+	o.encoding = EncodingQuicklist
+	o.ptr = ql
+}
+
+// listTryConvertQuicklist Convert a quicklist encoded list of a single
+// node to a listpack. When 'shrinking' the limits are halved, to avoid
+// frequent conversions around the limits.
+func listTryConvertQuicklist(o *Object, shrinking bool, cfg *Config) {
+	if o.encoding != EncodingQuicklist {
+		return
+	}
+	ql := o.ptr.(*quicklist.Quicklist)
+
+	// A quicklist can be converted to listpack only if it has only one
+	// packed node.
+	if ql.Len() != 1 {
+		return
+	}
+
+	// Check the length and size of the quicklist is below the limit.
+	szLimit, countLimit := quicklist.NodeLimit(cfg.ListMaxListpackSize)
+	if shrinking {
+		szLimit /= 2
+		countLimit /= 2
+	}
+	if ql.Head().Size() > szLimit || ql.Count() > countLimit {
+		return
+	}
+
+	lp := listpack.Create()
+	iter := ql.GetIterator(quicklist.StartHead)
+	var entry quicklist.Entry
+	for iter.Next(&entry) {
+		if entry.Str != nil {
+			lp.Append(entry.Str)
+		} else {
+			lp.AppendInteger(entry.Int)
+		}
+	}
+	iter.Release()
+	ql.Release()
+	o.encoding = EncodingListpack
+	o.ptr = lp
+}
+
+// listTryConversion Check the encoding of the list after a change, an
+// empty list never converts as it is going to be deleted.
+func listTryConversion(o *Object, cfg *Config) {
+	if ListLen(o) == 0 {
+		return
+	}
+	listTryConvertQuicklist(o, true, cfg)
+}
+
+// ListPush Push the values to the head or the tail of the list, like
+// LPUSH and RPUSH. The list is converted to a quicklist as needed.
+func ListPush(o *Object, where int, values [][]byte, cfg *Config) {
+	cfg = config(cfg)
+	listTryConvertListpack(o, values, cfg)
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		for _, v := range values {
+			if where == ListHead {
+				ptr.Prepend(v)
+			} else {
+				ptr.Append(v)
+			}
+		}
+	case *quicklist.Quicklist:
+		for _, v := range values {
+			if where == ListHead {
+				ptr.PushHead(v)
+			} else {
+				ptr.PushTail(v)
+			}
+		}
+	case *adlist.List:
+		for _, v := range values {
+			if where == ListHead {
+				ptr.AddNodeHead(CreateString(v))
+			} else {
+				ptr.AddNodeTail(CreateString(v))
+			}
+		}
+	}
+}
+
+// ListPop Pop a value from the head or the tail of the list, like LPOP
+// and RPOP. Returns false if the list is empty.
+func ListPop(o *Object, where int, cfg *Config) ([]byte, bool) {
+	cfg = config(cfg)
+	var value []byte
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		p := ptr.First()
+		if where == ListTail {
+			p = ptr.Last()
+		}
+		if p == -1 {
+			return nil, false
+		}
+		value = lpBytes(ptr, p)
+		ptr.Delete(p)
+	case *quicklist.Quicklist:
+		qlWhere := quicklist.Head
+		if where == ListTail {
+			qlWhere = quicklist.Tail
+		}
+		sval, lval, ok := ptr.Pop(qlWhere)
+		if !ok {
+			return nil, false
+		}
+		value = sval
+		if sval == nil {
+			value = strconv.AppendInt(nil, lval, 10)
+		}
+	case *adlist.List:
+		ln := ptr.First()
+		if where == ListTail {
+			ln = ptr.Last()
+		}
+		if ln == nil {
+			return nil, false
+		}
+		value = nodeBytes(ln)
+		ptr.DelNode(ln)
+	}
+	listTryConversion(o, cfg)
+	return value, true
+}
+
+// ListLen Return the number of elements of the list.
+func ListLen(o *Object) int {
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		return ptr.Len()
+	case *quicklist.Quicklist:
+		return ptr.Count()
+	case *adlist.List:
+		return int(ptr.Len())
+	}
+	panic("object: unknown list encoding")
+}
+
+// ListIndex Return the element at 'index', like LINDEX. Negative indexes
+// are counted from the tail. Returns false if out of range.
+func ListIndex(o *Object, index int) ([]byte, bool) {
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		p := ptr.Seek(index)
+		if p == -1 {
+			return nil, false
+		}
+		return lpBytes(ptr, p), true
+	case *quicklist.Quicklist:
+		var entry quicklist.Entry
+		if !ptr.Index(index, &entry) {
+			return nil, false
+		}
+		return entryBytes(&entry), true
+	case *adlist.List:
+		ln := ptr.Index(int64(index))
+		if ln == nil {
+			return nil, false
+		}
+		return nodeBytes(ln), true
+	}
+	panic("object: unknown list encoding")
+}
+
+// ListSet Replace the element at 'index', like LSET. Returns false if the
+// index is out of range.
+func ListSet(o *Object, index int, value []byte, cfg *Config) bool {
+	cfg = config(cfg)
+	listTryConvertListpack(o, [][]byte{value}, cfg)
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		p := ptr.Seek(index)
+		if p == -1 {
+			return false
+		}
+		ptr.Replace(p, value)
+	case *quicklist.Quicklist:
+		if !ptr.ReplaceAtIndex(index, value) {
+			return false
+		}
+		// We might replace a big item with a small one or vice versa,
+		// but we've already handled the growing case above, so here we
+		// just need to try the conversion for shrinking.
+		listTryConvertQuicklist(o, true, cfg)
+	case *adlist.List:
+		ln := ptr.Index(int64(index))
+		if ln == nil {
+			return false
+		}
+		old := ln.Value().(*Object)
+		ln.SetValue(CreateString(value))
+		old.DecrRefCount()
+	}
+	return true
+}
+
+// ListRange Return the elements between 'start' and 'end', both
+// inclusive, like LRANGE. Negative indexes are counted from the tail.
+func ListRange(o *Object, start, end int) [][]byte {
+	// Convert negative indexes.
+	llen := ListLen(o)
+	if start < 0 {
+		start = llen + start
+	}
+	if end < 0 {
+		end = llen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	// Invariant: start >= 0, so this test will be true when end < 0.
+	// The range is empty when start > end or start >= length.
+	if start > end || start >= llen {
+		return nil
+	}
+	if end >= llen {
+		end = llen - 1
+	}
+	rangelen := end - start + 1
+	result := make([][]byte, 0, rangelen)
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		p := ptr.Seek(start)
+		for ; rangelen > 0; rangelen-- {
+			result = append(result, lpBytes(ptr, p))
+			p = ptr.Next(p)
+		}
+	case *quicklist.Quicklist:
+		iter := ptr.GetIteratorAtIdx(quicklist.StartHead, start)
+		var entry quicklist.Entry
+		for ; rangelen > 0 && iter.Next(&entry); rangelen-- {
+			result = append(result, entryBytes(&entry))
+		}
+		iter.Release()
+	case *adlist.List:
+		// If we are nearest to the end of the list, reach the element
+		// starting from tail and going backward, as it is faster.
+		var ln *adlist.Node
+		if start > llen/2 {
+			ln = ptr.Index(int64(start - llen))
+		} else {
+			ln = ptr.Index(int64(start))
+		}
+		for ; rangelen > 0; rangelen-- {
+			result = append(result, nodeBytes(ln))
+			ln = ln.Next()
+		}
+	}
+	return result
+}
+
+// ListInsert Insert 'value' before or after the first element equal to
+// 'pivot', like LINSERT. Returns false if the pivot was not found.
+func ListInsert(o *Object, pivot, value []byte, after bool, cfg *Config) bool {
+	cfg = config(cfg)
+	listTryConvertListpack(o, [][]byte{value}, cfg)
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		for p := ptr.First(); p != -1; p = ptr.Next(p) {
+			if ptr.Compare(p, pivot) {
+				if after {
+					ptr.Insert(value, p, listpack.After)
+				} else {
+					ptr.Insert(value, p, listpack.Before)
+				}
+				return true
+			}
+		}
+	case *quicklist.Quicklist:
+		iter := ptr.GetIterator(quicklist.StartHead)
+		defer iter.Release()
+		var entry quicklist.Entry
+		for iter.Next(&entry) {
+			if entryEqual(&entry, pivot) {
+				if after {
+					ptr.InsertAfter(&entry, value)
+				} else {
+					ptr.InsertBefore(&entry, value)
+				}
+				return true
+			}
+		}
+	case *adlist.List:
+		for ln := ptr.First(); ln != nil; ln = ln.Next() {
+			if string(StringBytes(ln.Value().(*Object))) == string(pivot) {
+				if after {
+					ptr.InsertNode(ln, CreateString(value), 1)
+				} else {
+					ptr.InsertNode(ln, CreateString(value), 0)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// entryEqual Return true if the quicklist entry is equal to 's'.
+func entryEqual(entry *quicklist.Entry, s []byte) bool {
+	if entry.Str != nil {
+		return string(entry.Str) == string(s)
+	}
+	return string(strconv.AppendInt(nil, entry.Int, 10)) == string(s)
+}
+
+// ListRem Remove the first 'count' elements equal to 'value', like LREM:
+// from the head when count > 0, from the tail when count < 0, all of them
+// when count is 0. Returns the number of removed elements.
+func ListRem(o *Object, count int, value []byte, cfg *Config) int {
+	cfg = config(cfg)
+	removed := 0
+
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		if count < 0 {
+			count = -count
+			for p := ptr.Last(); p != -1; {
+				if ptr.Compare(p, value) {
+					// The offsets before the deleted element don't
+					// change.
+					prev := ptr.Prev(p)
+					ptr.Delete(p)
+					removed++
+					if removed == count {
+						break
+					}
+					p = prev
+				} else {
+					p = ptr.Prev(p)
+				}
+			}
+		} else {
+			for p := ptr.First(); p != -1; {
+				if ptr.Compare(p, value) {
+					p = ptr.Delete(p)
+					removed++
+					if removed == count {
+						break
+					}
+				} else {
+					p = ptr.Next(p)
+				}
+			}
+		}
+	case *quicklist.Quicklist:
+		direction := quicklist.StartHead
+		if count < 0 {
+			count = -count
+			direction = quicklist.StartTail
+		}
+		iter := ptr.GetIterator(direction)
+		var entry quicklist.Entry
+		for iter.Next(&entry) {
+			if entryEqual(&entry, value) {
+				iter.DelEntry(&entry)
+				removed++
+				if removed == count {
+					break
+				}
+			}
+		}
+		iter.Release()
+	case *adlist.List:
+		direction := adlist.ALStartHead
+		if count < 0 {
+			count = -count
+			direction = adlist.ALStartTail
+		}
+		iter := ptr.GetIterator(direction)
+		for ln := iter.Next(); ln != nil; ln = iter.Next() {
+			if string(StringBytes(ln.Value().(*Object))) == string(value) {
+				ptr.DelNode(ln)
+				removed++
+				if removed == count {
+					break
+				}
+			}
+		}
+	}
+
+	listTryConversion(o, cfg)
+	return removed
+}
+
+// ListTrim Trim the list to the elements between 'start' and 'end', both
+// inclusive, like LTRIM.
+func ListTrim(o *Object, start, end int, cfg *Config) {
+	cfg = config(cfg)
+	llen := ListLen(o)
+
+	// convert negative indexes
+	if start < 0 {
+		start = llen + start
+	}
+	if end < 0 {
+		end = llen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	// Invariant: start >= 0, so this test will be true when end < 0.
+	// The range is empty when start > end or start >= length.
+	var ltrim, rtrim int
+	if start > end || start >= llen {
+		// Out of range start or start > end result in empty list
+		ltrim = llen
+		rtrim = 0
+	} else {
+		if end >= llen {
+			end = llen - 1
+		}
+		ltrim = start
+		rtrim = llen - end - 1
+	}
+
+	// Remove list elements to perform the trim
+	switch ptr := o.ptr.(type) {
+	case *listpack.Listpack:
+		ptr.DeleteRange(0, ltrim)
+		ptr.DeleteRange(-rtrim, rtrim)
+	case *quicklist.Quicklist:
+		ptr.DelRange(0, ltrim)
+		ptr.DelRange(-rtrim, rtrim)
+	case *adlist.List:
+		for ; ltrim > 0; ltrim-- {
+			ptr.DelNode(ptr.First())
+		}
+		for ; rtrim > 0; rtrim-- {
+			ptr.DelNode(ptr.Last())
+		}
+	}
+	listTryConversion(o, cfg)
+}
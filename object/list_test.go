@@ -0,0 +1,222 @@
+package object
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"adlist"
+)
+
+// checkList Verify the list against the reference slice.
+func checkList(t *testing.T, o *Object, want []string) {
+	t.Helper()
+	if ListLen(o) != len(want) {
+		t.Fatalf("len %d, want %d", ListLen(o), len(want))
+	}
+	got := ListRange(o, 0, -1)
+	if fmt.Sprintf("%s", got) != fmt.Sprint(want) {
+		t.Fatalf("got %s\nwant %v", got, want)
+	}
+}
+
+func TestListConversion(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.ListMaxListpackSize = 4
+
+	o := CreateList()
+	ListPush(o, ListTail, [][]byte{[]byte("a"), []byte("1"), []byte("b")}, &cfg)
+	ListPush(o, ListHead, [][]byte{[]byte("0")}, &cfg)
+	if o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkList(t, o, []string{"0", "a", "1", "b"})
+
+	ListPush(o, ListTail, [][]byte{[]byte("c")}, &cfg)
+	if o.Encoding() != EncodingQuicklist {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkList(t, o, []string{"0", "a", "1", "b", "c"})
+
+	// Shrinks back once under half the node limits.
+	for _, want := range []string{"c", "b", "1"} {
+		if v, ok := ListPop(o, ListTail, &cfg); !ok || string(v) != want {
+			t.Fatalf("pop %q %v", v, ok)
+		}
+	}
+	if o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkList(t, o, []string{"0", "a"})
+
+	// A value too large for a node.
+	cfg.ListMaxListpackSize = -1
+	big := make([]byte, 5000)
+	ListSet(o, 0, big, &cfg)
+	if o.Encoding() != EncodingQuicklist {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	ListSet(o, 0, []byte("b"), &cfg)
+	if o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkList(t, o, []string{"b", "a"})
+}
+
+func TestListRandom(t *testing.T) {
+	for _, fill := range []int{-2, 3, 16, 0} {
+		cfg := DefaultConfig
+		cfg.ListMaxListpackSize = fill
+		o := CreateList()
+		if fill == 0 {
+			o = CreateLinkedList()
+		}
+		var ref []string
+
+		for i := 0; i < 3000; i++ {
+			v := strconv.Itoa(rand.Intn(20))
+			if rand.Intn(10) == 0 {
+				v += string(make([]byte, rand.Intn(300)))
+			}
+			switch rand.Intn(8) {
+			case 0:
+				ListPush(o, ListHead, [][]byte{[]byte(v)}, &cfg)
+				ref = append([]string{v}, ref...)
+			case 1:
+				ListPush(o, ListTail, [][]byte{[]byte(v), []byte(v)}, &cfg)
+				ref = append(ref, v, v)
+			case 2:
+				got, ok := ListPop(o, ListTail, &cfg)
+				if ok != (len(ref) > 0) || ok && string(got) != ref[len(ref)-1] {
+					t.Fatalf("pop %q %v", got, ok)
+				}
+				if ok {
+					ref = ref[:len(ref)-1]
+				}
+			case 3:
+				idx := rand.Intn(len(ref)+2) - 1
+				got, ok := ListIndex(o, idx)
+				if idx < 0 {
+					idx += len(ref)
+				}
+				inRange := idx >= 0 && idx < len(ref)
+				if ok != inRange || ok && string(got) != ref[idx] {
+					t.Fatalf("index %d: %q %v", idx, got, ok)
+				}
+				if ok && !ListSet(o, idx, []byte(v), &cfg) {
+					t.Fatal("set")
+				}
+				if ok {
+					ref[idx] = v
+				}
+			case 4:
+				after := rand.Intn(2) == 0
+				pivot := strconv.Itoa(rand.Intn(20))
+				pos := -1
+				for j, s := range ref {
+					if s == pivot {
+						pos = j
+						break
+					}
+				}
+				if ListInsert(o, []byte(pivot), []byte(v), after, &cfg) != (pos != -1) {
+					t.Fatalf("insert %q", pivot)
+				}
+				if pos != -1 {
+					if after {
+						pos++
+					}
+					ref = append(ref[:pos], append([]string{v}, ref[pos:]...)...)
+				}
+			case 5:
+				count := rand.Intn(5) - 2
+				target := strconv.Itoa(rand.Intn(20))
+				removed := listRemRef(&ref, count, target)
+				if got := ListRem(o, count, []byte(target), &cfg); got != removed {
+					t.Fatalf("rem %d %q: %d, want %d", count, target, got, removed)
+				}
+			case 6:
+				if rand.Intn(20) == 0 {
+					start, end := rand.Intn(10)-3, rand.Intn(len(ref)+10)-5
+					ListTrim(o, start, end, &cfg)
+					ref = listRangeRef(ref, start, end)
+				}
+			case 7:
+				start, end := rand.Intn(10)-5, rand.Intn(20)-10
+				got := ListRange(o, start, end)
+				if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", listRangeRef(ref, start, end)) {
+					t.Fatalf("range %d %d: %q", start, end, got)
+				}
+			}
+		}
+		checkList(t, o, ref)
+		if fill == 0 && o.Encoding() != EncodingLinkedlist {
+			t.Fatal(EncodingName(o.Encoding()))
+		}
+	}
+}
+
+func TestLinkedListRefCount(t *testing.T) {
+	o := CreateLinkedList()
+	ListPush(o, ListTail, [][]byte{[]byte("a"), []byte("b")}, nil)
+	l := o.Ptr().(*adlist.List)
+	a := l.First().Value().(*Object)
+	a.IncrRefCount()
+	ListSet(o, 0, []byte("c"), nil)
+	if a.RefCount() != 1 {
+		t.Fatalf("refcount %d", a.RefCount())
+	}
+	b := l.Last().Value().(*Object)
+	b.IncrRefCount()
+	o.DecrRefCount()
+	if b.RefCount() != 1 {
+		t.Fatalf("refcount %d", b.RefCount())
+	}
+}
+
+// listRemRef Reference implementation of ListRem.
+func listRemRef(ref *[]string, count int, target string) int {
+	removed := 0
+	s := *ref
+	if count >= 0 {
+		for j := 0; j < len(s); {
+			if s[j] == target && (count == 0 || removed < count) {
+				s = append(s[:j], s[j+1:]...)
+				removed++
+			} else {
+				j++
+			}
+		}
+	} else {
+		for j := len(s) - 1; j >= 0; j-- {
+			if s[j] == target && removed < -count {
+				s = append(s[:j], s[j+1:]...)
+				removed++
+			}
+		}
+	}
+	*ref = s
+	return removed
+}
+
+// listRangeRef Reference implementation of ListRange.
+func listRangeRef(ref []string, start, end int) []string {
+	n := len(ref)
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= n {
+		return []string{}
+	}
+	if end >= n {
+		end = n - 1
+	}
+	return append([]string{}, ref[start:end+1]...)
+}
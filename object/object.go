@@ -0,0 +1,314 @@
+package object
+
+// Redis object, a port of object.c of redis.
+//
+// An object wraps a value of one of the redis types, stored with one of
+// the encodings of the type: small values use a compact encoding (an
+// integer, a listpack or an intset) that is converted to the full encoding
+// (a quicklist, a dict.Dict or a skiplist) as soon as the value crosses
+// the thresholds of the Config.
+//
+// Objects are reference counted, so that the same object can be shared,
+// like the integers from 0 to SharedIntegers-1. As Object implements
+// dict.Value, storing an object in a dict.Dict increments its reference
+// count, removing it decrements the count.
+
+import (
+	"math"
+	"strings"
+
+	"adlist"
+	"dict"
+	"quicklist"
+	"stream"
+	"zset"
+)
+
+// Object types.
+const (
+	TypeString = 0
+	TypeList   = 1
+	TypeSet    = 2
+	TypeZset   = 3
+	TypeHash   = 4
+	TypeStream = 6
+)
+
+// Objects encoding. Some kind of objects like Strings and Hashes can be
+// internally represented in multiple ways. The 'encoding' field of the
+// object is set to one of these fields for this object.
+const (
+	// EncodingRaw Raw representation, a sds string.
+	EncodingRaw = 0
+	// EncodingInt Encoded as integer.
+	EncodingInt = 1
+	// EncodingHT Encoded as hash table, a dict.Dict.
+	EncodingHT = 2
+	// EncodingLinkedlist Encoded as an adlist.List of string objects.
+	EncodingLinkedlist = 4
+	// EncodingIntset Encoded as intset.
+	EncodingIntset = 6
+	// EncodingSkiplist Encoded as skiplist.
+	EncodingSkiplist = 7
+	// EncodingEmbstr Embedded sds string encoding.
+	EncodingEmbstr = 8
+	// EncodingQuicklist Encoded as linked list of listpacks.
+	EncodingQuicklist = 9
+	// EncodingStream Encoded as a radix tree of listpacks.
+	EncodingStream = 10
+	// EncodingListpack Encoded as a listpack.
+	EncodingListpack = 11
+)
+
+const (
+	// SharedIntegers The integers from 0 to SharedIntegers-1 are shared
+	// objects.
+	SharedIntegers = 10000
+	// SharedRefcount The refcount of the shared objects, never
+	// decremented.
+	SharedRefcount = math.MaxInt32
+)
+
+// Config The thresholds of the compact encodings, similar to the
+// *-max-listpack-* options of redis.
+type Config struct {
+	// ListMaxListpackSize The fill factor of the quicklists, as for
+	// quicklist.Create: the max number of entries of a node when
+	// positive, or the max size of a node from -1 (4kb) to -5 (64kb).
+	// The lists smaller than a node are listpack encoded.
+	ListMaxListpackSize int
+	// ListCompressDepth The compress depth of the quicklists.
+	ListCompressDepth int
+	// SetMaxIntsetEntries The max number of entries of an intset
+	// encoded set.
+	SetMaxIntsetEntries int
+	// SetMaxListpackEntries The max number of entries of a listpack
+	// encoded set.
+	SetMaxListpackEntries int
+	// SetMaxListpackValue The max length of a member of a listpack
+	// encoded set.
+	SetMaxListpackValue int
+	// HashMaxListpackEntries The max number of fields of a listpack
+	// encoded hash.
+	HashMaxListpackEntries int
+	// HashMaxListpackValue The max length of a field or a value of a
+	// listpack encoded hash.
+	HashMaxListpackValue int
+	// ZsetMaxListpackEntries The max number of members of a listpack
+	// encoded zset.
+	ZsetMaxListpackEntries int
+	// ZsetMaxListpackValue The max length of a member of a listpack
+	// encoded zset.
+	ZsetMaxListpackValue int
+}
+
+// DefaultConfig The default thresholds of redis.
+var DefaultConfig = Config{
+	ListMaxListpackSize:    -2,
+	ListCompressDepth:      0,
+	SetMaxIntsetEntries:    512,
+	SetMaxListpackEntries:  128,
+	SetMaxListpackValue:    64,
+	HashMaxListpackEntries: 128,
+	HashMaxListpackValue:   64,
+	ZsetMaxListpackEntries: zset.MaxListpackEntries,
+	ZsetMaxListpackValue:   zset.MaxListpackValue,
+}
+
+// config Return the config, DefaultConfig if nil.
+func config(cfg *Config) *Config {
+	if cfg == nil {
+		return &DefaultConfig
+	}
+	return cfg
+}
+
+// Object redis object.
+type Object struct {
+	typ      uint8
+	encoding uint8
+	refcount int32
+	ptr      interface{}
+}
+
+// Shared the shared integers.
+var shared [SharedIntegers]*Object
+
+func init() {
+	for i := range shared {
+		shared[i] = MakeShared(createObject(TypeString, EncodingInt, int64(i)))
+	}
+}
+
+// createObject Create a new object with refcount 1.
+func createObject(typ, encoding uint8, ptr interface{}) *Object {
+	return &Object{
+		typ:      typ,
+		encoding: encoding,
+		refcount: 1,
+		ptr:      ptr,
+	}
+}
+
+// MakeShared Set a special refcount in the object to make it "shared":
+// IncrRefCount and DecrRefCount will test for this special refcount and
+// will not touch the object. This way it is free to access shared objects
+// such as small integers from different goroutines without any
+// contention.
+func MakeShared(o *Object) *Object {
+	o.refcount = SharedRefcount
+	return o
+}
+
+// Type Return the type of the object.
+func (o *Object) Type() uint8 {
+	return o.typ
+}
+
+// Encoding Return the encoding of the object.
+func (o *Object) Encoding() uint8 {
+	// The zset converts itself.
+	if o.typ == TypeZset {
+		if o.ptr.(*zset.Zset).Encoding() == zset.EncodingListpack {
+			return EncodingListpack
+		}
+		return EncodingSkiplist
+	}
+	return o.encoding
+}
+
+// Ptr Return the value of the object, depending on the encoding: a
+// *sds.SDS, an int64, a *listpack.Listpack, a *quicklist.Quicklist, an
+// *adlist.List, an *intset.Intset, a *dict.Dict, a *zset.Zset or a
+// *stream.Stream.
+func (o *Object) Ptr() interface{} {
+	return o.ptr
+}
+
+// RefCount Return the reference count of the object.
+func (o *Object) RefCount() int32 {
+	return o.refcount
+}
+
+// IncrRefCount Increment the reference count of the object.
+func (o *Object) IncrRefCount() {
+	if o.refcount < SharedRefcount {
+		o.refcount++
+	}
+}
+
+// DecrRefCount Decrement the reference count of the object, the value is
+// released when the count reaches zero.
+func (o *Object) DecrRefCount() {
+	if o.refcount == SharedRefcount {
+		return
+	}
+	if o.refcount <= 0 {
+		panic("object: DecrRefCount against refcount <= 0")
+	}
+	o.refcount--
+	if o.refcount > 0 {
+		return
+	}
+
+	switch ptr := o.ptr.(type) {
+	case *adlist.List:
+		ptr.Release()
+	case *quicklist.Quicklist:
+		ptr.Release()
+	case *dict.Dict:
+		ptr.Close()
+	case *zset.Zset:
+		ptr.Release()
+	}
+	o.ptr = nil
+}
+
+// Dup implements dict.Value: the object is shared by incrementing its
+// reference count.
+func (o *Object) Dup() dict.Value {
+	o.IncrRefCount()
+	return o
+}
+
+// Destructor implements dict.Value.
+func (o *Object) Destructor() {
+	o.DecrRefCount()
+}
+
+// Value implements adlist.Value.
+func (o *Object) Value() {}
+
+// TypeName Return the name of the type, as reported by the TYPE command.
+func TypeName(typ uint8) string {
+	switch typ {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZset:
+		return "zset"
+	case TypeHash:
+		return "hash"
+	case TypeStream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// TypeByName Return the type with the name reported by the TYPE command,
+// false if unknown.
+func TypeByName(name string) (uint8, bool) {
+	for _, typ := range []uint8{TypeString, TypeList, TypeSet, TypeZset, TypeHash, TypeStream} {
+		if strings.EqualFold(TypeName(typ), name) {
+			return typ, true
+		}
+	}
+	return 0, false
+}
+
+// EncodingName Return the name of the encoding, as reported by the
+// OBJECT ENCODING command.
+func EncodingName(encoding uint8) string {
+	switch encoding {
+	case EncodingRaw:
+		return "raw"
+	case EncodingInt:
+		return "int"
+	case EncodingHT:
+		return "hashtable"
+	case EncodingLinkedlist:
+		return "linkedlist"
+	case EncodingIntset:
+		return "intset"
+	case EncodingSkiplist:
+		return "skiplist"
+	case EncodingEmbstr:
+		return "embstr"
+	case EncodingQuicklist:
+		return "quicklist"
+	case EncodingStream:
+		return "stream"
+	case EncodingListpack:
+		return "listpack"
+	}
+	return "unknown"
+}
+
+// CreateZset Create a zset object, the zset is converted from the
+// listpack to the skiplist encoding according to the config.
+func CreateZset(cfg *Config) *Object {
+	cfg = config(cfg)
+	zs := zset.Create(
+		zset.WithMaxListpackEntries(cfg.ZsetMaxListpackEntries),
+		zset.WithMaxListpackValue(cfg.ZsetMaxListpackValue),
+	)
+	return createObject(TypeZset, EncodingListpack, zs)
+}
+
+// CreateStream Create an empty stream object.
+func CreateStream(opts ...stream.Option) *Object {
+	return createObject(TypeStream, EncodingStream, stream.Create(opts...))
+}
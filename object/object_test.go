@@ -0,0 +1,148 @@
+package object
+
+import (
+	"bytes"
+	"testing"
+
+	"dict"
+	"sds"
+	"zset"
+)
+
+func TestStringEncoding(t *testing.T) {
+	tests := []struct {
+		s        string
+		encoding uint8
+	}{
+		{"foo", EncodingEmbstr},
+		{string(bytes.Repeat([]byte{'a'}, EmbstrSizeLimit)), EncodingEmbstr},
+		{string(bytes.Repeat([]byte{'a'}, EmbstrSizeLimit+1)), EncodingRaw},
+		{"12345", EncodingInt},
+		{"-1", EncodingInt},
+		{"9223372036854775807", EncodingInt},
+		{"9223372036854775808", EncodingEmbstr},
+		{"007", EncodingEmbstr},
+		{"", EncodingEmbstr},
+	}
+	for _, tt := range tests {
+		o := TryEncoding(CreateRawString([]byte(tt.s)))
+		if o.Encoding() != tt.encoding {
+			t.Fatalf("%q: encoding %s, want %s", tt.s, EncodingName(o.Encoding()), EncodingName(tt.encoding))
+		}
+		if string(StringBytes(o)) != tt.s || StringLen(o) != len(tt.s) {
+			t.Fatalf("%q: got %q", tt.s, StringBytes(o))
+		}
+		d := GetDecoded(o)
+		if d.Encoding() == EncodingInt || !EqualStrings(d, o) {
+			t.Fatalf("%q: decoded %q", tt.s, StringBytes(d))
+		}
+	}
+}
+
+func TestSharedIntegers(t *testing.T) {
+	a := TryEncoding(CreateString([]byte("100")))
+	b := CreateStringFromLongLong(100)
+	if a != b || a.RefCount() != SharedRefcount {
+		t.Fatal("not shared")
+	}
+	a.DecrRefCount()
+	a.IncrRefCount()
+	if a.RefCount() != SharedRefcount {
+		t.Fatal("refcount of a shared object changed")
+	}
+	if o := CreateStringFromLongLong(SharedIntegers); o.RefCount() != 1 || o.Encoding() != EncodingInt {
+		t.Fatal("out of range integer")
+	}
+
+	// Shared objects are not encoded, a copy is.
+	o := CreateString([]byte("foo"))
+	o.IncrRefCount()
+	if TryEncoding(o) != o {
+		t.Fatal("shared object encoded")
+	}
+	if c := DupString(b); c == b || c.RefCount() != 1 || !EqualStrings(b, c) {
+		t.Fatal("dup")
+	}
+}
+
+func TestRefCount(t *testing.T) {
+	o := CreateList()
+	ListPush(o, ListTail, [][]byte{[]byte("a")}, nil)
+
+	// The dict shares the object.
+	d := dict.Create()
+	d.Add((*sds.Key)(sds.NewString("key")), o)
+	if o.RefCount() != 2 || d.FetchValue((*sds.Key)(sds.NewString("key"))) != o {
+		t.Fatalf("refcount %d", o.RefCount())
+	}
+	d.Delete((*sds.Key)(sds.NewString("key")))
+	if o.RefCount() != 1 || ListLen(o) != 1 {
+		t.Fatalf("refcount %d", o.RefCount())
+	}
+	o.DecrRefCount()
+	if o.Ptr() != nil {
+		t.Fatal("not released")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	o.DecrRefCount()
+}
+
+func TestGetNumbers(t *testing.T) {
+	if v, err := GetLongLong(CreateStringFromLongLong(-5)); err != nil || v != -5 {
+		t.Fatal(v, err)
+	}
+	if v, err := GetLongLong(CreateString([]byte("123"))); err != nil || v != 123 {
+		t.Fatal(v, err)
+	}
+	for _, s := range []string{"", " 1", "1.5", "abc", "99999999999999999999"} {
+		if _, err := GetLongLong(CreateString([]byte(s))); err != ErrNotInteger {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+	if _, err := GetLongLong(CreateList()); err != ErrNotString {
+		t.Fatal(err)
+	}
+
+	if v, err := GetDouble(CreateString([]byte("1.5e3"))); err != nil || v != 1500 {
+		t.Fatal(v, err)
+	}
+	if v, err := GetDouble(CreateString([]byte("-inf"))); err != nil || v > 0 {
+		t.Fatal(v, err)
+	}
+	for _, s := range []string{"", " 1", "1 ", "nan", "abc", "1e400"} {
+		if _, err := GetDouble(CreateString([]byte(s))); err != ErrNotFloat {
+			t.Fatalf("%q: %v", s, err)
+		}
+	}
+}
+
+func TestZsetEncoding(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.ZsetMaxListpackEntries = 2
+	o := CreateZset(&cfg)
+	if o.Type() != TypeZset || o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	zs := o.Ptr().(*zset.Zset)
+	for _, m := range []string{"a", "b", "c"} {
+		zs.Add(1, []byte(m), 0)
+	}
+	if o.Encoding() != EncodingSkiplist {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+
+	if o := CreateStream(); TypeName(o.Type()) != "stream" || EncodingName(o.Encoding()) != "stream" {
+		t.Fatal("stream")
+	}
+	if typ, ok := TypeByName("ZSet"); !ok || typ != TypeZset {
+		t.Fatal("type by name")
+	}
+	if _, ok := TypeByName("none"); ok {
+		t.Fatal("type by name")
+	}
+}
@@ -0,0 +1,234 @@
+package object
+
+// The set type, a port of t_set.c of redis.
+//
+// A set of integers is intset encoded, a small set of short members is
+// listpack encoded, otherwise the set is a dict.Dict of *sds.Key without
+// values. The conversions only go from intset to listpack or dict, and
+// from listpack to dict.
+
+import (
+	"strconv"
+
+	"dict"
+	"intset"
+	"listpack"
+	"sds"
+	"util"
+)
+
+// CreateSet Create an empty set object that can hold 'value', used to
+// pick the encoding along with the expected number of members
+// 'sizeHint'.
+func CreateSet(value []byte, sizeHint int, cfg *Config) *Object {
+	cfg = config(cfg)
+	if _, ok := util.String2ll(value); ok && sizeHint <= setMaxIntsetEntries(cfg) {
+		return createObject(TypeSet, EncodingIntset, intset.Create())
+	}
+	if sizeHint <= cfg.SetMaxListpackEntries {
+		return createObject(TypeSet, EncodingListpack, listpack.Create())
+	}
+
+	// We may oversize the set by using the hint if the hint is not
+	// accurate, but we will assume this is acceptable to maximize
+	// performance.
+	d := dict.Create()
+	d.Expand(uint64(sizeHint))
+	return createObject(TypeSet, EncodingHT, d)
+}
+
+// setMaxIntsetEntries Return the max number of entries of an intset,
+// limited to 1G entries due to intset internals.
+func setMaxIntsetEntries(cfg *Config) int {
+	if cfg.SetMaxIntsetEntries >= 1<<30 {
+		return 1 << 30
+	}
+	return cfg.SetMaxIntsetEntries
+}
+
+// SetAdd Add the member to the set, like SADD. Returns false if the
+// member was already in the set. The set is converted as needed.
+func SetAdd(o *Object, value []byte, cfg *Config) bool {
+	cfg = config(cfg)
+
+	switch ptr := o.ptr.(type) {
+	case *dict.Dict:
+		// We don't use the value of the dict.
+		return ptr.Add((*sds.Key)(sds.New(value)), nil) == nil
+	case *listpack.Listpack:
+		if p := ptr.First(); p != -1 && ptr.Find(p, value, 0) != -1 {
+			return false
+		}
+		if ptr.Len() < cfg.SetMaxListpackEntries &&
+			len(value) <= cfg.SetMaxListpackValue &&
+			ptr.SafeToAdd(len(value)) {
+			ptr.Append(value)
+			return true
+		}
+		// Size limit is reached. Convert to hashtable and add.
+		setConvertAndExpand(o, EncodingHT, ptr.Len()+1)
+		return o.ptr.(*dict.Dict).Add((*sds.Key)(sds.New(value)), nil) == nil
+	case *intset.Intset:
+		if llval, ok := util.String2ll(value); ok {
+			if !ptr.Add(llval) {
+				return false
+			}
+			// Convert to regular set when the intset contains too many
+			// entries.
+			if ptr.Len() > setMaxIntsetEntries(cfg) {
+				setConvertAndExpand(o, EncodingHT, ptr.Len())
+			}
+			return true
+		}
+
+		// Check if listpack encoding is safe not to cross any threshold.
+		var maxelelen, totsize int
+		if n := ptr.Len(); n != 0 {
+			maxelelen = len(strconv.AppendInt(nil, ptr.Max(), 10))
+			if l := len(strconv.AppendInt(nil, ptr.Min(), 10)); l > maxelelen {
+				maxelelen = l
+			}
+			totsize = listpack.EstimateBytesRepeatedInteger(ptr.Max(), n)
+			if s := listpack.EstimateBytesRepeatedInteger(ptr.Min(), n); s > totsize {
+				totsize = s
+			}
+		}
+		if ptr.Len() < cfg.SetMaxListpackEntries &&
+			len(value) <= cfg.SetMaxListpackValue &&
+			maxelelen <= cfg.SetMaxListpackValue &&
+			(*listpack.Listpack)(nil).SafeToAdd(totsize+len(value)) {
+			// In the "safe to add" check above we assumed all elements
+			// in the intset are of size maxelelen. This is an upper
+			// bound.
+			setConvertAndExpand(o, EncodingListpack, ptr.Len()+1)
+			o.ptr.(*listpack.Listpack).Append(value)
+			return true
+		}
+		// The set *was* an intset and this value is not integer
+		// encodable, so adding to the dict always works.
+		setConvertAndExpand(o, EncodingHT, ptr.Len()+1)
+		return o.ptr.(*dict.Dict).Add((*sds.Key)(sds.New(value)), nil) == nil
+	}
+	panic("object: unknown set encoding")
+}
+
+// SetRemove Remove the member from the set, like SREM. Returns false if
+// the member was not in the set.
+func SetRemove(o *Object, value []byte) bool {
+	switch ptr := o.ptr.(type) {
+	case *dict.Dict:
+		return ptr.Delete((*sds.Key)(sds.New(value))) == dict.DictOK
+	case *listpack.Listpack:
+		p := ptr.First()
+		if p == -1 {
+			return false
+		}
+		if p = ptr.Find(p, value, 0); p == -1 {
+			return false
+		}
+		ptr.Delete(p)
+		return true
+	case *intset.Intset:
+		llval, ok := util.String2ll(value)
+		return ok && ptr.Remove(llval)
+	}
+	panic("object: unknown set encoding")
+}
+
+// SetIsMember Return true if the member is in the set, like SISMEMBER.
+func SetIsMember(o *Object, value []byte) bool {
+	switch ptr := o.ptr.(type) {
+	case *dict.Dict:
+		return ptr.Find((*sds.Key)(sds.New(value))) != nil
+	case *listpack.Listpack:
+		p := ptr.First()
+		return p != -1 && ptr.Find(p, value, 0) != -1
+	case *intset.Intset:
+		llval, ok := util.String2ll(value)
+		return ok && ptr.Find(llval)
+	}
+	panic("object: unknown set encoding")
+}
+
+// SetLen Return the number of members of the set, like SCARD.
+func SetLen(o *Object) int {
+	switch ptr := o.ptr.(type) {
+	case *dict.Dict:
+		return int(ptr.Size())
+	case *listpack.Listpack:
+		return ptr.Len()
+	case *intset.Intset:
+		return ptr.Len()
+	}
+	panic("object: unknown set encoding")
+}
+
+// SetMembers Return the members of the set, like SMEMBERS. The intset
+// members are sorted, the listpack ones in insertion order, the dict ones
+// in no particular order.
+func SetMembers(o *Object) [][]byte {
+	members := make([][]byte, 0, SetLen(o))
+	switch ptr := o.ptr.(type) {
+	case *dict.Dict:
+		it := ptr.GetIterator()
+		for de := it.Next(); de != nil; de = it.Next() {
+			members = append(members, append([]byte{}, de.Key().(*sds.Key).SDS().Bytes()...))
+		}
+		it.Release()
+	case *listpack.Listpack:
+		for p := ptr.First(); p != -1; p = ptr.Next(p) {
+			members = append(members, lpBytes(ptr, p))
+		}
+	case *intset.Intset:
+		for i := 0; i < ptr.Len(); i++ {
+			v, _ := ptr.Get(i)
+			members = append(members, strconv.AppendInt(nil, v, 10))
+		}
+	}
+	return members
+}
+
+// SetConvert Convert the set to the specified encoding, EncodingListpack
+// or EncodingHT. The resulting dict (when converting to a hash table) is
+// presized to hold the number of elements in the original set.
+func SetConvert(o *Object, encoding uint8) {
+	setConvertAndExpand(o, encoding, SetLen(o))
+}
+
+// setConvertAndExpand Convert the set to the specified encoding, sized
+// to hold 'size' members. Only the conversions from intset to listpack,
+// and from intset or listpack to hash table are supported.
+func setConvertAndExpand(o *Object, encoding uint8, size int) {
+	if o.encoding == encoding {
+		return
+	}
+
+	switch encoding {
+	case EncodingHT:
+		d := dict.Create()
+		// To add the elements we extract integers and create redis
+		// objects.
+		if d.Expand(uint64(size)) == dict.DictErr {
+			panic("object: set expand failed")
+		}
+		for _, member := range SetMembers(o) {
+			d.Add((*sds.Key)(sds.New(member)), nil)
+		}
+		o.encoding = EncodingHT
+		o.ptr = d
+	case EncodingListpack:
+		is, ok := o.ptr.(*intset.Intset)
+		if !ok {
+			panic("object: unsupported set conversion")
+		}
+		lp := listpack.Create()
+		for i := 0; i < is.Len(); i++ {
+			v, _ := is.Get(i)
+			lp.AppendInteger(v)
+		}
+		o.encoding = EncodingListpack
+		o.ptr = lp
+	default:
+		panic("object: unsupported set conversion")
+	}
+}
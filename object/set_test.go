@@ -0,0 +1,141 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkSet Verify the set against the reference map.
+func checkSet(t *testing.T, o *Object, want map[string]bool) {
+	t.Helper()
+	if SetLen(o) != len(want) {
+		t.Fatalf("len %d, want %d", SetLen(o), len(want))
+	}
+	var got, ref []string
+	for _, m := range SetMembers(o) {
+		got = append(got, string(m))
+	}
+	for m := range want {
+		ref = append(ref, m)
+	}
+	sort.Strings(got)
+	sort.Strings(ref)
+	if fmt.Sprint(got) != fmt.Sprint(ref) {
+		t.Fatalf("got %q\nwant %q", got, ref)
+	}
+}
+
+func TestSetConversion(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.SetMaxIntsetEntries = 4
+	cfg.SetMaxListpackEntries = 6
+	cfg.SetMaxListpackValue = 8
+	ref := make(map[string]bool)
+	add := func(o *Object, m string, encoding uint8) {
+		t.Helper()
+		if SetAdd(o, []byte(m), &cfg) == ref[m] {
+			t.Fatalf("add %q", m)
+		}
+		ref[m] = true
+		if o.Encoding() != encoding {
+			t.Fatalf("add %q: %s", m, EncodingName(o.Encoding()))
+		}
+		checkSet(t, o, ref)
+	}
+
+	// intset -> listpack -> hashtable
+	o := CreateSet([]byte("1"), 1, &cfg)
+	add(o, "1", EncodingIntset)
+	add(o, "-100", EncodingIntset)
+	if SetAdd(o, []byte("1"), &cfg) || !SetIsMember(o, []byte("-100")) || SetIsMember(o, []byte("a")) {
+		t.Fatal("intset")
+	}
+	add(o, "a", EncodingListpack)
+	add(o, "7", EncodingListpack)
+	if !SetRemove(o, []byte("7")) || SetRemove(o, []byte("7")) {
+		t.Fatal("remove")
+	}
+	delete(ref, "7")
+	add(o, "b", EncodingListpack)
+	add(o, "c", EncodingListpack)
+	add(o, "d", EncodingListpack)
+	add(o, "e", EncodingHT)
+	add(o, "f", EncodingHT)
+	if !SetIsMember(o, []byte("1")) || !SetRemove(o, []byte("a")) {
+		t.Fatal("hashtable")
+	}
+	delete(ref, "a")
+	checkSet(t, o, ref)
+
+	// intset -> hashtable with too many integers.
+	ref = make(map[string]bool)
+	o = CreateSet([]byte("1"), 0, &cfg)
+	for i := 0; i < 4; i++ {
+		add(o, strconv.Itoa(i), EncodingIntset)
+	}
+	add(o, "4", EncodingHT)
+
+	// intset -> hashtable with a long member.
+	ref = make(map[string]bool)
+	o = CreateSet([]byte("1"), 0, &cfg)
+	add(o, "1", EncodingIntset)
+	add(o, "abcdefghi", EncodingHT)
+
+	// intset -> hashtable with a long integer.
+	ref = make(map[string]bool)
+	o = CreateSet([]byte("1"), 0, &cfg)
+	add(o, "-123456789", EncodingIntset)
+	add(o, "a", EncodingHT)
+
+	if o := CreateSet([]byte("a"), 2, &cfg); o.Encoding() != EncodingListpack {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	if o := CreateSet([]byte("1"), 100, &cfg); o.Encoding() != EncodingHT {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+}
+
+func TestSetConvert(t *testing.T) {
+	o := CreateSet([]byte("1"), 0, nil)
+	for i := 0; i < 10; i++ {
+		SetAdd(o, []byte(strconv.Itoa(i)), nil)
+	}
+	SetConvert(o, EncodingListpack)
+	if o.Encoding() != EncodingListpack || !bytes.Equal(SetMembers(o)[3], []byte("3")) {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	SetConvert(o, EncodingHT)
+	if o.Encoding() != EncodingHT || SetLen(o) != 10 || !SetIsMember(o, []byte("9")) {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+}
+
+func TestSetRandom(t *testing.T) {
+	o := CreateSet([]byte("1"), 0, nil)
+	ref := make(map[string]bool)
+	for i := 0; i < 5000; i++ {
+		m := strconv.Itoa(rand.Intn(1000))
+		if i > 2500 && rand.Intn(10) == 0 {
+			m = "m" + m
+		}
+		if rand.Intn(3) == 0 {
+			if SetRemove(o, []byte(m)) != ref[m] {
+				t.Fatalf("remove %q", m)
+			}
+			delete(ref, m)
+		} else {
+			if SetAdd(o, []byte(m), nil) == ref[m] {
+				t.Fatalf("add %q", m)
+			}
+			ref[m] = true
+		}
+	}
+	if o.Encoding() != EncodingHT {
+		t.Fatal(EncodingName(o.Encoding()))
+	}
+	checkSet(t, o, ref)
+}
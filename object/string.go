@@ -0,0 +1,218 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+
+	"sds"
+	"util"
+)
+
+// Error
+var (
+	// ErrNotString the object is not a string.
+	ErrNotString = errors.New("object is not a string")
+	// ErrNotInteger the value is not an integer or out of range.
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	// ErrNotFloat the value is not a valid float.
+	ErrNotFloat = errors.New("value is not a valid float")
+)
+
+// EmbstrSizeLimit The strings up to this length are created with the
+// embstr encoding.
+const EmbstrSizeLimit = 44
+
+// CreateRawString Create a string object with the raw encoding, the
+// object owns a copy of s.
+func CreateRawString(s []byte) *Object {
+	return createObject(TypeString, EncodingRaw, sds.New(s))
+}
+
+// CreateEmbeddedString Create a string object with the embstr encoding,
+// that is an unmodifiable string. The object owns a copy of s.
+func CreateEmbeddedString(s []byte) *Object {
+	return createObject(TypeString, EncodingEmbstr, sds.New(s))
+}
+
+// CreateString Create a string object with the embstr encoding if the
+// string is small, otherwise the raw encoding.
+func CreateString(s []byte) *Object {
+	if len(s) <= EmbstrSizeLimit {
+		return CreateEmbeddedString(s)
+	}
+	return CreateRawString(s)
+}
+
+// CreateStringFromLongLong Create a string object from an integer, the
+// shared integers are returned when the value is in range.
+func CreateStringFromLongLong(value int64) *Object {
+	if value >= 0 && value < SharedIntegers {
+		return shared[value]
+	}
+	return createObject(TypeString, EncodingInt, value)
+}
+
+// CreateStringFromDouble Create a string object from a float, formatted
+// as INCRBYFLOAT does.
+func CreateStringFromDouble(value float64) *Object {
+	return CreateString([]byte(strconv.FormatFloat(value, 'f', -1, 64)))
+}
+
+// DupString Duplicate a string object, with the same encoding. The
+// returned object is never shared, so it can be modified.
+func DupString(o *Object) *Object {
+	switch o.encoding {
+	case EncodingRaw:
+		return CreateRawString(o.ptr.(*sds.SDS).Bytes())
+	case EncodingEmbstr:
+		return CreateEmbeddedString(o.ptr.(*sds.SDS).Bytes())
+	case EncodingInt:
+		return createObject(TypeString, EncodingInt, o.ptr)
+	}
+	panic("object: wrong encoding")
+}
+
+// TryEncoding Try to encode a string object in order to save space: the
+// strings representing an integer are int encoded, possibly using a
+// shared integer, the short strings are embstr encoded. The returned
+// object replaces o, that should not be used anymore.
+func TryEncoding(o *Object) *Object {
+	// Make sure this is a string object, the only type we encode in this
+	// function. Other types use encoded memory efficient representations
+	// but are handled by the commands implementing the type.
+	if o.typ != TypeString {
+		return o
+	}
+
+	// We try some specialized encoding only for objects that are RAW or
+	// EMBSTR encoded, in other words objects that are still in
+	// represented by an actually array of chars.
+	if o.encoding != EncodingRaw && o.encoding != EncodingEmbstr {
+		return o
+	}
+
+	// It's not safe to encode shared objects: shared objects can be
+	// shared everywhere in the "object space" of Redis and may end in
+	// places where they are not handled.
+	if o.refcount > 1 {
+		return o
+	}
+
+	// Check if we can represent this string as a long integer. Note that
+	// we are sure that a string larger than 20 chars is not representable
+	// as a 64 bit integer.
+	s := o.ptr.(*sds.SDS)
+	if value, ok := util.String2ll(s.Bytes()); ok {
+		if value >= 0 && value < SharedIntegers {
+			o.DecrRefCount()
+			return shared[value]
+		}
+		o.encoding = EncodingInt
+		o.ptr = value
+		return o
+	}
+
+	// If the string is small and is still RAW encoded, try the EMBSTR
+	// encoding which is more efficient.
+	if s.Len() <= EmbstrSizeLimit {
+		if o.encoding == EncodingEmbstr {
+			return o
+		}
+		emb := CreateEmbeddedString(s.Bytes())
+		o.DecrRefCount()
+		return emb
+	}
+
+	// We can't encode the object, try at least to remove the free space
+	// of the raw string.
+	if s.Avail() > s.Len()/10 {
+		s.RemoveFreeSpace()
+	}
+	return o
+}
+
+// GetDecoded Get a decoded version of an encoded object (returned as a
+// new object). If the object is already raw-encoded just increment the
+// ref count.
+func GetDecoded(o *Object) *Object {
+	if o.encoding == EncodingRaw || o.encoding == EncodingEmbstr {
+		o.IncrRefCount()
+		return o
+	}
+	if o.typ == TypeString && o.encoding == EncodingInt {
+		return CreateString(strconv.AppendInt(nil, o.ptr.(int64), 10))
+	}
+	panic("object: unknown encoding type")
+}
+
+// StringBytes Return the bytes of a string object. The returned slice
+// must not be modified.
+func StringBytes(o *Object) []byte {
+	if o.encoding == EncodingInt {
+		return strconv.AppendInt(nil, o.ptr.(int64), 10)
+	}
+	return o.ptr.(*sds.SDS).Bytes()
+}
+
+// StringLen Return the length of a string object.
+func StringLen(o *Object) int {
+	if o.encoding == EncodingInt {
+		return len(strconv.AppendInt(nil, o.ptr.(int64), 10))
+	}
+	return o.ptr.(*sds.SDS).Len()
+}
+
+// GetLongLong Return the integer value of a string object.
+func GetLongLong(o *Object) (int64, error) {
+	if o.typ != TypeString {
+		return 0, ErrNotString
+	}
+	if o.encoding == EncodingInt {
+		return o.ptr.(int64), nil
+	}
+	value, ok := util.String2ll(o.ptr.(*sds.SDS).Bytes())
+	if !ok {
+		return 0, ErrNotInteger
+	}
+	return value, nil
+}
+
+// GetDouble Return the float value of a string object. Spaces, NaN and
+// out of range values are not accepted.
+func GetDouble(o *Object) (float64, error) {
+	if o.typ != TypeString {
+		return 0, ErrNotString
+	}
+	if o.encoding == EncodingInt {
+		return float64(o.ptr.(int64)), nil
+	}
+	s := o.ptr.(*sds.SDS).Bytes()
+	if len(s) == 0 || s[0] == ' ' || s[0] == '\t' || s[len(s)-1] == ' ' || s[len(s)-1] == '\t' {
+		return 0, ErrNotFloat
+	}
+	value, err := strconv.ParseFloat(string(s), 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, ErrNotFloat
+	}
+	return value, nil
+}
+
+// CompareStrings Compare two string objects, binary safe, like
+// bytes.Compare.
+func CompareStrings(a, b *Object) int {
+	if a == b {
+		return 0
+	}
+	return bytes.Compare(StringBytes(a), StringBytes(b))
+}
+
+// EqualStrings Return true if the two string objects are equal, the int
+// encoded objects are compared without conversion.
+func EqualStrings(a, b *Object) bool {
+	if a.encoding == EncodingInt && b.encoding == EncodingInt {
+		return a.ptr.(int64) == b.ptr.(int64)
+	}
+	return CompareStrings(a, b) == 0
+}
@@ -0,0 +1,17 @@
+module pubsub
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	dict v0.0.0
+	sds v0.0.0
+	util v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	dict => ../dict
+	sds => ../sds
+	util => ../util
+)
@@ -0,0 +1,381 @@
+package pubsub
+
+// Publish/Subscribe, a port of pubsub.c of redis.
+//
+// The subscribers subscribe to channels, to glob-style patterns, and to
+// shard channels. Every channel maps to the list of its subscribers in a
+// dict.Dict, and every pattern as well. The shard channels are
+// partitioned by hash slot, like the shard channels of a cluster node:
+// every slot with subscribers has its own dict.Dict.
+//
+// The messages are delivered to the Receiver of the subscribers, while
+// publishing. A Receiver that can't keep up with the messages is a slow
+// subscriber, handled according to the slow policy of the PubSub.
+
+import (
+	"sync"
+
+	"adlist"
+	"dict"
+	"sds"
+	"util"
+)
+
+// Kinds of the messages, as in the push replies of redis.
+const (
+	// KindMessage A message published to a channel.
+	KindMessage = "message"
+	// KindPMessage A message published to a channel matching a pattern.
+	KindPMessage = "pmessage"
+	// KindSMessage A message published to a shard channel.
+	KindSMessage = "smessage"
+	// KindSUnsubscribe The subscriber was unsubscribed from a shard
+	// channel, see UnsubscribeShardSlot.
+	KindSUnsubscribe = "sunsubscribe"
+)
+
+// Policies of the slow subscribers, the subscribers whose Receiver
+// doesn't accept a message.
+const (
+	// SlowDrop The message is dropped, the subscriber stays subscribed.
+	SlowDrop = iota
+	// SlowDisconnect The subscriber is closed: it's unsubscribed from all
+	// the channels, patterns and shard channels, and its Receiver is
+	// closed.
+	SlowDisconnect
+)
+
+// Message a message delivered to a subscriber. The message is shared by
+// all the receivers and must not be modified.
+type Message struct {
+	Kind string
+	// The pattern matching the channel, for KindPMessage.
+	Pattern []byte
+	Channel []byte
+	Payload []byte
+}
+
+// Receiver the receiver of the messages of a subscriber. The methods are
+// called with the lock of the PubSub held: they must not block, nor call
+// the PubSub or its subscribers.
+type Receiver interface {
+	// Receive Deliver the message. Returns false if the subscriber is too
+	// slow to accept the message.
+	Receive(m *Message) bool
+	// Close Called once, when the subscriber is closed.
+	Close()
+}
+
+// ReceiverFunc a callback as Receiver, with nothing to close.
+type ReceiverFunc func(m *Message) bool
+
+// Receive implements Receiver.
+func (f ReceiverFunc) Receive(m *Message) bool {
+	return f(m)
+}
+
+// Close implements Receiver.
+func (f ReceiverFunc) Close() {}
+
+// chanReceiver a Go channel as Receiver: a subscriber is slow when the
+// channel is full.
+type chanReceiver chan *Message
+
+// Receive implements Receiver.
+func (ch chanReceiver) Receive(m *Message) bool {
+	select {
+	case ch <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close implements Receiver.
+func (ch chanReceiver) Close() {
+	close(ch)
+}
+
+// subscribers the list of the subscribers of a channel or a pattern, as
+// a dict.Value.
+type subscribers struct {
+	*adlist.List
+}
+
+// Dup implements dict.Value.
+func (l subscribers) Dup() dict.Value {
+	return l
+}
+
+// Destructor implements dict.Value.
+func (l subscribers) Destructor() {}
+
+// PubSub the channels, patterns and shard channels with their
+// subscribers. A PubSub is safe for concurrent use.
+type PubSub struct {
+	mu sync.Mutex
+	// Map channels to lists of subscribers
+	channels *dict.Dict
+	// Map patterns to lists of subscribers
+	patterns *dict.Dict
+	// Map shard channels to lists of subscribers, by slot. nil for the
+	// slots without shard channels.
+	shardChannels [ClusterSlots]*dict.Dict
+
+	slowPolicy int
+}
+
+// Option opt.
+type Option func(ps *PubSub)
+
+// WithSlowPolicy The policy of the slow subscribers, SlowDrop by default.
+func WithSlowPolicy(policy int) Option {
+	return func(ps *PubSub) {
+		ps.slowPolicy = policy
+	}
+}
+
+// Create a new PubSub.
+func Create(opts ...Option) *PubSub {
+	ps := &PubSub{
+		channels:   dict.Create(),
+		patterns:   dict.Create(),
+		slowPolicy: SlowDrop,
+	}
+
+	for _, o := range opts {
+		o(ps)
+	}
+	return ps
+}
+
+// keyOf Return the dict key of a channel or a pattern.
+func keyOf(channel []byte) *sds.Key {
+	return (*sds.Key)(sds.New(channel))
+}
+
+// pubsubType the channels, the patterns or the shard channels, their
+// subscriptions being handled alike.
+type pubsubType struct {
+	shard bool
+	// The channels of the subscriber.
+	subscriberChannels func(s *Subscriber) *dict.Dict
+	// The channels of the server, nil if they don't exist and 'create'
+	// is false.
+	serverChannels func(ps *PubSub, channel []byte, create bool) *dict.Dict
+	// The kind of the published messages.
+	messageKind string
+}
+
+var (
+	pubSubType = &pubsubType{
+		subscriberChannels: func(s *Subscriber) *dict.Dict {
+			return s.channels
+		},
+		serverChannels: func(ps *PubSub, channel []byte, create bool) *dict.Dict {
+			return ps.channels
+		},
+		messageKind: KindMessage,
+	}
+	pubSubPatternType = &pubsubType{
+		subscriberChannels: func(s *Subscriber) *dict.Dict {
+			return s.patterns
+		},
+		serverChannels: func(ps *PubSub, pattern []byte, create bool) *dict.Dict {
+			return ps.patterns
+		},
+		messageKind: KindPMessage,
+	}
+	pubSubShardType = &pubsubType{
+		shard: true,
+		subscriberChannels: func(s *Subscriber) *dict.Dict {
+			return s.shardChannels
+		},
+		serverChannels: func(ps *PubSub, channel []byte, create bool) *dict.Dict {
+			slot := KeyHashSlot(channel)
+			if ps.shardChannels[slot] == nil && create {
+				ps.shardChannels[slot] = dict.Create()
+			}
+			return ps.shardChannels[slot]
+		},
+		messageKind: KindSMessage,
+	}
+)
+
+// Publish a message to the subscribers of the channel, and of the
+// patterns matching the channel. Returns the number of subscribers that
+// received the message.
+func (ps *PubSub) Publish(channel, message []byte) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.publish(channel, message, pubSubType)
+}
+
+// SPublish Publish a message to the subscribers of the shard channel.
+// Returns the number of subscribers that received the message.
+func (ps *PubSub) SPublish(channel, message []byte) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.publish(channel, message, pubSubShardType)
+}
+
+// publish Publish a message to all the subscribers. The slow subscribers
+// are disconnected once the message is published, with SlowDisconnect.
+func (ps *PubSub) publish(channel, message []byte, typ *pubsubType) int {
+	// The message outlives the call in the receivers.
+	channel = append([]byte{}, channel...)
+	message = append([]byte{}, message...)
+
+	var (
+		receivers int
+		slow      []*Subscriber
+	)
+	deliver := func(clients subscribers, m *Message) {
+		iter := clients.Rewind()
+		for ln := iter.Next(); ln != nil; ln = iter.Next() {
+			s := ln.Value().(*Subscriber)
+			if s.slow {
+				continue
+			}
+			if s.r.Receive(m) {
+				receivers++
+			} else if ps.slowPolicy == SlowDisconnect {
+				s.slow = true
+				slow = append(slow, s)
+			}
+		}
+	}
+
+	// Send to clients listening for that channel
+	if d := typ.serverChannels(ps, channel, false); d != nil {
+		if de := d.Find(keyOf(channel)); de != nil {
+			deliver(de.Value().(subscribers), &Message{
+				Kind:    typ.messageKind,
+				Channel: channel,
+				Payload: message,
+			})
+		}
+	}
+
+	// Send to clients listening to matching channels
+	if !typ.shard {
+		it := ps.patterns.GetIterator()
+		for de := it.Next(); de != nil; de = it.Next() {
+			pattern := de.Key().(*sds.Key).SDS().Bytes()
+			if !util.StringMatchLen(pattern, channel, false) {
+				continue
+			}
+			deliver(de.Value().(subscribers), &Message{
+				Kind:    KindPMessage,
+				Pattern: append([]byte{}, pattern...),
+				Channel: channel,
+				Payload: message,
+			})
+		}
+		it.Release()
+	}
+
+	for _, s := range slow {
+		s.close()
+	}
+	return receivers
+}
+
+// channelList Return the channels of the dict matching the pattern, all
+// the channels if the pattern is nil.
+func channelList(d *dict.Dict, pattern []byte, channels [][]byte) [][]byte {
+	it := d.GetIterator()
+	for de := it.Next(); de != nil; de = it.Next() {
+		channel := de.Key().(*sds.Key).SDS().Bytes()
+		if pattern == nil || util.StringMatchLen(pattern, channel, false) {
+			channels = append(channels, append([]byte{}, channel...))
+		}
+	}
+	it.Release()
+	return channels
+}
+
+// Channels Return the active channels, the channels with at least one
+// subscriber, matching the pattern, or all of them if the pattern is nil,
+// like PUBSUB CHANNELS.
+func (ps *PubSub) Channels(pattern []byte) [][]byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return channelList(ps.channels, pattern, nil)
+}
+
+// ShardChannels Return the active shard channels matching the pattern,
+// or all of them if the pattern is nil, like PUBSUB SHARDCHANNELS.
+func (ps *PubSub) ShardChannels(pattern []byte) [][]byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var channels [][]byte
+	for _, d := range ps.shardChannels {
+		if d != nil {
+			channels = channelList(d, pattern, channels)
+		}
+	}
+	return channels
+}
+
+// numSub Return the number of subscribers of the channel.
+func (ps *PubSub) numSub(channel []byte, typ *pubsubType) int {
+	d := typ.serverChannels(ps, channel, false)
+	if d == nil {
+		return 0
+	}
+	de := d.Find(keyOf(channel))
+	if de == nil {
+		return 0
+	}
+	return int(de.Value().(subscribers).Len())
+}
+
+// NumSub Return the number of subscribers of the channel, like PUBSUB
+// NUMSUB. The pattern subscribers are not counted.
+func (ps *PubSub) NumSub(channel []byte) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.numSub(channel, pubSubType)
+}
+
+// ShardNumSub Return the number of subscribers of the shard channel,
+// like PUBSUB SHARDNUMSUB.
+func (ps *PubSub) ShardNumSub(channel []byte) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.numSub(channel, pubSubShardType)
+}
+
+// NumPat Return the number of unique patterns subscribed to, like PUBSUB
+// NUMPAT.
+func (ps *PubSub) NumPat() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return int(ps.patterns.Size())
+}
+
+// UnsubscribeShardSlot Unsubscribe all the subscribers from the shard
+// channels of the slot, like a cluster node losing the ownership of the
+// slot. A KindSUnsubscribe message is delivered for every unsubscribed
+// channel.
+func (ps *PubSub) UnsubscribeShardSlot(slot int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	d := ps.shardChannels[slot]
+	if d == nil {
+		return
+	}
+	for _, channel := range channelList(d, nil, nil) {
+		var unsubscribed []*Subscriber
+		iter := d.Find(keyOf(channel)).Value().(subscribers).Rewind()
+		for ln := iter.Next(); ln != nil; ln = iter.Next() {
+			unsubscribed = append(unsubscribed, ln.Value().(*Subscriber))
+		}
+		for _, s := range unsubscribed {
+			s.unsubscribeChannel(channel, pubSubShardType)
+			s.r.Receive(&Message{Kind: KindSUnsubscribe, Channel: channel})
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// received Return the messages received on the channel, rendered as
+// "kind pattern channel payload".
+func received(ch <-chan *Message) []string {
+	var msgs []string
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				return append(msgs, "closed")
+			}
+			msgs = append(msgs, fmt.Sprintf("%s %s %s %s", m.Kind, m.Pattern, m.Channel, m.Payload))
+		default:
+			return msgs
+		}
+	}
+}
+
+func checkReceived(t *testing.T, ch <-chan *Message, want ...string) {
+	t.Helper()
+	got := received(ch)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("received %q, want %q", got, want)
+	}
+}
+
+func sortedStrings(bs [][]byte) []string {
+	s := make([]string, len(bs))
+	for i, b := range bs {
+		s[i] = string(b)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestPublish(t *testing.T) {
+	ps := Create()
+	s1, ch1 := ps.CreateChanSubscriber(10)
+	s2, ch2 := ps.CreateChanSubscriber(10)
+
+	if !s1.Subscribe([]byte("foo")) || s1.Subscribe([]byte("foo")) {
+		t.Fatal("Subscribe")
+	}
+	s1.Subscribe([]byte("bar"))
+	s2.Subscribe([]byte("foo"))
+	if !s2.PSubscribe([]byte("f*")) || s2.PSubscribe([]byte("f*")) {
+		t.Fatal("PSubscribe")
+	}
+	s2.PSubscribe([]byte("b?r"))
+	if s1.Count() != 2 || s2.Count() != 3 {
+		t.Fatalf("Count = %d, %d", s1.Count(), s2.Count())
+	}
+
+	if n := ps.Publish([]byte("foo"), []byte("hello")); n != 3 {
+		t.Fatalf("Publish = %d", n)
+	}
+	if n := ps.Publish([]byte("bar"), []byte("world")); n != 2 {
+		t.Fatalf("Publish = %d", n)
+	}
+	if n := ps.Publish([]byte("nosuch"), []byte("x")); n != 0 {
+		t.Fatalf("Publish = %d", n)
+	}
+	checkReceived(t, ch1, "message  foo hello", "message  bar world")
+	checkReceived(t, ch2, "message  foo hello", "pmessage f* foo hello", "pmessage b?r bar world")
+
+	if got := sortedStrings(ps.Channels(nil)); fmt.Sprint(got) != "[bar foo]" {
+		t.Fatalf("Channels = %v", got)
+	}
+	if got := sortedStrings(ps.Channels([]byte("f*"))); fmt.Sprint(got) != "[foo]" {
+		t.Fatalf("Channels = %v", got)
+	}
+	if ps.NumSub([]byte("foo")) != 2 || ps.NumSub([]byte("bar")) != 1 || ps.NumSub([]byte("x")) != 0 {
+		t.Fatal("NumSub")
+	}
+	if ps.NumPat() != 2 {
+		t.Fatalf("NumPat = %d", ps.NumPat())
+	}
+
+	if !s1.Unsubscribe([]byte("foo")) || s1.Unsubscribe([]byte("foo")) {
+		t.Fatal("Unsubscribe")
+	}
+	if !s2.PUnsubscribe([]byte("f*")) || s2.PUnsubscribe([]byte("f*")) {
+		t.Fatal("PUnsubscribe")
+	}
+	if n := ps.Publish([]byte("foo"), []byte("again")); n != 1 {
+		t.Fatalf("Publish = %d", n)
+	}
+	checkReceived(t, ch1)
+	checkReceived(t, ch2, "message  foo again")
+
+	if got := sortedStrings(s2.UnsubscribeAll()); fmt.Sprint(got) != "[foo]" {
+		t.Fatalf("UnsubscribeAll = %v", got)
+	}
+	if got := sortedStrings(s2.PUnsubscribeAll()); fmt.Sprint(got) != "[b?r]" {
+		t.Fatalf("PUnsubscribeAll = %v", got)
+	}
+	if s2.Count() != 0 || ps.NumPat() != 0 || ps.NumSub([]byte("foo")) != 0 {
+		t.Fatal("not unsubscribed")
+	}
+
+	s1.Close()
+	s1.Close()
+	checkReceived(t, ch1, "closed")
+	if len(ps.Channels(nil)) != 0 {
+		t.Fatalf("Channels = %v", ps.Channels(nil))
+	}
+	if s1.Subscribe([]byte("foo")) {
+		t.Fatal("Subscribe after Close")
+	}
+}
+
+func TestReceiverFunc(t *testing.T) {
+	ps := Create()
+	var msgs []string
+	s := ps.CreateSubscriber(ReceiverFunc(func(m *Message) bool {
+		msgs = append(msgs, string(m.Payload))
+		return true
+	}))
+	s.Subscribe([]byte("ch"))
+	payload := []byte("a")
+	ps.Publish([]byte("ch"), payload)
+	// The published message is not shared with the publisher.
+	payload[0] = 'b'
+	ps.Publish([]byte("ch"), payload)
+	if fmt.Sprint(msgs) != "[a b]" {
+		t.Fatalf("received %v", msgs)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	ps := Create()
+	s, ch := ps.CreateChanSubscriber(1)
+	s.Subscribe([]byte("ch"))
+	if n := ps.Publish([]byte("ch"), []byte("1")); n != 1 {
+		t.Fatalf("Publish = %d", n)
+	}
+	if n := ps.Publish([]byte("ch"), []byte("2")); n != 0 {
+		t.Fatalf("Publish = %d", n)
+	}
+	// The message is dropped, the subscriber stays subscribed.
+	checkReceived(t, ch, "message  ch 1")
+	ps.Publish([]byte("ch"), []byte("3"))
+	checkReceived(t, ch, "message  ch 3")
+
+	ps = Create(WithSlowPolicy(SlowDisconnect))
+	s, ch = ps.CreateChanSubscriber(1)
+	s.Subscribe([]byte("ch"))
+	s.PSubscribe([]byte("*"))
+	s.SSubscribe([]byte("ch"))
+	other, och := ps.CreateChanSubscriber(10)
+	other.Subscribe([]byte("ch"))
+	if n := ps.Publish([]byte("ch"), []byte("1")); n != 2 {
+		t.Fatalf("Publish = %d", n)
+	}
+	// The subscriber is disconnected, the other subscribers keep
+	// receiving.
+	checkReceived(t, ch, "message  ch 1", "closed")
+	checkReceived(t, och, "message  ch 1")
+	if s.Count() != 0 || s.ShardCount() != 0 || ps.NumSub([]byte("ch")) != 1 || ps.NumPat() != 0 {
+		t.Fatal("slow subscriber not unsubscribed")
+	}
+	if n := ps.Publish([]byte("ch"), []byte("2")); n != 1 {
+		t.Fatalf("Publish = %d", n)
+	}
+}
+
+func TestShardChannels(t *testing.T) {
+	ps := Create()
+	s1, ch1 := ps.CreateChanSubscriber(10)
+	s2, ch2 := ps.CreateChanSubscriber(10)
+
+	if !s1.SSubscribe([]byte("{user1}a")) || s1.SSubscribe([]byte("{user1}a")) {
+		t.Fatal("SSubscribe")
+	}
+	s1.SSubscribe([]byte("{user1}b"))
+	s2.SSubscribe([]byte("{user1}a"))
+	s2.SSubscribe([]byte("foo"))
+	s2.PSubscribe([]byte("*"))
+	if s1.ShardCount() != 2 || s1.Count() != 0 {
+		t.Fatalf("ShardCount = %d, Count = %d", s1.ShardCount(), s1.Count())
+	}
+
+	// The shard channels are not matched by the patterns, nor are they
+	// channels.
+	if n := ps.SPublish([]byte("{user1}a"), []byte("hi")); n != 2 {
+		t.Fatalf("SPublish = %d", n)
+	}
+	if n := ps.Publish([]byte("{user1}a"), []byte("no")); n != 1 {
+		t.Fatalf("Publish = %d", n)
+	}
+	checkReceived(t, ch1, "smessage  {user1}a hi")
+	checkReceived(t, ch2, "smessage  {user1}a hi", "pmessage * {user1}a no")
+
+	if got := sortedStrings(ps.ShardChannels(nil)); fmt.Sprint(got) != "[foo {user1}a {user1}b]" {
+		t.Fatalf("ShardChannels = %v", got)
+	}
+	if got := sortedStrings(ps.ShardChannels([]byte("{*"))); fmt.Sprint(got) != "[{user1}a {user1}b]" {
+		t.Fatalf("ShardChannels = %v", got)
+	}
+	if len(ps.Channels(nil)) != 0 {
+		t.Fatalf("Channels = %v", ps.Channels(nil))
+	}
+	if ps.ShardNumSub([]byte("{user1}a")) != 2 || ps.ShardNumSub([]byte("foo")) != 1 {
+		t.Fatal("ShardNumSub")
+	}
+
+	// Losing the slot unsubscribes from all its channels.
+	ps.UnsubscribeShardSlot(KeyHashSlot([]byte("user1")))
+	got := received(ch1)
+	sort.Strings(got)
+	if fmt.Sprint(got) != "[sunsubscribe  {user1}a  sunsubscribe  {user1}b ]" {
+		t.Fatalf("received %q", got)
+	}
+	checkReceived(t, ch2, "sunsubscribe  {user1}a ")
+	if s1.ShardCount() != 0 || s2.ShardCount() != 1 {
+		t.Fatalf("ShardCount = %d, %d", s1.ShardCount(), s2.ShardCount())
+	}
+	if got := sortedStrings(ps.ShardChannels(nil)); fmt.Sprint(got) != "[foo]" {
+		t.Fatalf("ShardChannels = %v", got)
+	}
+
+	if !s2.SUnsubscribe([]byte("foo")) || s2.SUnsubscribe([]byte("foo")) {
+		t.Fatal("SUnsubscribe")
+	}
+	if n := ps.SPublish([]byte("foo"), []byte("x")); n != 0 {
+		t.Fatalf("SPublish = %d", n)
+	}
+	if ps.shardChannels[KeyHashSlot([]byte("foo"))] != nil {
+		t.Fatal("empty slot not freed")
+	}
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"", 0},
+		{"foo", 12182},
+		{"123456789", 12739},
+		{"{foo}bar", 12182},
+		{"bar{foo}", 12182},
+		{"{foo}{bar}", 12182},
+		{"{}foo", 9500},
+		{"foo{", 7673},
+		{"{}", 15257},
+	}
+	for _, test := range tests {
+		if slot := KeyHashSlot([]byte(test.key)); slot != test.slot {
+			t.Errorf("KeyHashSlot(%q) = %d, want %d", test.key, slot, test.slot)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package pubsub
+
+import (
+	"bytes"
+
+	"util"
+)
+
+// ClusterSlots the number of hash slots of a cluster.
+const ClusterSlots = 16384
+
+// KeyHashSlot Return the hash slot of the key, a port of keyHashSlot of
+// cluster.c of redis.
+//
+// We have 16384 hash slots. The hash slot of a given key is obtained as
+// the least significant 14 bits of the crc16 of the key.
+//
+// However if the key contains the {...} pattern, only the part between
+// { and } is hashed. This may be useful in the future to force certain
+// keys to be in the same node (assuming no resharding is in progress).
+func KeyHashSlot(key []byte) int {
+	s := bytes.IndexByte(key, '{')
+	// No '{' ? Hash the whole key. This is the base case.
+	if s == -1 {
+		return int(util.Crc16(key) & 0x3FFF)
+	}
+
+	// '{' found? Check if we have the corresponding '}'.
+	e := bytes.IndexByte(key[s+1:], '}')
+
+	// No '}' or nothing between {} ? Hash the whole key.
+	if e <= 0 {
+		return int(util.Crc16(key) & 0x3FFF)
+	}
+
+	// If we are here there is both a { and a } on its right. Hash what
+	// is in the middle between { and }.
+	return int(util.Crc16(key[s+1:s+1+e]) & 0x3FFF)
+}
@@ -0,0 +1,247 @@
+package pubsub
+
+import (
+	"adlist"
+	"dict"
+	"sds"
+)
+
+// subscription the node of a subscriber in the list of the subscribers of
+// a channel, as a dict.Value.
+type subscription struct {
+	node *adlist.Node
+}
+
+// Dup implements dict.Value.
+func (s *subscription) Dup() dict.Value {
+	return s
+}
+
+// Destructor implements dict.Value.
+func (s *subscription) Destructor() {}
+
+// Subscriber a subscriber of channels, patterns and shard channels,
+// receiving the messages published to them until it's closed.
+type Subscriber struct {
+	ps *PubSub
+	r  Receiver
+	// channels a client is interested in (SUBSCRIBE)
+	channels *dict.Dict
+	// patterns a client is interested in (PSUBSCRIBE)
+	patterns *dict.Dict
+	// shard level channels a client is interested in (SSUBSCRIBE)
+	shardChannels *dict.Dict
+
+	// The subscriber is being disconnected as slow.
+	slow   bool
+	closed bool
+}
+
+// Value implements adlist.Value.
+func (s *Subscriber) Value() {}
+
+// CreateSubscriber Create a subscriber delivering the messages to the
+// receiver.
+func (ps *PubSub) CreateSubscriber(r Receiver) *Subscriber {
+	return &Subscriber{
+		ps:            ps,
+		r:             r,
+		channels:      dict.Create(),
+		patterns:      dict.Create(),
+		shardChannels: dict.Create(),
+	}
+}
+
+// CreateChanSubscriber Create a subscriber delivering the messages to a
+// Go channel buffering up to 'size' messages. The subscriber is slow
+// when the Go channel is full. The Go channel is closed when the
+// subscriber is closed.
+func (ps *PubSub) CreateChanSubscriber(size int) (*Subscriber, <-chan *Message) {
+	ch := make(chanReceiver, size)
+	return ps.CreateSubscriber(ch), ch
+}
+
+// subscribeChannel Subscribe to a channel, a pattern or a shard channel.
+// Returns false if the subscriber was already subscribed to it.
+func (s *Subscriber) subscribeChannel(channel []byte, typ *pubsubType) bool {
+	if s.closed {
+		return false
+	}
+	k := keyOf(channel)
+	channels := typ.subscriberChannels(s)
+	if channels.Find(k) != nil {
+		return false
+	}
+
+	// Add the subscriber to the channel -> list of subscribers hash table
+	d := typ.serverChannels(s.ps, channel, true)
+	var clients subscribers
+	if de := d.Find(k); de != nil {
+		clients = de.Value().(subscribers)
+	} else {
+		clients = subscribers{adlist.ListCreate()}
+		d.Add(k, clients)
+	}
+	clients.AddNodeTail(s)
+	channels.Add(k, &subscription{node: clients.Last()})
+	return true
+}
+
+// unsubscribeChannel Unsubscribe from a channel, a pattern or a shard
+// channel. Returns false if the subscriber was not subscribed to it.
+func (s *Subscriber) unsubscribeChannel(channel []byte, typ *pubsubType) bool {
+	k := keyOf(channel)
+	channels := typ.subscriberChannels(s)
+	de := channels.Find(k)
+	if de == nil {
+		return false
+	}
+	node := de.Value().(*subscription).node
+	channels.Delete(k)
+
+	// Remove the subscriber from the channel -> subscribers list hash
+	// table
+	d := typ.serverChannels(s.ps, channel, false)
+	clients := d.Find(k).Value().(subscribers)
+	clients.DelNode(node)
+	if clients.Len() == 0 {
+		// Free the list and associated hash entry at all if this was
+		// the latest subscriber, so that it will be possible to abuse
+		// PUBSUB creating millions of channels.
+		d.Delete(k)
+		if typ.shard && d.Size() == 0 {
+			s.ps.shardChannels[KeyHashSlot(channel)] = nil
+		}
+	}
+	return true
+}
+
+// channelKeys Return the keys of the dict.
+func channelKeys(d *dict.Dict) [][]byte {
+	var keys [][]byte
+	it := d.GetIterator()
+	for de := it.Next(); de != nil; de = it.Next() {
+		keys = append(keys, append([]byte{}, de.Key().(*sds.Key).SDS().Bytes()...))
+	}
+	it.Release()
+	return keys
+}
+
+// unsubscribeAll Unsubscribe from all the channels, patterns or shard
+// channels. Returns the unsubscribed channels.
+func (s *Subscriber) unsubscribeAll(typ *pubsubType) [][]byte {
+	channels := channelKeys(typ.subscriberChannels(s))
+	for _, channel := range channels {
+		s.unsubscribeChannel(channel, typ)
+	}
+	return channels
+}
+
+// Subscribe to a channel. Returns false if the subscriber was already
+// subscribed to the channel, or is closed.
+func (s *Subscriber) Subscribe(channel []byte) bool {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.subscribeChannel(channel, pubSubType)
+}
+
+// Unsubscribe from a channel. Returns false if the subscriber was not
+// subscribed to the channel.
+func (s *Subscriber) Unsubscribe(channel []byte) bool {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.unsubscribeChannel(channel, pubSubType)
+}
+
+// UnsubscribeAll Unsubscribe from all the channels. Returns the
+// unsubscribed channels.
+func (s *Subscriber) UnsubscribeAll() [][]byte {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.unsubscribeAll(pubSubType)
+}
+
+// PSubscribe Subscribe to the channels matching the glob-style pattern.
+// Returns false if the subscriber was already subscribed to the pattern,
+// or is closed.
+func (s *Subscriber) PSubscribe(pattern []byte) bool {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.subscribeChannel(pattern, pubSubPatternType)
+}
+
+// PUnsubscribe Unsubscribe from a pattern. Returns false if the
+// subscriber was not subscribed to the pattern.
+func (s *Subscriber) PUnsubscribe(pattern []byte) bool {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.unsubscribeChannel(pattern, pubSubPatternType)
+}
+
+// PUnsubscribeAll Unsubscribe from all the patterns. Returns the
+// unsubscribed patterns.
+func (s *Subscriber) PUnsubscribeAll() [][]byte {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.unsubscribeAll(pubSubPatternType)
+}
+
+// SSubscribe Subscribe to a shard channel. Returns false if the
+// subscriber was already subscribed to the shard channel, or is closed.
+func (s *Subscriber) SSubscribe(channel []byte) bool {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.subscribeChannel(channel, pubSubShardType)
+}
+
+// SUnsubscribe Unsubscribe from a shard channel. Returns false if the
+// subscriber was not subscribed to the shard channel.
+func (s *Subscriber) SUnsubscribe(channel []byte) bool {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.unsubscribeChannel(channel, pubSubShardType)
+}
+
+// SUnsubscribeAll Unsubscribe from all the shard channels. Returns the
+// unsubscribed shard channels.
+func (s *Subscriber) SUnsubscribeAll() [][]byte {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return s.unsubscribeAll(pubSubShardType)
+}
+
+// Count Return the number of channels and patterns the subscriber is
+// subscribed to.
+func (s *Subscriber) Count() int {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return int(s.channels.Size() + s.patterns.Size())
+}
+
+// ShardCount Return the number of shard channels the subscriber is
+// subscribed to.
+func (s *Subscriber) ShardCount() int {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	return int(s.shardChannels.Size())
+}
+
+// close Unsubscribe from everything and close the receiver.
+func (s *Subscriber) close() {
+	if s.closed {
+		return
+	}
+	s.unsubscribeAll(pubSubType)
+	s.unsubscribeAll(pubSubPatternType)
+	s.unsubscribeAll(pubSubShardType)
+	s.closed = true
+	s.r.Close()
+}
+
+// Close the subscriber: it's unsubscribed from all the channels, patterns
+// and shard channels, and its receiver is closed.
+func (s *Subscriber) Close() {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	s.close()
+}
@@ -0,0 +1,14 @@
+module quicklist
+
+go 1.14
+
+require (
+	listpack v0.0.0
+	lzf v0.0.0
+)
+
+replace (
+	listpack => ../listpack
+	lzf => ../lzf
+	util => ../util
+)
@@ -0,0 +1,1226 @@
+package quicklist
+
+// quicklist is a doubly linked list of listpacks: every node holds a
+// listpack of up to 'fill' entries (or bytes), so that the list has the
+// memory efficiency of the listpack and the O(1) push/pop at both ends of
+// a linked list, whatever its length.
+//
+// Nodes that are deeper than 'compress' nodes from both ends are LZF
+// compressed, since most list workloads only access the head and the tail.
+
+import (
+	"strconv"
+
+	"listpack"
+	"lzf"
+)
+
+// Where to push and pop.
+const (
+	// Head of the list.
+	Head = 0
+	// Tail of the list.
+	Tail = -1
+)
+
+// Directions for iterators
+const (
+	// StartHead iter from the head.
+	StartHead = 0
+	// StartTail iter from the tail.
+	StartTail = 1
+)
+
+const (
+	// FillMax max entries per node for a positive fill.
+	FillMax = 1 << 15
+	// FillMin min fill, the node is limited to 64kb.
+	FillMin = -5
+	// CompressMax max compress depth.
+	CompressMax = 1 << 16
+	// BookmarksMax max number of bookmarks.
+	BookmarksMax = 15
+
+	// Optimization levels for size-based filling.
+	// Note that the largest possible limit is 64k, so even if each record
+	// takes just one byte, it still won't overflow the 16 bit count field.
+
+	// sizeSafetyLimit Maximum size in bytes of any multi-element listpack.
+	// Larger values will live in their own isolated listpacks.
+	// This is used only if we're limited by record count. when we're
+	// limited by size, the maximum limit is bigger, but still safe.
+	// 8k is a recommended / default size limit
+	sizeSafetyLimit = 8192
+
+	// sizeEstimateOverhead Estimate of the listpack entry overhead, when
+	// checking if a new element fits.
+	sizeEstimateOverhead = 8
+
+	// minCompressBytes Minimum listpack size in bytes for attempting
+	// compression.
+	minCompressBytes = 48
+
+	// minCompressImprove Minimum size reduction in bytes to store
+	// compressed quicklistNode data. This also prevents us from storing
+	// compression if the compression resulted in a larger size than the
+	// original data.
+	minCompressImprove = 8
+)
+
+var optimizationLevel = [...]int{4096, 8192, 16384, 32768, 65536}
+
+// Node quicklist node, holding a listpack that may be LZF compressed.
+type Node struct {
+	prev, next *Node
+
+	// the listpack, nil when the node is compressed.
+	lp *listpack.Listpack
+	// LZF compressed listpack, when lp is nil.
+	compressed []byte
+
+	// listpack size in bytes, even when compressed.
+	sz int
+	// count of items in the listpack
+	count int
+
+	// was this node previously compressed?
+	recompress bool
+	// node can't compress; too small
+	attemptedCompress bool
+}
+
+// Count Return the number of entries of the node.
+func (n *Node) Count() int {
+	return n.count
+}
+
+// Compressed Check if the node is currently compressed.
+func (n *Node) Compressed() bool {
+	return n.lp == nil
+}
+
+// Size Return the size in bytes of the listpack of the node, even when
+// compressed.
+func (n *Node) Size() int {
+	return n.sz
+}
+
+// Next Return the next node, nil for the tail.
+func (n *Node) Next() *Node {
+	return n.next
+}
+
+// Listpack Return the listpack of the node. A compressed node is
+// decompressed into a new listpack, the node is left compressed.
+func (n *Node) Listpack() *listpack.Listpack {
+	if n.lp != nil {
+		return n.lp
+	}
+	buf, err := lzf.Decompress(n.compressed, n.sz)
+	if err != nil {
+		// The data was compressed by us, this can't happen.
+		panic(err)
+	}
+	lp, err := listpack.Load(buf)
+	if err != nil {
+		panic(err)
+	}
+	return lp
+}
+
+type bookmark struct {
+	name string
+	node *Node
+}
+
+// Quicklist quicklist.
+type Quicklist struct {
+	head, tail *Node
+	// total count of all entries in all listpacks
+	count int
+	// number of quicklistNodes
+	len int
+	// fill factor for individual nodes
+	fill int
+	// depth of end nodes not to compress; 0=off
+	compress int
+
+	bookmarks []bookmark
+}
+
+// Entry an entry of the quicklist, as returned by iterators and Index.
+type Entry struct {
+	// Str the string value, nil for integer entries.
+	Str []byte
+	// Int the integer value, if Str is nil.
+	Int int64
+
+	node *Node
+	// offset of the entry in the listpack of the node.
+	p int
+	// position of the entry in the node, from the head.
+	offset int
+}
+
+// Iter quicklist iter.
+type Iter struct {
+	ql      *Quicklist
+	current *Node
+	// offset of the current entry in the listpack, -1 to seek 'offset'.
+	p int
+	// position in the node, negative when counted from the tail.
+	offset    int
+	direction int
+}
+
+// Create a new quicklist.
+// 'fill' is the max number of entries of a node when positive, or the
+// optimization level (-1 for 4kb to -5 for 64kb) limiting the node size in
+// bytes when negative. 'compress' is the number of nodes at each end that
+// are never compressed, 0 disables the compression.
+func Create(fill, compress int) *Quicklist {
+	ql := &Quicklist{}
+	ql.SetOptions(fill, compress)
+	return ql
+}
+
+// SetCompressDepth Set the compress depth.
+func (ql *Quicklist) SetCompressDepth(compress int) {
+	if compress > CompressMax {
+		compress = CompressMax
+	} else if compress < 0 {
+		compress = 0
+	}
+	ql.compress = compress
+}
+
+// SetFill Set the fill factor.
+func (ql *Quicklist) SetFill(fill int) {
+	if fill > FillMax {
+		fill = FillMax
+	} else if fill < FillMin {
+		fill = FillMin
+	}
+	ql.fill = fill
+}
+
+// SetOptions Set the fill factor and the compress depth.
+func (ql *Quicklist) SetOptions(fill, compress int) {
+	ql.SetFill(fill)
+	ql.SetCompressDepth(compress)
+}
+
+// Count Return the number of entries of the quicklist.
+func (ql *Quicklist) Count() int {
+	return ql.count
+}
+
+// Len Return the number of nodes of the quicklist.
+func (ql *Quicklist) Len() int {
+	return ql.len
+}
+
+// Head Return the first node, nil if the quicklist is empty.
+func (ql *Quicklist) Head() *Node {
+	return ql.head
+}
+
+// Release Free the whole quicklist.
+func (ql *Quicklist) Release() {
+	ql.head, ql.tail = nil, nil
+	ql.count, ql.len = 0, 0
+	ql.bookmarks = nil
+}
+
+func createNode() *Node {
+	lp := listpack.Create()
+	return &Node{
+		lp: lp,
+		sz: lp.BlobLen(),
+	}
+}
+
+func (n *Node) updateSz() {
+	n.sz = n.lp.BlobLen()
+}
+
+// compressNode Compress the listpack in 'node'. Returns false if the
+// compression was not attempted or didn't save enough space.
+func compressNode(node *Node) bool {
+	if node == nil || node.lp == nil {
+		return false
+	}
+
+	node.recompress = false
+	node.attemptedCompress = true
+
+	// Don't bother compressing small values
+	if node.sz < minCompressBytes {
+		return false
+	}
+
+	// Cancel if compression fails or doesn't compress small enough
+	c := lzf.Compress(node.lp.Bytes())
+	if len(c)+minCompressImprove >= node.sz {
+		return false
+	}
+
+	node.compressed = c
+	node.lp = nil
+	node.attemptedCompress = false
+	return true
+}
+
+// decompressNode Uncompress the listpack in 'node'.
+func decompressNode(node *Node) {
+	if node == nil || node.lp != nil {
+		return
+	}
+
+	node.attemptedCompress = false
+	buf, err := lzf.Decompress(node.compressed, node.sz)
+	if err != nil {
+		// The data was compressed by us, this can't happen.
+		panic(err)
+	}
+	lp, err := listpack.Load(buf)
+	if err != nil {
+		panic(err)
+	}
+	node.lp = lp
+	node.compressed = nil
+}
+
+// decompressNodeForUse Force node to not be immediately re-compressible.
+func decompressNodeForUse(node *Node) {
+	if node != nil && node.lp == nil {
+		decompressNode(node)
+		node.recompress = true
+	}
+}
+
+// recompressOnly Compress the node only if it was compressed before being
+// used.
+func recompressOnly(node *Node) {
+	if node != nil && node.recompress {
+		compressNode(node)
+	}
+}
+
+// compressAround Force 'quicklist' to meet compression guidelines set by
+// compression depth. The only way to guarantee interior nodes get
+// compressed is to iterate to our "interior" compress depth then compress
+// the next node we find. If compress depth is larger than the entire list,
+// we return immediately.
+func (ql *Quicklist) compressAround(node *Node) {
+	// If length is less than our compress depth (from both sides),
+	// we can't compress anything.
+	if ql.compress == 0 || ql.len < ql.compress*2 {
+		return
+	}
+
+	// Iterate until we reach compress depth for both sides of the list.
+	// Note: because we do length checks at the *top* of this function,
+	// we can skip explicit null checks below. Everything exists.
+	forward := ql.head
+	reverse := ql.tail
+	inDepth := false
+	for depth := 0; depth < ql.compress; depth++ {
+		decompressNode(forward)
+		decompressNode(reverse)
+
+		if forward == node || reverse == node {
+			inDepth = true
+		}
+
+		// We passed into compress depth of opposite side of the
+		// quicklist so there's no need to compress anything and we can
+		// exit.
+		if forward == reverse || forward.next == reverse {
+			return
+		}
+
+		forward = forward.next
+		reverse = reverse.prev
+	}
+
+	if !inDepth {
+		compressNode(node)
+	}
+
+	// At this point, forward and reverse are one node beyond depth
+	compressNode(forward)
+	compressNode(reverse)
+}
+
+// compressNodeOf Compress the node, or only re-compress it if it was
+// compressed before being used.
+func (ql *Quicklist) compressNodeOf(node *Node) {
+	if node.recompress {
+		compressNode(node)
+	} else {
+		ql.compressAround(node)
+	}
+}
+
+// insertNode Insert 'newNode' after 'oldNode' if 'after' is true.
+// Insert 'newNode' before 'oldNode' if 'after' is false.
+// Note: 'newNode' is *always* uncompressed, so if we assign it to
+// head or tail, we do not need to uncompress it.
+func (ql *Quicklist) insertNode(oldNode, newNode *Node, after bool) {
+	if after {
+		newNode.prev = oldNode
+		if oldNode != nil {
+			newNode.next = oldNode.next
+			if oldNode.next != nil {
+				oldNode.next.prev = newNode
+			}
+			oldNode.next = newNode
+		}
+		if ql.tail == oldNode {
+			ql.tail = newNode
+		}
+	} else {
+		newNode.next = oldNode
+		if oldNode != nil {
+			newNode.prev = oldNode.prev
+			if oldNode.prev != nil {
+				oldNode.prev.next = newNode
+			}
+			oldNode.prev = newNode
+		}
+		if ql.head == oldNode {
+			ql.head = newNode
+		}
+	}
+
+	// If this insert creates the only element so far, initialize
+	// head/tail.
+	if ql.len == 0 {
+		ql.head, ql.tail = newNode, newNode
+	}
+
+	// Update len first, so in compressAround we know exactly len
+	ql.len++
+
+	if oldNode != nil {
+		ql.compressNodeOf(oldNode)
+	}
+	ql.compressNodeOf(newNode)
+}
+
+// delNode Delete the node from the quicklist, updating the bookmarks.
+func (ql *Quicklist) delNode(node *Node) {
+	// Update the bookmark if any
+	for i := range ql.bookmarks {
+		if ql.bookmarks[i].node != node {
+			continue
+		}
+		// if the bookmark was to the last node, delete it.
+		if node.next != nil {
+			ql.bookmarks[i].node = node.next
+		} else {
+			ql.bookmarks = append(ql.bookmarks[:i], ql.bookmarks[i+1:]...)
+		}
+		break
+	}
+
+	if node.next != nil {
+		node.next.prev = node.prev
+	}
+	if node.prev != nil {
+		node.prev.next = node.next
+	}
+
+	if node == ql.tail {
+		ql.tail = node.prev
+	}
+	if node == ql.head {
+		ql.head = node.next
+	}
+
+	// Update len first, so in compressAround we know exactly len
+	ql.len--
+	ql.count -= node.count
+
+	// If we deleted a node within our compress depth, we
+	// now have compressed nodes needing to be decompressed.
+	ql.compressAround(nil)
+}
+
+// NodeLimit Return the max size in bytes and the max number of entries
+// of a node for the fill factor 'fill'. A negative 'fill' only limits the
+// size, a positive one the number of entries.
+func NodeLimit(fill int) (size, count int) {
+	size, count = int(^uint(0)>>1), int(^uint(0)>>1)
+	if fill >= 0 {
+		// Ensure that one node have at least one entry
+		count = fill
+		if fill == 0 {
+			count = 1
+		}
+	} else {
+		offset := -fill - 1
+		if offset >= len(optimizationLevel) {
+			offset = len(optimizationLevel) - 1
+		}
+		size = optimizationLevel[offset]
+	}
+	return size, count
+}
+
+// NodeExceedsLimit Check if a node with 'newSz' bytes and 'newCount'
+// entries would exceed the limits set by the fill factor 'fill'.
+func NodeExceedsLimit(fill, newSz, newCount int) bool {
+	sizeLimit, countLimit := NodeLimit(fill)
+	if fill < 0 {
+		return newSz > sizeLimit
+	}
+
+	// when we reach here we know that the limit is a count limit (which is
+	// safe)
+	if newSz > sizeSafetyLimit {
+		return true
+	}
+	return newCount > countLimit
+}
+
+// nodeExceedsLimit Check if a node with 'newSz' bytes and 'newCount'
+// entries would exceed the limits set by the fill factor.
+func (ql *Quicklist) nodeExceedsLimit(newSz, newCount int) bool {
+	return NodeExceedsLimit(ql.fill, newSz, newCount)
+}
+
+// nodeAllowInsert Check if an entry of 'sz' bytes can be added to the
+// node.
+func (ql *Quicklist) nodeAllowInsert(node *Node, sz int) bool {
+	if node == nil {
+		return false
+	}
+
+	// Estimate how many bytes will be added to the listpack by this one
+	// entry. We prefer an overestimation, which would at worse lead to a
+	// few bytes below the lowest limit of 4k (see optimizationLevel).
+	// Note: No need to check for overflow below since both `node.sz` and
+	// `sz` are to be less than 1GB after the plain/large element check
+	// above.
+	newSz := node.sz + sz + sizeEstimateOverhead
+	return !ql.nodeExceedsLimit(newSz, node.count+1)
+}
+
+// nodeAllowMerge Check if the nodes 'a' and 'b' can be merged.
+func (ql *Quicklist) nodeAllowMerge(a, b *Node) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	// approximate merged listpack size (- 7 to remove one listpack
+	// header/trailer, see listpack.HdrSize)
+	mergeSz := a.sz + b.sz - listpack.HdrSize - 1
+	return !ql.nodeExceedsLimit(mergeSz, a.count+b.count)
+}
+
+// PushHead Add new entry to head node of quicklist.
+// Returns false if used existing head.
+// Returns true if new head created.
+func (ql *Quicklist) PushHead(value []byte) bool {
+	origHead := ql.head
+
+	if ql.nodeAllowInsert(ql.head, len(value)) {
+		decompressNodeForUse(ql.head)
+		ql.head.lp.Prepend(value)
+		ql.head.updateSz()
+		recompressOnly(ql.head)
+	} else {
+		node := createNode()
+		node.lp.Prepend(value)
+		node.updateSz()
+		ql.insertNode(ql.head, node, false)
+	}
+	ql.count++
+	ql.head.count++
+	return origHead != ql.head
+}
+
+// PushTail Add new entry to tail node of quicklist.
+// Returns false if used existing tail.
+// Returns true if new tail created.
+func (ql *Quicklist) PushTail(value []byte) bool {
+	origTail := ql.tail
+
+	if ql.nodeAllowInsert(ql.tail, len(value)) {
+		decompressNodeForUse(ql.tail)
+		ql.tail.lp.Append(value)
+		ql.tail.updateSz()
+		recompressOnly(ql.tail)
+	} else {
+		node := createNode()
+		node.lp.Append(value)
+		node.updateSz()
+		ql.insertNode(ql.tail, node, true)
+	}
+	ql.count++
+	ql.tail.count++
+	return origTail != ql.tail
+}
+
+// AppendListpack Create a new node holding 'lp' and append it to the tail
+// of the quicklist. The listpack is owned by the quicklist from now on.
+func (ql *Quicklist) AppendListpack(lp *listpack.Listpack) {
+	node := createNode()
+	node.lp = lp
+	node.count = lp.Len()
+	node.updateSz()
+	ql.insertNode(ql.tail, node, true)
+	ql.count += node.count
+}
+
+// Push Wrapper to allow argument-based switching between head/tail pop
+func (ql *Quicklist) Push(value []byte, where int) {
+	if where == Head {
+		ql.PushHead(value)
+	} else if where == Tail {
+		ql.PushTail(value)
+	}
+}
+
+// delIndex Delete one entry from list given the node for the entry and a
+// pointer to the entry in the node.
+// Note: delIndex *requires* uncompressed nodes because you already had to
+// get *p from an uncompressed node somewhere.
+// Returns true if the entire node was deleted, false if node still exists.
+// Also updates in/out param 'p' with the next offset in the listpack.
+func (ql *Quicklist) delIndex(node *Node, p *int) bool {
+	gone := false
+
+	*p = node.lp.Delete(*p)
+	node.count--
+	if node.count == 0 {
+		gone = true
+		ql.delNode(node)
+	} else {
+		node.updateSz()
+	}
+	ql.count--
+	// If we deleted the node, the original node is no longer valid
+	return gone
+}
+
+// nodeEntry Fill 'entry' with the entry at offset 'p' of the node.
+func nodeEntry(node *Node, p int, offset int, entry *Entry) {
+	sval, lval, _ := node.lp.Get(p)
+	entry.node = node
+	entry.p = p
+	entry.offset = offset
+	entry.Str = sval
+	entry.Int = lval
+}
+
+// GetIterator Returns a quicklist iterator 'iter'. After the initialization
+// every call to Next() will return the next element of the quicklist.
+func (ql *Quicklist) GetIterator(direction int) *Iter {
+	iter := &Iter{
+		ql:        ql,
+		p:         -1,
+		direction: direction,
+	}
+	if direction == StartHead {
+		iter.current = ql.head
+		iter.offset = 0
+	} else {
+		iter.current = ql.tail
+		iter.offset = -1
+	}
+	return iter
+}
+
+// GetIteratorAtIdx Initialize an iterator at a specific offset 'idx' and
+// make the iterator return nodes in 'direction' direction.
+// Returns nil if the index is out of range.
+func (ql *Quicklist) GetIteratorAtIdx(direction int, idx int) *Iter {
+	pos := idx
+	if idx < 0 {
+		pos = ql.count + idx
+	}
+	if pos < 0 || pos >= ql.count {
+		return nil
+	}
+
+	// Seek from the nearest end, 'accum' being the number of entries
+	// before 'n' from the head.
+	var n *Node
+	accum := 0
+	if pos <= (ql.count-1)/2 {
+		for n = ql.head; n != nil; n = n.next {
+			if accum+n.count > pos {
+				break
+			}
+			accum += n.count
+		}
+	} else {
+		accum = ql.count
+		for n = ql.tail; n != nil; n = n.prev {
+			accum -= n.count
+			if accum <= pos {
+				break
+			}
+		}
+	}
+	if n == nil {
+		return nil
+	}
+
+	iter := ql.GetIterator(direction)
+	iter.current = n
+	iter.offset = pos - accum
+	if direction == StartTail {
+		iter.offset -= n.count
+	}
+	return iter
+}
+
+// Release the iterator.
+// If we still have a valid current node, then re-encode current node.
+func (iter *Iter) Release() {
+	if iter.current != nil {
+		iter.ql.compressNodeOf(iter.current)
+	}
+}
+
+// Next Get next element in iterator.
+//
+// Note: You must NOT insert into the list while iterating over it.
+// You *may* delete from the list while iterating using the DelEntry()
+// function.
+// If you insert into the quicklist while iterating, you should re-create
+// the iterator after your addition.
+//
+// Populates 'entry' with values for this iteration.
+// Returns false when iteration is complete or if iteration not possible.
+// If return value is false, the contents of 'entry' are not valid.
+func (iter *Iter) Next(entry *Entry) bool {
+	for iter.current != nil {
+		if iter.p == -1 {
+			// If !p, use current index.
+			decompressNodeForUse(iter.current)
+			iter.p = iter.current.lp.Seek(iter.offset)
+		} else if iter.direction == StartHead {
+			iter.p = iter.current.lp.Next(iter.p)
+			iter.offset++
+		} else {
+			iter.p = iter.current.lp.Prev(iter.p)
+			iter.offset--
+		}
+
+		if iter.p != -1 {
+			offset := iter.offset
+			if offset < 0 {
+				offset += iter.current.count
+			}
+			nodeEntry(iter.current, iter.p, offset, entry)
+			return true
+		}
+
+		// We ran out of listpack entries.
+		// Pick next node, update offset, then re-run retrieval.
+		iter.ql.compressNodeOf(iter.current)
+		if iter.direction == StartHead {
+			// Forward traversal
+			iter.current = iter.current.next
+			iter.offset = 0
+		} else {
+			// Reverse traversal
+			iter.current = iter.current.prev
+			iter.offset = -1
+		}
+		iter.p = -1
+	}
+	return false
+}
+
+// DelEntry Delete the entry returned by the last call of Next, the
+// iteration continues with the entry following it.
+func (iter *Iter) DelEntry(entry *Entry) {
+	prev := entry.node.prev
+	next := entry.node.next
+	deletedNode := iter.ql.delIndex(entry.node, &entry.p)
+
+	// after delete, the p is invalid so we need to seek the offset again
+	iter.p = -1
+
+	// If current node is deleted, we must update iterator node and
+	// offset.
+	if deletedNode {
+		if iter.direction == StartHead {
+			iter.current = next
+			iter.offset = 0
+		} else {
+			iter.current = prev
+			iter.offset = -1
+		}
+	}
+	// else if (!deletedNode), no changes needed.
+	// we already reset iter.p above, and the existing iter.offset
+	// doesn't move again because:
+	//   - [1, 2, 3] => delete offset 1 => [1, 3]: next element still
+	//     offset 1
+	//   - [1, 2, 3] => delete offset 0 => [2, 3]: next element still
+	//     offset 0
+	//  if we deleted the last element at offset N and now
+	//  length of this listpack is N-1, the next call into Next() will
+	//  jump to the next node.
+}
+
+// Index Populate 'entry' with the element at the specified zero-based
+// index where 0 is the head, 1 is the element next to head and so on.
+// Negative integers are used in order to count from the tail, -1 is the
+// last element, -2 the penultimate and so on. If the index is out of
+// range false is returned.
+func (ql *Quicklist) Index(idx int, entry *Entry) bool {
+	iter := ql.GetIteratorAtIdx(StartHead, idx)
+	if iter == nil {
+		return false
+	}
+	defer iter.Release()
+	return iter.Next(entry)
+}
+
+// ReplaceEntry Replace the entry returned by an iterator or Index with
+// 'data'.
+func (ql *Quicklist) ReplaceEntry(entry *Entry, data []byte) {
+	decompressNodeForUse(entry.node)
+	entry.p = entry.node.lp.Replace(entry.p, data)
+	entry.node.updateSz()
+	ql.compressNodeOf(entry.node)
+}
+
+// ReplaceAtIndex Replace quicklist entry at offset 'index' by 'data'.
+// Returns true if replace happened.
+// Returns false if replace failed and no changes happened.
+func (ql *Quicklist) ReplaceAtIndex(index int, data []byte) bool {
+	var entry Entry
+	if !ql.Index(index, &entry) {
+		return false
+	}
+	ql.ReplaceEntry(&entry, data)
+	return true
+}
+
+// listpackMerge Given two nodes, try to merge their listpacks.
+// This helps us not have a quicklist with 3 element listpacks if
+// our fill factor can handle much higher levels.
+// Note: 'a' must be to the LEFT of 'b'.
+// After calling this function, 'b' is deleted, and the merged node 'a' is
+// returned.
+func (ql *Quicklist) listpackMerge(a, b *Node) *Node {
+	decompressNode(a)
+	decompressNode(b)
+
+	for p := b.lp.First(); p != -1; p = b.lp.Next(p) {
+		sval, lval, _ := b.lp.Get(p)
+		if sval != nil {
+			a.lp.Append(sval)
+		} else {
+			a.lp.AppendInteger(lval)
+		}
+	}
+	a.count += b.count
+	a.updateSz()
+
+	// delNode subtracts the count of the deleted node.
+	ql.count += b.count
+	ql.delNode(b)
+	ql.compressNodeOf(a)
+	return a
+}
+
+// mergeNodes Attempt to merge listpacks within two nodes on either side
+// of 'center'.
+//
+// We attempt to merge:
+//   - (center.prev.prev, center.prev)
+//   - (center.next, center.next.next)
+//   - (center.prev, center)
+//   - (center, center.next)
+func (ql *Quicklist) mergeNodes(center *Node) {
+	var prev, prevPrev, next, nextNext *Node
+
+	if center.prev != nil {
+		prev = center.prev
+		prevPrev = center.prev.prev
+	}
+	if center.next != nil {
+		next = center.next
+		nextNext = center.next.next
+	}
+
+	// Try to merge prevPrev and prev
+	if ql.nodeAllowMerge(prevPrev, prev) {
+		ql.listpackMerge(prevPrev, prev)
+	}
+
+	// Try to merge next and nextNext
+	if ql.nodeAllowMerge(next, nextNext) {
+		ql.listpackMerge(next, nextNext)
+	}
+
+	// Try to merge center node and previous node
+	target := center
+	if ql.nodeAllowMerge(center.prev, center) {
+		target = ql.listpackMerge(center.prev, center)
+	}
+
+	// Use result of center merge (or original) to merge with next node.
+	if ql.nodeAllowMerge(target, target.next) {
+		ql.listpackMerge(target, target.next)
+	}
+}
+
+// splitNode Split 'node' into two parts, the first 'offset' entries stay
+// in 'node', the others are moved to the returned node, which is not
+// linked in the quicklist yet.
+func (ql *Quicklist) splitNode(node *Node, offset int) *Node {
+	decompressNode(node)
+
+	newNode := createNode()
+	for p := node.lp.Seek(offset); p != -1; p = node.lp.Next(p) {
+		sval, lval, _ := node.lp.Get(p)
+		if sval != nil {
+			newNode.lp.Append(sval)
+		} else {
+			newNode.lp.AppendInteger(lval)
+		}
+	}
+	newNode.count = node.count - offset
+	newNode.updateSz()
+
+	node.lp.DeleteRange(offset, node.count-offset)
+	node.count = offset
+	node.updateSz()
+	return newNode
+}
+
+// insert Insert a new entry before or after existing entry 'entry'.
+//
+// If after is true, the new value is inserted after 'entry', otherwise
+// the new value is inserted before 'entry'.
+func (ql *Quicklist) insert(entry *Entry, value []byte, after bool) {
+	var (
+		full, atTail, atHead, fullNext, fullPrev bool
+	)
+
+	node := entry.node
+	if node == nil {
+		// we have no reference node, so let's create only node in the list
+		newNode := createNode()
+		newNode.lp.Prepend(value)
+		newNode.count++
+		newNode.updateSz()
+		ql.insertNode(nil, newNode, after)
+		ql.count++
+		return
+	}
+
+	// Populate accounting flags for easier boolean checks later
+	if !ql.nodeAllowInsert(node, len(value)) {
+		full = true
+	}
+
+	if after && entry.offset == node.count-1 {
+		atTail = true
+		if node.next != nil && !ql.nodeAllowInsert(node.next, len(value)) {
+			fullNext = true
+		}
+	}
+
+	if !after && entry.offset == 0 {
+		atHead = true
+		if node.prev != nil && !ql.nodeAllowInsert(node.prev, len(value)) {
+			fullPrev = true
+		}
+	}
+
+	// Now determine where and how to insert the new element
+	switch {
+	case !full:
+		// Insert into the node in place.
+		decompressNodeForUse(node)
+		where := listpack.Before
+		if after {
+			where = listpack.After
+		}
+		node.lp.Insert(value, entry.p, where)
+		node.count++
+		node.updateSz()
+		recompressOnly(node)
+	case atTail && node.next != nil && !fullNext && after:
+		// If we are: at tail, next has free space, and inserting after:
+		//   - insert entry at head of next node.
+		newNode := node.next
+		decompressNodeForUse(newNode)
+		newNode.lp.Prepend(value)
+		newNode.count++
+		newNode.updateSz()
+		recompressOnly(newNode)
+		recompressOnly(node)
+	case atHead && node.prev != nil && !fullPrev && !after:
+		// If we are: at head, previous has free space, and inserting
+		// before:
+		//   - insert entry at tail of previous node.
+		newNode := node.prev
+		decompressNodeForUse(newNode)
+		newNode.lp.Append(value)
+		newNode.count++
+		newNode.updateSz()
+		recompressOnly(newNode)
+		recompressOnly(node)
+	case (atTail && after && (node.next == nil || fullNext)) ||
+		(atHead && !after && (node.prev == nil || fullPrev)):
+		// If we are: full, and our prev/next has no available space,
+		// then:
+		//   - create new node and attach to quicklist
+		newNode := createNode()
+		newNode.lp.Append(value)
+		newNode.count++
+		newNode.updateSz()
+		ql.insertNode(node, newNode, after)
+	default:
+		// else, node is full we need to split it.
+		// covers both after and !after cases
+		offset := entry.offset
+		if after {
+			offset++
+		}
+		newNode := ql.splitNode(node, offset)
+		if after {
+			newNode.lp.Prepend(value)
+			newNode.count++
+			newNode.updateSz()
+		} else {
+			node.lp.Append(value)
+			node.count++
+			node.updateSz()
+		}
+		ql.insertNode(node, newNode, true)
+		ql.mergeNodes(node)
+	}
+
+	ql.count++
+}
+
+// InsertBefore Insert 'value' before the entry returned by an iterator or
+// Index. The iterator must be re-created after the insertion.
+func (ql *Quicklist) InsertBefore(entry *Entry, value []byte) {
+	ql.insert(entry, value, false)
+}
+
+// InsertAfter Insert 'value' after the entry returned by an iterator or
+// Index. The iterator must be re-created after the insertion.
+func (ql *Quicklist) InsertAfter(entry *Entry, value []byte) {
+	ql.insert(entry, value, true)
+}
+
+// DelRange Delete a range of elements from the quicklist.
+//
+// elements may span across multiple quicklistNodes, so we
+// have to be careful about tracking where we start and end.
+//
+// Returns true if entries were deleted, false if nothing was deleted.
+func (ql *Quicklist) DelRange(start, count int) bool {
+	if count <= 0 {
+		return false
+	}
+
+	extent := count // range is inclusive of start position
+
+	if start >= 0 && extent > ql.count-start {
+		// if requesting delete more elements than exist, limit to list
+		// size.
+		extent = ql.count - start
+	} else if start < 0 && extent > -start {
+		// else, if at negative offset, limit max size to rest of list.
+		extent = -start // c.f. LREM -29 29; just delete until end.
+	}
+
+	var entry Entry
+	if !ql.Index(start, &entry) {
+		return false
+	}
+
+	node := entry.node
+	offset := entry.offset
+
+	// iterate over next nodes until everything is deleted.
+	for extent > 0 {
+		next := node.next
+
+		var (
+			del              int
+			deleteEntireNode bool
+		)
+		if offset == 0 && extent >= node.count {
+			// If we are deleting more than the count of this node, we
+			// can just delete the entire node without listpack math.
+			deleteEntireNode = true
+			del = node.count
+		} else if extent+offset >= node.count {
+			// If deleting more nodes after this one, calculate delete
+			// based on size of current node.
+			del = node.count - offset
+		} else {
+			// else, we are deleting less than the extent of this node,
+			// so use extent directly.
+			del = extent
+		}
+
+		if deleteEntireNode {
+			ql.delNode(node)
+		} else {
+			decompressNodeForUse(node)
+			node.lp.DeleteRange(offset, del)
+			node.count -= del
+			node.updateSz()
+			ql.count -= del
+			if node.count == 0 {
+				ql.delNode(node)
+			} else {
+				ql.compressNodeOf(node)
+			}
+		}
+
+		extent -= del
+		node = next
+		offset = 0
+	}
+	return true
+}
+
+// Pop a value from the head or the tail of the quicklist. The string is
+// copied, so it stays valid after the entry is deleted.
+// Returns false if the quicklist is empty.
+func (ql *Quicklist) Pop(where int) (sval []byte, lval int64, ok bool) {
+	if ql.count == 0 {
+		return nil, 0, false
+	}
+
+	node := ql.head
+	if where == Tail {
+		node = ql.tail
+	}
+
+	decompressNodeForUse(node)
+	p := node.lp.First()
+	if where == Tail {
+		p = node.lp.Last()
+	}
+
+	sval, lval, _ = node.lp.Get(p)
+	if sval != nil {
+		sval = append([]byte{}, sval...)
+	}
+	if !ql.delIndex(node, &p) {
+		recompressOnly(node)
+	}
+	return sval, lval, true
+}
+
+// Rotate Move the last entry of the quicklist to the head.
+func (ql *Quicklist) Rotate() {
+	if ql.count <= 1 {
+		return
+	}
+
+	sval, lval, _ := ql.Pop(Tail)
+	if sval == nil {
+		sval = strconv.AppendInt(nil, lval, 10)
+	}
+	ql.PushHead(sval)
+}
+
+// Dup Duplicate the quicklist.
+func (ql *Quicklist) Dup() *Quicklist {
+	copy := Create(ql.fill, ql.compress)
+
+	for current := ql.head; current != nil; current = current.next {
+		node := &Node{
+			sz:                current.sz,
+			count:             current.count,
+			recompress:        current.recompress,
+			attemptedCompress: current.attemptedCompress,
+		}
+		if current.lp != nil {
+			lp, _ := listpack.Load(append([]byte{}, current.lp.Bytes()...))
+			node.lp = lp
+		} else {
+			node.compressed = append([]byte{}, current.compressed...)
+		}
+
+		node.prev = copy.tail
+		if copy.tail != nil {
+			copy.tail.next = node
+		} else {
+			copy.head = node
+		}
+		copy.tail = node
+		copy.len++
+		copy.count += node.count
+	}
+	return copy
+}
+
+// BookmarkCreate Create or update a bookmark in the list which will be
+// updated to the next node automatically when the one referenced gets
+// deleted. Returns false on failure (reached the maximum supported number
+// of bookmarks).
+// NOTE: use short simple names, so that string compare on find is quick.
+func (ql *Quicklist) BookmarkCreate(name string, node *Node) bool {
+	for i := range ql.bookmarks {
+		if ql.bookmarks[i].name == name {
+			ql.bookmarks[i].node = node
+			return true
+		}
+	}
+	if len(ql.bookmarks) >= BookmarksMax {
+		return false
+	}
+	ql.bookmarks = append(ql.bookmarks, bookmark{
+		name: name,
+		node: node,
+	})
+	return true
+}
+
+// BookmarkFind Find the quicklist node referenced by a named bookmark.
+// When the bookmarked node is deleted the bookmark is updated to the next
+// node, and if that's the last node, the bookmark is deleted (so find
+// returns nil).
+func (ql *Quicklist) BookmarkFind(name string) *Node {
+	for i := range ql.bookmarks {
+		if ql.bookmarks[i].name == name {
+			return ql.bookmarks[i].node
+		}
+	}
+	return nil
+}
+
+// BookmarkDelete Delete a named bookmark.
+// returns false if bookmark was not found, and true if deleted.
+func (ql *Quicklist) BookmarkDelete(name string) bool {
+	for i := range ql.bookmarks {
+		if ql.bookmarks[i].name == name {
+			ql.bookmarks = append(ql.bookmarks[:i], ql.bookmarks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// BookmarksClear Delete all the bookmarks.
+func (ql *Quicklist) BookmarksClear() {
+	ql.bookmarks = nil
+}
+
+// Node Return the node of the entry, to be used with bookmarks.
+func (e *Entry) Node() *Node {
+	return e.node
+}
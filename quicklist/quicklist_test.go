@@ -0,0 +1,313 @@
+package quicklist
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"listpack"
+)
+
+func entryString(entry *Entry) string {
+	if entry.Str != nil {
+		return string(entry.Str)
+	}
+	return strconv.FormatInt(entry.Int, 10)
+}
+
+// checkList Check the entries of the quicklist in both directions, and
+// the consistency of the nodes.
+func checkList(t *testing.T, ql *Quicklist, want []string) {
+	t.Helper()
+
+	var entry Entry
+
+	count, nodes := 0, 0
+	var prev *Node
+	for n := ql.head; n != nil; n = n.next {
+		if n.prev != prev || n.count == 0 {
+			t.Fatalf("node %d is inconsistent", nodes)
+		}
+		count += n.count
+		nodes++
+		prev = n
+	}
+	if prev != ql.tail || count != ql.Count() || nodes != ql.Len() {
+		t.Fatalf("count %d/%d nodes %d/%d", count, ql.Count(), nodes, ql.Len())
+	}
+	if ql.Count() != len(want) {
+		t.Fatalf("count %d, want %d", ql.Count(), len(want))
+	}
+
+	i := 0
+	iter := ql.GetIterator(StartHead)
+	for iter.Next(&entry) {
+		if s := entryString(&entry); s != want[i] {
+			t.Fatalf("entry %d is %q, want %q", i, s, want[i])
+		}
+		i++
+	}
+	iter = ql.GetIterator(StartTail)
+	for iter.Next(&entry) {
+		i--
+		if s := entryString(&entry); s != want[i] {
+			t.Fatalf("entry %d is %q, want %q", i, s, want[i])
+		}
+	}
+	for i := range want {
+		if !ql.Index(i, &entry) || entryString(&entry) != want[i] {
+			t.Fatalf("index %d is not %q", i, want[i])
+		}
+		if !ql.Index(i-len(want), &entry) || entryString(&entry) != want[i] {
+			t.Fatalf("index %d is not %q", i-len(want), want[i])
+		}
+	}
+	if ql.Index(len(want), &entry) || ql.Index(-len(want)-1, &entry) {
+		t.Fatal("index out of range")
+	}
+}
+
+func values(n int, format string) []string {
+	want := make([]string, n)
+	for i := range want {
+		want[i] = fmt.Sprintf(format, i)
+	}
+	return want
+}
+
+func TestQuicklistPushPop(t *testing.T) {
+	for _, fill := range []int{1, 4, 32, -1, -5} {
+		ql := Create(fill, 0)
+		want := values(100, "%d")
+
+		for i := 49; i >= 0; i-- {
+			ql.PushHead([]byte(want[i]))
+		}
+		for i := 50; i < 100; i++ {
+			ql.Push([]byte(want[i]), Tail)
+		}
+		checkList(t, ql, want)
+		if fill == 4 && ql.Len() != 26 {
+			t.Fatalf("fill %d made %d nodes", fill, ql.Len())
+		}
+
+		if _, lval, ok := ql.Pop(Head); !ok || lval != 0 {
+			t.FailNow()
+		}
+		if _, lval, ok := ql.Pop(Tail); !ok || lval != 99 {
+			t.FailNow()
+		}
+		checkList(t, ql, want[1:99])
+
+		ql.Rotate()
+		checkList(t, ql, append([]string{"98"}, want[1:98]...))
+	}
+
+	ql := Create(-2, 0)
+	if _, _, ok := ql.Pop(Head); ok {
+		t.FailNow()
+	}
+}
+
+func TestQuicklistCompress(t *testing.T) {
+	ql := Create(16, 1)
+	want := values(500, "value number %d, compressible")
+	for _, s := range want {
+		ql.PushTail([]byte(s))
+	}
+	checkList(t, ql, want)
+
+	// Only the head and the tail are left uncompressed.
+	for n := ql.head; n != nil; n = n.next {
+		if n.Compressed() != (n != ql.head && n != ql.tail) {
+			t.Fatalf("node compressed: %v", n.Compressed())
+		}
+	}
+
+	// The listpack of a compressed node is decompressed on demand.
+	n := ql.head.next
+	if lp := n.Listpack(); lp.Len() != n.Count() || !n.Compressed() {
+		t.Fatalf("node listpack: %d entries", lp.Len())
+	}
+
+	dup := ql.Dup()
+	checkList(t, dup, want)
+
+	// Deleting the head uncompresses the new head.
+	ql.DelRange(0, 16)
+	checkList(t, ql, want[16:])
+	if ql.head.Compressed() || ql.tail.Compressed() {
+		t.FailNow()
+	}
+}
+
+func TestQuicklistInsert(t *testing.T) {
+	for _, compress := range []int{0, 1, 2} {
+		ql := Create(4, compress)
+		want := values(20, "v%d")
+		for _, s := range want {
+			ql.PushTail([]byte(s))
+		}
+
+		var entry Entry
+
+		// Insert in a full node, splitting it.
+		ql.Index(5, &entry)
+		ql.InsertAfter(&entry, []byte("a"))
+		want = append(want[:6], append([]string{"a"}, want[6:]...)...)
+		checkList(t, ql, want)
+
+		ql.Index(2, &entry)
+		ql.InsertBefore(&entry, []byte("b"))
+		want = append(want[:2], append([]string{"b"}, want[2:]...)...)
+		checkList(t, ql, want)
+
+		// Insert at the edges of nodes.
+		ql.Index(-1, &entry)
+		ql.InsertAfter(&entry, []byte("c"))
+		want = append(want, "c")
+		ql.Index(0, &entry)
+		ql.InsertBefore(&entry, []byte("d"))
+		want = append([]string{"d"}, want...)
+		checkList(t, ql, want)
+
+		if !ql.ReplaceAtIndex(3, []byte("replaced")) || ql.ReplaceAtIndex(100, nil) {
+			t.FailNow()
+		}
+		want[3] = "replaced"
+		checkList(t, ql, want)
+
+		empty := Create(4, compress)
+		empty.InsertAfter(&Entry{}, []byte("only"))
+		checkList(t, empty, []string{"only"})
+	}
+}
+
+func TestQuicklistDelete(t *testing.T) {
+	ql := Create(4, 1)
+	want := values(50, "entry-%d")
+	for _, s := range want {
+		ql.PushTail([]byte(s))
+	}
+
+	// Delete the even entries while iterating.
+	var entry Entry
+	iter := ql.GetIterator(StartHead)
+	for i := 0; iter.Next(&entry); i++ {
+		if i%2 == 0 {
+			iter.DelEntry(&entry)
+		}
+	}
+	iter.Release()
+	odd := make([]string, 0)
+	for i := 1; i < len(want); i += 2 {
+		odd = append(odd, want[i])
+	}
+	checkList(t, ql, odd)
+
+	// Same, backwards.
+	iter = ql.GetIterator(StartTail)
+	for i := 0; iter.Next(&entry); i++ {
+		if i%2 == 0 {
+			iter.DelEntry(&entry)
+		}
+	}
+	iter.Release()
+	rest := make([]string, 0)
+	for i := 0; i < len(odd); i++ {
+		if (len(odd)-1-i)%2 != 0 {
+			rest = append(rest, odd[i])
+		}
+	}
+	checkList(t, ql, rest)
+
+	if !ql.DelRange(1, 3) || ql.DelRange(0, 0) {
+		t.FailNow()
+	}
+	rest = append(rest[:1], rest[4:]...)
+	checkList(t, ql, rest)
+
+	ql.DelRange(-3, 100)
+	rest = rest[:len(rest)-3]
+	checkList(t, ql, rest)
+
+	ql.DelRange(0, ql.Count())
+	checkList(t, ql, nil)
+}
+
+func TestQuicklistBookmark(t *testing.T) {
+	ql := Create(1, 0)
+	for _, s := range values(3, "%d") {
+		ql.PushTail([]byte(s))
+	}
+
+	var entry Entry
+	ql.Index(1, &entry)
+	if !ql.BookmarkCreate("mid", entry.Node()) || ql.BookmarkFind("mid") != ql.head.next {
+		t.FailNow()
+	}
+
+	// Deleting the node moves the bookmark to the next one.
+	ql.DelRange(1, 1)
+	if ql.BookmarkFind("mid") != ql.tail {
+		t.FailNow()
+	}
+	ql.DelRange(1, 1)
+	if ql.BookmarkFind("mid") != nil {
+		t.FailNow()
+	}
+
+	for i := 0; i < BookmarksMax; i++ {
+		if !ql.BookmarkCreate(strconv.Itoa(i), ql.head) {
+			t.FailNow()
+		}
+	}
+	if ql.BookmarkCreate("full", ql.head) || !ql.BookmarkCreate("0", ql.head) {
+		t.FailNow()
+	}
+	if !ql.BookmarkDelete("0") || ql.BookmarkDelete("0") {
+		t.FailNow()
+	}
+	ql.BookmarksClear()
+	if ql.BookmarkFind("1") != nil {
+		t.FailNow()
+	}
+}
+
+func TestQuicklistAppendListpack(t *testing.T) {
+	want := values(30, "v%d")
+	ql := Create(8, 1)
+	for i := 0; i < 3; i++ {
+		lp := listpack.Create()
+		for _, v := range want[i*10 : i*10+10] {
+			lp.Append([]byte(v))
+		}
+		ql.AppendListpack(lp)
+	}
+	if ql.Len() != 3 || ql.Head().Count() != 10 || ql.Head().Next().Size() == 0 {
+		t.Fatalf("nodes %d", ql.Len())
+	}
+	checkList(t, ql, want)
+
+	// The nodes larger than the fill are still usable.
+	ql.PushTail([]byte("v30"))
+	checkList(t, ql, values(31, "v%d"))
+
+	for _, test := range []struct {
+		fill, size, count int
+	}{
+		{0, int(^uint(0) >> 1), 1},
+		{128, int(^uint(0) >> 1), 128},
+		{-1, 4096, int(^uint(0) >> 1)},
+		{-2, 8192, int(^uint(0) >> 1)},
+		{-9, 65536, int(^uint(0) >> 1)},
+	} {
+		if size, count := NodeLimit(test.fill); size != test.size || count != test.count {
+			t.Fatalf("NodeLimit(%d) = %d, %d", test.fill, size, count)
+		}
+	}
+	if !NodeExceedsLimit(4, 100, 5) || NodeExceedsLimit(4, 100, 4) || !NodeExceedsLimit(4, 9000, 1) ||
+		!NodeExceedsLimit(-1, 4097, 1) || NodeExceedsLimit(-1, 4096, 1000) {
+		t.Fatal("NodeExceedsLimit")
+	}
+}
@@ -0,0 +1,3 @@
+module rax
+
+go 1.14
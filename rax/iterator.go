@@ -0,0 +1,401 @@
+package rax
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Iterator iterates the keys of a Rax in lexicographic order. The
+// iterator must be positioned with Seek, and is invalidated by any
+// modification of the tree, after which it must be sought again.
+//
+//	it := r.Iterator()
+//	it.Seek(">=", []byte("foo"))
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+type Iterator struct {
+	rt *Rax
+	// The current key, the path from the head to node.
+	key []byte
+	// The current node, a key unless the iterator is at EOF.
+	node *raxNode
+	// The parents of node.
+	stack []*raxNode
+
+	eof bool
+	// Seek was called, the next Next or Prev returns the current key.
+	justSeeked bool
+}
+
+// Iterator Return a new iterator, not positioned, for the tree.
+func (r *Rax) Iterator() *Iterator {
+	return &Iterator{
+		rt:  r,
+		eof: true,
+	}
+}
+
+// Key Return the current key, owned by the iterator.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value Return the value of the current key.
+func (it *Iterator) Value() interface{} {
+	return it.node.value
+}
+
+// EOF Return true if the iterator is at EOF: Seek found no element, or
+// Next or Prev went past the last or first element.
+func (it *Iterator) EOF() bool {
+	return it.eof
+}
+
+// push Move to the child 'i' of the current node.
+func (it *Iterator) push(i int) {
+	n := it.node
+	if n.isCompr() {
+		it.key = append(it.key, n.data...)
+	} else {
+		it.key = append(it.key, n.data[i])
+	}
+	it.stack = append(it.stack, n)
+	it.node = n.children[i]
+}
+
+// pop Move to the parent of the current node, returning the char of the
+// edge from the parent to the node.
+func (it *Iterator) pop() byte {
+	prevchild := it.key[len(it.key)-1]
+	it.node = it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	todel := 1
+	if it.node.isCompr() {
+		todel = it.node.size()
+	}
+	it.key = it.key[:len(it.key)-todel]
+	return prevchild
+}
+
+// first Move to the lexicographically smaller key in the subtree of the
+// current node, which is the first one found always going towards the
+// first child of every successive node.
+func (it *Iterator) first() bool {
+	for !it.node.isKey() {
+		if it.node.numChildren() == 0 {
+			// Only the empty head.
+			return false
+		}
+		it.push(0)
+	}
+	return true
+}
+
+// last Move to the lexicographically greater key in the subtree of the
+// current node, the leftmost leaf always going towards the last child.
+func (it *Iterator) last() bool {
+	for it.node.numChildren() > 0 {
+		it.push(it.node.numChildren() - 1)
+	}
+	return it.node.isKey()
+}
+
+// nextSubtree Move to the first key after the subtree of the current
+// node: go upper until a node is found where there are children
+// representing keys lexicographically greater than the current key.
+func (it *Iterator) nextSubtree() bool {
+	for it.node != it.rt.head {
+		prevchild := it.pop()
+
+		// Try visiting the next child if there was at least one
+		// additional child.
+		if !it.node.isCompr() {
+			for i, c := range it.node.data {
+				if c > prevchild {
+					it.push(i)
+					return it.first()
+				}
+			}
+		}
+	}
+	return false
+}
+
+// prevSubtree Move to the last key before the subtree of the current
+// node: go upper until a node is found where there are children
+// representing keys lexicographically smaller than the current key, or
+// that is itself a key.
+func (it *Iterator) prevSubtree() bool {
+	for it.node != it.rt.head {
+		prevchild := it.pop()
+
+		// Try visiting the prev child if there is at least one child
+		// smaller than the current one.
+		if !it.node.isCompr() {
+			for i := it.node.size() - 1; i >= 0; i-- {
+				if it.node.data[i] < prevchild {
+					it.push(i)
+					return it.last()
+				}
+			}
+		}
+
+		// If there is no prev child, the node itself is the key
+		// before the subtree, if it is a key.
+		if it.node.isKey() {
+			return true
+		}
+	}
+	return false
+}
+
+// nextStep Move to the next key: the first key of the children of the
+// current node, or the first key after its subtree.
+func (it *Iterator) nextStep() bool {
+	if it.node.numChildren() > 0 {
+		it.push(0)
+		return it.first()
+	}
+	return it.nextSubtree()
+}
+
+// reset Position the iterator at the head.
+func (it *Iterator) reset() {
+	it.key = it.key[:0]
+	it.stack = it.stack[:0]
+	it.node = it.rt.head
+	it.eof = false
+	it.justSeeked = false
+}
+
+// Seek the iterator at the first element satisfying the operator 'op'
+// with 'ele', so that the next call to Next or Prev returns it. The
+// operators are:
+//
+//	"=":  the element equal to ele.
+//	">":  the first element greater than ele.
+//	">=": the first element greater or equal than ele.
+//	"<":  the first element smaller than ele.
+//	"<=": the first element smaller or equal than ele.
+//	"^":  the first element, ele is ignored.
+//	"$":  the last element, ele is ignored.
+//
+// Returns false if 'op' is invalid. When no element satisfies 'op' the
+// iterator is at EOF, and Next or Prev return false.
+func (it *Iterator) Seek(op string, ele []byte) bool {
+	var eq, lt, gt, first, last bool
+	switch op {
+	case "=":
+		eq = true
+	case ">":
+		gt = true
+	case ">=":
+		gt, eq = true, true
+	case "<":
+		lt = true
+	case "<=":
+		lt, eq = true, true
+	case "^":
+		first = true
+	case "$":
+		last = true
+	default:
+		return false // Error.
+	}
+
+	it.reset()
+	if it.rt.numele == 0 {
+		it.eof = true
+		return true
+	}
+
+	found := false
+	switch {
+	case first:
+		found = it.first()
+	case last:
+		found = it.last()
+	default:
+		found = it.seek(ele, eq, lt, gt)
+	}
+	it.eof = !found
+	it.justSeeked = found
+	return true
+}
+
+func (it *Iterator) seek(ele []byte, eq, lt, gt bool) bool {
+	// We need to seek the specified key. What we do here is to actually
+	// perform a lookup, and later invoke the prev/next key code that we
+	// already use for iteration.
+	i, h, j := it.rt.lowWalk(ele, &it.stack)
+	it.node = h
+
+	// The path to the node: the chars of the key that were consumed,
+	// minus the ones matched inside a compressed node.
+	pathlen := i
+	if h.isCompr() {
+		pathlen -= j
+	}
+	it.key = append(it.key, ele[:pathlen]...)
+
+	// Return OK on exact match.
+	if i == len(ele) && (!h.isCompr() || j == 0) {
+		// The node is our key, or a node whose subtree has all the
+		// keys greater than ele.
+		if h.isKey() && eq {
+			return true
+		}
+		if gt {
+			if h.isKey() {
+				return it.nextStep()
+			}
+			return it.first()
+		}
+		if lt {
+			return it.prevSubtree()
+		}
+		return false
+	}
+	if eq && !lt && !gt {
+		return false
+	}
+
+	if h.isCompr() {
+		// The search stopped inside a compressed node: the keys in the
+		// subtree of the node are all greater than ele when ele ended
+		// inside the node or the mismatching char is smaller, otherwise
+		// they are all smaller.
+		subtreeGreater := i == len(ele) || ele[i] < h.data[j]
+		if gt {
+			if subtreeGreater {
+				it.push(0)
+				return it.first()
+			}
+			return it.nextSubtree()
+		}
+		if subtreeGreater {
+			return h.isKey() || it.prevSubtree()
+		}
+		return it.last()
+	}
+
+	// The search stopped at a normal node without a child for the
+	// char ele[i]: the children with a smaller char are smaller, the
+	// ones with a greater char are greater, and the node itself is
+	// smaller.
+	c := ele[i]
+	if gt {
+		for k, nc := range h.data {
+			if nc > c {
+				it.push(k)
+				return it.first()
+			}
+		}
+		return it.nextSubtree()
+	}
+	for k := h.size() - 1; k >= 0; k-- {
+		if h.data[k] < c {
+			it.push(k)
+			return it.last()
+		}
+	}
+	return h.isKey() || it.prevSubtree()
+}
+
+// Next Go to the next element in the scope of the iterator, returning
+// false when there are no more elements. The first call after Seek
+// returns the sought element.
+func (it *Iterator) Next() bool {
+	if it.eof || it.node == nil {
+		return false
+	}
+	if it.justSeeked {
+		it.justSeeked = false
+		return true
+	}
+
+	if !it.nextStep() {
+		it.eof = true
+		return false
+	}
+	return true
+}
+
+// Prev Go to the previous element in the scope of the iterator,
+// returning false when there are no more elements. The first call after
+// Seek returns the sought element.
+func (it *Iterator) Prev() bool {
+	if it.eof || it.node == nil {
+		return false
+	}
+	if it.justSeeked {
+		it.justSeeked = false
+		return true
+	}
+
+	if !it.prevSubtree() {
+		it.eof = true
+		return false
+	}
+	return true
+}
+
+// RandomWalk Perform a random walk starting in the current position of
+// the iterator, or from the head if the iterator was not sought.
+// Returns false if the tree is empty, otherwise positions the iterator at
+// a random key.
+//
+// This function does not produce a perfectly uniform distribution, but
+// the elements are reachable in a number of steps that is random and
+// bound to the log of the tree size. When 'steps' is 0 it is computed
+// from the number of elements.
+func (it *Iterator) RandomWalk(steps int) bool {
+	if it.rt.numele == 0 {
+		it.eof = true
+		return false
+	}
+
+	if steps == 0 {
+		fle := 1 + int(math.Floor(math.Log(float64(it.rt.numele))))
+		fle *= 2
+		steps = 1 + rand.Intn(fle)
+	}
+
+	if it.node == nil || it.eof {
+		it.reset()
+	}
+
+	for steps > 0 || !it.node.isKey() {
+		numchildren := it.node.numChildren()
+		up := 0
+		if it.node != it.rt.head {
+			up = 1
+		}
+		if numchildren+up == 0 {
+			// The empty key is the only element.
+			break
+		}
+		r := rand.Intn(numchildren + up)
+		if r == numchildren {
+			// Go up to parent.
+			it.pop()
+		} else {
+			// Select a random child.
+			it.push(r)
+		}
+		if it.node.isKey() {
+			steps--
+		}
+	}
+	it.eof = false
+	it.justSeeked = false
+	return true
+}
+
+// Compare the key currently pointed by the iterator to the specified
+// key according to the specified operator "==", ">", ">=", "<", "<=".
+// Returns false if the comparison is false or the operator is invalid.
+func (it *Iterator) Compare(op string, key []byte) bool {
+	return compare(it.key, op, key)
+}
@@ -0,0 +1,523 @@
+package rax
+
+// Rax -- A radix tree implementation, a port of the redis rax.
+//
+// A radix tree stores the keys as paths from the root: every node
+// represents the prefix obtained by concatenating the chars of the edges
+// from the root to the node, and the node flagged as key stores the value
+// of the key. Keys are ordered lexicographically, so the tree can be
+// iterated in order and sought by prefix.
+//
+// Nodes come in two flavors. A normal node has one child for every
+// distinct next char, the chars being sorted:
+//
+//	(f) ""
+//	  \
+//	  (o) "f"
+//	    \
+//	    (o) "fo"
+//	      \
+//	    [t   b] "foo"
+//	    /     \
+//	"foot" (e)     (a) "foob"
+//	      /         \
+//	"foote" (r)     (r) "fooba"
+//	        /         \
+//	"footer" []     [] "foobar"
+//
+// A compressed node represents a chain of nodes with a single child and
+// no key, storing all the chars of the chain in a single node:
+//
+//	["foo"] ""
+//	   |
+//	[t   b] "foo"
+//	/     \
+//	"foot" ("er")    ("ar") "foob"
+//	         /          \
+//	"footer" []          [] "foobar"
+//
+// Inserting a key may split a compressed node, and removing a key
+// recompresses the chain of nodes left with a single child.
+
+import (
+	"bytes"
+)
+
+const (
+	// nodeIsKey Does this node contain a key?
+	nodeIsKey uint8 = 1 << iota
+	// nodeIsCompr Node is compressed.
+	nodeIsCompr
+)
+
+// raxNode The node flags are packed in a single byte, the chars are the
+// edges to the children for a normal node, or the chars of the chain for
+// a compressed node that has a single child.
+type raxNode struct {
+	flags    uint8
+	data     []byte
+	children []*raxNode
+	value    interface{}
+}
+
+func (n *raxNode) isKey() bool {
+	return n.flags&nodeIsKey != 0
+}
+
+func (n *raxNode) isCompr() bool {
+	return n.flags&nodeIsCompr != 0
+}
+
+func (n *raxNode) setKey(value interface{}) {
+	n.flags |= nodeIsKey
+	n.value = value
+}
+
+func (n *raxNode) unsetKey() {
+	n.flags &^= nodeIsKey
+	n.value = nil
+}
+
+// size Return the number of chars of the node.
+func (n *raxNode) size() int {
+	return len(n.data)
+}
+
+// numChildren Return the number of children of the node.
+func (n *raxNode) numChildren() int {
+	return len(n.children)
+}
+
+// Rax rax.
+type Rax struct {
+	head     *raxNode
+	numele   uint64
+	numnodes uint64
+}
+
+// Create a new, empty radix tree.
+func Create() *Rax {
+	return &Rax{
+		head:     &raxNode{},
+		numnodes: 1,
+	}
+}
+
+// Len Return the number of elements inside the radix tree.
+func (r *Rax) Len() uint64 {
+	return r.numele
+}
+
+// NumNodes Return the number of nodes of the radix tree.
+func (r *Rax) NumNodes() uint64 {
+	return r.numnodes
+}
+
+// newNode Allocate a new node, the caller links it.
+func (r *Rax) newNode() *raxNode {
+	r.numnodes++
+	return &raxNode{}
+}
+
+// addChild Add a new child to the normal node 'n' for the char 'c',
+// keeping the chars sorted. Returns the new child.
+func (r *Rax) addChild(n *raxNode, c byte) *raxNode {
+	child := r.newNode()
+
+	pos := 0
+	for pos < len(n.data) && n.data[pos] < c {
+		pos++
+	}
+	n.data = append(n.data, 0)
+	copy(n.data[pos+1:], n.data[pos:])
+	n.data[pos] = c
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+	return child
+}
+
+// compressNode Turn the empty node 'n' into a compressed node with the
+// chars 's', returning its new child.
+func (r *Rax) compressNode(n *raxNode, s []byte) *raxNode {
+	child := r.newNode()
+	n.flags |= nodeIsCompr
+	n.data = append([]byte(nil), s...)
+	n.children = []*raxNode{child}
+	return child
+}
+
+// removeChild Remove the link to 'child' from the node 'parent'.
+func removeChild(parent, child *raxNode) {
+	if parent.isCompr() {
+		// If parent is compressed, it is turned into an empty node.
+		parent.flags &^= nodeIsCompr
+		parent.data = nil
+		parent.children = nil
+		return
+	}
+
+	for i, c := range parent.children {
+		if c == child {
+			parent.data = append(parent.data[:i], parent.data[i+1:]...)
+			parent.children = append(parent.children[:i], parent.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// replaceChild Replace the link of 'parent' to 'old' with a link to
+// 'child', replacing the head when 'parent' is nil.
+func (r *Rax) replaceChild(parent, old, child *raxNode) {
+	if parent == nil {
+		r.head = child
+		return
+	}
+	for i, c := range parent.children {
+		if c == old {
+			parent.children[i] = child
+			return
+		}
+	}
+}
+
+// lowWalk Low level function that walks the tree looking for the string
+// 's'. Returns the number of characters of the string that was possible
+// to process: if the returned integer is the same as len(s), then it
+// means that the node corresponding to the string was found (however it
+// may not be a key in case the node's key flag is not set or there is
+// a split in the middle of a compressed node).
+//
+// The node where the search ended (because the full string was processed
+// or because there was an early stop) is returned in 'stopnode', and the
+// 'parents' are the nodes traversed to reach it, if 'ts' is not nil.
+//
+// When the search stops in a compressed node, 'splitpos' returns the
+// index inside the compressed node where the search ended. This is
+// useful when we want to split the node for insertion.
+func (r *Rax) lowWalk(s []byte, ts *[]*raxNode) (i int, stopnode *raxNode, splitpos int) {
+	h := r.head
+	j := 0
+
+	for h.size() > 0 && i < len(s) {
+		if h.isCompr() {
+			for j = 0; j < h.size() && i < len(s); j++ {
+				if h.data[j] != s[i] {
+					break
+				}
+				i++
+			}
+			if j != h.size() {
+				break
+			}
+			j = 0
+		} else {
+			for j = 0; j < h.size(); j++ {
+				if h.data[j] == s[i] {
+					break
+				}
+			}
+			if j == h.size() {
+				break
+			}
+			i++
+		}
+
+		if ts != nil {
+			*ts = append(*ts, h) // Save stack of parent nodes.
+		}
+		h = h.children[j]
+		j = 0 // If the new node is non compressed and we do not
+		// iterate again (since i == len) set the split position to 0
+		// to signal this node represents the searched key.
+	}
+	return i, h, j
+}
+
+// Find Return the value associated with the key, ok is false if the key
+// is not in the tree.
+func (r *Rax) Find(s []byte) (value interface{}, ok bool) {
+	i, h, j := r.lowWalk(s, nil)
+	if i != len(s) || (h.isCompr() && j != 0) || !h.isKey() {
+		return nil, false
+	}
+	return h.value, true
+}
+
+// Insert the element 's', setting as auxiliary data 'value'. If the
+// element was already present, the associated data is updated, and false
+// is returned, otherwise the element is inserted and true is returned.
+// The old value is returned when the element was already present.
+func (r *Rax) Insert(s []byte, value interface{}) (old interface{}, inserted bool) {
+	return r.genericInsert(s, value, true)
+}
+
+// TryInsert Like Insert, but if the element was already present, the
+// associated data is not updated and false is returned with the current
+// value.
+func (r *Rax) TryInsert(s []byte, value interface{}) (old interface{}, inserted bool) {
+	return r.genericInsert(s, value, false)
+}
+
+func (r *Rax) genericInsert(s []byte, value interface{}, overwrite bool) (interface{}, bool) {
+	var parents []*raxNode
+	i, h, j := r.lowWalk(s, &parents)
+	parent := func() *raxNode {
+		if len(parents) == 0 {
+			return nil
+		}
+		return parents[len(parents)-1]
+	}
+
+	// If i == len(s) and we are not in the middle of a compressed node,
+	// the string is either already inserted or this middle node is
+	// currently not a key, but can represent our key. We have just to
+	// reallocate the node and make space for the data pointer.
+	if i == len(s) && (!h.isCompr() || j == 0) {
+		if h.isKey() {
+			old := h.value
+			if overwrite {
+				h.value = value
+			}
+			return old, false
+		}
+		h.setKey(value)
+		r.numele++
+		return nil, true
+	}
+
+	// If the node we stopped at is a compressed node, we need to split it
+	// before to continue.
+	if h.isCompr() {
+		if i != len(s) {
+			// ALGO 1: the insertion diverges from the compressed node
+			// at the char 'j'. Split the node in three parts, the
+			// chars before 'j' (if any) in the trimmed node, the char
+			// 'j' as the only edge of a new normal node (the split
+			// node), and the chars after 'j' (if any) in the postfix
+			// node linked to the original child.
+			next := h.children[0]
+
+			// Create the postfix node: what remains of the original
+			// compressed node after the split.
+			postfix := next
+			if j+1 < h.size() {
+				postfix = r.newNode()
+				if h.size()-j-1 == 1 {
+					postfix.data = []byte{h.data[j+1]}
+				} else {
+					postfix.flags |= nodeIsCompr
+					postfix.data = append([]byte(nil), h.data[j+1:]...)
+				}
+				postfix.children = []*raxNode{next}
+			}
+
+			split := r.newNode()
+			split.data = []byte{h.data[j]}
+			split.children = []*raxNode{postfix}
+
+			if j == 0 {
+				// Replace the old node with the split node, copying
+				// the key if any.
+				split.flags |= h.flags & nodeIsKey
+				split.value = h.value
+				r.replaceChild(parent(), h, split)
+				r.numnodes--
+			} else {
+				// Trim the compressed node.
+				if j == 1 {
+					h.flags &^= nodeIsCompr
+				}
+				h.data = h.data[:j:j]
+				h.children = []*raxNode{split}
+			}
+			// We now continue inserting from the split node.
+			h = split
+		} else {
+			// ALGO 2: the string ends in the middle of the compressed
+			// node at 'j'. Split the node in the trimmed node with the
+			// chars before 'j', and the postfix node with the chars
+			// from 'j', that represents our key.
+			next := h.children[0]
+
+			postfix := r.newNode()
+			if h.size()-j > 1 {
+				postfix.flags |= nodeIsCompr
+			}
+			postfix.data = append([]byte(nil), h.data[j:]...)
+			postfix.children = []*raxNode{next}
+			postfix.setKey(value)
+
+			// Trim the compressed node.
+			if j == 1 {
+				h.flags &^= nodeIsCompr
+			}
+			h.data = h.data[:j:j]
+			h.children = []*raxNode{postfix}
+
+			r.numele++
+			return nil, true
+		}
+	}
+
+	// We walked the radix tree as far as we could, but still there are
+	// left chars in our string. We need to insert the missing nodes.
+	for i < len(s) {
+		// If this node is going to have a single child, and there
+		// are other characters, so that that would result in a chain
+		// of single-childed nodes, turn it into a compressed node.
+		if h.size() == 0 && len(s)-i > 1 {
+			h = r.compressNode(h, s[i:])
+			i = len(s)
+		} else {
+			h = r.addChild(h, s[i])
+			i++
+		}
+	}
+	h.setKey(value)
+	r.numele++
+	return nil, true
+}
+
+// Remove the specified item. Returns false if the item was not found,
+// otherwise true and the value of the removed item.
+func (r *Rax) Remove(s []byte) (old interface{}, removed bool) {
+	var parents []*raxNode
+	i, h, j := r.lowWalk(s, &parents)
+	if i != len(s) || (h.isCompr() && j != 0) || !h.isKey() {
+		return nil, false
+	}
+	old = h.value
+	h.unsetKey()
+	r.numele--
+
+	pop := func() *raxNode {
+		if len(parents) == 0 {
+			return nil
+		}
+		p := parents[len(parents)-1]
+		parents = parents[:len(parents)-1]
+		return p
+	}
+
+	// If this node has no children, the deletion needs to reclaim the
+	// no longer used nodes. This is an iterative process that needs to
+	// walk the three upward, deleting all the nodes with just one child
+	// that are not keys, until the head of the rax is reached or the
+	// first node with more than one child is found.
+	trycompress := false
+	if h.numChildren() == 0 {
+		var child *raxNode
+		for h != r.head {
+			child = h
+			r.numnodes--
+			h = pop()
+			// If this node has more than one child, or actually holds
+			// a key, stop here.
+			if h.isKey() || (!h.isCompr() && h.size() != 1) {
+				break
+			}
+		}
+		if child != nil {
+			removeChild(h, child)
+
+			// If after the removal the node has just a single child
+			// and is not a key, we need to try to compress it.
+			if h.size() == 1 && !h.isKey() {
+				trycompress = true
+			}
+		}
+	} else if h.numChildren() == 1 {
+		// If the node had just one child, after the removal of the key
+		// further compression with adjacent nodes is potentially
+		// possible.
+		trycompress = true
+	}
+
+	// Recompression: if trycompress is true, 'h' points to a radix tree
+	// node that changed in a way that could allow to compress nodes in
+	// this sub-branch. Compressed nodes represent chains of nodes that
+	// are not keys and have a single child, so there are two deletion
+	// events that may alter the tree so that further compression is
+	// needed:
+	//
+	// 1) A node with a single child was a key and now no longer is a key.
+	// 2) A node with two children now has just one child.
+	if trycompress {
+		// Try to reach the upper node that is compressible. At the end
+		// of the loop 'h' will point to the first node we can try to
+		// compress and 'parent' to its parent.
+		var parent *raxNode
+		for {
+			parent = pop()
+			if parent == nil || parent.isKey() || (!parent.isCompr() && parent.size() != 1) {
+				break
+			}
+			h = parent
+		}
+		start := h // Compression starting node.
+
+		// Scan chain of nodes we can compress.
+		var chars []byte
+		chars = append(chars, h.data...)
+		nodes := 1
+		for h.size() != 0 {
+			h = h.children[len(h.children)-1]
+			if h.isKey() || (!h.isCompr() && h.size() != 1) {
+				break
+			}
+			nodes++
+			chars = append(chars, h.data...)
+		}
+
+		if nodes > 1 {
+			// If we can compress, create the new node and populate it.
+			n := &raxNode{
+				flags:    nodeIsCompr,
+				data:     chars,
+				children: []*raxNode{h},
+			}
+			r.numnodes -= uint64(nodes - 1)
+			r.replaceChild(parent, start, n)
+		}
+	}
+	return old, true
+}
+
+// Release Free the whole radix tree, calling 'free' (when not nil) with
+// the value of every key.
+func (r *Rax) Release(free func(value interface{})) {
+	var recursiveFree func(n *raxNode)
+	recursiveFree = func(n *raxNode) {
+		for _, child := range n.children {
+			recursiveFree(child)
+		}
+		if free != nil && n.isKey() {
+			free(n.value)
+		}
+	}
+	recursiveFree(r.head)
+
+	r.head = &raxNode{}
+	r.numele = 0
+	r.numnodes = 1
+}
+
+// compare Compare the key 'a' with 'b' using the operator 'op', that is
+// one of "==", ">", ">=", "<", "<=".
+func compare(a []byte, op string, b []byte) bool {
+	cmp := bytes.Compare(a, b)
+	switch op {
+	case "==":
+		return cmp == 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
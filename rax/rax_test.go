@@ -0,0 +1,301 @@
+package rax
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// checkRax Verify the invariants of the nodes, and the counters.
+func checkRax(t *testing.T, r *Rax) {
+	t.Helper()
+	var nodes, keys uint64
+	var walk func(n *raxNode)
+	walk = func(n *raxNode) {
+		nodes++
+		if n.isKey() {
+			keys++
+		}
+		if n.isCompr() {
+			if n.size() < 2 || n.numChildren() != 1 {
+				t.Fatalf("compressed node size %d children %d", n.size(), n.numChildren())
+			}
+		} else {
+			if n.size() != n.numChildren() {
+				t.Fatalf("node size %d children %d", n.size(), n.numChildren())
+			}
+			for i := 1; i < n.size(); i++ {
+				if n.data[i-1] >= n.data[i] {
+					t.Fatalf("node chars not sorted: %q", n.data)
+				}
+			}
+		}
+		if n != r.head && !n.isKey() && n.numChildren() == 0 {
+			t.Fatal("leaf is not a key")
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(r.head)
+	if nodes != r.NumNodes() || keys != r.Len() {
+		t.Fatalf("nodes %d/%d keys %d/%d", nodes, r.NumNodes(), keys, r.Len())
+	}
+}
+
+func TestInsertFindRemove(t *testing.T) {
+	r := Create()
+	keys := []string{"foo", "foobar", "footer", "first", "f", "", "fo", "zzz"}
+	for i, k := range keys {
+		if _, inserted := r.Insert([]byte(k), i); !inserted {
+			t.Fatalf("%q not inserted", k)
+		}
+		checkRax(t, r)
+	}
+	if old, inserted := r.Insert([]byte("foo"), 100); inserted || old != 0 {
+		t.Fatalf("overwrite %v %v", old, inserted)
+	}
+	if old, inserted := r.TryInsert([]byte("foo"), 200); inserted || old != 100 {
+		t.Fatalf("try insert %v %v", old, inserted)
+	}
+	if v, ok := r.Find([]byte("foo")); !ok || v != 100 {
+		t.Fatalf("find %v %v", v, ok)
+	}
+	for _, k := range []string{"fooba", "foob", "fi", "z", "zzzz"} {
+		if _, ok := r.Find([]byte(k)); ok {
+			t.Fatalf("found %q", k)
+		}
+	}
+	if _, removed := r.Remove([]byte("fooba")); removed {
+		t.Fatal("removed missing key")
+	}
+	for _, k := range keys {
+		if _, removed := r.Remove([]byte(k)); !removed {
+			t.Fatalf("%q not removed", k)
+		}
+		checkRax(t, r)
+	}
+	if r.Len() != 0 || r.NumNodes() != 1 {
+		t.Fatalf("len %d nodes %d", r.Len(), r.NumNodes())
+	}
+}
+
+func TestRecompression(t *testing.T) {
+	r := Create()
+	r.Insert([]byte("foobar"), nil)
+	nodes := r.NumNodes()
+	r.Insert([]byte("footer"), nil)
+	r.Insert([]byte("foo"), nil)
+	r.Remove([]byte("footer"))
+	r.Remove([]byte("foo"))
+	checkRax(t, r)
+	if r.NumNodes() != nodes {
+		t.Fatalf("nodes %d, want %d", r.NumNodes(), nodes)
+	}
+}
+
+func randomKey() []byte {
+	key := make([]byte, rand.Intn(8))
+	for i := range key {
+		key[i] = "abcd"[rand.Intn(4)]
+	}
+	return key
+}
+
+func TestRandom(t *testing.T) {
+	r := Create()
+	m := make(map[string]int)
+	for i := 0; i < 20000; i++ {
+		key := randomKey()
+		if rand.Intn(3) == 0 {
+			_, removed := r.Remove(key)
+			_, ok := m[string(key)]
+			if removed != ok {
+				t.Fatalf("remove %q: %v, want %v", key, removed, ok)
+			}
+			delete(m, string(key))
+		} else {
+			_, inserted := r.Insert(key, i)
+			_, ok := m[string(key)]
+			if inserted == ok {
+				t.Fatalf("insert %q: %v", key, inserted)
+			}
+			m[string(key)] = i
+		}
+		if i%1000 == 0 {
+			checkRax(t, r)
+		}
+	}
+	checkRax(t, r)
+
+	for k, v := range m {
+		if got, ok := r.Find([]byte(k)); !ok || got != v {
+			t.Fatalf("find %q: %v %v", k, got, ok)
+		}
+	}
+}
+
+// seekRef Return the keys in iteration order after Seek(op, ele).
+func seekRef(sorted []string, op, ele string, forward bool) []string {
+	var start int
+	switch op {
+	case "^":
+		start = 0
+	case "$":
+		start = len(sorted) - 1
+	case "=":
+		start = sort.SearchStrings(sorted, ele)
+		if start == len(sorted) || sorted[start] != ele {
+			return nil
+		}
+	case ">=":
+		start = sort.SearchStrings(sorted, ele)
+	case ">":
+		start = sort.SearchStrings(sorted, ele)
+		if start < len(sorted) && sorted[start] == ele {
+			start++
+		}
+	case "<=":
+		start = sort.SearchStrings(sorted, ele)
+		if start == len(sorted) || sorted[start] != ele {
+			start--
+		}
+	case "<":
+		start = sort.SearchStrings(sorted, ele) - 1
+	}
+
+	var result []string
+	if forward {
+		for i := start; i >= 0 && i < len(sorted); i++ {
+			result = append(result, sorted[i])
+		}
+	} else {
+		for i := start; i >= 0 && i < len(sorted); i-- {
+			result = append(result, sorted[i])
+		}
+	}
+	return result
+}
+
+func TestIterator(t *testing.T) {
+	r := Create()
+	m := make(map[string]bool)
+	for i := 0; i < 300; i++ {
+		key := randomKey()
+		r.Insert(key, string(key))
+		m[string(key)] = true
+	}
+	sorted := make([]string, 0, len(m))
+	for k := range m {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	it := r.Iterator()
+	if it.Next() {
+		t.Fatal("not sought iterator")
+	}
+	if it.Seek("!", nil) {
+		t.Fatal("invalid operator")
+	}
+
+	for i := 0; i < 2000; i++ {
+		op := []string{"=", ">", ">=", "<", "<=", "^", "$"}[rand.Intn(7)]
+		ele := string(randomKey())
+		forward := rand.Intn(2) == 0
+
+		if !it.Seek(op, []byte(ele)) {
+			t.Fatal("seek")
+		}
+		want := seekRef(sorted, op, ele, forward)
+		var got []string
+		for {
+			var ok bool
+			if forward {
+				ok = it.Next()
+			} else {
+				ok = it.Prev()
+			}
+			if !ok {
+				break
+			}
+			if it.Value() != string(it.Key()) {
+				t.Fatalf("key %q value %v", it.Key(), it.Value())
+			}
+			got = append(got, string(it.Key()))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("seek %s %q forward %v:\n got %q\nwant %q", op, ele, forward, got, want)
+		}
+		if !it.EOF() {
+			t.Fatal("not EOF")
+		}
+	}
+}
+
+func TestIteratorEmpty(t *testing.T) {
+	r := Create()
+	it := r.Iterator()
+	it.Seek("^", nil)
+	if it.Next() || !it.EOF() {
+		t.Fatal("empty tree")
+	}
+	if it.RandomWalk(0) {
+		t.Fatal("random walk on empty tree")
+	}
+
+	r.Insert(nil, 1)
+	it.Seek(">=", nil)
+	if !it.Next() || len(it.Key()) != 0 || it.Next() {
+		t.Fatal("empty key")
+	}
+	if !it.RandomWalk(0) || len(it.Key()) != 0 {
+		t.Fatal("random walk to the empty key")
+	}
+}
+
+func TestRandomWalk(t *testing.T) {
+	r := Create()
+	for _, k := range []string{"a", "ab", "abc", "b", "bcd", "cde", "cdf"} {
+		r.Insert([]byte(k), k)
+	}
+
+	seen := make(map[string]int)
+	it := r.Iterator()
+	for i := 0; i < 10000; i++ {
+		if !it.RandomWalk(0) {
+			t.Fatal("random walk")
+		}
+		if v, ok := r.Find(it.Key()); !ok || v != it.Value() {
+			t.Fatalf("walked to %q", it.Key())
+		}
+		seen[string(it.Key())]++
+	}
+	if len(seen) != 7 {
+		t.Fatalf("seen %v", seen)
+	}
+
+	// Iterate from the random position.
+	it.Seek("=", []byte("b"))
+	it.Next()
+	if !it.Compare("==", []byte("b")) || !it.Compare(">", []byte("ab")) || !it.Compare("<=", []byte("bcd")) {
+		t.Fatal("compare")
+	}
+	if !it.Next() || !bytes.Equal(it.Key(), []byte("bcd")) {
+		t.Fatalf("next %q", it.Key())
+	}
+}
+
+func TestRelease(t *testing.T) {
+	r := Create()
+	for i := 0; i < 100; i++ {
+		r.Insert([]byte(fmt.Sprint(i)), i)
+	}
+	sum := 0
+	r.Release(func(v interface{}) { sum += v.(int) })
+	if sum != 4950 || r.Len() != 0 || r.NumNodes() != 1 {
+		t.Fatalf("sum %d len %d", sum, r.Len())
+	}
+}
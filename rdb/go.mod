@@ -0,0 +1,38 @@
+module rdb
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	db v0.0.0
+	dict v0.0.0
+	intset v0.0.0
+	listpack v0.0.0
+	lzf v0.0.0
+	object v0.0.0
+	quicklist v0.0.0
+	sds v0.0.0
+	stream v0.0.0
+	util v0.0.0
+	ziplist v0.0.0
+	zset v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	db => ../db
+	dict => ../dict
+	intset => ../intset
+	listpack => ../listpack
+	lzf => ../lzf
+	object => ../object
+	pubsub => ../pubsub
+	quicklist => ../quicklist
+	rax => ../rax
+	sds => ../sds
+	skiplist => ../skiplist
+	stream => ../stream
+	util => ../util
+	ziplist => ../ziplist
+	zset => ../zset
+)
@@ -0,0 +1,977 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"db"
+	"intset"
+	"listpack"
+	"lzf"
+	"object"
+	"stream"
+	"util"
+	"ziplist"
+	"zset"
+)
+
+// maxPrealloc The strings longer than this are read as they arrive, so
+// that a corrupted length doesn't allocate a huge buffer.
+const maxPrealloc = 1024 * 1024
+
+// Decoder reads the databases from an RDB file.
+type Decoder struct {
+	r   *bufio.Reader
+	crc uint64
+
+	cfg            *object.Config
+	now            func() time.Time
+	auxHandler     func(key, value []byte)
+	functionLoader func(code []byte) error
+}
+
+// DecoderOption opt.
+type DecoderOption func(d *Decoder)
+
+// WithObjectConfig The thresholds of the compact encodings of the loaded
+// values, object.DefaultConfig if nil.
+func WithObjectConfig(cfg *object.Config) DecoderOption {
+	return func(d *Decoder) {
+		d.cfg = cfg
+	}
+}
+
+// WithClock The keys already expired at 'now' are not loaded, time.Now by
+// default.
+func WithClock(now func() time.Time) DecoderOption {
+	return func(d *Decoder) {
+		d.now = now
+	}
+}
+
+// WithAuxHandler Call 'fn' with every aux field loaded.
+func WithAuxHandler(fn func(key, value []byte)) DecoderOption {
+	return func(d *Decoder) {
+		d.auxHandler = fn
+	}
+}
+
+// WithFunctionLoader Call 'fn' with the code of every function library
+// loaded, the loading fails with its error. The libraries are skipped by
+// default.
+func WithFunctionLoader(fn func(code []byte) error) DecoderOption {
+	return func(d *Decoder) {
+		d.functionLoader = fn
+	}
+}
+
+// CreateDecoder Create a decoder reading from 'r'. The reader is
+// buffered, unless it is a *bufio.Reader, which can be read past the end
+// of the RDB file after Load, like an AOF with an RDB preamble.
+func CreateDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{
+		r:   bufio.NewReader(r),
+		now: time.Now,
+	}
+
+	for _, o := range opts {
+		o(d)
+	}
+	return d
+}
+
+// read Read exactly len(p) bytes, updating the checksum. A truncated file
+// returns io.ErrUnexpectedEOF.
+func (d *Decoder) read(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.crc = util.Crc64(d.crc, p)
+	return nil
+}
+
+// loadType Load the type of the following object, or an opcode.
+func (d *Decoder) loadType() (byte, error) {
+	var buf [1]byte
+	err := d.read(buf[:])
+	return buf[0], err
+}
+
+// loadMillisecondTime Load a time in milliseconds.
+func (d *Decoder) loadMillisecondTime() (int64, error) {
+	var buf [8]byte
+	if err := d.read(buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// loadLenByRef Load an encoded length. When 'isencoded' is true the
+// length is the special encoding of the following string, one of the
+// enc* constants.
+func (d *Decoder) loadLenByRef() (l uint64, isencoded bool, err error) {
+	var buf [8]byte
+	if err := d.read(buf[:1]); err != nil {
+		return 0, false, err
+	}
+	typ := (buf[0] & 0xc0) >> 6
+	switch {
+	case typ == encVal:
+		// Read a 6 bit encoding type.
+		return uint64(buf[0] & 0x3f), true, nil
+	case typ == len6Bit:
+		// Read a 6 bit len.
+		return uint64(buf[0] & 0x3f), false, nil
+	case typ == len14Bit:
+		// Read a 14 bit len.
+		hi := buf[0]
+		if err := d.read(buf[:1]); err != nil {
+			return 0, false, err
+		}
+		return uint64(hi&0x3f)<<8 | uint64(buf[0]), false, nil
+	case buf[0] == len32Bit:
+		// Read a 32 bit len.
+		if err := d.read(buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf[:4])), false, nil
+	case buf[0] == len64Bit:
+		// Read a 64 bit len.
+		if err := d.read(buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf[:8]), false, nil
+	}
+	// Unknown length encoding
+	return 0, false, ErrCorrupt
+}
+
+// loadLen Load a length, an encoded length is an error.
+func (d *Decoder) loadLen() (uint64, error) {
+	l, isencoded, err := d.loadLenByRef()
+	if err == nil && isencoded {
+		err = ErrCorrupt
+	}
+	return l, err
+}
+
+// loadIntegerObject Load an integer-encoded string, as its decimal
+// representation.
+func (d *Decoder) loadIntegerObject(enctype uint64) ([]byte, error) {
+	var buf [4]byte
+	var v int64
+	switch enctype {
+	case encInt8:
+		if err := d.read(buf[:1]); err != nil {
+			return nil, err
+		}
+		v = int64(int8(buf[0]))
+	case encInt16:
+		if err := d.read(buf[:2]); err != nil {
+			return nil, err
+		}
+		v = int64(int16(binary.LittleEndian.Uint16(buf[:2])))
+	case encInt32:
+		if err := d.read(buf[:4]); err != nil {
+			return nil, err
+		}
+		v = int64(int32(binary.LittleEndian.Uint32(buf[:4])))
+	default:
+		// Unknown integer encoding type
+		return nil, ErrCorrupt
+	}
+	return strconv.AppendInt(nil, v, 10), nil
+}
+
+// loadBytes Load 'n' bytes, the long ones are read as they arrive.
+func (d *Decoder) loadBytes(n uint64) ([]byte, error) {
+	if n <= maxPrealloc {
+		buf := make([]byte, n)
+		return buf, d.read(buf)
+	}
+	var buf bytes.Buffer
+	chunk := make([]byte, maxPrealloc)
+	for n > 0 {
+		c := chunk
+		if n < uint64(len(c)) {
+			c = c[:n]
+		}
+		if err := d.read(c); err != nil {
+			return nil, err
+		}
+		buf.Write(c)
+		n -= uint64(len(c))
+	}
+	return buf.Bytes(), nil
+}
+
+// loadLzfString Load an LZF compressed string.
+func (d *Decoder) loadLzfString() ([]byte, error) {
+	clen, err := d.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	l, err := d.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.loadBytes(clen)
+	if err != nil {
+		return nil, err
+	}
+	// Load the compressed representation and uncompress it to target.
+	if l == 0 || l > math.MaxInt32 {
+		return nil, ErrCorrupt
+	}
+	val, err := lzf.Decompress(c, int(l))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return val, nil
+}
+
+// loadString Load a string, in any of its encodings.
+func (d *Decoder) loadString() ([]byte, error) {
+	l, isencoded, err := d.loadLenByRef()
+	if err != nil {
+		return nil, err
+	}
+	if isencoded {
+		switch l {
+		case encInt8, encInt16, encInt32:
+			return d.loadIntegerObject(l)
+		case encLZF:
+			return d.loadLzfString()
+		}
+		// Unknown string encoding
+		return nil, ErrCorrupt
+	}
+	return d.loadBytes(l)
+}
+
+// loadDoubleValue Load a double of TypeZset, saved as a string with a
+// special length for the infinities and NaN.
+func (d *Decoder) loadDoubleValue() (float64, error) {
+	var buf [256]byte
+	if err := d.read(buf[:1]); err != nil {
+		return 0, err
+	}
+	switch l := buf[0]; l {
+	case doubleNegInf:
+		return math.Inf(-1), nil
+	case doublePosInf:
+		return math.Inf(1), nil
+	case doubleNaN:
+		return math.NaN(), nil
+	default:
+		if err := d.read(buf[:l]); err != nil {
+			return 0, err
+		}
+		v, err := strconv.ParseFloat(string(buf[:l]), 64)
+		if err != nil {
+			return 0, ErrCorrupt
+		}
+		return v, nil
+	}
+}
+
+// loadBinaryDouble Load a double saved in binary.
+func (d *Decoder) loadBinaryDouble() (float64, error) {
+	var buf [8]byte
+	if err := d.read(buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// loadStreamID Load a stream ID saved as a 128 bit big endian number.
+func (d *Decoder) loadStreamID() (stream.ID, error) {
+	var buf [streamIDLen]byte
+	if err := d.read(buf[:]); err != nil {
+		return stream.ID{}, err
+	}
+	return decodeStreamID(buf[:]), nil
+}
+
+// decodeStreamID This is the reverse of encodeStreamID.
+func decodeStreamID(buf []byte) stream.ID {
+	return stream.ID{
+		Ms:  binary.BigEndian.Uint64(buf),
+		Seq: binary.BigEndian.Uint64(buf[8:]),
+	}
+}
+
+// elementBytes Return a listpack or ziplist element as a new slice.
+func elementBytes(sval []byte, lval int64) []byte {
+	if sval != nil {
+		return append([]byte{}, sval...)
+	}
+	return strconv.AppendInt(nil, lval, 10)
+}
+
+// loadListpackElements Load a listpack blob and return its elements.
+func (d *Decoder) loadListpackElements() ([][]byte, error) {
+	blob, err := d.loadString()
+	if err != nil {
+		return nil, err
+	}
+	lp, err := listpack.Load(blob)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	elements := make([][]byte, 0, lp.Len())
+	for p := lp.First(); p != -1; p = lp.Next(p) {
+		sval, lval, _ := lp.Get(p)
+		elements = append(elements, elementBytes(sval, lval))
+	}
+	return elements, nil
+}
+
+// loadZiplistElements Load a ziplist blob and return its elements.
+func (d *Decoder) loadZiplistElements() ([][]byte, error) {
+	blob, err := d.loadString()
+	if err != nil {
+		return nil, err
+	}
+	zl, err := ziplist.Load(blob)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	var elements [][]byte
+	for p := zl.Index(0); p != -1; p = zl.Next(p) {
+		sval, lval, _ := zl.Get(p)
+		elements = append(elements, elementBytes(sval, lval))
+	}
+	return elements, nil
+}
+
+// loadIntsetElements Load an intset blob and return its elements.
+func (d *Decoder) loadIntsetElements() ([][]byte, error) {
+	blob, err := d.loadString()
+	if err != nil {
+		return nil, err
+	}
+	is, err := intset.Load(blob)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	elements := make([][]byte, 0, is.Len())
+	for i := 0; i < is.Len(); i++ {
+		v, _ := is.Get(i)
+		elements = append(elements, strconv.AppendInt(nil, v, 10))
+	}
+	return elements, nil
+}
+
+// loadStrings Load a count followed by 'n' times the count strings.
+func (d *Decoder) loadStrings(n int) ([][]byte, error) {
+	l, err := d.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	if l > math.MaxInt32 {
+		return nil, ErrCorrupt
+	}
+	var elements [][]byte
+	for i := uint64(0); i < l*uint64(n); i++ {
+		s, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, s)
+	}
+	return elements, nil
+}
+
+// createList Create a list object with the elements.
+func (d *Decoder) createList(elements [][]byte) *object.Object {
+	o := object.CreateList()
+	object.ListPush(o, object.ListTail, elements, d.cfg)
+	return o
+}
+
+// createSet Create a set object with the members, presized to hold them.
+// Duplicated members are an error.
+func (d *Decoder) createSet(members [][]byte) (*object.Object, error) {
+	o := object.CreateSet(members[0], len(members), d.cfg)
+	for _, m := range members {
+		if !object.SetAdd(o, m, d.cfg) {
+			// Duplicate set members detected
+			o.DecrRefCount()
+			return nil, ErrCorrupt
+		}
+	}
+	return o, nil
+}
+
+// createHash Create a hash object with the field-value pairs, presized
+// to hold them. Duplicated fields are an error.
+func (d *Decoder) createHash(pairs [][]byte) (*object.Object, error) {
+	if len(pairs)%2 != 0 {
+		return nil, ErrCorrupt
+	}
+	o := object.CreateHashSized(len(pairs)/2, d.cfg)
+	for i := 0; i < len(pairs); i += 2 {
+		if object.HashSet(o, pairs[i], pairs[i+1], d.cfg) {
+			// Duplicate hash fields detected
+			o.DecrRefCount()
+			return nil, ErrCorrupt
+		}
+	}
+	return o, nil
+}
+
+// createZset Create a zset object with the elements, presized to hold
+// them. Duplicated members and NaN scores are an error.
+func (d *Decoder) createZset(elements []zset.ScoreMember) (*object.Object, error) {
+	o := object.CreateZset(d.cfg)
+	zs := o.Ptr().(*zset.Zset)
+	zs.Expand(len(elements))
+
+	maxelelen, totelelen := 0, 0
+	for _, el := range elements {
+		if math.IsNaN(el.Score) {
+			// Zset with NAN score detected
+			o.DecrRefCount()
+			return nil, ErrCorrupt
+		}
+		if _, out := zs.Add(el.Score, el.Member, 0); out&zset.OutAdded == 0 {
+			// Duplicate zset fields detected
+			o.DecrRefCount()
+			return nil, ErrCorrupt
+		}
+		if len(el.Member) > maxelelen {
+			maxelelen = len(el.Member)
+		}
+		totelelen += len(el.Member)
+	}
+
+	// Convert *after* loading, since sorted sets are not stored ordered.
+	zs.ConvertToListpackIfNeeded(maxelelen, totelelen)
+	return o, nil
+}
+
+// scoreMembers Convert the member-score pairs of a listpack or ziplist
+// encoded zset.
+func scoreMembers(pairs [][]byte) ([]zset.ScoreMember, error) {
+	if len(pairs)%2 != 0 {
+		return nil, ErrCorrupt
+	}
+	elements := make([]zset.ScoreMember, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(string(pairs[i+1]), 64)
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		elements = append(elements, zset.ScoreMember{Score: score, Member: pairs[i]})
+	}
+	return elements, nil
+}
+
+// loadQuicklist Load the elements of the nodes of a quicklist, ziplists
+// for TypeListQuicklist, plain or listpack nodes for TypeListQuicklist2.
+func (d *Decoder) loadQuicklist(typ byte) ([][]byte, error) {
+	n, err := d.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	var elements [][]byte
+	for ; n > 0; n-- {
+		container := uint64(quicklistNodeContainerPacked)
+		if typ == TypeListQuicklist2 {
+			if container, err = d.loadLen(); err != nil {
+				return nil, err
+			}
+			if container != quicklistNodeContainerPacked && container != quicklistNodeContainerPlain {
+				return nil, ErrCorrupt
+			}
+		}
+
+		var nodeElements [][]byte
+		switch {
+		case container == quicklistNodeContainerPlain:
+			s, err := d.loadString()
+			if err != nil {
+				return nil, err
+			}
+			nodeElements = [][]byte{s}
+		case typ == TypeListQuicklist2:
+			nodeElements, err = d.loadListpackElements()
+		default:
+			nodeElements, err = d.loadZiplistElements()
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Silently skip empty listpacks, if we'll end up with empty
+		// quicklist we'll fail later.
+		elements = append(elements, nodeElements...)
+	}
+	return elements, nil
+}
+
+// loadStream Load a stream of TypeStreamListpacks, TypeStreamListpacks2
+// or TypeStreamListpacks3.
+func (d *Decoder) loadStream(typ byte) (*object.Object, error) {
+	o := object.CreateStream()
+	s := o.Ptr().(*stream.Stream)
+	if err := d.loadStreamInto(typ, s); err != nil {
+		o.DecrRefCount()
+		return nil, err
+	}
+	return o, nil
+}
+
+// loadStreamInto Load the nodes, the metadata and the consumer groups of
+// the stream.
+func (d *Decoder) loadStreamInto(typ byte, s *stream.Stream) error {
+	nodes, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	for ; nodes > 0; nodes-- {
+		// Get the master ID, the one we'll use as key of the radix tree
+		// node: the entries inside the listpack itself are delta-encoded
+		// relatively to this ID.
+		key, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		if len(key) != streamIDLen {
+			// Stream node key entry is not the size of a stream ID
+			return ErrCorrupt
+		}
+		// Load the listpack.
+		blob, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		lp, err := listpack.Load(blob)
+		if err != nil {
+			return ErrCorrupt
+		}
+		if err := s.LoadNode(decodeStreamID(key), lp); err != nil {
+			return ErrCorrupt
+		}
+	}
+
+	// Load total number of items inside the stream, and the last ID.
+	var meta [3]uint64
+	for i := range meta {
+		if meta[i], err = d.loadLen(); err != nil {
+			return err
+		}
+	}
+	length, lastID := meta[0], stream.ID{Ms: meta[1], Seq: meta[2]}
+	// During migration the offset can be initialized to the stream's
+	// length.
+	entriesAdded := length
+	if typ >= TypeStreamListpacks2 {
+		// Load the first entry ID, the max deleted entry ID and the
+		// number of entries added.
+		var meta [5]uint64
+		for i := range meta {
+			if meta[i], err = d.loadLen(); err != nil {
+				return err
+			}
+		}
+		entriesAdded = meta[4]
+	}
+	if s.Len() != length {
+		return ErrCorrupt
+	}
+	s.LoadMeta(lastID, entriesAdded)
+
+	// Consumer groups loading
+	groups, err := d.loadLen()
+	if err != nil {
+		return err
+	}
+	for ; groups > 0; groups-- {
+		// Get the consumer group name and ID. We can then create the
+		// consumer group ASAP and populate its structure as we read
+		// more data.
+		name, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		g := stream.GroupState{Name: string(name)}
+		if g.LastID.Ms, err = d.loadLen(); err != nil {
+			return err
+		}
+		if g.LastID.Seq, err = d.loadLen(); err != nil {
+			return err
+		}
+		if typ >= TypeStreamListpacks2 {
+			// The read counter of the group is not tracked.
+			if _, err := d.loadLen(); err != nil {
+				return err
+			}
+		}
+
+		// Load the global PEL for this consumer group, however we'll
+		// not yet populate the NACK structures with the message owner,
+		// since consumers were not read yet.
+		pel, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for ; pel > 0; pel-- {
+			var p stream.PendingState
+			if p.ID, err = d.loadStreamID(); err != nil {
+				return err
+			}
+			if p.DeliveryTime, err = d.loadMillisecondTime(); err != nil {
+				return err
+			}
+			if p.DeliveryCount, err = d.loadLen(); err != nil {
+				return err
+			}
+			g.Pending = append(g.Pending, p)
+		}
+
+		// Now that we loaded our global PEL, we need to load the
+		// consumers and their local PELs.
+		consumers, err := d.loadLen()
+		if err != nil {
+			return err
+		}
+		for ; consumers > 0; consumers-- {
+			cname, err := d.loadString()
+			if err != nil {
+				return err
+			}
+			c := stream.ConsumerState{Name: string(cname)}
+			if c.SeenTime, err = d.loadMillisecondTime(); err != nil {
+				return err
+			}
+			c.ActiveTime = c.SeenTime
+			if typ >= TypeStreamListpacks3 {
+				if c.ActiveTime, err = d.loadMillisecondTime(); err != nil {
+					return err
+				}
+			}
+
+			// Load the PEL about entries owned by this specific
+			// consumer.
+			pel, err := d.loadLen()
+			if err != nil {
+				return err
+			}
+			for ; pel > 0; pel-- {
+				id, err := d.loadStreamID()
+				if err != nil {
+					return err
+				}
+				c.Pending = append(c.Pending, id)
+			}
+			g.Consumers = append(g.Consumers, c)
+		}
+
+		if err := s.LoadGroup(&g); err != nil {
+			// Duplicated consumer group name, or inconsistent PELs.
+			return ErrCorrupt
+		}
+	}
+	return nil
+}
+
+// loadObject Load a Redis object of the specified type. A nil object is
+// returned for an empty aggregate, that is skipped.
+func (d *Decoder) loadObject(typ byte) (*object.Object, error) {
+	var (
+		elements [][]byte
+		err      error
+	)
+
+	switch typ {
+	case TypeString:
+		s, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		return object.TryEncoding(object.CreateString(s)), nil
+
+	case TypeList:
+		elements, err = d.loadStrings(1)
+	case TypeListZiplist:
+		elements, err = d.loadZiplistElements()
+	case TypeListQuicklist, TypeListQuicklist2:
+		elements, err = d.loadQuicklist(typ)
+
+	case TypeSet:
+		elements, err = d.loadStrings(1)
+	case TypeSetIntset:
+		elements, err = d.loadIntsetElements()
+	case TypeSetListpack:
+		elements, err = d.loadListpackElements()
+
+	case TypeZset, TypeZset2:
+		return d.loadZset(typ)
+	case TypeZsetZiplist:
+		elements, err = d.loadZiplistElements()
+	case TypeZsetListpack:
+		elements, err = d.loadListpackElements()
+
+	case TypeHash:
+		elements, err = d.loadStrings(2)
+	case TypeHashZiplist:
+		elements, err = d.loadZiplistElements()
+	case TypeHashListpack:
+		elements, err = d.loadListpackElements()
+
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return d.loadStream(typ)
+
+	case TypeHashZipmap, TypeModulePreGA, TypeModule2:
+		return nil, ErrUnsupported
+	default:
+		return nil, ErrUnknownType
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		// Empty keys are skipped.
+		return nil, nil
+	}
+
+	switch typ {
+	case TypeList, TypeListZiplist, TypeListQuicklist, TypeListQuicklist2:
+		return d.createList(elements), nil
+	case TypeSet, TypeSetIntset, TypeSetListpack:
+		return d.createSet(elements)
+	case TypeZsetZiplist, TypeZsetListpack:
+		scoreMembers, err := scoreMembers(elements)
+		if err != nil {
+			return nil, err
+		}
+		return d.createZset(scoreMembers)
+	default:
+		return d.createHash(elements)
+	}
+}
+
+// loadZset Load a zset of TypeZset or TypeZset2.
+func (d *Decoder) loadZset(typ byte) (*object.Object, error) {
+	l, err := d.loadLen()
+	if err != nil {
+		return nil, err
+	}
+	var elements []zset.ScoreMember
+	for ; l > 0; l-- {
+		member, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if typ == TypeZset2 {
+			score, err = d.loadBinaryDouble()
+		} else {
+			score, err = d.loadDoubleValue()
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, zset.ScoreMember{Score: score, Member: member})
+	}
+	if len(elements) == 0 {
+		return nil, nil
+	}
+	return d.createZset(elements)
+}
+
+// loadVersion Load the magic string and the version of the file.
+func (d *Decoder) loadVersion() (int, error) {
+	var buf [9]byte
+	if err := d.read(buf[:]); err != nil {
+		return 0, err
+	}
+	if string(buf[:5]) != "REDIS" {
+		return 0, ErrSignature
+	}
+	ver, err := strconv.Atoi(string(buf[5:]))
+	if err != nil || ver < 1 || ver > Version {
+		return 0, ErrVersion
+	}
+	return ver, nil
+}
+
+// Load the databases from the RDB file, adding the keys to the databases
+// of 'dbs', that are usually empty: a key that already exists is an
+// error. The keys already expired are skipped.
+func (d *Decoder) Load(dbs *db.Server) error {
+	d.crc = 0
+	ver, err := d.loadVersion()
+	if err != nil {
+		return err
+	}
+
+	cur, _ := dbs.Select(0)
+	expire := int64(-1)
+	now := d.now().UnixNano() / int64(time.Millisecond)
+	for {
+		// Read type.
+		typ, err := d.loadType()
+		if err != nil {
+			return err
+		}
+
+		// Handle special types.
+		switch typ {
+		case OpcodeExpiretime:
+			// EXPIRETIME: load an expire associated with the next key
+			// to load. Note that after loading an expire we need to
+			// load the actual type, and continue.
+			var buf [4]byte
+			if err := d.read(buf[:]); err != nil {
+				return err
+			}
+			expire = int64(int32(binary.LittleEndian.Uint32(buf[:]))) * 1000
+			continue
+		case OpcodeExpiretimeMs:
+			// EXPIRETIME_MS: milliseconds precision expire times
+			// introduced with RDB v3. Like EXPIRETIME but no with more
+			// precision.
+			if expire, err = d.loadMillisecondTime(); err != nil {
+				return err
+			}
+			continue
+		case OpcodeFreq:
+			// FREQ: LFU frequency, not used.
+			var buf [1]byte
+			if err := d.read(buf[:]); err != nil {
+				return err
+			}
+			continue
+		case OpcodeIdle:
+			// IDLE: LRU idle time, not used.
+			if _, err := d.loadLen(); err != nil {
+				return err
+			}
+			continue
+		case OpcodeEOF:
+			// EOF: End of file, exit the main loop.
+			return d.verifyChecksum(ver)
+		case OpcodeSelectDB:
+			// SELECTDB: Select the specified database.
+			id, err := d.loadLen()
+			if err != nil {
+				return err
+			}
+			if id > math.MaxInt32 {
+				return db.ErrDBIndex
+			}
+			if cur, err = dbs.Select(int(id)); err != nil {
+				return err
+			}
+			continue
+		case OpcodeResizeDB:
+			// RESIZEDB: Hint about the size of the keys in the currently
+			// selected data base, in order to avoid useless rehashing.
+			size, err := d.loadLen()
+			if err != nil {
+				return err
+			}
+			expiresSize, err := d.loadLen()
+			if err != nil {
+				return err
+			}
+			cur.Expand(size, expiresSize)
+			continue
+		case OpcodeAux:
+			// AUX: generic string-string fields. Use to add state to RDB
+			// which is backward compatible. Implementations of RDB
+			// loading are required to skip AUX fields they don't
+			// understand.
+			key, err := d.loadString()
+			if err != nil {
+				return err
+			}
+			val, err := d.loadString()
+			if err != nil {
+				return err
+			}
+			if d.auxHandler != nil {
+				d.auxHandler(key, val)
+			}
+			continue
+		case OpcodeFunction2:
+			code, err := d.loadString()
+			if err != nil {
+				return err
+			}
+			if d.functionLoader != nil {
+				if err := d.functionLoader(code); err != nil {
+					return err
+				}
+			}
+			continue
+		case OpcodeModuleAux, OpcodeFunctionPreGA:
+			return ErrUnsupported
+		}
+
+		if !isObjectType(typ) {
+			return ErrUnknownType
+		}
+
+		// Read key
+		key, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		// Read value
+		val, err := d.loadObject(typ)
+		if err != nil {
+			return err
+		}
+
+		// Check if the key already expired. This function is used when
+		// loading an RDB file from disk, either at startup, or when an
+		// RDB was received from the master. In the latter case, the
+		// master is responsible for key expiry.
+		switch {
+		case val == nil:
+			// Empty keys are skipped.
+		case expire != -1 && expire < now:
+			val.DecrRefCount()
+		default:
+			// Add the new object in the hash table
+			added := cur.LoadKey(key, val, expire)
+			val.DecrRefCount()
+			if !added {
+				return ErrDuplicateKey
+			}
+		}
+
+		// Reset the state that is key-specified and is populated by
+		// opcodes before the key, so that we start from scratch again.
+		expire = -1
+	}
+}
+
+// verifyChecksum Verify the checksum at the end of the file, since RDB
+// version 5. A zero checksum means the checksum was not computed.
+func (d *Decoder) verifyChecksum(ver int) error {
+	if ver < 5 {
+		return nil
+	}
+	expected := d.crc
+	var buf [8]byte
+	if err := d.read(buf[:]); err != nil {
+		return err
+	}
+	cksum := binary.LittleEndian.Uint64(buf[:])
+	if cksum != 0 && cksum != expected {
+		return ErrChecksum
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package rdb
+
+// The RDB snapshot file format, a port of rdb.c of redis.
+//
+// An RDB file starts with the "REDIS" magic string and a 4 digits
+// version, followed by a sequence of opcodes and key-value pairs, and
+// ends with the EOF opcode and the CRC64 checksum of the whole file:
+//
+// REDIS0011 AUX... FUNCTION2... [SELECTDB RESIZEDB [EXPIRETIME_MS] type key value...]... EOF crc64
+//
+// The lengths are encoded in 1, 2, 5 or 9 bytes depending on their value,
+// and the strings as a length followed by the bytes, or as special
+// encodings: an integer that fits 8, 16 or 32 bits, or LZF compressed.
+//
+// The values of the small aggregate types are saved as the serialized
+// listpack or intset of their compact encoding, the others element by
+// element. All the type encodings since RDB version 1 can be loaded, but
+// the modules and the zipmap encoded hashes.
+
+import (
+	"errors"
+)
+
+// Error
+var (
+	// ErrSignature the file doesn't start with the RDB magic string.
+	ErrSignature = errors.New("rdb: wrong signature trying to load DB from file")
+	// ErrVersion the RDB version is not supported.
+	ErrVersion = errors.New("rdb: can't handle RDB format version")
+	// ErrChecksum the checksum of the file doesn't match its content.
+	ErrChecksum = errors.New("rdb: wrong RDB checksum")
+	// ErrCorrupt the file is malformed.
+	ErrCorrupt = errors.New("rdb: corrupt RDB file")
+	// ErrUnknownType the type of a value or an opcode is not known.
+	ErrUnknownType = errors.New("rdb: unknown RDB encoding type")
+	// ErrUnsupported the file holds module data, zipmap encoded hashes,
+	// or functions in the pre-GA format.
+	ErrUnsupported = errors.New("rdb: unsupported RDB content")
+	// ErrDuplicateKey a key is saved twice in the same database, or
+	// already exists in the database loaded.
+	ErrDuplicateKey = errors.New("rdb: duplicated key found in RDB file")
+)
+
+// Version The RDB version saved, of redis 7.2. The older versions can be
+// loaded.
+const Version = 11
+
+// redisVersion The redis version saved in the "redis-ver" aux field.
+const redisVersion = "7.2.0"
+
+// Object types.
+const (
+	TypeString = 0
+	TypeList   = 1
+	TypeSet    = 2
+	TypeZset   = 3
+	TypeHash   = 4
+	// TypeZset2 ZSET version 2 with doubles stored in binary.
+	TypeZset2 = 5
+	// TypeModulePreGA Used in 4.0 release candidates.
+	TypeModulePreGA = 6
+	// TypeModule2 Module value with annotations for parsing without the
+	// generating module being loaded.
+	TypeModule2 = 7
+
+	// Object types for encoded objects.
+	TypeHashZipmap       = 9
+	TypeListZiplist      = 10
+	TypeSetIntset        = 11
+	TypeZsetZiplist      = 12
+	TypeHashZiplist      = 13
+	TypeListQuicklist    = 14
+	TypeStreamListpacks  = 15
+	TypeHashListpack     = 16
+	TypeZsetListpack     = 17
+	TypeListQuicklist2   = 18
+	TypeStreamListpacks2 = 19
+	TypeSetListpack      = 20
+	TypeStreamListpacks3 = 21
+)
+
+// isObjectType Test if a type is an object type.
+func isObjectType(t byte) bool {
+	return t <= TypeModule2 || t >= TypeHashZipmap && t <= TypeStreamListpacks3
+}
+
+// Special RDB opcodes (saved/loaded with saveType/loadType).
+const (
+	// OpcodeFunction2 function library data
+	OpcodeFunction2 = 245
+	// OpcodeFunctionPreGA old function library data for 7.0 rc1 and rc2
+	OpcodeFunctionPreGA = 246
+	// OpcodeModuleAux Module auxiliary data.
+	OpcodeModuleAux = 247
+	// OpcodeIdle LRU idle time.
+	OpcodeIdle = 248
+	// OpcodeFreq LFU frequency.
+	OpcodeFreq = 249
+	// OpcodeAux RDB aux field.
+	OpcodeAux = 250
+	// OpcodeResizeDB Hash table resize hint.
+	OpcodeResizeDB = 251
+	// OpcodeExpiretimeMs Expire time in milliseconds.
+	OpcodeExpiretimeMs = 252
+	// OpcodeExpiretime Old expire time in seconds.
+	OpcodeExpiretime = 253
+	// OpcodeSelectDB DB number of the following keys.
+	OpcodeSelectDB = 254
+	// OpcodeEOF End of the RDB file.
+	OpcodeEOF = 255
+)
+
+// Defines related to the dump file format. To store 32 bits lengths for
+// short keys requires a lot of space, so we check the most significant 2
+// bits of the first byte to interpreter the length:
+//
+// 00|XXXXXX => if the two MSB are 00 the len is the 6 bits of this byte
+// 01|XXXXXX XXXXXXXX =>  01, the len is 14 bits, 6 bits + 8 bits of next byte
+// 10|000000 [32 bit integer] => A full 32 bit len in net byte order will follow
+// 10|000001 [64 bit integer] => A full 64 bit len in net byte order will follow
+// 11|OBKIND this means: specially encoded object will follow. The six bits
+//
+//	number specify the kind of object that follows.
+//	See the encVal* defines.
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	encVal   = 3
+)
+
+// When a length of a string object stored on disk has the first two bits
+// set, the remaining six bits specify a special encoding for the object
+// accordingly to the following defines:
+const (
+	encInt8  = 0 // 8 bit signed integer
+	encInt16 = 1 // 16 bit signed integer
+	encInt32 = 2 // 32 bit signed integer
+	encLZF   = 3 // string compressed with FASTLZ
+)
+
+// Quicklist node containers of TypeListQuicklist2.
+const (
+	quicklistNodeContainerPlain  = 1
+	quicklistNodeContainerPacked = 2
+)
+
+// The special lengths of the doubles of TypeZset, saved as strings.
+const (
+	doubleNaN    = 253
+	doublePosInf = 254
+	doubleNegInf = 255
+)
+
+// streamIDLen The size of a stream ID, saved as a 128 bit big endian
+// number.
+const streamIDLen = 16
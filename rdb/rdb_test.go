@@ -0,0 +1,647 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"db"
+	"intset"
+	"listpack"
+	"object"
+	"stream"
+	"ziplist"
+	"zset"
+)
+
+// dumpValue Return the type, the encoding and the content of the value.
+func dumpValue(o *object.Object) []interface{} {
+	var content interface{}
+	switch o.Type() {
+	case object.TypeString:
+		content = string(object.StringBytes(o))
+	case object.TypeList:
+		content = object.ListRange(o, 0, -1)
+	case object.TypeSet:
+		members := object.SetMembers(o)
+		sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i], members[j]) < 0 })
+		content = members
+	case object.TypeHash:
+		all := object.HashGetAll(o)
+		pairs := make([]string, 0, len(all)/2)
+		for i := 0; i < len(all); i += 2 {
+			pairs = append(pairs, string(all[i])+"="+string(all[i+1]))
+		}
+		sort.Strings(pairs)
+		content = pairs
+	case object.TypeZset:
+		content = o.Ptr().(*zset.Zset).RangeByRank(0, -1, false)
+	case object.TypeStream:
+		s := o.Ptr().(*stream.Stream)
+		content = []interface{}{s.Range(stream.ID{}, stream.MaxID, 0, false),
+			s.LastID(), s.EntriesAdded(), s.GroupStates()}
+	}
+	return []interface{}{o.Type(), o.Encoding(), content}
+}
+
+// dump Return the keys of every database, with their values and expires.
+func dump(dbs *db.Server) map[string][]interface{} {
+	keys := make(map[string][]interface{})
+	for id := 0; id < dbs.Len(); id++ {
+		d, _ := dbs.Select(id)
+		d.Each(func(key []byte, val *object.Object, expire int64) bool {
+			keys[fmt.Sprintf("%d:%s", id, key)] = append(dumpValue(val), expire)
+			return true
+		})
+	}
+	return keys
+}
+
+func save(t *testing.T, dbs *db.Server, opts ...EncoderOption) []byte {
+	var buf bytes.Buffer
+	if err := CreateEncoder(&buf, opts...).Save(dbs); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func load(data []byte, opts ...DecoderOption) (*db.Server, error) {
+	dbs := db.Create()
+	return dbs, CreateDecoder(bytes.NewReader(data), opts...).Load(dbs)
+}
+
+func add(d *db.DB, key string, val *object.Object) {
+	d.Add([]byte(key), val)
+	val.DecrRefCount()
+}
+
+func strs(elems ...string) [][]byte {
+	b := make([][]byte, len(elems))
+	for i, e := range elems {
+		b[i] = []byte(e)
+	}
+	return b
+}
+
+func seq(prefix string, n int) [][]byte {
+	b := make([][]byte, n)
+	for i := range b {
+		b[i] = []byte(prefix + strconv.Itoa(i))
+	}
+	return b
+}
+
+func createList(linked bool, elems [][]byte) *object.Object {
+	o := object.CreateList()
+	if linked {
+		o = object.CreateLinkedList()
+	}
+	object.ListPush(o, object.ListTail, elems, nil)
+	return o
+}
+
+func createSet(members [][]byte) *object.Object {
+	o := object.CreateSet(members[0], len(members), nil)
+	for _, m := range members {
+		object.SetAdd(o, m, nil)
+	}
+	return o
+}
+
+func createHash(pairs [][]byte) *object.Object {
+	o := object.CreateHash()
+	for i := 0; i < len(pairs); i += 2 {
+		object.HashSet(o, pairs[i], pairs[i+1], nil)
+	}
+	return o
+}
+
+func createZset(members [][]byte, scores ...float64) *object.Object {
+	o := object.CreateZset(nil)
+	zs := o.Ptr().(*zset.Zset)
+	for i, m := range members {
+		zs.Add(scores[i%len(scores)]+float64(i), m, 0)
+	}
+	return o
+}
+
+func createStream(t *testing.T, n int) *object.Object {
+	o := object.CreateStream(stream.WithNodeMaxEntries(10))
+	s := o.Ptr().(*stream.Stream)
+	for i := 1; i <= n; i++ {
+		fields := strs("f", strconv.Itoa(i))
+		if i%3 == 0 {
+			fields = append(fields, strs("g", "x")...)
+		}
+		if _, err := s.XAdd([]byte(fmt.Sprintf("%d-1", i)), fields, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n > 0 {
+		s.CreateGroup("g1", stream.ID{})
+		s.CreateGroup("g2", stream.ID{Ms: 3})
+		s.ReadGroup("g1", "alice", nil, 5, false)
+		s.ReadGroup("g1", "bob", nil, 2, false)
+		s.CreateConsumer("g2", "carol")
+	}
+	return o
+}
+
+func fillDB(t *testing.T, dbs *db.Server) {
+	d, _ := dbs.Select(0)
+	add(d, "str", object.CreateString([]byte("hello")))
+	add(d, "int", object.CreateStringFromLongLong(-123456))
+	add(d, "int8", object.CreateStringFromLongLong(-7))
+	add(d, "int16", object.TryEncoding(object.CreateString([]byte("30000"))))
+	add(d, "notint", object.CreateString([]byte("007")))
+	add(d, "empty", object.CreateString(nil))
+	add(d, "compressed", object.CreateString(bytes.Repeat([]byte("abcd"), 1000)))
+	rnd := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(1)).Read(rnd)
+	add(d, "large", object.CreateString(rnd))
+
+	add(d, "list", createList(false, strs("a", "1", "b")))
+	add(d, "quicklist", createList(false, seq("elem", 1000)))
+	add(d, "plainlist", createList(false, [][]byte{bytes.Repeat([]byte("p"), 1<<20), []byte("x")}))
+	add(d, "linkedlist", createList(true, strs("x", "y")))
+
+	add(d, "intset", createSet(strs("1", "-2", "300000", "5000000000")))
+	add(d, "lpset", createSet(strs("a", "b", "1")))
+	add(d, "htset", createSet(seq("member", 200)))
+
+	add(d, "lphash", createHash(strs("f1", "v1", "f2", "2")))
+	add(d, "hthash", createHash(seq("field", 400)))
+
+	add(d, "lpzset", createZset(strs("a", "b", "c"), 1.5, math.Inf(-1)))
+	add(d, "slzset", createZset(seq("z", 300), 0.25, math.Inf(1), -3))
+
+	add(d, "stream", createStream(t, 25))
+	add(d, "emptystream", createStream(t, 0))
+
+	d, _ = dbs.Select(3)
+	for i := 0; i < 1000; i++ {
+		key := []byte("key:" + strconv.Itoa(i))
+		add(d, string(key), object.CreateStringFromLongLong(int64(i)))
+		if i%2 == 0 {
+			d.SetExpire(key, time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond)+int64(i))
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dbs := db.Create()
+	fillDB(t, dbs)
+	want := dump(dbs)
+
+	for _, compression := range []bool{true, false} {
+		data := save(t, dbs, WithCompression(compression), WithAuxField("aof-base", "0"),
+			WithFunctions([]byte("#!lua name=lib1"), []byte("#!lua name=lib2")))
+
+		aux := make(map[string]string)
+		var functions []string
+		loaded, err := load(data,
+			WithAuxHandler(func(key, value []byte) {
+				aux[string(key)] = string(value)
+			}),
+			WithFunctionLoader(func(code []byte) error {
+				functions = append(functions, string(code))
+				return nil
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := dump(loaded)
+		for key, val := range want {
+			// A linkedlist is loaded with the encoding of the new lists.
+			if key == "0:linkedlist" {
+				val = append([]interface{}{val[0], got[key][1]}, val[2:]...)
+			}
+			if !reflect.DeepEqual(got[key], val) {
+				t.Errorf("%s: got %v, want %v", key, got[key], val)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d keys, want %d", len(got), len(want))
+		}
+
+		if aux["redis-ver"] != redisVersion || aux["redis-bits"] != strconv.Itoa(strconv.IntSize) ||
+			aux["aof-base"] != "0" {
+			t.Fatalf("aux %v", aux)
+		}
+		if !reflect.DeepEqual(functions, []string{"#!lua name=lib1", "#!lua name=lib2"}) {
+			t.Fatalf("functions %v", functions)
+		}
+
+		// The RESIZEDB hints sized the hash tables, the load didn't
+		// rehash.
+		for id := 0; id < loaded.Len(); id++ {
+			if d, _ := loaded.Select(id); d.IsRehashing() {
+				t.Fatalf("db %d is rehashing", id)
+			}
+		}
+		d, _ := loaded.Select(3)
+		if d.Size() != 1000 || d.ExpiresSize() != 500 {
+			t.Fatalf("size %d expires %d", d.Size(), d.ExpiresSize())
+		}
+	}
+
+	// The compression saves space.
+	if len(save(t, dbs)) >= len(save(t, dbs, WithCompression(false))) {
+		t.Fatal("not compressed")
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	data := save(t, db.Create())
+	if string(data[:9]) != "REDIS0011" || data[len(data)-9] != OpcodeEOF {
+		t.Fatalf("%q", data)
+	}
+	if _, err := load(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	dbs := db.Create()
+	d, _ := dbs.Select(0)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	add(d, "a", object.CreateString([]byte("1")))
+	add(d, "b", object.CreateString([]byte("2")))
+	add(d, "c", object.CreateString([]byte("3")))
+	d.SetExpire([]byte("a"), now+1000)
+	d.SetExpire([]byte("b"), now+3000)
+	data := save(t, dbs)
+
+	clock := time.Unix(0, (now+2000)*int64(time.Millisecond))
+	loaded, err := load(data, WithClock(func() time.Time { return clock }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ = loaded.Select(0)
+	if d.Size() != 2 || d.Exists([]byte("a")) || d.GetExpire([]byte("b")) != now+3000 ||
+		d.GetExpire([]byte("c")) != -1 {
+		t.Fatalf("size %d", d.Size())
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	dbs := db.Create()
+	d, _ := dbs.Select(0)
+	add(d, "key", object.CreateString([]byte("value")))
+
+	data := save(t, dbs)
+	if crc := data[len(data)-8:]; bytes.Equal(crc, make([]byte, 8)) {
+		t.Fatal("no checksum")
+	}
+	corrupt := append([]byte{}, data...)
+	corrupt[bytes.Index(corrupt, []byte("value"))] = 'V'
+	if _, err := load(corrupt); err != ErrChecksum {
+		t.Fatal(err)
+	}
+	corrupt = append([]byte{}, data...)
+	corrupt[len(corrupt)-1]++
+	if _, err := load(corrupt); err != ErrChecksum {
+		t.Fatal(err)
+	}
+
+	// A zero checksum is not verified.
+	data = save(t, dbs, WithChecksum(false))
+	if crc := data[len(data)-8:]; !bytes.Equal(crc, make([]byte, 8)) {
+		t.Fatalf("checksum %x", crc)
+	}
+	data[bytes.Index(data, []byte("value"))] = 'V'
+	loaded, err := load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ = loaded.Select(0)
+	if v := d.Lookup([]byte("key")); v == nil || string(object.StringBytes(v)) != "Value" {
+		t.Fatal(v)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dbs := db.Create()
+	fillDB(t, dbs)
+	data := save(t, dbs)
+
+	// Every truncation of the file fails.
+	for _, n := range []int{0, 5, 9, 20, 100, 1000, len(data) / 2, len(data) - 9, len(data) - 1} {
+		if _, err := load(data[:n]); err != io.ErrUnexpectedEOF {
+			t.Errorf("truncated at %d: %v", n, err)
+		}
+	}
+
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte("RADIS0011\xff"), ErrSignature},
+		{[]byte("REDIS0012\xff"), ErrVersion},
+		{[]byte("REDIS0000\xff"), ErrVersion},
+		{[]byte("REDIS00a1\xff"), ErrVersion},
+		// Old versions have no checksum.
+		{[]byte("REDIS0004\xff"), nil},
+		{[]byte("REDIS0011\xfe\x10"), db.ErrDBIndex},
+		{[]byte("REDIS0011\x08"), ErrUnknownType},
+		{[]byte("REDIS0011\xf0"), ErrUnknownType},
+		{[]byte("REDIS0011\x09\x01k\x00"), ErrUnsupported},
+		{[]byte("REDIS0011\x07\x01k\x00"), ErrUnsupported},
+		{[]byte("REDIS0011\xf7"), ErrUnsupported},
+		{[]byte("REDIS0011\x00\xc5"), ErrCorrupt},
+		{[]byte("REDIS0011\x00\x01k\xc3\x00\x01"), ErrCorrupt},
+		{[]byte("REDIS0011\x00\x01k\xc3\x02\x04\x01ab"), ErrCorrupt},
+		{[]byte("REDIS0011\x00\x01k\x01v\x00\x01k\x01v"), ErrDuplicateKey},
+		{[]byte("REDIS0011\x02\x01k\x02\x01a\x01a"), ErrCorrupt},
+		{[]byte("REDIS0011\x04\x01k\x02\x01a\x01b\x01a\x01c"), ErrCorrupt},
+		{[]byte("REDIS0011\x10\x01k\x03abc"), ErrCorrupt},
+		{[]byte("REDIS0011\x12\x01k\x01\x03"), ErrCorrupt},
+	}
+	for i, test := range tests {
+		data := append(append([]byte{}, test.data...), make([]byte, 8)...)
+		if _, err := load(data); err != test.err {
+			t.Errorf("test %d: %v, want %v", i, err, test.err)
+		}
+	}
+
+	// A zset with a NaN score.
+	var buf bytes.Buffer
+	e := CreateEncoder(&buf, WithChecksum(false))
+	e.write([]byte("REDIS0011"))
+	e.saveType(TypeZset2)
+	e.saveString([]byte("k"))
+	e.saveLen(1)
+	e.saveString([]byte("m"))
+	e.saveBinaryDouble(math.NaN())
+	e.saveType(OpcodeEOF)
+	e.write(make([]byte, 8))
+	e.w.Flush()
+	if _, err := load(buf.Bytes()); err != ErrCorrupt {
+		t.Fatal(err)
+	}
+
+	// The keys can't be loaded twice.
+	loaded, _ := load(data)
+	if err := CreateDecoder(bytes.NewReader(data)).Load(loaded); err != ErrDuplicateKey {
+		t.Fatal(err)
+	}
+}
+
+// TestLegacyEncodings Load the encodings of the older RDB versions, that
+// are not saved anymore.
+func TestLegacyEncodings(t *testing.T) {
+	var buf bytes.Buffer
+	e := CreateEncoder(&buf, WithCompression(false))
+	e.write([]byte("REDIS0009"))
+	e.saveAuxField([]byte("redis-ver"), []byte("5.0.0"))
+	e.saveType(OpcodeSelectDB)
+	e.saveLen(1)
+	e.saveType(OpcodeResizeDB)
+	e.saveLen(11)
+	e.saveLen(1)
+
+	keyValue := func(typ byte, key string) {
+		e.saveType(typ)
+		e.saveString([]byte(key))
+	}
+	ziplistBlob := func(elems ...string) []byte {
+		zl := ziplist.Create()
+		for _, el := range elems {
+			zl.Push([]byte(el), ziplist.Tail)
+		}
+		return zl.Bytes()
+	}
+	listpackBlob := func(elems ...string) []byte {
+		lp := listpack.Create()
+		for _, el := range elems {
+			lp.Append([]byte(el))
+		}
+		return lp.Bytes()
+	}
+
+	// A string with the old expire time in seconds, the LRU and LFU
+	// info.
+	e.saveType(OpcodeExpiretime)
+	e.write([]byte{0xff, 0xff, 0xff, 0x7f})
+	e.saveType(OpcodeIdle)
+	e.saveLen(100)
+	e.saveType(OpcodeFreq)
+	e.write([]byte{5})
+	keyValue(TypeString, "string")
+	e.saveString([]byte("value"))
+
+	keyValue(TypeList, "list")
+	e.saveLen(3)
+	for _, el := range []string{"a", "12", "c"} {
+		e.saveString([]byte(el))
+	}
+	keyValue(TypeListZiplist, "ziplist")
+	e.saveString(ziplistBlob("x", "-5", "y"))
+	keyValue(TypeListQuicklist, "quicklist")
+	e.saveLen(3)
+	e.saveString(ziplistBlob("1", "2"))
+	e.saveString(ziplistBlob())
+	e.saveString(ziplistBlob("three"))
+
+	keyValue(TypeSet, "set")
+	e.saveLen(2)
+	e.saveString([]byte("m1"))
+	e.saveString([]byte("m2"))
+	is := intset.Create()
+	is.Add(70000)
+	is.Add(-3)
+	keyValue(TypeSetIntset, "intset")
+	e.saveString(is.Bytes())
+
+	keyValue(TypeZset, "zset")
+	e.saveLen(4)
+	for _, m := range []string{"a", "b", "c", "d"} {
+		e.saveString([]byte(m))
+		switch m {
+		case "a":
+			e.write([]byte{doubleNegInf})
+		case "b":
+			e.write([]byte{doublePosInf})
+		default:
+			s := strconv.FormatFloat(float64(len(m))/4+float64(m[0]), 'g', 17, 64)
+			e.write([]byte{byte(len(s))})
+			e.write([]byte(s))
+		}
+	}
+	keyValue(TypeZsetZiplist, "zsetziplist")
+	e.saveString(ziplistBlob("a", "2", "b", "1.5"))
+
+	keyValue(TypeHash, "hash")
+	e.saveLen(2)
+	for _, s := range []string{"f1", "v1", "f2", "v2"} {
+		e.saveString([]byte(s))
+	}
+	keyValue(TypeHashZiplist, "hashziplist")
+	e.saveString(ziplistBlob("f", "1"))
+
+	// The empty aggregates are skipped.
+	keyValue(TypeSetListpack, "emptyset")
+	e.saveString(listpackBlob())
+	keyValue(TypeHash, "emptyhash")
+	e.saveLen(0)
+
+	e.saveType(OpcodeEOF)
+	e.write(make([]byte, 8))
+	e.w.Flush()
+
+	aux := make(map[string]string)
+	loaded, err := load(buf.Bytes(), WithAuxHandler(func(key, value []byte) {
+		aux[string(key)] = string(value)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aux["redis-ver"] != "5.0.0" {
+		t.Fatal(aux)
+	}
+
+	got := dump(loaded)
+	want := map[string][]interface{}{
+		"1:string":      {uint8(object.TypeString), uint8(object.EncodingEmbstr), "value", int64(math.MaxInt32) * 1000},
+		"1:list":        {uint8(object.TypeList), uint8(object.EncodingListpack), strs("a", "12", "c"), int64(-1)},
+		"1:ziplist":     {uint8(object.TypeList), uint8(object.EncodingListpack), strs("x", "-5", "y"), int64(-1)},
+		"1:quicklist":   {uint8(object.TypeList), uint8(object.EncodingListpack), strs("1", "2", "three"), int64(-1)},
+		"1:set":         {uint8(object.TypeSet), uint8(object.EncodingListpack), strs("m1", "m2"), int64(-1)},
+		"1:intset":      {uint8(object.TypeSet), uint8(object.EncodingIntset), strs("-3", "70000"), int64(-1)},
+		"1:hash":        {uint8(object.TypeHash), uint8(object.EncodingListpack), []string{"f1=v1", "f2=v2"}, int64(-1)},
+		"1:hashziplist": {uint8(object.TypeHash), uint8(object.EncodingListpack), []string{"f=1"}, int64(-1)},
+		"1:zset": {uint8(object.TypeZset), got["1:zset"][1], []zset.ScoreMember{
+			{Score: math.Inf(-1), Member: []byte("a")},
+			{Score: 99.25, Member: []byte("c")},
+			{Score: 100.25, Member: []byte("d")},
+			{Score: math.Inf(1), Member: []byte("b")},
+		}, int64(-1)},
+		"1:zsetziplist": {uint8(object.TypeZset), got["1:zsetziplist"][1], []zset.ScoreMember{
+			{Score: 1.5, Member: []byte("b")},
+			{Score: 2, Member: []byte("a")},
+		}, int64(-1)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d keys: %v", len(got), got)
+	}
+	for key, val := range want {
+		if !reflect.DeepEqual(got[key], val) {
+			t.Errorf("%s: got %v, want %v", key, got[key], val)
+		}
+	}
+	d, _ := loaded.Select(1)
+	if zs := d.Lookup([]byte("zset")).Ptr().(*zset.Zset); zs.Encoding() != zset.EncodingListpack {
+		t.Fatal(zs.Encoding())
+	}
+}
+
+// TestStreamVersions Load the streams of the older type encodings.
+func TestStreamVersions(t *testing.T) {
+	o := createStream(t, 25)
+	s := o.Ptr().(*stream.Stream)
+	for _, typ := range []byte{TypeStreamListpacks, TypeStreamListpacks2} {
+		var buf bytes.Buffer
+		e := CreateEncoder(&buf)
+		e.write([]byte("REDIS0009"))
+		e.saveType(typ)
+		e.saveString([]byte("s"))
+		// Rewrite the stream without the fields of the newer types.
+		var nodes [][2][]byte
+		s.Nodes(func(master stream.ID, lp *listpack.Listpack) {
+			nodes = append(nodes, [2][]byte{encodeStreamID(master), lp.Bytes()})
+		})
+		e.saveLen(uint64(len(nodes)))
+		for _, n := range nodes {
+			e.saveString(n[0])
+			e.saveString(n[1])
+		}
+		e.saveLen(s.Len())
+		e.saveLen(s.LastID().Ms)
+		e.saveLen(s.LastID().Seq)
+		if typ == TypeStreamListpacks2 {
+			for i := 0; i < 4; i++ {
+				e.saveLen(0)
+			}
+			e.saveLen(s.EntriesAdded())
+		}
+		groups := s.GroupStates()
+		e.saveLen(uint64(len(groups)))
+		for _, g := range groups {
+			e.saveString([]byte(g.Name))
+			e.saveLen(g.LastID.Ms)
+			e.saveLen(g.LastID.Seq)
+			if typ == TypeStreamListpacks2 {
+				e.saveLen(0)
+			}
+			e.saveLen(uint64(len(g.Pending)))
+			for _, p := range g.Pending {
+				e.saveStreamID(p.ID)
+				e.saveMillisecondTime(p.DeliveryTime)
+				e.saveLen(p.DeliveryCount)
+			}
+			e.saveLen(uint64(len(g.Consumers)))
+			for _, c := range g.Consumers {
+				e.saveString([]byte(c.Name))
+				e.saveMillisecondTime(c.SeenTime)
+				e.saveLen(uint64(len(c.Pending)))
+				for _, id := range c.Pending {
+					e.saveStreamID(id)
+				}
+			}
+		}
+		e.saveType(OpcodeEOF)
+		var crc [8]byte
+		binary.LittleEndian.PutUint64(crc[:], e.crc)
+		e.w.Write(crc[:])
+		e.w.Flush()
+
+		loaded, err := load(buf.Bytes())
+		if err != nil {
+			t.Fatalf("type %d: %v", typ, err)
+		}
+		d, _ := loaded.Select(0)
+		ls := d.Lookup([]byte("s")).Ptr().(*stream.Stream)
+		if !reflect.DeepEqual(ls.Range(stream.ID{}, stream.MaxID, 0, false), s.Range(stream.ID{}, stream.MaxID, 0, false)) ||
+			ls.LastID() != s.LastID() || ls.EntriesAdded() != s.EntriesAdded() {
+			t.Fatalf("type %d: stream differs", typ)
+		}
+		// The active time of the consumers is their seen time.
+		lg := ls.GroupStates()
+		for i := range groups {
+			for j := range groups[i].Consumers {
+				groups[i].Consumers[j].ActiveTime = groups[i].Consumers[j].SeenTime
+			}
+		}
+		if !reflect.DeepEqual(lg, groups) {
+			t.Fatalf("type %d: groups %+v, want %+v", typ, lg, groups)
+		}
+	}
+}
+
+// TestTrailingData The reader can be read after the RDB file, like an AOF
+// with an RDB preamble.
+func TestTrailingData(t *testing.T) {
+	dbs := db.Create()
+	d, _ := dbs.Select(0)
+	add(d, "key", object.CreateString([]byte("value")))
+	data := append(save(t, dbs), "*1\r\n$4\r\nPING\r\n"...)
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	if err := CreateDecoder(r).Load(db.Create()); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := ioutil.ReadAll(r)
+	if string(rest) != "*1\r\n$4\r\nPING\r\n" {
+		t.Fatalf("%q", rest)
+	}
+}
@@ -0,0 +1,602 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"adlist"
+	"db"
+	"dict"
+	"intset"
+	"listpack"
+	"lzf"
+	"object"
+	"quicklist"
+	"sds"
+	"stream"
+	"util"
+	"zset"
+)
+
+// Encoder writes the databases in the RDB format.
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+
+	compression bool
+	checksum    bool
+	aux         [][2]string
+	functions   [][]byte
+}
+
+// EncoderOption opt.
+type EncoderOption func(e *Encoder)
+
+// WithCompression Compress the strings with LZF, like rdbcompression.
+// Enabled by default.
+func WithCompression(compression bool) EncoderOption {
+	return func(e *Encoder) {
+		e.compression = compression
+	}
+}
+
+// WithChecksum Save the CRC64 checksum of the file, like rdbchecksum,
+// otherwise a zero checksum is saved, that is not verified on load.
+// Enabled by default.
+func WithChecksum(checksum bool) EncoderOption {
+	return func(e *Encoder) {
+		e.checksum = checksum
+	}
+}
+
+// WithAuxField Save the aux field after the default ones, like
+// "aof-base".
+func WithAuxField(key, value string) EncoderOption {
+	return func(e *Encoder) {
+		e.aux = append(e.aux, [2]string{key, value})
+	}
+}
+
+// WithFunctions Save the code of the function libraries.
+func WithFunctions(code ...[]byte) EncoderOption {
+	return func(e *Encoder) {
+		e.functions = append(e.functions, code...)
+	}
+}
+
+// CreateEncoder Create an encoder writing to 'w'.
+func CreateEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{
+		w:           bufio.NewWriter(w),
+		compression: true,
+		checksum:    true,
+	}
+
+	for _, o := range opts {
+		o(e)
+	}
+	return e
+}
+
+// write Write the bytes, updating the checksum.
+func (e *Encoder) write(p []byte) error {
+	if e.checksum {
+		e.crc = util.Crc64(e.crc, p)
+	}
+	_, err := e.w.Write(p)
+	return err
+}
+
+// saveType Save the type of the following object, or an opcode.
+func (e *Encoder) saveType(typ byte) error {
+	return e.write([]byte{typ})
+}
+
+// saveMillisecondTime Save a time in milliseconds, as a little endian
+// 64 bit integer.
+func (e *Encoder) saveMillisecondTime(t int64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(t))
+	return e.write(buf[:])
+}
+
+// saveLen Saves an encoded length. The first two bits in the first byte
+// are used to hold the encoding type. See the len* definitions for more
+// information on the types of encoding.
+func (e *Encoder) saveLen(l uint64) error {
+	var buf [9]byte
+	switch {
+	case l < 1<<6:
+		// Save a 6 bit len
+		buf[0] = byte(l) | len6Bit<<6
+		return e.write(buf[:1])
+	case l < 1<<14:
+		// Save a 14 bit len
+		buf[0] = byte(l>>8) | len14Bit<<6
+		buf[1] = byte(l)
+		return e.write(buf[:2])
+	case l <= math.MaxUint32:
+		// Save a 32 bit len
+		buf[0] = len32Bit
+		binary.BigEndian.PutUint32(buf[1:], uint32(l))
+		return e.write(buf[:5])
+	default:
+		// Save a 64 bit len
+		buf[0] = len64Bit
+		binary.BigEndian.PutUint64(buf[1:], l)
+		return e.write(buf[:9])
+	}
+}
+
+// encodeInteger Encodes the "value" argument as integer when it fits in
+// the supported ranges for encoded types. Returns nil if the value can't
+// be encoded.
+func encodeInteger(value int64) []byte {
+	switch {
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		return []byte{encVal<<6 | encInt8, byte(value)}
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		return []byte{encVal<<6 | encInt16, byte(value), byte(value >> 8)}
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		return []byte{encVal<<6 | encInt32, byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)}
+	}
+	return nil
+}
+
+// tryIntegerEncoding String objects in the form "2391" "-100" without
+// any space and with a range of values that can fit in an 8, 16 or 32
+// bit signed value can be encoded as integers to save space.
+func tryIntegerEncoding(s []byte) []byte {
+	value, ok := util.String2ll(s)
+	if !ok {
+		return nil
+	}
+	// If the number converted back into a string is not identical
+	// then it's not possible to encode the string as integer
+	if strconv.FormatInt(value, 10) != string(s) {
+		return nil
+	}
+	return encodeInteger(value)
+}
+
+// saveLzfBlob Save an LZF compressed string, 'origLen' being the length
+// of the uncompressed data.
+func (e *Encoder) saveLzfBlob(data []byte, origLen int) error {
+	// Data compressed! Let's save it on disk
+	if err := e.write([]byte{encVal<<6 | encLZF}); err != nil {
+		return err
+	}
+	if err := e.saveLen(uint64(len(data))); err != nil {
+		return err
+	}
+	if err := e.saveLen(uint64(origLen)); err != nil {
+		return err
+	}
+	return e.write(data)
+}
+
+// saveString Save a string, trying the integer encoding of the short
+// strings and the LZF compression of the long ones.
+func (e *Encoder) saveString(s []byte) error {
+	// Try integer encoding
+	if len(s) <= 11 {
+		if enc := tryIntegerEncoding(s); enc != nil {
+			return e.write(enc)
+		}
+	}
+
+	// Try LZF compression - under 20 bytes it's unable to compress even
+	// aaaaaaaaaaaaaaaaaa so skip it
+	if e.compression && len(s) > 20 {
+		// We require at least four bytes compression for this to be
+		// worth it
+		if c := lzf.Compress(s); len(c) <= len(s)-4 {
+			return e.saveLzfBlob(c, len(s))
+		}
+	}
+
+	// Store verbatim
+	if err := e.saveLen(uint64(len(s))); err != nil {
+		return err
+	}
+	return e.write(s)
+}
+
+// saveBinaryDouble Saves a double as its little endian binary
+// representation.
+func (e *Encoder) saveBinaryDouble(v float64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return e.write(buf[:])
+}
+
+// saveStreamID Save a stream ID as a 128 bit big endian number, not as a
+// string.
+func (e *Encoder) saveStreamID(id stream.ID) error {
+	return e.write(encodeStreamID(id))
+}
+
+// encodeStreamID Convert a stream ID into a 128 bit big endian number.
+func encodeStreamID(id stream.ID) []byte {
+	buf := make([]byte, streamIDLen)
+	binary.BigEndian.PutUint64(buf, id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+// objectType Return the RDB type of the object.
+func objectType(o *object.Object) byte {
+	switch o.Type() {
+	case object.TypeString:
+		return TypeString
+	case object.TypeList:
+		if o.Encoding() == object.EncodingLinkedlist {
+			return TypeList
+		}
+		return TypeListQuicklist2
+	case object.TypeSet:
+		switch o.Encoding() {
+		case object.EncodingIntset:
+			return TypeSetIntset
+		case object.EncodingListpack:
+			return TypeSetListpack
+		}
+		return TypeSet
+	case object.TypeZset:
+		if o.Ptr().(*zset.Zset).Encoding() == zset.EncodingListpack {
+			return TypeZsetListpack
+		}
+		return TypeZset2
+	case object.TypeHash:
+		if o.Encoding() == object.EncodingListpack {
+			return TypeHashListpack
+		}
+		return TypeHash
+	case object.TypeStream:
+		return TypeStreamListpacks3
+	}
+	panic("rdb: unknown object type")
+}
+
+// saveObject Save a Redis object.
+func (e *Encoder) saveObject(o *object.Object) error {
+	switch ptr := o.Ptr().(type) {
+	case *listpack.Listpack:
+		if o.Type() == object.TypeList {
+			// A listpack encoded list is saved as a quicklist of a
+			// single node.
+			if err := e.saveLen(1); err != nil {
+				return err
+			}
+			if err := e.saveLen(quicklistNodeContainerPacked); err != nil {
+				return err
+			}
+		}
+		return e.saveString(ptr.Bytes())
+	case *intset.Intset:
+		return e.saveString(ptr.Bytes())
+	case *quicklist.Quicklist:
+		return e.saveQuicklist(ptr)
+	case *adlist.List:
+		if err := e.saveLen(uint64(ptr.Len())); err != nil {
+			return err
+		}
+		for _, v := range object.ListRange(o, 0, -1) {
+			if err := e.saveString(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *dict.Dict:
+		return e.saveDict(o, ptr)
+	case *zset.Zset:
+		return e.saveZset(ptr)
+	case *stream.Stream:
+		return e.saveStream(ptr)
+	}
+	// A string object.
+	return e.saveString(object.StringBytes(o))
+}
+
+// saveQuicklist Save the listpacks of the nodes of the quicklist.
+func (e *Encoder) saveQuicklist(ql *quicklist.Quicklist) error {
+	if err := e.saveLen(uint64(ql.Len())); err != nil {
+		return err
+	}
+	for node := ql.Head(); node != nil; node = node.Next() {
+		if err := e.saveLen(quicklistNodeContainerPacked); err != nil {
+			return err
+		}
+		if err := e.saveString(node.Listpack().Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDict Save the members of a set, or the fields and values of a
+// hash, encoded as a hash table.
+func (e *Encoder) saveDict(o *object.Object, d *dict.Dict) error {
+	if err := e.saveLen(d.Size()); err != nil {
+		return err
+	}
+	it := d.GetIterator()
+	defer it.Release()
+	for de := it.Next(); de != nil; de = it.Next() {
+		if err := e.saveString(de.Key().(*sds.Key).SDS().Bytes()); err != nil {
+			return err
+		}
+		if o.Type() == object.TypeHash {
+			if err := e.saveString(de.Value().(*sds.Value).SDS().Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveZset Save a zset, the listpack as it is, or the elements of the
+// skiplist.
+func (e *Encoder) saveZset(zs *zset.Zset) error {
+	if lp := zs.Listpack(); lp != nil {
+		return e.saveString(lp.Bytes())
+	}
+
+	// We save the skiplist elements from the greatest to the smallest
+	// (that's trivial since the elements are already ordered in the
+	// skiplist): this improves the load process, since the next loaded
+	// element will always be the smaller, so adding to the skiplist will
+	// always immediately stop at the head, making the insertion O(1)
+	// instead of O(log(N)).
+	elements := zs.RangeByRank(0, -1, true)
+	if err := e.saveLen(uint64(len(elements))); err != nil {
+		return err
+	}
+	for _, el := range elements {
+		if err := e.saveString(el.Member); err != nil {
+			return err
+		}
+		if err := e.saveBinaryDouble(el.Score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveStream Save the nodes of the stream, its metadata, and the
+// consumer groups.
+func (e *Encoder) saveStream(s *stream.Stream) error {
+	var nodes []stream.ID
+	var lps []*listpack.Listpack
+	s.Nodes(func(master stream.ID, lp *listpack.Listpack) {
+		nodes = append(nodes, master)
+		lps = append(lps, lp)
+	})
+	if err := e.saveLen(uint64(len(nodes))); err != nil {
+		return err
+	}
+	for i, master := range nodes {
+		if err := e.saveString(encodeStreamID(master)); err != nil {
+			return err
+		}
+		if err := e.saveString(lps[i].Bytes()); err != nil {
+			return err
+		}
+	}
+
+	// Save the metadata: the number of elements, the last entry ID, the
+	// first entry ID, the max deleted entry ID (not tracked, saved as
+	// 0-0), and the number of entries ever added.
+	firstID, _ := s.FirstID()
+	lastID := s.LastID()
+	meta := []uint64{
+		s.Len(),
+		lastID.Ms, lastID.Seq,
+		firstID.Ms, firstID.Seq,
+		0, 0,
+		s.EntriesAdded(),
+	}
+	for _, l := range meta {
+		if err := e.saveLen(l); err != nil {
+			return err
+		}
+	}
+
+	// The consumer groups, with their pending entries and consumers.
+	groups := s.GroupStates()
+	if err := e.saveLen(uint64(len(groups))); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := e.saveString([]byte(g.Name)); err != nil {
+			return err
+		}
+		if err := e.saveLen(g.LastID.Ms); err != nil {
+			return err
+		}
+		if err := e.saveLen(g.LastID.Seq); err != nil {
+			return err
+		}
+		// The read counter of the group is not tracked, saved as
+		// invalid.
+		if err := e.saveLen(math.MaxUint64); err != nil {
+			return err
+		}
+
+		// Save the global PEL.
+		if err := e.saveLen(uint64(len(g.Pending))); err != nil {
+			return err
+		}
+		for _, p := range g.Pending {
+			if err := e.saveStreamID(p.ID); err != nil {
+				return err
+			}
+			if err := e.saveMillisecondTime(p.DeliveryTime); err != nil {
+				return err
+			}
+			if err := e.saveLen(p.DeliveryCount); err != nil {
+				return err
+			}
+		}
+
+		// Save the consumers of this group.
+		if err := e.saveLen(uint64(len(g.Consumers))); err != nil {
+			return err
+		}
+		for _, c := range g.Consumers {
+			if err := e.saveString([]byte(c.Name)); err != nil {
+				return err
+			}
+			if err := e.saveMillisecondTime(c.SeenTime); err != nil {
+				return err
+			}
+			if err := e.saveMillisecondTime(c.ActiveTime); err != nil {
+				return err
+			}
+			// Consumer PEL, without the NACKs, that are in the global
+			// PEL.
+			if err := e.saveLen(uint64(len(c.Pending))); err != nil {
+				return err
+			}
+			for _, id := range c.Pending {
+				if err := e.saveStreamID(id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// saveKeyValuePair Save a key-value pair, with the expire time (-1 if
+// none).
+func (e *Encoder) saveKeyValuePair(key []byte, val *object.Object, expire int64) error {
+	// Save the expire time
+	if expire != -1 {
+		if err := e.saveType(OpcodeExpiretimeMs); err != nil {
+			return err
+		}
+		if err := e.saveMillisecondTime(expire); err != nil {
+			return err
+		}
+	}
+
+	// Save type, key, value
+	if err := e.saveType(objectType(val)); err != nil {
+		return err
+	}
+	if err := e.saveString(key); err != nil {
+		return err
+	}
+	return e.saveObject(val)
+}
+
+// saveAuxField Save an AUX field.
+func (e *Encoder) saveAuxField(key, val []byte) error {
+	if err := e.saveType(OpcodeAux); err != nil {
+		return err
+	}
+	if err := e.saveString(key); err != nil {
+		return err
+	}
+	return e.saveString(val)
+}
+
+// saveInfoAuxFields Save a few default AUX fields with information about
+// the RDB generated, and the ones of the options.
+func (e *Encoder) saveInfoAuxFields() error {
+	aux := [][2]string{
+		{"redis-ver", redisVersion},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	for _, field := range append(aux, e.aux...) {
+		if err := e.saveAuxField([]byte(field[0]), []byte(field[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDB Save the keys of the database, preceded by its number and the
+// sizes of its hash tables.
+func (e *Encoder) saveDB(d *db.DB) error {
+	if d.Size() == 0 {
+		return nil
+	}
+
+	// Write the SELECT DB opcode
+	if err := e.saveType(OpcodeSelectDB); err != nil {
+		return err
+	}
+	if err := e.saveLen(uint64(d.ID())); err != nil {
+		return err
+	}
+
+	// Write the RESIZE DB opcode.
+	if err := e.saveType(OpcodeResizeDB); err != nil {
+		return err
+	}
+	if err := e.saveLen(d.Size()); err != nil {
+		return err
+	}
+	if err := e.saveLen(d.ExpiresSize()); err != nil {
+		return err
+	}
+
+	// Iterate this DB writing every entry
+	var err error
+	d.Each(func(key []byte, val *object.Object, expire int64) bool {
+		err = e.saveKeyValuePair(key, val, expire)
+		return err == nil
+	})
+	return err
+}
+
+// Save Produce a dump of the databases in RDB format: the header, the
+// aux fields, the functions, the keys of every database, the EOF opcode
+// and the checksum.
+func (e *Encoder) Save(dbs *db.Server) error {
+	e.crc = 0
+	magic := fmt.Sprintf("REDIS%04d", Version)
+	if err := e.write([]byte(magic)); err != nil {
+		return err
+	}
+	if err := e.saveInfoAuxFields(); err != nil {
+		return err
+	}
+	for _, code := range e.functions {
+		if err := e.saveType(OpcodeFunction2); err != nil {
+			return err
+		}
+		if err := e.saveString(code); err != nil {
+			return err
+		}
+	}
+
+	for id := 0; id < dbs.Len(); id++ {
+		d, _ := dbs.Select(id)
+		if err := e.saveDB(d); err != nil {
+			return err
+		}
+	}
+
+	// EOF opcode
+	if err := e.saveType(OpcodeEOF); err != nil {
+		return err
+	}
+
+	// CRC64 checksum. It will be zero if checksum computation is
+	// disabled, the loading code skips the check in this case.
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], e.crc)
+	if _, err := e.w.Write(buf[:]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
@@ -0,0 +1,14 @@
+module resp
+
+go 1.14
+
+require (
+	sds v0.0.0
+	util v0.0.0
+)
+
+replace (
+	dict => ../dict
+	sds => ../sds
+	util => ../util
+)
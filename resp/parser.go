@@ -0,0 +1,327 @@
+package resp
+
+// The RESP request parser, a port of the query buffer processing of
+// networking.c of redis.
+//
+// The requests are either multibulk, an array of bulk strings:
+//
+// *3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n
+//
+// or inline, a line of space separated arguments with quotes support as
+// typed in telnet:
+//
+// SET foo "bar baz"\r\n
+//
+// The data is fed as it is read from the connection, and the parser
+// returns the commands as soon as they are complete.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+
+	"sds"
+	"util"
+)
+
+// Error
+var (
+	// ErrTooBigInline the inline request has no newline within the limit.
+	ErrTooBigInline = errors.New("Protocol error: too big inline request")
+	// ErrUnbalancedQuotes the inline request has unbalanced quotes.
+	ErrUnbalancedQuotes = errors.New("Protocol error: unbalanced quotes in request")
+	// ErrTooBigMbulkCount the multibulk count has no newline within the
+	// limit.
+	ErrTooBigMbulkCount = errors.New("Protocol error: too big mbulk count string")
+	// ErrInvalidMultibulkLen the multibulk count is not valid or too big.
+	ErrInvalidMultibulkLen = errors.New("Protocol error: invalid multibulk length")
+	// ErrTooBigBulkCount the bulk length has no newline within the limit.
+	ErrTooBigBulkCount = errors.New("Protocol error: too big bulk count string")
+	// ErrExpectedBulk a bulk string was expected.
+	ErrExpectedBulk = errors.New("Protocol error: expected '$'")
+	// ErrInvalidBulkLen the bulk length is not valid or too big.
+	ErrInvalidBulkLen = errors.New("Protocol error: invalid bulk length")
+)
+
+const (
+	// InlineMaxSize Max size of inline reads, and of the length lines of
+	// the multibulk requests.
+	InlineMaxSize = 1024 * 64
+	// MaxBulkLen Default max length of a bulk string, like
+	// proto-max-bulk-len.
+	MaxBulkLen = 512 * 1024 * 1024
+	// MaxMultibulkLen Default max number of arguments of a multibulk
+	// request.
+	MaxMultibulkLen = math.MaxInt32
+)
+
+// Request types.
+const (
+	reqNone = iota
+	reqInline
+	reqMultibulk
+)
+
+// Parser RESP request parser.
+type Parser struct {
+	// Buffer we use to accumulate client queries.
+	querybuf []byte
+	// The position we have read in querybuf.
+	qbPos int
+
+	// Request protocol type.
+	reqtype int
+	// Number of multi bulk arguments left to read.
+	multibulklen int64
+	// Length of bulk argument in multi bulk request.
+	bulklen int64
+	argv    [][]byte
+	// The protocol error, the following calls of Next return it.
+	err error
+
+	maxBulkLen      int64
+	maxMultibulkLen int64
+	inlineMaxSize   int
+}
+
+// Option opt.
+type Option func(p *Parser)
+
+// WithMaxBulkLen The max length of a bulk string, MaxBulkLen by default.
+func WithMaxBulkLen(n int64) Option {
+	return func(p *Parser) {
+		p.maxBulkLen = n
+	}
+}
+
+// WithMaxMultibulkLen The max number of arguments of a multibulk request,
+// MaxMultibulkLen by default.
+func WithMaxMultibulkLen(n int64) Option {
+	return func(p *Parser) {
+		p.maxMultibulkLen = n
+	}
+}
+
+// WithInlineMaxSize The max size of an inline request, InlineMaxSize by
+// default.
+func WithInlineMaxSize(n int) Option {
+	return func(p *Parser) {
+		p.inlineMaxSize = n
+	}
+}
+
+// CreateParser Create a new request parser.
+func CreateParser(opts ...Option) *Parser {
+	p := &Parser{
+		bulklen:         -1,
+		maxBulkLen:      MaxBulkLen,
+		maxMultibulkLen: MaxMultibulkLen,
+		inlineMaxSize:   InlineMaxSize,
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+// Feed Append the data read from the connection to the query buffer.
+func (p *Parser) Feed(data []byte) {
+	// Trim the processed part of the query buffer.
+	if p.qbPos > 0 {
+		n := copy(p.querybuf, p.querybuf[p.qbPos:])
+		p.querybuf = p.querybuf[:n]
+		p.qbPos = 0
+	}
+	p.querybuf = append(p.querybuf, data...)
+}
+
+// Buffered Return the number of bytes of the query buffer not processed
+// yet.
+func (p *Parser) Buffered() int {
+	return len(p.querybuf) - p.qbPos
+}
+
+// Next Return the next command from the query buffer, nil if it is not
+// complete yet and more data must be fed. On protocol errors the
+// connection should be closed, Next keeps returning the error.
+func (p *Parser) Next() ([][]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	// Keep processing while there is something in the input buffer
+	for p.qbPos < len(p.querybuf) {
+		// Determine request type when unknown.
+		if p.reqtype == reqNone {
+			if p.querybuf[p.qbPos] == '*' {
+				p.reqtype = reqMultibulk
+			} else {
+				p.reqtype = reqInline
+			}
+		}
+
+		var complete bool
+		if p.reqtype == reqInline {
+			complete, p.err = p.processInlineBuffer()
+		} else {
+			complete, p.err = p.processMultibulkBuffer()
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		if !complete {
+			break
+		}
+
+		// Multibulk processing could see a <= 0 length, and the inline
+		// processing an empty line.
+		argv := p.argv
+		p.reset()
+		if len(argv) > 0 {
+			return argv, nil
+		}
+	}
+	return nil, nil
+}
+
+// reset Prepare the parser to process the next command.
+func (p *Parser) reset() {
+	p.reqtype = reqNone
+	p.multibulklen = 0
+	p.bulklen = -1
+	p.argv = nil
+}
+
+// processInlineBuffer Like processMultibulkBuffer(), but for the inline
+// protocol instead of RESP, this function consumes the client query
+// buffer and creates a command ready to be executed inside the client
+// structure. Returns true if the command is ready to be executed, or
+// false if there is still more buffer to get to build the command.
+func (p *Parser) processInlineBuffer() (bool, error) {
+	// Search for end of line
+	newline := bytes.IndexByte(p.querybuf[p.qbPos:], '\n')
+
+	// Nothing to do without a \r\n
+	if newline == -1 {
+		if len(p.querybuf)-p.qbPos > p.inlineMaxSize {
+			return false, ErrTooBigInline
+		}
+		return false, nil
+	}
+
+	// Handle the \r\n case.
+	linefeedChars := 1
+	if newline != 0 && p.querybuf[p.qbPos+newline-1] == '\r' {
+		newline--
+		linefeedChars++
+	}
+
+	// Split the input buffer up to the \r\n
+	querylen := newline
+	args, ok := sds.SplitArgs(p.querybuf[p.qbPos : p.qbPos+querylen])
+	if !ok {
+		return false, ErrUnbalancedQuotes
+	}
+
+	// Move querybuffer position to the next query in the buffer.
+	p.qbPos += querylen + linefeedChars
+
+	// Setup argv array on client structure
+	p.argv = make([][]byte, len(args))
+	for j, arg := range args {
+		p.argv[j] = arg.Bytes()
+	}
+	return true, nil
+}
+
+// processMultibulkBuffer Process the query buffer for client 'c', setting
+// up the client argument vector for command execution. Returns true if
+// after running the function the client has a well-formed ready to be
+// processed command, otherwise false if there is still to read more
+// buffer to get the full command. Also returns an error when there is a
+// protocol error: in such a case the client should be closed.
+func (p *Parser) processMultibulkBuffer() (bool, error) {
+	if p.multibulklen == 0 {
+		// Multi bulk length cannot be read without a \r\n
+		newline := bytes.IndexByte(p.querybuf[p.qbPos:], '\r')
+		if newline == -1 {
+			if len(p.querybuf)-p.qbPos > p.inlineMaxSize {
+				return false, ErrTooBigMbulkCount
+			}
+			return false, nil
+		}
+
+		// Buffer should also contain \n
+		if newline > len(p.querybuf)-p.qbPos-2 {
+			return false, nil
+		}
+
+		// We know for sure there is a whole line since newline != -1,
+		// so go ahead and find out the multi bulk length.
+		ll, ok := util.String2ll(p.querybuf[p.qbPos+1 : p.qbPos+newline])
+		if !ok || ll > p.maxMultibulkLen {
+			return false, ErrInvalidMultibulkLen
+		}
+
+		p.qbPos += newline + 2
+		if ll <= 0 {
+			return true, nil
+		}
+		p.multibulklen = ll
+
+		// Setup argv array on client structure. Don't trust the count
+		// of arguments to allocate the array.
+		n := ll
+		if n > 1024 {
+			n = 1024
+		}
+		p.argv = make([][]byte, 0, n)
+	}
+
+	for p.multibulklen > 0 {
+		// Read bulk length if unknown
+		if p.bulklen == -1 {
+			newline := bytes.IndexByte(p.querybuf[p.qbPos:], '\r')
+			if newline == -1 {
+				if len(p.querybuf)-p.qbPos > p.inlineMaxSize {
+					return false, ErrTooBigBulkCount
+				}
+				break
+			}
+
+			// Buffer should also contain \n
+			if newline > len(p.querybuf)-p.qbPos-2 {
+				break
+			}
+
+			if c := p.querybuf[p.qbPos]; c != '$' {
+				return false, fmt.Errorf("%w, got '%c'", ErrExpectedBulk, c)
+			}
+
+			ll, ok := util.String2ll(p.querybuf[p.qbPos+1 : p.qbPos+newline])
+			if !ok || ll < 0 || ll > p.maxBulkLen {
+				return false, ErrInvalidBulkLen
+			}
+
+			p.qbPos += newline + 2
+			p.bulklen = ll
+		}
+
+		// Read bulk argument
+		if int64(len(p.querybuf)-p.qbPos) < p.bulklen+2 {
+			// Not enough data (+2 == trailing \r\n)
+			break
+		}
+		arg := make([]byte, p.bulklen)
+		copy(arg, p.querybuf[p.qbPos:])
+		p.argv = append(p.argv, arg)
+		p.qbPos += int(p.bulklen) + 2
+		p.bulklen = -1
+		p.multibulklen--
+	}
+
+	// We're done when multibulklen == 0
+	return p.multibulklen == 0, nil
+}
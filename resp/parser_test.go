@@ -0,0 +1,106 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// parseAll Feed the transcript 'chunk' bytes at a time, and return the
+// commands.
+func parseAll(t *testing.T, p *Parser, transcript string, chunk int) ([][][]byte, error) {
+	t.Helper()
+	var cmds [][][]byte
+	for i := 0; i < len(transcript); i += chunk {
+		end := i + chunk
+		if end > len(transcript) {
+			end = len(transcript)
+		}
+		p.Feed([]byte(transcript[i:end]))
+		for {
+			argv, err := p.Next()
+			if err != nil {
+				return cmds, err
+			}
+			if argv == nil {
+				break
+			}
+			cmds = append(cmds, argv)
+		}
+	}
+	return cmds, nil
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		transcript string
+		cmds       string
+	}{
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n", "[[SET foo bar]]"},
+		{"*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", "[[PING] [ECHO ]]"},
+		{"*2\r\n$3\r\nGET\r\n$5\r\na\r\nb\n\r\n", "[[GET a\r\nb\n]]"},
+		{"*0\r\n*-1\r\n*1\r\n$4\r\nPING\r\n", "[[PING]]"},
+		{"PING\r\nSET foo \"bar baz\"\nGET  foo\r\n", "[[PING] [SET foo bar baz] [GET foo]]"},
+		{"\r\n\n   \r\nPING\r\n", "[[PING]]"},
+		{"PING\r\n*1\r\n$4\r\nPING\r\nPING\r\n", "[[PING] [PING] [PING]]"},
+	}
+	for _, test := range tests {
+		// Every split of the transcript gives the same commands.
+		for chunk := 1; chunk <= len(test.transcript); chunk++ {
+			cmds, err := parseAll(t, CreateParser(), test.transcript, chunk)
+			if err != nil {
+				t.Fatalf("%q: %v", test.transcript, err)
+			}
+			if got := fmt.Sprintf("%s", cmds); got != test.cmds {
+				t.Fatalf("%q chunk %d: %q, want %q", test.transcript, chunk, got, test.cmds)
+			}
+		}
+	}
+}
+
+func TestParserIncomplete(t *testing.T) {
+	p := CreateParser()
+	cmds, err := parseAll(t, p, "*2\r\n$3\r\nGET\r\n$3\r\nfo", 3)
+	if err != nil || len(cmds) != 0 || p.Buffered() != 2 {
+		t.Fatalf("%q %v buffered %d", cmds, err, p.Buffered())
+	}
+	if cmds, err = parseAll(t, p, "o\r\n", 1); err != nil || len(cmds) != 1 || p.Buffered() != 0 {
+		t.Fatalf("%q %v", cmds, err)
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	tests := []struct {
+		transcript string
+		opts       []Option
+		err        error
+	}{
+		{"SET \"foo\r\n", nil, ErrUnbalancedQuotes},
+		{strings.Repeat("a", 100), []Option{WithInlineMaxSize(64)}, ErrTooBigInline},
+		{"*" + strings.Repeat("1", 100), []Option{WithInlineMaxSize(64)}, ErrTooBigMbulkCount},
+		{"*1\r\n$" + strings.Repeat("1", 100), []Option{WithInlineMaxSize(64)}, ErrTooBigBulkCount},
+		{"*x\r\n", nil, ErrInvalidMultibulkLen},
+		{"*11\r\n", []Option{WithMaxMultibulkLen(10)}, ErrInvalidMultibulkLen},
+		{"*1\r\n$-1\r\n", nil, ErrInvalidBulkLen},
+		{"*1\r\n$11\r\n", []Option{WithMaxBulkLen(10)}, ErrInvalidBulkLen},
+		{"*1\r\n+PING\r\n", nil, ErrExpectedBulk},
+	}
+	for _, test := range tests {
+		p := CreateParser(test.opts...)
+		_, err := parseAll(t, p, test.transcript, len(test.transcript))
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%q: %v, want %v", test.transcript, err, test.err)
+		}
+		// The error is sticky.
+		p.Feed([]byte("*1\r\n$4\r\nPING\r\n"))
+		if _, err2 := p.Next(); err2 != err {
+			t.Fatalf("%q: %v", test.transcript, err2)
+		}
+	}
+
+	_, err := parseAll(t, CreateParser(), "*1\r\n+PING\r\n", 1)
+	if err.Error() != "Protocol error: expected '$', got '+'" {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,335 @@
+package resp
+
+// The RESP reply writer, a port of the addReply* functions of
+// networking.c of redis.
+//
+// The replies are buffered, the buffer is sent with WriteTo. RESP3 types
+// are downgraded to RESP2 ones for the RESP2 clients: maps and sets are
+// sent as arrays, doubles, big numbers and verbatim strings as bulk
+// strings, booleans as integers.
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions.
+const (
+	// RESP2 the protocol of redis 2 to 5.
+	RESP2 = 2
+	// RESP3 the protocol introduced by redis 6 with HELLO 3.
+	RESP3 = 3
+)
+
+// Writer RESP reply writer.
+type Writer struct {
+	buf   []byte
+	proto int
+}
+
+// CreateWriter Create a new reply writer for the protocol version
+// 'proto', RESP2 or RESP3.
+func CreateWriter(proto int) *Writer {
+	return &Writer{
+		proto: proto,
+	}
+}
+
+// Proto Return the protocol version.
+func (w *Writer) Proto() int {
+	return w.proto
+}
+
+// SetProto Set the protocol version, like HELLO.
+func (w *Writer) SetProto(proto int) {
+	w.proto = proto
+}
+
+// Bytes Return the buffered replies.
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// Len Return the number of buffered bytes.
+func (w *Writer) Len() int {
+	return len(w.buf)
+}
+
+// Reset Discard the buffered replies.
+func (w *Writer) Reset() {
+	w.buf = w.buf[:0]
+}
+
+// WriteTo implements io.WriterTo: the buffered replies are written to
+// 'dst' and discarded.
+func (w *Writer) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write(w.buf)
+	if n == len(w.buf) {
+		w.Reset()
+	} else {
+		w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	}
+	return int64(n), err
+}
+
+// WriteRaw Add raw protocol to the buffer.
+func (w *Writer) WriteRaw(p []byte) {
+	w.buf = append(w.buf, p...)
+}
+
+// writeLongLongWithPrefix Add a long long as integer reply or bulk len /
+// multi bulk count. Basically this is used to output <prefix><long long>
+// <crlf>.
+func (w *Writer) writeLongLongWithPrefix(prefix byte, ll int64) {
+	w.buf = append(w.buf, prefix)
+	w.buf = strconv.AppendInt(w.buf, ll, 10)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteStatus Add a simple string reply, like "+OK\r\n". The status must
+// not contain newlines.
+func (w *Writer) WriteStatus(status string) {
+	w.buf = append(w.buf, '+')
+	w.buf = append(w.buf, status...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteError Add an error reply. The error code is "ERR" unless the
+// message starts with '-' followed by the code, as in "-WRONGTYPE ...".
+// Newlines are replaced by spaces, to keep the protocol valid.
+func (w *Writer) WriteError(msg string) {
+	// If the string already starts with "-..." then the error code is
+	// provided by the caller. Otherwise we use "-ERR".
+	if len(msg) == 0 || msg[0] != '-' {
+		w.buf = append(w.buf, "-ERR "...)
+	}
+	start := len(w.buf)
+	w.buf = append(w.buf, msg...)
+	for i := start; i < len(w.buf); i++ {
+		if w.buf[i] == '\r' || w.buf[i] == '\n' {
+			w.buf[i] = ' '
+		}
+	}
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteInteger Add an integer reply.
+func (w *Writer) WriteInteger(ll int64) {
+	w.writeLongLongWithPrefix(':', ll)
+}
+
+// WriteBulk Add a bulk string reply.
+func (w *Writer) WriteBulk(p []byte) {
+	w.writeLongLongWithPrefix('$', int64(len(p)))
+	w.buf = append(w.buf, p...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteBulkString Add a bulk string reply from a string.
+func (w *Writer) WriteBulkString(s string) {
+	w.writeLongLongWithPrefix('$', int64(len(s)))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteBulkInteger Add an integer as a bulk string reply.
+func (w *Writer) WriteBulkInteger(ll int64) {
+	w.WriteBulk(strconv.AppendInt(nil, ll, 10))
+}
+
+// WriteNull Add a null reply, a null bulk string in RESP2.
+func (w *Writer) WriteNull() {
+	if w.proto == RESP2 {
+		w.buf = append(w.buf, "$-1\r\n"...)
+	} else {
+		w.buf = append(w.buf, "_\r\n"...)
+	}
+}
+
+// WriteNullArray Add a null array reply, a null reply in RESP3.
+func (w *Writer) WriteNullArray() {
+	if w.proto == RESP2 {
+		w.buf = append(w.buf, "*-1\r\n"...)
+	} else {
+		w.buf = append(w.buf, "_\r\n"...)
+	}
+}
+
+// WriteArrayLen Add the header of an array of 'length' elements.
+func (w *Writer) WriteArrayLen(length int) {
+	w.writeLongLongWithPrefix('*', int64(length))
+}
+
+// WriteMapLen Add the header of a map of 'length' key value pairs, an
+// array of 2*length elements in RESP2.
+func (w *Writer) WriteMapLen(length int) {
+	if w.proto == RESP2 {
+		w.writeLongLongWithPrefix('*', int64(length)*2)
+	} else {
+		w.writeLongLongWithPrefix('%', int64(length))
+	}
+}
+
+// WriteSetLen Add the header of a set of 'length' elements, an array in
+// RESP2.
+func (w *Writer) WriteSetLen(length int) {
+	if w.proto == RESP2 {
+		w.writeLongLongWithPrefix('*', int64(length))
+	} else {
+		w.writeLongLongWithPrefix('~', int64(length))
+	}
+}
+
+// WriteAttributeLen Add the header of an attribute of 'length' key value
+// pairs. Attributes are available only in RESP3.
+func (w *Writer) WriteAttributeLen(length int) {
+	if w.proto == RESP2 {
+		panic("resp: attribute reply in RESP2")
+	}
+	w.writeLongLongWithPrefix('|', int64(length))
+}
+
+// WritePushLen Add the header of a push reply of 'length' elements, an
+// array in RESP2.
+func (w *Writer) WritePushLen(length int) {
+	if w.proto == RESP2 {
+		w.writeLongLongWithPrefix('*', int64(length))
+	} else {
+		w.writeLongLongWithPrefix('>', int64(length))
+	}
+}
+
+// FormatDouble Convert a double to a string representation, as the
+// double replies: integral values are formatted as integers, infinities
+// as "inf" and "-inf", the other values with the shortest representation
+// that round trips.
+func FormatDouble(d float64) string {
+	switch {
+	case math.IsNaN(d):
+		return "nan"
+	case math.IsInf(d, 1):
+		return "inf"
+	case math.IsInf(d, -1):
+		return "-inf"
+	case d == 0:
+		// See: http://en.wikipedia.org/wiki/Signed_zero, "Comparisons".
+		if math.Signbit(d) {
+			return "-0"
+		}
+		return "0"
+	case d < math.MaxInt64 && d > math.MinInt64 && d == math.Trunc(d):
+		return strconv.FormatInt(int64(d), 10)
+	}
+	return strconv.FormatFloat(d, 'g', -1, 64)
+}
+
+// WriteDouble Add a double reply, a bulk string in RESP2.
+func (w *Writer) WriteDouble(d float64) {
+	if w.proto == RESP2 {
+		w.WriteBulkString(FormatDouble(d))
+		return
+	}
+	w.buf = append(w.buf, ',')
+	w.buf = append(w.buf, FormatDouble(d)...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteBool Add a boolean reply, an integer in RESP2.
+func (w *Writer) WriteBool(b bool) {
+	switch {
+	case w.proto == RESP2 && b:
+		w.buf = append(w.buf, ":1\r\n"...)
+	case w.proto == RESP2:
+		w.buf = append(w.buf, ":0\r\n"...)
+	case b:
+		w.buf = append(w.buf, "#t\r\n"...)
+	default:
+		w.buf = append(w.buf, "#f\r\n"...)
+	}
+}
+
+// WriteBigNumber Add a big number reply, 'num' being the decimal
+// representation of the number. A bulk string in RESP2.
+func (w *Writer) WriteBigNumber(num string) {
+	if w.proto == RESP2 {
+		w.WriteBulkString(num)
+		return
+	}
+	w.buf = append(w.buf, '(')
+	w.buf = append(w.buf, num...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteVerbatim Add a verbatim string reply: 'ext' is the three chars
+// format of the string, like "txt" or "mkd". A bulk string in RESP2.
+func (w *Writer) WriteVerbatim(s string, ext string) {
+	if w.proto == RESP2 {
+		w.WriteBulkString(s)
+		return
+	}
+	w.writeLongLongWithPrefix('=', int64(len(s)+4))
+	w.buf = append(w.buf, ext[:3]...)
+	w.buf = append(w.buf, ':')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteHelp Add a help reply: the lines of the help, as status replies
+// in an array, like the HELP subcommands.
+func (w *Writer) WriteHelp(command string, lines ...string) {
+	w.WriteArrayLen(len(lines) + 2)
+	w.WriteStatus(strings.ToUpper(command) + " <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")
+	for _, line := range lines {
+		w.WriteStatus(line)
+	}
+	w.WriteStatus("HELP")
+	w.WriteStatus("    Print this help.")
+}
+
+// DeferredLen A placeholder for an aggregate header of unknown length.
+type DeferredLen int
+
+// WriteDeferredLen Add a placeholder for an aggregate header, for the
+// replies whose length is not known in advance. The header is set with
+// SetDeferredArrayLen, SetDeferredMapLen or SetDeferredSetLen.
+func (w *Writer) WriteDeferredLen() DeferredLen {
+	return DeferredLen(len(w.buf))
+}
+
+// setDeferredLen Insert the header at the placeholder.
+func (w *Writer) setDeferredLen(node DeferredLen, prefix byte, length int64) {
+	var hdr []byte
+	hdr = append(hdr, prefix)
+	hdr = strconv.AppendInt(hdr, length, 10)
+	hdr = append(hdr, '\r', '\n')
+
+	off := int(node)
+	w.buf = append(w.buf, hdr...)
+	copy(w.buf[off+len(hdr):], w.buf[off:len(w.buf)-len(hdr)])
+	copy(w.buf[off:], hdr)
+}
+
+// SetDeferredArrayLen Set the header of a deferred array.
+func (w *Writer) SetDeferredArrayLen(node DeferredLen, length int) {
+	w.setDeferredLen(node, '*', int64(length))
+}
+
+// SetDeferredMapLen Set the header of a deferred map.
+func (w *Writer) SetDeferredMapLen(node DeferredLen, length int) {
+	if w.proto == RESP2 {
+		w.setDeferredLen(node, '*', int64(length)*2)
+	} else {
+		w.setDeferredLen(node, '%', int64(length))
+	}
+}
+
+// SetDeferredSetLen Set the header of a deferred set.
+func (w *Writer) SetDeferredSetLen(node DeferredLen, length int) {
+	if w.proto == RESP2 {
+		w.setDeferredLen(node, '*', int64(length))
+	} else {
+		w.setDeferredLen(node, '~', int64(length))
+	}
+}
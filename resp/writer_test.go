@@ -0,0 +1,100 @@
+package resp
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// writeReplies Write every kind of reply.
+func writeReplies(w *Writer) {
+	w.WriteStatus("OK")
+	w.WriteError("unknown command")
+	w.WriteError("-WRONGTYPE Operation against a key\r\nholding the wrong kind of value")
+	w.WriteInteger(-42)
+	w.WriteBulk([]byte("foo\r\nbar"))
+	w.WriteBulk(nil)
+	w.WriteBulkInteger(1000)
+	w.WriteNull()
+	w.WriteNullArray()
+
+	w.WriteArrayLen(3)
+	w.WriteInteger(1)
+	w.WriteBulkString("two")
+	w.WriteArrayLen(0)
+
+	w.WriteMapLen(2)
+	w.WriteBulkString("a")
+	w.WriteDouble(1.5)
+	w.WriteBulkString("b")
+	w.WriteBool(true)
+
+	w.WriteSetLen(2)
+	w.WriteBulkString("x")
+	w.WriteBool(false)
+
+	for _, d := range []float64{0, math.Copysign(0, -1), 3, -1e18, 0.1, 1e300, 1.5e-7, math.Inf(1), math.Inf(-1), math.NaN()} {
+		w.WriteDouble(d)
+	}
+	w.WriteBigNumber("3492890328409238509324850943850943825024385")
+	w.WriteVerbatim("Some string", "txt")
+
+	w.WritePushLen(3)
+	w.WriteBulkString("message")
+	w.WriteBulkString("channel")
+	w.WriteBulkString("hello")
+
+	if w.Proto() == RESP3 {
+		w.WriteAttributeLen(1)
+		w.WriteBulkString("ttl")
+		w.WriteInteger(3600)
+		w.WriteBulkString("value")
+	}
+
+	node := w.WriteDeferredLen()
+	w.WriteBulkString("k")
+	w.WriteBulkString("v")
+	w.SetDeferredMapLen(node, 1)
+
+	w.WriteHelp("object", "ENCODING <key>", "    Return the kind of internal representation.")
+}
+
+func TestWriterGolden(t *testing.T) {
+	for _, proto := range []int{RESP2, RESP3} {
+		w := CreateWriter(proto)
+		writeReplies(w)
+
+		golden := filepath.Join("testdata", map[int]string{RESP2: "replies.resp2", RESP3: "replies.resp3"}[proto])
+		if *update {
+			if err := ioutil.WriteFile(golden, w.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w.Bytes(), want) {
+			t.Fatalf("RESP%d:\n got %q\nwant %q", proto, w.Bytes(), want)
+		}
+
+		var out bytes.Buffer
+		if n, err := w.WriteTo(&out); err != nil || int(n) != len(want) || w.Len() != 0 || !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("WriteTo %d %v", n, err)
+		}
+	}
+}
+
+func TestWriterAttributeRESP2(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	CreateWriter(RESP2).WriteAttributeLen(1)
+}
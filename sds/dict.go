@@ -0,0 +1,60 @@
+package sds
+
+// Ready-made dict types, so that sds strings can be used as keys and
+// values of a dict.Dict:
+//
+// d := dict.Create()
+// d.Add((*sds.Key)(sds.NewString("foo")), (*sds.Value)(sds.NewString("bar")))
+//
+// The dict duplicates the keys and the values it stores, so the caller
+// keeps the ownership of the strings passed to it.
+
+import (
+	"dict"
+)
+
+// Key a sds string usable as dict.Key.
+type Key SDS
+
+// SDS Return the sds string of the key.
+func (k *Key) SDS() *SDS {
+	return (*SDS)(k)
+}
+
+// HashFunction implements dict.Key.
+func (k *Key) HashFunction() uint64 {
+	return dict.GenHashFunction(k.buf)
+}
+
+// Compare implements dict.Key.
+func (k *Key) Compare(key dict.Key) int {
+	return k.SDS().Cmp(key.(*Key).SDS())
+}
+
+// Dup implements dict.Key.
+func (k *Key) Dup() dict.Key {
+	return (*Key)(k.SDS().Dup())
+}
+
+// Destructor implements dict.Key.
+func (k *Key) Destructor() {
+	k.buf = nil
+}
+
+// Value a sds string usable as dict.Value.
+type Value SDS
+
+// SDS Return the sds string of the value.
+func (v *Value) SDS() *SDS {
+	return (*SDS)(v)
+}
+
+// Dup implements dict.Value.
+func (v *Value) Dup() dict.Value {
+	return (*Value)(v.SDS().Dup())
+}
+
+// Destructor implements dict.Value.
+func (v *Value) Destructor() {
+	v.buf = nil
+}
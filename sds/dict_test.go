@@ -0,0 +1,36 @@
+package sds
+
+import (
+	"testing"
+
+	"dict"
+)
+
+func TestSdsDict(t *testing.T) {
+	d := dict.Create()
+	defer d.Close()
+
+	key := NewString("foo")
+	if err := d.Add((*Key)(key), (*Value)(NewString("bar"))); err != nil {
+		t.Fatal(err)
+	}
+	d.Add((*Key)(NewString("baz")), (*Value)(NewString("qux")))
+
+	// The dict holds its own copies.
+	key.CatString("!")
+	value := d.FetchValue((*Key)(NewString("foo")))
+	if value == nil || value.(*Value).SDS().String() != "bar" {
+		t.FailNow()
+	}
+	if d.FetchValue((*Key)(key)) != nil {
+		t.FailNow()
+	}
+
+	if d.Replace((*Key)(NewString("baz")), (*Value)(NewString("new"))) != 0 {
+		t.FailNow()
+	}
+	entry := d.Find((*Key)(NewString("baz")))
+	if entry.Key().(*Key).SDS().String() != "baz" || entry.Value().(*Value).SDS().String() != "new" {
+		t.FailNow()
+	}
+}
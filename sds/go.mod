@@ -0,0 +1,7 @@
+module sds
+
+go 1.14
+
+require dict v0.0.0
+
+replace dict => ../dict
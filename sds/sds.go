@@ -0,0 +1,674 @@
+package sds
+
+// SDSLib, A C dynamic strings library, ported to go.
+//
+// A sds is a binary safe string that keeps its length and the allocated
+// size in its header, so that appending to it is amortized O(1) thanks to
+// the greedy preallocation of MakeRoomFor.
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Types of the header, the smallest one able to hold the allocated size
+// is used.
+const (
+	Type5  byte = 0
+	Type8  byte = 1
+	Type16 byte = 2
+	Type32 byte = 3
+	Type64 byte = 4
+)
+
+// MaxPrealloc Up to this size MakeRoomFor doubles the allocation, after
+// it only MaxPrealloc more bytes are allocated.
+const MaxPrealloc = 1024 * 1024
+
+// SDS sds string. The len and alloc of the header are the length and the
+// capacity of the buffer.
+type SDS struct {
+	flags byte
+	buf   []byte
+}
+
+// reqType Return the header type needed for a string of 'size' bytes.
+func reqType(size int) byte {
+	switch {
+	case size < 1<<5:
+		return Type5
+	case size < 1<<8:
+		return Type8
+	case size < 1<<16:
+		return Type16
+	case uint64(size) < 1<<32:
+		return Type32
+	}
+	return Type64
+}
+
+// hdrSize Return the size in bytes of the header of the type.
+func hdrSize(flags byte) int {
+	switch flags {
+	case Type5:
+		return 1
+	case Type8:
+		return 3
+	case Type16:
+		return 5
+	case Type32:
+		return 9
+	}
+	return 17
+}
+
+// NewLen Create a new sds string with the content specified by the
+// 'init' bytes. If 'init' is nil the string is initialized with zero
+// bytes of length 'initlen'.
+//
+// You can print the string with fmt.Println() as the SDS implements
+// fmt.Stringer, however the string is binary safe and can contain
+// \0 characters in the middle.
+func NewLen(init []byte, initlen int) *SDS {
+	flags := reqType(initlen)
+	// Empty strings are usually created in order to append. Use type 8
+	// since type 5 is not good at this.
+	if flags == Type5 && initlen == 0 {
+		flags = Type8
+	}
+
+	s := &SDS{
+		flags: flags,
+		buf:   make([]byte, initlen),
+	}
+	if init != nil {
+		copy(s.buf, init)
+	}
+	return s
+}
+
+// New Create a new sds string with the content of 'init'.
+func New(init []byte) *SDS {
+	return NewLen(init, len(init))
+}
+
+// NewString Create a new sds string from a go string.
+func NewString(init string) *SDS {
+	return NewLen([]byte(init), len(init))
+}
+
+// Empty Create an empty (zero length) sds string.
+func Empty() *SDS {
+	return NewLen(nil, 0)
+}
+
+// FromLongLong Create an sds string from a long long value. It is much
+// faster than:
+//
+// sds.NewString(fmt.Sprintf("%d", value))
+func FromLongLong(value int64) *SDS {
+	return New(strconv.AppendInt(nil, value, 10))
+}
+
+// Dup Duplicate an sds string.
+func (s *SDS) Dup() *SDS {
+	return New(s.buf)
+}
+
+// Len Return the length of the string.
+func (s *SDS) Len() int {
+	return len(s.buf)
+}
+
+// Alloc Return the allocated size of the string, not counting the header.
+func (s *SDS) Alloc() int {
+	return cap(s.buf)
+}
+
+// Avail Return the free space at the end of the string, that can be used
+// without reallocating.
+func (s *SDS) Avail() int {
+	if s.flags == Type5 {
+		return 0
+	}
+	return cap(s.buf) - len(s.buf)
+}
+
+// Type Return the type of the header of the string.
+func (s *SDS) Type() byte {
+	return s.flags
+}
+
+// AllocSize Return the total size of the allocation of the specified sds
+// string, including:
+// 1) The sds header.
+// 2) The string.
+// 3) The free buffer at the end if any.
+func (s *SDS) AllocSize() int {
+	return hdrSize(s.flags) + s.Alloc()
+}
+
+// Bytes Return the content of the string, the slice is owned by the sds.
+func (s *SDS) Bytes() []byte {
+	return s.buf
+}
+
+// String implements fmt.Stringer.
+func (s *SDS) String() string {
+	return string(s.buf)
+}
+
+// Clear Modify an sds string in-place to make it empty (zero length).
+// However all the existing buffer is not discarded but set as free space
+// so that next append operations will not require allocations up to the
+// number of bytes previously available.
+func (s *SDS) Clear() {
+	s.buf = s.buf[:0]
+}
+
+// MakeRoomFor Enlarge the free space at the end of the sds string so that
+// the caller is sure that after calling this function can overwrite up to
+// addlen bytes after the end of the string.
+//
+// Note: this does not change the *length* of the sds string as returned
+// by Len(), but only the free buffer space we have.
+func (s *SDS) MakeRoomFor(addlen int) {
+	// Return ASAP if there is enough space left.
+	if s.Avail() >= addlen {
+		return
+	}
+
+	l := len(s.buf)
+	newlen := l + addlen
+	if newlen < MaxPrealloc {
+		newlen *= 2
+	} else {
+		newlen += MaxPrealloc
+	}
+
+	flags := reqType(newlen)
+
+	// Don't use type 5: the user is appending to the string and type 5 is
+	// not able to remember empty space, so MakeRoomFor must be called at
+	// every appending operation.
+	if flags == Type5 {
+		flags = Type8
+	}
+
+	buf := make([]byte, l, newlen)
+	copy(buf, s.buf)
+	s.buf = buf
+	s.flags = flags
+}
+
+// RemoveFreeSpace Reallocate the sds string so that it has no free space
+// at the end. The contained string remains not altered, but next
+// concatenation operations will require a reallocation.
+func (s *SDS) RemoveFreeSpace() {
+	if s.Avail() == 0 {
+		return
+	}
+
+	buf := make([]byte, len(s.buf))
+	copy(buf, s.buf)
+	s.buf = buf
+	s.flags = reqType(len(buf))
+}
+
+// IncrLen Increment the sds length and decrements the left free space at
+// the end of the string according to 'incr'.
+//
+// This function is used in order to fix the string length after the user
+// calls MakeRoomFor(), writes something after the end of the current
+// string, and finally needs to set the new length.
+//
+// Note: it is possible to use a negative increment in order to right-trim
+// the string.
+func (s *SDS) IncrLen(incr int) {
+	l := len(s.buf) + incr
+	if l < 0 || l > cap(s.buf) {
+		panic("sds: IncrLen out of range")
+	}
+	s.buf = s.buf[:l]
+}
+
+// GrowZero Grow the sds to have the specified length. Bytes that were not
+// part of the original length of the sds will be set to zero.
+//
+// if the specified length is smaller than the current length, no
+// operation is performed.
+func (s *SDS) GrowZero(l int) {
+	curlen := len(s.buf)
+	if l <= curlen {
+		return
+	}
+
+	s.MakeRoomFor(l - curlen)
+
+	// Make sure added region doesn't contain garbage
+	s.buf = s.buf[:l]
+	for i := curlen; i < l; i++ {
+		s.buf[i] = 0
+	}
+}
+
+// Cat Append the specified binary-safe string 't' to the end of the
+// sds string.
+func (s *SDS) Cat(t []byte) {
+	s.MakeRoomFor(len(t))
+	s.buf = append(s.buf, t...)
+}
+
+// CatString Append the specified go string to the sds string.
+func (s *SDS) CatString(t string) {
+	s.MakeRoomFor(len(t))
+	s.buf = append(s.buf, t...)
+}
+
+// CatSds Append the specified sds 't' to the existing sds 's'.
+func (s *SDS) CatSds(t *SDS) {
+	s.Cat(t.buf)
+}
+
+// CatPrintf Append to the sds string 's' a string obtained using
+// fmt.Sprintf-alike format specifier.
+//
+// Example:
+//
+// s := sds.NewString("Sum is: ")
+// s.CatPrintf("%d+%d = %d", a, b, a+b)
+//
+// Often you need to create a string from scratch with the printf-alike
+// format. When this is the need, just use sds.Empty() as the target
+// string:
+//
+// s := sds.Empty()
+// s.CatPrintf("%d", 10)
+func (s *SDS) CatPrintf(format string, args ...interface{}) {
+	s.CatString(fmt.Sprintf(format, args...))
+}
+
+// Cpy Destructively modify the sds string 's' to hold the specified
+// binary safe string 't'.
+func (s *SDS) Cpy(t []byte) {
+	if cap(s.buf) < len(t) {
+		s.MakeRoomFor(len(t) - len(s.buf))
+	}
+	s.buf = append(s.buf[:0], t...)
+}
+
+// CatRepr Append to the sds string "s" an escaped string representation
+// where all the non-printable characters (tested with isprint()) are
+// turned into escapes in the form "\n\r\a...." or "\x<hex-number>".
+func (s *SDS) CatRepr(p []byte) {
+	const hex = "0123456789abcdef"
+
+	repr := make([]byte, 0, len(p)+2)
+	repr = append(repr, '"')
+	for _, c := range p {
+		switch c {
+		case '\\', '"':
+			repr = append(repr, '\\', c)
+		case '\n':
+			repr = append(repr, '\\', 'n')
+		case '\r':
+			repr = append(repr, '\\', 'r')
+		case '\t':
+			repr = append(repr, '\\', 't')
+		case '\a':
+			repr = append(repr, '\\', 'a')
+		case '\b':
+			repr = append(repr, '\\', 'b')
+		default:
+			if isprint(c) {
+				repr = append(repr, c)
+			} else {
+				repr = append(repr, '\\', 'x', hex[c>>4], hex[c&0xf])
+			}
+		}
+	}
+	repr = append(repr, '"')
+	s.Cat(repr)
+}
+
+func isprint(c byte) bool {
+	return c >= 0x20 && c <= 0x7e
+}
+
+// Trim Remove the part of the string from left and from right composed
+// just of contiguous characters found in 'cset'.
+//
+// Example:
+//
+// s := sds.NewString("AA...AA.a.aa.aHelloWorld     :::")
+// s.Trim("Aa. :")
+// fmt.Println(s)
+//
+// Output will be just "HelloWorld".
+func (s *SDS) Trim(cset string) {
+	inSet := func(c byte) bool {
+		for i := 0; i < len(cset); i++ {
+			if cset[i] == c {
+				return true
+			}
+		}
+		return false
+	}
+
+	sp, ep := 0, len(s.buf)-1
+	for sp <= ep && inSet(s.buf[sp]) {
+		sp++
+	}
+	for ep > sp && inSet(s.buf[ep]) {
+		ep--
+	}
+
+	l := 0
+	if sp <= ep {
+		l = ep - sp + 1
+	}
+	copy(s.buf, s.buf[sp:sp+l])
+	s.buf = s.buf[:l]
+}
+
+// SubStr Changes the input string to be a subset of the original.
+// It does not release the free space in the string, so a call to
+// RemoveFreeSpace may be wise after.
+func (s *SDS) SubStr(start, l int) {
+	oldlen := len(s.buf)
+	if start >= oldlen {
+		start, l = 0, 0
+	}
+	if l > oldlen-start {
+		l = oldlen - start
+	}
+	copy(s.buf, s.buf[start:start+l])
+	s.buf = s.buf[:l]
+}
+
+// Range Turn the string into a smaller (or equal) string containing only
+// the substring specified by the 'start' and 'end' indexes.
+//
+// start and end can be negative, where -1 means the last character of the
+// string, -2 the penultimate character, and so forth.
+//
+// The interval is inclusive, so the start and end characters will be
+// part of the resulting string.
+//
+// The string is modified in-place.
+//
+// Example:
+//
+// s := sds.NewString("Hello World")
+// s.Range(1, -1) => "ello World"
+func (s *SDS) Range(start, end int) {
+	l := len(s.buf)
+	if l == 0 {
+		return
+	}
+	if start < 0 {
+		start = l + start
+		if start < 0 {
+			start = 0
+		}
+	}
+	if end < 0 {
+		end = l + end
+		if end < 0 {
+			end = 0
+		}
+	}
+
+	newlen := 0
+	if start <= end {
+		newlen = end - start + 1
+	}
+	s.SubStr(start, newlen)
+}
+
+// ToLower Apply tolower() to every character of the sds string 's'.
+func (s *SDS) ToLower() {
+	for i, c := range s.buf {
+		if c >= 'A' && c <= 'Z' {
+			s.buf[i] = c + ('a' - 'A')
+		}
+	}
+}
+
+// ToUpper Apply toupper() to every character of the sds string 's'.
+func (s *SDS) ToUpper() {
+	for i, c := range s.buf {
+		if c >= 'a' && c <= 'z' {
+			s.buf[i] = c - ('a' - 'A')
+		}
+	}
+}
+
+// Cmp Compare two sds strings s1 and s2 with memcmp().
+//
+// Return value:
+//
+//	positive if s1 > s2.
+//	negative if s1 < s2.
+//	0 if s1 and s2 are exactly the same binary string.
+//
+// If two strings share exactly the same prefix, but one of the two has
+// additional characters, the longer string is considered to be greater
+// than the smaller one.
+func (s *SDS) Cmp(s2 *SDS) int {
+	l1, l2 := len(s.buf), len(s2.buf)
+	minlen := l1
+	if l2 < minlen {
+		minlen = l2
+	}
+
+	for i := 0; i < minlen; i++ {
+		if s.buf[i] != s2.buf[i] {
+			return int(s.buf[i]) - int(s2.buf[i])
+		}
+	}
+	return l1 - l2
+}
+
+// Split 's' with separator in 'sep'. An array of sds strings is returned.
+//
+// On zero length string, zero length separator, nil is returned.
+//
+// Note that 'sep' is able to split a string using a multi-character
+// separator. For example Split("foo_-_bar", "_-_") will return two
+// elements "foo" and "bar".
+//
+// This version of the function is binary-safe.
+func Split(s []byte, sep []byte) []*SDS {
+	if len(sep) < 1 || len(s) == 0 {
+		return nil
+	}
+
+	tokens := make([]*SDS, 0, 5)
+	start := 0
+	for j := 0; j < len(s)-(len(sep)-1); j++ {
+		// search the separator
+		if (len(sep) == 1 && s[j] == sep[0]) || string(s[j:j+len(sep)]) == string(sep) {
+			tokens = append(tokens, New(s[start:j]))
+			start = j + len(sep)
+			j = j + len(sep) - 1 // skip the separator
+		}
+	}
+	// Add the final element. We are sure there is room in the tokens
+	// array.
+	tokens = append(tokens, New(s[start:]))
+	return tokens
+}
+
+// MapChars Modify the string substituting all the occurrences of the set
+// of characters specified in the 'from' string to the corresponding
+// character in the 'to' array.
+//
+// For instance: s.MapChars("ho", "01") will have the effect of turning
+// the string "hello" into "0ell1".
+func (s *SDS) MapChars(from, to string) {
+	setlen := len(from)
+	if len(to) < setlen {
+		setlen = len(to)
+	}
+
+	for j, c := range s.buf {
+		for i := 0; i < setlen; i++ {
+			if c == from[i] {
+				s.buf[j] = to[i]
+				break
+			}
+		}
+	}
+}
+
+// Join the slice of strings using the specified separator.
+func Join(argv []*SDS, sep []byte) *SDS {
+	join := Empty()
+	for j, arg := range argv {
+		join.CatSds(arg)
+		if j != len(argv)-1 {
+			join.Cat(sep)
+		}
+	}
+	return join
+}
+
+// isHexDigit Helper function for SplitArgs() that returns true if 'c' is
+// a valid hex digit.
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') ||
+		(c >= 'A' && c <= 'F')
+}
+
+// hexDigitToInt Helper function for SplitArgs() that converts a hex digit
+// into an integer from 0 to 15
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0
+}
+
+// isSpace Return true for the characters of isspace(3).
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+// SplitArgs Split a line into arguments, where every argument can be in
+// the following programming-language REPL-alike form:
+//
+// foo bar "newline are supported\n" and "\xff\x00otherstuff"
+//
+// The number of arguments is returned, along with false if the input
+// contains unbalanced quotes or closed quotes followed by non space
+// characters as in: "foo"bar or "foo'
+//
+// An empty input (or an input only made of spaces) returns an empty
+// slice.
+func SplitArgs(line []byte) ([]*SDS, bool) {
+	p := 0
+	vector := make([]*SDS, 0)
+	for {
+		// skip blanks
+		for p < len(line) && isSpace(line[p]) {
+			p++
+		}
+		if p == len(line) {
+			// even on empty input string return something not nil.
+			return vector, true
+		}
+
+		var (
+			inq     bool // set to true if we are in "quotes"
+			insq    bool // set to true if we are in 'single quotes'
+			done    bool
+			current = Empty()
+		)
+		for !done {
+			switch {
+			case inq:
+				if p == len(line) {
+					// unterminated quotes
+					return nil, false
+				}
+				if line[p] == '\\' && p+3 < len(line) && line[p+1] == 'x' &&
+					isHexDigit(line[p+2]) && isHexDigit(line[p+3]) {
+					b := hexDigitToInt(line[p+2])*16 + hexDigitToInt(line[p+3])
+					current.Cat([]byte{b})
+					p += 3
+				} else if line[p] == '\\' && p+1 < len(line) {
+					p++
+					var c byte
+					switch line[p] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					default:
+						c = line[p]
+					}
+					current.Cat([]byte{c})
+				} else if line[p] == '"' {
+					// closing quote must be followed by a space or
+					// nothing at all.
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					current.Cat(line[p : p+1])
+				}
+			case insq:
+				if p == len(line) {
+					// unterminated quotes
+					return nil, false
+				}
+				if line[p] == '\\' && p+1 < len(line) && line[p+1] == '\'' {
+					p++
+					current.Cat([]byte{'\''})
+				} else if line[p] == '\'' {
+					// closing quote must be followed by a space or
+					// nothing at all.
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					current.Cat(line[p : p+1])
+				}
+			default:
+				if p == len(line) {
+					done = true
+					break
+				}
+				switch line[p] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					current.Cat(line[p : p+1])
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		// add the token to the vector
+		vector = append(vector, current)
+	}
+}
@@ -0,0 +1,193 @@
+package sds
+
+import (
+	"testing"
+)
+
+func check(t *testing.T, s *SDS, want string) {
+	t.Helper()
+	if s.Len() != len(want) || s.String() != want {
+		t.Fatalf("got %q (len %d), want %q", s.String(), s.Len(), want)
+	}
+}
+
+func TestSdsCreate(t *testing.T) {
+	check(t, NewString("foo"), "foo")
+	check(t, NewLen([]byte("foo"), 2), "fo")
+	check(t, NewLen(nil, 3), "\x00\x00\x00")
+	check(t, Empty(), "")
+	check(t, FromLongLong(-9223372036854775808), "-9223372036854775808")
+
+	s := NewString("foo")
+	d := s.Dup()
+	s.CatString("bar")
+	check(t, d, "foo")
+
+	if NewString("foo").Type() != Type5 || Empty().Type() != Type8 {
+		t.FailNow()
+	}
+	if NewLen(nil, 300).Type() != Type16 || NewLen(nil, 70000).Type() != Type32 {
+		t.FailNow()
+	}
+}
+
+func TestSdsCat(t *testing.T) {
+	s := NewString("fo")
+	s.Cat([]byte("bar"))
+	check(t, s, "fobar")
+
+	s.Cpy([]byte("a"))
+	check(t, s, "a")
+	s.Cpy([]byte("xyzxxxxxxxxxxyyyyyyyyyykkkkkkkkkk"))
+	check(t, s, "xyzxxxxxxxxxxyyyyyyyyyykkkkkkkkkk")
+
+	s = Empty()
+	s.CatPrintf("%d", 123)
+	s.CatSds(NewString("--"))
+	check(t, s, "123--")
+
+	s = Empty()
+	s.CatRepr([]byte("\a\n\x00foo\r\"\\\x7f"))
+	check(t, s, `"\a\n\x00foo\r\"\\\x7f"`)
+
+	s = NewString("a")
+	s.GrowZero(3)
+	check(t, s, "a\x00\x00")
+
+	check(t, Join([]*SDS{NewString("a"), NewString("b"), NewString("c")}, []byte(", ")), "a, b, c")
+}
+
+func TestSdsMakeRoomFor(t *testing.T) {
+	s := NewString("0")
+	if s.Avail() != 0 {
+		t.FailNow()
+	}
+
+	// Greedy preallocation doubles the size.
+	s.MakeRoomFor(1)
+	if s.Alloc() != 4 || s.Avail() != 3 || s.Type() != Type8 {
+		t.FailNow()
+	}
+	copy(s.Bytes()[:s.Alloc()][1:], "abc")
+	s.IncrLen(3)
+	check(t, s, "0abc")
+	s.IncrLen(-1)
+	check(t, s, "0ab")
+
+	// After MaxPrealloc only MaxPrealloc more bytes are allocated.
+	s.MakeRoomFor(MaxPrealloc)
+	if s.Alloc() != 3+MaxPrealloc+MaxPrealloc {
+		t.FailNow()
+	}
+	if s.AllocSize() != s.Alloc()+9 {
+		t.FailNow()
+	}
+
+	s.RemoveFreeSpace()
+	if s.Avail() != 0 || s.Type() != Type5 {
+		t.FailNow()
+	}
+	s.Clear()
+	check(t, s, "")
+}
+
+func TestSdsTrimRange(t *testing.T) {
+	s := NewString("AA...AA.a.aa.aHelloWorld     :::")
+	s.Trim("Aa. :")
+	check(t, s, "HelloWorld")
+
+	s = NewString(" x ")
+	s.Trim(" x")
+	check(t, s, "")
+
+	s = NewString("xx")
+	s.Trim("x")
+	check(t, s, "")
+
+	tests := []struct {
+		start, end int
+		want       string
+	}{
+		{1, 1, "i"},
+		{1, -1, "iao"},
+		{-2, -1, "ao"},
+		{2, 1, ""},
+		{1, 100, "iao"},
+		{100, 100, ""},
+		{-100, 0, "c"},
+	}
+	for _, test := range tests {
+		s = NewString("ciao")
+		s.Range(test.start, test.end)
+		check(t, s, test.want)
+	}
+}
+
+func TestSdsCmpSplitMap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"foo", "foa", 1},
+		{"bar", "bar", 0},
+		{"aar", "bar", -1},
+		{"ba", "bar", -1},
+	}
+	for _, test := range tests {
+		cmp := NewString(test.a).Cmp(NewString(test.b))
+		if (cmp > 0 && test.cmp <= 0) || (cmp < 0 && test.cmp >= 0) || (cmp == 0 && test.cmp != 0) {
+			t.Fatalf("Cmp(%q, %q) = %d", test.a, test.b, cmp)
+		}
+	}
+
+	tokens := Split([]byte("foo_-_bar_-_"), []byte("_-_"))
+	if len(tokens) != 3 || tokens[0].String() != "foo" || tokens[1].String() != "bar" || tokens[2].Len() != 0 {
+		t.FailNow()
+	}
+	if Split([]byte("a,b"), nil) != nil || Split(nil, []byte(",")) != nil {
+		t.FailNow()
+	}
+
+	s := NewString("hello")
+	s.MapChars("ho", "01")
+	check(t, s, "0ell1")
+
+	s = NewString("Hello")
+	s.ToUpper()
+	check(t, s, "HELLO")
+	s.ToLower()
+	check(t, s, "hello")
+}
+
+func TestSdsSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+		ok   bool
+	}{
+		{"", []string{}, true},
+		{"   ", []string{}, true},
+		{"set foo bar", []string{"set", "foo", "bar"}, true},
+		{"  set\tfoo  bar \r\n", []string{"set", "foo", "bar"}, true},
+		{`set "foo bar" 'a b'`, []string{"set", "foo bar", "a b"}, true},
+		{`"\x41\x4a\n\t\"" '\''`, []string{"AJ\n\t\"", "'"}, true},
+		{`"\xzz"`, []string{"xzz"}, true},
+		{`a"b"`, []string{"ab"}, true},
+		{`""`, []string{""}, true},
+		{`"foo`, nil, false},
+		{`'foo`, nil, false},
+		{`"foo"bar`, nil, false},
+		{`'foo'bar`, nil, false},
+	}
+	for _, test := range tests {
+		args, ok := SplitArgs([]byte(test.line))
+		if ok != test.ok || len(args) != len(test.args) {
+			t.Fatalf("SplitArgs(%q) = %v, %v", test.line, args, ok)
+		}
+		for i, arg := range args {
+			if arg.String() != test.args[i] {
+				t.Fatalf("SplitArgs(%q)[%d] = %q", test.line, i, arg.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+// The propagation of the write commands to the append only file, and its
+// loading on startup, as in server.c and aof.c of redis.
+//
+// A command modifying the dataset increments the dirty counter, and is
+// propagated once executed as it was called, unless it rewrites its
+// arguments to be replayed deterministically, like the relative expires
+// propagated as absolute ones. The commands propagated while executing a
+// command, like the deletion of the expired keys, are wrapped in a
+// MULTI/EXEC.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"adlist"
+	"aof"
+	"rdb"
+	"resp"
+)
+
+// propagateOp a command to propagate, in the database 'dbid'.
+type propagateOp struct {
+	dbid int
+	argv [][]byte
+}
+
+// forceCommandPropagation Propagate the current command even if it didn't
+// modify the dataset.
+func (c *client) forceCommandPropagation() {
+	c.flags |= clientForceAOF
+}
+
+// rewriteCommandVector Propagate the command as 'argv' instead of the
+// arguments it was called with.
+func (c *client) rewriteCommandVector(argv ...[]byte) {
+	c.argv = argv
+}
+
+// call Execute the command of the client, then propagate it if it
+// modified the dataset. The commands of a transaction are executed and
+// propagated by their own call.
+func (c *client) call() {
+	s := c.srv
+	if s.aof != nil && c.cmd.flags&cmdWrite != 0 {
+		c.rewriteVisitKeys()
+	}
+
+	c.flags &^= clientForceAOF
+	dirty := s.dirty
+	c.cmd.proc(c)
+	dirty = s.dirty - dirty
+
+	if c.cmd.name != "exec" && (dirty != 0 || c.flags&clientForceAOF != 0) {
+		s.alsoPropagate(c.db.ID(), c.argv)
+	}
+	c.flags &^= clientForceAOF
+}
+
+// rewriteVisitKeys Rewrite the keys the write command is about to modify
+// if a rewrite of the AOF is in progress, or the whole keyspace if the
+// command has no key arguments, like FLUSHALL.
+func (c *client) rewriteVisitKeys() {
+	a := c.srv.aof
+	if !a.RewriteInProgress() {
+		return
+	}
+	cmd := c.cmd
+	if cmd.firstKey == 0 {
+		a.RewriteDrain()
+		return
+	}
+
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(c.argv)
+	}
+	for j := cmd.firstKey; j <= last && j < len(c.argv); j += cmd.keyStep {
+		a.RewriteVisit(c.db.ID(), c.argv[j])
+	}
+	// MOVE also creates the key in the target database.
+	if cmd.name == "move" {
+		if dbid, err := strconv.Atoi(string(c.argv[2])); err == nil {
+			a.RewriteVisit(dbid, c.argv[1])
+		}
+	}
+}
+
+// alsoPropagate Record the command to propagate once the command being
+// executed returns.
+func (s *Server) alsoPropagate(dbid int, argv [][]byte) {
+	if s.aof == nil || s.loading {
+		return
+	}
+	s.alsoPropagateOps = append(s.alsoPropagateOps, propagateOp{dbid: dbid, argv: argv})
+}
+
+// propagatePendingCommands Feed the AOF with the recorded commands,
+// wrapped in a MULTI/EXEC if there are more than one.
+func (s *Server) propagatePendingCommands() {
+	ops := s.alsoPropagateOps
+	if len(ops) == 0 {
+		return
+	}
+	s.alsoPropagateOps = s.alsoPropagateOps[:0]
+
+	// Wrap the commands in a MULTI/EXEC so that they are replayed
+	// atomically, like the commands of a transaction.
+	transaction := len(ops) > 1
+	if transaction {
+		s.aof.Feed(ops[0].dbid, [][]byte{[]byte("MULTI")})
+	}
+	for _, op := range ops {
+		s.aof.Feed(op.dbid, op.argv)
+	}
+	if transaction {
+		s.aof.Feed(ops[len(ops)-1].dbid, [][]byte{[]byte("EXEC")})
+	}
+	for i := range ops {
+		ops[i].argv = nil
+	}
+}
+
+// propagateExpire Propagate the deletion of an expired key as a DEL, so
+// that the key is deleted when the AOF is replayed.
+func (s *Server) propagateExpire(class int, event string, key []byte, dbid int) {
+	s.alsoPropagate(dbid, [][]byte{[]byte("DEL"), append([]byte{}, key...)})
+}
+
+// flushAppendOnlyFile Write the propagated commands to the AOF before the
+// replies are sent to the clients.
+func (s *Server) flushAppendOnlyFile() {
+	if s.aof != nil {
+		s.aof.Flush()
+	}
+}
+
+// aofCron The AOF part of serverCron: retry the failed writes, collect
+// the background fsync, and start a rewrite if the AOF grew enough.
+func (s *Server) aofCron() {
+	if s.aof == nil {
+		return
+	}
+	s.aof.Cron()
+	if s.aof.NeedRewrite() {
+		s.aof.StartRewrite(s.dbs, &s.mu)
+	}
+}
+
+// LoadAppendOnlyFile Replay the AOF set with WithAppendOnly, executing
+// its commands as a client, before serving the clients. The file written
+// with an RDB preamble is loaded as an RDB.
+func (s *Server) LoadAppendOnlyFile() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aof == nil {
+		return nil
+	}
+
+	s.loading = true
+	defer func() { s.loading = false }()
+	// The fake client executing the commands, its replies are discarded.
+	c := &client{
+		srv:         s,
+		w:           resp.CreateWriter(resp.RESP2),
+		watchedKeys: adlist.ListCreate(),
+	}
+	c.db, _ = s.dbs.Select(0)
+	c.initClientMultiState()
+	defer c.freeClientMultiState()
+
+	loadRDB := func(r io.Reader) error {
+		return rdb.CreateDecoder(r, rdb.WithObjectConfig(s.cfg), rdb.WithClock(s.now)).Load(s.dbs)
+	}
+	exec := func(argv [][]byte) error {
+		// Command lookup
+		c.argv = argv
+		c.cmd = s.lookupCommand(argv[0])
+		if c.cmd == nil {
+			return fmt.Errorf("Unknown command '%s' reading the append only file", argv[0])
+		}
+
+		// Run the command in the context of a fake client
+		if c.flags&clientMulti != 0 && c.cmd.name != "exec" {
+			c.queueMultiCommand()
+		} else {
+			c.call()
+		}
+		c.w.Reset()
+		c.argv = nil
+		c.cmd = nil
+		return nil
+	}
+	return s.aof.Load(loadRDB, exec)
+}
+
+// BGREWRITEAOF
+func bgrewriteaofCommand(c *client) {
+	s := c.srv
+	switch {
+	case s.aof == nil:
+		c.w.WriteError("Append only file is not enabled")
+	case s.aof.RewriteInProgress():
+		c.w.WriteError(aof.ErrRewriteInProgress.Error())
+	default:
+		if err := s.aof.StartRewrite(s.dbs, &s.mu); err != nil {
+			c.w.WriteError(err.Error())
+			return
+		}
+		c.w.WriteStatus("Background append only file rewriting started")
+	}
+}
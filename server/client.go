@@ -0,0 +1,380 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"adlist"
+	"db"
+	"object"
+	"pubsub"
+	"resp"
+	"util"
+)
+
+// Client flags.
+const (
+	// clientCloseAfterReply Close after writing entire reply.
+	clientCloseAfterReply = 1 << 0
+	// clientMulti This client is in a MULTI context
+	clientMulti = 1 << 1
+	// clientDirtyCAS Watched keys modified. EXEC will fail.
+	clientDirtyCAS = 1 << 2
+	// clientDirtyExec EXEC will fail for errors while queueing
+	clientDirtyExec = 1 << 3
+	// clientPubSub Client is in Pub/Sub mode.
+	clientPubSub = 1 << 4
+	// clientForceAOF Force AOF propagation of current cmd.
+	clientForceAOF = 1 << 5
+)
+
+// Shared error replies.
+const (
+	errNotInteger = "value is not an integer or out of range"
+	errNotFloat   = "value is not a valid float"
+	errSyntax     = "syntax error"
+	errNoSuchKey  = "no such key"
+	errOutOfRange = "index out of range"
+	errOverflow   = "increment or decrement would overflow"
+)
+
+// client the state of a connected client.
+type client struct {
+	id     uint64
+	srv    *Server
+	conn   net.Conn
+	flags  int
+	name   string
+	db     *db.DB
+	parser *resp.Parser
+	// Reply buffer, written to the connection once the pipelined
+	// commands of the query buffer are processed, and when messages are
+	// published to a subscribed client. Accessed with the server lock
+	// held.
+	w *resp.Writer
+	// writeMu serializes the writes to the connection, obuf is the
+	// buffer being written.
+	writeMu sync.Mutex
+	obuf    []byte
+
+	// Arguments of the current command.
+	argv [][]byte
+	cmd  *command
+
+	// MULTI/EXEC state
+	mstate []multiCmd
+	// Keys WATCHED for MULTI/EXEC CAS, the *db.WatchedKey.
+	watchedKeys *adlist.List
+
+	// Pub/Sub state, nil until the first subscription. The writer of
+	// the published messages is woken up by 'wake', and closes
+	// 'writerDone' when it exits.
+	sub        *pubsub.Subscriber
+	wake       chan struct{}
+	writerDone chan struct{}
+
+	// The node of the client in the list of clients.
+	node *adlist.Node
+}
+
+// Value implements adlist.Value.
+func (c *client) Value() {}
+
+// createClient Create a client for the connection, and link it to the
+// list of clients. The server lock is held.
+func (s *Server) createClient(conn net.Conn) *client {
+	s.nextClientID++
+	c := &client{
+		id:     s.nextClientID,
+		srv:    s,
+		conn:   conn,
+		parser: resp.CreateParser(resp.WithMaxBulkLen(s.maxBulkLen)),
+		w:      resp.CreateWriter(resp.RESP2),
+
+		watchedKeys: adlist.ListCreate(),
+	}
+	c.db, _ = s.dbs.Select(0)
+	c.initClientMultiState()
+
+	s.clients.AddNodeTail(c)
+	c.node = s.clients.Last()
+	s.wg.Add(1)
+	return c
+}
+
+// free Unlink the client from the list of clients and close the
+// connection.
+func (c *client) free() {
+	s := c.srv
+	s.mu.Lock()
+	// Deallocate structures used for MULTI/EXEC and WATCH.
+	c.freeClientMultiState()
+	c.unwatchAllKeys()
+	// Unsubscribe from all the pubsub channels
+	if c.sub != nil {
+		c.sub.Close()
+		close(c.wake)
+	}
+	s.clients.UnlinkNode(c.node)
+	s.mu.Unlock()
+
+	c.conn.Close()
+	if c.writerDone != nil {
+		<-c.writerDone
+	}
+	s.wg.Done()
+}
+
+// serve Read the queries of the client, until the connection is closed.
+func (c *client) serve() {
+	defer c.free()
+
+	buf := make([]byte, ioBufLen)
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			c.parser.Feed(buf[:n])
+			if c.parser.Buffered() > c.srv.maxQueryBufLen {
+				// Closing client that reached max query buffer length.
+				return
+			}
+
+			closeAfterReply := c.processInputBuffer()
+			if err := c.flush(); err != nil {
+				return
+			}
+			if closeAfterReply {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// processInputBuffer Process all the complete commands of the query
+// buffer, and add the replies to the reply buffer. Returns true if the
+// connection is to be closed after the reply.
+func (c *client) processInputBuffer() bool {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c.flags&clientCloseAfterReply == 0 {
+		argv, err := c.parser.Next()
+		if err != nil {
+			// Protocol error: reply with the error and close the
+			// connection after the reply.
+			c.w.WriteError(err.Error())
+			c.flags |= clientCloseAfterReply
+			break
+		}
+		if argv == nil {
+			break
+		}
+		// Multibulk processing could see a <= 0 length.
+		if len(argv) == 0 {
+			continue
+		}
+
+		c.argv = argv
+		c.processCommand()
+		s.propagatePendingCommands()
+		c.argv = nil
+		c.cmd = nil
+
+		// Let the other clients run between the pipelined commands.
+		s.mu.Unlock()
+		s.mu.Lock()
+	}
+	// Write the AOF buffer on disk, must be done before the replies are
+	// written to the connection.
+	s.flushAppendOnlyFile()
+	return c.flags&clientCloseAfterReply != 0
+}
+
+// flush Write the reply buffer to the connection.
+func (c *client) flush() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.srv.mu.Lock()
+	c.obuf = append(c.obuf[:0], c.w.Bytes()...)
+	c.w.Reset()
+	c.srv.mu.Unlock()
+
+	if len(c.obuf) == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(c.obuf)
+	return err
+}
+
+// addReplyErr Add the error reply of an error returned by the lower
+// layers, keeping the error code of the errors like db.ErrWrongType.
+func (c *client) addReplyErr(err error) {
+	if err == db.ErrWrongType {
+		c.w.WriteError("-" + err.Error())
+		return
+	}
+	c.w.WriteError(err.Error())
+}
+
+// addReplyErrorArity Add the wrong number of arguments error of the
+// current command.
+func (c *client) addReplyErrorArity() {
+	c.w.WriteError(fmt.Sprintf("wrong number of arguments for '%s' command", c.cmd.name))
+}
+
+// addReplySubcommandSyntaxError Add the error of an unknown subcommand,
+// or of a subcommand with the wrong number of arguments.
+func (c *client) addReplySubcommandSyntaxError() {
+	c.w.WriteError(fmt.Sprintf("unknown subcommand '%.128s'. Try %s HELP.", c.argv[1], strings.ToUpper(c.cmd.name)))
+}
+
+// addReplyBulks Add an array reply of bulk strings.
+func (c *client) addReplyBulks(bulks [][]byte) {
+	c.w.WriteArrayLen(len(bulks))
+	for _, b := range bulks {
+		c.w.WriteBulk(b)
+	}
+}
+
+// PING [message]
+func pingCommand(c *client) {
+	// The command takes zero or one arguments.
+	if len(c.argv) > 2 {
+		c.addReplyErrorArity()
+		return
+	}
+	if c.flags&clientPubSub != 0 && c.w.Proto() == resp.RESP2 {
+		c.w.WriteArrayLen(2)
+		c.w.WriteBulkString("pong")
+		if len(c.argv) == 1 {
+			c.w.WriteBulkString("")
+		} else {
+			c.w.WriteBulk(c.argv[1])
+		}
+	} else if len(c.argv) == 1 {
+		c.w.WriteStatus("PONG")
+	} else {
+		c.w.WriteBulk(c.argv[1])
+	}
+}
+
+// ECHO message
+func echoCommand(c *client) {
+	c.w.WriteBulk(c.argv[1])
+}
+
+// QUIT
+func quitCommand(c *client) {
+	c.w.WriteStatus("OK")
+	c.flags |= clientCloseAfterReply
+}
+
+// validateClientName Check that the client name contains only the chars
+// from '!' to '~', so that it can be shown in the client list.
+func validateClientName(name []byte) bool {
+	for _, ch := range name {
+		if ch < '!' || ch > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloCommand(c *client) {
+	ver := int64(0)
+	nextArg := 1
+	if len(c.argv) >= 2 {
+		var ok bool
+		if ver, ok = util.String2ll(c.argv[nextArg]); !ok {
+			c.w.WriteError("Protocol version is not an integer or out of range")
+			return
+		}
+		nextArg++
+		if ver < 2 || ver > 3 {
+			c.w.WriteError("-NOPROTO unsupported protocol version")
+			return
+		}
+	}
+
+	var name []byte
+	for j := nextArg; j < len(c.argv); j++ {
+		moreargs := len(c.argv) - 1 - j
+		opt := strings.ToLower(string(c.argv[j]))
+		if opt == "auth" && moreargs >= 2 {
+			// There are no users with a password: the default user
+			// accepts any password.
+			j += 2
+		} else if opt == "setname" && moreargs >= 1 {
+			name = c.argv[j+1]
+			if !validateClientName(name) {
+				c.w.WriteError("Client names cannot contain spaces, newlines or special characters.")
+				return
+			}
+			j++
+		} else {
+			c.w.WriteError(fmt.Sprintf("Syntax error in HELLO option '%s'", c.argv[j]))
+			return
+		}
+	}
+
+	if name != nil {
+		c.name = string(name)
+	}
+	// Let's switch to the specified RESP mode.
+	if ver != 0 {
+		c.w.SetProto(int(ver))
+	}
+
+	c.w.WriteMapLen(7)
+	c.w.WriteBulkString("server")
+	c.w.WriteBulkString("redis")
+	c.w.WriteBulkString("version")
+	c.w.WriteBulkString(Version)
+	c.w.WriteBulkString("proto")
+	c.w.WriteInteger(int64(c.w.Proto()))
+	c.w.WriteBulkString("id")
+	c.w.WriteInteger(int64(c.id))
+	c.w.WriteBulkString("mode")
+	c.w.WriteBulkString("standalone")
+	c.w.WriteBulkString("role")
+	c.w.WriteBulkString("master")
+	c.w.WriteBulkString("modules")
+	c.w.WriteArrayLen(0)
+}
+
+// getLongLongOrReply Parse the integer argument, replying with 'msg', or
+// the generic error if empty, when it is not valid.
+func (c *client) getLongLongOrReply(arg []byte, msg string) (int64, bool) {
+	value, ok := util.String2ll(arg)
+	if !ok {
+		if msg == "" {
+			msg = errNotInteger
+		}
+		c.w.WriteError(msg)
+	}
+	return value, ok
+}
+
+// getDoubleOrReply Parse the float argument, replying with 'msg', or the
+// generic error if empty, when it is not valid.
+func (c *client) getDoubleOrReply(arg []byte, msg string) (float64, bool) {
+	o := object.CreateString(arg)
+	value, err := object.GetDouble(o)
+	o.DecrRefCount()
+	if err != nil {
+		if msg == "" {
+			msg = errNotFloat
+		}
+		c.w.WriteError(msg)
+		return 0, false
+	}
+	return value, true
+}
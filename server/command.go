@@ -0,0 +1,324 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"resp"
+)
+
+// Command flags.
+const (
+	// cmdWrite The command may modify the dataset.
+	cmdWrite = 1 << iota
+	// cmdReadonly The command only reads from the keys.
+	cmdReadonly
+	// cmdAdmin An administrative command.
+	cmdAdmin
+	// cmdFast The command runs in O(1) or O(log(N)) time.
+	cmdFast
+	// cmdPubSub A Pub/Sub related command.
+	cmdPubSub
+)
+
+var cmdFlagNames = []struct {
+	flag int
+	name string
+}{
+	{cmdWrite, "write"},
+	{cmdReadonly, "readonly"},
+	{cmdAdmin, "admin"},
+	{cmdFast, "fast"},
+	{cmdPubSub, "pubsub"},
+}
+
+// command an entry of the command table.
+type command struct {
+	name string
+	proc func(c *client)
+	// Number of arguments, the command name included. A negative arity
+	// means at least -arity arguments.
+	arity int
+	flags int
+	// Position of the first and last key arguments, and step between
+	// the keys. A negative last key is counted from the end.
+	firstKey int
+	lastKey  int
+	keyStep  int
+}
+
+// commandTable The table of the commands served.
+var commandTable = []command{
+	// Connection
+	{"ping", pingCommand, -1, cmdFast, 0, 0, 0},
+	{"echo", echoCommand, 2, cmdFast, 0, 0, 0},
+	{"quit", quitCommand, -1, cmdFast, 0, 0, 0},
+	{"select", selectCommand, 2, cmdFast, 0, 0, 0},
+	{"hello", helloCommand, -1, cmdFast, 0, 0, 0},
+
+	// Transactions
+	{"multi", multiCommand, 1, cmdFast, 0, 0, 0},
+	{"exec", execCommand, 1, 0, 0, 0, 0},
+	{"discard", discardCommand, 1, cmdFast, 0, 0, 0},
+	{"watch", watchCommand, -2, cmdFast, 1, -1, 1},
+	{"unwatch", unwatchCommand, 1, cmdFast, 0, 0, 0},
+
+	// Pub/Sub
+	{"subscribe", subscribeCommand, -2, cmdPubSub, 0, 0, 0},
+	{"unsubscribe", unsubscribeCommand, -1, cmdPubSub, 0, 0, 0},
+	{"psubscribe", psubscribeCommand, -2, cmdPubSub, 0, 0, 0},
+	{"punsubscribe", punsubscribeCommand, -1, cmdPubSub, 0, 0, 0},
+	{"publish", publishCommand, 3, cmdPubSub | cmdFast, 0, 0, 0},
+	{"ssubscribe", ssubscribeCommand, -2, cmdPubSub, 1, -1, 1},
+	{"sunsubscribe", sunsubscribeCommand, -1, cmdPubSub, 1, -1, 1},
+	{"spublish", spublishCommand, 3, cmdPubSub | cmdFast, 1, 1, 1},
+	{"pubsub", pubsubCommand, -2, cmdPubSub, 0, 0, 0},
+
+	// Server
+	{"command", commandCommand, -1, 0, 0, 0, 0},
+	{"dbsize", dbsizeCommand, 1, cmdReadonly | cmdFast, 0, 0, 0},
+	{"flushdb", flushdbCommand, -1, cmdWrite, 0, 0, 0},
+	{"flushall", flushallCommand, -1, cmdWrite, 0, 0, 0},
+	{"swapdb", swapdbCommand, 3, cmdWrite | cmdFast, 0, 0, 0},
+	{"shutdown", shutdownCommand, -1, cmdAdmin, 0, 0, 0},
+	{"bgrewriteaof", bgrewriteaofCommand, 1, cmdAdmin, 0, 0, 0},
+
+	// Keyspace
+	{"del", delCommand, -2, cmdWrite, 1, -1, 1},
+	{"unlink", delCommand, -2, cmdWrite | cmdFast, 1, -1, 1},
+	{"exists", existsCommand, -2, cmdReadonly | cmdFast, 1, -1, 1},
+	{"type", typeCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+	{"rename", renameCommand, 3, cmdWrite, 1, 2, 1},
+	{"renamenx", renamenxCommand, 3, cmdWrite | cmdFast, 1, 2, 1},
+	{"randomkey", randomkeyCommand, 1, cmdReadonly, 0, 0, 0},
+	{"scan", scanCommand, -2, cmdReadonly, 0, 0, 0},
+	{"move", moveCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"expire", expireCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"pexpire", pexpireCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"expireat", expireatCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"pexpireat", pexpireatCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"ttl", ttlCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+	{"pttl", pttlCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+	{"persist", persistCommand, 2, cmdWrite | cmdFast, 1, 1, 1},
+
+	// String
+	{"get", getCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+	{"set", setCommand, -3, cmdWrite, 1, 1, 1},
+	{"setnx", setnxCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"setex", setexCommand, 4, cmdWrite, 1, 1, 1},
+	{"psetex", psetexCommand, 4, cmdWrite, 1, 1, 1},
+	{"getset", getsetCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"getdel", getdelCommand, 2, cmdWrite | cmdFast, 1, 1, 1},
+	{"mget", mgetCommand, -2, cmdReadonly | cmdFast, 1, -1, 1},
+	{"mset", msetCommand, -3, cmdWrite, 1, -1, 2},
+	{"msetnx", msetnxCommand, -3, cmdWrite, 1, -1, 2},
+	{"incr", incrCommand, 2, cmdWrite | cmdFast, 1, 1, 1},
+	{"decr", decrCommand, 2, cmdWrite | cmdFast, 1, 1, 1},
+	{"incrby", incrbyCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"decrby", decrbyCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"incrbyfloat", incrbyfloatCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"append", appendCommand, 3, cmdWrite | cmdFast, 1, 1, 1},
+	{"strlen", strlenCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+
+	// List
+	{"lpush", lpushCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"rpush", rpushCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"lpushx", lpushxCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"rpushx", rpushxCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"lpop", lpopCommand, -2, cmdWrite | cmdFast, 1, 1, 1},
+	{"rpop", rpopCommand, -2, cmdWrite | cmdFast, 1, 1, 1},
+	{"llen", llenCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+	{"lindex", lindexCommand, 3, cmdReadonly, 1, 1, 1},
+	{"lset", lsetCommand, 4, cmdWrite, 1, 1, 1},
+	{"lrange", lrangeCommand, 4, cmdReadonly, 1, 1, 1},
+	{"linsert", linsertCommand, 5, cmdWrite, 1, 1, 1},
+	{"lrem", lremCommand, 4, cmdWrite, 1, 1, 1},
+	{"ltrim", ltrimCommand, 4, cmdWrite, 1, 1, 1},
+
+	// Hash
+	{"hset", hsetCommand, -4, cmdWrite | cmdFast, 1, 1, 1},
+	{"hmset", hsetCommand, -4, cmdWrite | cmdFast, 1, 1, 1},
+	{"hsetnx", hsetnxCommand, 4, cmdWrite | cmdFast, 1, 1, 1},
+	{"hget", hgetCommand, 3, cmdReadonly | cmdFast, 1, 1, 1},
+	{"hmget", hmgetCommand, -3, cmdReadonly | cmdFast, 1, 1, 1},
+	{"hdel", hdelCommand, -3, cmdWrite | cmdFast, 1, 1, 1},
+	{"hlen", hlenCommand, 2, cmdReadonly | cmdFast, 1, 1, 1},
+	{"hstrlen", hstrlenCommand, 3, cmdReadonly | cmdFast, 1, 1, 1},
+	{"hexists", hexistsCommand, 3, cmdReadonly | cmdFast, 1, 1, 1},
+	{"hincrby", hincrbyCommand, 4, cmdWrite | cmdFast, 1, 1, 1},
+	{"hincrbyfloat", hincrbyfloatCommand, 4, cmdWrite | cmdFast, 1, 1, 1},
+	{"hkeys", hkeysCommand, 2, cmdReadonly, 1, 1, 1},
+	{"hvals", hvalsCommand, 2, cmdReadonly, 1, 1, 1},
+	{"hgetall", hgetallCommand, 2, cmdReadonly, 1, 1, 1},
+}
+
+// populateCommandTable Index the command table by name.
+func (s *Server) populateCommandTable() {
+	s.commands = make(map[string]*command, len(commandTable))
+	for i := range commandTable {
+		s.commands[commandTable[i].name] = &commandTable[i]
+	}
+}
+
+// lookupCommand Return the command by name, case insensitive, nil if not
+// found.
+func (s *Server) lookupCommand(name []byte) *command {
+	return s.commands[strings.ToLower(string(name))]
+}
+
+// processCommand Execute the command in c.argv, after checking that it
+// exists, its arity and its flags. The server lock is held.
+func (c *client) processCommand() {
+	s := c.srv
+
+	// Now lookup the command and check ASAP about trivial error
+	// conditions such as wrong arity, bad command name and so forth.
+	c.cmd = s.lookupCommand(c.argv[0])
+	if c.cmd == nil {
+		var args strings.Builder
+		for i := 1; i < len(c.argv) && args.Len() < 128; i++ {
+			arg := c.argv[i]
+			if n := 128 - args.Len(); len(arg) > n {
+				arg = arg[:n]
+			}
+			fmt.Fprintf(&args, "'%s' ", arg)
+		}
+		name := c.argv[0]
+		if len(name) > 128 {
+			name = name[:128]
+		}
+		c.flagTransaction()
+		c.w.WriteError(fmt.Sprintf("unknown command '%s', with args beginning with: %s", name, args.String()))
+		return
+	}
+	if c.cmd.arity > 0 && c.cmd.arity != len(c.argv) ||
+		len(c.argv) < -c.cmd.arity {
+		c.flagTransaction()
+		c.addReplyErrorArity()
+		return
+	}
+
+	// Don't accept write commands if this is a read only server.
+	if s.readOnly && c.cmd.flags&cmdWrite != 0 {
+		c.flagTransaction()
+		c.w.WriteError("-READONLY You can't write against a read only replica.")
+		return
+	}
+
+	// Don't accept write commands if there are problems persisting on
+	// disk.
+	if s.aof != nil && s.aof.LastWriteError() != nil && c.cmd.flags&cmdWrite != 0 {
+		c.flagTransaction()
+		c.w.WriteError("-MISCONF Errors writing to the AOF file: " + s.aof.LastWriteError().Error())
+		return
+	}
+
+	// Only allow a subset of commands in the context of Pub/Sub if the
+	// connection is in RESP2 mode
+	if c.flags&clientPubSub != 0 && c.w.Proto() == resp.RESP2 &&
+		c.cmd.name != "ping" && c.cmd.name != "quit" &&
+		c.cmd.name != "subscribe" && c.cmd.name != "unsubscribe" &&
+		c.cmd.name != "psubscribe" && c.cmd.name != "punsubscribe" &&
+		c.cmd.name != "ssubscribe" && c.cmd.name != "sunsubscribe" {
+		c.w.WriteError(fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", c.cmd.name))
+		return
+	}
+
+	// Exec the command
+	if c.flags&clientMulti != 0 &&
+		c.cmd.name != "exec" && c.cmd.name != "discard" &&
+		c.cmd.name != "multi" && c.cmd.name != "watch" &&
+		c.cmd.name != "quit" {
+		c.queueMultiCommand()
+		c.w.WriteStatus("QUEUED")
+		return
+	}
+	c.call()
+}
+
+// addReplyCommandInfo Add the reply of COMMAND for the command 'cmd'.
+func (c *client) addReplyCommandInfo(cmd *command) {
+	if cmd == nil {
+		c.w.WriteNullArray()
+		return
+	}
+	c.w.WriteArrayLen(6)
+	c.w.WriteBulkString(cmd.name)
+	c.w.WriteInteger(int64(cmd.arity))
+
+	var flags []string
+	for _, f := range cmdFlagNames {
+		if cmd.flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	c.w.WriteSetLen(len(flags))
+	for _, f := range flags {
+		c.w.WriteStatus(f)
+	}
+
+	c.w.WriteInteger(int64(cmd.firstKey))
+	c.w.WriteInteger(int64(cmd.lastKey))
+	c.w.WriteInteger(int64(cmd.keyStep))
+}
+
+// sortedCommands Return the commands sorted by name.
+func (s *Server) sortedCommands() []*command {
+	cmds := make([]*command, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].name < cmds[j].name })
+	return cmds
+}
+
+// COMMAND [COUNT | INFO [command-name ...] | LIST]
+func commandCommand(c *client) {
+	if len(c.argv) == 1 {
+		cmds := c.srv.sortedCommands()
+		c.w.WriteArrayLen(len(cmds))
+		for _, cmd := range cmds {
+			c.addReplyCommandInfo(cmd)
+		}
+		return
+	}
+
+	switch sub := strings.ToLower(string(c.argv[1])); {
+	case sub == "help" && len(c.argv) == 2:
+		c.w.WriteHelp("COMMAND",
+			"(no subcommand)",
+			"    Return details about all commands.",
+			"COUNT",
+			"    Return the total number of commands in this server.",
+			"LIST",
+			"    Return a list of all commands in this server.",
+			"INFO [<command-name> ...]",
+			"    Return details about multiple commands.",
+			"    If no command names are given, documentation details for all",
+			"    commands are returned.")
+	case sub == "count" && len(c.argv) == 2:
+		c.w.WriteInteger(int64(len(c.srv.commands)))
+	case sub == "list" && len(c.argv) == 2:
+		cmds := c.srv.sortedCommands()
+		c.w.WriteArrayLen(len(cmds))
+		for _, cmd := range cmds {
+			c.w.WriteBulkString(cmd.name)
+		}
+	case sub == "info":
+		if len(c.argv) == 2 {
+			cmds := c.srv.sortedCommands()
+			c.w.WriteArrayLen(len(cmds))
+			for _, cmd := range cmds {
+				c.addReplyCommandInfo(cmd)
+			}
+			return
+		}
+		c.w.WriteArrayLen(len(c.argv) - 2)
+		for _, name := range c.argv[2:] {
+			c.addReplyCommandInfo(c.srv.lookupCommand(name))
+		}
+	default:
+		c.addReplySubcommandSyntaxError()
+	}
+}
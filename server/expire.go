@@ -0,0 +1,189 @@
+package server
+
+// The expire commands, a port of expire.c of redis.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"db"
+)
+
+// Flags of the EXPIRE commands.
+const (
+	// expireNX Set expiry only when the key has no expiry.
+	expireNX = 1 << iota
+	// expireXX Set expiry only when the key has an existing expiry.
+	expireXX
+	// expireGT Set expiry only when the new expiry is greater than the
+	// current one.
+	expireGT
+	// expireLT Set expiry only when the new expiry is less than the
+	// current one.
+	expireLT
+)
+
+// mstime Return the UNIX time in milliseconds.
+func (s *Server) mstime() int64 {
+	return s.now().UnixNano() / int64(time.Millisecond)
+}
+
+// parseExtendedExpireArgumentsOrReply Parse the NX, XX, GT and LT
+// options of the EXPIRE commands.
+func (c *client) parseExtendedExpireArgumentsOrReply() (int, bool) {
+	var flags int
+	for _, arg := range c.argv[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			flags |= expireNX
+		case "xx":
+			flags |= expireXX
+		case "gt":
+			flags |= expireGT
+		case "lt":
+			flags |= expireLT
+		default:
+			c.w.WriteError(fmt.Sprintf("Unsupported option %s", arg))
+			return 0, false
+		}
+	}
+
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		c.w.WriteError("NX and XX, GT or LT options at the same time are not compatible")
+		return 0, false
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		c.w.WriteError("GT and LT options at the same time are not compatible")
+		return 0, false
+	}
+	return flags, true
+}
+
+// expireGenericCommand This is the generic command implementation for
+// EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. Because the command second
+// argument may be relative or absolute the "basetime" argument is used
+// to signal what the base time is (either 0 for *AT variants of the
+// command, or the current time for relative expires).
+//
+// 'unit' is either time.Second or time.Millisecond, and is only used for
+// the argv[2] parameter. The basetime is always specified in
+// milliseconds.
+func expireGenericCommand(c *client, basetime int64, unit time.Duration) {
+	key := c.argv[1]
+	when, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+	flags, ok := c.parseExtendedExpireArgumentsOrReply()
+	if !ok {
+		return
+	}
+
+	// EXPIRE allows negative numbers, but we can at least detect an
+	// overflow by either unit conversion or basetime addition.
+	if unit == time.Second {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			c.w.WriteError(fmt.Sprintf("invalid expire time in '%s' command", c.cmd.name))
+			return
+		}
+		when *= 1000
+	}
+	if when > math.MaxInt64-basetime {
+		c.w.WriteError(fmt.Sprintf("invalid expire time in '%s' command", c.cmd.name))
+		return
+	}
+	when += basetime
+
+	// No key, return zero.
+	if c.db.Lookup(key) == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+
+	if flags != 0 {
+		current := c.db.GetExpire(key)
+
+		// NX option is set, check current has no expiry
+		if flags&expireNX != 0 && current != -1 ||
+			// XX option is set, check current has expiry
+			flags&expireXX != 0 && current == -1 ||
+			// GT option is set, check new expiry is greater than
+			// current, a persistent key is considered infinite
+			flags&expireGT != 0 && (when <= current || current == -1) ||
+			// LT option is set, check new expiry is less than current
+			flags&expireLT != 0 && current != -1 && when >= current {
+			c.w.WriteInteger(0)
+			return
+		}
+	}
+
+	if when <= c.srv.mstime() {
+		// An expire in the past deletes the key.
+		c.db.Delete(key)
+		c.srv.dirty++
+
+		// Replicate/AOF this as an explicit DEL.
+		c.rewriteCommandVector([]byte("DEL"), key)
+	} else {
+		c.db.SetExpire(key, when)
+		c.srv.dirty++
+
+		// Propagate as PEXPIREAT millisecond-timestamp.
+		argv := [][]byte{[]byte("PEXPIREAT"), key, strconv.AppendInt(nil, when, 10)}
+		c.rewriteCommandVector(append(argv, c.argv[3:]...)...)
+	}
+	c.w.WriteInteger(1)
+}
+
+// EXPIRE key seconds [NX|XX|GT|LT]
+func expireCommand(c *client) {
+	expireGenericCommand(c, c.srv.mstime(), time.Second)
+}
+
+// EXPIREAT key unix-time-seconds [NX|XX|GT|LT]
+func expireatCommand(c *client) {
+	expireGenericCommand(c, 0, time.Second)
+}
+
+// PEXPIRE key milliseconds [NX|XX|GT|LT]
+func pexpireCommand(c *client) {
+	expireGenericCommand(c, c.srv.mstime(), time.Millisecond)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT]
+func pexpireatCommand(c *client) {
+	expireGenericCommand(c, 0, time.Millisecond)
+}
+
+// ttlGenericCommand Implements TTL and PTTL.
+func ttlGenericCommand(c *client, outputMs bool) {
+	ttl := c.db.TTL(c.argv[1])
+	if ttl >= 0 && !outputMs {
+		ttl = (ttl + 500) / 1000
+	}
+	c.w.WriteInteger(ttl)
+}
+
+// TTL key
+func ttlCommand(c *client) {
+	ttlGenericCommand(c, false)
+}
+
+// PTTL key
+func pttlCommand(c *client) {
+	ttlGenericCommand(c, true)
+}
+
+// PERSIST key
+func persistCommand(c *client) {
+	if c.db.Lookup(c.argv[1]) != nil && c.db.RemoveExpire(c.argv[1]) {
+		c.db.NotifyKeyspaceEvent(db.NotifyGeneric, "persist", c.argv[1])
+		c.srv.dirty++
+		c.w.WriteInteger(1)
+	} else {
+		c.w.WriteInteger(0)
+	}
+}
@@ -0,0 +1,38 @@
+module server
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	ae v0.0.0
+	aof v0.0.0
+	db v0.0.0
+	object v0.0.0
+	pubsub v0.0.0
+	rdb v0.0.0
+	resp v0.0.0
+	util v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	ae => ../ae
+	aof => ../aof
+	db => ../db
+	dict => ../dict
+	intset => ../intset
+	listpack => ../listpack
+	lzf => ../lzf
+	object => ../object
+	pubsub => ../pubsub
+	quicklist => ../quicklist
+	rax => ../rax
+	rdb => ../rdb
+	resp => ../resp
+	sds => ../sds
+	skiplist => ../skiplist
+	stream => ../stream
+	util => ../util
+	ziplist => ../ziplist
+	zset => ../zset
+)
@@ -0,0 +1,331 @@
+package server
+
+// The hash commands, a port of t_hash.c of redis. The hashes are
+// listpacks converted to a dict.Dict as they grow.
+
+import (
+	"math"
+	"strconv"
+
+	"db"
+	"object"
+	"util"
+)
+
+// hashTypeLookupWriteOrCreate Return the hash of the key, creating it if
+// the key doesn't exist. Returns nil after replying with an error if the
+// key holds another type.
+func (c *client) hashTypeLookupWriteOrCreate(key []byte) *object.Object {
+	o, err := c.db.LookupType(key, object.TypeHash)
+	if err != nil {
+		c.addReplyErr(err)
+		return nil
+	}
+	if o == nil {
+		o = object.CreateHash()
+		c.db.Add(key, o)
+		o.DecrRefCount()
+	}
+	return o
+}
+
+// lookupHashOrReply Return the hash of the key. Returns nil, after
+// replying with an error if the key holds another type, if the key
+// doesn't exist.
+func (c *client) lookupHashOrReply(key []byte) (*object.Object, bool) {
+	o, err := c.db.LookupType(key, object.TypeHash)
+	if err != nil {
+		c.addReplyErr(err)
+		return nil, false
+	}
+	return o, true
+}
+
+// HSET key field value [field value ...]
+// HMSET key field value [field value ...]
+func hsetCommand(c *client) {
+	if len(c.argv)%2 == 1 {
+		c.addReplyErrorArity()
+		return
+	}
+
+	o := c.hashTypeLookupWriteOrCreate(c.argv[1])
+	if o == nil {
+		return
+	}
+	var created int64
+	for i := 2; i < len(c.argv); i += 2 {
+		if !object.HashSet(o, c.argv[i], c.argv[i+1], c.srv.cfg) {
+			created++
+		}
+	}
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyHash, "hset", c.argv[1])
+	c.srv.dirty += int64(len(c.argv)-2) / 2
+
+	// HMSET (deprecated) and HSET return value is different.
+	if c.cmd.name[1] == 'm' {
+		// HMSET
+		c.w.WriteStatus("OK")
+	} else {
+		// HSET
+		c.w.WriteInteger(created)
+	}
+}
+
+// HSETNX key field value
+func hsetnxCommand(c *client) {
+	o := c.hashTypeLookupWriteOrCreate(c.argv[1])
+	if o == nil {
+		return
+	}
+	if object.HashExists(o, c.argv[2]) {
+		c.w.WriteInteger(0)
+		return
+	}
+	object.HashSet(o, c.argv[2], c.argv[3], c.srv.cfg)
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyHash, "hset", c.argv[1])
+	c.srv.dirty++
+	c.w.WriteInteger(1)
+}
+
+// HGET key field
+func hgetCommand(c *client) {
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	if o != nil {
+		if value, ok := object.HashGet(o, c.argv[2]); ok {
+			c.w.WriteBulk(value)
+			return
+		}
+	}
+	c.w.WriteNull()
+}
+
+// HMGET key field [field ...]
+func hmgetCommand(c *client) {
+	// Don't abort when the key cannot be found. Non-existing keys are
+	// empty hashes, where HMGET should respond with a series of null
+	// bulks.
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	c.w.WriteArrayLen(len(c.argv) - 2)
+	for _, field := range c.argv[2:] {
+		if o != nil {
+			if value, ok := object.HashGet(o, field); ok {
+				c.w.WriteBulk(value)
+				continue
+			}
+		}
+		c.w.WriteNull()
+	}
+}
+
+// HDEL key field [field ...]
+func hdelCommand(c *client) {
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+
+	var (
+		deleted    int64
+		keyremoved bool
+	)
+	for _, field := range c.argv[2:] {
+		if object.HashDelete(o, field) {
+			deleted++
+			if object.HashLen(o) == 0 {
+				keyremoved = true
+				break
+			}
+		}
+	}
+	if deleted > 0 {
+		c.db.SignalModifiedKey(c.argv[1])
+		c.db.NotifyKeyspaceEvent(db.NotifyHash, "hdel", c.argv[1])
+		c.srv.dirty += deleted
+		if keyremoved {
+			c.db.Delete(c.argv[1])
+		}
+	}
+	c.w.WriteInteger(deleted)
+}
+
+// HLEN key
+func hlenCommand(c *client) {
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+	c.w.WriteInteger(int64(object.HashLen(o)))
+}
+
+// HSTRLEN key field
+func hstrlenCommand(c *client) {
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	var length int
+	if o != nil {
+		value, _ := object.HashGet(o, c.argv[2])
+		length = len(value)
+	}
+	c.w.WriteInteger(int64(length))
+}
+
+// HEXISTS key field
+func hexistsCommand(c *client) {
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	if o != nil && object.HashExists(o, c.argv[2]) {
+		c.w.WriteInteger(1)
+	} else {
+		c.w.WriteInteger(0)
+	}
+}
+
+// HINCRBY key field increment
+func hincrbyCommand(c *client) {
+	incr, ok := c.getLongLongOrReply(c.argv[3], "")
+	if !ok {
+		return
+	}
+	o := c.hashTypeLookupWriteOrCreate(c.argv[1])
+	if o == nil {
+		return
+	}
+
+	var value int64
+	if old, ok := object.HashGet(o, c.argv[2]); ok {
+		if value, ok = util.String2ll(old); !ok {
+			c.w.WriteError("hash value is not an integer")
+			return
+		}
+	}
+
+	if incr < 0 && value < 0 && incr < math.MinInt64-value ||
+		incr > 0 && value > 0 && incr > math.MaxInt64-value {
+		c.w.WriteError(errOverflow)
+		return
+	}
+	value += incr
+	object.HashSet(o, c.argv[2], strconv.AppendInt(nil, value, 10), c.srv.cfg)
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyHash, "hincrby", c.argv[1])
+	c.srv.dirty++
+	c.w.WriteInteger(value)
+}
+
+// HINCRBYFLOAT key field increment
+func hincrbyfloatCommand(c *client) {
+	incr, ok := c.getDoubleOrReply(c.argv[3], "")
+	if !ok {
+		return
+	}
+	if math.IsInf(incr, 0) {
+		c.w.WriteError("value is NaN or Infinity")
+		return
+	}
+	o := c.hashTypeLookupWriteOrCreate(c.argv[1])
+	if o == nil {
+		return
+	}
+
+	var value float64
+	if old, ok := object.HashGet(o, c.argv[2]); ok {
+		s := object.CreateString(old)
+		v, err := object.GetDouble(s)
+		s.DecrRefCount()
+		if err != nil {
+			c.w.WriteError("hash value is not a float")
+			return
+		}
+		value = v
+	}
+
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		c.w.WriteError("increment would produce NaN or Infinity")
+		return
+	}
+	n := object.CreateStringFromDouble(value)
+	object.HashSet(o, c.argv[2], object.StringBytes(n), c.srv.cfg)
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyHash, "hincrbyfloat", c.argv[1])
+	c.srv.dirty++
+	c.w.WriteBulk(object.StringBytes(n))
+
+	// Always replicate HINCRBYFLOAT as an HSET command with the final
+	// value in order to make sure that differences in float precision
+	// or formatting will not create differences in replicas or after an
+	// AOF restart.
+	c.rewriteCommandVector([]byte("HSET"), c.argv[1], c.argv[2], object.StringBytes(n))
+	n.DecrRefCount()
+}
+
+// Flags of genericHgetallCommand.
+const (
+	hashKey = 1 << iota
+	hashValue
+)
+
+// genericHgetallCommand Implements HKEYS, HVALS and HGETALL.
+func genericHgetallCommand(c *client, flags int) {
+	o, ok := c.lookupHashOrReply(c.argv[1])
+	if !ok {
+		return
+	}
+	var all [][]byte
+	if o != nil {
+		all = object.HashGetAll(o)
+	}
+
+	// We return a map if the user requested keys and values, like in
+	// the HGETALL case. Otherwise to use a flat array makes more sense.
+	length := len(all) / 2
+	if flags&hashKey != 0 && flags&hashValue != 0 {
+		c.w.WriteMapLen(length)
+	} else {
+		c.w.WriteArrayLen(length)
+	}
+	for i := 0; i < len(all); i += 2 {
+		if flags&hashKey != 0 {
+			c.w.WriteBulk(all[i])
+		}
+		if flags&hashValue != 0 {
+			c.w.WriteBulk(all[i+1])
+		}
+	}
+}
+
+// HKEYS key
+func hkeysCommand(c *client) {
+	genericHgetallCommand(c, hashKey)
+}
+
+// HVALS key
+func hvalsCommand(c *client) {
+	genericHgetallCommand(c, hashValue)
+}
+
+// HGETALL key
+func hgetallCommand(c *client) {
+	genericHgetallCommand(c, hashKey|hashValue)
+}
@@ -0,0 +1,255 @@
+package server
+
+// The keyspace commands, a port of db.c of redis.
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"db"
+)
+
+// SELECT index
+func selectCommand(c *client) {
+	id, ok := c.getLongLongOrReply(c.argv[1], "invalid DB index")
+	if !ok {
+		return
+	}
+	d, err := c.srv.dbs.Select(int(id))
+	if err != nil || int64(int(id)) != id {
+		c.w.WriteError(db.ErrDBIndex.Error())
+		return
+	}
+	c.db = d
+	c.w.WriteStatus("OK")
+}
+
+// DBSIZE
+func dbsizeCommand(c *client) {
+	c.w.WriteInteger(int64(c.db.Size()))
+}
+
+// checkFlushOptions Check the [ASYNC|SYNC] option of FLUSHDB and
+// FLUSHALL. The keys are always freed synchronously.
+func (c *client) checkFlushOptions() bool {
+	if len(c.argv) > 2 {
+		c.w.WriteError(errSyntax)
+		return false
+	}
+	if len(c.argv) == 2 {
+		opt := strings.ToLower(string(c.argv[1]))
+		if opt != "sync" && opt != "async" {
+			c.w.WriteError(errSyntax)
+			return false
+		}
+	}
+	return true
+}
+
+// FLUSHDB [ASYNC|SYNC]
+func flushdbCommand(c *client) {
+	if !c.checkFlushOptions() {
+		return
+	}
+	c.srv.dirty += int64(c.db.Flush())
+	// Without the forceCommandPropagation, when DB was already empty,
+	// FLUSHDB will not be replicated nor put into the AOF.
+	c.forceCommandPropagation()
+	c.w.WriteStatus("OK")
+}
+
+// FLUSHALL [ASYNC|SYNC]
+func flushallCommand(c *client) {
+	if !c.checkFlushOptions() {
+		return
+	}
+	c.srv.dirty += int64(c.srv.dbs.FlushAll())
+	// Without the forceCommandPropagation, when DBs were already empty,
+	// FLUSHALL will not be replicated nor put into the AOF.
+	c.forceCommandPropagation()
+	c.w.WriteStatus("OK")
+}
+
+// SWAPDB index1 index2
+func swapdbCommand(c *client) {
+	// Get the two DBs indexes.
+	id1, ok := c.getLongLongOrReply(c.argv[1], "invalid first DB index")
+	if !ok {
+		return
+	}
+	id2, ok := c.getLongLongOrReply(c.argv[2], "invalid second DB index")
+	if !ok {
+		return
+	}
+
+	// Swap...
+	if int64(int(id1)) != id1 || int64(int(id2)) != id2 ||
+		c.srv.dbs.SwapDB(int(id1), int(id2)) != nil {
+		c.w.WriteError(db.ErrDBIndex.Error())
+		return
+	}
+	c.srv.dirty++
+	c.w.WriteStatus("OK")
+}
+
+// SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE]
+//
+// The server is gracefully shut down, without replying to the client.
+func shutdownCommand(c *client) {
+	for _, arg := range c.argv[1:] {
+		switch strings.ToLower(string(arg)) {
+		case "nosave", "save", "now", "force":
+		default:
+			c.w.WriteError(errSyntax)
+			return
+		}
+	}
+	c.flags |= clientCloseAfterReply
+	// The server lock is held by the command, and the shutdown waits for
+	// this client to be closed.
+	go c.srv.Shutdown(context.Background())
+}
+
+// DEL key [key ...]
+func delCommand(c *client) {
+	var deleted int64
+	for _, key := range c.argv[1:] {
+		if c.db.Delete(key) {
+			deleted++
+		}
+	}
+	c.srv.dirty += deleted
+	c.w.WriteInteger(deleted)
+}
+
+// EXISTS key [key ...]
+func existsCommand(c *client) {
+	var count int64
+	for _, key := range c.argv[1:] {
+		if c.db.Exists(key) {
+			count++
+		}
+	}
+	c.w.WriteInteger(count)
+}
+
+// TYPE key
+func typeCommand(c *client) {
+	c.w.WriteStatus(c.db.Type(c.argv[1]))
+}
+
+// renameGenericCommand Implements RENAME and RENAMENX.
+func renameGenericCommand(c *client, nx bool) {
+	renamed, err := c.db.Rename(c.argv[1], c.argv[2], nx)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if renamed {
+		c.srv.dirty++
+	}
+	if nx {
+		if renamed {
+			c.w.WriteInteger(1)
+		} else {
+			c.w.WriteInteger(0)
+		}
+		return
+	}
+	c.w.WriteStatus("OK")
+}
+
+// RENAME key newkey
+func renameCommand(c *client) {
+	renameGenericCommand(c, false)
+}
+
+// RENAMENX key newkey
+func renamenxCommand(c *client) {
+	renameGenericCommand(c, true)
+}
+
+// RANDOMKEY
+func randomkeyCommand(c *client) {
+	key := c.db.RandomKey()
+	if key == nil {
+		c.w.WriteNull()
+		return
+	}
+	c.w.WriteBulk(key)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func scanCommand(c *client) {
+	// Use strconv.ParseUint for unsigned 64 bit integer.
+	cursor, err := strconv.ParseUint(string(c.argv[1]), 10, 64)
+	if err != nil {
+		c.w.WriteError("invalid cursor")
+		return
+	}
+
+	var (
+		match []byte
+		count int64
+		typ   string
+	)
+	// Step 1: Parse options.
+	for i := 2; i < len(c.argv); i += 2 {
+		j := len(c.argv) - i
+		opt := strings.ToLower(string(c.argv[i]))
+		if opt == "count" && j >= 2 {
+			var ok bool
+			if count, ok = c.getLongLongOrReply(c.argv[i+1], ""); !ok {
+				return
+			}
+			if count < 1 {
+				c.w.WriteError(errSyntax)
+				return
+			}
+		} else if opt == "match" && j >= 2 {
+			match = c.argv[i+1]
+		} else if opt == "type" && j >= 2 {
+			typ = string(c.argv[i+1])
+		} else {
+			c.w.WriteError(errSyntax)
+			return
+		}
+	}
+
+	// Step 2: Iterate the collection, filtering the elements.
+	cursor, keys, err := c.db.Scan(cursor, match, int(count), typ)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+
+	// Step 3: Reply to the client.
+	c.w.WriteArrayLen(2)
+	c.w.WriteBulkString(strconv.FormatUint(cursor, 10))
+	c.addReplyBulks(keys)
+}
+
+// MOVE key db
+func moveCommand(c *client) {
+	// Obtain source and target DB pointers
+	id, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+	if int64(int(id)) != id {
+		c.w.WriteError(db.ErrDBIndex.Error())
+		return
+	}
+	moved, err := c.srv.dbs.Move(c.db, c.argv[1], int(id))
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if moved {
+		c.srv.dirty++
+		c.w.WriteInteger(1)
+	} else {
+		c.w.WriteInteger(0)
+	}
+}
@@ -0,0 +1,319 @@
+package server
+
+// The list commands, a port of t_list.c of redis. The lists are backed by
+// an adlist.List of the elements.
+
+import (
+	"strings"
+
+	"db"
+	"object"
+)
+
+// pushGenericCommand Implements LPUSH/RPUSH/LPUSHX/RPUSHX. 'xx' means
+// the push is done only if the key already exists.
+func pushGenericCommand(c *client, where int, xx bool) {
+	key := c.argv[1]
+	lobj, err := c.db.LookupType(key, object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+
+	if lobj == nil {
+		if xx {
+			c.w.WriteInteger(0)
+			return
+		}
+		lobj = object.CreateLinkedList()
+		c.db.Add(key, lobj)
+		lobj.DecrRefCount()
+	}
+
+	object.ListPush(lobj, where, c.argv[2:], c.srv.cfg)
+	c.db.SignalModifiedKey(key)
+	event := "lpush"
+	if where == object.ListTail {
+		event = "rpush"
+	}
+	c.db.NotifyKeyspaceEvent(db.NotifyList, event, key)
+	c.srv.dirty += int64(len(c.argv) - 2)
+	c.w.WriteInteger(int64(object.ListLen(lobj)))
+}
+
+// LPUSH key element [element ...]
+func lpushCommand(c *client) {
+	pushGenericCommand(c, object.ListHead, false)
+}
+
+// RPUSH key element [element ...]
+func rpushCommand(c *client) {
+	pushGenericCommand(c, object.ListTail, false)
+}
+
+// LPUSHX key element [element ...]
+func lpushxCommand(c *client) {
+	pushGenericCommand(c, object.ListHead, true)
+}
+
+// RPUSHX key element [element ...]
+func rpushxCommand(c *client) {
+	pushGenericCommand(c, object.ListTail, true)
+}
+
+// popGenericCommand Implements the generic LPOP/RPOP with an optional
+// count argument.
+func popGenericCommand(c *client, where int) {
+	hascount := len(c.argv) == 3
+	var count int64
+
+	// Parse the optional count argument.
+	if len(c.argv) > 3 {
+		c.addReplyErrorArity()
+		return
+	} else if hascount {
+		var ok bool
+		if count, ok = c.getLongLongOrReply(c.argv[2], "value is out of range, must be positive"); !ok {
+			return
+		}
+		if count < 0 {
+			c.w.WriteError("value is out of range, must be positive")
+			return
+		}
+	}
+
+	key := c.argv[1]
+	o, err := c.db.LookupType(key, object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		if hascount {
+			c.w.WriteNullArray()
+		} else {
+			c.w.WriteNull()
+		}
+		return
+	}
+
+	if !hascount {
+		// Pop a single element. This is POP's original behavior that
+		// replies with a bulk string.
+		value, _ := object.ListPop(o, where, c.srv.cfg)
+		c.w.WriteBulk(value)
+		c.srv.dirty++
+	} else {
+		// Pop a range of elements. An addition to the original POP
+		// command, which replies with a multi-bulk.
+		if llen := int64(object.ListLen(o)); count > llen {
+			count = llen
+		}
+		c.w.WriteArrayLen(int(count))
+		c.srv.dirty += count
+		for ; count > 0; count-- {
+			value, _ := object.ListPop(o, where, c.srv.cfg)
+			c.w.WriteBulk(value)
+		}
+	}
+
+	c.db.SignalModifiedKey(key)
+	event := "lpop"
+	if where == object.ListTail {
+		event = "rpop"
+	}
+	c.db.NotifyKeyspaceEvent(db.NotifyList, event, key)
+	if object.ListLen(o) == 0 {
+		c.db.Delete(key)
+	}
+}
+
+// LPOP key [count]
+func lpopCommand(c *client) {
+	popGenericCommand(c, object.ListHead)
+}
+
+// RPOP key [count]
+func rpopCommand(c *client) {
+	popGenericCommand(c, object.ListTail)
+}
+
+// LLEN key
+func llenCommand(c *client) {
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+	c.w.WriteInteger(int64(object.ListLen(o)))
+}
+
+// LINDEX key index
+func lindexCommand(c *client) {
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteNull()
+		return
+	}
+	index, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+
+	if value, ok := object.ListIndex(o, int(index)); ok {
+		c.w.WriteBulk(value)
+	} else {
+		c.w.WriteNull()
+	}
+}
+
+// LSET key index element
+func lsetCommand(c *client) {
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteError(errNoSuchKey)
+		return
+	}
+	index, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+
+	if !object.ListSet(o, int(index), c.argv[3], c.srv.cfg) {
+		c.w.WriteError(errOutOfRange)
+		return
+	}
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyList, "lset", c.argv[1])
+	c.srv.dirty++
+	c.w.WriteStatus("OK")
+}
+
+// LRANGE key start stop
+func lrangeCommand(c *client) {
+	start, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+	end, ok := c.getLongLongOrReply(c.argv[3], "")
+	if !ok {
+		return
+	}
+
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteArrayLen(0)
+		return
+	}
+	c.addReplyBulks(object.ListRange(o, int(start), int(end)))
+}
+
+// LINSERT key <BEFORE | AFTER> pivot element
+func linsertCommand(c *client) {
+	var after bool
+	switch strings.ToLower(string(c.argv[2])) {
+	case "after":
+		after = true
+	case "before":
+	default:
+		c.w.WriteError(errSyntax)
+		return
+	}
+
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+
+	if !object.ListInsert(o, c.argv[3], c.argv[4], after, c.srv.cfg) {
+		// Notify client of a failed insert
+		c.w.WriteInteger(-1)
+		return
+	}
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyList, "linsert", c.argv[1])
+	c.srv.dirty++
+	c.w.WriteInteger(int64(object.ListLen(o)))
+}
+
+// LREM key count element
+func lremCommand(c *client) {
+	toremove, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+
+	removed := object.ListRem(o, int(toremove), c.argv[3], c.srv.cfg)
+	if removed > 0 {
+		c.db.SignalModifiedKey(c.argv[1])
+		c.db.NotifyKeyspaceEvent(db.NotifyList, "lrem", c.argv[1])
+		c.srv.dirty += int64(removed)
+	}
+	if object.ListLen(o) == 0 {
+		c.db.Delete(c.argv[1])
+	}
+	c.w.WriteInteger(int64(removed))
+}
+
+// LTRIM key start stop
+func ltrimCommand(c *client) {
+	start, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+	end, ok := c.getLongLongOrReply(c.argv[3], "")
+	if !ok {
+		return
+	}
+
+	o, err := c.db.LookupType(c.argv[1], object.TypeList)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteStatus("OK")
+		return
+	}
+
+	llen := object.ListLen(o)
+	object.ListTrim(o, int(start), int(end), c.srv.cfg)
+	c.db.SignalModifiedKey(c.argv[1])
+	c.db.NotifyKeyspaceEvent(db.NotifyList, "ltrim", c.argv[1])
+	c.srv.dirty += int64(llen - object.ListLen(o))
+	if object.ListLen(o) == 0 {
+		c.db.Delete(c.argv[1])
+	}
+	c.w.WriteStatus("OK")
+}
@@ -0,0 +1,192 @@
+package server
+
+// MULTI/EXEC transactions with the WATCH optimistic locking, a port of
+// multi.c of redis.
+
+import (
+	"bytes"
+
+	"db"
+)
+
+// multiCmd Client MULTI/EXEC state
+type multiCmd struct {
+	argv [][]byte
+	cmd  *command
+}
+
+// initClientMultiState Client state initialization for MULTI/EXEC
+func (c *client) initClientMultiState() {
+	c.mstate = nil
+}
+
+// freeClientMultiState Release all the resources associated with MULTI/EXEC
+// state
+func (c *client) freeClientMultiState() {
+	c.mstate = nil
+}
+
+// queueMultiCommand Add a new command into the MULTI commands queue
+func (c *client) queueMultiCommand() {
+	// No sense to waste memory if the transaction is already aborted.
+	// this is useful in case client sends these in a pipeline, or
+	// doesn't bother to read previous responses and didn't notice the
+	// multi was already aborted.
+	if c.flags&(clientDirtyCAS|clientDirtyExec) != 0 {
+		return
+	}
+	c.mstate = append(c.mstate, multiCmd{argv: c.argv, cmd: c.cmd})
+}
+
+// discardTransaction Abort the transaction, and unwatch all the keys.
+func (c *client) discardTransaction() {
+	c.freeClientMultiState()
+	c.initClientMultiState()
+	c.flags &^= clientMulti | clientDirtyCAS | clientDirtyExec
+	c.unwatchAllKeys()
+}
+
+// flagTransaction Flag the transaction as DIRTY_EXEC so that EXEC will
+// fail. Should be called every time there is an error while queueing a
+// command.
+func (c *client) flagTransaction() {
+	if c.flags&clientMulti != 0 {
+		c.flags |= clientDirtyExec
+	}
+}
+
+// MULTI
+func multiCommand(c *client) {
+	if c.flags&clientMulti != 0 {
+		c.w.WriteError("MULTI calls can not be nested")
+		return
+	}
+	c.flags |= clientMulti
+	c.w.WriteStatus("OK")
+}
+
+// DISCARD
+func discardCommand(c *client) {
+	if c.flags&clientMulti == 0 {
+		c.w.WriteError("DISCARD without MULTI")
+		return
+	}
+	c.discardTransaction()
+	c.w.WriteStatus("OK")
+}
+
+// EXEC
+func execCommand(c *client) {
+	if c.flags&clientMulti == 0 {
+		c.w.WriteError("EXEC without MULTI")
+		return
+	}
+
+	// EXEC with expired watched key is disallowed
+	if c.isWatchedKeyExpired() {
+		c.flags |= clientDirtyCAS
+	}
+
+	// Check if we need to propagate MULTI/EXEC to AOF / slaves, and
+	// abort the transaction:
+	//
+	// 1) Some WATCHed key was touched.
+	// 2) There was a previous error while queueing commands.
+	//
+	// A failed EXEC in the first case returns a multi bulk nil object
+	// (technically it is not an error but a special behavior), while in
+	// the second an EXECABORT error is returned.
+	if c.flags&(clientDirtyCAS|clientDirtyExec) != 0 {
+		if c.flags&clientDirtyExec != 0 {
+			c.w.WriteError("-EXECABORT Transaction discarded because of previous errors.")
+		} else {
+			c.w.WriteNullArray()
+		}
+		c.discardTransaction()
+		return
+	}
+
+	// Exec all the queued commands
+	c.unwatchAllKeys() // Unwatch ASAP otherwise we'll waste CPU cycles
+
+	origArgv, origCmd := c.argv, c.cmd
+	c.w.WriteArrayLen(len(c.mstate))
+	for _, mc := range c.mstate {
+		c.argv, c.cmd = mc.argv, mc.cmd
+		c.call()
+	}
+	c.argv, c.cmd = origArgv, origCmd
+
+	c.discardTransaction()
+}
+
+// watchForKey Watch for the specified key
+func (c *client) watchForKey(key []byte) {
+	// Check if we are already watching for this key
+	iter := c.watchedKeys.Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		wk := ln.Value().(*db.WatchedKey)
+		if wk.DB() == c.db && bytes.Equal(wk.Key(), key) {
+			return // Key already watched
+		}
+	}
+	c.watchedKeys.AddNodeTail(c.db.Watch(key, c))
+}
+
+// unwatchAllKeys Unwatch all the keys watched by this client. To clean
+// the EXEC dirty flag is up to the caller.
+func (c *client) unwatchAllKeys() {
+	iter := c.watchedKeys.Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		ln.Value().(*db.WatchedKey).Unwatch()
+		c.watchedKeys.DelNode(ln)
+	}
+}
+
+// isWatchedKeyExpired Iterates over the watched_keys list and looks for
+// an expired key. Keys which were expired already when WATCH was called
+// are ignored.
+func (c *client) isWatchedKeyExpired() bool {
+	iter := c.watchedKeys.Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		if ln.Value().(*db.WatchedKey).IsExpired() {
+			return true
+		}
+	}
+	return false
+}
+
+// Touched implements db.Watcher: a watched key was modified, the
+// transaction will fail.
+func (c *client) Touched(wk *db.WatchedKey) {
+	c.flags |= clientDirtyCAS
+	// As the client is marked as dirty, there is no point in getting
+	// here again in case that key (or others) are modified again (or
+	// keep the memory overhead till EXEC).
+	c.unwatchAllKeys()
+}
+
+// WATCH key [key ...]
+func watchCommand(c *client) {
+	if c.flags&clientMulti != 0 {
+		c.w.WriteError("WATCH inside MULTI is not allowed")
+		return
+	}
+	// No point in watching if the client is already dirty.
+	if c.flags&clientDirtyCAS != 0 {
+		c.w.WriteStatus("OK")
+		return
+	}
+	for _, key := range c.argv[1:] {
+		c.watchForKey(key)
+	}
+	c.w.WriteStatus("OK")
+}
+
+// UNWATCH
+func unwatchCommand(c *client) {
+	c.unwatchAllKeys()
+	// Clear the dirty flag, as no key is watched anymore.
+	c.flags &^= clientDirtyCAS
+	c.w.WriteStatus("OK")
+}
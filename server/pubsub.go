@@ -0,0 +1,268 @@
+package server
+
+// The Pub/Sub commands, a port of pubsub.c of redis over the pubsub
+// package.
+
+import (
+	"strings"
+
+	"pubsub"
+)
+
+// subscriber Return the subscriber of the client, created on the first
+// subscription along with the goroutine writing the published messages.
+func (c *client) subscriber() *pubsub.Subscriber {
+	if c.sub == nil {
+		c.sub = c.srv.pubsub.CreateSubscriber(c)
+		c.wake = make(chan struct{}, 1)
+		c.writerDone = make(chan struct{})
+		go c.writeMessages()
+	}
+	return c.sub
+}
+
+// writeMessages Write the published messages added to the reply buffer,
+// until the client is freed.
+func (c *client) writeMessages() {
+	defer close(c.writerDone)
+	for range c.wake {
+		if err := c.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// subscriptionsCount Return the number of channels + patterns a client
+// is subscribed to.
+func (c *client) subscriptionsCount() int {
+	if c.sub == nil {
+		return 0
+	}
+	return c.sub.Count()
+}
+
+// shardSubscriptionsCount Return the number of shard level channels a
+// client is subscribed to.
+func (c *client) shardSubscriptionsCount() int {
+	if c.sub == nil {
+		return 0
+	}
+	return c.sub.ShardCount()
+}
+
+// updatePubSubFlag Set the client in Pub/Sub mode while it has
+// subscriptions.
+func (c *client) updatePubSubFlag() {
+	if c.subscriptionsCount()+c.shardSubscriptionsCount() > 0 {
+		c.flags |= clientPubSub
+	} else {
+		c.flags &^= clientPubSub
+	}
+}
+
+// addReplyPubsubSubscribed Send the subscription or unsubscription
+// confirmation, a push reply of the kind, the channel or pattern (nil
+// when there was nothing to unsubscribe from) and the count.
+func (c *client) addReplyPubsubSubscribed(kind string, channel []byte, count int) {
+	c.w.WritePushLen(3)
+	c.w.WriteBulkString(kind)
+	if channel == nil {
+		c.w.WriteNull()
+	} else {
+		c.w.WriteBulk(channel)
+	}
+	c.w.WriteInteger(int64(count))
+}
+
+// Receive implements pubsub.Receiver: the message is added to the reply
+// buffer, and written by the writer goroutine. The subscriber is slow
+// when its pending replies exceed the limit.
+func (c *client) Receive(m *pubsub.Message) bool {
+	switch m.Kind {
+	case pubsub.KindPMessage:
+		c.w.WritePushLen(4)
+		c.w.WriteBulkString(m.Kind)
+		c.w.WriteBulk(m.Pattern)
+		c.w.WriteBulk(m.Channel)
+		c.w.WriteBulk(m.Payload)
+	case pubsub.KindSUnsubscribe:
+		c.addReplyPubsubSubscribed(m.Kind, m.Channel, c.shardSubscriptionsCount())
+		c.updatePubSubFlag()
+	default:
+		c.w.WritePushLen(3)
+		c.w.WriteBulkString(m.Kind)
+		c.w.WriteBulk(m.Channel)
+		c.w.WriteBulk(m.Payload)
+	}
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return c.w.Len() <= c.srv.pubsubBufferLimit
+}
+
+// Close implements pubsub.Receiver: a slow subscriber is disconnected.
+func (c *client) Close() {
+	c.conn.Close()
+}
+
+// SUBSCRIBE channel [channel ...]
+func subscribeCommand(c *client) {
+	sub := c.subscriber()
+	for _, channel := range c.argv[1:] {
+		sub.Subscribe(channel)
+		c.addReplyPubsubSubscribed("subscribe", channel, sub.Count())
+	}
+	c.updatePubSubFlag()
+}
+
+// UNSUBSCRIBE [channel [channel ...]]
+func unsubscribeCommand(c *client) {
+	var channels [][]byte
+	if len(c.argv) == 1 {
+		if c.sub != nil {
+			channels = c.sub.UnsubscribeAll()
+		}
+	} else {
+		channels = c.argv[1:]
+	}
+	for _, channel := range channels {
+		if c.sub != nil {
+			c.sub.Unsubscribe(channel)
+		}
+		c.addReplyPubsubSubscribed("unsubscribe", channel, c.subscriptionsCount())
+	}
+	// We were subscribed to nothing? Still reply to the client.
+	if len(channels) == 0 {
+		c.addReplyPubsubSubscribed("unsubscribe", nil, c.subscriptionsCount())
+	}
+	c.updatePubSubFlag()
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func psubscribeCommand(c *client) {
+	sub := c.subscriber()
+	for _, pattern := range c.argv[1:] {
+		sub.PSubscribe(pattern)
+		c.addReplyPubsubSubscribed("psubscribe", pattern, sub.Count())
+	}
+	c.updatePubSubFlag()
+}
+
+// PUNSUBSCRIBE [pattern [pattern ...]]
+func punsubscribeCommand(c *client) {
+	var patterns [][]byte
+	if len(c.argv) == 1 {
+		if c.sub != nil {
+			patterns = c.sub.PUnsubscribeAll()
+		}
+	} else {
+		patterns = c.argv[1:]
+	}
+	for _, pattern := range patterns {
+		if c.sub != nil {
+			c.sub.PUnsubscribe(pattern)
+		}
+		c.addReplyPubsubSubscribed("punsubscribe", pattern, c.subscriptionsCount())
+	}
+	// We were subscribed to nothing? Still reply to the client.
+	if len(patterns) == 0 {
+		c.addReplyPubsubSubscribed("punsubscribe", nil, c.subscriptionsCount())
+	}
+	c.updatePubSubFlag()
+}
+
+// PUBLISH channel message
+func publishCommand(c *client) {
+	receivers := c.srv.pubsub.Publish(c.argv[1], c.argv[2])
+	c.w.WriteInteger(int64(receivers))
+}
+
+// SSUBSCRIBE shardchannel [shardchannel ...]
+func ssubscribeCommand(c *client) {
+	sub := c.subscriber()
+	for _, channel := range c.argv[1:] {
+		sub.SSubscribe(channel)
+		c.addReplyPubsubSubscribed("ssubscribe", channel, sub.ShardCount())
+	}
+	c.updatePubSubFlag()
+}
+
+// SUNSUBSCRIBE [shardchannel [shardchannel ...]]
+func sunsubscribeCommand(c *client) {
+	var channels [][]byte
+	if len(c.argv) == 1 {
+		if c.sub != nil {
+			channels = c.sub.SUnsubscribeAll()
+		}
+	} else {
+		channels = c.argv[1:]
+	}
+	for _, channel := range channels {
+		if c.sub != nil {
+			c.sub.SUnsubscribe(channel)
+		}
+		c.addReplyPubsubSubscribed("sunsubscribe", channel, c.shardSubscriptionsCount())
+	}
+	// We were subscribed to nothing? Still reply to the client.
+	if len(channels) == 0 {
+		c.addReplyPubsubSubscribed("sunsubscribe", nil, c.shardSubscriptionsCount())
+	}
+	c.updatePubSubFlag()
+}
+
+// SPUBLISH shardchannel message
+func spublishCommand(c *client) {
+	receivers := c.srv.pubsub.SPublish(c.argv[1], c.argv[2])
+	c.w.WriteInteger(int64(receivers))
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT |
+// SHARDCHANNELS [pattern] | SHARDNUMSUB [shardchannel ...] | HELP
+func pubsubCommand(c *client) {
+	ps := c.srv.pubsub
+	sub := strings.ToLower(string(c.argv[1]))
+	switch {
+	case len(c.argv) == 2 && sub == "help":
+		c.w.WriteHelp("PUBSUB",
+			"CHANNELS [<pattern>]",
+			"    Return the currently active channels matching a <pattern> (default: '*').",
+			"NUMPAT",
+			"    Return number of subscriptions to patterns.",
+			"NUMSUB [<channel> ...]",
+			"    Return the number of subscribers for the specified channels, excluding",
+			"    pattern subscriptions(default: no channels).",
+			"SHARDCHANNELS [<pattern>]",
+			"    Return the currently active shard level channels matching a <pattern> (default: '*').",
+			"SHARDNUMSUB [<shardchannel> ...]",
+			"    Return the number of subscribers for the specified shard level channel(s)")
+	case (sub == "channels" || sub == "shardchannels") && len(c.argv) <= 3:
+		// PUBSUB CHANNELS [<pattern>]
+		var pattern []byte
+		if len(c.argv) == 3 {
+			pattern = c.argv[2]
+		}
+		if sub == "channels" {
+			c.addReplyBulks(ps.Channels(pattern))
+		} else {
+			c.addReplyBulks(ps.ShardChannels(pattern))
+		}
+	case sub == "numsub" || sub == "shardnumsub":
+		// PUBSUB NUMSUB [Channel_1 ... Channel_N]
+		c.w.WriteArrayLen((len(c.argv) - 2) * 2)
+		for _, channel := range c.argv[2:] {
+			c.w.WriteBulk(channel)
+			if sub == "numsub" {
+				c.w.WriteInteger(int64(ps.NumSub(channel)))
+			} else {
+				c.w.WriteInteger(int64(ps.ShardNumSub(channel)))
+			}
+		}
+	case sub == "numpat" && len(c.argv) == 2:
+		// PUBSUB NUMPAT
+		c.w.WriteInteger(int64(ps.NumPat()))
+	default:
+		c.addReplySubcommandSyntaxError()
+	}
+}
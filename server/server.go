@@ -0,0 +1,396 @@
+package server
+
+// A RESP server, a port of the networking and command dispatching of
+// server.c and networking.c of redis.
+//
+// Every client is served by its own goroutine, reading the queries into
+// a per-client query buffer and writing the replies once all the
+// pipelined commands of the buffer are processed. The commands are
+// executed one at a time while holding the server lock, so that the
+// keyspace sees the commands in a total order, as in the single threaded
+// redis.
+//
+// The messages published to the channels a client is subscribed to are
+// added to its reply buffer by the publishing client, and written by a
+// goroutine of the subscribed client.
+//
+// The background jobs on the keyspace, like the active expire of the keys
+// and the incremental rehashing of the hash tables, are run by the
+// serverCron time event of an ae event loop, 'hz' times per second.
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"adlist"
+	"ae"
+	"aof"
+	"db"
+	"object"
+	"pubsub"
+	"resp"
+)
+
+// Error
+var (
+	// ErrServerClosed Serve returns this error after Shutdown or Close.
+	ErrServerClosed = errors.New("server: Server closed")
+)
+
+const (
+	// Version The redis version implemented, as reported by HELLO.
+	Version = "7.2.0"
+	// MaxQueryBufLen Default max length of the query buffer of a client,
+	// like client-query-buffer-limit.
+	MaxQueryBufLen = 1024 * 1024 * 1024
+	// ioBufLen Generic I/O buffer size.
+	ioBufLen = 1024 * 16
+	// DefaultHz Default number of serverCron calls per second.
+	DefaultHz = 10
+	// MaxHz Max number of serverCron calls per second.
+	MaxHz = 500
+	// configFdsetIncr The set size of the event loop, no file events are
+	// registered.
+	configFdsetIncr = 128
+	// activeExpireCycleSlowTimePerc Max % of the CPU time of the cron
+	// used by the active expire cycle.
+	activeExpireCycleSlowTimePerc = 25
+	// PubSubBufferLimit Default max length of the pending replies of a
+	// subscribed client, like the hard limit of client-output-buffer-limit
+	// pubsub.
+	PubSubBufferLimit = 32 * 1024 * 1024
+)
+
+// Server a RESP server.
+type Server struct {
+	// mu serializes the execution of the commands.
+	mu sync.Mutex
+
+	dbs      *db.Server
+	commands map[string]*command
+	cfg      *object.Config
+	// Channels, patterns and shard channels with their subscribers
+	pubsub *pubsub.PubSub
+	// Classes of the keyspace events published, the db.Notify* flags.
+	notifyKeyspaceEvents int
+
+	// List of active clients
+	clients      *adlist.List
+	nextClientID uint64
+	listeners    map[net.Listener]struct{}
+	shutdown     bool
+	wg           sync.WaitGroup
+
+	// The event loop running serverCron, started by the first Serve.
+	el *ae.EventLoop
+	// Number of times the cron function run
+	cronloops int64
+
+	// Changes to DB from the last save
+	dirty int64
+	// The append only file, nil if disabled.
+	aof *aof.AOF
+	// Additional command to propagate.
+	alsoPropagateOps []propagateOp
+	// We are loading data from disk if true
+	loading bool
+
+	readOnly       bool
+	maxBulkLen     int64
+	maxQueryBufLen int
+	// The subscribed clients exceeding this limit are disconnected.
+	pubsubBufferLimit int
+	databases         int
+	hz                int
+	now               func() time.Time
+}
+
+// Option opt.
+type Option func(s *Server)
+
+// WithDatabases The number of databases, db.Databases by default.
+func WithDatabases(n int) Option {
+	return func(s *Server) {
+		s.databases = n
+	}
+}
+
+// WithClock The 'now' is used to expire the keys, time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithObjectConfig The thresholds of the compact encodings of the values,
+// object.DefaultConfig if nil.
+func WithObjectConfig(cfg *object.Config) Option {
+	return func(s *Server) {
+		s.cfg = cfg
+	}
+}
+
+// WithHz The number of serverCron calls per second, from 1 to MaxHz,
+// DefaultHz by default.
+func WithHz(hz int) Option {
+	return func(s *Server) {
+		s.hz = hz
+	}
+}
+
+// WithReadOnly Reject the write commands, like a read only replica.
+func WithReadOnly(readOnly bool) Option {
+	return func(s *Server) {
+		s.readOnly = readOnly
+	}
+}
+
+// WithMaxBulkLen The max length of a bulk string of the requests, like
+// proto-max-bulk-len, resp.MaxBulkLen by default.
+func WithMaxBulkLen(n int64) Option {
+	return func(s *Server) {
+		s.maxBulkLen = n
+	}
+}
+
+// WithMaxQueryBufLen The max length of the query buffer of a client, the
+// client is closed when exceeded. MaxQueryBufLen by default.
+func WithMaxQueryBufLen(n int) Option {
+	return func(s *Server) {
+		s.maxQueryBufLen = n
+	}
+}
+
+// WithPubSubBufferLimit The max length of the pending replies of a
+// subscribed client, the client is disconnected when exceeded.
+// PubSubBufferLimit by default.
+func WithPubSubBufferLimit(n int) Option {
+	return func(s *Server) {
+		s.pubsubBufferLimit = n
+	}
+}
+
+// WithNotifyKeyspaceEvents The classes of the keyspace events published
+// to the __keyspace@<db>__ and __keyevent@<db>__ channels, the db.Notify*
+// flags, like notify-keyspace-events. None by default.
+func WithNotifyKeyspaceEvents(classes int) Option {
+	return func(s *Server) {
+		s.notifyKeyspaceEvents = classes
+	}
+}
+
+// WithAppendOnly Log the write commands to the AOF, like appendonly yes.
+// The AOF should be loaded with LoadAppendOnlyFile before serving, and
+// closed by the caller once the server is closed.
+func WithAppendOnly(a *aof.AOF) Option {
+	return func(s *Server) {
+		s.aof = a
+	}
+}
+
+// Create a new server.
+func Create(opts ...Option) *Server {
+	s := &Server{
+		clients:           adlist.ListCreate(),
+		listeners:         make(map[net.Listener]struct{}),
+		maxBulkLen:        resp.MaxBulkLen,
+		maxQueryBufLen:    MaxQueryBufLen,
+		pubsubBufferLimit: PubSubBufferLimit,
+		databases:         db.Databases,
+		hz:                DefaultHz,
+		now:               time.Now,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+	if s.hz < 1 {
+		s.hz = 1
+	}
+	if s.hz > MaxHz {
+		s.hz = MaxHz
+	}
+	s.pubsub = pubsub.Create(pubsub.WithSlowPolicy(pubsub.SlowDisconnect))
+	s.dbs = db.Create(db.WithDatabases(s.databases), db.WithClock(s.now),
+		db.WithPubSub(s.pubsub), db.WithNotifyKeyspaceEvents(s.notifyKeyspaceEvents))
+	if s.aof != nil {
+		s.dbs.SubscribeKeyspaceEvents(db.NotifyExpired, s.propagateExpire)
+	}
+	s.populateCommandTable()
+	return s
+}
+
+// DB Return the database 'id', to access the keyspace directly. The
+// server must not be serving clients at the same time.
+func (s *Server) DB(id int) (*db.DB, error) {
+	return s.dbs.Select(id)
+}
+
+// ListenAndServe Listen on the network address, "tcp" or "unix", and
+// serve the clients, see Serve.
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve Accept the clients on the listener, creating a goroutine for
+// every client. Serve always returns a non nil error and closes 'l',
+// ErrServerClosed after Shutdown or Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.el == nil {
+		if err := s.startCron(); err != nil {
+			s.mu.Unlock()
+			l.Close()
+			return err
+		}
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			shutdown := s.shutdown
+			s.mu.Unlock()
+			if shutdown {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.shutdown {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		c := s.createClient(conn)
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// closeListeners Stop accepting the clients, the server lock is held.
+func (s *Server) closeListeners() {
+	s.shutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+}
+
+// Shutdown Gracefully shut down the server: the listeners are closed,
+// then the clients are closed once the commands already received are
+// executed and replied. If the context expires first, the remaining
+// clients are closed and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closeListeners()
+	// Interrupt the reads of the clients waiting for commands.
+	iter := s.clients.Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		ln.Value().(*client).conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close Immediately close the listeners and the clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeListeners()
+	iter := s.clients.Rewind()
+	for ln := iter.Next(); ln != nil; ln = iter.Next() {
+		ln.Value().(*client).conn.Close()
+	}
+	return nil
+}
+
+// startCron Start the event loop running serverCron. The server lock is
+// held.
+func (s *Server) startCron() error {
+	el, err := ae.Create(configFdsetIncr)
+	if err != nil {
+		return err
+	}
+	s.el = el
+	el.CreateTimeEvent(1, s.serverCron, nil, nil)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		el.Main()
+		el.Close()
+	}()
+	return nil
+}
+
+// serverCron This is our timer interrupt, called server.hz times per
+// second. Here is where we do a number of things that need to be done
+// asynchronously:
+//
+//   - Active expired keys collection (it is also performed in a lazy way on
+//     lookup).
+//   - Incremental rehashing of the hash tables of the databases.
+//   - Flush of the AOF buffer on write errors, and rewrite of the AOF
+//     when it grew enough.
+//
+// The event loop is stopped once the server is shut down.
+func (s *Server) serverCron(el *ae.EventLoop, id int64, clientData interface{}) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shutdown {
+		el.Stop()
+		return ae.NoMore
+	}
+
+	// Handle background operations on Redis databases.
+	s.dbs.DatabasesCron(time.Second * activeExpireCycleSlowTimePerc / time.Duration(s.hz) / 100)
+	s.propagatePendingCommands()
+
+	// AOF write errors, background fsync and rewrite.
+	s.aofCron()
+
+	s.cronloops++
+	return 1000 / s.hz
+}
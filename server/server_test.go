@@ -0,0 +1,984 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aof"
+	"db"
+	"object"
+)
+
+// testClient a client of the server under test.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer Start serving on a loopback address, the server is shut
+// down at the end of the test.
+func startServer(t *testing.T, opts ...Option) (*Server, string) {
+	t.Helper()
+	s := Create(opts...)
+	return s, serve(t, s)
+}
+
+// serve Serve on a loopback address, the server is closed at the end of
+// the test. Returns the address.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, network, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encode Encode the command as a multibulk request.
+func encode(args ...string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return sb.String()
+}
+
+// readReply Read a reply, rendered as in redis-cli, the arrays as
+// [a b c], the maps as {k v} and the push replies as >[a b c].
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty line")
+	}
+
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return "(error) " + payload, nil
+	case ':':
+		return "(integer) " + payload, nil
+	case ',':
+		return "(double) " + payload, nil
+	case '_':
+		return "(nil)", nil
+	case '$':
+		n, _ := strconv.Atoi(payload)
+		if n < 0 {
+			return "(nil)", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	case '*', '%', '~', '>':
+		n, _ := strconv.Atoi(payload)
+		if n < 0 {
+			return "(nil)", nil
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		elems := make([]string, n)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return "", err
+			}
+		}
+		switch line[0] {
+		case '%':
+			return "{" + strings.Join(elems, " ") + "}", nil
+		case '>':
+			return ">[" + strings.Join(elems, " ") + "]", nil
+		}
+		return "[" + strings.Join(elems, " ") + "]", nil
+	}
+	return "", fmt.Errorf("unknown reply %q", line)
+}
+
+// do Send the command and return the reply.
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, encode(args...)); err != nil {
+		c.t.Fatal(err)
+	}
+	reply, err := readReply(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+// read Read a reply, not sent in response to a command.
+func (c *testClient) read() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+	reply, err := readReply(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+// check Send the commands and check the replies, 'cmds' are pairs of a
+// space separated command and the expected reply.
+func (c *testClient) check(cmds ...string) {
+	c.t.Helper()
+	for i := 0; i < len(cmds); i += 2 {
+		if got := c.do(strings.Fields(cmds[i])...); got != cmds[i+1] {
+			c.t.Fatalf("%s: got %q, want %q", cmds[i], got, cmds[i+1])
+		}
+	}
+}
+
+func TestConnection(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, "tcp", addr)
+
+	c.check(
+		"PING", "PONG",
+		"ping hello", "hello",
+		"ping a b", "(error) ERR wrong number of arguments for 'ping' command",
+		"echo", "(error) ERR wrong number of arguments for 'echo' command",
+		"echo hi", "hi",
+		"foo bar baz", "(error) ERR unknown command 'foo', with args beginning with: 'bar' 'baz' ",
+		"select 16", "(error) ERR DB index is out of range",
+		"select x", "(error) ERR invalid DB index",
+		"command count", "(integer) "+strconv.Itoa(len(commandTable)),
+		"command info get nosuch", "[[get (integer) 2 [readonly fast] (integer) 1 (integer) 1 (integer) 1] (nil)]",
+		"hello 4", "(error) NOPROTO unsupported protocol version",
+		"hello 3 setname foo", "{server redis version 7.2.0 proto (integer) 3 id (integer) 1 mode standalone role master modules []}",
+		"hgetall nokey", "{}",
+		"hello 2", "[server redis version 7.2.0 proto (integer) 2 id (integer) 1 mode standalone role master modules []]",
+		"quit", "OK",
+	)
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection not closed after QUIT: %v", err)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, "tcp", addr)
+
+	c.check(
+		"set foo bar", "OK",
+		"get foo", "bar",
+		"get nokey", "(nil)",
+		"set foo baz nx", "(nil)",
+		"set foo baz xx get", "bar",
+		"set foo x nx xx", "(error) ERR syntax error",
+		"set foo x ex 0", "(error) ERR invalid expire time in 'set' command",
+		"setnx foo x", "(integer) 0",
+		"setnx new x", "(integer) 1",
+		"append new yz", "(integer) 3",
+		"strlen new", "(integer) 3",
+		"getset new v", "xyz",
+		"getdel new", "v",
+		"exists new", "(integer) 0",
+		"mset a 1 b 2", "OK",
+		"mset a 1 b", "(error) ERR wrong number of arguments for 'mset' command",
+		"msetnx a 1 c 3", "(integer) 0",
+		"mget a b c", "[1 2 (nil)]",
+		"incr a", "(integer) 2",
+		"incrby a 10", "(integer) 12",
+		"decrby a 20", "(integer) -8",
+		"decr counter", "(integer) -1",
+		"incr foo", "(error) ERR value is not an integer or out of range",
+		"set big 9223372036854775807", "OK",
+		"incr big", "(error) ERR increment or decrement would overflow",
+		"incrbyfloat f 10.5", "10.5",
+		"incrbyfloat f 0.1", "10.6",
+		"incrbyfloat f 5.0e3", "5010.6",
+		"append a 0", "(integer) 3",
+		"get a", "-80",
+	)
+}
+
+func TestLists(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, "tcp", addr)
+
+	c.check(
+		"rpush l a b c", "(integer) 3",
+		"lpush l z", "(integer) 4",
+		"lrange l 0 -1", "[z a b c]",
+		"lpushx nolist a", "(integer) 0",
+		"lindex l -1", "c",
+		"lindex l 10", "(nil)",
+		"lset l 1 A", "OK",
+		"lset l 10 A", "(error) ERR index out of range",
+		"lset nolist 0 A", "(error) ERR no such key",
+		"linsert l before b B", "(integer) 5",
+		"linsert l after nopivot x", "(integer) -1",
+		"linsert l middle b x", "(error) ERR syntax error",
+		"lrange l 1 3", "[A B b]",
+		"rpush l A A", "(integer) 7",
+		"lrem l -2 A", "(integer) 2",
+		"lrange l 0 -1", "[z A B b c]",
+		"ltrim l 1 -2", "OK",
+		"llen l", "(integer) 3",
+		"lpop l", "A",
+		"rpop l 5", "[b B]",
+		"exists l", "(integer) 0",
+		"lpop l 2", "(nil)",
+		"lpop l -1", "(error) ERR value is out of range, must be positive",
+		"set s x", "OK",
+		"lpush s a", "(error) WRONGTYPE Operation against a key holding the wrong kind of value",
+		"rpush l x", "(integer) 1",
+		"type l", "list",
+	)
+
+	s.mu.Lock()
+	d, _ := s.DB(0)
+	if enc := d.Lookup([]byte("l")).Encoding(); enc != object.EncodingLinkedlist {
+		t.Fatalf("list encoding %s", object.EncodingName(enc))
+	}
+	s.mu.Unlock()
+}
+
+func TestHashes(t *testing.T) {
+	s, addr := startServer(t, WithObjectConfig(&object.Config{
+		HashMaxListpackEntries: 4,
+		HashMaxListpackValue:   8,
+	}))
+	c := dial(t, "tcp", addr)
+
+	c.check(
+		"hset h a 1 b 2", "(integer) 2",
+		"hset h a 3", "(integer) 0",
+		"hset h a", "(error) ERR wrong number of arguments for 'hset' command",
+		"hmset h c 4", "OK",
+		"hsetnx h c 5", "(integer) 0",
+		"hget h a", "3",
+		"hget h nofield", "(nil)",
+		"hmget h a nofield c", "[3 (nil) 4]",
+		"hlen h", "(integer) 3",
+		"hstrlen h a", "(integer) 1",
+		"hexists h b", "(integer) 1",
+		"hincrby h a 10", "(integer) 13",
+		"hincrbyfloat h b 0.5", "2.5",
+		"hincrby h b 1", "(error) ERR hash value is not an integer",
+		"hgetall h", "[a 13 b 2.5 c 4]",
+		"hkeys h", "[a b c]",
+		"hvals h", "[13 2.5 4]",
+		"hdel h a nofield", "(integer) 1",
+		"hset h d 1 e 2 f 3", "(integer) 3",
+		"hlen h", "(integer) 5",
+		"hdel h b c d e f", "(integer) 5",
+		"exists h", "(integer) 0",
+		"set s x", "OK",
+		"hget s a", "(error) WRONGTYPE Operation against a key holding the wrong kind of value",
+	)
+
+	// The hash is converted to a dict when it grows.
+	c.check("hset h a 1 b 2 c 3 d 4 e 5", "(integer) 5")
+	s.mu.Lock()
+	d, _ := s.DB(0)
+	if enc := d.Lookup([]byte("h")).Encoding(); enc != object.EncodingHT {
+		t.Fatalf("hash encoding %s", object.EncodingName(enc))
+	}
+	s.mu.Unlock()
+	c.check("hget h e", "5", "hlen h", "(integer) 5")
+}
+
+// fakeClock a clock advanced by the tests.
+type fakeClock struct {
+	ns int64
+}
+
+func (c *fakeClock) now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.ns))
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	atomic.AddInt64(&c.ns, int64(d))
+}
+
+func TestKeyspace(t *testing.T) {
+	clock := &fakeClock{ns: int64(1000 * time.Second)}
+	_, addr := startServer(t, WithClock(clock.now))
+	c := dial(t, "tcp", addr)
+
+	c.check(
+		"set a 1", "OK",
+		"set b 2", "OK",
+		"dbsize", "(integer) 2",
+		"exists a b c a", "(integer) 3",
+		"type a", "string",
+		"type c", "none",
+		"rename a c", "OK",
+		"rename a c", "(error) ERR no such key",
+		"renamenx b c", "(integer) 0",
+		"move c 1", "(integer) 1",
+		"move b 0", "(error) ERR source and destination objects are the same",
+		"move b 16", "(error) ERR DB index is out of range",
+		"del b c", "(integer) 1",
+		"randomkey", "(nil)",
+		"select 1", "OK",
+		"randomkey", "c",
+		"swapdb 0 1", "OK",
+		"dbsize", "(integer) 0",
+		"swapdb 0 x", "(error) ERR invalid second DB index",
+		"select 0", "OK",
+		"get c", "1",
+		"mset k1 1 k2 2 k3 3 x 4", "OK",
+		"rpush l a", "(integer) 1",
+		"scan 0 count 100 match k[2x] type string", "[0 [k2]]",
+		"scan 0 type list", "[0 [l]]",
+		"scan 0 type nosuch", "(error) ERR unknown type name",
+		"scan 0 count 0", "(error) ERR syntax error",
+		"scan x", "(error) ERR invalid cursor",
+		"flushdb", "OK",
+		"dbsize", "(integer) 0",
+	)
+
+	c.check(
+		"set a 1", "OK",
+		"ttl a", "(integer) -1",
+		"ttl nokey", "(integer) -2",
+		"expire a 10", "(integer) 1",
+		"ttl a", "(integer) 10",
+		"expire a 5 gt", "(integer) 0",
+		"expire a 5 lt", "(integer) 1",
+		"expire a 20 nx", "(integer) 0",
+		"expire a 20 nx xx", "(error) ERR NX and XX, GT or LT options at the same time are not compatible",
+		"expire a 20 gt lt", "(error) ERR GT and LT options at the same time are not compatible",
+		"expire a 20 foo", "(error) ERR Unsupported option foo",
+		"expire a 9223372036854775807", "(error) ERR invalid expire time in 'expire' command",
+		"pexpire a 1500", "(integer) 1",
+		"pttl a", "(integer) 1500",
+		"ttl a", "(integer) 2",
+		"expire nokey 10", "(integer) 0",
+		"set b 1 px 100", "OK",
+		"persist b", "(integer) 1",
+		"persist b", "(integer) 0",
+		"set c 1 keepttl", "OK",
+		"setex c 1 v", "OK",
+		"set d 1 exat 2000", "OK",
+		"ttl d", "(integer) 1000",
+	)
+	clock.advance(1501 * time.Millisecond)
+	c.check(
+		"get a", "(nil)",
+		"get c", "(nil)",
+		"get b", "1",
+		"pexpireat b 1000", "(integer) 1",
+		"exists b", "(integer) 0",
+		"flushall", "OK",
+		"flushall async", "OK",
+		"flushall foo", "(error) ERR syntax error",
+	)
+}
+
+func TestPipelining(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, "tcp", addr)
+
+	// Many commands in a single write, including an inline command and
+	// a command split across the writes.
+	var req strings.Builder
+	for i := 0; i < 1000; i++ {
+		req.WriteString(encode("rpush", "l", strconv.Itoa(i)))
+	}
+	req.WriteString("llen l\r\n")
+	req.WriteString(encode("lindex", "l", "-1")[:10])
+	if _, err := io.WriteString(c.conn, req.String()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if reply, err := readReply(c.r); err != nil || reply != fmt.Sprintf("(integer) %d", i+1) {
+			t.Fatalf("reply %d: %q %v", i, reply, err)
+		}
+	}
+	if reply, _ := readReply(c.r); reply != "(integer) 1000" {
+		t.Fatalf("inline reply %q", reply)
+	}
+	io.WriteString(c.conn, encode("lindex", "l", "-1")[10:])
+	if reply, _ := readReply(c.r); reply != "999" {
+		t.Fatalf("split reply %q", reply)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	_, addr := startServer(t, WithMaxBulkLen(16))
+	c := dial(t, "tcp", addr)
+
+	io.WriteString(c.conn, encode("ping")+"*1\r\n$17\r\n")
+	if reply, _ := readReply(c.r); reply != "PONG" {
+		t.Fatalf("reply %q", reply)
+	}
+	if reply, _ := readReply(c.r); reply != "(error) ERR Protocol error: invalid bulk length" {
+		t.Fatalf("reply %q", reply)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection not closed after a protocol error: %v", err)
+	}
+}
+
+func TestMaxQueryBufLen(t *testing.T) {
+	_, addr := startServer(t, WithMaxQueryBufLen(1024))
+	c := dial(t, "tcp", addr)
+
+	// A bulk never completed, the client is closed once the query
+	// buffer exceeds the limit.
+	go io.WriteString(c.conn, "*1\r\n$100000\r\n"+strings.Repeat("x", 4096))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection not closed: %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	_, addr := startServer(t, WithReadOnly(true))
+	c := dial(t, "tcp", addr)
+
+	c.check(
+		"set a 1", "(error) READONLY You can't write against a read only replica.",
+		"get a", "(nil)",
+	)
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "redis.sock")
+
+	s := Create()
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe("unix", path) }()
+
+	var c *testClient
+	for i := 0; ; i++ {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			c = &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer c.conn.Close()
+	c.check("set a 1", "OK", "get a", "1")
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s, addr := startServer(t)
+	c1 := dial(t, "tcp", addr)
+	c2 := dial(t, "tcp", addr)
+	c1.check("set a 1", "OK")
+	c2.check("ping", "PONG")
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*testClient{c1, c2} {
+		if _, err := c.r.ReadByte(); err != io.EOF {
+			t.Fatalf("connection not closed: %v", err)
+		}
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("listener not closed")
+	}
+
+	// The keyspace survives the shutdown.
+	d, _ := s.DB(0)
+	if !d.Exists([]byte("a")) {
+		t.Fatal("key lost")
+	}
+}
+
+func TestShutdownCommand(t *testing.T) {
+	s := Create()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	c := dial(t, "tcp", l.Addr().String())
+	c.check("shutdown foo", "(error) ERR syntax error")
+	io.WriteString(c.conn, encode("shutdown", "nosave"))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestServerCron(t *testing.T) {
+	clock := &fakeClock{ns: int64(1000 * time.Second)}
+	s, addr := startServer(t, WithClock(clock.now), WithHz(100))
+	c := dial(t, "tcp", addr)
+
+	for i := 0; i < 100; i++ {
+		c.check(fmt.Sprintf("set k%d v px 100", i), "OK")
+	}
+	c.check("set persistent v", "OK", "dbsize", "(integer) 101")
+
+	// The keys are expired by the cron, without being accessed.
+	clock.advance(time.Second)
+	for i := 0; c.do("dbsize") != "(integer) 1"; i++ {
+		if i == 200 {
+			t.Fatal("keys not expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	if s.cronloops == 0 {
+		t.Fatal("cron not running")
+	}
+	s.mu.Unlock()
+}
+
+func TestTransactions(t *testing.T) {
+	clock := &fakeClock{ns: int64(1000 * time.Second)}
+	_, addr := startServer(t, WithClock(clock.now))
+	c1 := dial(t, "tcp", addr)
+	c2 := dial(t, "tcp", addr)
+
+	c1.check(
+		"exec", "(error) ERR EXEC without MULTI",
+		"discard", "(error) ERR DISCARD without MULTI",
+		"multi", "OK",
+		"multi", "(error) ERR MULTI calls can not be nested",
+		"watch a", "(error) ERR WATCH inside MULTI is not allowed",
+		"set a 1", "QUEUED",
+		"incr a", "QUEUED",
+		"lpush a x", "QUEUED",
+		"get a", "QUEUED",
+		"exec", "[OK (integer) 2 (error) WRONGTYPE Operation against a key holding the wrong kind of value 2]",
+		"multi", "OK",
+		"set a 3", "QUEUED",
+		"discard", "OK",
+		"get a", "2",
+	)
+
+	// Errors while queueing abort the transaction.
+	c1.check(
+		"multi", "OK",
+		"set a 3", "QUEUED",
+		"nosuch", "(error) ERR unknown command 'nosuch', with args beginning with: ",
+		"get", "(error) ERR wrong number of arguments for 'get' command",
+		"exec", "(error) EXECABORT Transaction discarded because of previous errors.",
+		"get a", "2",
+	)
+
+	// A watched key modified by another client aborts the transaction.
+	c1.check("watch a b", "OK", "multi", "OK", "set a 3", "QUEUED")
+	c2.check("set b 1", "OK")
+	c1.check("exec", "(nil)", "get a", "2")
+
+	// The keys are unwatched after EXEC, and by UNWATCH.
+	c1.check("watch a", "OK", "multi", "OK", "incr a", "QUEUED", "exec", "[(integer) 3]")
+	c2.check("set a 1", "OK")
+	c1.check("watch a", "OK", "unwatch", "OK")
+	c2.check("set a 2", "OK")
+	c1.check("multi", "OK", "incr a", "QUEUED", "exec", "[(integer) 3]")
+
+	// In-place modifications of the values touch the keys.
+	for _, cmd := range []string{
+		"rpush l c", "lpop l", "lset l 0 z", "linsert l before b y",
+		"lrem l 0 b", "ltrim l 1 -1",
+		"hset h f 2", "hsetnx h x 1", "hdel h g", "hincrby h f 1",
+		"hincrbyfloat h f 1.5",
+	} {
+		c2.do("del", "l", "h")
+		c2.check("rpush l a b", "(integer) 2", "hset h f 1 g 2", "(integer) 2")
+		c1.check("watch l h", "OK", "multi", "OK", "ping", "QUEUED")
+		c2.do(strings.Split(cmd, " ")...)
+		if reply := c1.do("exec"); reply != "(nil)" {
+			t.Errorf("%s: exec = %q, want (nil)", cmd, reply)
+		}
+	}
+
+	// Commands not modifying the keys don't.
+	c1.check("watch l h", "OK")
+	c2.check("lpush l2 x", "(integer) 1", "lrem l 0 nosuch", "(integer) 0", "hdel h nosuch", "(integer) 0", "linsert l before nosuch x", "(integer) -1")
+	c1.check("multi", "OK", "ping", "QUEUED", "exec", "[PONG]")
+
+	// FLUSHALL and SWAPDB touch the watched keys.
+	c1.check("watch a", "OK", "multi", "OK", "ping", "QUEUED")
+	c2.check("flushall", "OK")
+	c1.check("exec", "(nil)")
+	c2.check("select 1", "OK", "set a 1", "OK")
+	c1.check("watch a", "OK", "multi", "OK", "ping", "QUEUED")
+	c2.check("swapdb 0 1", "OK")
+	c1.check("exec", "(nil)", "get a", "1")
+
+	// A watched key expiring aborts the transaction, unless it was
+	// already expired when watched.
+	c1.check("set e 1 px 100", "OK", "watch e", "OK")
+	clock.advance(time.Second)
+	c1.check("multi", "OK", "ping", "QUEUED", "exec", "(nil)")
+	c1.check("set e 1 px 100", "OK")
+	clock.advance(time.Second)
+	c1.check("watch e", "OK", "multi", "OK", "ping", "QUEUED", "exec", "[PONG]")
+}
+
+func TestPubSub(t *testing.T) {
+	_, addr := startServer(t)
+	c1 := dial(t, "tcp", addr)
+	c2 := dial(t, "tcp", addr)
+
+	c1.check("subscribe foo bar", "[subscribe foo (integer) 1]")
+	if got := c1.read(); got != "[subscribe bar (integer) 2]" {
+		t.Fatalf("subscribe: got %q", got)
+	}
+	c2.check("publish foo hello", "(integer) 1")
+	if got := c1.read(); got != "[message foo hello]" {
+		t.Fatalf("message: got %q", got)
+	}
+
+	c1.check(
+		"psubscribe f*", "[psubscribe f* (integer) 3]",
+		"get a", "(error) ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context",
+		"ping", "[pong ]",
+		"ping hi", "[pong hi]",
+	)
+	c2.check(
+		"publish foo x", "(integer) 2",
+		"publish nosuch x", "(integer) 0",
+		"pubsub channels f*", "[foo]",
+		"pubsub numsub foo bar nosuch", "[foo (integer) 1 bar (integer) 1 nosuch (integer) 0]",
+		"pubsub numsub", "[]",
+		"pubsub numpat", "(integer) 1",
+		"pubsub numpat x", "(error) ERR unknown subcommand 'numpat'. Try PUBSUB HELP.",
+		"pubsub foo", "(error) ERR unknown subcommand 'foo'. Try PUBSUB HELP.",
+	)
+	for _, want := range []string{"[message foo x]", "[pmessage f* foo x]"} {
+		if got := c1.read(); got != want {
+			t.Fatalf("message: got %q, want %q", got, want)
+		}
+	}
+
+	c1.check(
+		"unsubscribe foo", "[unsubscribe foo (integer) 2]",
+		"punsubscribe", "[punsubscribe f* (integer) 1]",
+		"unsubscribe", "[unsubscribe bar (integer) 0]",
+		"unsubscribe", "[unsubscribe (nil) (integer) 0]",
+		"get a", "(nil)",
+		"ping", "PONG",
+	)
+	c2.check("publish foo x", "(integer) 0", "pubsub channels", "[]")
+
+	// Shard channels.
+	c1.check("ssubscribe {u}a {u}b", "[ssubscribe {u}a (integer) 1]")
+	if got := c1.read(); got != "[ssubscribe {u}b (integer) 2]" {
+		t.Fatalf("ssubscribe: got %q", got)
+	}
+	c2.check(
+		"spublish {u}a hi", "(integer) 1",
+		"publish {u}a hi", "(integer) 0",
+		"pubsub shardchannels {u}a", "[{u}a]",
+		"pubsub shardnumsub {u}a {u}b {u}c", "[{u}a (integer) 1 {u}b (integer) 1 {u}c (integer) 0]",
+	)
+	if got := c1.read(); got != "[smessage {u}a hi]" {
+		t.Fatalf("smessage: got %q", got)
+	}
+	c1.check(
+		"sunsubscribe {u}a", "[sunsubscribe {u}a (integer) 1]",
+		"sunsubscribe nosuch", "[sunsubscribe nosuch (integer) 1]",
+		"sunsubscribe", "[sunsubscribe {u}b (integer) 0]",
+		"get a", "(nil)",
+	)
+
+	// RESP3 clients get push replies, and can run any command.
+	c1.do("hello", "3")
+	c1.check(
+		"subscribe foo", ">[subscribe foo (integer) 1]",
+		"get a", "(nil)",
+		"ping", "PONG",
+	)
+	c2.check("publish foo hello", "(integer) 1")
+	if got := c1.read(); got != ">[message foo hello]" {
+		t.Fatalf("message: got %q", got)
+	}
+
+	// MULTI/EXEC delivers the messages once the transaction is executed.
+	c2.check("multi", "OK", "publish foo 1", "QUEUED", "publish foo 2", "QUEUED", "exec", "[(integer) 1 (integer) 1]")
+	for _, want := range []string{">[message foo 1]", ">[message foo 2]"} {
+		if got := c1.read(); got != want {
+			t.Fatalf("message: got %q, want %q", got, want)
+		}
+	}
+
+	// The subscriptions are released when the client is closed.
+	c1.conn.Close()
+	for i := 0; c2.do("pubsub", "numsub", "foo") != "[foo (integer) 0]"; i++ {
+		if i == 100 {
+			t.Fatal("subscriptions not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	_, addr := startServer(t, WithPubSubBufferLimit(64))
+	c1 := dial(t, "tcp", addr)
+	c2 := dial(t, "tcp", addr)
+
+	c1.check("subscribe foo", "[subscribe foo (integer) 1]")
+	c2.check("publish foo small", "(integer) 1")
+	if got := c1.read(); got != "[message foo small]" {
+		t.Fatalf("message: got %q", got)
+	}
+
+	// The subscriber exceeding the limit is disconnected.
+	c2.check(
+		"publish foo "+strings.Repeat("x", 100), "(integer) 0",
+		"pubsub numsub foo", "[foo (integer) 0]",
+	)
+	c1.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(c1.r); err != nil {
+		t.Fatalf("subscriber not disconnected: %v", err)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	_, addr := startServer(t, WithNotifyKeyspaceEvents(db.NotifyKeyspace|db.NotifyKeyevent|db.NotifyAll|db.NotifyNew))
+	c1 := dial(t, "tcp", addr)
+	c2 := dial(t, "tcp", addr)
+
+	c1.check("psubscribe __keyevent@0__:*", "[psubscribe __keyevent@0__:* (integer) 1]")
+	c2.check(
+		"set a 1", "OK",
+		"incr a", "(integer) 2",
+		"append a x", "(integer) 2",
+		"rpush l x y", "(integer) 2",
+		"lpop l", "x",
+		"rpop l", "y",
+		"hset h f v", "(integer) 1",
+		"hdel h f", "(integer) 1",
+		"expire a 100", "(integer) 1",
+		"persist a", "(integer) 1",
+		"rename a b", "OK",
+		"del b", "(integer) 1",
+		"select 1", "OK",
+		"set a 1", "OK",
+	)
+	for _, want := range []string{
+		"[pmessage __keyevent@0__:* __keyevent@0__:new a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:set a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:incrby a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:append a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:new l]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:rpush l]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:lpop l]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:rpop l]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:del l]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:new h]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:hset h]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:hdel h]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:del h]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:expire a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:persist a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:new b]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:rename_from a]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:rename_to b]",
+		"[pmessage __keyevent@0__:* __keyevent@0__:del b]",
+	} {
+		if got := c1.read(); got != want {
+			t.Fatalf("event: got %q, want %q", got, want)
+		}
+	}
+
+	// The keyspace channels of the key.
+	c1.check("psubscribe __keyspace@1__:a", "[psubscribe __keyspace@1__:a (integer) 2]")
+	c2.check("incrbyfloat a 1.5", "2.5")
+	if got := c1.read(); got != "[pmessage __keyspace@1__:a __keyspace@1__:a incrbyfloat]" {
+		t.Fatalf("event: got %q", got)
+	}
+}
+
+// startAOFServer Start a server logging the commands to the AOF in 'dir',
+// loaded before serving. The AOF is closed at the end of the test, once
+// the server is closed.
+func startAOFServer(t *testing.T, dir string, opts ...Option) (*Server, *aof.AOF, string) {
+	t.Helper()
+	a, err := aof.Open(aof.WithDir(dir), aof.WithFsync(aof.FsyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	s := Create(append(opts, WithAppendOnly(a))...)
+	if err := s.LoadAppendOnlyFile(); err != nil {
+		t.Fatal(err)
+	}
+	return s, a, serve(t, s)
+}
+
+func TestAppendOnly(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{ns: int64(1000 * time.Second)}
+	t.Run("write", func(t *testing.T) {
+		_, _, addr := startAOFServer(t, dir, WithClock(clock.now))
+		c := dial(t, "tcp", addr)
+		c.check(
+			"set a 1", "OK",
+			"expire a 100", "(integer) 1",
+			"set e x px 100", "OK",
+			"incrbyfloat f 1.5", "1.5",
+			"rpush l a b c", "(integer) 3",
+			"lpop l", "a",
+			"hset h f v g w", "(integer) 2",
+			"hincrbyfloat h n 0.25", "0.25",
+			"getdel nokey", "(nil)",
+			"del nokey", "(integer) 0",
+			"select 1", "OK",
+			"set b 2", "OK",
+			"move b 2", "(integer) 1",
+			"multi", "OK",
+			"incr c", "QUEUED",
+			"incr c", "QUEUED",
+			"exec", "[(integer) 1 (integer) 2]",
+			"select 3", "OK",
+			"set z 1", "OK",
+			"flushdb", "OK",
+			"flushdb", "OK",
+		)
+
+		// The expired keys are propagated as DEL.
+		clock.advance(time.Second)
+		c.check("select 0", "OK", "get e", "(nil)")
+	})
+
+	// The relative expires are logged as absolute ones, the non
+	// deterministic commands as their effect, and the commands that
+	// didn't modify the dataset are not logged.
+	data, err := ioutil.ReadFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		encode("PEXPIREAT", "a", "1100000"),
+		encode("SET", "e", "x", "PXAT", "1000100"),
+		encode("SET", "f", "1.5", "KEEPTTL"),
+		encode("HSET", "h", "n", "0.25"),
+		encode("MULTI") + encode("incr", "c") + encode("incr", "c") + encode("EXEC"),
+		encode("flushdb") + encode("flushdb"),
+		encode("SELECT", "0") + encode("DEL", "e"),
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("%q not logged in %q", want, data)
+		}
+	}
+	if strings.Contains(string(data), "nokey") {
+		t.Fatalf("command without effect logged in %q", data)
+	}
+
+	// A truncated command, or a transaction without EXEC, at the end of
+	// the AOF is discarded.
+	f, err := os.OpenFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(encode("MULTI") + encode("SET", "a", "2") + encode("SET", "x")[:10])
+	f.Close()
+
+	check := func(c *testClient) {
+		t.Helper()
+		c.check(
+			"get a", "1",
+			"pttl a", "(integer) 99000",
+			"exists e", "(integer) 0",
+			"get f", "1.5",
+			"lrange l 0 -1", "[b c]",
+			"hmget h f g n", "[v w 0.25]",
+			"select 1", "OK",
+			"get c", "2",
+			"exists b", "(integer) 0",
+			"select 2", "OK",
+			"get b", "2",
+			"select 3", "OK",
+			"dbsize", "(integer) 0",
+			"select 0", "OK",
+		)
+	}
+	t.Run("replay", func(t *testing.T) {
+		_, a, addr := startAOFServer(t, dir, WithClock(clock.now))
+		c := dial(t, "tcp", addr)
+		check(c)
+
+		// The rewrite produces a new base file, the commands executed
+		// from then on are appended to a new incr file.
+		c.check("bgrewriteaof", "Background append only file rewriting started")
+		if err := a.WaitRewrite(); err != nil {
+			t.Fatal(err)
+		}
+		c.check("set after 1", "OK")
+	})
+
+	t.Run("rewritten", func(t *testing.T) {
+		base, err := ioutil.ReadFile(filepath.Join(dir, "appendonly.aof.1.base.aof"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(base), "incr") {
+			t.Fatalf("not rewritten %q", base)
+		}
+		_, _, addr := startAOFServer(t, dir, WithClock(clock.now))
+		c := dial(t, "tcp", addr)
+		check(c)
+		c.check("get after", "1")
+	})
+
+	_, addr := startServer(t)
+	c := dial(t, "tcp", addr)
+	c.check("bgrewriteaof", "(error) ERR Append only file is not enabled")
+}
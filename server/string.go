@@ -0,0 +1,450 @@
+package server
+
+// The string commands, a port of t_string.c of redis.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"db"
+	"object"
+)
+
+// Flags of SET, in addition to db.SetNX, db.SetXX and db.SetKeepTTL.
+const (
+	// setGet Set and return the old value.
+	setGet = 1 << (iota + 8)
+	// setEX Set with expire in seconds.
+	setEX
+	// setPX Set with expire in milliseconds.
+	setPX
+	// setEXAT Set with unix time in seconds.
+	setEXAT
+	// setPXAT Set with unix time in milliseconds.
+	setPXAT
+)
+
+// checkStringLength Check that the string of length 'size' doesn't exceed
+// the max length of a bulk string.
+func (c *client) checkStringLength(size int64) bool {
+	if size > c.srv.maxBulkLen {
+		c.w.WriteError("string exceeds maximum allowed size (proto-max-bulk-len)")
+		return false
+	}
+	return true
+}
+
+// getExpireMillisecondsOrReply Return the unix time in milliseconds of the
+// expire of SET, SETEX and PSETEX, 0 if there is no expire.
+func (c *client) getExpireMillisecondsOrReply(expire []byte, flags int) (int64, bool) {
+	if expire == nil {
+		return 0, true
+	}
+	milliseconds, ok := c.getLongLongOrReply(expire, "")
+	if !ok {
+		return 0, false
+	}
+
+	// EXAT/PXAT is allowed to be in the past, but not 0 or negative.
+	invalid := milliseconds <= 0 ||
+		flags&(setEX|setEXAT) != 0 && milliseconds > math.MaxInt64/1000
+	if !invalid {
+		if flags&(setEX|setEXAT) != 0 {
+			milliseconds *= 1000
+		}
+		if flags&(setEX|setPX) != 0 {
+			// Relative expire, add the current time.
+			now := c.srv.mstime()
+			invalid = milliseconds > math.MaxInt64-now
+			milliseconds += now
+		}
+	}
+	if invalid {
+		c.w.WriteError(fmt.Sprintf("invalid expire time in '%s' command", c.cmd.name))
+		return 0, false
+	}
+	return milliseconds, true
+}
+
+// parseExtendedStringArgumentsOrReply Parse the options of SET, starting
+// from argv[3]. Returns the flags and the expire argument if any.
+func (c *client) parseExtendedStringArgumentsOrReply() (int, []byte, bool) {
+	var (
+		flags  int
+		expire []byte
+	)
+	for j := 3; j < len(c.argv); j++ {
+		var next []byte
+		if j+1 < len(c.argv) {
+			next = c.argv[j+1]
+		}
+
+		switch opt := strings.ToLower(string(c.argv[j])); {
+		case opt == "nx" && flags&(db.SetXX) == 0:
+			flags |= db.SetNX
+		case opt == "xx" && flags&db.SetNX == 0:
+			flags |= db.SetXX
+		case opt == "get":
+			flags |= setGet
+		case opt == "keepttl" && flags&(setEX|setPX|setEXAT|setPXAT) == 0:
+			flags |= db.SetKeepTTL
+		case opt == "ex" && next != nil &&
+			flags&(db.SetKeepTTL|setPX|setEXAT|setPXAT) == 0:
+			flags |= setEX
+			expire = next
+			j++
+		case opt == "px" && next != nil &&
+			flags&(db.SetKeepTTL|setEX|setEXAT|setPXAT) == 0:
+			flags |= setPX
+			expire = next
+			j++
+		case opt == "exat" && next != nil &&
+			flags&(db.SetKeepTTL|setEX|setPX|setPXAT) == 0:
+			flags |= setEXAT
+			expire = next
+			j++
+		case opt == "pxat" && next != nil &&
+			flags&(db.SetKeepTTL|setEX|setPX|setEXAT) == 0:
+			flags |= setPXAT
+			expire = next
+			j++
+		default:
+			c.w.WriteError(errSyntax)
+			return 0, nil, false
+		}
+	}
+	return flags, expire, true
+}
+
+// setGenericCommand The setGenericCommand() function implements the
+// SET operation with different options and variants. This function is
+// called in order to implement the following commands: SET, SETEX,
+// PSETEX, SETNX, GETSET.
+//
+// 'flags' changes the behavior of the command (NX, XX or GET, see
+// above).
+//
+// 'expire' represents an expire to set in form of a Redis object as
+// passed by the user. It is interpreted according to the specified
+// 'flags'.
+//
+// 'okReply' and 'abortReply' is what the function will reply to the
+// client if the operation is performed, or when it is not because of NX
+// or XX flags. A nil 'okReply' is "+OK", a nil 'abortReply' is a null.
+func setGenericCommand(c *client, flags int, key, val, expire []byte, okReply, abortReply func()) {
+	when, ok := c.getExpireMillisecondsOrReply(expire, flags)
+	if !ok {
+		return
+	}
+
+	var old []byte
+	if flags&setGet != 0 {
+		var err error
+		if old, err = c.db.Get(key); err != nil {
+			c.addReplyErr(err)
+			return
+		}
+	}
+
+	set, _ := c.db.Set(key, val, flags&(db.SetNX|db.SetXX|db.SetKeepTTL), when)
+	if set {
+		c.srv.dirty++
+		// Propagate as SET Key Value PXAT millisecond-timestamp if
+		// there is EX/PX/EXAT flag.
+		if expire != nil && flags&setPXAT == 0 {
+			c.rewriteCommandVector([]byte("SET"), key, val, []byte("PXAT"),
+				strconv.AppendInt(nil, when, 10))
+		}
+	}
+	switch {
+	case flags&setGet != 0:
+		// When GET is used, the reply is the old value, even if the key
+		// was not set because of the NX or XX condition.
+		if old == nil {
+			c.w.WriteNull()
+		} else {
+			c.w.WriteBulk(old)
+		}
+	case !set && abortReply != nil:
+		abortReply()
+	case !set:
+		c.w.WriteNull()
+	case okReply != nil:
+		okReply()
+	default:
+		c.w.WriteStatus("OK")
+	}
+}
+
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func setCommand(c *client) {
+	flags, expire, ok := c.parseExtendedStringArgumentsOrReply()
+	if !ok {
+		return
+	}
+	setGenericCommand(c, flags, c.argv[1], c.argv[2], expire, nil, nil)
+}
+
+// SETNX key value
+func setnxCommand(c *client) {
+	setGenericCommand(c, db.SetNX, c.argv[1], c.argv[2], nil,
+		func() { c.w.WriteInteger(1) },
+		func() { c.w.WriteInteger(0) })
+}
+
+// SETEX key seconds value
+func setexCommand(c *client) {
+	setGenericCommand(c, setEX, c.argv[1], c.argv[3], c.argv[2], nil, nil)
+}
+
+// PSETEX key milliseconds value
+func psetexCommand(c *client) {
+	setGenericCommand(c, setPX, c.argv[1], c.argv[3], c.argv[2], nil, nil)
+}
+
+// GET key
+func getCommand(c *client) {
+	value, err := c.db.Get(c.argv[1])
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if value == nil {
+		c.w.WriteNull()
+		return
+	}
+	c.w.WriteBulk(value)
+}
+
+// GETSET key value
+func getsetCommand(c *client) {
+	setGenericCommand(c, setGet, c.argv[1], c.argv[2], nil, nil, nil)
+}
+
+// GETDEL key
+func getdelCommand(c *client) {
+	value, err := c.db.Get(c.argv[1])
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if value == nil {
+		c.w.WriteNull()
+		return
+	}
+	c.db.Delete(c.argv[1])
+	c.srv.dirty++
+	c.w.WriteBulk(value)
+
+	// Propagate as DEL command
+	c.rewriteCommandVector([]byte("DEL"), c.argv[1])
+}
+
+// MGET key [key ...]
+func mgetCommand(c *client) {
+	c.w.WriteArrayLen(len(c.argv) - 1)
+	for _, key := range c.argv[1:] {
+		o := c.db.Lookup(key)
+		if o == nil || o.Type() != object.TypeString {
+			c.w.WriteNull()
+		} else {
+			c.w.WriteBulk(object.StringBytes(o))
+		}
+	}
+}
+
+// msetGenericCommand Implements MSET and MSETNX.
+func msetGenericCommand(c *client, nx bool) {
+	if len(c.argv)%2 == 0 {
+		c.addReplyErrorArity()
+		return
+	}
+
+	// Handle the NX flag. The MSETNX semantic is to return zero and
+	// don't set anything if at least one key already exists.
+	if nx {
+		for j := 1; j < len(c.argv); j += 2 {
+			if c.db.Exists(c.argv[j]) {
+				c.w.WriteInteger(0)
+				return
+			}
+		}
+	}
+
+	for j := 1; j < len(c.argv); j += 2 {
+		c.db.Set(c.argv[j], c.argv[j+1], 0, 0)
+	}
+	c.srv.dirty += int64(len(c.argv)-1) / 2
+	if nx {
+		c.w.WriteInteger(1)
+	} else {
+		c.w.WriteStatus("OK")
+	}
+}
+
+// MSET key value [key value ...]
+func msetCommand(c *client) {
+	msetGenericCommand(c, false)
+}
+
+// MSETNX key value [key value ...]
+func msetnxCommand(c *client) {
+	msetGenericCommand(c, true)
+}
+
+// setValue Set the value of an existing key retaining its expire, or add
+// the key. The reference of 'o' is released.
+func (c *client) setValue(key []byte, exists bool, o *object.Object) {
+	if exists {
+		c.db.Overwrite(key, o)
+	} else {
+		c.db.Add(key, o)
+	}
+	o.DecrRefCount()
+}
+
+// incrDecrCommand Implements INCR, DECR, INCRBY and DECRBY.
+func incrDecrCommand(c *client, incr int64) {
+	o, err := c.db.LookupType(c.argv[1], object.TypeString)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	var value int64
+	if o != nil {
+		if value, err = object.GetLongLong(o); err != nil {
+			c.w.WriteError(errNotInteger)
+			return
+		}
+	}
+
+	if incr < 0 && value < 0 && incr < math.MinInt64-value ||
+		incr > 0 && value > 0 && incr > math.MaxInt64-value {
+		c.w.WriteError(errOverflow)
+		return
+	}
+	value += incr
+
+	c.setValue(c.argv[1], o != nil, object.CreateStringFromLongLong(value))
+	c.db.NotifyKeyspaceEvent(db.NotifyString, "incrby", c.argv[1])
+	c.srv.dirty++
+	c.w.WriteInteger(value)
+}
+
+// INCR key
+func incrCommand(c *client) {
+	incrDecrCommand(c, 1)
+}
+
+// DECR key
+func decrCommand(c *client) {
+	incrDecrCommand(c, -1)
+}
+
+// INCRBY key increment
+func incrbyCommand(c *client) {
+	incr, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+	incrDecrCommand(c, incr)
+}
+
+// DECRBY key decrement
+func decrbyCommand(c *client) {
+	incr, ok := c.getLongLongOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+	// Overflow check: negating LLONG_MIN will cause an overflow
+	if incr == math.MinInt64 {
+		c.w.WriteError("decrement would overflow")
+		return
+	}
+	incrDecrCommand(c, -incr)
+}
+
+// INCRBYFLOAT key increment
+func incrbyfloatCommand(c *client) {
+	o, err := c.db.LookupType(c.argv[1], object.TypeString)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	var value float64
+	if o != nil {
+		if value, err = object.GetDouble(o); err != nil {
+			c.w.WriteError(errNotFloat)
+			return
+		}
+	}
+	incr, ok := c.getDoubleOrReply(c.argv[2], "")
+	if !ok {
+		return
+	}
+
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		c.w.WriteError("increment would produce NaN or Infinity")
+		return
+	}
+	n := object.CreateStringFromDouble(value)
+	c.w.WriteBulk(object.StringBytes(n))
+	// Always replicate INCRBYFLOAT as a SET command with the final
+	// value in order to make sure that differences in float precision
+	// or formatting will not create differences in replicas or after an
+	// AOF restart.
+	c.rewriteCommandVector([]byte("SET"), c.argv[1], object.StringBytes(n), []byte("KEEPTTL"))
+	c.setValue(c.argv[1], o != nil, n)
+	c.db.NotifyKeyspaceEvent(db.NotifyString, "incrbyfloat", c.argv[1])
+	c.srv.dirty++
+}
+
+// APPEND key value
+func appendCommand(c *client) {
+	key, arg := c.argv[1], c.argv[2]
+	o, err := c.db.LookupType(key, object.TypeString)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+
+	if o == nil {
+		// Create the key
+		c.setValue(key, false, object.TryEncoding(object.CreateString(arg)))
+		c.db.NotifyKeyspaceEvent(db.NotifyString, "append", key)
+		c.srv.dirty++
+		c.w.WriteInteger(int64(len(arg)))
+		return
+	}
+
+	// "append" is an argument, so always an sds
+	totlen := int64(object.StringLen(o)) + int64(len(arg))
+	if !c.checkStringLength(totlen) {
+		return
+	}
+	value := make([]byte, 0, totlen)
+	value = append(value, object.StringBytes(o)...)
+	value = append(value, arg...)
+	c.setValue(key, true, object.CreateRawString(value))
+	c.db.NotifyKeyspaceEvent(db.NotifyString, "append", key)
+	c.srv.dirty++
+	c.w.WriteInteger(totlen)
+}
+
+// STRLEN key
+func strlenCommand(c *client) {
+	o, err := c.db.LookupType(c.argv[1], object.TypeString)
+	if err != nil {
+		c.addReplyErr(err)
+		return
+	}
+	if o == nil {
+		c.w.WriteInteger(0)
+		return
+	}
+	c.w.WriteInteger(int64(object.StringLen(o)))
+}
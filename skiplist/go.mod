@@ -0,0 +1,10 @@
+module skiplist
+
+go 1.14
+
+require sds v0.0.0
+
+replace (
+	dict => ../dict
+	sds => ../sds
+)
@@ -0,0 +1,371 @@
+package skiplist
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+
+	"sds"
+)
+
+// Error
+var (
+	// ErrNotFloat a score range item is not a valid float.
+	ErrNotFloat = errors.New("min or max is not a float")
+	// ErrNotLexRange a lex range item is not valid.
+	ErrNotLexRange = errors.New("min or max not valid string range item")
+)
+
+// RangeSpec Struct to hold an inclusive/exclusive range spec by score
+// comparison.
+type RangeSpec struct {
+	Min, Max float64
+	// are min or max exclusive?
+	Minex, Maxex bool
+}
+
+// LexRangeSpec Struct to hold an inclusive/exclusive range spec by lexicographic
+// comparison. Min and Max may be the MinString and MaxString sentinels.
+type LexRangeSpec struct {
+	Min, Max *sds.SDS
+	// are min or max exclusive?
+	Minex, Maxex bool
+}
+
+var (
+	// MinString the "-" lex range item, smaller than any string. It is
+	// compared by pointer.
+	MinString = sds.NewString("minstring")
+	// MaxString the "+" lex range item, greater than any string. It is
+	// compared by pointer.
+	MaxString = sds.NewString("maxstring")
+)
+
+// ParseRange Populate the RangeSpec from the min and max arguments of
+// ZRANGEBYSCORE and friends, like "1", "(1.5", "-inf" and "+inf".
+func ParseRange(min, max []byte) (*RangeSpec, error) {
+	spec := &RangeSpec{}
+	var err error
+
+	if spec.Min, spec.Minex, err = parseRangeItem(min); err != nil {
+		return nil, err
+	}
+	if spec.Max, spec.Maxex, err = parseRangeItem(max); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func parseRangeItem(item []byte) (float64, bool, error) {
+	ex := false
+	if len(item) > 0 && item[0] == '(' {
+		ex = true
+		item = item[1:]
+	}
+	v, err := strconv.ParseFloat(string(item), 64)
+	if err != nil && !isRangeErr(err) {
+		return 0, false, ErrNotFloat
+	}
+	if math.IsNaN(v) {
+		return 0, false, ErrNotFloat
+	}
+	return v, ex, nil
+}
+
+// isRangeErr Overflows are accepted like strtod does, as +/-inf.
+func isRangeErr(err error) bool {
+	e, ok := err.(*strconv.NumError)
+	return ok && e.Err == strconv.ErrRange
+}
+
+// ParseLexRange Populate the LexRangeSpec from the min and max arguments
+// of ZRANGEBYLEX and friends. The items must start with '(' or '[', or
+// be exactly "-" or "+".
+func ParseLexRange(min, max []byte) (*LexRangeSpec, error) {
+	spec := &LexRangeSpec{}
+	var err error
+
+	if spec.Min, spec.Minex, err = parseLexRangeItem(min); err != nil {
+		return nil, err
+	}
+	if spec.Max, spec.Maxex, err = parseLexRangeItem(max); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func parseLexRangeItem(item []byte) (*sds.SDS, bool, error) {
+	if len(item) == 0 {
+		return nil, false, ErrNotLexRange
+	}
+	switch item[0] {
+	case '+':
+		if len(item) != 1 {
+			return nil, false, ErrNotLexRange
+		}
+		return MaxString, false, nil
+	case '-':
+		if len(item) != 1 {
+			return nil, false, ErrNotLexRange
+		}
+		return MinString, false, nil
+	case '(':
+		return sds.New(item[1:]), true, nil
+	case '[':
+		return sds.New(item[1:]), false, nil
+	}
+	return nil, false, ErrNotLexRange
+}
+
+// ValueGteMin Return true if value is within the lower bound of the range.
+func (r *RangeSpec) ValueGteMin(value float64) bool {
+	if r.Minex {
+		return value > r.Min
+	}
+	return value >= r.Min
+}
+
+// ValueLteMax Return true if value is within the upper bound of the range.
+func (r *RangeSpec) ValueLteMax(value float64) bool {
+	if r.Maxex {
+		return value < r.Max
+	}
+	return value <= r.Max
+}
+
+// empty Test for ranges that will always be empty.
+func (r *RangeSpec) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.Minex || r.Maxex))
+}
+
+// LexCmp This is just a wrapper to sds.Cmp that is able to handle
+// MinString and MaxString sentinels.
+func LexCmp(a, b *sds.SDS) int {
+	if a == b {
+		return 0
+	}
+	if a == MinString || b == MaxString {
+		return -1
+	}
+	if a == MaxString || b == MinString {
+		return 1
+	}
+	return bytes.Compare(a.Bytes(), b.Bytes())
+}
+
+// ValueGteMin Return true if value is within the lower bound of the range.
+func (r *LexRangeSpec) ValueGteMin(value *sds.SDS) bool {
+	if r.Minex {
+		return LexCmp(value, r.Min) > 0
+	}
+	return LexCmp(value, r.Min) >= 0
+}
+
+// ValueLteMax Return true if value is within the upper bound of the range.
+func (r *LexRangeSpec) ValueLteMax(value *sds.SDS) bool {
+	if r.Maxex {
+		return LexCmp(value, r.Max) < 0
+	}
+	return LexCmp(value, r.Max) <= 0
+}
+
+// empty Test for ranges that will always be empty.
+func (r *LexRangeSpec) empty() bool {
+	c := LexCmp(r.Min, r.Max)
+	return c > 0 || (c == 0 && (r.Minex || r.Maxex))
+}
+
+// IsInRange Returns if there is a part of the zset is in range.
+func (zsl *Skiplist) IsInRange(r *RangeSpec) bool {
+	if r.empty() {
+		return false
+	}
+	x := zsl.tail
+	if x == nil || !r.ValueGteMin(x.score) {
+		return false
+	}
+	x = zsl.header.level[0].forward
+	if x == nil || !r.ValueLteMax(x.score) {
+		return false
+	}
+	return true
+}
+
+// FirstInRange Find the first node that is contained in the specified
+// range. Returns nil when no element is contained in the range.
+func (zsl *Skiplist) FirstInRange(r *RangeSpec) *Node {
+	// If everything is out of range, return early.
+	if !zsl.IsInRange(r) {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		// Go forward while *OUT* of range.
+		for x.level[i].forward != nil && !r.ValueGteMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	// This is an inner range, so the next node cannot be nil.
+	x = x.level[0].forward
+
+	// Check if score <= max.
+	if !r.ValueLteMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+// LastInRange Find the last node that is contained in the specified
+// range. Returns nil when no element is contained in the range.
+func (zsl *Skiplist) LastInRange(r *RangeSpec) *Node {
+	// If everything is out of range, return early.
+	if !zsl.IsInRange(r) {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		// Go forward while *IN* range.
+		for x.level[i].forward != nil && r.ValueLteMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	// This is an inner range, so this node cannot be nil.
+	// Check if score >= min.
+	if !r.ValueGteMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+// IsInLexRange Returns if there is a part of the zset is in the lex range.
+func (zsl *Skiplist) IsInLexRange(r *LexRangeSpec) bool {
+	if r.empty() {
+		return false
+	}
+	x := zsl.tail
+	if x == nil || !r.ValueGteMin(x.ele) {
+		return false
+	}
+	x = zsl.header.level[0].forward
+	if x == nil || !r.ValueLteMax(x.ele) {
+		return false
+	}
+	return true
+}
+
+// FirstInLexRange Find the first node that is contained in the specified
+// lex range. Returns nil when no element is contained in the range.
+func (zsl *Skiplist) FirstInLexRange(r *LexRangeSpec) *Node {
+	// If everything is out of range, return early.
+	if !zsl.IsInLexRange(r) {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		// Go forward while *OUT* of range.
+		for x.level[i].forward != nil && !r.ValueGteMin(x.level[i].forward.ele) {
+			x = x.level[i].forward
+		}
+	}
+
+	// This is an inner range, so the next node cannot be nil.
+	x = x.level[0].forward
+
+	// Check if ele <= max.
+	if !r.ValueLteMax(x.ele) {
+		return nil
+	}
+	return x
+}
+
+// LastInLexRange Find the last node that is contained in the specified
+// lex range. Returns nil when no element is contained in the range.
+func (zsl *Skiplist) LastInLexRange(r *LexRangeSpec) *Node {
+	// If everything is out of range, return early.
+	if !zsl.IsInLexRange(r) {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		// Go forward while *IN* range.
+		for x.level[i].forward != nil && r.ValueLteMax(x.level[i].forward.ele) {
+			x = x.level[i].forward
+		}
+	}
+
+	// This is an inner range, so this node cannot be nil.
+	// Check if ele >= min.
+	if !r.ValueGteMin(x.ele) {
+		return nil
+	}
+	return x
+}
+
+// DeleteRangeByScore Delete all the elements with score between min and
+// max from the skiplist. 'deleted', when not nil, is called with every
+// removed element. Returns the number of removed elements.
+func (zsl *Skiplist) DeleteRangeByScore(r *RangeSpec, deleted func(ele *sds.SDS)) uint64 {
+	var update [MaxLevel]*Node
+	var removed uint64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.ValueGteMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	// Current node is the last with score < or <= min.
+	x = x.level[0].forward
+
+	// Delete nodes while in range.
+	for x != nil && r.ValueLteMax(x.score) {
+		next := x.level[0].forward
+		zsl.deleteNode(x, &update)
+		if deleted != nil {
+			deleted(x.ele)
+		}
+		removed++
+		x = next
+	}
+	return removed
+}
+
+// DeleteRangeByLex Delete all the elements between min and max in
+// lexicographic order. 'deleted', when not nil, is called with every
+// removed element. Returns the number of removed elements.
+func (zsl *Skiplist) DeleteRangeByLex(r *LexRangeSpec, deleted func(ele *sds.SDS)) uint64 {
+	var update [MaxLevel]*Node
+	var removed uint64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.ValueGteMin(x.level[i].forward.ele) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	// Current node is the last with ele < or <= min.
+	x = x.level[0].forward
+
+	// Delete nodes while in range.
+	for x != nil && r.ValueLteMax(x.ele) {
+		next := x.level[0].forward
+		zsl.deleteNode(x, &update)
+		if deleted != nil {
+			deleted(x.ele)
+		}
+		removed++
+		x = next
+	}
+	return removed
+}
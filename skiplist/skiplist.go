@@ -0,0 +1,359 @@
+package skiplist
+
+// Skiplist implementation, a port of the zskiplist used by the redis
+// sorted sets.
+//
+// This skiplist implementation is almost a C translation of the original
+// algorithm described by William Pugh in "Skip Lists: A Probabilistic
+// Alternative to Balanced Trees", modified in three ways:
+// a) this implementation allows for repeated scores.
+// b) the comparison is not just by key (our 'score') but by satellite data.
+// c) there is a back pointer, so it's a doubly linked list with the back
+// pointers being only at "level 1". This allows to traverse the list
+// from tail to head.
+//
+// Every forward pointer also records its span, the number of nodes it
+// jumps over, so that the rank of a node can be computed while walking
+// the list.
+
+import (
+	"math/rand"
+
+	"sds"
+)
+
+const (
+	// MaxLevel Should be enough for 2^64 elements
+	MaxLevel = 32
+	// P Skiplist P = 1/4
+	P = 0.25
+)
+
+type level struct {
+	forward *Node
+	span    uint64
+}
+
+// Node skiplist node.
+type Node struct {
+	ele      *sds.SDS
+	score    float64
+	backward *Node
+	level    []level
+}
+
+// Ele Return the element of the node.
+func (n *Node) Ele() *sds.SDS {
+	return n.ele
+}
+
+// Score Return the score of the node.
+func (n *Node) Score() float64 {
+	return n.score
+}
+
+// Next Return the next node, in ascending order, nil at the tail.
+func (n *Node) Next() *Node {
+	return n.level[0].forward
+}
+
+// Prev Return the previous node, nil at the head.
+func (n *Node) Prev() *Node {
+	return n.backward
+}
+
+// Skiplist skiplist.
+type Skiplist struct {
+	header, tail *Node
+	length       uint64
+	level        int
+}
+
+// createNode Create a skiplist node with the specified number of levels.
+func createNode(lvl int, score float64, ele *sds.SDS) *Node {
+	return &Node{
+		ele:   ele,
+		score: score,
+		level: make([]level, lvl),
+	}
+}
+
+// Create a new skiplist.
+func Create() *Skiplist {
+	return &Skiplist{
+		header: createNode(MaxLevel, 0, nil),
+		level:  1,
+	}
+}
+
+// Release Free a whole skiplist.
+func (zsl *Skiplist) Release() {
+	node := zsl.header.level[0].forward
+	for node != nil {
+		next := node.level[0].forward
+		node.level = nil
+		node.backward = nil
+		node = next
+	}
+	for i := range zsl.header.level {
+		zsl.header.level[i] = level{}
+	}
+	zsl.tail = nil
+	zsl.length = 0
+	zsl.level = 1
+}
+
+// Len Return the number of elements.
+func (zsl *Skiplist) Len() uint64 {
+	return zsl.length
+}
+
+// First Return the node with the lowest score, nil if empty.
+func (zsl *Skiplist) First() *Node {
+	return zsl.header.level[0].forward
+}
+
+// Last Return the node with the highest score, nil if empty.
+func (zsl *Skiplist) Last() *Node {
+	return zsl.tail
+}
+
+// randomLevel Returns a random level for the new skiplist node we are going
+// to create. The return value of this function is between 1 and MaxLevel
+// (both inclusive), with a powerlaw-alike distribution where higher
+// levels are less likely to be returned.
+func randomLevel() int {
+	lvl := 1
+	for rand.Float64() < P && lvl < MaxLevel {
+		lvl++
+	}
+	return lvl
+}
+
+// less Return true if the (score, ele) pair of the node sorts before the
+// given one.
+func (n *Node) less(score float64, ele *sds.SDS) bool {
+	return n.score < score || (n.score == score && n.ele.Cmp(ele) < 0)
+}
+
+// Insert a new node in the skiplist. Assumes the element does not already
+// exist (up to the caller to enforce that). The skiplist takes ownership
+// of the passed SDS string 'ele'.
+func (zsl *Skiplist) Insert(score float64, ele *sds.SDS) *Node {
+	var update [MaxLevel]*Node
+	var rank [MaxLevel]uint64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		// store rank that is crossed to reach the insert position
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, ele) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	// we assume the element is not already inside, since we allow duplicated
+	// scores, reinserting the same element should never happen since the
+	// caller of Insert() should test in the hash table if the element is
+	// already inside or not.
+	lvl := randomLevel()
+	if lvl > zsl.level {
+		for i := zsl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = lvl
+	}
+
+	x = createNode(lvl, score, ele)
+	for i := 0; i < lvl; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		// update span covered by update[i] as x is inserted here
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// increment span for untouched levels
+	for i := lvl; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// deleteNode Internal function used by Delete, DeleteRangeByScore and
+// DeleteRangeByRank.
+func (zsl *Skiplist) deleteNode(x *Node, update *[MaxLevel]*Node) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// Delete an element with matching score/element from the skiplist.
+// The function returns the removed node if it was found and deleted,
+// otherwise nil is returned.
+func (zsl *Skiplist) Delete(score float64, ele *sds.SDS) *Node {
+	var update [MaxLevel]*Node
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, ele) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	// We may have multiple elements with the same score, what we need
+	// is to find the element with both the right score and object.
+	x = x.level[0].forward
+	if x != nil && score == x.score && x.ele.Cmp(ele) == 0 {
+		zsl.deleteNode(x, &update)
+		return x
+	}
+	return nil // not found
+}
+
+// UpdateScore Update the score of an element inside the sorted set
+// skiplist. Note that the element must exist and must match 'score'.
+// This function does not update the score in the hash table side, the
+// caller should take care of it.
+//
+// The function returns the updated element skiplist node pointer, or nil
+// if the element was not found.
+func (zsl *Skiplist) UpdateScore(curscore float64, ele *sds.SDS, newscore float64) *Node {
+	var update [MaxLevel]*Node
+
+	// We need to seek to element to update to start: this is useful
+	// anyway, we'll have to update or remove it.
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(curscore, ele) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || curscore != x.score || x.ele.Cmp(ele) != 0 {
+		return nil
+	}
+
+	// If the node, after the score update, would be still exactly
+	// at the same position, we can just update the score without
+	// actually removing and re-inserting the element in the skiplist.
+	if (x.backward == nil || x.backward.score < newscore) &&
+		(x.level[0].forward == nil || x.level[0].forward.score > newscore) {
+		x.score = newscore
+		return x
+	}
+
+	// No way to reuse the old node: we need to remove and insert a new
+	// one at a different place.
+	zsl.deleteNode(x, &update)
+	return zsl.Insert(newscore, x.ele)
+}
+
+// Rank Find the rank for an element by both score and key.
+// Returns 0 when the element cannot be found, rank otherwise.
+// Note that the rank is 1-based due to the span of zsl.header to the
+// first element.
+func (zsl *Skiplist) Rank(score float64, ele *sds.SDS) uint64 {
+	var rank uint64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.score < score ||
+				(x.level[i].forward.score == score &&
+					x.level[i].forward.ele.Cmp(ele) <= 0)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		// x might be equal to zsl.header, so test if ele is non-nil
+		if x.ele != nil && x.score == score && x.ele.Cmp(ele) == 0 {
+			return rank
+		}
+	}
+	return 0
+}
+
+// ByRank Finds an element by its rank. The rank argument needs to be
+// 1-based. Returns nil when the rank is out of range.
+func (zsl *Skiplist) ByRank(rank uint64) *Node {
+	var traversed uint64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// DeleteRangeByRank Delete all the elements with rank between start and
+// end from the skiplist. Start and end are inclusive. Note that start and
+// end need to be 1-based. 'deleted', when not nil, is called with every
+// removed element. Returns the number of removed elements.
+func (zsl *Skiplist) DeleteRangeByRank(start, end uint64, deleted func(ele *sds.SDS)) uint64 {
+	var update [MaxLevel]*Node
+	var traversed, removed uint64
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	traversed++
+	x = x.level[0].forward
+	for x != nil && traversed <= end {
+		next := x.level[0].forward
+		zsl.deleteNode(x, &update)
+		if deleted != nil {
+			deleted(x.ele)
+		}
+		removed++
+		traversed++
+		x = next
+	}
+	return removed
+}
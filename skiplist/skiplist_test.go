@@ -0,0 +1,237 @@
+package skiplist
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"sds"
+)
+
+type pair struct {
+	score float64
+	ele   string
+}
+
+// checkSkiplist Verify the order, backward pointers and spans of every
+// level against the expected pairs.
+func checkSkiplist(t *testing.T, zsl *Skiplist, want []pair) {
+	t.Helper()
+	sort.Slice(want, func(i, j int) bool {
+		if want[i].score != want[j].score {
+			return want[i].score < want[j].score
+		}
+		return want[i].ele < want[j].ele
+	})
+
+	if zsl.Len() != uint64(len(want)) {
+		t.Fatalf("len %d, want %d", zsl.Len(), len(want))
+	}
+
+	var prev *Node
+	i := 0
+	for x := zsl.First(); x != nil; x = x.Next() {
+		if x.Score() != want[i].score || x.Ele().String() != want[i].ele {
+			t.Fatalf("node %d = (%v, %s), want %v", i, x.Score(), x.Ele(), want[i])
+		}
+		if x.Prev() != prev {
+			t.Fatalf("node %d bad backward", i)
+		}
+		if r := zsl.Rank(x.Score(), x.Ele()); r != uint64(i+1) {
+			t.Fatalf("rank of %s = %d, want %d", x.Ele(), r, i+1)
+		}
+		if n := zsl.ByRank(uint64(i + 1)); n != x {
+			t.Fatalf("ByRank(%d) = %v", i+1, n)
+		}
+		prev = x
+		i++
+	}
+	if zsl.Last() != prev {
+		t.Fatal("bad tail")
+	}
+
+	// The span of every forward pointer is the distance in ranks.
+	for lvl := 0; lvl < zsl.level; lvl++ {
+		var rank uint64
+		for x := zsl.header; x.level[lvl].forward != nil; x = x.level[lvl].forward {
+			next := x.level[lvl].forward
+			rank += x.level[lvl].span
+			if r := zsl.Rank(next.score, next.ele); r != rank {
+				t.Fatalf("level %d span to %s: rank %d, want %d", lvl, next.ele, rank, r)
+			}
+		}
+	}
+}
+
+func TestInsertDelete(t *testing.T) {
+	zsl := Create()
+	var want []pair
+	for i := 0; i < 500; i++ {
+		p := pair{float64(rand.Intn(50)), fmt.Sprintf("e%d", i)}
+		zsl.Insert(p.score, sds.NewString(p.ele))
+		want = append(want, p)
+	}
+	checkSkiplist(t, zsl, want)
+
+	// Delete every other element.
+	var kept []pair
+	for i, p := range want {
+		if i%2 == 0 {
+			if zsl.Delete(p.score, sds.NewString(p.ele)) == nil {
+				t.Fatalf("delete %v", p)
+			}
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	if zsl.Delete(0, sds.NewString("missing")) != nil {
+		t.Fatal("deleted missing element")
+	}
+	if zsl.Rank(0, sds.NewString("missing")) != 0 || zsl.ByRank(uint64(len(kept)+1)) != nil {
+		t.Fatal("found missing element")
+	}
+	checkSkiplist(t, zsl, kept)
+
+	zsl.Release()
+	if zsl.Len() != 0 || zsl.First() != nil || zsl.Last() != nil {
+		t.Fatal("release")
+	}
+}
+
+func TestUpdateScore(t *testing.T) {
+	zsl := Create()
+	want := []pair{{1, "a"}, {2, "b"}, {3, "c"}}
+	for _, p := range want {
+		zsl.Insert(p.score, sds.NewString(p.ele))
+	}
+
+	// Same position, updated in place.
+	n := zsl.First().Next()
+	if zsl.UpdateScore(2, sds.NewString("b"), 2.5) != n {
+		t.Fatal("not updated in place")
+	}
+	want[1].score = 2.5
+	checkSkiplist(t, zsl, want)
+
+	// Moved to the head.
+	zsl.UpdateScore(3, sds.NewString("c"), 0)
+	want[2].score = 0
+	checkSkiplist(t, zsl, want)
+
+	if zsl.UpdateScore(1, sds.NewString("b"), 4) != nil {
+		t.Fatal("updated with a wrong score")
+	}
+}
+
+func TestRangeByScore(t *testing.T) {
+	zsl := Create()
+	for i := 1; i <= 10; i++ {
+		zsl.Insert(float64(i), sds.NewString(fmt.Sprintf("e%02d", i)))
+	}
+
+	tests := []struct {
+		min, max    string
+		first, last float64
+		empty       bool
+	}{
+		{"-inf", "+inf", 1, 10, false},
+		{"3", "5", 3, 5, false},
+		{"(3", "(5", 4, 4, false},
+		{"(3", "(4", 0, 0, true},
+		{"5", "3", 0, 0, true},
+		{"(5", "5", 0, 0, true},
+		{"10.5", "inf", 0, 0, true},
+		{"-inf", "0.5", 0, 0, true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange([]byte(tt.min), []byte(tt.max))
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, last := zsl.FirstInRange(r), zsl.LastInRange(r)
+		if tt.empty {
+			if first != nil || last != nil {
+				t.Fatalf("[%s, %s] not empty", tt.min, tt.max)
+			}
+			continue
+		}
+		if first == nil || last == nil || first.Score() != tt.first || last.Score() != tt.last {
+			t.Fatalf("[%s, %s] = %v, %v", tt.min, tt.max, first, last)
+		}
+	}
+
+	for _, bad := range []string{"", "(", "abc", "nan", "1x"} {
+		if _, err := ParseRange([]byte(bad), []byte("1")); err != ErrNotFloat {
+			t.Fatalf("%q: %v", bad, err)
+		}
+	}
+
+	var deleted []string
+	r, _ := ParseRange([]byte("(2"), []byte("4"))
+	if n := zsl.DeleteRangeByScore(r, func(ele *sds.SDS) {
+		deleted = append(deleted, ele.String())
+	}); n != 2 {
+		t.Fatalf("deleted %d", n)
+	}
+	if fmt.Sprint(deleted) != "[e03 e04]" {
+		t.Fatalf("deleted %v", deleted)
+	}
+
+	if n := zsl.DeleteRangeByRank(1, 2, nil); n != 2 {
+		t.Fatalf("deleted %d", n)
+	}
+	var want []pair
+	for i := 5; i <= 10; i++ {
+		want = append(want, pair{float64(i), fmt.Sprintf("e%02d", i)})
+	}
+	checkSkiplist(t, zsl, want)
+}
+
+func TestRangeByLex(t *testing.T) {
+	zsl := Create()
+	for _, e := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		zsl.Insert(0, sds.NewString(e))
+	}
+
+	tests := []struct {
+		min, max    string
+		first, last string
+	}{
+		{"-", "+", "a", "g"},
+		{"[b", "[d", "b", "d"},
+		{"(b", "(d", "c", "c"},
+		{"(aa", "[cc", "b", "c"},
+		{"-", "(a", "", ""},
+		{"[e", "[c", "", ""},
+		{"+", "-", "", ""},
+	}
+	for _, tt := range tests {
+		r, err := ParseLexRange([]byte(tt.min), []byte(tt.max))
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, last := zsl.FirstInLexRange(r), zsl.LastInLexRange(r)
+		if tt.first == "" {
+			if first != nil || last != nil {
+				t.Fatalf("[%s, %s] not empty", tt.min, tt.max)
+			}
+			continue
+		}
+		if first == nil || last == nil || first.Ele().String() != tt.first || last.Ele().String() != tt.last {
+			t.Fatalf("[%s, %s] = %v, %v", tt.min, tt.max, first, last)
+		}
+	}
+
+	for _, bad := range []string{"", "a", "+a", "-a"} {
+		if _, err := ParseLexRange([]byte(bad), []byte("+")); err != ErrNotLexRange {
+			t.Fatalf("%q: %v", bad, err)
+		}
+	}
+
+	r, _ := ParseLexRange([]byte("[b"), []byte("(e"))
+	if n := zsl.DeleteRangeByLex(r, nil); n != 3 {
+		t.Fatalf("deleted %d", n)
+	}
+	checkSkiplist(t, zsl, []pair{{0, "a"}, {0, "e"}, {0, "f"}, {0, "g"}})
+}
@@ -0,0 +1,660 @@
+package stream
+
+import (
+	"errors"
+	"time"
+
+	"rax"
+)
+
+// Error
+var (
+	// ErrBusyGroup the consumer group already exists.
+	ErrBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+	// ErrNoGroup the consumer group doesn't exist.
+	ErrNoGroup = errors.New("NOGROUP No such key or consumer group")
+)
+
+// group Consumer group.
+type group struct {
+	// Last delivered (not acknowledged) ID for this group. Consumers
+	// that will just ask for more messages will served with IDs > than
+	// this.
+	lastID ID
+	// Pending entries list. This is a radix tree that has every message
+	// delivered to consumers (without the NOACK option) that was yet not
+	// acknowledged as processed. The key of the radix tree is the ID as
+	// a 64 bit big endian number, while the associated value is a *nack.
+	pel *rax.Rax
+	// A radix tree representing the consumers by name and their
+	// associated *consumer.
+	consumers *rax.Rax
+}
+
+// consumer A specific consumer in a consumer group.
+type consumer struct {
+	// Last time this consumer was active (read or claimed), in ms.
+	seenTime int64
+	// Last time this consumer was successfully delivered or claimed an
+	// entry, in ms, -1 if never.
+	activeTime int64
+	// Consumer name. This is how the consumer will be identified in the
+	// consumer group protocol. Case sensitive.
+	name string
+	// Consumer specific pending entries list: all the pending messages
+	// delivered to this consumer not yet acknowledged. Keys are big
+	// endian message IDs, while values are the same *nack of the
+	// consumer group PEL.
+	pel *rax.Rax
+}
+
+// nack Pending (yet not acknowledged) message in a consumer group.
+type nack struct {
+	// Last time this message was delivered, in ms.
+	deliveryTime int64
+	// Number of times this message was delivered.
+	deliveryCount uint64
+	// The consumer this message was delivered to in the last delivery.
+	consumer *consumer
+}
+
+// PendingEntry a pending entry, as reported by the extended form of
+// XPENDING.
+type PendingEntry struct {
+	ID            ID
+	Consumer      string
+	Idle          time.Duration
+	DeliveryCount uint64
+}
+
+// PendingSummary the pending entries of a group, as reported by the
+// summary form of XPENDING.
+type PendingSummary struct {
+	Count int64
+	// Min, Max the smallest and greatest pending ID, if Count > 0.
+	Min, Max ID
+	// Consumers the consumers with at least one pending entry.
+	Consumers []ConsumerPending
+}
+
+// ConsumerPending the number of pending entries of a consumer.
+type ConsumerPending struct {
+	Name  string
+	Count int64
+}
+
+// ConsumerInfo a consumer, as reported by XINFO CONSUMERS.
+type ConsumerInfo struct {
+	Name    string
+	Pending int64
+	// Idle the time since the last interaction of the consumer.
+	Idle time.Duration
+	// Inactive the time since the last successful interaction of the
+	// consumer, -1 if never.
+	Inactive time.Duration
+}
+
+// GroupInfo a consumer group, as reported by XINFO GROUPS.
+type GroupInfo struct {
+	Name            string
+	Consumers       int64
+	Pending         int64
+	LastDeliveredID ID
+}
+
+// ClaimArgs the options of XCLAIM.
+type ClaimArgs struct {
+	// Idle Set the idle time of the claimed entries, the default is 0,
+	// that is the last delivery time is reset to now. The TIME option
+	// is the same as an idle of now minus the unix time.
+	Idle time.Duration
+	// RetryCount Set the delivery count, if not nil. Otherwise the
+	// delivery count is incremented, unless JustID is set.
+	RetryCount *uint64
+	// Force Create the pending entry if it doesn't exist, as long as
+	// the entry exists in the stream.
+	Force bool
+	// JustID Return just the IDs of the claimed entries, without
+	// incrementing the delivery count.
+	JustID bool
+	// LastID Update the last delivered ID of the group, if greater.
+	LastID *ID
+}
+
+func (s *Stream) msnow() int64 {
+	return int64(s.mstime())
+}
+
+// elapsed Return the duration since 'ms', never negative.
+func elapsed(now, ms int64) time.Duration {
+	if now < ms {
+		return 0
+	}
+	return time.Duration(now-ms) * time.Millisecond
+}
+
+// lookupGroup Return the consumer group with the name, nil if it doesn't
+// exist.
+func (s *Stream) lookupGroup(name string) *group {
+	v, ok := s.cgroups.Find([]byte(name))
+	if !ok {
+		return nil
+	}
+	return v.(*group)
+}
+
+// CreateGroup Create a new consumer group with the name and the last
+// delivered ID 'id', like XGROUP CREATE.
+func (s *Stream) CreateGroup(name string, id ID) error {
+	g := &group{
+		lastID:    id,
+		pel:       rax.Create(),
+		consumers: rax.Create(),
+	}
+	if _, inserted := s.cgroups.TryInsert([]byte(name), g); !inserted {
+		return ErrBusyGroup
+	}
+	return nil
+}
+
+// DestroyGroup Remove the consumer group with the name, like XGROUP
+// DESTROY. Returns false if the group doesn't exist.
+func (s *Stream) DestroyGroup(name string) bool {
+	_, removed := s.cgroups.Remove([]byte(name))
+	return removed
+}
+
+// SetGroupID Set the last delivered ID of the consumer group, like XGROUP
+// SETID.
+func (s *Stream) SetGroupID(name string, id ID) error {
+	g := s.lookupGroup(name)
+	if g == nil {
+		return ErrNoGroup
+	}
+	g.lastID = id
+	return nil
+}
+
+// Groups Return the consumer groups ordered by name, like XINFO GROUPS.
+func (s *Stream) Groups() []GroupInfo {
+	var infos []GroupInfo
+	it := s.cgroups.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		g := it.Value().(*group)
+		infos = append(infos, GroupInfo{
+			Name:            string(it.Key()),
+			Consumers:       int64(g.consumers.Len()),
+			Pending:         int64(g.pel.Len()),
+			LastDeliveredID: g.lastID,
+		})
+	}
+	return infos
+}
+
+// lookupConsumer Lookup the consumer with the specified name in the
+// group, create it if 'create' is set.
+func (s *Stream) lookupConsumer(g *group, name string, create bool) *consumer {
+	if v, ok := g.consumers.Find([]byte(name)); ok {
+		return v.(*consumer)
+	}
+	if !create {
+		return nil
+	}
+	c := &consumer{
+		name:       name,
+		seenTime:   s.msnow(),
+		activeTime: -1,
+		pel:        rax.Create(),
+	}
+	g.consumers.Insert([]byte(name), c)
+	return c
+}
+
+// CreateConsumer Create the consumer in the group, like XGROUP
+// CREATECONSUMER. Returns false if the consumer already exists.
+func (s *Stream) CreateConsumer(groupName, name string) (bool, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return false, ErrNoGroup
+	}
+	if s.lookupConsumer(g, name, false) != nil {
+		return false, nil
+	}
+	s.lookupConsumer(g, name, true)
+	return true, nil
+}
+
+// DeleteConsumer Delete the consumer from the group, like XGROUP
+// DELCONSUMER. The pending entries of the consumer are removed from the
+// group too. Returns the number of pending entries the consumer had.
+func (s *Stream) DeleteConsumer(groupName, name string) (int64, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return 0, ErrNoGroup
+	}
+	c := s.lookupConsumer(g, name, false)
+	if c == nil {
+		return 0, nil
+	}
+
+	// Iterate all the consumer pending messages, deleting every
+	// corresponding entry from the global entry.
+	pending := int64(c.pel.Len())
+	it := c.pel.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		g.pel.Remove(it.Key())
+	}
+	g.consumers.Remove([]byte(name))
+	return pending, nil
+}
+
+// ReadGroup Read the entries as the consumer of the group, like
+// XREADGROUP, the consumer is created if it doesn't exist.
+//
+// When 'id' is nil, the ">" special ID, the entries never delivered to
+// the group are returned, and added to the pending entries of the
+// consumer, unless 'noack' is set.
+//
+// Otherwise the history of the pending entries of the consumer with an
+// ID greater than 'id' is returned, incrementing their delivery count.
+// The pending entries that were deleted from the stream are returned
+// with nil fields.
+//
+// At most 'count' entries are returned, 0 meaning all of them.
+func (s *Stream) ReadGroup(groupName, consumerName string, id *ID, count int64, noack bool) ([]Entry, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return nil, ErrNoGroup
+	}
+	c := s.lookupConsumer(g, consumerName, true)
+	now := s.msnow()
+	c.seenTime = now
+
+	if id != nil {
+		start, ok := id.Incr()
+		if !ok {
+			return nil, nil
+		}
+		return s.readConsumerPEL(c, start, count, now), nil
+	}
+
+	start, ok := g.lastID.Incr()
+	if !ok {
+		return nil, nil
+	}
+	var entries []Entry
+	it := s.iteratorStart(start, MaxID, false)
+	for count == 0 || int64(len(entries)) < count {
+		eid, fields, ok := it.next()
+		if !ok {
+			break
+		}
+		entries = append(entries, Entry{ID: eid, Fields: fields})
+
+		// Update the group last_id if needed.
+		if eid.Compare(g.lastID) > 0 {
+			g.lastID = eid
+		}
+		if noack {
+			continue
+		}
+
+		// Try to add a new NACK. Most of the time this will work and
+		// will not require extra lookups. We'll fix the problem later
+		// if we find that there is already a NACK for the entry, that
+		// is possible if the group last ID was set backward with
+		// SETID.
+		n := &nack{deliveryTime: now, deliveryCount: 1, consumer: c}
+		key := eid.encode()
+		if old, inserted := g.pel.Insert(key, n); !inserted {
+			old.(*nack).consumer.pel.Remove(key)
+		}
+		c.pel.Insert(key, n)
+	}
+	if len(entries) > 0 {
+		c.activeTime = now
+	}
+	return entries, nil
+}
+
+// readConsumerPEL Return the pending entries of the consumer with an ID
+// greater or equal to 'start', updating the delivery count and time.
+func (s *Stream) readConsumerPEL(c *consumer, start ID, count int64, now int64) []Entry {
+	entries := []Entry{}
+	it := c.pel.Iterator()
+	it.Seek(">=", start.encode())
+	for (count == 0 || int64(len(entries)) < count) && it.Next() {
+		id := decodeID(it.Key())
+		n := it.Value().(*nack)
+
+		// If the entry is no longer in the stream, the fields are nil.
+		var fields [][]byte
+		if eid, f, ok := s.iteratorStart(id, id, false).next(); ok && eid == id {
+			fields = f
+		}
+		entries = append(entries, Entry{ID: id, Fields: fields})
+
+		n.deliveryTime = now
+		n.deliveryCount++
+	}
+	return entries
+}
+
+// Ack Acknowledge the pending entries of the group, like XACK. Returns
+// the number of acknowledged entries.
+func (s *Stream) Ack(groupName string, ids ...ID) (int64, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return 0, nil
+	}
+
+	var acknowledged int64
+	for _, id := range ids {
+		key := id.encode()
+		// Lookup the ID in the group PEL: it will have a reference to
+		// the NACK structure that will have a reference to the
+		// consumer, so that we are able to remove the entry from both
+		// PELs.
+		v, ok := g.pel.Find(key)
+		if !ok {
+			continue
+		}
+		g.pel.Remove(key)
+		v.(*nack).consumer.pel.Remove(key)
+		acknowledged++
+	}
+	return acknowledged, nil
+}
+
+// PendingSummary Return the summary of the pending entries of the group,
+// like XPENDING without range.
+func (s *Stream) PendingSummary(groupName string) (*PendingSummary, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return nil, ErrNoGroup
+	}
+
+	summary := &PendingSummary{Count: int64(g.pel.Len())}
+	if summary.Count == 0 {
+		return summary, nil
+	}
+
+	it := g.pel.Iterator()
+	it.Seek("^", nil)
+	it.Next()
+	summary.Min = decodeID(it.Key())
+	it.Seek("$", nil)
+	it.Prev()
+	summary.Max = decodeID(it.Key())
+
+	ci := g.consumers.Iterator()
+	ci.Seek("^", nil)
+	for ci.Next() {
+		c := ci.Value().(*consumer)
+		if c.pel.Len() == 0 {
+			continue
+		}
+		summary.Consumers = append(summary.Consumers, ConsumerPending{
+			Name:  c.name,
+			Count: int64(c.pel.Len()),
+		})
+	}
+	return summary, nil
+}
+
+// Pending Return the pending entries of the group with an ID between
+// start and end, like the extended form of XPENDING. At most 'count'
+// entries are returned, only the ones delivered to 'consumerName' if not
+// empty, and idle for at least 'minIdle'.
+func (s *Stream) Pending(groupName string, start, end ID, count int64, consumerName string, minIdle time.Duration) ([]PendingEntry, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return nil, ErrNoGroup
+	}
+
+	pel := g.pel
+	if consumerName != "" {
+		c := s.lookupConsumer(g, consumerName, false)
+		if c == nil {
+			return []PendingEntry{}, nil
+		}
+		pel = c.pel
+	}
+
+	now := s.msnow()
+	entries := []PendingEntry{}
+	it := pel.Iterator()
+	it.Seek(">=", start.encode())
+	for int64(len(entries)) < count && it.Next() {
+		id := decodeID(it.Key())
+		if id.Compare(end) > 0 {
+			break
+		}
+		n := it.Value().(*nack)
+		idle := elapsed(now, n.deliveryTime)
+		if idle < minIdle {
+			continue
+		}
+		entries = append(entries, PendingEntry{
+			ID:            id,
+			Consumer:      n.consumer.name,
+			Idle:          idle,
+			DeliveryCount: n.deliveryCount,
+		})
+	}
+	return entries, nil
+}
+
+// Claim Change the ownership of the pending entries of the group idle
+// for at least 'minIdle' to the consumer, like XCLAIM. The consumer is
+// created if it doesn't exist. The pending entries no longer in the
+// stream are removed from the group. Returns the claimed entries, with
+// nil fields if JustID is set.
+func (s *Stream) Claim(groupName, consumerName string, minIdle time.Duration, ids []ID, args *ClaimArgs) ([]Entry, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return nil, ErrNoGroup
+	}
+	if args == nil {
+		args = &ClaimArgs{}
+	}
+
+	now := s.msnow()
+	deliveryTime := now - int64(args.Idle/time.Millisecond)
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	// If the provided last ID is greater than the one of the group, we
+	// update the group last ID.
+	if args.LastID != nil && args.LastID.Compare(g.lastID) > 0 {
+		g.lastID = *args.LastID
+	}
+
+	var c *consumer
+	entries := []Entry{}
+	for _, id := range ids {
+		key := id.encode()
+
+		// Lookup the ID in the group PEL.
+		var n *nack
+		if v, ok := g.pel.Find(key); ok {
+			n = v.(*nack)
+		}
+
+		// Item must exist for us to transfer it to another consumer.
+		if !s.entryExists(id) {
+			// Clear this entry from the PEL, it no longer exists.
+			if n != nil {
+				g.pel.Remove(key)
+				n.consumer.pel.Remove(key)
+			}
+			continue
+		}
+
+		// If FORCE is passed, let's check if at least the entry exists
+		// in the stream. In such case, we'll create a new entry in the
+		// PEL from scratch, so that XCLAIM can also be used to create
+		// entries in the PEL. Useful for AOF and replication of
+		// consumer groups.
+		if args.Force && n == nil {
+			n = &nack{}
+			g.pel.Insert(key, n)
+		}
+		if n == nil {
+			continue
+		}
+
+		// We need to check if the minimum idle time requested by the
+		// caller is satisfied by this entry.
+		if minIdle > 0 && elapsed(now, n.deliveryTime) < minIdle {
+			continue
+		}
+
+		if c == nil {
+			c = s.lookupConsumer(g, consumerName, true)
+		}
+		if n.consumer != c {
+			// Remove the entry from the old consumer. Note that the
+			// nack may be just created with FORCE.
+			if n.consumer != nil {
+				n.consumer.pel.Remove(key)
+			}
+			// Update the consumer and idle time.
+			n.consumer = c
+			c.pel.Insert(key, n)
+		}
+		n.deliveryTime = deliveryTime
+		// Set the delivery attempts counter if given, otherwise
+		// autoincrement unless JUSTID option provided.
+		if args.RetryCount != nil {
+			n.deliveryCount = *args.RetryCount
+		} else if !args.JustID {
+			n.deliveryCount++
+		}
+
+		c.activeTime = now
+		entry := Entry{ID: id}
+		if !args.JustID {
+			_, entry.Fields, _ = s.iteratorStart(id, id, false).next()
+		}
+		entries = append(entries, entry)
+	}
+	if c != nil {
+		c.seenTime = now
+	}
+	return entries, nil
+}
+
+// AutoClaim Claim the pending entries of the group with an ID greater or
+// equal to 'start' and idle for at least 'minIdle', like XAUTOCLAIM. At
+// most 'count' entries are claimed, scanning at most count*10 pending
+// entries.
+//
+// Returns the ID to use as 'start' of the next call, 0-0 when the scan is
+// complete, the claimed entries, with nil fields if 'justID' is set, and
+// the IDs of the pending entries no longer in the stream, that are
+// removed from the group.
+func (s *Stream) AutoClaim(groupName, consumerName string, minIdle time.Duration, start ID, count int64, justID bool) (next ID, claimed []Entry, deleted []ID, err error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return ID{}, nil, nil, ErrNoGroup
+	}
+
+	now := s.msnow()
+	c := s.lookupConsumer(g, consumerName, true)
+	c.seenTime = now
+
+	claimed = []Entry{}
+	deleted = []ID{}
+	attempts := count * 10
+
+	it := g.pel.Iterator()
+	it.Seek(">=", start.encode())
+	more := false
+	for attempts > 0 && count > 0 {
+		if more = it.Next(); !more {
+			break
+		}
+		attempts--
+
+		key := append([]byte{}, it.Key()...)
+		id := decodeID(key)
+		n := it.Value().(*nack)
+
+		// Item must exist for us to transfer it to another consumer.
+		if !s.entryExists(id) {
+			// Clear this entry from the PEL, it no longer exists.
+			g.pel.Remove(key)
+			n.consumer.pel.Remove(key)
+			deleted = append(deleted, id)
+			// Remember the ID for the iterator, invalidated by the
+			// removal.
+			it.Seek(">", key)
+			continue
+		}
+
+		if minIdle > 0 && elapsed(now, n.deliveryTime) < minIdle {
+			continue
+		}
+
+		if n.consumer != c {
+			// Remove the entry from the old consumer.
+			n.consumer.pel.Remove(key)
+			// Update the consumer and idle time.
+			n.consumer = c
+			c.pel.Insert(key, n)
+		}
+		n.deliveryTime = now
+		// Increment the delivery attempts counter unless JUSTID
+		// option provided.
+		if !justID {
+			n.deliveryCount++
+		}
+
+		c.activeTime = now
+		entry := Entry{ID: id}
+		if !justID {
+			_, entry.Fields, _ = s.iteratorStart(id, id, false).next()
+		}
+		claimed = append(claimed, entry)
+		count--
+	}
+
+	// We need to return the next entry as a cursor for the next
+	// XAUTOCLAIM call.
+	if more && it.Next() {
+		next = decodeID(it.Key())
+	}
+	return next, claimed, deleted, nil
+}
+
+// Consumers Return the consumers of the group ordered by name, like
+// XINFO CONSUMERS.
+func (s *Stream) Consumers(groupName string) ([]ConsumerInfo, error) {
+	g := s.lookupGroup(groupName)
+	if g == nil {
+		return nil, ErrNoGroup
+	}
+
+	now := s.msnow()
+	infos := []ConsumerInfo{}
+	it := g.consumers.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		c := it.Value().(*consumer)
+		info := ConsumerInfo{
+			Name:     c.name,
+			Pending:  int64(c.pel.Len()),
+			Idle:     elapsed(now, c.seenTime),
+			Inactive: -1,
+		}
+		if c.activeTime != -1 {
+			info.Inactive = elapsed(now, c.activeTime)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
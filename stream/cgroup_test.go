@@ -0,0 +1,249 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroups(t *testing.T) {
+	s := Create()
+	if err := s.CreateGroup("g1", ID{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateGroup("g1", ID{}); err != ErrBusyGroup {
+		t.Fatalf("CreateGroup %v", err)
+	}
+	if err := s.CreateGroup("g0", ID{5, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetGroupID("nogroup", ID{}); err != ErrNoGroup {
+		t.Fatalf("SetGroupID %v", err)
+	}
+
+	groups := s.Groups()
+	if len(groups) != 2 || groups[0].Name != "g0" || groups[0].LastDeliveredID != (ID{5, 0}) || groups[1].Name != "g1" {
+		t.Fatalf("groups %+v", groups)
+	}
+
+	if created, _ := s.CreateConsumer("g1", "alice"); !created {
+		t.Fatal("CreateConsumer")
+	}
+	if created, _ := s.CreateConsumer("g1", "alice"); created {
+		t.Fatal("CreateConsumer twice")
+	}
+	if _, err := s.CreateConsumer("nogroup", "alice"); err != ErrNoGroup {
+		t.Fatalf("CreateConsumer %v", err)
+	}
+
+	if !s.DestroyGroup("g0") || s.DestroyGroup("g0") {
+		t.Fatal("DestroyGroup")
+	}
+	if _, err := s.ReadGroup("g0", "alice", nil, 0, false); err != ErrNoGroup {
+		t.Fatalf("ReadGroup %v", err)
+	}
+}
+
+func TestReadGroup(t *testing.T) {
+	clock := newClock()
+	s := Create(WithClock(clock.now))
+	entries := fill(t, s, 10)
+	s.CreateGroup("g", ID{})
+
+	got, err := s.ReadGroup("g", "alice", nil, 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, got, entries[:3])
+	got, _ = s.ReadGroup("g", "bob", nil, 2, false)
+	checkEntries(t, got, entries[3:5])
+	// NOACK entries are not added to the PEL.
+	got, _ = s.ReadGroup("g", "bob", nil, 1, true)
+	checkEntries(t, got, entries[5:6])
+
+	summary, err := s.PendingSummary("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 5 || summary.Min != (ID{1, 0}) || summary.Max != (ID{5, 0}) ||
+		len(summary.Consumers) != 2 || summary.Consumers[0] != (ConsumerPending{"alice", 3}) ||
+		summary.Consumers[1] != (ConsumerPending{"bob", 2}) {
+		t.Fatalf("summary %+v", summary)
+	}
+
+	// The history of the consumer.
+	clock.advance(time.Second)
+	got, _ = s.ReadGroup("g", "alice", &ID{1, 0}, 0, false)
+	checkEntries(t, got, entries[1:3])
+	pending, _ := s.Pending("g", ID{}, MaxID, 10, "", 0)
+	if len(pending) != 5 {
+		t.Fatalf("pending %+v", pending)
+	}
+	if p := pending[0]; p.ID != (ID{1, 0}) || p.Consumer != "alice" || p.DeliveryCount != 1 || p.Idle != time.Second {
+		t.Fatalf("pending %+v", p)
+	}
+	if p := pending[1]; p.ID != (ID{2, 0}) || p.DeliveryCount != 2 || p.Idle != 0 {
+		t.Fatalf("pending %+v", p)
+	}
+	pending, _ = s.Pending("g", ID{}, MaxID, 10, "bob", 0)
+	if len(pending) != 2 || pending[0].ID != (ID{4, 0}) {
+		t.Fatalf("pending bob %+v", pending)
+	}
+	pending, _ = s.Pending("g", ID{}, MaxID, 10, "", time.Second)
+	if len(pending) != 3 {
+		t.Fatalf("pending idle %+v", pending)
+	}
+	pending, _ = s.Pending("g", ID{2, 0}, ID{4, 0}, 2, "", 0)
+	if len(pending) != 2 || pending[0].ID != (ID{2, 0}) || pending[1].ID != (ID{3, 0}) {
+		t.Fatalf("pending range %+v", pending)
+	}
+
+	// Deleted entries are returned with nil fields.
+	s.Trim(&TrimArgs{Strategy: TrimMinID, MinID: ID{3, 0}})
+	got, _ = s.ReadGroup("g", "alice", &ID{}, 0, false)
+	if len(got) != 3 || got[0].Fields != nil || got[1].Fields != nil || got[2].Fields == nil {
+		t.Fatalf("history %+v", got)
+	}
+
+	if n, _ := s.Ack("g", ID{1, 0}, ID{2, 0}, ID{4, 0}, ID{9, 0}); n != 3 {
+		t.Fatalf("Ack %d", n)
+	}
+	if n, _ := s.Ack("g", ID{1, 0}); n != 0 {
+		t.Fatalf("Ack twice %d", n)
+	}
+	if n, _ := s.Ack("nogroup", ID{3, 0}); n != 0 {
+		t.Fatalf("Ack no group %d", n)
+	}
+	summary, _ = s.PendingSummary("g")
+	if summary.Count != 2 || summary.Min != (ID{3, 0}) || summary.Max != (ID{5, 0}) {
+		t.Fatalf("summary %+v", summary)
+	}
+
+	// Setting the group ID backward redelivers the entries.
+	s.SetGroupID("g", ID{4, 0})
+	got, _ = s.ReadGroup("g", "carol", nil, 0, false)
+	checkEntries(t, got, entries[4:])
+	pending, _ = s.Pending("g", ID{5, 0}, ID{5, 0}, 1, "", 0)
+	if len(pending) != 1 || pending[0].Consumer != "carol" {
+		t.Fatalf("pending %+v", pending)
+	}
+	if pending, _ = s.Pending("g", ID{}, MaxID, 10, "bob", 0); len(pending) != 0 {
+		t.Fatalf("pending bob %+v", pending)
+	}
+	if got, _ = s.ReadGroup("g", "carol", nil, 0, false); len(got) != 0 {
+		t.Fatalf("nothing new %+v", got)
+	}
+
+	n, _ := s.DeleteConsumer("g", "carol")
+	if n != 6 {
+		t.Fatalf("DeleteConsumer %d", n)
+	}
+	summary, _ = s.PendingSummary("g")
+	if summary.Count != 1 || summary.Min != (ID{3, 0}) {
+		t.Fatalf("summary %+v", summary)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	clock := newClock()
+	s := Create(WithClock(clock.now))
+	entries := fill(t, s, 10)
+	s.CreateGroup("g", ID{})
+	s.ReadGroup("g", "alice", nil, 5, false)
+
+	clock.advance(10 * time.Second)
+	s.ReadGroup("g", "alice", &ID{}, 1, false) // Reset the idle of 1-0.
+
+	got, err := s.Claim("g", "bob", 5*time.Second, []ID{{1, 0}, {2, 0}, {3, 0}, {9, 0}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, got, entries[1:3])
+	pending, _ := s.Pending("g", ID{}, MaxID, 10, "bob", 0)
+	if len(pending) != 2 || pending[0].DeliveryCount != 2 || pending[0].Idle != 0 {
+		t.Fatalf("pending %+v", pending)
+	}
+
+	// JUSTID doesn't increment the delivery count, RETRYCOUNT sets it.
+	retry := uint64(7)
+	got, _ = s.Claim("g", "carol", 0, []ID{{4, 0}}, &ClaimArgs{JustID: true, Idle: time.Minute})
+	if len(got) != 1 || got[0].ID != (ID{4, 0}) || got[0].Fields != nil {
+		t.Fatalf("claim justid %+v", got)
+	}
+	s.Claim("g", "carol", 0, []ID{{5, 0}}, &ClaimArgs{RetryCount: &retry})
+	pending, _ = s.Pending("g", ID{}, MaxID, 10, "carol", 0)
+	if len(pending) != 2 || pending[0].DeliveryCount != 1 || pending[0].Idle != time.Minute ||
+		pending[1].DeliveryCount != 7 {
+		t.Fatalf("pending %+v", pending)
+	}
+
+	// FORCE creates the pending entry of an entry never delivered.
+	lastID := ID{9, 0}
+	got, _ = s.Claim("g", "carol", 0, []ID{{8, 0}, {11, 0}}, &ClaimArgs{Force: true, LastID: &lastID})
+	checkEntries(t, got, entries[7:8])
+	if groups := s.Groups(); groups[0].LastDeliveredID != lastID || groups[0].Pending != 6 {
+		t.Fatalf("groups %+v", groups)
+	}
+
+	// Entries no longer in the stream are removed from the PEL.
+	s.Trim(&TrimArgs{Strategy: TrimMinID, MinID: ID{2, 0}})
+	if got, _ = s.Claim("g", "carol", 0, []ID{{1, 0}}, nil); len(got) != 0 {
+		t.Fatalf("claim deleted %+v", got)
+	}
+	if pending, _ = s.Pending("g", ID{}, MaxID, 10, "alice", 0); len(pending) != 0 {
+		t.Fatalf("pending alice %+v", pending)
+	}
+
+	consumers, _ := s.Consumers("g")
+	if len(consumers) != 3 || consumers[0].Name != "alice" || consumers[0].Pending != 0 ||
+		consumers[1].Name != "bob" || consumers[1].Pending != 2 || consumers[2].Pending != 3 {
+		t.Fatalf("consumers %+v", consumers)
+	}
+	clock.advance(time.Second)
+	consumers, _ = s.Consumers("g")
+	if consumers[1].Idle != time.Second || consumers[1].Inactive != time.Second {
+		t.Fatalf("consumer %+v", consumers[1])
+	}
+	s.CreateConsumer("g", "dave")
+	consumers, _ = s.Consumers("g")
+	if consumers[3].Inactive != -1 {
+		t.Fatalf("consumer %+v", consumers[3])
+	}
+}
+
+func TestAutoClaim(t *testing.T) {
+	clock := newClock()
+	s := Create(WithClock(clock.now))
+	entries := fill(t, s, 10)
+	s.CreateGroup("g", ID{})
+	s.ReadGroup("g", "alice", nil, 0, false)
+	clock.advance(time.Minute)
+
+	// Delete some of the pending entries.
+	s.Trim(&TrimArgs{Strategy: TrimMinID, MinID: ID{3, 0}})
+
+	next, claimed, deleted, err := s.AutoClaim("g", "bob", time.Second, ID{}, 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, claimed, entries[2:5])
+	if len(deleted) != 2 || deleted[0] != (ID{1, 0}) || deleted[1] != (ID{2, 0}) {
+		t.Fatalf("deleted %v", deleted)
+	}
+	if next != (ID{6, 0}) {
+		t.Fatalf("next %v", next)
+	}
+
+	// The claimed entries are no longer idle.
+	next, claimed, _, _ = s.AutoClaim("g", "bob", time.Second, ID{}, 100, true)
+	if len(claimed) != 5 || claimed[0].ID != (ID{6, 0}) || claimed[0].Fields != nil || next != (ID{}) {
+		t.Fatalf("claimed %+v next %v", claimed, next)
+	}
+	pending, _ := s.Pending("g", ID{}, MaxID, 100, "bob", 0)
+	if len(pending) != 8 || pending[0].DeliveryCount != 2 || pending[7].DeliveryCount != 1 {
+		t.Fatalf("pending %+v", pending)
+	}
+
+	if _, _, _, err = s.AutoClaim("nogroup", "bob", 0, ID{}, 1, false); err != ErrNoGroup {
+		t.Fatalf("AutoClaim %v", err)
+	}
+}
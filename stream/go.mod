@@ -0,0 +1,14 @@
+module stream
+
+go 1.14
+
+require (
+	listpack v0.0.0
+	rax v0.0.0
+)
+
+replace (
+	listpack => ../listpack
+	rax => ../rax
+	util => ../util
+)
@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// ID Stream item ID: a 128 bit number composed of a milliseconds time
+// and a sequence counter. IDs generated in the same millisecond (or in a
+// past millisecond if the clock jumped backward) will use the millisecond
+// time of the latest generated ID and an incremented sequence.
+type ID struct {
+	Ms  uint64 // Unix time in milliseconds.
+	Seq uint64 // Sequence number.
+}
+
+// MaxID The greatest ID.
+var MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// String Return the ID as "<ms>-<seq>".
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare Return -1 if id < other, 0 if equal, 1 if id > other.
+func (id ID) Compare(other ID) int {
+	if id.Ms > other.Ms {
+		return 1
+	} else if id.Ms < other.Ms {
+		return -1
+	}
+	// The ms part is the same. Check the sequence part.
+	if id.Seq > other.Seq {
+		return 1
+	} else if id.Seq < other.Seq {
+		return -1
+	}
+	// Everything is the same: IDs are equal.
+	return 0
+}
+
+// Incr Return the next ID, ok is false if the ID is the greatest ID.
+func (id ID) Incr() (next ID, ok bool) {
+	if id.Seq == math.MaxUint64 {
+		if id.Ms == math.MaxUint64 {
+			// Special case where 'id' is the last possible streamID...
+			return id, false
+		}
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+}
+
+// Decr Return the previous ID, ok is false if the ID is 0-0.
+func (id ID) Decr() (prev ID, ok bool) {
+	if id.Seq == 0 {
+		if id.Ms == 0 {
+			// Special case where 'id' is the first possible streamID...
+			return id, false
+		}
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+}
+
+// encode Convert the ID into a 128 bit big endian number, so that the
+// IDs can be sorted lexicographically, as the keys of the radix tree.
+func (id ID) encode() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return buf
+}
+
+// decodeID This is the reverse of encode: the decoded ID is returned.
+func decodeID(buf []byte) ID {
+	return ID{
+		Ms:  binary.BigEndian.Uint64(buf),
+		Seq: binary.BigEndian.Uint64(buf[8:]),
+	}
+}
+
+// parseUint Parse an unsigned 64 bit number, rejecting signs and spaces.
+func parseUint(b []byte) (uint64, bool) {
+	if len(b) == 0 || b[0] < '0' || b[0] > '9' {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(b), 10, 64)
+	return v, err == nil
+}
+
+// ParseID Parse a stream ID in the format given by clients, "<ms>-<seq>",
+// or just "<ms>" in which case the sequence is 'missingSeq'. The special
+// IDs "-" and "+" are the smallest and the greatest IDs.
+func ParseID(b []byte, missingSeq uint64) (ID, error) {
+	id, seqGiven, err := parseID(b, true, missingSeq)
+	if err == nil && !seqGiven {
+		return id, ErrInvalidID
+	}
+	return id, err
+}
+
+// parseID Like ParseID, "-" and "+" are accepted only when 'special' is
+// set. The sequence can also be "*", like in "<ms>-*", in which case
+// 'seqGiven' is false and the caller must generate the sequence.
+func parseID(b []byte, special bool, missingSeq uint64) (id ID, seqGiven bool, err error) {
+	if len(b) > 127 {
+		return id, false, ErrInvalidID
+	}
+
+	// Handle the "-" and "+" special cases.
+	if len(b) == 1 && (b[0] == '-' || b[0] == '+') {
+		if !special {
+			return id, false, ErrInvalidID
+		}
+		if b[0] == '-' {
+			return ID{}, true, nil
+		}
+		return MaxID, true, nil
+	}
+
+	// Parse <ms>-<seq> form.
+	seqGiven = true
+	dot := bytes.IndexByte(b, '-')
+	msPart := b
+	if dot != -1 {
+		msPart = b[:dot]
+	}
+	ms, ok := parseUint(msPart)
+	if !ok {
+		return id, false, ErrInvalidID
+	}
+
+	seq := missingSeq
+	if dot != -1 {
+		seqPart := b[dot+1:]
+		if len(seqPart) == 1 && seqPart[0] == '*' {
+			seq = 0
+			seqGiven = false
+		} else if seq, ok = parseUint(seqPart); !ok {
+			return id, false, ErrInvalidID
+		}
+	}
+	return ID{Ms: ms, Seq: seq}, seqGiven, nil
+}
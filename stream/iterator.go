@@ -0,0 +1,302 @@
+package stream
+
+import (
+	"errors"
+
+	"listpack"
+	"rax"
+)
+
+// Error
+var (
+	// ErrInvalidStart the exclusive start ID can't be incremented.
+	ErrInvalidStart = errors.New("invalid start ID for the interval")
+	// ErrInvalidEnd the exclusive end ID can't be decremented.
+	ErrInvalidEnd = errors.New("invalid end ID for the interval")
+)
+
+// iterator iterates the entries of the stream with an ID between start
+// and end, both inclusive, skipping the deleted entries.
+type iterator struct {
+	start, end ID
+	rev        bool
+	// Radix tree iterator.
+	ri *rax.Iterator
+
+	// The current listpack, nil when the next node must be loaded.
+	lp *listpack.Listpack
+	// ID of the master entry of the listpack.
+	masterID ID
+	// Number of the master fields.
+	masterFields int
+	// Offset of the first master field.
+	masterFieldsStart int
+	// Offset of the flags of the first entry.
+	firstEntry int
+	// Forward: the flags of the next entry. Reverse: the lp-count of the
+	// next entry. -1 when the listpack is exhausted.
+	p int
+}
+
+// iteratorStart Initialize the stream iterator, so that we can call
+// iterating functions to get the next items.
+func (s *Stream) iteratorStart(start, end ID, rev bool) *iterator {
+	it := &iterator{
+		start: start,
+		end:   end,
+		rev:   rev,
+		ri:    s.rax.Iterator(),
+	}
+
+	// Seek the correct node in the radix tree.
+	if !rev {
+		it.ri.Seek("<=", start.encode())
+		if it.ri.EOF() {
+			it.ri.Seek("^", nil)
+		}
+	} else {
+		it.ri.Seek("<=", end.encode())
+		if it.ri.EOF() {
+			it.ri.Seek("$", nil)
+		}
+	}
+	return it
+}
+
+// loadNode Load the next listpack of the radix tree. Returns false at
+// the end of the radix tree.
+func (it *iterator) loadNode() bool {
+	var ok bool
+	if it.rev {
+		ok = it.ri.Prev()
+	} else {
+		ok = it.ri.Next()
+	}
+	if !ok {
+		return false
+	}
+
+	it.lp = it.ri.Value().(*listpack.Listpack)
+	it.masterID = decodeID(it.ri.Key())
+
+	// The master entry is composed like in the following example:
+	//
+	// +-------+---------+------------+---------+--/--+---------+---------+-+
+	// | count | deleted | num-fields | field_1 | field_2 | ... | field_N |0|
+	// +-------+---------+------------+---------+--/--+---------+---------+-+
+	p := it.lp.First()
+	p = it.lp.Next(p) // Skip count.
+	p = it.lp.Next(p) // Skip deleted.
+	it.masterFields = int(lpGetInteger(it.lp, p))
+	p = it.lp.Next(p)
+	it.masterFieldsStart = p
+	for i := 0; i < it.masterFields+1; i++ {
+		p = it.lp.Next(p) // Skip the master fields and the terminator.
+	}
+	it.firstEntry = p
+
+	if it.rev {
+		it.p = it.lp.Last()
+	} else {
+		it.p = it.firstEntry
+	}
+	return true
+}
+
+// next Return the next entry of the iteration, ok is false when there
+// are no more entries in the range.
+func (it *iterator) next() (id ID, fields [][]byte, ok bool) {
+	for {
+		if it.lp == nil {
+			if !it.loadNode() {
+				return ID{}, nil, false
+			}
+			// The master ID is the smaller ID of the node, so all
+			// the following nodes are out of range.
+			if !it.rev && it.masterID.Compare(it.end) > 0 {
+				return ID{}, nil, false
+			}
+		}
+		if it.p == -1 {
+			it.lp = nil
+			continue
+		}
+
+		lp := it.lp
+		flagsOff := it.p
+		if it.rev {
+			// Seek the flags of the entry with the lp-count.
+			lpCount := int(lpGetInteger(lp, it.p))
+			for ; lpCount > 0; lpCount-- {
+				flagsOff = lp.Prev(flagsOff)
+			}
+		}
+
+		// Get the flags entry.
+		p := flagsOff
+		flags := lpGetInteger(lp, p)
+		p = lp.Next(p) // Seek ID.
+
+		// Get the ID: it is encoded as difference between the master
+		// ID and this entry ID.
+		id = it.masterID
+		id.Ms += uint64(lpGetInteger(lp, p))
+		p = lp.Next(p)
+		id.Seq += uint64(lpGetInteger(lp, p))
+		p = lp.Next(p)
+
+		// The number of entries is here or not depending on the flags.
+		numfields := it.masterFields
+		if flags&itemFlagSameFields == 0 {
+			numfields = int(lpGetInteger(lp, p))
+			p = lp.Next(p)
+		}
+
+		if flags&itemFlagDeleted == 0 {
+			fields = make([][]byte, 0, numfields*2)
+			mf := it.masterFieldsStart
+			for i := 0; i < numfields; i++ {
+				if flags&itemFlagSameFields != 0 {
+					fields = append(fields, lpGetBytes(lp, mf))
+					mf = lp.Next(mf)
+				} else {
+					fields = append(fields, lpGetBytes(lp, p))
+					p = lp.Next(p)
+				}
+				fields = append(fields, lpGetBytes(lp, p))
+				p = lp.Next(p)
+			}
+		} else {
+			fields = nil
+			for i := 0; i < numfields; i++ {
+				if flags&itemFlagSameFields == 0 {
+					p = lp.Next(p)
+				}
+				p = lp.Next(p)
+			}
+		}
+
+		// Move to the next entry: p is now the lp-count.
+		if !it.rev {
+			it.p = lp.Next(p)
+		} else if flagsOff == it.firstEntry {
+			it.p = -1
+		} else {
+			it.p = lp.Prev(flagsOff)
+		}
+
+		// Skip deleted entries.
+		if flags&itemFlagDeleted != 0 {
+			continue
+		}
+
+		// If we are iterating in normal order, skip the entries that
+		// are smaller than the start ID, and stop at the first entry
+		// greater than the end ID. And the other way around in reverse
+		// order.
+		if !it.rev {
+			if id.Compare(it.start) < 0 {
+				continue
+			}
+			if id.Compare(it.end) > 0 {
+				return ID{}, nil, false
+			}
+		} else {
+			if id.Compare(it.end) > 0 {
+				continue
+			}
+			if id.Compare(it.start) < 0 {
+				return ID{}, nil, false
+			}
+		}
+		return id, fields, true
+	}
+}
+
+// Range Return the entries with an ID between start and end, both
+// inclusive, like XRANGE, or XREVRANGE in reverse order when 'rev' is
+// set. At most 'count' entries are returned, 0 meaning all of them.
+func (s *Stream) Range(start, end ID, count int64, rev bool) []Entry {
+	var entries []Entry
+	it := s.iteratorStart(start, end, rev)
+	for count == 0 || int64(len(entries)) < count {
+		id, fields, ok := it.next()
+		if !ok {
+			break
+		}
+		entries = append(entries, Entry{ID: id, Fields: fields})
+	}
+	return entries
+}
+
+// parseRangeID Parse a range argument of XRANGE, that can also be an
+// exclusive ID "(<ms>-<seq>".
+func parseRangeID(b []byte, missingSeq uint64) (id ID, exclusive bool, err error) {
+	if len(b) > 1 && b[0] == '(' {
+		id, seqGiven, err := parseID(b[1:], false, missingSeq)
+		if err == nil && !seqGiven {
+			err = ErrInvalidID
+		}
+		return id, true, err
+	}
+	id, err = ParseID(b, missingSeq)
+	return id, false, err
+}
+
+// XRange Implements XRANGE, and XREVRANGE when 'rev' is set, with the
+// range given as arguments: "-", "+", "<ms>", "<ms>-<seq>" or the
+// exclusive forms "(<ms>" and "(<ms>-<seq>". See Range for 'count'.
+func (s *Stream) XRange(start, end []byte, count int64, rev bool) ([]Entry, error) {
+	// Parse start/end IDs.
+	startID, startEx, err := parseRangeID(start, 0)
+	if err != nil {
+		return nil, err
+	}
+	if startEx {
+		var ok bool
+		if startID, ok = startID.Incr(); !ok {
+			return nil, ErrInvalidStart
+		}
+	}
+
+	endID, endEx, err := parseRangeID(end, MaxID.Seq)
+	if err != nil {
+		return nil, err
+	}
+	if endEx {
+		var ok bool
+		if endID, ok = endID.Decr(); !ok {
+			return nil, ErrInvalidEnd
+		}
+	}
+
+	if startID.Compare(endID) > 0 {
+		// Nothing to return.
+		return nil, nil
+	}
+	return s.Range(startID, endID, count, rev), nil
+}
+
+// Read Return the entries with an ID greater than 'after', like XREAD.
+// See Range for 'count'.
+func (s *Stream) Read(after ID, count int64) []Entry {
+	start, ok := after.Incr()
+	if !ok {
+		return nil
+	}
+	return s.Range(start, MaxID, count, false)
+}
+
+// entryExists Return true if the entry with the ID exists, and is not
+// deleted.
+func (s *Stream) entryExists(id ID) bool {
+	_, _, ok := s.iteratorStart(id, id, false).next()
+	return ok
+}
+
+// FirstID Return the ID of the first entry, ok is false if the stream is
+// empty.
+func (s *Stream) FirstID() (id ID, ok bool) {
+	id, _, ok = s.iteratorStart(ID{}, MaxID, false).next()
+	return id, ok
+}
@@ -0,0 +1,297 @@
+package stream
+
+// The access to the internals of the stream needed to persist it, as
+// rdb.c of redis does: the nodes of the radix tree are saved and loaded
+// as they are, along with the consumer groups and their pending entries.
+
+import (
+	"errors"
+
+	"listpack"
+	"rax"
+)
+
+// Error
+var (
+	// ErrCorrupt the loaded node or consumer group is malformed.
+	ErrCorrupt = errors.New("stream: corrupt data")
+)
+
+// PendingState a pending entry of a consumer group.
+type PendingState struct {
+	ID ID
+	// Last time this message was delivered, in ms.
+	DeliveryTime int64
+	// Number of times this message was delivered.
+	DeliveryCount uint64
+}
+
+// ConsumerState a consumer of a consumer group.
+type ConsumerState struct {
+	Name string
+	// Last time this consumer was active, in ms.
+	SeenTime int64
+	// Last time this consumer was delivered or claimed an entry, in ms,
+	// -1 if never.
+	ActiveTime int64
+	// The IDs of the pending entries of the consumer, they must be
+	// pending entries of the group.
+	Pending []ID
+}
+
+// GroupState a consumer group, with its pending entries and consumers.
+type GroupState struct {
+	Name      string
+	LastID    ID
+	Pending   []PendingState
+	Consumers []ConsumerState
+}
+
+// Nodes Call 'fn' with every node of the radix tree in order, the ID of
+// the master entry and the listpack. The listpack must not be modified.
+func (s *Stream) Nodes(fn func(master ID, lp *listpack.Listpack)) {
+	it := s.rax.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		fn(decodeID(it.Key()), it.Value().(*listpack.Listpack))
+	}
+}
+
+// LoadNode Append the node to the radix tree of a stream being loaded,
+// the nodes must be loaded in order. The listpack is validated and the
+// valid entries are added to the length of the stream, ErrCorrupt is
+// returned if it is malformed.
+func (s *Stream) LoadNode(master ID, lp *listpack.Listpack) error {
+	count, ok := validateNode(lp)
+	if !ok {
+		return ErrCorrupt
+	}
+
+	// The nodes are keyed in increasing order of their master ID.
+	it := s.rax.Iterator()
+	it.Seek("$", nil)
+	if it.Next() && decodeID(it.Key()).Compare(master) >= 0 {
+		return ErrCorrupt
+	}
+	s.rax.Insert(master.encode(), lp)
+	s.length += uint64(count)
+	return nil
+}
+
+// lpGetIntegerIfValid Return the integer at 'p', ok is false if 'p' is
+// not an integer.
+func lpGetIntegerIfValid(lp *listpack.Listpack, p int) (int64, bool) {
+	sval, lval, ok := lp.Get(p)
+	return lval, ok && sval == nil
+}
+
+// validateNode Validate the integrity of the entries of a node, the
+// listpack itself being already validated. Returns the number of valid
+// entries.
+func validateNode(lp *listpack.Listpack) (int64, bool) {
+	// Since we don't want to run validation of all records twice, we'll
+	// run the listpack validation of just the header and do the rest
+	// here.
+	p := lp.First()
+	if p == -1 {
+		// Empty listpack inside stream
+		return 0, false
+	}
+
+	// entry count
+	count, ok := lpGetIntegerIfValid(lp, p)
+	if !ok || count < 0 {
+		return 0, false
+	}
+	// deleted
+	p = lp.Next(p)
+	deleted, ok := lpGetIntegerIfValid(lp, p)
+	if !ok || deleted < 0 {
+		return 0, false
+	}
+	// num-of-fields
+	p = lp.Next(p)
+	masterFields, ok := lpGetIntegerIfValid(lp, p)
+	if !ok || masterFields < 0 {
+		return 0, false
+	}
+	// the field names
+	for j := int64(0); j < masterFields; j++ {
+		if p = lp.Next(p); p == -1 {
+			return 0, false
+		}
+	}
+	// the zero master entry terminator.
+	p = lp.Next(p)
+	if zero, ok := lpGetIntegerIfValid(lp, p); !ok || zero != 0 {
+		return 0, false
+	}
+	p = lp.Next(p)
+
+	var valid int64
+	for entries := count + deleted; entries > 0; entries-- {
+		fields, extraFields := masterFields, int64(3)
+		flags, ok := lpGetIntegerIfValid(lp, p)
+		if !ok {
+			return 0, false
+		}
+		if flags&itemFlagDeleted == 0 {
+			valid++
+		}
+		// entry id
+		p = lp.Next(p)
+		if _, ok := lpGetIntegerIfValid(lp, p); !ok {
+			return 0, false
+		}
+		p = lp.Next(p)
+		if _, ok := lpGetIntegerIfValid(lp, p); !ok {
+			return 0, false
+		}
+		p = lp.Next(p)
+
+		if flags&itemFlagSameFields == 0 {
+			// num-of-fields
+			if fields, ok = lpGetIntegerIfValid(lp, p); !ok || fields < 0 {
+				return 0, false
+			}
+			p = lp.Next(p)
+			// the field and value pairs
+			for j := int64(0); j < fields*2; j++ {
+				if p == -1 {
+					return 0, false
+				}
+				p = lp.Next(p)
+			}
+			extraFields += fields + 1
+		} else {
+			// the values
+			for j := int64(0); j < fields; j++ {
+				if p == -1 {
+					return 0, false
+				}
+				p = lp.Next(p)
+			}
+		}
+
+		// lp-count
+		if lpCount, ok := lpGetIntegerIfValid(lp, p); !ok || lpCount != fields+extraFields {
+			return 0, false
+		}
+		p = lp.Next(p)
+	}
+	// The deleted entries are marked, not counted.
+	if p != -1 || valid != count {
+		return 0, false
+	}
+	return count, true
+}
+
+// LoadMeta Set the last ID and the number of entries added of a stream
+// being loaded.
+func (s *Stream) LoadMeta(lastID ID, entriesAdded uint64) {
+	s.lastID = lastID
+	s.entriesAdded = entriesAdded
+}
+
+// GroupStates Return the consumer groups ordered by name, with their
+// pending entries and consumers.
+func (s *Stream) GroupStates() []GroupState {
+	var groups []GroupState
+	it := s.cgroups.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		g := it.Value().(*group)
+		gs := GroupState{
+			Name:   string(it.Key()),
+			LastID: g.lastID,
+		}
+
+		pit := g.pel.Iterator()
+		pit.Seek("^", nil)
+		for pit.Next() {
+			n := pit.Value().(*nack)
+			gs.Pending = append(gs.Pending, PendingState{
+				ID:            decodeID(pit.Key()),
+				DeliveryTime:  n.deliveryTime,
+				DeliveryCount: n.deliveryCount,
+			})
+		}
+
+		cit := g.consumers.Iterator()
+		cit.Seek("^", nil)
+		for cit.Next() {
+			c := cit.Value().(*consumer)
+			cs := ConsumerState{
+				Name:       c.name,
+				SeenTime:   c.seenTime,
+				ActiveTime: c.activeTime,
+			}
+			cpit := c.pel.Iterator()
+			cpit.Seek("^", nil)
+			for cpit.Next() {
+				cs.Pending = append(cs.Pending, decodeID(cpit.Key()))
+			}
+			gs.Consumers = append(gs.Consumers, cs)
+		}
+		groups = append(groups, gs)
+	}
+	return groups
+}
+
+// LoadGroup Add the consumer group of a stream being loaded. Every
+// pending entry of the group must be owned by exactly one consumer,
+// ErrCorrupt is returned otherwise, ErrBusyGroup if the group exists.
+func (s *Stream) LoadGroup(gs *GroupState) error {
+	g := &group{
+		lastID:    gs.LastID,
+		pel:       rax.Create(),
+		consumers: rax.Create(),
+	}
+	for _, p := range gs.Pending {
+		n := &nack{deliveryTime: p.DeliveryTime, deliveryCount: p.DeliveryCount}
+		if _, inserted := g.pel.TryInsert(p.ID.encode(), n); !inserted {
+			// Duplicated global PEL entry loading stream consumer group
+			return ErrCorrupt
+		}
+	}
+
+	for _, cs := range gs.Consumers {
+		c := &consumer{
+			name:       cs.Name,
+			seenTime:   cs.SeenTime,
+			activeTime: cs.ActiveTime,
+			pel:        rax.Create(),
+		}
+		if _, inserted := g.consumers.TryInsert([]byte(cs.Name), c); !inserted {
+			// Duplicate stream consumer detected.
+			return ErrCorrupt
+		}
+		// Load the PEL about entries owned by this specific consumer.
+		for _, id := range cs.Pending {
+			key := id.encode()
+			v, ok := g.pel.Find(key)
+			if !ok || v.(*nack).consumer != nil {
+				// Consumer entry not found in group global PEL, or
+				// already owned by a consumer.
+				return ErrCorrupt
+			}
+			n := v.(*nack)
+			n.consumer = c
+			c.pel.Insert(key, n)
+		}
+	}
+
+	// Verify that each PEL eventually got a consumer assigned to it.
+	it := g.pel.Iterator()
+	it.Seek("^", nil)
+	for it.Next() {
+		if it.Value().(*nack).consumer == nil {
+			return ErrCorrupt
+		}
+	}
+
+	if _, inserted := s.cgroups.TryInsert([]byte(gs.Name), g); !inserted {
+		return ErrBusyGroup
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+
+	"listpack"
+)
+
+func TestPersist(t *testing.T) {
+	clock := newClock()
+	s := Create(WithNodeMaxEntries(10), WithClock(clock.now))
+	entries := fill(t, s, 55)
+	// Trimming marks the entries of the first node as deleted.
+	s.Trim(&TrimArgs{Strategy: TrimMaxLen, MaxLen: 50})
+	s.CreateGroup("g1", ID{})
+	s.CreateGroup("g2", ID{3, 0})
+	s.ReadGroup("g1", "alice", nil, 8, false)
+	s.ReadGroup("g1", "bob", nil, 2, false)
+	s.CreateConsumer("g2", "carol")
+
+	// Copy the stream through its persisted state.
+	c := Create(WithNodeMaxEntries(10), WithClock(clock.now))
+	s.Nodes(func(master ID, lp *listpack.Listpack) {
+		blob := append([]byte{}, lp.Bytes()...)
+		clp, err := listpack.Load(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.LoadNode(master, clp); err != nil {
+			t.Fatal(err)
+		}
+	})
+	c.LoadMeta(s.LastID(), s.EntriesAdded())
+	groups := s.GroupStates()
+	for i := range groups {
+		if err := c.LoadGroup(&groups[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.Len() != 50 || c.LastID() != (ID{55, 0}) || c.EntriesAdded() != 55 {
+		t.Fatalf("len %d last %v added %d", c.Len(), c.LastID(), c.EntriesAdded())
+	}
+	checkEntries(t, c.Range(ID{}, MaxID, 0, false), entries[5:])
+	if !reflect.DeepEqual(c.GroupStates(), groups) {
+		t.Fatalf("groups %+v, want %+v", c.GroupStates(), groups)
+	}
+	if len(groups) != 2 || len(groups[0].Pending) != 10 || len(groups[0].Consumers[0].Pending) != 8 ||
+		groups[1].LastID != (ID{3, 0}) || groups[1].Consumers[0].Name != "carol" {
+		t.Fatalf("groups %+v", groups)
+	}
+
+	// The copy works as the original.
+	id, err := c.XAdd([]byte("*"), fields("a", "b"), nil)
+	if err != nil || id != (ID{1000000, 0}) {
+		t.Fatalf("xadd %v %v", id, err)
+	}
+	if err := c.LoadGroup(&groups[0]); err != ErrBusyGroup {
+		t.Fatal(err)
+	}
+
+	// The nodes must be loaded in order.
+	lp := listpack.Create()
+	lp.AppendInteger(1)
+	lp.AppendInteger(0)
+	lp.AppendInteger(1)
+	lp.Append([]byte("f"))
+	lp.AppendInteger(0)
+	lp.AppendInteger(itemFlagSameFields)
+	lp.AppendInteger(0)
+	lp.AppendInteger(0)
+	lp.Append([]byte("v"))
+	lp.AppendInteger(4)
+	if err := c.LoadNode(ID{1, 0}, lp); err != ErrCorrupt {
+		t.Fatal(err)
+	}
+	if err := Create().LoadNode(ID{1, 0}, lp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistCorrupt(t *testing.T) {
+	// A valid node holds one entry with the fields of the master entry,
+	// and one with its own fields.
+	node := []interface{}{
+		2, 0, 1, "f", 0,
+		itemFlagSameFields, 0, 0, "v", 4,
+		itemFlagNone, 0, 1, 1, "g", "w", 6,
+	}
+	build := func(elems []interface{}) *listpack.Listpack {
+		lp := listpack.Create()
+		for _, e := range elems {
+			switch e := e.(type) {
+			case int:
+				lp.AppendInteger(int64(e))
+			case string:
+				lp.Append([]byte(e))
+			}
+		}
+		return lp
+	}
+	if err := Create().LoadNode(ID{1, 0}, build(node)); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := func(i int, v interface{}) []interface{} {
+		c := append([]interface{}{}, node...)
+		c[i] = v
+		return c
+	}
+	tests := [][]interface{}{
+		{},
+		node[:len(node)-1],
+		append(append([]interface{}{}, node...), 1),
+		corrupt(0, 3),
+		corrupt(1, -1),
+		corrupt(4, 1),
+		corrupt(9, 5),
+		corrupt(13, 2),
+		corrupt(16, 5),
+		corrupt(10, itemFlagDeleted),
+	}
+	for i, elems := range tests {
+		if err := Create().LoadNode(ID{1, 0}, build(elems)); err != ErrCorrupt {
+			t.Errorf("test %d: %v", i, err)
+		}
+	}
+
+	// The pending entries must be owned by exactly one consumer.
+	groups := []GroupState{
+		{Name: "g", Pending: []PendingState{{ID: ID{1, 0}}, {ID: ID{1, 0}}}},
+		{Name: "g", Pending: []PendingState{{ID: ID{1, 0}}}},
+		{Name: "g", Consumers: []ConsumerState{{Name: "c", Pending: []ID{{1, 0}}}}},
+		{Name: "g", Pending: []PendingState{{ID: ID{1, 0}}}, Consumers: []ConsumerState{
+			{Name: "c", Pending: []ID{{1, 0}}},
+			{Name: "d", Pending: []ID{{1, 0}}},
+		}},
+		{Name: "g", Consumers: []ConsumerState{{Name: "c"}, {Name: "c"}}},
+	}
+	for i := range groups {
+		if err := Create().LoadGroup(&groups[i]); err != ErrCorrupt {
+			t.Errorf("group %d: %v", i, err)
+		}
+	}
+}
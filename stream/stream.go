@@ -0,0 +1,572 @@
+package stream
+
+// Stream, a port of the redis stream data type: an append only log of
+// entries, every entry being a set of field-value pairs with an unique
+// and increasing ID.
+//
+// The entries are stored in listpacks, every listpack being a node of a
+// radix tree keyed by the ID of the first entry of the node, the master
+// entry, encoded as a 128 bit big endian number.
+//
+// Every listpack starts with the master entry, the fields of the first
+// entry are stored there so that the entries with the same fields (the
+// common case) only store the values:
+//
+// +-------+---------+------------+---------+--/--+---------+---------+-+
+// | count | deleted | num-fields | field_1 | field_2 | ... | field_N |0|
+// +-------+---------+------------+---------+--/--+---------+---------+-+
+//
+// 'count' is the number of valid entries, 'deleted' the number of entries
+// marked as deleted. Then every entry is stored as:
+//
+// +-----+--------+----------+-------+-------+-/-+-------+-------+--------+
+// |flags|ms-diff |seq-diff  |num-fields|field-1|value-1|...|value-N|lp-count|
+// +-----+--------+----------+-------+-------+-/-+-------+-------+--------+
+//
+// or, when the SAMEFIELDS flag is set, just:
+//
+// +-----+--------+-------+-/-+-------+--------+
+// |flags|entry-id|value-1|...|value-N|lp-count|
+// +-----+--------+-------+-/-+-------+--------+
+//
+// The ID of the entry is stored as the difference with the master entry
+// ID. 'lp-count' is the number of listpack elements of the entry, so that
+// the node can be iterated backward.
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"listpack"
+	"rax"
+)
+
+// Error
+var (
+	// ErrInvalidID the stream ID can't be parsed.
+	ErrInvalidID = errors.New("Invalid stream ID specified as stream command argument")
+	// ErrIDTooSmall the ID is not greater than the last ID.
+	ErrIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	// ErrIDZero the ID 0-0 can't be added.
+	ErrIDZero = errors.New("The ID specified in XADD must be greater than 0-0")
+	// ErrIDExhausted the last ID is the greatest possible ID.
+	ErrIDExhausted = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+	// ErrFields no fields or a field without value.
+	ErrFields = errors.New("wrong number of arguments for stream entry fields")
+)
+
+// Flags of the entries.
+const (
+	itemFlagNone       = 0      // No special flags.
+	itemFlagDeleted    = 1 << 0 // Entry is deleted. Skip it.
+	itemFlagSameFields = 1 << 1 // Same fields as master entry.
+)
+
+const (
+	// NodeMaxBytes Default max size of a node, similar to
+	// stream-node-max-bytes of redis.
+	NodeMaxBytes = 4096
+	// NodeMaxEntries Default max number of entries of a node, similar to
+	// stream-node-max-entries of redis.
+	NodeMaxEntries = 100
+)
+
+// Entry an entry of the stream.
+type Entry struct {
+	ID ID
+	// Fields the field-value pairs, nil for an entry that was deleted
+	// while pending in a consumer group.
+	Fields [][]byte
+}
+
+// Stream stream.
+type Stream struct {
+	// The radix tree holding the stream.
+	rax *rax.Rax
+	// Count of elements inside this stream.
+	length uint64
+	// Zero if there are yet no items.
+	lastID ID
+	// All time count of elements added.
+	entriesAdded uint64
+	// Consumer groups dictionary: name -> *group
+	cgroups *rax.Rax
+
+	nodeMaxBytes   int
+	nodeMaxEntries int
+	now            func() time.Time
+}
+
+// Option opt.
+type Option func(s *Stream)
+
+// WithNodeMaxBytes The max size in bytes of a node, 0 means no limit.
+func WithNodeMaxBytes(n int) Option {
+	return func(s *Stream) {
+		s.nodeMaxBytes = n
+	}
+}
+
+// WithNodeMaxEntries The max number of entries of a node, 0 means no
+// limit.
+func WithNodeMaxEntries(n int) Option {
+	return func(s *Stream) {
+		s.nodeMaxEntries = n
+	}
+}
+
+// WithClock The 'now' is used to generate the IDs and for the idle time
+// of the consumer groups, time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Stream) {
+		s.now = now
+	}
+}
+
+// Create a new stream.
+func Create(opts ...Option) *Stream {
+	s := &Stream{
+		rax:            rax.Create(),
+		cgroups:        rax.Create(),
+		nodeMaxBytes:   NodeMaxBytes,
+		nodeMaxEntries: NodeMaxEntries,
+		now:            time.Now,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Len Return the number of entries.
+func (s *Stream) Len() uint64 {
+	return s.length
+}
+
+// LastID Return the ID of the last added entry, 0-0 if no entry was ever
+// added. The last entry may have been removed.
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// EntriesAdded Return the number of entries added since the creation.
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+func (s *Stream) mstime() uint64 {
+	return uint64(s.now().UnixNano() / int64(time.Millisecond))
+}
+
+// nextID Generate the next stream item ID given the previous one. If the
+// current milliseconds Unix time is greater than the previous one, just
+// use this as time part and start with sequence part of zero. Otherwise
+// we use the previous time (and never go backward) and increment the
+// sequence.
+func (s *Stream) nextID(last ID) (ID, bool) {
+	if ms := s.mstime(); ms > last.Ms {
+		return ID{Ms: ms}, true
+	}
+	return last.Incr()
+}
+
+// lpGetInteger Return the integer at 'p', the integers of the stream
+// nodes are always stored with an integer encoding.
+func lpGetInteger(lp *listpack.Listpack, p int) int64 {
+	_, lval, _ := lp.Get(p)
+	return lval
+}
+
+// lpGetBytes Return a copy of the element at 'p'.
+func lpGetBytes(lp *listpack.Listpack, p int) []byte {
+	sval, lval, _ := lp.Get(p)
+	if sval != nil {
+		return append([]byte{}, sval...)
+	}
+	return strconv.AppendInt(nil, lval, 10)
+}
+
+// Append Add a new item into the stream with the specified fields, the
+// field-value pairs. When 'id' is nil the ID is generated, otherwise
+// it is used as the ID of the new entry, it must be greater than the last
+// ID. When 'seqGiven' is false, only the milliseconds of 'id' are used
+// and the sequence is generated. Returns the ID of the new entry.
+func (s *Stream) Append(fields [][]byte, id *ID, seqGiven bool) (ID, error) {
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return ID{}, ErrFields
+	}
+	numfields := len(fields) / 2
+
+	// Generate the new entry ID.
+	var newID ID
+	if id != nil {
+		if seqGiven {
+			newID = *id
+		} else {
+			// The automatically generated sequence can be either zero
+			// (new timestamps) or the incremented sequence of the last
+			// ID. In the latter case, we need to prevent an overflow.
+			if id.Ms == s.lastID.Ms {
+				if s.lastID.Seq == math.MaxUint64 {
+					return ID{}, ErrIDTooSmall
+				}
+				newID = ID{Ms: id.Ms, Seq: s.lastID.Seq + 1}
+			} else {
+				newID = ID{Ms: id.Ms}
+			}
+		}
+		if newID.Compare(ID{}) == 0 {
+			return ID{}, ErrIDZero
+		}
+	} else {
+		var ok bool
+		if newID, ok = s.nextID(s.lastID); !ok {
+			return ID{}, ErrIDExhausted
+		}
+	}
+
+	// Check that the new ID is greater than the last entry ID or
+	// return an error. Automatically generated IDs might overflow (and
+	// wrap-around) when incrementing the sequence part.
+	if newID.Compare(s.lastID) <= 0 {
+		if s.lastID.Compare(MaxID) == 0 {
+			return ID{}, ErrIDExhausted
+		}
+		return ID{}, ErrIDTooSmall
+	}
+
+	// Add the new entry.
+	it := s.rax.Iterator()
+	it.Seek("$", nil)
+
+	var lp *listpack.Listpack
+	var masterID ID
+	lpBytes := 0
+	if it.Next() {
+		lp = it.Value().(*listpack.Listpack)
+		masterID = decodeID(it.Key())
+		lpBytes = lp.BlobLen()
+	}
+
+	// We have to add the key into the radix tree in lexicographic order,
+	// to do so we consider the ID as a single 128 bit number written in
+	// big endian, so that the most significant bytes are the first ones.
+
+	// Create a new listpack and radix tree node if needed. Note that when
+	// a new listpack is created, we populate it with a "master entry". This
+	// is just a set of fields that is taken as references in order to
+	// compress the stream entries that we'll add inside the listpack.
+	if lp != nil {
+		totelelen := 0
+		for _, f := range fields {
+			totelelen += len(f)
+		}
+		if s.nodeMaxBytes != 0 && lpBytes+totelelen >= s.nodeMaxBytes {
+			lp = nil
+		} else if s.nodeMaxEntries != 0 {
+			master := lp.First()
+			count := lpGetInteger(lp, master) + lpGetInteger(lp, lp.Next(master))
+			if count >= int64(s.nodeMaxEntries) {
+				lp = nil
+			}
+		}
+	}
+
+	flags := itemFlagNone
+	if lp == nil {
+		masterID = newID
+		lp = listpack.Create()
+		lp.AppendInteger(1) // One item, the one we are adding.
+		lp.AppendInteger(0) // Zero deleted so far.
+		lp.AppendInteger(int64(numfields))
+		for i := 0; i < numfields; i++ {
+			lp.Append(fields[i*2])
+		}
+		lp.AppendInteger(0) // Master entry zero terminator.
+		s.rax.Insert(newID.encode(), lp)
+		// The first entry we insert, has obviously the same fields of
+		// the master entry.
+		flags |= itemFlagSameFields
+	} else {
+		// Update count and skip the deleted fields.
+		p := lp.First()
+		count := lpGetInteger(lp, p)
+		p = lp.ReplaceInteger(p, count+1)
+		p = lp.Next(p) // Seek deleted.
+		p = lp.Next(p) // Seek master entry num fields.
+
+		// Check if the entry we are adding, have the same fields as the
+		// master entry.
+		masterFields := int(lpGetInteger(lp, p))
+		if numfields == masterFields {
+			p = lp.Next(p)
+			i := 0
+			for ; i < masterFields; i++ {
+				if !lp.Compare(p, fields[i*2]) {
+					break
+				}
+				p = lp.Next(p)
+			}
+			// All fields are the same! We can compress the field names
+			// setting a single bit in the flags.
+			if i == masterFields {
+				flags |= itemFlagSameFields
+			}
+		}
+	}
+
+	// Populate the listpack with the new entry. We use the following
+	// encoding:
+	//
+	// +-----+--------+----------+-------+-------+-/-+-------+-------+--------+
+	// |flags|entry-id|num-fields|field-1|value-1|...|field-N|value-N|lp-count|
+	// +-----+--------+----------+-------+-------+-/-+-------+-------+--------+
+	//
+	// However if the SAMEFIELD flag is set, we have just to populate
+	// the entry with the values, so it becomes:
+	//
+	// +-----+--------+-------+-/-+-------+--------+
+	// |flags|entry-id|value-1|...|value-N|lp-count|
+	// +-----+--------+-------+-/-+-------+--------+
+	//
+	// The entry-id field is actually two separated fields: the ms
+	// and seq difference compared to the master entry.
+	//
+	// The lp-count field is a number that states the number of listpack
+	// pieces that compose the entry, so that it's possible to travel the
+	// entry in reverse order: we can just start from the end of the
+	// listpack, read the entry, and jump back N times to seek the
+	// "flags" field to read the stream full entry.
+	lp.AppendInteger(int64(flags))
+	lp.AppendInteger(int64(newID.Ms - masterID.Ms))
+	lp.AppendInteger(int64(newID.Seq - masterID.Seq))
+	if flags&itemFlagSameFields == 0 {
+		lp.AppendInteger(int64(numfields))
+	}
+	for i := 0; i < numfields; i++ {
+		if flags&itemFlagSameFields == 0 {
+			lp.Append(fields[i*2])
+		}
+		lp.Append(fields[i*2+1])
+	}
+
+	// Compute and store the lp-count field.
+	lpCount := numfields
+	lpCount += 3 // Add the 3 fixed fields flags + ms-diff + seq-diff.
+	if flags&itemFlagSameFields == 0 {
+		// If the item is not compressed, it also has the fields other
+		// than the values, and an additional num-fields field.
+		lpCount += numfields + 1
+	}
+	lp.AppendInteger(int64(lpCount))
+
+	s.length++
+	s.entriesAdded++
+	s.lastID = newID
+	return newID, nil
+}
+
+// XAdd Implements the XADD command: add the entry with the ID given as
+// "*", "<ms>-*" or "<ms>-<seq>", and then trim the stream according to
+// 'trim' when not nil.
+func (s *Stream) XAdd(id []byte, fields [][]byte, trim *TrimArgs) (ID, error) {
+	var newID ID
+	var err error
+	if len(id) == 1 && id[0] == '*' {
+		newID, err = s.Append(fields, nil, true)
+	} else {
+		var given ID
+		var seqGiven bool
+		if given, seqGiven, err = parseID(id, false, 0); err != nil {
+			return ID{}, err
+		}
+		newID, err = s.Append(fields, &given, seqGiven)
+	}
+	if err != nil {
+		return ID{}, err
+	}
+
+	if trim != nil {
+		s.Trim(trim)
+	}
+	return newID, nil
+}
+
+// Trim strategies.
+const (
+	// TrimMaxLen evict the oldest entries beyond a maximum length.
+	TrimMaxLen = iota
+	// TrimMinID evict the entries with an ID lower than a minimum ID.
+	TrimMinID
+)
+
+// TrimArgs the arguments of Trim.
+type TrimArgs struct {
+	// Strategy TrimMaxLen or TrimMinID.
+	Strategy int
+	// MaxLen the max length with TrimMaxLen.
+	MaxLen int64
+	// MinID the min ID with TrimMinID.
+	MinID ID
+	// Approx only remove whole nodes, the stream may be larger than
+	// requested, but the trimming is much cheaper.
+	Approx bool
+	// Limit the max number of entries removed, only with Approx. When 0,
+	// the limit is 100 times the max entries of a node.
+	Limit int64
+}
+
+// Trim the stream according to 'args', like XTRIM. Returns the number of
+// removed entries.
+//
+// When not Approx, the entries are removed from the first node by
+// marking them as deleted, the node being removed once all its entries
+// are deleted.
+func (s *Stream) Trim(args *TrimArgs) int64 {
+	if s.length == 0 {
+		return 0
+	}
+
+	limit := args.Limit
+	if !args.Approx {
+		limit = 0
+	} else if limit == 0 {
+		limit = 100 * int64(s.nodeMaxEntries)
+	}
+
+	var deleted int64
+	it := s.rax.Iterator()
+	it.Seek("^", nil)
+
+	for it.Next() {
+		// Check if we exceeded the amount of work we could do.
+		if args.Strategy == TrimMaxLen && s.length <= uint64(args.MaxLen) {
+			break
+		}
+
+		lp := it.Value().(*listpack.Listpack)
+		master := lp.First()
+		entries := lpGetInteger(lp, master)
+
+		// Check if we can remove the whole node.
+		removeNode := false
+		if args.Strategy == TrimMaxLen {
+			removeNode = int64(s.length)-entries >= args.MaxLen
+		} else {
+			// Read the last ID of the node.
+			lastID := lastNodeID(lp, decodeID(it.Key()))
+			removeNode = lastID.Compare(args.MinID) < 0
+		}
+
+		if removeNode {
+			if limit != 0 && deleted+entries > limit {
+				break
+			}
+			key := append([]byte(nil), it.Key()...)
+			s.rax.Remove(key)
+			it.Seek(">", key)
+			s.length -= uint64(entries)
+			deleted += entries
+			continue
+		}
+
+		// If we cannot remove a whole element, and approx is true,
+		// stop here.
+		if args.Approx {
+			break
+		}
+
+		// Now we have to trim entries from within 'lp'
+		deleted += s.trimNode(lp, decodeID(it.Key()), args)
+		break
+	}
+	return deleted
+}
+
+// trimNode Mark as deleted the entries of the node until the trimming
+// condition is satisfied. Returns the number of deleted entries.
+func (s *Stream) trimNode(lp *listpack.Listpack, masterID ID, args *TrimArgs) int64 {
+	var deletedFromLp int64
+
+	// Skip the master entry.
+	p := lp.First()
+	p = lp.Next(p) // Skip deleted field.
+	p = lp.Next(p) // Skip num-of-fields in the master entry.
+	masterFields := int(lpGetInteger(lp, p))
+	for i := 0; i < masterFields+2; i++ {
+		p = lp.Next(p) // Skip the master fields and the terminator.
+	}
+
+	for p != -1 {
+		// We keep a copy of p (which point to flags part) in order to
+		// update it after (and if) we actually remove the entry.
+		pcopy := p
+		flags := lpGetInteger(lp, p)
+		p = lp.Next(p) // Skip flags.
+
+		// Check if we can stop trimming.
+		if args.Strategy == TrimMaxLen {
+			if s.length <= uint64(args.MaxLen) {
+				break
+			}
+		} else {
+			currID := ID{
+				Ms:  masterID.Ms + uint64(lpGetInteger(lp, p)),
+				Seq: masterID.Seq + uint64(lpGetInteger(lp, lp.Next(p))),
+			}
+			if currID.Compare(args.MinID) >= 0 {
+				break
+			}
+		}
+
+		toSkip := 0
+		p = lp.Next(p) // Skip ID ms delta.
+		p = lp.Next(p) // Skip ID seq delta.
+		if flags&itemFlagSameFields != 0 {
+			toSkip = masterFields
+		} else {
+			toSkip = int(lpGetInteger(lp, p))
+			toSkip = 1 + toSkip*2
+		}
+		for ; toSkip > 0; toSkip-- {
+			p = lp.Next(p) // Skip the fields and values.
+		}
+		p = lp.Next(p) // Skip the final lp-count field.
+
+		// Mark the entry as deleted, the flags are always encoded in a
+		// single byte so the offsets don't change.
+		if flags&itemFlagDeleted == 0 {
+			lp.ReplaceInteger(pcopy, flags|itemFlagDeleted)
+			deletedFromLp++
+			s.length--
+		}
+	}
+
+	// Update the listpack with the new count of entries and deleted.
+	p = lp.First()
+	entries := lpGetInteger(lp, p)
+	p = lp.ReplaceInteger(p, entries-deletedFromLp)
+	p = lp.Next(p) // Seek deleted field.
+	markedDeleted := lpGetInteger(lp, p)
+	lp.ReplaceInteger(p, markedDeleted+deletedFromLp)
+	return deletedFromLp
+}
+
+// lastNodeID Return the ID of the last entry of the node, deleted or
+// not.
+func lastNodeID(lp *listpack.Listpack, masterID ID) ID {
+	// Seek the flags of the last entry with the lp-count.
+	p := lp.Last()
+	lpCount := int(lpGetInteger(lp, p))
+	for ; lpCount > 0; lpCount-- {
+		p = lp.Prev(p)
+	}
+	p = lp.Next(p) // Seek the ms delta after the flags.
+	return ID{
+		Ms:  masterID.Ms + uint64(lpGetInteger(lp, p)),
+		Seq: masterID.Seq + uint64(lpGetInteger(lp, lp.Next(p))),
+	}
+}
@@ -0,0 +1,336 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// fakeClock a clock that only moves when told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1000, 0)}
+}
+
+func fields(kv ...string) [][]byte {
+	f := make([][]byte, len(kv))
+	for i, s := range kv {
+		f[i] = []byte(s)
+	}
+	return f
+}
+
+func equalFields(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func ids(entries []Entry) []ID {
+	var r []ID
+	for _, e := range entries {
+		r = append(r, e.ID)
+	}
+	return r
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		in      string
+		missing uint64
+		id      ID
+		err     bool
+	}{
+		{"0-1", 0, ID{0, 1}, false},
+		{"1526919030474-55", 0, ID{1526919030474, 55}, false},
+		{"5", 0, ID{5, 0}, false},
+		{"5", MaxID.Seq, ID{5, MaxID.Seq}, false},
+		{"-", 0, ID{}, false},
+		{"+", 0, MaxID, false},
+		{"18446744073709551615-18446744073709551615", 0, MaxID, false},
+		{"18446744073709551616-0", 0, ID{}, true},
+		{"1-*", 0, ID{}, true},
+		{"-1", 0, ID{}, true},
+		{"1-+1", 0, ID{}, true},
+		{"a-1", 0, ID{}, true},
+		{"", 0, ID{}, true},
+	}
+	for _, test := range tests {
+		id, err := ParseID([]byte(test.in), test.missing)
+		if (err != nil) != test.err {
+			t.Fatalf("ParseID(%q) err %v", test.in, err)
+		}
+		if err == nil && id != test.id {
+			t.Fatalf("ParseID(%q) = %v, want %v", test.in, id, test.id)
+		}
+	}
+
+	if s := (ID{12, 3}).String(); s != "12-3" {
+		t.Fatalf("String %s", s)
+	}
+	if _, ok := MaxID.Incr(); ok {
+		t.Fatal("incr max")
+	}
+	if _, ok := (ID{}).Decr(); ok {
+		t.Fatal("decr zero")
+	}
+	if id, _ := (ID{1, MaxID.Seq}).Incr(); id != (ID{2, 0}) {
+		t.Fatalf("incr %v", id)
+	}
+	if id, _ := (ID{2, 0}).Decr(); id != (ID{1, MaxID.Seq}) {
+		t.Fatalf("decr %v", id)
+	}
+}
+
+func TestXAdd(t *testing.T) {
+	clock := newClock()
+	s := Create(WithClock(clock.now))
+
+	id, err := s.XAdd([]byte("*"), fields("a", "1"), nil)
+	if err != nil || id != (ID{1000000, 0}) {
+		t.Fatalf("XAdd %v %v", id, err)
+	}
+	// Same millisecond: the sequence is incremented.
+	if id, _ = s.XAdd([]byte("*"), fields("a", "2"), nil); id != (ID{1000000, 1}) {
+		t.Fatalf("XAdd %v", id)
+	}
+	// The clock going backward never generates smaller IDs.
+	clock.advance(-time.Second)
+	if id, _ = s.XAdd([]byte("*"), fields("a", "3"), nil); id != (ID{1000000, 2}) {
+		t.Fatalf("XAdd %v", id)
+	}
+	clock.advance(2 * time.Second)
+	if id, _ = s.XAdd([]byte("*"), fields("a", "4"), nil); id != (ID{1001000, 0}) {
+		t.Fatalf("XAdd %v", id)
+	}
+
+	if _, err = s.XAdd([]byte("1001000-0"), fields("a", "5"), nil); err != ErrIDTooSmall {
+		t.Fatalf("XAdd equal ID %v", err)
+	}
+	if id, _ = s.XAdd([]byte("1001000-*"), fields("a", "5"), nil); id != (ID{1001000, 1}) {
+		t.Fatalf("XAdd ms-* %v", id)
+	}
+	if id, _ = s.XAdd([]byte("2000000-*"), fields("a", "6"), nil); id != (ID{2000000, 0}) {
+		t.Fatalf("XAdd ms-* %v", id)
+	}
+	if id, _ = s.XAdd([]byte("2000000-7"), fields("a", "7"), nil); id != (ID{2000000, 7}) {
+		t.Fatalf("XAdd explicit %v", id)
+	}
+	if _, err = s.XAdd([]byte("1-*"), fields("a", "8"), nil); err != ErrIDTooSmall {
+		t.Fatalf("XAdd smaller ms %v", err)
+	}
+	if _, err = s.XAdd([]byte("x"), fields("a", "8"), nil); err != ErrInvalidID {
+		t.Fatalf("XAdd invalid %v", err)
+	}
+	if _, err = s.XAdd([]byte("*"), fields("a"), nil); err != ErrFields {
+		t.Fatalf("XAdd odd fields %v", err)
+	}
+	if s.Len() != 7 || s.EntriesAdded() != 7 || s.LastID() != (ID{2000000, 7}) {
+		t.Fatalf("len %d added %d last %v", s.Len(), s.EntriesAdded(), s.LastID())
+	}
+
+	empty := Create()
+	if _, err = empty.XAdd([]byte("0-0"), fields("a", "1"), nil); err != ErrIDZero {
+		t.Fatalf("XAdd 0-0 %v", err)
+	}
+	if _, err = empty.XAdd([]byte(MaxID.String()), fields("a", "1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = empty.XAdd([]byte("*"), fields("a", "1"), nil); err != ErrIDExhausted {
+		t.Fatalf("XAdd exhausted %v", err)
+	}
+}
+
+// fill Add n entries with IDs 1-0 ... n-0, the fields of every third
+// entry differ from the master entry.
+func fill(t *testing.T, s *Stream, n int) []Entry {
+	t.Helper()
+	var entries []Entry
+	for i := 1; i <= n; i++ {
+		f := fields("name", fmt.Sprint("v", i), "n", fmt.Sprint(i))
+		if i%3 == 0 {
+			f = fields("other", fmt.Sprint(i))
+		}
+		id, err := s.XAdd([]byte(fmt.Sprint(i)+"-0"), f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, Entry{ID: id, Fields: f})
+	}
+	return entries
+}
+
+func checkEntries(t *testing.T, got, want []Entry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %v", len(got), len(want), ids(got))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID || !equalFields(got[i].Fields, want[i].Fields) {
+			t.Fatalf("entry %d: got %v %q, want %v %q", i, got[i].ID, got[i].Fields, want[i].ID, want[i].Fields)
+		}
+	}
+}
+
+func reverse(entries []Entry) []Entry {
+	r := make([]Entry, len(entries))
+	for i, e := range entries {
+		r[len(entries)-1-i] = e
+	}
+	return r
+}
+
+func TestRange(t *testing.T) {
+	s := Create(WithNodeMaxEntries(7))
+	entries := fill(t, s, 100)
+
+	checkEntries(t, s.Range(ID{}, MaxID, 0, false), entries)
+	checkEntries(t, s.Range(ID{}, MaxID, 0, true), reverse(entries))
+	checkEntries(t, s.Range(ID{10, 0}, ID{20, 0}, 0, false), entries[9:20])
+	checkEntries(t, s.Range(ID{10, 0}, ID{20, 0}, 0, true), reverse(entries[9:20]))
+	checkEntries(t, s.Range(ID{10, 1}, ID{20, 0}, 3, false), entries[10:13])
+	checkEntries(t, s.Range(ID{10, 1}, ID{20, 0}, 3, true), reverse(entries[17:20]))
+	checkEntries(t, s.Range(ID{101, 0}, MaxID, 0, false), nil)
+	checkEntries(t, s.Range(ID{}, ID{0, 5}, 0, true), nil)
+
+	for i := 0; i < 200; i++ {
+		a, b := rand.Intn(110), rand.Intn(110)
+		if a > b {
+			a, b = b, a
+		}
+		lo, hi := a, b
+		if lo < 1 {
+			lo = 1
+		}
+		if hi > 100 {
+			hi = 100
+		}
+		var want []Entry
+		if lo <= hi {
+			want = entries[lo-1 : hi]
+		}
+		checkEntries(t, s.Range(ID{uint64(a), 0}, ID{uint64(b), 0}, 0, false), want)
+		checkEntries(t, s.Range(ID{uint64(a), 0}, ID{uint64(b), 0}, 0, true), reverse(want))
+	}
+
+	if id, ok := s.FirstID(); !ok || id != (ID{1, 0}) {
+		t.Fatalf("FirstID %v %v", id, ok)
+	}
+	if _, ok := Create().FirstID(); ok {
+		t.Fatal("FirstID of empty stream")
+	}
+}
+
+func TestXRange(t *testing.T) {
+	s := Create()
+	entries := fill(t, s, 10)
+
+	got, err := s.XRange([]byte("-"), []byte("+"), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, got, entries)
+
+	got, _ = s.XRange([]byte("(3"), []byte("(6-0"), 0, false)
+	checkEntries(t, got, entries[3:5])
+	got, _ = s.XRange([]byte("3"), []byte("6"), 0, false)
+	checkEntries(t, got, entries[2:6])
+	// XREVRANGE key end start.
+	got, _ = s.XRange([]byte("3"), []byte("6"), 2, true)
+	checkEntries(t, got, reverse(entries[4:6]))
+	got, _ = s.XRange([]byte("6"), []byte("3"), 0, false)
+	checkEntries(t, got, nil)
+
+	if _, err = s.XRange([]byte("(+"), []byte("+"), 0, false); err != ErrInvalidID {
+		t.Fatalf("XRange (+ %v", err)
+	}
+	if _, err = s.XRange([]byte("(18446744073709551615-18446744073709551615"), []byte("+"), 0, false); err != ErrInvalidStart {
+		t.Fatalf("XRange start %v", err)
+	}
+	if _, err = s.XRange([]byte("-"), []byte("(0-0"), 0, false); err != ErrInvalidEnd {
+		t.Fatalf("XRange end %v", err)
+	}
+	if _, err = s.XRange([]byte("x"), []byte("+"), 0, false); err != ErrInvalidID {
+		t.Fatalf("XRange invalid %v", err)
+	}
+
+	checkEntries(t, s.Read(ID{7, 0}, 0), entries[7:])
+	checkEntries(t, s.Read(ID{7, 0}, 1), entries[7:8])
+	checkEntries(t, s.Read(ID{10, 0}, 0), nil)
+	checkEntries(t, s.Read(MaxID, 0), nil)
+}
+
+func TestTrim(t *testing.T) {
+	s := Create(WithNodeMaxEntries(10))
+	entries := fill(t, s, 100)
+
+	// Approximated trimming only removes whole nodes.
+	if n := s.Trim(&TrimArgs{Strategy: TrimMaxLen, MaxLen: 55, Approx: true}); n != 40 {
+		t.Fatalf("approx trim removed %d", n)
+	}
+	checkEntries(t, s.Range(ID{}, MaxID, 0, false), entries[40:])
+
+	if n := s.Trim(&TrimArgs{Strategy: TrimMaxLen, MaxLen: 55}); n != 5 {
+		t.Fatalf("trim removed %d", n)
+	}
+	if s.Len() != 55 {
+		t.Fatalf("len %d", s.Len())
+	}
+	checkEntries(t, s.Range(ID{}, MaxID, 0, false), entries[45:])
+	checkEntries(t, s.Range(ID{}, MaxID, 0, true), reverse(entries[45:]))
+
+	if n := s.Trim(&TrimArgs{Strategy: TrimMinID, MinID: ID{80, 0}}); n != 34 {
+		t.Fatalf("minid trim removed %d", n)
+	}
+	checkEntries(t, s.Range(ID{}, MaxID, 0, false), entries[79:])
+
+	// XADD with MAXLEN.
+	id, err := s.XAdd([]byte("*"), fields("name", "last"), &TrimArgs{Strategy: TrimMaxLen, MaxLen: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Range(ID{}, MaxID, 0, false)
+	if s.Len() != 1 || len(got) != 1 || got[0].ID != id {
+		t.Fatalf("len %d %v", s.Len(), ids(got))
+	}
+	if s.LastID() != id {
+		t.Fatalf("last id %v", s.LastID())
+	}
+}
+
+func TestNodeLimits(t *testing.T) {
+	s := Create(WithNodeMaxBytes(128), WithNodeMaxEntries(0))
+	var want []Entry
+	for i := 1; i <= 50; i++ {
+		f := fields("field", string(bytes.Repeat([]byte{'x'}, i)))
+		id, err := s.XAdd([]byte("*"), f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, Entry{ID: id, Fields: f})
+	}
+	if s.rax.Len() < 2 {
+		t.Fatalf("nodes %d", s.rax.Len())
+	}
+	checkEntries(t, s.Range(ID{}, MaxID, 0, false), want)
+	checkEntries(t, s.Range(ID{}, MaxID, 0, true), reverse(want))
+}
@@ -0,0 +1,40 @@
+package util
+
+// CRC64 implementation, a port of crc64.c of redis.
+//
+// Name                       : "CRC-64/Jones"
+// Width                      : 64 bit
+// Poly                       : ad93d23594c935a9
+// Initialization             : 0000000000000000
+// Reflect Input byte         : True
+// Reflect Output CRC         : True
+// Xor constant to output CRC : 0000000000000000
+// Output for "123456789"     : e9c6d914c4b8d9ca
+
+// crc64Poly The polynomial, reflected.
+const crc64Poly = 0x95ac9329ac4bc9b5
+
+var crc64tab [256]uint64
+
+func init() {
+	for i := range crc64tab {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ crc64Poly
+			} else {
+				crc >>= 1
+			}
+		}
+		crc64tab[i] = crc
+	}
+}
+
+// Crc64 Update the CRC64 'crc' with 'buf', the CRC64 of a whole buffer
+// being Crc64(0, buf).
+func Crc64(crc uint64, buf []byte) uint64 {
+	for _, b := range buf {
+		crc = crc64tab[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,3 @@
+module util
+
+go 1.14
@@ -0,0 +1,140 @@
+package util
+
+// stringMatchLenImpl Glob-style pattern matching, see StringMatchLen.
+// 'skipLongerMatches' is set when a '*' fails to match the rest of the
+// string: a longer match of the '*' can't succeed either, so the callers
+// can stop trying.
+func stringMatchLenImpl(pattern, s []byte, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	// Protection against abusive patterns.
+	if nesting > 1000 {
+		return false
+	}
+
+	for len(pattern) > 0 && len(s) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true // match
+			}
+			for len(s) > 0 {
+				if stringMatchLenImpl(pattern[1:], s, nocase, skipLongerMatches, nesting+1) {
+					return true // match
+				}
+				if *skipLongerMatches {
+					return false // no match
+				}
+				s = s[1:]
+			}
+			// There was no match for the rest of the pattern starting
+			// from anywhere in the rest of the string. If there were
+			// any '*' earlier in the pattern, we can terminate the
+			// search early without trying to match them to longer
+			// substrings. This is because a longer match for the
+			// earlier part of the pattern would require the rest of the
+			// pattern to match starting later in the string, and we
+			// have just determined that there is no match for the rest
+			// of the pattern starting from anywhere in the current
+			// string.
+			*skipLongerMatches = true
+			return false // no match
+		case '?':
+			s = s[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for {
+				if len(pattern) >= 2 && pattern[0] == '\\' {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+				} else if len(pattern) == 0 {
+					// Unterminated class, consider it terminated here.
+					break
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					c := s[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else {
+					if !nocase {
+						if pattern[0] == s[0] {
+							match = true
+						}
+					} else if toLower(pattern[0]) == toLower(s[0]) {
+						match = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false // no match
+			}
+			s = s[1:]
+			if len(pattern) == 0 {
+				// The class was not terminated, and the pattern is
+				// consumed.
+				return len(s) == 0
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if !nocase {
+				if pattern[0] != s[0] {
+					return false // no match
+				}
+			} else if toLower(pattern[0]) != toLower(s[0]) {
+				return false // no match
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+		if len(s) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+	return len(pattern) == 0 && len(s) == 0
+}
+
+// StringMatchLen Glob-style pattern matching, as used by KEYS and the
+// MATCH option of SCAN: '*' matches any sequence, '?' any character,
+// '[abc]' and '[a-z]' a set of characters, '[^abc]' the characters not in
+// the set, and '\' escapes the next character. With 'nocase' the match is
+// case insensitive.
+func StringMatchLen(pattern, s []byte, nocase bool) bool {
+	skipLongerMatches := false
+	return stringMatchLenImpl(pattern, s, nocase, &skipLongerMatches, 0)
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
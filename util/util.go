@@ -0,0 +1,69 @@
+package util
+
+// Helpers shared by the data structures, similar to util.c of redis.
+
+// String2ll Convert a string into a int64. Returns true if the string could
+// be parsed into a (non-overflowing) int64, false otherwise.
+// Only the canonical form is accepted: no spaces, no '+' sign, no leading
+// zeros and no "-0", so that converting the integer back to a string
+// produces exactly the same bytes.
+func String2ll(s []byte) (int64, bool) {
+	var (
+		v        uint64
+		negative bool
+		p        int
+	)
+
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+
+	// Special case: first and only digit is 0.
+	if len(s) == 1 && s[0] == '0' {
+		return 0, true
+	}
+
+	if s[0] == '-' {
+		negative = true
+		p++
+		if p == len(s) {
+			return 0, false
+		}
+	}
+
+	// First digit should be 1-9, otherwise the string should just be 0.
+	if s[p] >= '1' && s[p] <= '9' {
+		v = uint64(s[p] - '0')
+		p++
+	} else {
+		return 0, false
+	}
+
+	for ; p < len(s); p++ {
+		if s[p] < '0' || s[p] > '9' {
+			return 0, false
+		}
+		// Overflow.
+		if v > (^uint64(0))/10 {
+			return 0, false
+		}
+		v *= 10
+		if v > (^uint64(0))-uint64(s[p]-'0') {
+			return 0, false
+		}
+		v += uint64(s[p] - '0')
+	}
+
+	// Convert to negative if needed, and do the final overflow check when
+	// converting from unsigned long long to long long.
+	if negative {
+		if v > uint64(1)<<63 {
+			return 0, false
+		}
+		return -int64(v), true
+	}
+	if v > uint64(1)<<63-1 {
+		return 0, false
+	}
+	return int64(v), true
+}
@@ -0,0 +1,114 @@
+package util
+
+import "testing"
+
+func TestString2ll(t *testing.T) {
+	tests := []struct {
+		s  string
+		v  int64
+		ok bool
+	}{
+		{s: "0", v: 0, ok: true},
+		{s: "1", v: 1, ok: true},
+		{s: "-1", v: -1, ok: true},
+		{s: "9223372036854775807", v: 9223372036854775807, ok: true},
+		{s: "-9223372036854775808", v: -9223372036854775808, ok: true},
+		{s: "9223372036854775808", ok: false},
+		{s: "-9223372036854775809", ok: false},
+		{s: "", ok: false},
+		{s: "-", ok: false},
+		{s: "-0", ok: false},
+		{s: "01", ok: false},
+		{s: "+1", ok: false},
+		{s: " 1", ok: false},
+		{s: "1a", ok: false},
+	}
+
+	for _, test := range tests {
+		v, ok := String2ll([]byte(test.s))
+		if ok != test.ok || (ok && v != test.v) {
+			t.Fatalf("String2ll(%q) = %d, %v", test.s, v, ok)
+		}
+	}
+}
+
+func TestStringMatchLen(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		nocase     bool
+		match      bool
+	}{
+		{"*", "", false, false},
+		{"*", "foo", false, true},
+		{"", "", false, true},
+		{"", "a", false, false},
+		{"foo", "foo", false, true},
+		{"foo", "FOO", false, false},
+		{"foo", "FOO", true, true},
+		{"f?o", "fxo", false, true},
+		{"f?o", "fo", false, false},
+		{"f*", "foobar", false, true},
+		{"*bar", "foobar", false, true},
+		{"*o*a*", "foobar", false, true},
+		{"*x*", "foobar", false, false},
+		{"a**b", "axxb", false, true},
+		{"a*", "", false, false},
+		{"h[ae]llo", "hello", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"h[A-B]llo", "hbllo", true, true},
+		{"h[\\]]llo", "h]llo", false, true},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"h[ab", "ha", false, true},
+		{"h[ab", "hab", false, false},
+		{"a\\", "a\\", false, true},
+		{"*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false, false},
+	}
+
+	for _, test := range tests {
+		if StringMatchLen([]byte(test.pattern), []byte(test.s), test.nocase) != test.match {
+			t.Fatalf("StringMatchLen(%q, %q, %v) != %v", test.pattern, test.s, test.nocase, test.match)
+		}
+	}
+}
+
+func TestCrc16(t *testing.T) {
+	tests := []struct {
+		s   string
+		crc uint16
+	}{
+		{"", 0},
+		{"123456789", 0x31c3},
+		{"foo", 0xaf96},
+	}
+	for _, test := range tests {
+		if crc := Crc16([]byte(test.s)); crc != test.crc {
+			t.Errorf("Crc16(%q) = %#x, want %#x", test.s, crc, test.crc)
+		}
+	}
+}
+
+func TestCrc64(t *testing.T) {
+	tests := []struct {
+		s   string
+		crc uint64
+	}{
+		{"", 0},
+		{"123456789", 0xe9c6d914c4b8d9ca},
+	}
+	for _, test := range tests {
+		if crc := Crc64(0, []byte(test.s)); crc != test.crc {
+			t.Errorf("Crc64(%q) = %#x, want %#x", test.s, crc, test.crc)
+		}
+	}
+
+	// The CRC can be computed incrementally.
+	if crc := Crc64(Crc64(0, []byte("1234")), []byte("56789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("incremental Crc64 = %#x", crc)
+	}
+}
@@ -0,0 +1,13 @@
+module ziplist
+
+go 1.14
+
+require (
+	adlist v0.0.0
+	util v0.0.0
+)
+
+replace (
+	adlist => ../adlist
+	util => ../util
+)
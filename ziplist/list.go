@@ -0,0 +1,103 @@
+package ziplist
+
+import (
+	"errors"
+	"strconv"
+
+	"adlist"
+)
+
+// Conversion between a ziplist and an adlist.List. Small lists are kept
+// in a ziplist, once an entry or the number of entries crosses the
+// thresholds the list is converted to an adlist.List, and converted back
+// when it shrinks again.
+
+// Error
+var (
+	// ErrListValue the adlist.List holds a value that is not a *ListValue.
+	ErrListValue = errors.New("ziplist: list value is not a *ListValue")
+)
+
+// Default thresholds, similar to list-max-ziplist-entries and
+// list-max-ziplist-value of redis.
+const (
+	// MaxEntries max number of entries of a ziplist encoded list.
+	MaxEntries = 128
+	// MaxValue max size in bytes of a value of a ziplist encoded list.
+	MaxValue = 64
+)
+
+// ListValue the value of a ziplist entry stored in an adlist.List node.
+type ListValue struct {
+	// Str the string value, nil for integer entries.
+	Str []byte
+	// Int the integer value, if Str is nil.
+	Int int64
+}
+
+// Value implements adlist.Value.
+func (v *ListValue) Value() {}
+
+// Bytes Return the value as a string, integers are converted to their
+// decimal representation.
+func (v *ListValue) Bytes() []byte {
+	if v.Str != nil {
+		return v.Str
+	}
+	return strconv.AppendInt(nil, v.Int, 10)
+}
+
+// NeedConvert Check if pushing 's' makes the ziplist cross the thresholds,
+// in which case the list should be converted with ToList first.
+func (zl *Ziplist) NeedConvert(s []byte, maxEntries, maxValue int) bool {
+	return len(s) > maxValue || zl.Len()+1 > maxEntries || !zl.SafeToAdd(len(s))
+}
+
+// ToList Convert the ziplist to an adlist.List of *ListValue, from head to
+// tail. The string values are copied.
+func (zl *Ziplist) ToList(opts ...adlist.Option) *adlist.List {
+	list := adlist.ListCreate(opts...)
+
+	for p := zl.Index(0); p != -1; p = zl.Next(p) {
+		sval, lval, _ := zl.Get(p)
+		value := &ListValue{
+			Int: lval,
+		}
+		if sval != nil {
+			value.Str = append([]byte{}, sval...)
+		}
+		list.AddNodeTail(value)
+	}
+	return list
+}
+
+// FitsZiplist Check if the list is small enough to be converted back to a
+// ziplist. Every value of the list must be a *ListValue.
+func FitsZiplist(list *adlist.List, maxEntries, maxValue int) bool {
+	if list.Len() > int64(maxEntries) {
+		return false
+	}
+
+	for node := list.First(); node != nil; node = node.Next() {
+		value, ok := node.Value().(*ListValue)
+		if !ok || (value.Str != nil && len(value.Str) > maxValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// FromList Create a ziplist with the values of the list, from head to tail.
+// Every value of the list must be a *ListValue. The list is not modified.
+func FromList(list *adlist.List) (*Ziplist, error) {
+	zl := Create()
+
+	for node := list.First(); node != nil; node = node.Next() {
+		value, ok := node.Value().(*ListValue)
+		if !ok {
+			return nil, ErrListValue
+		}
+		zl.Push(value.Bytes(), Tail)
+	}
+	return zl, nil
+}
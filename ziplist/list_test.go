@@ -0,0 +1,41 @@
+package ziplist
+
+import (
+	"testing"
+)
+
+func TestZiplistList(t *testing.T) {
+	zl := Create()
+	for _, s := range []string{"a", "100", "b"} {
+		zl.Push([]byte(s), Tail)
+	}
+
+	if zl.NeedConvert([]byte("c"), MaxEntries, MaxValue) {
+		t.FailNow()
+	}
+	if !zl.NeedConvert(make([]byte, MaxValue+1), MaxEntries, MaxValue) {
+		t.FailNow()
+	}
+	if !zl.NeedConvert([]byte("c"), 3, MaxValue) {
+		t.FailNow()
+	}
+
+	list := zl.ToList()
+	if list.Len() != 3 {
+		t.FailNow()
+	}
+	if v := list.First().Next().Value().(*ListValue); v.Str != nil || v.Int != 100 {
+		t.FailNow()
+	}
+
+	list.AddNodeTail(&ListValue{Str: []byte("c")})
+	if !FitsZiplist(list, MaxEntries, MaxValue) || FitsZiplist(list, 3, MaxValue) {
+		t.FailNow()
+	}
+
+	zl, err := FromList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, zl, []string{"a", "100", "b", "c"})
+}
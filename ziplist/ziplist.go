@@ -0,0 +1,930 @@
+package ziplist
+
+// The ziplist is a specially encoded dually linked list that is designed
+// to be very memory efficient. It stores both strings and integer values,
+// where integers are encoded as actual integers instead of a series of
+// characters. It allows push and pop operations on either side of the list
+// in O(1) time. However, because every operation requires a reallocation of
+// the memory used by the ziplist, the actual complexity is related to the
+// amount of memory used by the ziplist.
+//
+// The general layout of the ziplist is as follows:
+//
+// <zlbytes> <zltail> <zllen> <entry> <entry> ... <entry> <zlend>
+//
+// All the header fields are stored in little endian.
+//
+// Every entry is prefixed by the length of the previous entry (prevlen),
+// so that the list can be traversed from back to front, followed by the
+// encoding of the entry and the entry data:
+//
+// <prevlen> <encoding> <entry-data>
+//
+// The prevlen is 1 byte when the previous entry is shorter than 254 bytes,
+// otherwise it is 0xFE followed by the length as a 4 bytes little endian
+// integer. Because of that, growing an entry may make the prevlen of the
+// next entry grow from 1 to 5 bytes, which may in turn grow the entry after
+// it: this is the cascade update.
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"util"
+)
+
+// Error
+var (
+	// ErrCorrupt the ziplist blob is malformed.
+	ErrCorrupt = errors.New("ziplist: corrupt blob")
+)
+
+// Where to push.
+const (
+	// Head push to the head of the list.
+	Head = 0
+	// Tail push to the tail of the list.
+	Tail = 1
+)
+
+const (
+	zipEnd        byte = 255 // Special "end of ziplist" entry.
+	zipBigPrevlen byte = 254 // ZIP_BIG_PREVLEN - 1 is the max number of bytes of
+	// the previous entry, for the "prevlen" field prefixing each entry, to be
+	// represented with just a single byte. Otherwise it is represented as
+	// FE AA BB CC DD, where AA BB CC DD are a 4 bytes unsigned integer
+	// representing the previous entry len.
+
+	// Different encoding/length possibilities
+	zipStrMask byte = 0xc0
+	zipStr06b  byte = 0 << 6
+	zipStr14b  byte = 1 << 6
+	zipStr32b  byte = 2 << 6
+	zipInt16b  byte = 0xc0 | 0<<4
+	zipInt32b  byte = 0xc0 | 1<<4
+	zipInt64b  byte = 0xc0 | 2<<4
+	zipInt24b  byte = 0xc0 | 3<<4
+	zipInt8b   byte = 0xfe
+
+	// 4 bit integer immediate encoding |1111xxxx| with xxxx between
+	// 0001 and 1101.
+	zipIntImmMask byte = 0x0f
+	zipIntImmMin  byte = 0xf1 // 11110001
+	zipIntImmMax  byte = 0xfd // 11111101
+
+	int24Max = 0x7fffff
+	int24Min = -int24Max - 1
+
+	// zlbytes + zltail + zllen
+	headerSize = 4 + 4 + 2
+	endSize    = 1
+
+	// Max value of the zllen field, when the list is longer the field is
+	// stuck at this value and the list must be traversed to get the length.
+	lenMax = math.MaxUint16
+
+	// safetySize max ziplist size, we don't want to create a ziplist larger
+	// than 1GB.
+	safetySize = 1 << 30
+)
+
+// Ziplist ziplist.
+type Ziplist struct {
+	buf []byte
+}
+
+// zlentry We use this structure to receive information about a ziplist
+// entry. Note that this is not how the data is actually encoded, is just
+// what we get filled by a function in order to operate more easily.
+type zlentry struct {
+	prevrawlensize int // Bytes used to encode the previous entry len
+	prevrawlen     int // Previous entry len.
+	lensize        int // Bytes used to encode this entry type/len.
+	len            int // Bytes used to represent the actual entry.
+	headersize     int // prevrawlensize + lensize.
+	encoding       byte
+	p              int // Offset of the very start of the entry.
+}
+
+// Create a new empty ziplist.
+func Create() *Ziplist {
+	size := headerSize + endSize
+	zl := &Ziplist{
+		buf: make([]byte, size),
+	}
+	zl.setBytes(size)
+	zl.setTailOffset(headerSize)
+	zl.setLength(0)
+	zl.buf[size-1] = zipEnd
+	return zl
+}
+
+// Load Create a ziplist from a blob previously returned by Bytes.
+// The blob is validated, ErrCorrupt is returned if it is malformed.
+func Load(blob []byte) (*Ziplist, error) {
+	zl := &Ziplist{
+		buf: blob,
+	}
+	if !zl.validate() {
+		return nil, ErrCorrupt
+	}
+	return zl, nil
+}
+
+// Bytes Return the serialized ziplist, the slice is owned by the ziplist.
+func (zl *Ziplist) Bytes() []byte {
+	return zl.buf
+}
+
+// BlobLen Return the size in bytes of the ziplist.
+func (zl *Ziplist) BlobLen() int {
+	return len(zl.buf)
+}
+
+// SafeToAdd Check if adding 'add' bytes to the ziplist keeps it under the
+// safety size.
+func (zl *Ziplist) SafeToAdd(add int) bool {
+	return len(zl.buf)+add <= safetySize
+}
+
+func (zl *Ziplist) setBytes(n int) {
+	binary.LittleEndian.PutUint32(zl.buf[0:], uint32(n))
+}
+
+func (zl *Ziplist) tailOffset() int {
+	return int(binary.LittleEndian.Uint32(zl.buf[4:]))
+}
+
+func (zl *Ziplist) setTailOffset(offset int) {
+	binary.LittleEndian.PutUint32(zl.buf[4:], uint32(offset))
+}
+
+func (zl *Ziplist) length() int {
+	return int(binary.LittleEndian.Uint16(zl.buf[8:]))
+}
+
+func (zl *Ziplist) setLength(n int) {
+	binary.LittleEndian.PutUint16(zl.buf[8:], uint16(n))
+}
+
+// incrLength Increment the number of items field in the ziplist header.
+// Note that this macro should never overflow the unsigned 16 bit integer,
+// since entries are always pushed one at a time. When UINT16_MAX is
+// reached we want the count to stay there to signal that a full scan is
+// needed to get the number of items inside the ziplist.
+func (zl *Ziplist) incrLength(incr int) {
+	if zl.length() < lenMax {
+		zl.setLength(zl.length() + incr)
+	}
+}
+
+func (zl *Ziplist) endOffset() int {
+	return len(zl.buf) - endSize
+}
+
+// isStr Check if the encoding is a string encoding.
+func isStr(encoding byte) bool {
+	return encoding&zipStrMask < zipStrMask
+}
+
+// intSize Return bytes needed to store integer encoded by 'encoding'.
+func intSize(encoding byte) int {
+	switch encoding {
+	case zipInt8b:
+		return 1
+	case zipInt16b:
+		return 2
+	case zipInt24b:
+		return 3
+	case zipInt32b:
+		return 4
+	case zipInt64b:
+		return 8
+	}
+	if encoding >= zipIntImmMin && encoding <= zipIntImmMax {
+		return 0 // 4 bit immediate
+	}
+	return 0
+}
+
+// encodingSize Return the number of bytes needed to encode the entry
+// type + length of the entry.
+func encodingSize(encoding byte, rawlen int) int {
+	if !isStr(encoding) {
+		return 1
+	}
+	if rawlen <= 0x3f {
+		return 1
+	} else if rawlen <= 0x3fff {
+		return 2
+	}
+	return 5
+}
+
+// storeEntryEncoding Write the encoding header of the entry in 'p'.
+// Returns the number of bytes written.
+func storeEntryEncoding(p []byte, encoding byte, rawlen int) int {
+	if !isStr(encoding) {
+		p[0] = encoding
+		return 1
+	}
+
+	if rawlen <= 0x3f {
+		p[0] = zipStr06b | byte(rawlen)
+		return 1
+	} else if rawlen <= 0x3fff {
+		p[0] = zipStr14b | byte(rawlen>>8&0x3f)
+		p[1] = byte(rawlen)
+		return 2
+	}
+	p[0] = zipStr32b
+	binary.BigEndian.PutUint32(p[1:], uint32(rawlen))
+	return 5
+}
+
+// decodeEntryEncoding Decode the entry encoding type and data length
+// (string length for strings, number of bytes used for the integer for
+// integer entries) encoded in 'p'.
+// ok is false if the encoding is invalid or truncated.
+func decodeEntryEncoding(p []byte) (encoding byte, lensize, l int, ok bool) {
+	if len(p) < 1 {
+		return 0, 0, 0, false
+	}
+
+	encoding = p[0]
+	if encoding < zipStrMask {
+		encoding &= zipStrMask
+	}
+
+	switch encoding {
+	case zipStr06b:
+		return encoding, 1, int(p[0] & 0x3f), true
+	case zipStr14b:
+		if len(p) < 2 {
+			return 0, 0, 0, false
+		}
+		return encoding, 2, int(p[0]&0x3f)<<8 | int(p[1]), true
+	case zipStr32b:
+		if len(p) < 5 {
+			return 0, 0, 0, false
+		}
+		return encoding, 5, int(binary.BigEndian.Uint32(p[1:])), true
+	case zipInt8b, zipInt16b, zipInt24b, zipInt32b, zipInt64b:
+		return encoding, 1, intSize(encoding), true
+	}
+	if encoding >= zipIntImmMin && encoding <= zipIntImmMax {
+		return encoding, 1, 0, true
+	}
+	return 0, 0, 0, false
+}
+
+// prevLenBytes Return the number of bytes used to encode the length of the
+// previous entry.
+func prevLenBytes(prevlen int) int {
+	if prevlen < int(zipBigPrevlen) {
+		return 1
+	}
+	return 5
+}
+
+// storePrevEntryLength Encode the length of the previous entry and write
+// it to "p". Returns the number of bytes written.
+func storePrevEntryLength(p []byte, prevlen int) int {
+	if prevlen < int(zipBigPrevlen) {
+		p[0] = byte(prevlen)
+		return 1
+	}
+	return storePrevEntryLengthLarge(p, prevlen)
+}
+
+// storePrevEntryLengthLarge Encode the length of the previous entry using
+// the 5 bytes form, even if it would fit in one byte.
+func storePrevEntryLengthLarge(p []byte, prevlen int) int {
+	p[0] = zipBigPrevlen
+	binary.LittleEndian.PutUint32(p[1:], uint32(prevlen))
+	return 5
+}
+
+// decodePrevlen Return the number of bytes used to encode the previous
+// entry length, and the length itself.
+func decodePrevlen(p []byte) (prevlensize, prevlen int, ok bool) {
+	if len(p) < 1 {
+		return 0, 0, false
+	}
+	if p[0] < zipBigPrevlen {
+		return 1, int(p[0]), true
+	}
+	if len(p) < 5 {
+		return 0, 0, false
+	}
+	return 5, int(binary.LittleEndian.Uint32(p[1:])), true
+}
+
+// tryEncoding Check if string 's' can be encoded as an integer.
+// Returns the integer value and its encoding.
+func tryEncoding(s []byte) (int64, byte, bool) {
+	if len(s) >= 32 || len(s) == 0 {
+		return 0, 0, false
+	}
+	value, ok := util.String2ll(s)
+	if !ok {
+		return 0, 0, false
+	}
+
+	// Great, the string can be encoded. Check what's the smallest
+	// of our encoding types that can hold this value.
+	var encoding byte
+	switch {
+	case value >= 0 && value <= 12:
+		encoding = zipIntImmMin + byte(value)
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		encoding = zipInt8b
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		encoding = zipInt16b
+	case value >= int24Min && value <= int24Max:
+		encoding = zipInt24b
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		encoding = zipInt32b
+	default:
+		encoding = zipInt64b
+	}
+	return value, encoding, true
+}
+
+// saveInteger Store integer 'value' at 'p', encoded as 'encoding'.
+func saveInteger(p []byte, value int64, encoding byte) {
+	switch encoding {
+	case zipInt8b:
+		p[0] = byte(int8(value))
+	case zipInt16b:
+		binary.LittleEndian.PutUint16(p, uint16(int16(value)))
+	case zipInt24b:
+		u := uint32(int32(value))
+		p[0], p[1], p[2] = byte(u), byte(u>>8), byte(u>>16)
+	case zipInt32b:
+		binary.LittleEndian.PutUint32(p, uint32(int32(value)))
+	case zipInt64b:
+		binary.LittleEndian.PutUint64(p, uint64(value))
+	default:
+		// Nothing to do, the value is stored in the encoding itself.
+	}
+}
+
+// loadInteger Read integer encoded as 'encoding' from 'p'.
+func loadInteger(p []byte, encoding byte) int64 {
+	switch encoding {
+	case zipInt8b:
+		return int64(int8(p[0]))
+	case zipInt16b:
+		return int64(int16(binary.LittleEndian.Uint16(p)))
+	case zipInt24b:
+		u := uint32(p[0])<<8 | uint32(p[1])<<16 | uint32(p[2])<<24
+		return int64(int32(u) >> 8)
+	case zipInt32b:
+		return int64(int32(binary.LittleEndian.Uint32(p)))
+	case zipInt64b:
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+	return int64(encoding&zipIntImmMask) - 1
+}
+
+// entry Return a structure with all information about an entry.
+// The entry must be valid.
+func (zl *Ziplist) entry(p int) zlentry {
+	e, _ := zl.entrySafe(p)
+	return e
+}
+
+// entrySafe Like entry, but checks that the entry header and data don't
+// reach outside the ziplist.
+func (zl *Ziplist) entrySafe(p int) (zlentry, bool) {
+	var e zlentry
+	var ok bool
+
+	e.p = p
+	end := zl.endOffset()
+	if p < headerSize || p >= end {
+		return e, false
+	}
+	if e.prevrawlensize, e.prevrawlen, ok = decodePrevlen(zl.buf[p:end]); !ok {
+		return e, false
+	}
+	if e.encoding, e.lensize, e.len, ok = decodeEntryEncoding(zl.buf[p+e.prevrawlensize : end]); !ok {
+		return e, false
+	}
+	e.headersize = e.prevrawlensize + e.lensize
+	if e.len < 0 || p+e.headersize+e.len > end {
+		return e, false
+	}
+	return e, true
+}
+
+// rawEntryLength Return the total number of bytes used by the entry
+// pointed to by 'p'.
+func (zl *Ziplist) rawEntryLength(p int) int {
+	e := zl.entry(p)
+	return e.headersize + e.len
+}
+
+// Len Return length of the ziplist.
+func (zl *Ziplist) Len() int {
+	if zl.length() < lenMax {
+		return zl.length()
+	}
+
+	n := 0
+	for p := headerSize; zl.buf[p] != zipEnd; p += zl.rawEntryLength(p) {
+		n++
+	}
+	// Re-store length if small enough.
+	if n < lenMax {
+		zl.setLength(n)
+	}
+	return n
+}
+
+// cascadeUpdate When an entry is inserted, we need to set the prevlen
+// field of the next entry to equal the length of the inserted entry. It
+// can occur that this length cannot be encoded in 1 byte and the next
+// entry needs to be grow a bit larger to hold the 5-byte encoded prevlen.
+// This can be done for free, because this only happens when an entry is
+// already being inserted (which causes a realloc and memmove). However,
+// encoding the prevlen may require that this entry is grown as well. This
+// effect may cascade throughout the ziplist when there are consecutive
+// entries with a size close to ZIP_BIG_PREVLEN, so we need to check that
+// the prevlen can be encoded in every consecutive entry.
+//
+// Note that this effect can also happen in reverse, where the bytes
+// required to encode the prevlen field can shrink. This effect is
+// deliberately ignored, because it can cause a "flapping" effect where a
+// chain prevlen fields is first grown and then shrunk again after
+// consecutive inserts. Rather, the field is allowed to stay larger than
+// necessary, because a large prevlen field implies the ziplist is holding
+// large entries anyway.
+//
+// The pointer "p" points to the first entry that does NOT need to be
+// updated, i.e. consecutive fields MAY need an update.
+func (zl *Ziplist) cascadeUpdate(p int) {
+	for zl.buf[p] != zipEnd {
+		cur := zl.entry(p)
+		rawlen := cur.headersize + cur.len
+		rawlensize := prevLenBytes(rawlen)
+
+		// Abort if there is no next entry.
+		np := p + rawlen
+		if zl.buf[np] == zipEnd {
+			break
+		}
+		next := zl.entry(np)
+
+		// Abort when "prevlen" has not changed.
+		if next.prevrawlen == rawlen {
+			break
+		}
+
+		if next.prevrawlensize < rawlensize {
+			// The "prevlen" field of "next" needs more bytes to hold
+			// the raw length of "cur".
+			extra := rawlensize - next.prevrawlensize
+
+			// Update tail offset when next element is not the tail element.
+			if zl.tailOffset() != np {
+				zl.setTailOffset(zl.tailOffset() + extra)
+			}
+
+			buf := make([]byte, len(zl.buf)+extra)
+			copy(buf, zl.buf[:np])
+			storePrevEntryLength(buf[np:], rawlen)
+			copy(buf[np+rawlensize:], zl.buf[np+next.prevrawlensize:])
+			zl.buf = buf
+			zl.setBytes(len(buf))
+
+			// Advance the cursor
+			p += rawlen
+		} else {
+			if next.prevrawlensize > rawlensize {
+				// This would result in shrinking, which we want to avoid.
+				// So, set "rawlen" in the available bytes.
+				storePrevEntryLengthLarge(zl.buf[np:], rawlen)
+			} else {
+				storePrevEntryLength(zl.buf[np:], rawlen)
+			}
+
+			// Stop here, as the raw length of "next" has not changed.
+			break
+		}
+	}
+}
+
+// delete Delete "num" entries, starting at "p". Returns the offset of the
+// entry following the deleted ones.
+func (zl *Ziplist) delete(p int, num int) int {
+	first := zl.entry(p)
+	deleted := 0
+	for i := 0; zl.buf[p] != zipEnd && i < num; i++ {
+		p += zl.rawEntryLength(p)
+		deleted++
+	}
+
+	totlen := p - first.p // Bytes taken by the element(s) to delete.
+	if totlen == 0 {
+		return first.p
+	}
+
+	nextdiff := 0
+	if zl.buf[p] != zipEnd {
+		// Storing `prevrawlen` in this entry may increase or decrease the
+		// number of bytes required compare to the current `prevrawlen`.
+		// There always is room to store this, because it was previously
+		// stored by an entry that is now being deleted.
+		next := zl.entry(p)
+		nextdiff = prevLenBytes(first.prevrawlen) - next.prevrawlensize
+
+		// Update offset for tail
+		tail := zl.tailOffset() - totlen
+
+		// When the tail contains more than one entry, we need to take
+		// "nextdiff" in account as well. Otherwise, a change in the
+		// size of prevlen doesn't have an effect on the *tail* offset.
+		if p+next.headersize+next.len != zl.endOffset() {
+			tail += nextdiff
+		}
+
+		buf := make([]byte, len(zl.buf)-totlen+nextdiff)
+		copy(buf, zl.buf[:first.p])
+		n := storePrevEntryLength(buf[first.p:], first.prevrawlen)
+		copy(buf[first.p+n:], zl.buf[p+next.prevrawlensize:])
+		zl.buf = buf
+		zl.setTailOffset(tail)
+	} else {
+		// The entire tail was deleted. No need to move memory.
+		buf := make([]byte, len(zl.buf)-totlen)
+		copy(buf, zl.buf[:first.p])
+		buf[len(buf)-1] = zipEnd
+		zl.buf = buf
+		zl.setTailOffset(first.p - first.prevrawlen)
+	}
+
+	zl.setBytes(len(zl.buf))
+	zl.incrLength(-deleted)
+
+	// When nextdiff != 0, the raw length of the next entry has changed,
+	// so we need to cascade the update throughout the ziplist.
+	if nextdiff != 0 {
+		zl.cascadeUpdate(first.p)
+	}
+	return first.p
+}
+
+// insert Insert item at "p".
+func (zl *Ziplist) insert(p int, s []byte) {
+	var (
+		prevlen    int
+		reqlen     int
+		nextdiff   int
+		forcelarge bool
+	)
+
+	// Find out prevlen for the entry that is inserted.
+	if zl.buf[p] != zipEnd {
+		_, prevlen, _ = decodePrevlen(zl.buf[p:])
+	} else if tail := zl.tailOffset(); zl.buf[tail] != zipEnd {
+		prevlen = zl.rawEntryLength(tail)
+	}
+
+	// See if the entry can be encoded
+	value, encoding, isInt := tryEncoding(s)
+	if isInt {
+		reqlen = intSize(encoding)
+	} else {
+		// 'encoding' is set to the appropriate string encoding by
+		// storeEntryEncoding.
+		encoding = zipStr06b
+		reqlen = len(s)
+	}
+
+	// We need space for both the length of the previous entry and
+	// the length of the payload.
+	reqlen += prevLenBytes(prevlen)
+	reqlen += encodingSize(encoding, len(s))
+
+	// When the insert position is not equal to the tail, we need to
+	// make sure that the next entry can hold this entry's length in
+	// its prevlen field.
+	var next zlentry
+	if zl.buf[p] != zipEnd {
+		next = zl.entry(p)
+		nextdiff = prevLenBytes(reqlen) - next.prevrawlensize
+		if nextdiff == -4 && reqlen < 4 {
+			nextdiff = 0
+			forcelarge = true
+		}
+	}
+
+	buf := make([]byte, len(zl.buf)+reqlen+nextdiff)
+	copy(buf, zl.buf[:p])
+
+	// Write the entry
+	q := p
+	q += storePrevEntryLength(buf[q:], prevlen)
+	q += storeEntryEncoding(buf[q:], encoding, len(s))
+	if isInt {
+		saveInteger(buf[q:], value, encoding)
+	} else {
+		copy(buf[q:], s)
+	}
+
+	if zl.buf[p] != zipEnd {
+		// Encode this entry's raw length in the next entry.
+		q = p + reqlen
+		if forcelarge {
+			q += storePrevEntryLengthLarge(buf[q:], reqlen)
+		} else {
+			q += storePrevEntryLength(buf[q:], reqlen)
+		}
+		copy(buf[q:], zl.buf[p+next.prevrawlensize:])
+
+		// Update offset for tail
+		tail := zl.tailOffset() + reqlen
+
+		// When the tail contains more than one entry, we need to take
+		// "nextdiff" in account as well. Otherwise, a change in the
+		// size of prevlen doesn't have an effect on the *tail* offset.
+		if p+next.headersize+next.len != zl.endOffset() {
+			tail += nextdiff
+		}
+		zl.buf = buf
+		zl.setTailOffset(tail)
+	} else {
+		// This element will be the new tail.
+		buf[len(buf)-1] = zipEnd
+		zl.buf = buf
+		zl.setTailOffset(p)
+	}
+
+	zl.setBytes(len(zl.buf))
+	zl.incrLength(1)
+
+	// When nextdiff != 0, the raw length of the next entry has changed, so
+	// we need to cascade the update throughout the ziplist
+	if nextdiff != 0 {
+		zl.cascadeUpdate(p + reqlen)
+	}
+}
+
+// Push Push the string to the head or to the tail of the ziplist.
+func (zl *Ziplist) Push(s []byte, where int) {
+	p := headerSize
+	if where == Tail {
+		p = zl.endOffset()
+	}
+	zl.insert(p, s)
+}
+
+// Index Returns an offset to use for iterating with Next. When the given
+// index is negative, the list is traversed back to front. When the list
+// doesn't contain an element at the provided index, -1 is returned.
+func (zl *Ziplist) Index(index int) int {
+	var p int
+
+	if index < 0 {
+		index = -index - 1
+		p = zl.tailOffset()
+		if zl.buf[p] != zipEnd {
+			_, prevlen, _ := decodePrevlen(zl.buf[p:])
+			for prevlen > 0 && index > 0 {
+				index--
+				p -= prevlen
+				_, prevlen, _ = decodePrevlen(zl.buf[p:])
+			}
+		}
+	} else {
+		p = headerSize
+		for zl.buf[p] != zipEnd && index > 0 {
+			index--
+			p += zl.rawEntryLength(p)
+		}
+	}
+
+	if zl.buf[p] == zipEnd || index > 0 {
+		return -1
+	}
+	return p
+}
+
+// Next Return offset to next entry in ziplist.
+// p is the offset of the current element.
+// The element after 'p' is returned, otherwise -1 if we are at the end.
+func (zl *Ziplist) Next(p int) int {
+	// "p" could be equal to ZIP_END, caused by Delete, and we should
+	// return -1. Otherwise, we should return -1 when the *next* element is
+	// ZIP_END (there is no next entry).
+	if p < 0 || zl.buf[p] == zipEnd {
+		return -1
+	}
+
+	p += zl.rawEntryLength(p)
+	if zl.buf[p] == zipEnd {
+		return -1
+	}
+	return p
+}
+
+// Prev Return offset to previous entry in ziplist, -1 if we are at the head.
+func (zl *Ziplist) Prev(p int) int {
+	// Iterating backwards from ZIP_END should return the tail. When "p" is
+	// equal to the first element of the list, we're already at the head,
+	// and should return -1.
+	if p < 0 {
+		return -1
+	}
+	if zl.buf[p] == zipEnd {
+		p = zl.tailOffset()
+		if zl.buf[p] == zipEnd {
+			return -1
+		}
+		return p
+	}
+	if p == headerSize {
+		return -1
+	}
+	_, prevlen, _ := decodePrevlen(zl.buf[p:])
+	return p - prevlen
+}
+
+// Get the value pointed to by 'p'. Depending on the encoding of the entry,
+// either sval (a non nil slice, also for the empty string) or lval is set.
+// The returned slice is owned by the ziplist. ok is false when 'p' points
+// to the end of the ziplist.
+func (zl *Ziplist) Get(p int) (sval []byte, lval int64, ok bool) {
+	if p < 0 || zl.buf[p] == zipEnd {
+		return nil, 0, false
+	}
+
+	e := zl.entry(p)
+	start := p + e.headersize
+	if isStr(e.encoding) {
+		return zl.buf[start : start+e.len : start+e.len], 0, true
+	}
+	return nil, loadInteger(zl.buf[start:], e.encoding), true
+}
+
+// Insert an entry before the entry at "p".
+func (zl *Ziplist) Insert(p int, s []byte) {
+	zl.insert(p, s)
+}
+
+// Delete a single entry from the ziplist, pointed to by 'p'.
+// Returns the offset of the entry now at 'p', so it is possible to
+// delete elements while iterating: use Index/Get on the result, it may
+// point to the end of the ziplist.
+func (zl *Ziplist) Delete(p int) int {
+	return zl.delete(p, 1)
+}
+
+// DeleteRange Delete a range of entries from the ziplist.
+func (zl *Ziplist) DeleteRange(index int, num int) {
+	if p := zl.Index(index); p != -1 {
+		zl.delete(p, num)
+	}
+}
+
+// Replace the entry at 'p' with 's'.
+func (zl *Ziplist) Replace(p int, s []byte) {
+	e := zl.entry(p)
+
+	// We need to know the new entry size to see if it fits in place.
+	value, encoding, isInt := tryEncoding(s)
+	var reqlen int
+	if isInt {
+		reqlen = intSize(encoding)
+	} else {
+		encoding = zipStr06b
+		reqlen = len(s)
+	}
+	reqlen += encodingSize(encoding, len(s))
+
+	if reqlen == e.lensize+e.len {
+		// Simply overwrite the element.
+		q := p + e.prevrawlensize
+		q += storeEntryEncoding(zl.buf[q:], encoding, len(s))
+		if isInt {
+			saveInteger(zl.buf[q:], value, encoding)
+		} else {
+			copy(zl.buf[q:], s)
+		}
+		return
+	}
+
+	// Fallback.
+	p = zl.Delete(p)
+	zl.Insert(p, s)
+}
+
+// Compare entry pointer to by 'p' with 's'.
+// Return true if equal.
+func (zl *Ziplist) Compare(p int, s []byte) bool {
+	if p < 0 || zl.buf[p] == zipEnd {
+		return false
+	}
+
+	e := zl.entry(p)
+	start := p + e.headersize
+	if isStr(e.encoding) {
+		// Raw compare
+		return string(zl.buf[start:start+e.len]) == string(s)
+	}
+
+	// Try to compare encoded values. Don't compare encoding because
+	// different implementations may encoded integers differently.
+	value, _, ok := tryEncoding(s)
+	return ok && loadInteger(zl.buf[start:], e.encoding) == value
+}
+
+// Find pointer to the entry equal to the specified entry. Skip 'skip'
+// entries between every comparison. Returns -1 when the field could not
+// be found.
+func (zl *Ziplist) Find(p int, s []byte, skip int) int {
+	var (
+		skipcnt  int
+		vencoded bool
+		vtried   bool
+		vll      int64
+	)
+
+	for p >= 0 && zl.buf[p] != zipEnd {
+		e := zl.entry(p)
+		q := p + e.headersize
+
+		if skipcnt == 0 {
+			// Compare current entry with specified entry
+			if isStr(e.encoding) {
+				if string(zl.buf[q:q+e.len]) == string(s) {
+					return p
+				}
+			} else {
+				// Find out if the searched field can be encoded. Note
+				// that we do it only the first time, once done
+				// vencoded is set to true.
+				if !vtried {
+					vll, _, vencoded = tryEncoding(s)
+					vtried = true
+				}
+
+				// Compare current entry with specified entry, do it
+				// only if vencoded is true, that is the value could be
+				// encoded as integer.
+				if vencoded && loadInteger(zl.buf[q:], e.encoding) == vll {
+					return p
+				}
+			}
+
+			// Reset skip count
+			skipcnt = skip
+		} else {
+			// Skip entry
+			skipcnt--
+		}
+
+		// Move to next entry
+		p = q + e.len
+	}
+
+	return -1
+}
+
+// validate Check that every entry is well formed, that the prevlen of
+// every entry matches the previous entry, and that the header is
+// consistent with the entries.
+func (zl *Ziplist) validate() bool {
+	if len(zl.buf) < headerSize+endSize {
+		return false
+	}
+	if int(binary.LittleEndian.Uint32(zl.buf)) != len(zl.buf) {
+		return false
+	}
+	if zl.buf[len(zl.buf)-1] != zipEnd {
+		return false
+	}
+
+	count, prevlen, tail := 0, 0, headerSize
+	for p := headerSize; zl.buf[p] != zipEnd; {
+		e, ok := zl.entrySafe(p)
+		if !ok || e.prevrawlen != prevlen {
+			return false
+		}
+		if !isStr(e.encoding) && e.len != intSize(e.encoding) {
+			return false
+		}
+		tail = p
+		prevlen = e.headersize + e.len
+		p += prevlen
+		count++
+	}
+
+	if zl.tailOffset() != tail {
+		return false
+	}
+	return zl.length() == lenMax || zl.length() == count
+}
@@ -0,0 +1,168 @@
+package ziplist
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// checkEntry Check that the entry at 'p' holds 's'.
+func checkEntry(t *testing.T, zl *Ziplist, p int, s string) {
+	t.Helper()
+
+	sval, lval, ok := zl.Get(p)
+	if !ok {
+		t.Fatalf("no entry at %d, want %q", p, s)
+	}
+	if sval != nil {
+		if string(sval) != s {
+			t.Fatalf("entry %q, want %q", sval, s)
+		}
+	} else if strconv.FormatInt(lval, 10) != s {
+		t.Fatalf("entry %d, want %q", lval, s)
+	}
+}
+
+// checkList Check the entries of the ziplist in both directions, and that
+// the blob is still valid.
+func checkList(t *testing.T, zl *Ziplist, want []string) {
+	t.Helper()
+
+	if zl.Len() != len(want) {
+		t.Fatalf("len %d, want %d", zl.Len(), len(want))
+	}
+	i := 0
+	for p := zl.Index(0); p != -1; p = zl.Next(p) {
+		checkEntry(t, zl, p, want[i])
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("iterated %d entries, want %d", i, len(want))
+	}
+	for p := zl.Index(-1); p != -1; p = zl.Prev(p) {
+		i--
+		checkEntry(t, zl, p, want[i])
+	}
+	if i != 0 {
+		t.Fatalf("iterated backwards to %d", i)
+	}
+	if _, err := Load(zl.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZiplistPush(t *testing.T) {
+	zl := Create()
+	checkList(t, zl, nil)
+
+	want := []string{"-9223372036854775808", "foo", "0", "12", "13", "-100",
+		"1024", "-70000", "8000000", "3000000000", "quux", "hello", "", "0x10"}
+	for _, s := range want[1:] {
+		zl.Push([]byte(s), Tail)
+	}
+	zl.Push([]byte(want[0]), Head)
+	checkList(t, zl, want)
+
+	if zl.Index(len(want)) != -1 || zl.Index(-len(want)-1) != -1 {
+		t.FailNow()
+	}
+	checkEntry(t, zl, zl.Index(-2), "")
+	checkEntry(t, zl, zl.Index(3), "12")
+
+	if p := zl.Find(zl.Index(0), []byte("3000000000"), 0); p != zl.Index(9) {
+		t.FailNow()
+	}
+	if p := zl.Find(zl.Index(0), []byte("hello"), 1); p != -1 {
+		t.FailNow()
+	}
+	if p := zl.Find(zl.Index(1), []byte("hello"), 1); p != zl.Index(11) {
+		t.FailNow()
+	}
+	if !zl.Compare(zl.Index(2), []byte("0")) || zl.Compare(zl.Index(1), []byte("bar")) {
+		t.FailNow()
+	}
+}
+
+func TestZiplistInsertDelete(t *testing.T) {
+	zl := Create()
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		zl.Push([]byte(s), Tail)
+	}
+
+	zl.Insert(zl.Index(2), []byte("x"))
+	checkList(t, zl, []string{"a", "b", "x", "c", "d", "e"})
+
+	// Delete while iterating.
+	for p := zl.Index(0); zl.buf[p] != zipEnd; {
+		if zl.Compare(p, []byte("x")) || zl.Compare(p, []byte("e")) {
+			p = zl.Delete(p)
+		} else {
+			p += zl.rawEntryLength(p)
+		}
+	}
+	checkList(t, zl, []string{"a", "b", "c", "d"})
+
+	zl.DeleteRange(1, 2)
+	checkList(t, zl, []string{"a", "d"})
+
+	zl.Replace(zl.Index(0), []byte("z"))
+	zl.Replace(zl.Index(1), []byte("a much longer value"))
+	checkList(t, zl, []string{"z", "a much longer value"})
+
+	zl.DeleteRange(-2, 10)
+	checkList(t, zl, nil)
+}
+
+func TestZiplistCascadeUpdate(t *testing.T) {
+	zl := Create()
+
+	// Entries of 253 bytes have a 1 byte prevlen, making them 254 bytes
+	// long would need 5 bytes in the prevlen of the next entry.
+	want := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		s := string(bytes.Repeat([]byte{byte('a' + i)}, 250))
+		want = append(want, s)
+		zl.Push([]byte(s), Tail)
+	}
+	checkList(t, zl, want)
+
+	// Inserting a big entry at the head grows every prevlen.
+	big := string(bytes.Repeat([]byte{'z'}, 300))
+	zl.Push([]byte(big), Head)
+	checkList(t, zl, append([]string{big}, want...))
+	for p := zl.Index(1); p != -1; p = zl.Next(p) {
+		if zl.entry(p).prevrawlensize != 5 {
+			t.Fatalf("prevlen of %d not cascaded", p)
+		}
+	}
+
+	// Deleting it must keep the list consistent.
+	zl.Delete(zl.Index(0))
+	checkList(t, zl, want)
+
+	// Inserting a small entry before a large prevlen doesn't shrink it.
+	zl.Insert(zl.Index(1), []byte("1"))
+	checkList(t, zl, append([]string{want[0], "1"}, want[1:]...))
+	if zl.entry(zl.Index(2)).prevrawlensize != 5 {
+		t.FailNow()
+	}
+}
+
+func TestZiplistLoad(t *testing.T) {
+	zl := Create()
+	zl.Push([]byte("hello"), Tail)
+	zl.Push([]byte("1234"), Tail)
+
+	blob := zl.Bytes()
+	for i := 0; i < len(blob); i++ {
+		if _, err := Load(blob[:i]); err == nil {
+			t.Fatalf("truncated blob of %d bytes loaded", i)
+		}
+	}
+
+	corrupt := append([]byte{}, blob...)
+	corrupt[len(corrupt)-4] = 0x0f
+	if _, err := Load(corrupt); err == nil {
+		t.FailNow()
+	}
+}
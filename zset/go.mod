@@ -0,0 +1,18 @@
+module zset
+
+go 1.14
+
+require (
+	dict v0.0.0
+	listpack v0.0.0
+	sds v0.0.0
+	skiplist v0.0.0
+)
+
+replace (
+	dict => ../dict
+	listpack => ../listpack
+	sds => ../sds
+	skiplist => ../skiplist
+	util => ../util
+)
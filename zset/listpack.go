@@ -0,0 +1,200 @@
+package zset
+
+// Listpack encoded zset, the member and score of every element are two
+// adjacent entries of the listpack.
+
+import (
+	"math"
+	"strconv"
+
+	"listpack"
+	"sds"
+	"skiplist"
+)
+
+// formatScore Convert the score to the string stored in the listpack, an
+// integer for integral scores, so that the listpack encodes it as an
+// integer.
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	case score == 0:
+		// See: http://en.wikipedia.org/wiki/Signed_zero, "Comparisons"
+		if math.Signbit(score) {
+			return []byte("-0")
+		}
+		return []byte("0")
+	case score == math.Trunc(score) && math.Abs(score) < 1<<53:
+		return strconv.AppendInt(nil, int64(score), 10)
+	}
+	return strconv.AppendFloat(nil, score, 'g', 17, 64)
+}
+
+// zzlString Return the string at 'p', converting integer entries. The
+// returned slice is a copy.
+func (zs *Zset) zzlString(p int) []byte {
+	sval, lval, _ := zs.lp.Get(p)
+	if sval != nil {
+		return append([]byte{}, sval...)
+	}
+	return strconv.AppendInt(nil, lval, 10)
+}
+
+// zzlScore Return the score at 'p'.
+func (zs *Zset) zzlScore(p int) float64 {
+	sval, lval, _ := zs.lp.Get(p)
+	if sval == nil {
+		return float64(lval)
+	}
+	score, _ := strconv.ParseFloat(string(sval), 64)
+	return score
+}
+
+// zzlGet Return the member and score of the element at 'eptr'.
+func (zs *Zset) zzlGet(eptr int) ([]byte, float64) {
+	return zs.zzlString(eptr), zs.zzlScore(zs.lp.Next(eptr))
+}
+
+// zzlNext Move to next entry based on the values in eptr. Returns -1
+// when there is no next entry.
+func (zs *Zset) zzlNext(eptr int) int {
+	return zs.lp.Next(zs.lp.Next(eptr))
+}
+
+// zzlPrev Move to the previous entry based on the values in eptr.
+// Returns -1 when there is no previous entry.
+func (zs *Zset) zzlPrev(eptr int) int {
+	sptr := zs.lp.Prev(eptr)
+	if sptr == -1 {
+		return -1
+	}
+	return zs.lp.Prev(sptr)
+}
+
+// zzlFind Find the member in the listpack, returning the offset of the
+// member and its score.
+func (zs *Zset) zzlFind(member []byte) (eptr int, score float64, ok bool) {
+	eptr = zs.lp.First()
+	if eptr == -1 {
+		return -1, 0, false
+	}
+	eptr = zs.lp.Find(eptr, member, 1)
+	if eptr == -1 {
+		return -1, 0, false
+	}
+	return eptr, zs.zzlScore(zs.lp.Next(eptr)), true
+}
+
+// zzlDelete Delete the element at 'eptr' with its score.
+func (zs *Zset) zzlDelete(eptr int) {
+	// Deleting the member moves the score at the same offset.
+	zs.lp.Delete(zs.lp.Delete(eptr))
+}
+
+// zzlInsertAt Insert the element before 'eptr', or at the tail when
+// 'eptr' is -1.
+func (zs *Zset) zzlInsertAt(eptr int, member []byte, score float64) {
+	if eptr == -1 {
+		zs.lp.Append(member)
+		zs.lp.Append(formatScore(score))
+		return
+	}
+
+	// Insert member before the element 'eptr'.
+	sptr := zs.lp.Insert(member, eptr, listpack.Before)
+	// Insert score after the member.
+	zs.lp.Insert(formatScore(score), sptr, listpack.After)
+}
+
+// zzlInsert Insert (element,score) pair in listpack. This function
+// assumes the element is not yet present in the list.
+func (zs *Zset) zzlInsert(member []byte, score float64) {
+	for eptr := zs.lp.First(); eptr != -1; eptr = zs.zzlNext(eptr) {
+		s := zs.zzlScore(zs.lp.Next(eptr))
+		if s > score {
+			// First element with score larger than score for element to
+			// be inserted. This means we should take its spot in the
+			// list to maintain ordering.
+			zs.zzlInsertAt(eptr, member, score)
+			return
+		} else if s == score {
+			// Ensure lexicographical ordering for elements.
+			if string(zs.zzlString(eptr)) > string(member) {
+				zs.zzlInsertAt(eptr, member, score)
+				return
+			}
+		}
+	}
+
+	// Push on tail of list when it was not yet inserted.
+	zs.zzlInsertAt(-1, member, score)
+}
+
+// zzlFirstInRange Find pointer to the first element contained in the
+// specified range. Returns -1 when no element is contained in the range.
+func (zs *Zset) zzlFirstInRange(r *skiplist.RangeSpec) int {
+	for eptr := zs.lp.First(); eptr != -1; eptr = zs.zzlNext(eptr) {
+		score := zs.zzlScore(zs.lp.Next(eptr))
+		if r.ValueGteMin(score) {
+			// Check if score <= max.
+			if r.ValueLteMax(score) {
+				return eptr
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// zzlLastInRange Find pointer to the last element contained in the
+// specified range. Returns -1 when no element is contained in the range.
+func (zs *Zset) zzlLastInRange(r *skiplist.RangeSpec) int {
+	for eptr := zs.lp.Seek(-2); eptr != -1; eptr = zs.zzlPrev(eptr) {
+		score := zs.zzlScore(zs.lp.Next(eptr))
+		if r.ValueLteMax(score) {
+			// Check if score >= min.
+			if r.ValueGteMin(score) {
+				return eptr
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// zzlFirstInLexRange Find pointer to the first element contained in the
+// specified lex range. Returns -1 when no element is contained in the
+// range.
+func (zs *Zset) zzlFirstInLexRange(r *skiplist.LexRangeSpec) int {
+	for eptr := zs.lp.First(); eptr != -1; eptr = zs.zzlNext(eptr) {
+		member := sds.New(zs.zzlString(eptr))
+		if r.ValueGteMin(member) {
+			// Check if member <= max.
+			if r.ValueLteMax(member) {
+				return eptr
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// zzlLastInLexRange Find pointer to the last element contained in the
+// specified lex range. Returns -1 when no element is contained in the
+// range.
+func (zs *Zset) zzlLastInLexRange(r *skiplist.LexRangeSpec) int {
+	for eptr := zs.lp.Seek(-2); eptr != -1; eptr = zs.zzlPrev(eptr) {
+		member := sds.New(zs.zzlString(eptr))
+		if r.ValueLteMax(member) {
+			// Check if member >= min.
+			if r.ValueGteMin(member) {
+				return eptr
+			}
+			return -1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,285 @@
+package zset
+
+import (
+	"sds"
+	"skiplist"
+)
+
+// RangeByRank Return the elements with rank between start and end, both
+// inclusive and 0-based, like ZRANGE. Negative ranks are counted from the
+// end, -1 being the last element. With 'reverse' the ranks are counted
+// from the highest score, like ZRANGE REV.
+func (zs *Zset) RangeByRank(start, end int64, reverse bool) []ScoreMember {
+	// Sanitize indexes.
+	llen := zs.Len()
+	if start < 0 {
+		start = llen + start
+	}
+	if end < 0 {
+		end = llen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	// Invariant: start >= 0, so this test will be true when end < 0.
+	// The range is empty when start > end or start >= length.
+	if start > end || start >= llen {
+		return nil
+	}
+	if end >= llen {
+		end = llen - 1
+	}
+	rangelen := end - start + 1
+	result := make([]ScoreMember, 0, rangelen)
+
+	if zs.encoding == EncodingListpack {
+		var eptr int
+		if reverse {
+			eptr = zs.lp.Seek(int(-2 - 2*start))
+		} else {
+			eptr = zs.lp.Seek(int(2 * start))
+		}
+
+		for ; rangelen > 0; rangelen-- {
+			member, score := zs.zzlGet(eptr)
+			result = append(result, ScoreMember{Score: score, Member: member})
+			if reverse {
+				eptr = zs.zzlPrev(eptr)
+			} else {
+				eptr = zs.zzlNext(eptr)
+			}
+		}
+		return result
+	}
+
+	// Check if starting point is trivial, before doing log(N) lookup.
+	var ln *skiplist.Node
+	if reverse {
+		ln = zs.zsl.Last()
+		if start > 0 {
+			ln = zs.zsl.ByRank(uint64(llen - start))
+		}
+	} else {
+		ln = zs.zsl.First()
+		if start > 0 {
+			ln = zs.zsl.ByRank(uint64(start + 1))
+		}
+	}
+
+	for ; rangelen > 0; rangelen-- {
+		result = append(result, nodeScoreMember(ln))
+		if reverse {
+			ln = ln.Prev()
+		} else {
+			ln = ln.Next()
+		}
+	}
+	return result
+}
+
+func nodeScoreMember(ln *skiplist.Node) ScoreMember {
+	return ScoreMember{
+		Score:  ln.Score(),
+		Member: append([]byte{}, ln.Ele().Bytes()...),
+	}
+}
+
+// RangeByScore Return the elements with score in the range, like
+// ZRANGE BYSCORE. With 'reverse' the elements are returned from the
+// highest score, like ZRANGE BYSCORE REV, note that the range is still
+// from r.Min to r.Max. The first 'offset' elements are skipped, and at
+// most 'limit' elements are returned, a negative limit meaning all.
+func (zs *Zset) RangeByScore(r *skiplist.RangeSpec, reverse bool, offset, limit int64) []ScoreMember {
+	var result []ScoreMember
+	if offset < 0 {
+		return nil
+	}
+
+	if zs.encoding == EncodingListpack {
+		// If reversed, get the last node in range as starting point.
+		var eptr int
+		if reverse {
+			eptr = zs.zzlLastInRange(r)
+		} else {
+			eptr = zs.zzlFirstInRange(r)
+		}
+
+		// If there is an offset, just element skip to that point.
+		for eptr != -1 && offset > 0 {
+			offset--
+			if reverse {
+				eptr = zs.zzlPrev(eptr)
+			} else {
+				eptr = zs.zzlNext(eptr)
+			}
+		}
+
+		for eptr != -1 && limit != 0 {
+			member, score := zs.zzlGet(eptr)
+
+			// Abort when the node is no longer in range.
+			if reverse {
+				if !r.ValueGteMin(score) {
+					break
+				}
+			} else {
+				if !r.ValueLteMax(score) {
+					break
+				}
+			}
+
+			result = append(result, ScoreMember{Score: score, Member: member})
+			limit--
+
+			// Move to next node
+			if reverse {
+				eptr = zs.zzlPrev(eptr)
+			} else {
+				eptr = zs.zzlNext(eptr)
+			}
+		}
+		return result
+	}
+
+	// If reversed, get the last node in range as starting point.
+	var ln *skiplist.Node
+	if reverse {
+		ln = zs.zsl.LastInRange(r)
+	} else {
+		ln = zs.zsl.FirstInRange(r)
+	}
+
+	// If there is an offset, just element skip to that point.
+	for ln != nil && offset > 0 {
+		offset--
+		if reverse {
+			ln = ln.Prev()
+		} else {
+			ln = ln.Next()
+		}
+	}
+
+	for ln != nil && limit != 0 {
+		// Abort when the node is no longer in range.
+		if reverse {
+			if !r.ValueGteMin(ln.Score()) {
+				break
+			}
+		} else {
+			if !r.ValueLteMax(ln.Score()) {
+				break
+			}
+		}
+
+		result = append(result, nodeScoreMember(ln))
+		limit--
+
+		// Move to next node
+		if reverse {
+			ln = ln.Prev()
+		} else {
+			ln = ln.Next()
+		}
+	}
+	return result
+}
+
+// RangeByLex Return the elements with member in the lex range, like
+// ZRANGE BYLEX, see RangeByScore for 'reverse', 'offset' and 'limit'.
+// The lex range is meaningful only when all the elements have the same
+// score.
+func (zs *Zset) RangeByLex(r *skiplist.LexRangeSpec, reverse bool, offset, limit int64) []ScoreMember {
+	var result []ScoreMember
+	if offset < 0 {
+		return nil
+	}
+
+	if zs.encoding == EncodingListpack {
+		// If reversed, get the last node in range as starting point.
+		var eptr int
+		if reverse {
+			eptr = zs.zzlLastInLexRange(r)
+		} else {
+			eptr = zs.zzlFirstInLexRange(r)
+		}
+
+		// If there is an offset, just element skip to that point.
+		for eptr != -1 && offset > 0 {
+			offset--
+			if reverse {
+				eptr = zs.zzlPrev(eptr)
+			} else {
+				eptr = zs.zzlNext(eptr)
+			}
+		}
+
+		for eptr != -1 && limit != 0 {
+			member, score := zs.zzlGet(eptr)
+
+			// Abort when the node is no longer in range.
+			if reverse {
+				if !r.ValueGteMin(sds.New(member)) {
+					break
+				}
+			} else {
+				if !r.ValueLteMax(sds.New(member)) {
+					break
+				}
+			}
+
+			result = append(result, ScoreMember{Score: score, Member: member})
+			limit--
+
+			// Move to next node
+			if reverse {
+				eptr = zs.zzlPrev(eptr)
+			} else {
+				eptr = zs.zzlNext(eptr)
+			}
+		}
+		return result
+	}
+
+	// If reversed, get the last node in range as starting point.
+	var ln *skiplist.Node
+	if reverse {
+		ln = zs.zsl.LastInLexRange(r)
+	} else {
+		ln = zs.zsl.FirstInLexRange(r)
+	}
+
+	// If there is an offset, just element skip to that point.
+	for ln != nil && offset > 0 {
+		offset--
+		if reverse {
+			ln = ln.Prev()
+		} else {
+			ln = ln.Next()
+		}
+	}
+
+	for ln != nil && limit != 0 {
+		// Abort when the node is no longer in range.
+		if reverse {
+			if !r.ValueGteMin(ln.Ele()) {
+				break
+			}
+		} else {
+			if !r.ValueLteMax(ln.Ele()) {
+				break
+			}
+		}
+
+		result = append(result, nodeScoreMember(ln))
+		limit--
+
+		// Move to next node
+		if reverse {
+			ln = ln.Prev()
+		} else {
+			ln = ln.Next()
+		}
+	}
+	return result
+}
@@ -0,0 +1,246 @@
+package zset
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"sds"
+)
+
+// Error
+var (
+	// ErrWeights the number of weights doesn't match the number of sets.
+	ErrWeights = errors.New("the number of weights doesn't match the number of sets")
+)
+
+// How the scores of the same member in different sets are combined.
+const (
+	// AggregateSum sum of the scores.
+	AggregateSum = iota
+	// AggregateMin minimum of the scores.
+	AggregateMin
+	// AggregateMax maximum of the scores.
+	AggregateMax
+)
+
+// each Call 'fn' with every element of the zset in ascending order. The
+// member passed to 'fn' must not be retained.
+func (zs *Zset) each(fn func(member []byte, score float64)) {
+	if zs.encoding == EncodingListpack {
+		for eptr := zs.lp.First(); eptr != -1; eptr = zs.zzlNext(eptr) {
+			member, score := zs.zzlGet(eptr)
+			fn(member, score)
+		}
+		return
+	}
+
+	for ln := zs.zsl.First(); ln != nil; ln = ln.Next() {
+		fn(ln.Ele().Bytes(), ln.Score())
+	}
+}
+
+// setLen Return the length of the set, nil sets are empty.
+func setLen(zs *Zset) int64 {
+	if zs == nil {
+		return 0
+	}
+	return zs.Len()
+}
+
+func aggregate(target *float64, val float64, agg int) {
+	switch agg {
+	case AggregateSum:
+		*target = *target + val
+		// The result of adding two doubles is NaN when one variable
+		// is +inf and the other is -inf. When these numbers are added,
+		// we maintain the convention of the result being 0.0.
+		if math.IsNaN(*target) {
+			*target = 0
+		}
+	case AggregateMin:
+		if val < *target {
+			*target = val
+		}
+	case AggregateMax:
+		if val > *target {
+			*target = val
+		}
+	}
+}
+
+// weighted Return the score multiplied by the weight, 0 for NaN results
+// such as 0 * inf.
+func weighted(score, weight float64) float64 {
+	score *= weight
+	if math.IsNaN(score) {
+		return 0
+	}
+	return score
+}
+
+// setopSources The sets with their weights, sorted from the smallest to
+// the largest set.
+func setopSources(sets []*Zset, weights []float64) ([]*Zset, []float64, error) {
+	if weights != nil && len(weights) != len(sets) {
+		return nil, nil, ErrWeights
+	}
+
+	// sort sets from the smallest to largest, this will improve our
+	// algorithm's performance
+	idx := make([]int, len(sets))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return setLen(sets[idx[i]]) < setLen(sets[idx[j]])
+	})
+	src := make([]*Zset, len(sets))
+	w := make([]float64, len(sets))
+	for i, j := range idx {
+		src[i] = sets[j]
+		w[i] = 1
+		if weights != nil {
+			w[i] = weights[j]
+		}
+	}
+	return src, w, nil
+}
+
+// setopResult Create the skiplist encoded zset the results are added to.
+func setopResult(opts []Option) *Zset {
+	dst := Create(opts...)
+	dst.Convert(EncodingSkiplist)
+	return dst
+}
+
+// Union Return the union of the sets, like ZUNION. The score of every
+// element is multiplied by the weight of its set, all 1 when 'weights' is
+// nil, and the scores of the same member are combined with 'agg'. Nil
+// sets are empty sets. The options apply to the returned zset.
+func Union(sets []*Zset, weights []float64, agg int, opts ...Option) (*Zset, error) {
+	src, w, err := setopSources(sets, weights)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := setopResult(opts)
+	var maxelelen, totelelen int
+	var members []*sds.SDS
+
+	// Accumulate the scores in the dict of the result, the skiplist is
+	// populated once all the scores are known.
+	for i, zs := range src {
+		if zs == nil {
+			continue
+		}
+		zs.each(func(member []byte, score float64) {
+			score = weighted(score, w[i])
+
+			key := (*sds.Key)(sds.New(member))
+			if de := dst.dict.Find(key); de != nil {
+				aggregate(&de.Value().(*scoreValue).score, score, agg)
+				return
+			}
+
+			dst.dict.Add(key, &scoreValue{score: score})
+			members = append(members, key.SDS())
+
+			// Remember the longest single element encountered,
+			// to understand if it's possible to convert to listpack
+			// at the end.
+			if len(member) > maxelelen {
+				maxelelen = len(member)
+			}
+			totelelen += len(member)
+		})
+	}
+
+	for _, ele := range members {
+		score := dst.dict.FetchValue((*sds.Key)(ele)).(*scoreValue).score
+		dst.zsl.Insert(score, ele)
+	}
+
+	dst.ConvertToListpackIfNeeded(maxelelen, totelelen)
+	return dst, nil
+}
+
+// Inter Return the intersection of the sets, like ZINTER, see Union for
+// the weights and 'agg'.
+func Inter(sets []*Zset, weights []float64, agg int, opts ...Option) (*Zset, error) {
+	src, w, err := setopSources(sets, weights)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := setopResult(opts)
+	var maxelelen, totelelen int
+
+	// Skip everything if the smallest input is empty.
+	if len(src) == 0 || setLen(src[0]) == 0 {
+		dst.ConvertToListpackIfNeeded(0, 0)
+		return dst, nil
+	}
+
+	// Precondition: as src[0] is non-empty and the inputs are ordered
+	// by size, all src[i > 0] are non-empty too.
+	src[0].each(func(member []byte, score float64) {
+		score = weighted(score, w[0])
+
+		for j := 1; j < len(src); j++ {
+			value, ok := src[j].Score(member)
+			if !ok {
+				return
+			}
+			aggregate(&score, weighted(value, w[j]), agg)
+		}
+
+		// Only continue when present in every input.
+		ele := sds.New(member)
+		dst.zsl.Insert(score, ele)
+		dst.dict.Add((*sds.Key)(ele), &scoreValue{score: score})
+
+		if len(member) > maxelelen {
+			maxelelen = len(member)
+		}
+		totelelen += len(member)
+	})
+
+	dst.ConvertToListpackIfNeeded(maxelelen, totelelen)
+	return dst, nil
+}
+
+// Diff Return the members of the first set that are not in the other
+// sets, with their scores, like ZDIFF.
+func Diff(sets []*Zset, opts ...Option) *Zset {
+	dst := setopResult(opts)
+	var maxelelen, totelelen int
+
+	if len(sets) == 0 || setLen(sets[0]) == 0 {
+		dst.ConvertToListpackIfNeeded(0, 0)
+		return dst
+	}
+
+	sets[0].each(func(member []byte, score float64) {
+		for j := 1; j < len(sets); j++ {
+			if sets[j] == nil {
+				continue
+			}
+			if _, ok := sets[j].Score(member); ok {
+				return
+			}
+		}
+
+		ele := sds.New(member)
+		dst.zsl.Insert(score, ele)
+		dst.dict.Add((*sds.Key)(ele), &scoreValue{score: score})
+
+		if len(member) > maxelelen {
+			maxelelen = len(member)
+		}
+		totelelen += len(member)
+	})
+
+	dst.ConvertToListpackIfNeeded(maxelelen, totelelen)
+	return dst
+}
@@ -0,0 +1,84 @@
+package zset
+
+import (
+	"math"
+	"testing"
+)
+
+func zsetOf(opts []Option, elements ...ScoreMember) *Zset {
+	zs := Create(opts...)
+	zs.ZAdd(0, elements...)
+	return zs
+}
+
+func TestSetOps(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithMaxListpackEntries(0)}} {
+		a := zsetOf(opts, ScoreMember{1, []byte("a")}, ScoreMember{2, []byte("b")}, ScoreMember{3, []byte("c")})
+		b := zsetOf(opts, ScoreMember{10, []byte("b")}, ScoreMember{20, []byte("c")}, ScoreMember{30, []byte("d")})
+
+		u, err := Union([]*Zset{a, b, nil}, nil, AggregateSum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := dump(u.RangeByRank(0, -1, false)); got != "a:1 b:12 c:23 d:30 " {
+			t.Fatalf("union %s", got)
+		}
+		if u.Encoding() != EncodingListpack {
+			t.Fatal("small union not converted to listpack")
+		}
+
+		u, _ = Union([]*Zset{a, b}, []float64{2, 0.5}, AggregateMax)
+		if got := dump(u.RangeByRank(0, -1, false)); got != "a:2 b:5 c:10 d:15 " {
+			t.Fatalf("union weights max %s", got)
+		}
+
+		i, _ := Inter([]*Zset{a, b}, nil, AggregateMin)
+		if got := dump(i.RangeByRank(0, -1, false)); got != "b:2 c:3 " {
+			t.Fatalf("inter min %s", got)
+		}
+		i, _ = Inter([]*Zset{a, b, nil}, nil, AggregateSum)
+		if i.Len() != 0 {
+			t.Fatal("inter with a missing set not empty")
+		}
+
+		d := Diff([]*Zset{a, nil, b})
+		if got := dump(d.RangeByRank(0, -1, false)); got != "a:1 " {
+			t.Fatalf("diff %s", got)
+		}
+		if Diff([]*Zset{nil, a}).Len() != 0 {
+			t.Fatal("diff of a missing set not empty")
+		}
+
+		if _, err := Union([]*Zset{a, b}, []float64{1}, AggregateSum); err != ErrWeights {
+			t.Fatalf("err %v", err)
+		}
+	}
+}
+
+func TestSetOpsInf(t *testing.T) {
+	a := zsetOf(nil, ScoreMember{math.Inf(1), []byte("a")})
+	b := zsetOf(nil, ScoreMember{math.Inf(-1), []byte("a")})
+
+	// inf + -inf is 0, not NaN.
+	u, _ := Union([]*Zset{a, b}, nil, AggregateSum)
+	if s, _ := u.Score([]byte("a")); s != 0 {
+		t.Fatalf("score %v", s)
+	}
+
+	// 0 * inf is 0, not NaN.
+	u, _ = Union([]*Zset{a}, []float64{0}, AggregateSum)
+	if s, _ := u.Score([]byte("a")); s != 0 {
+		t.Fatalf("score %v", s)
+	}
+}
+
+func TestSetOpsLarge(t *testing.T) {
+	a := Create()
+	for i := 0; i < 200; i++ {
+		a.Add(float64(i), []byte{byte(i)}, 0)
+	}
+	u, _ := Union([]*Zset{a}, nil, AggregateSum)
+	if u.Encoding() != EncodingSkiplist || u.Len() != 200 {
+		t.Fatalf("encoding %d len %d", u.Encoding(), u.Len())
+	}
+}
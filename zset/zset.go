@@ -0,0 +1,539 @@
+package zset
+
+// Sorted set, a port of the redis zset.
+//
+// The zset uses two encodings:
+//
+// Small sorted sets are stored in a listpack, every element being two
+// adjacent entries, the member followed by its score, sorted by score and
+// then lexicographically by member.
+//
+// Larger sorted sets are stored in a skiplist, ordered by score and
+// member, and in a dict.Dict mapping every member to its score, so that
+// the score of a member is found in O(1).
+//
+// A listpack encoded zset is converted to the skiplist encoding as soon
+// as it has more than maxListpackEntries elements, or a member longer than
+// maxListpackValue bytes.
+
+import (
+	"errors"
+	"math"
+
+	"dict"
+	"listpack"
+	"sds"
+	"skiplist"
+)
+
+// Error
+var (
+	// ErrNaN the resulting score is not a number.
+	ErrNaN = errors.New("resulting score is not a number (NaN)")
+	// ErrXXNX XX and NX flags together.
+	ErrXXNX = errors.New("XX and NX options at the same time are not compatible")
+	// ErrGTLTNX GT, LT and NX flags together.
+	ErrGTLTNX = errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	// ErrIncrPair INCR flag with more than one element.
+	ErrIncrPair = errors.New("INCR option supports a single increment-element pair")
+)
+
+// Encodings
+const (
+	// EncodingListpack small zset encoded as a listpack.
+	EncodingListpack = iota
+	// EncodingSkiplist zset encoded as a skiplist and a dict.
+	EncodingSkiplist
+)
+
+const (
+	// MaxListpackEntries Default max number of elements of a listpack
+	// encoded zset, similar to zset-max-listpack-entries of redis.
+	MaxListpackEntries = 128
+	// MaxListpackValue Default max member length of a listpack encoded
+	// zset, similar to zset-max-listpack-value of redis.
+	MaxListpackValue = 64
+)
+
+// Input flags of Add and ZAdd.
+const (
+	// AddNX Don't touch elements already existing.
+	AddNX = 1 << iota
+	// AddXX Only touch elements already existing.
+	AddXX
+	// AddGT Only update existing when new scores are higher.
+	AddGT
+	// AddLT Only update existing when new scores are lower.
+	AddLT
+	// AddCH ZAdd returns the number of elements added or updated.
+	AddCH
+	// AddIncr Increment the score instead of setting it.
+	AddIncr
+)
+
+// Output flags of Add.
+const (
+	// OutNaN The resulting score is not a number.
+	OutNaN = 1 << iota
+	// OutAdded The element was new and was added.
+	OutAdded
+	// OutUpdated The element already existed, score updated.
+	OutUpdated
+	// OutNop Operation not performed because of conditionals.
+	OutNop
+)
+
+// ScoreMember an element of the zset with its score.
+type ScoreMember struct {
+	Score  float64
+	Member []byte
+}
+
+// scoreValue the dict value of the skiplist encoding, the score of the
+// member.
+type scoreValue struct {
+	score float64
+}
+
+// Dup implements dict.Value.
+func (v *scoreValue) Dup() dict.Value {
+	return &scoreValue{
+		score: v.score,
+	}
+}
+
+// Destructor implements dict.Value.
+func (v *scoreValue) Destructor() {}
+
+// Zset sorted set.
+type Zset struct {
+	encoding int
+
+	// EncodingListpack
+	lp *listpack.Listpack
+
+	// EncodingSkiplist
+	dict *dict.Dict
+	zsl  *skiplist.Skiplist
+
+	maxListpackEntries int
+	maxListpackValue   int
+}
+
+// Option opt.
+type Option func(zs *Zset)
+
+// WithMaxListpackEntries The max number of elements of a listpack encoded
+// zset, 0 means the skiplist encoding is always used.
+func WithMaxListpackEntries(n int) Option {
+	return func(zs *Zset) {
+		zs.maxListpackEntries = n
+	}
+}
+
+// WithMaxListpackValue The max member length of a listpack encoded zset.
+func WithMaxListpackValue(n int) Option {
+	return func(zs *Zset) {
+		zs.maxListpackValue = n
+	}
+}
+
+// Create a new empty zset, listpack encoded unless the listpack encoding
+// is disabled with WithMaxListpackEntries(0).
+func Create(opts ...Option) *Zset {
+	zs := &Zset{
+		maxListpackEntries: MaxListpackEntries,
+		maxListpackValue:   MaxListpackValue,
+	}
+
+	for _, o := range opts {
+		o(zs)
+	}
+
+	if zs.maxListpackEntries == 0 {
+		zs.encoding = EncodingSkiplist
+		zs.dict = dict.Create()
+		zs.zsl = skiplist.Create()
+	} else {
+		zs.encoding = EncodingListpack
+		zs.lp = listpack.Create()
+	}
+	return zs
+}
+
+// Encoding Return the encoding of the zset.
+func (zs *Zset) Encoding() int {
+	return zs.encoding
+}
+
+// Listpack Return the listpack of a listpack encoded zset, nil for the
+// skiplist encoding. The listpack must not be modified.
+func (zs *Zset) Listpack() *listpack.Listpack {
+	return zs.lp
+}
+
+// Len Return the number of elements.
+func (zs *Zset) Len() int64 {
+	if zs.encoding == EncodingListpack {
+		return int64(zs.lp.Len() / 2)
+	}
+	return int64(zs.zsl.Len())
+}
+
+// Release Free the zset.
+func (zs *Zset) Release() {
+	if zs.encoding == EncodingSkiplist {
+		zs.dict.Close()
+		zs.zsl.Release()
+	}
+	zs.lp = nil
+	zs.dict = nil
+	zs.zsl = nil
+}
+
+// Convert the zset to the specified encoding.
+func (zs *Zset) Convert(encoding int) {
+	if zs.encoding == encoding {
+		return
+	}
+
+	if encoding == EncodingSkiplist {
+		zs.dict = dict.Create()
+		zs.zsl = skiplist.Create()
+
+		for eptr := zs.lp.First(); eptr != -1; eptr = zs.zzlNext(eptr) {
+			member, score := zs.zzlGet(eptr)
+			ele := sds.New(member)
+			zs.zsl.Insert(score, ele)
+			zs.dict.Add((*sds.Key)(ele), &scoreValue{score: score})
+		}
+		zs.lp = nil
+	} else {
+		zs.lp = listpack.Create()
+
+		for node := zs.zsl.First(); node != nil; node = node.Next() {
+			zs.zzlInsertAt(-1, node.Ele().Bytes(), node.Score())
+		}
+		zs.dict.Close()
+		zs.zsl.Release()
+		zs.dict = nil
+		zs.zsl = nil
+	}
+	zs.encoding = encoding
+}
+
+// Expand Prepare the zset to hold 'size' elements: it is converted to
+// the skiplist encoding if they don't fit a listpack, and the dict is
+// presized so that adding them doesn't rehash.
+func (zs *Zset) Expand(size int) {
+	if zs.encoding == EncodingListpack && size > zs.maxListpackEntries {
+		zs.Convert(EncodingSkiplist)
+	}
+	if zs.encoding == EncodingSkiplist {
+		zs.dict.Expand(uint64(size))
+	}
+}
+
+// ConvertToListpackIfNeeded Converts a skiplist to listpack if the
+// skiplist is not too big, given the length of the longest member and
+// the total length of the members.
+func (zs *Zset) ConvertToListpackIfNeeded(maxelelen, totelelen int) {
+	if zs.encoding == EncodingListpack {
+		return
+	}
+	if zs.zsl.Len() <= uint64(zs.maxListpackEntries) &&
+		maxelelen <= zs.maxListpackValue &&
+		listpack.Create().SafeToAdd(totelelen) {
+		zs.Convert(EncodingListpack)
+	}
+}
+
+// Score Return the score of the member, ok is false if the member is not
+// in the zset.
+func (zs *Zset) Score(member []byte) (score float64, ok bool) {
+	if zs.encoding == EncodingListpack {
+		_, score, ok = zs.zzlFind(member)
+		return score, ok
+	}
+
+	value := zs.dict.FetchValue((*sds.Key)(sds.New(member)))
+	if value == nil {
+		return 0, false
+	}
+	return value.(*scoreValue).score, true
+}
+
+// Add a new element or update the score of an existing element in the
+// sorted set, regardless of its encoding.
+//
+// The set of flags change the command behavior:
+//
+//	AddIncr: Increment the current element score by 'score' instead of
+//	         updating the current element score. If the element does not
+//	         exist, we assume 0 as previous score.
+//	AddNX:   Perform the operation only if the element does not exist.
+//	AddXX:   Perform the operation only if the element already exist.
+//	AddGT:   Perform the operation on existing elements only if the new
+//	         score is greater than the current score.
+//	AddLT:   Perform the operation on existing elements only if the new
+//	         score is less than the current score.
+//
+// When AddIncr is used, the new score of the element is returned in
+// 'newscore', also when the element was not added or updated.
+//
+// The returned flags are the following:
+//
+//	OutNaN:     The resulting score is not a number.
+//	OutAdded:   The element was added (not present before the call).
+//	OutUpdated: The element score was updated.
+//	OutNop:     No operation was performed because of NX or XX.
+func (zs *Zset) Add(score float64, member []byte, flags int) (newscore float64, out int) {
+	// Turn options into simple to check vars.
+	incr := flags&AddIncr != 0
+	nx := flags&AddNX != 0
+	xx := flags&AddXX != 0
+	gt := flags&AddGT != 0
+	lt := flags&AddLT != 0
+
+	// NaN as input is an error regardless of all the other parameters.
+	if math.IsNaN(score) {
+		return 0, OutNaN
+	}
+
+	// Update the sorted set according to its encoding.
+	if zs.encoding == EncodingListpack {
+		if eptr, curscore, ok := zs.zzlFind(member); ok {
+			// NX? Return, same element already exists.
+			if nx {
+				return curscore, OutNop
+			}
+
+			// Prepare the score for the increment if needed.
+			if incr {
+				score += curscore
+				if math.IsNaN(score) {
+					return 0, OutNaN
+				}
+			}
+
+			// GT/LT? Only update if score is greater/less than current.
+			if (lt && score >= curscore) || (gt && score <= curscore) {
+				return score, OutNop
+			}
+
+			// Remove and re-insert when score changed.
+			if score != curscore {
+				zs.zzlDelete(eptr)
+				zs.zzlInsert(member, score)
+				out |= OutUpdated
+			}
+			return score, out
+		} else if !xx {
+			// check if the element is too large or the list
+			// becomes too long *before* executing zzlInsert.
+			if zs.Len()+1 > int64(zs.maxListpackEntries) ||
+				len(member) > zs.maxListpackValue ||
+				!zs.lp.SafeToAdd(len(member)) {
+				zs.Convert(EncodingSkiplist)
+			} else {
+				zs.zzlInsert(member, score)
+				return score, OutAdded
+			}
+		} else {
+			return 0, OutNop
+		}
+	}
+
+	// Note that the above block handling listpack would have either
+	// returned or converted the key to skiplist.
+	key := (*sds.Key)(sds.New(member))
+	if de := zs.dict.Find(key); de != nil {
+		// NX? Return, same element already exists.
+		value := de.Value().(*scoreValue)
+		curscore := value.score
+		if nx {
+			return curscore, OutNop
+		}
+
+		// Prepare the score for the increment if needed.
+		if incr {
+			score += curscore
+			if math.IsNaN(score) {
+				return 0, OutNaN
+			}
+		}
+
+		// GT/LT? Only update if score is greater/less than current.
+		if (lt && score >= curscore) || (gt && score <= curscore) {
+			return score, OutNop
+		}
+
+		// Remove and re-insert when score changes.
+		if score != curscore {
+			zs.zsl.UpdateScore(curscore, key.SDS(), score)
+			// Note that we did not removed the original element from
+			// the hash table representing the sorted set, so we just
+			// update the score.
+			value.score = score
+			out |= OutUpdated
+		}
+		return score, out
+	} else if !xx {
+		ele := key.SDS()
+		zs.zsl.Insert(score, ele)
+		zs.dict.Add((*sds.Key)(ele), &scoreValue{score: score})
+		return score, OutAdded
+	}
+	return 0, OutNop
+}
+
+// ZAdd Implements the ZADD command: add or update every element
+// according to the flags, including AddCH and AddIncr.
+//
+// Without AddIncr, 'reply' is the number of added elements, or of added
+// and updated elements with AddCH.
+//
+// With AddIncr, a single element is allowed, 'reply' is 1 and 'score' is
+// the new score of the element, or 'reply' is 0 when the operation was
+// aborted because of NX, XX, GT or LT (a nil reply for redis).
+func (zs *Zset) ZAdd(flags int, elements ...ScoreMember) (reply int64, score float64, err error) {
+	incr := flags&AddIncr != 0
+	nx := flags&AddNX != 0
+	xx := flags&AddXX != 0
+	gt := flags&AddGT != 0
+	lt := flags&AddLT != 0
+	ch := flags&AddCH != 0
+
+	// XX and NX options at the same time are not compatible.
+	if nx && xx {
+		return 0, 0, ErrXXNX
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		return 0, 0, ErrGTLTNX
+	}
+	// Note that XX is compatible with either GT or LT
+	if incr && len(elements) > 1 {
+		return 0, 0, ErrIncrPair
+	}
+
+	var added, updated, processed int64
+	for _, e := range elements {
+		if math.IsNaN(e.Score) {
+			return 0, 0, ErrNaN
+		}
+	}
+	for _, e := range elements {
+		newscore, out := zs.Add(e.Score, e.Member, flags&^AddCH)
+		if out&OutNaN != 0 {
+			return 0, 0, ErrNaN
+		}
+		if out&OutAdded != 0 {
+			added++
+		}
+		if out&OutUpdated != 0 {
+			updated++
+		}
+		if out&OutNop == 0 {
+			processed++
+		}
+		score = newscore
+	}
+
+	if incr {
+		// ZINCRBY or INCR option.
+		if processed == 0 {
+			return 0, 0, nil
+		}
+		return 1, score, nil
+	}
+	if ch {
+		return added + updated, 0, nil
+	}
+	return added, 0, nil
+}
+
+// Remove the member from the zset. Returns false if the member was not
+// in the zset.
+func (zs *Zset) Remove(member []byte) bool {
+	if zs.encoding == EncodingListpack {
+		eptr, _, ok := zs.zzlFind(member)
+		if !ok {
+			return false
+		}
+		zs.zzlDelete(eptr)
+		return true
+	}
+
+	key := (*sds.Key)(sds.New(member))
+	de := zs.dict.Find(key)
+	if de == nil {
+		return false
+	}
+	score := de.Value().(*scoreValue).score
+
+	// Delete from the skiplist before the dict, as the dict entry is
+	// used to find the score.
+	zs.zsl.Delete(score, key.SDS())
+	zs.dict.Delete(key)
+	return true
+}
+
+// Rank Return the 0-based rank of the member, in ascending order, or in
+// descending order when 'reverse' is set. ok is false if the member is
+// not in the zset.
+func (zs *Zset) Rank(member []byte, reverse bool) (rank int64, ok bool) {
+	llen := zs.Len()
+
+	if zs.encoding == EncodingListpack {
+		rank = 1
+		for eptr := zs.lp.First(); eptr != -1; eptr = zs.zzlNext(eptr) {
+			if zs.lp.Compare(eptr, member) {
+				if reverse {
+					return llen - rank, true
+				}
+				return rank - 1, true
+			}
+			rank++
+		}
+		return 0, false
+	}
+
+	score, ok := zs.Score(member)
+	if !ok {
+		return 0, false
+	}
+	rank = int64(zs.zsl.Rank(score, sds.New(member)))
+	// Existing elements always have a rank.
+	if reverse {
+		return llen - rank, true
+	}
+	return rank - 1, true
+}
+
+// Pop Remove and return up to 'count' elements with the lowest scores,
+// or the highest scores when 'max' is set, like ZPOPMIN and ZPOPMAX.
+func (zs *Zset) Pop(count int64, max bool) []ScoreMember {
+	var result []ScoreMember
+	for ; count > 0 && zs.Len() > 0; count-- {
+		var e ScoreMember
+		if zs.encoding == EncodingListpack {
+			eptr := zs.lp.First()
+			if max {
+				eptr = zs.lp.Seek(-2)
+			}
+			member, score := zs.zzlGet(eptr)
+			e = ScoreMember{Score: score, Member: member}
+			zs.zzlDelete(eptr)
+		} else {
+			node := zs.zsl.First()
+			if max {
+				node = zs.zsl.Last()
+			}
+			e = ScoreMember{Score: node.Score(), Member: append([]byte(nil), node.Ele().Bytes()...)}
+			zs.zsl.Delete(node.Score(), node.Ele())
+			zs.dict.Delete((*sds.Key)(node.Ele()))
+		}
+		result = append(result, e)
+	}
+	return result
+}
@@ -0,0 +1,307 @@
+package zset
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"skiplist"
+)
+
+// encodings Run the test with a listpack and a skiplist encoded zset.
+func encodings(t *testing.T, fn func(t *testing.T, create func() *Zset)) {
+	t.Run("listpack", func(t *testing.T) {
+		fn(t, func() *Zset { return Create() })
+	})
+	t.Run("skiplist", func(t *testing.T) {
+		fn(t, func() *Zset { return Create(WithMaxListpackEntries(0)) })
+	})
+}
+
+func dump(elements []ScoreMember) string {
+	s := ""
+	for _, e := range elements {
+		s += fmt.Sprintf("%s:%v ", e.Member, e.Score)
+	}
+	return s
+}
+
+func TestAdd(t *testing.T) {
+	encodings(t, func(t *testing.T, create func() *Zset) {
+		zs := create()
+		n, _, err := zs.ZAdd(0, ScoreMember{1, []byte("a")}, ScoreMember{2, []byte("b")}, ScoreMember{3, []byte("10")})
+		if err != nil || n != 3 {
+			t.Fatalf("added %d, %v", n, err)
+		}
+
+		// Existing element, updated but not counted without CH.
+		if n, _, _ := zs.ZAdd(0, ScoreMember{4, []byte("a")}, ScoreMember{5, []byte("c")}); n != 1 {
+			t.Fatalf("added %d", n)
+		}
+		if n, _, _ := zs.ZAdd(AddCH, ScoreMember{1, []byte("a")}, ScoreMember{2, []byte("b")}); n != 1 {
+			t.Fatalf("changed %d", n)
+		}
+
+		// NX, XX.
+		if n, _, _ := zs.ZAdd(AddNX|AddCH, ScoreMember{9, []byte("a")}, ScoreMember{9, []byte("d")}); n != 1 {
+			t.Fatalf("NX changed %d", n)
+		}
+		if n, _, _ := zs.ZAdd(AddXX|AddCH, ScoreMember{8, []byte("d")}, ScoreMember{8, []byte("e")}); n != 1 {
+			t.Fatalf("XX changed %d", n)
+		}
+		if _, ok := zs.Score([]byte("e")); ok {
+			t.Fatal("XX added a new element")
+		}
+
+		// GT, LT.
+		if n, _, _ := zs.ZAdd(AddGT|AddCH, ScoreMember{0, []byte("a")}, ScoreMember{10, []byte("b")}); n != 1 {
+			t.Fatalf("GT changed %d", n)
+		}
+		if n, _, _ := zs.ZAdd(AddLT|AddCH, ScoreMember{0, []byte("a")}, ScoreMember{20, []byte("b")}); n != 1 {
+			t.Fatalf("LT changed %d", n)
+		}
+		if s, _ := zs.Score([]byte("a")); s != 0 {
+			t.Fatalf("score of a %v", s)
+		}
+		if s, _ := zs.Score([]byte("b")); s != 10 {
+			t.Fatalf("score of b %v", s)
+		}
+
+		// INCR.
+		if n, s, _ := zs.ZAdd(AddIncr, ScoreMember{2.5, []byte("a")}); n != 1 || s != 2.5 {
+			t.Fatalf("INCR %d %v", n, s)
+		}
+		if n, s, _ := zs.ZAdd(AddIncr, ScoreMember{1, []byte("new")}); n != 1 || s != 1 {
+			t.Fatalf("INCR new %d %v", n, s)
+		}
+		if n, _, _ := zs.ZAdd(AddIncr|AddGT, ScoreMember{-1, []byte("a")}); n != 0 {
+			t.Fatal("INCR GT not aborted")
+		}
+		if n, _, _ := zs.ZAdd(AddIncr|AddNX, ScoreMember{1, []byte("a")}); n != 0 {
+			t.Fatal("INCR NX not aborted")
+		}
+
+		zs.ZAdd(0, ScoreMember{math.Inf(1), []byte("inf")})
+		if _, _, err := zs.ZAdd(AddIncr, ScoreMember{math.Inf(-1), []byte("inf")}); err != ErrNaN {
+			t.Fatalf("err %v", err)
+		}
+
+		got := dump(zs.RangeByRank(0, -1, false))
+		if got != "new:1 a:2.5 10:3 c:5 d:8 b:10 inf:+Inf " {
+			t.Fatalf("range %s", got)
+		}
+	})
+}
+
+func TestAddFlagsErrors(t *testing.T) {
+	zs := Create()
+	tests := []struct {
+		flags int
+		n     int
+		err   error
+	}{
+		{AddNX | AddXX, 1, ErrXXNX},
+		{AddNX | AddGT, 1, ErrGTLTNX},
+		{AddNX | AddLT, 1, ErrGTLTNX},
+		{AddGT | AddLT, 1, ErrGTLTNX},
+		{AddIncr, 2, ErrIncrPair},
+		{AddXX | AddGT, 1, nil},
+	}
+	for _, tt := range tests {
+		elements := make([]ScoreMember, tt.n)
+		for i := range elements {
+			elements[i] = ScoreMember{1, []byte{byte('a' + i)}}
+		}
+		if _, _, err := zs.ZAdd(tt.flags, elements...); err != tt.err {
+			t.Fatalf("flags %b: %v, want %v", tt.flags, err, tt.err)
+		}
+	}
+	if _, _, err := zs.ZAdd(0, ScoreMember{math.NaN(), []byte("a")}); err != ErrNaN {
+		t.Fatalf("NaN: %v", err)
+	}
+	if zs.Len() != 0 {
+		t.Fatalf("len %d", zs.Len())
+	}
+}
+
+func TestConvert(t *testing.T) {
+	zs := Create(WithMaxListpackEntries(4), WithMaxListpackValue(8))
+	for i := 0; i < 4; i++ {
+		zs.Add(float64(i), []byte(fmt.Sprint("m", i)), 0)
+	}
+	if zs.Encoding() != EncodingListpack {
+		t.Fatal("converted too early")
+	}
+	if zs.Listpack().Len() != 8 {
+		t.Fatal("listpack")
+	}
+	zs.Add(4, []byte("m4"), 0)
+	if zs.Encoding() != EncodingSkiplist {
+		t.Fatal("not converted on entries")
+	}
+	if zs.Listpack() != nil {
+		t.Fatal("listpack of skiplist")
+	}
+	if got := dump(zs.RangeByRank(0, -1, false)); got != "m0:0 m1:1 m2:2 m3:3 m4:4 " {
+		t.Fatalf("range %s", got)
+	}
+
+	zs = Create(WithMaxListpackEntries(4), WithMaxListpackValue(8))
+	zs.Add(1.5, []byte("a"), 0)
+	zs.Add(-0.25, []byte("123456789"), 0)
+	if zs.Encoding() != EncodingSkiplist {
+		t.Fatal("not converted on value")
+	}
+	zs.Remove([]byte("123456789"))
+	zs.ConvertToListpackIfNeeded(1, 1)
+	if zs.Encoding() != EncodingListpack {
+		t.Fatal("not converted back")
+	}
+	if s, ok := zs.Score([]byte("a")); !ok || s != 1.5 {
+		t.Fatalf("score %v %v", s, ok)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	zs := Create(WithMaxListpackEntries(4))
+	zs.Expand(4)
+	if zs.Encoding() != EncodingListpack {
+		t.Fatal("converted")
+	}
+	zs.Expand(100)
+	if zs.Encoding() != EncodingSkiplist {
+		t.Fatal("not converted")
+	}
+	for i := 0; i < 100; i++ {
+		zs.Add(float64(i), []byte(fmt.Sprint(i)), 0)
+		if zs.dict.IsRehashing() {
+			t.Fatalf("rehashing at %d", i)
+		}
+	}
+}
+
+func TestScoreFormat(t *testing.T) {
+	zs := Create()
+	scores := []float64{0, math.Copysign(0, -1), 1, -1, 1.5, 1e300, -1e-300,
+		math.Inf(1), math.Inf(-1), 1 << 60, 0.1}
+	for i, s := range scores {
+		zs.Add(s, []byte(fmt.Sprint(i)), 0)
+	}
+	for i, s := range scores {
+		got, ok := zs.Score([]byte(fmt.Sprint(i)))
+		if !ok || got != s || math.Signbit(got) != math.Signbit(s) {
+			t.Fatalf("score %v, want %v", got, s)
+		}
+	}
+}
+
+func TestRemoveRankPop(t *testing.T) {
+	encodings(t, func(t *testing.T, create func() *Zset) {
+		zs := create()
+		for i, m := range []string{"a", "b", "c", "d", "e"} {
+			zs.Add(float64(i), []byte(m), 0)
+		}
+
+		if r, ok := zs.Rank([]byte("b"), false); !ok || r != 1 {
+			t.Fatalf("rank %d", r)
+		}
+		if r, ok := zs.Rank([]byte("b"), true); !ok || r != 3 {
+			t.Fatalf("revrank %d", r)
+		}
+		if _, ok := zs.Rank([]byte("z"), false); ok {
+			t.Fatal("rank of missing member")
+		}
+
+		if !zs.Remove([]byte("c")) || zs.Remove([]byte("c")) {
+			t.Fatal("remove")
+		}
+		if r, _ := zs.Rank([]byte("d"), false); r != 2 {
+			t.Fatalf("rank %d", r)
+		}
+
+		if got := dump(zs.Pop(1, false)); got != "a:0 " {
+			t.Fatalf("popmin %s", got)
+		}
+		if got := dump(zs.Pop(2, true)); got != "e:4 d:3 " {
+			t.Fatalf("popmax %s", got)
+		}
+		if got := dump(zs.Pop(10, true)); got != "b:1 " {
+			t.Fatalf("popmax %s", got)
+		}
+		if zs.Len() != 0 || zs.Pop(1, false) != nil {
+			t.Fatal("not empty")
+		}
+	})
+}
+
+func TestRange(t *testing.T) {
+	encodings(t, func(t *testing.T, create func() *Zset) {
+		zs := create()
+		for i := 1; i <= 5; i++ {
+			zs.Add(float64(i), []byte(fmt.Sprint("m", i)), 0)
+		}
+
+		rank := []struct {
+			start, end int64
+			reverse    bool
+			want       string
+		}{
+			{0, -1, false, "m1:1 m2:2 m3:3 m4:4 m5:5 "},
+			{1, 2, false, "m2:2 m3:3 "},
+			{-2, -1, false, "m4:4 m5:5 "},
+			{0, 1, true, "m5:5 m4:4 "},
+			{-100, 100, true, "m5:5 m4:4 m3:3 m2:2 m1:1 "},
+			{3, 1, false, ""},
+			{5, 10, false, ""},
+		}
+		for _, tt := range rank {
+			if got := dump(zs.RangeByRank(tt.start, tt.end, tt.reverse)); got != tt.want {
+				t.Fatalf("rank %d %d %v: %s", tt.start, tt.end, tt.reverse, got)
+			}
+		}
+
+		score := []struct {
+			min, max      string
+			reverse       bool
+			offset, limit int64
+			want          string
+		}{
+			{"-inf", "+inf", false, 0, -1, "m1:1 m2:2 m3:3 m4:4 m5:5 "},
+			{"(1", "3", false, 0, -1, "m2:2 m3:3 "},
+			{"(1", "3", true, 0, -1, "m3:3 m2:2 "},
+			{"-inf", "+inf", false, 1, 2, "m2:2 m3:3 "},
+			{"-inf", "+inf", true, 1, 2, "m4:4 m3:3 "},
+			{"2", "4", false, 5, -1, ""},
+			{"6", "7", false, 0, -1, ""},
+		}
+		for _, tt := range score {
+			r, _ := skiplist.ParseRange([]byte(tt.min), []byte(tt.max))
+			if got := dump(zs.RangeByScore(r, tt.reverse, tt.offset, tt.limit)); got != tt.want {
+				t.Fatalf("score %s %s %v: %s", tt.min, tt.max, tt.reverse, got)
+			}
+		}
+
+		lex := create()
+		for _, m := range []string{"a", "b", "c", "d", "e"} {
+			lex.Add(0, []byte(m), 0)
+		}
+		lexs := []struct {
+			min, max      string
+			reverse       bool
+			offset, limit int64
+			want          string
+		}{
+			{"-", "+", false, 0, -1, "a:0 b:0 c:0 d:0 e:0 "},
+			{"[b", "(d", false, 0, -1, "b:0 c:0 "},
+			{"[b", "(d", true, 0, -1, "c:0 b:0 "},
+			{"-", "+", true, 1, 1, "d:0 "},
+			{"(e", "+", false, 0, -1, ""},
+		}
+		for _, tt := range lexs {
+			r, _ := skiplist.ParseLexRange([]byte(tt.min), []byte(tt.max))
+			if got := dump(lex.RangeByLex(r, tt.reverse, tt.offset, tt.limit)); got != tt.want {
+				t.Fatalf("lex %s %s %v: %s", tt.min, tt.max, tt.reverse, got)
+			}
+		}
+	})
+}